@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Bisect(c *git.Client, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: %v bisect [help|start|bad|good|new|old|terms|skip|next|reset|visualize|view|replay|log|run]", os.Args[0])
+	}
+	err := bisect(c, args[0], args[1:])
+	if e, ok := err.(git.BisectError); ok {
+		// Some errors have already been explained on stdout.
+		if e.Error() != "" {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		os.Exit(e.Status)
+	}
+	return err
+}
+
+func bisect(c *git.Client, subcmd string, args []string) error {
+	switch subcmd {
+	case "help":
+		flags := newFlagSet("bisect")
+		flags.Usage()
+		return nil
+	case "start":
+		// The arguments to start are logged as they were given so
+		// that they can be replayed, so they're parsed by the git
+		// package.
+		return git.BisectStart(c, args, os.Stdout)
+	case "next":
+		return git.BisectNext(c, os.Stdout)
+	case "reset":
+		if len(args) > 1 {
+			return fmt.Errorf("usage: %v bisect reset [<commit>]", os.Args[0])
+		}
+		var commit string
+		if len(args) == 1 {
+			commit = args[0]
+		}
+		return git.BisectReset(c, commit, os.Stdout)
+	case "visualize", "view":
+		return bisectVisualize(c, args)
+	case "replay":
+		if len(args) != 1 {
+			return fmt.Errorf("error: no logfile given")
+		}
+		return git.BisectReplay(c, args[0], os.Stdout)
+	case "log":
+		return git.BisectLog(c, os.Stdout)
+	case "run":
+		return git.BisectRun(c, args, os.Stdout)
+	case "terms":
+		if len(args) > 1 {
+			return fmt.Errorf("error: 'git bisect terms' requires 0 or 1 argument")
+		}
+		var opt string
+		if len(args) == 1 {
+			opt = args[0]
+		}
+		return git.BisectTermsPrint(c, opt, os.Stdout)
+	default:
+		// Anything else is one of the terms or skip.
+		return git.BisectState(c, subcmd, args, os.Stdout)
+	}
+}
+
+// Shows the commits which may still be the first bad commit with log, or
+// with the command given in args. Commands other than tig and git... are
+// run as subcommands, and options are passed to log.
+func bisectVisualize(c *git.Client, args []string) error {
+	revs, err := git.BisectRevisions(c)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return Log(c, append(args, revs...))
+	}
+	var run *exec.Cmd
+	if args[0] == "tig" || strings.HasPrefix(args[0], "git") {
+		run = exec.Command(args[0], append(args[1:], revs...)...)
+	} else {
+		run = exec.Command(os.Args[0], append(args, revs...)...)
+	}
+	run.Stdin = os.Stdin
+	run.Stdout = os.Stdout
+	run.Stderr = os.Stderr
+	return run.Run()
+}
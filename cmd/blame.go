@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Blame(c *git.Client, args []string) error {
+	return blame(c, "blame", args)
+}
+
+// Annotate is blame with output in the format of git annotate.
+func Annotate(c *git.Client, args []string) error {
+	return blame(c, "annotate", append([]string{"-c"}, args...))
+}
+
+func blame(c *git.Client, name string, args []string) error {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	var opts git.BlameOptions
+	flags.Var(NewMultiStringValue(&opts.Ranges), "L", "only blame the lines in the range <start>,<end> or :<funcname>")
+	flags.BoolVar(&opts.IgnoreWhitespace, "w", false, "ignore whitespace when comparing lines")
+	var moves, copies []string
+	flags.Var(NewMultiStringValue(&moves), "M", "detect lines moved within a file, optionally with the minimum score")
+	flags.Var(NewMultiStringValue(&copies), "C", "detect lines copied from other files, optionally with the minimum score")
+	flags.BoolVar(&opts.Reverse, "reverse", false, "find the last commit each line existed in, rather than the first")
+	flags.BoolVar(&opts.FirstParent, "first-parent", false, "only follow the first parent of merge commits")
+	root := flags.Bool("root", false, "do not treat root commits as boundaries")
+	flags.BoolVar(&opts.Porcelain, "porcelain", false, "show the output in a format designed for machine consumption")
+	flags.BoolVar(&opts.Porcelain, "p", false, "alias of --porcelain")
+	flags.BoolVar(&opts.LinePorcelain, "line-porcelain", false, "show the porcelain format with commit information for every line")
+	flags.BoolVar(&opts.Annotate, "c", false, "use the same output format as git annotate")
+	var ignoreRevs, ignoreRevsFiles []string
+	flags.Var(NewMultiStringValue(&ignoreRevs), "ignore-rev", "ignore the changes made by the revision")
+	flags.Var(NewMultiStringValue(&ignoreRevsFiles), "ignore-revs-file", "ignore the revisions listed in the file")
+	blank := flags.Bool("b", false, "show blank commit ids for boundary commits")
+	flags.BoolVar(&opts.NoAuthor, "s", false, "do not show the author name and date")
+	showEmail := flags.Bool("show-email", false, "show the author email instead of the author name")
+	flags.BoolVar(showEmail, "e", false, "alias of --show-email")
+	flags.BoolVar(&opts.ShowName, "show-name", false, "show the filename in the original commit")
+	flags.BoolVar(&opts.ShowName, "f", false, "alias of --show-name")
+	flags.BoolVar(&opts.ShowNumber, "show-number", false, "show the line number in the original commit")
+	flags.BoolVar(&opts.ShowNumber, "n", false, "alias of --show-number")
+	flags.BoolVar(&opts.LongNames, "l", false, "show the full commit ids")
+	flags.BoolVar(&opts.RawTimestamp, "t", false, "show raw timestamps")
+	abbrev := flags.Int("abbrev", -1, "show commit ids abbreviated to at least n digits")
+	date := flags.String("date", "", "show dates in the given format")
+	flags.Var(newNotimplBoolValue(), "incremental", "not implemented")
+	flags.Var(newNotimplStringValue(), "contents", "not implemented")
+	flags.Var(newNotimplStringValue(), "S", "not implemented")
+
+	flags.Parse(blameAdjustArgs(args))
+
+	opts.ShowRoot = *root || c.GetConfig("blame.showroot") == "true"
+	opts.BlankBoundary = *blank || c.GetConfig("blame.blankboundary") == "true"
+	opts.ShowEmail = *showEmail || c.GetConfig("blame.showemail") == "true"
+	opts.MarkUnblamable = blameConfigBool(c, "blame.markunblamablelines")
+	opts.MarkIgnored = blameConfigBool(c, "blame.markignoredlines")
+	if opts.LinePorcelain {
+		opts.Porcelain = true
+	}
+
+	if len(moves) > 0 {
+		opts.DetectMoves = true
+		if score, err := strconv.Atoi(moves[len(moves)-1]); err == nil {
+			opts.MoveScore = score
+		}
+	}
+	if len(copies) > 0 {
+		opts.DetectCopies = len(copies)
+		if score, err := strconv.Atoi(copies[len(copies)-1]); err == nil {
+			opts.CopyScore = score
+		}
+	}
+
+	if *abbrev < 0 {
+		if n, err := strconv.Atoi(c.GetConfig("core.abbrev")); err == nil {
+			*abbrev = n
+		}
+	}
+	switch {
+	case *abbrev == 0:
+		opts.LongNames = true
+	case *abbrev > 0:
+		opts.Abbrev = *abbrev
+	}
+
+	if *date == "" {
+		*date = c.GetConfig("blame.date")
+	}
+	if *date != "" {
+		mode, err := git.ParseDateMode(*date)
+		if err != nil {
+			return err
+		}
+		opts.Date = mode
+	}
+
+	if len(ignoreRevsFiles) == 0 {
+		ignoreRevsFiles = c.GetConfigAll("blame.ignorerevsfile")
+	}
+	for _, file := range ignoreRevsFiles {
+		ids, err := git.ReadIgnoreRevsFile(c, file)
+		if err != nil {
+			return err
+		}
+		opts.IgnoreRevs = append(opts.IgnoreRevs, ids...)
+	}
+	for _, rev := range ignoreRevs {
+		commits, err := git.RevParse(c, git.RevParseOptions{}, []string{rev})
+		if err != nil {
+			return err
+		}
+		for _, cmt := range commits {
+			id, err := cmt.CommitID(c)
+			if err != nil {
+				return err
+			}
+			opts.IgnoreRevs = append(opts.IgnoreRevs, id)
+		}
+	}
+
+	// The file is the last argument, or the one after "--", and any
+	// arguments before it are revisions.
+	revs := flags.Args()
+	for i, arg := range revs {
+		if arg == "--" {
+			if i != len(revs)-2 {
+				flags.Usage()
+				os.Exit(128)
+			}
+			revs = append(revs[:i], revs[i+1:]...)
+			break
+		}
+	}
+	if len(revs) == 0 {
+		flags.Usage()
+		os.Exit(128)
+	}
+	file := git.File(revs[len(revs)-1])
+	revs = revs[:len(revs)-1]
+	path, err := file.IndexPath(c)
+	if err != nil {
+		return err
+	}
+
+	var include *git.CommitID
+	var excludes []git.CommitID
+	for _, rev := range revs {
+		commits, err := git.RevParse(c, git.RevParseOptions{}, []string{rev})
+		if err != nil {
+			return err
+		}
+		for _, cmt := range commits {
+			id, err := cmt.CommitID(c)
+			if err != nil {
+				return err
+			}
+			if cmt.Excluded {
+				excludes = append(excludes, id)
+				continue
+			}
+			if include != nil {
+				return fmt.Errorf("fatal: More than one commit to dig from: %v and %v", *include, id)
+			}
+			include = &id
+		}
+	}
+	if opts.Reverse {
+		// --reverse <rev> means <rev>..HEAD.
+		if len(excludes) == 0 {
+			if include == nil {
+				return fmt.Errorf("fatal: --reverse requires a revision")
+			}
+			excludes = []git.CommitID{*include}
+			include = nil
+		}
+		if include == nil {
+			head, err := c.GetHeadCommit()
+			if err != nil {
+				return err
+			}
+			include = &head
+		}
+		opts.Revision = &excludes[0]
+		opts.ReverseEnd = *include
+	} else {
+		if include == nil && len(excludes) > 0 {
+			head, err := c.GetHeadCommit()
+			if err != nil {
+				return err
+			}
+			include = &head
+		}
+		opts.Revision = include
+		opts.Excludes = excludes
+	}
+	return git.Blame(c, opts, path, os.Stdout)
+}
+
+// Converts -M, -C and their optional scores into -M=<score> and
+// -C=<score>, and -L<range> into -L=<range>, so that the flag package can
+// parse them.
+func blameAdjustArgs(args []string) []string {
+	var adjusted []string
+	for i, a := range args {
+		switch {
+		case a == "--":
+			return append(adjusted, args[i:]...)
+		case strings.HasPrefix(a, "-M") || strings.HasPrefix(a, "-C"):
+			adjusted = append(adjusted, a[:2]+"="+a[2:])
+		case strings.HasPrefix(a, "-L") && a != "-L":
+			adjusted = append(adjusted, "-L="+a[2:])
+		default:
+			adjusted = append(adjusted, a)
+		}
+	}
+	return adjusted
+}
+
+// Returns true if the boolean config variable name is set to true.
+func blameConfigBool(c *git.Client, name string) bool {
+	switch strings.ToLower(c.GetConfig(name)) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Bundle(c *git.Client, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: %v bundle (create|verify|list-heads|unbundle) <file> [<args>]", os.Args[0])
+	}
+	switch args[0] {
+	case "create":
+		return bundleCreate(c, args[1:])
+	case "verify":
+		return bundleVerify(c, args[1:])
+	case "list-heads":
+		return bundleListHeads(args[1:])
+	case "unbundle":
+		return bundleUnbundle(c, args[1:])
+	default:
+		return fmt.Errorf("Invalid bundle subcommand: %v", args[0])
+	}
+}
+
+func bundleCreate(c *git.Client, args []string) error {
+	flags := newFlagSet("bundle create")
+	opts := git.BundleCreateOptions{}
+	// Progress isn't shown while writing the bundle, so --quiet is
+	// always in effect.
+	flags.Bool("quiet", false, "Do not show progress")
+	flags.Bool("q", false, "Alias of --quiet")
+	flags.IntVar(&opts.Version, "version", 2, "The bundle format version to write (2 or 3)")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	return git.BundleCreate(c, opts, flags.Arg(0), flags.Args()[1:])
+}
+
+func bundleVerify(c *git.Client, args []string) error {
+	flags := newFlagSet("bundle verify")
+	quiet := false
+	flags.BoolVar(&quiet, "quiet", false, "Only report errors")
+	flags.BoolVar(&quiet, "q", false, "Alias of --quiet")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	b, err := git.BundleVerify(c, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s is okay\n", flags.Arg(0))
+	if quiet {
+		return nil
+	}
+	printBundleList := func(refs []git.Ref, what string) {
+		if len(refs) == 1 {
+			fmt.Printf("The bundle %s this ref:\n", what)
+		} else {
+			fmt.Printf("The bundle %s these %d refs:\n", what, len(refs))
+		}
+		for _, ref := range refs {
+			fmt.Printf("%v %s\n", ref.Value, ref.Name)
+		}
+	}
+	printBundleList(b.Refs, "contains")
+	if len(b.Prerequisites) == 0 {
+		fmt.Println("The bundle records a complete history.")
+	} else {
+		prereqs := make([]git.Ref, len(b.Prerequisites))
+		for i, id := range b.Prerequisites {
+			prereqs[i].Value = id
+		}
+		printBundleList(prereqs, "requires")
+	}
+	fmt.Println("The bundle uses this hash algorithm: sha1")
+	return nil
+}
+
+func bundleListHeads(args []string) error {
+	flags := newFlagSet("bundle list-heads")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	refs, err := git.BundleListHeads(flags.Arg(0), flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		fmt.Printf("%v %s\n", ref.Value, ref.Name)
+	}
+	return nil
+}
+
+func bundleUnbundle(c *git.Client, args []string) error {
+	flags := newFlagSet("bundle unbundle")
+	opts := git.BundleUnbundleOptions{}
+	flags.BoolVar(&opts.Progress, "progress", false, "Show progress while indexing the pack")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	refs, err := git.BundleUnbundle(c, opts, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	patterns := flags.Args()[1:]
+refs:
+	for _, ref := range refs {
+		for _, pattern := range patterns {
+			if ref.Matches(pattern) {
+				fmt.Printf("%v %s\n", ref.Value, ref.Name)
+				continue refs
+			}
+		}
+		if len(patterns) == 0 {
+			fmt.Printf("%v %s\n", ref.Value, ref.Name)
+		}
+	}
+	return nil
+}
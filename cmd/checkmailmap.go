@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func CheckMailmap(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("check-mailmap", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	var opts git.CheckMailmapOptions
+	flags.BoolVar(&opts.Stdin, "stdin", false, "also read contacts from stdin, one per line")
+	flags.Parse(args)
+
+	return git.CheckMailmap(c, opts, flags.Args(), os.Stdout)
+}
@@ -17,6 +17,22 @@ func newFlagSet(name string) *flag.FlagSet {
 	return flags
 }
 
+// Parses args with flags, allowing flags to come after positional
+// arguments the way that git's option parser does. It returns the
+// positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
 func Clean(c *git.Client, args []string) error {
 	flags := newFlagSet("clean")
 	opts := git.CleanOptions{}
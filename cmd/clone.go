@@ -25,16 +25,41 @@ func Clone(c *git.Client, args []string) error {
 	template := ""
 	flags.StringVar(&template, "template", "", "Specify the directory from which templates will be used.")
 
+	flags.BoolVar(&opts.Local, "local", false, "Copy the objects of a local repository instead of using the transport.")
+	flags.BoolVar(&opts.Local, "l", false, "Alias for --local")
+	flags.BoolVar(&opts.NoHardLinks, "no-hardlinks", false, "Copy the object files of a local repository instead of hardlinking them.")
+	flags.BoolVar(&opts.Shared, "shared", false, "Use the objects of a local repository through alternates.")
+	flags.BoolVar(&opts.Shared, "s", false, "Alias for --shared")
+	flags.BoolVar(&opts.Dissociate, "dissociate", false, "Copy borrowed objects and stop using alternates after cloning.")
+	flags.BoolVar(&opts.Mirror, "mirror", false, "Set up a mirror of the remote repository.")
+	flags.BoolVar(&opts.NoCheckout, "no-checkout", false, "Don't checkout HEAD after cloning.")
+	flags.BoolVar(&opts.NoCheckout, "n", false, "Alias for --no-checkout")
+	flags.BoolVar(&opts.NoTags, "no-tags", false, "Don't clone any tags.")
+	flags.StringVar(&opts.Origin, "origin", "", "Use name instead of origin for the remote.")
+	flags.StringVar(&opts.Origin, "o", "", "Alias for --origin")
+	flags.StringVar(&opts.Branch, "branch", "", "Checkout branch instead of the remote's HEAD.")
+	flags.StringVar(&opts.Branch, "b", "", "Alias for --branch")
+	var reference, referenceIfAble string
+	flags.StringVar(&reference, "reference", "", "Borrow objects from a local reference repository.")
+	flags.StringVar(&referenceIfAble, "reference-if-able", "", "Like --reference, but only warn if the reference repository doesn't exist.")
+
 	// These flags can be moved out of these lists and below as proper flags as they are implemented
-	for _, bf := range []string{"l", "s", "no-hardlinks", "n", "mirror", "dissociate", "single-branch", "no-single-branch", "no-tags", "shallow-submodules", "no-shallow-submodules"} {
+	for _, bf := range []string{"single-branch", "no-single-branch", "shallow-submodules", "no-shallow-submodules"} {
 		flags.Var(newNotimplBoolValue(), bf, "Not implemented")
 	}
-	for _, sf := range []string{"o", "b", "u", "reference", "separate-git-dir", "depth", "recurse-submodules", "jobs"} {
+	for _, sf := range []string{"u", "separate-git-dir", "depth", "recurse-submodules", "jobs"} {
 		flags.Var(newNotimplStringValue(), sf, "Not implemented")
 	}
 
 	flags.Parse(args)
 
+	if referenceIfAble != "" {
+		opts.Reference = referenceIfAble
+		opts.ReferenceIfAble = true
+	}
+	if reference != "" {
+		opts.Reference = reference
+	}
 	if template != "" {
 		initOpts.Template = git.File(template)
 	}
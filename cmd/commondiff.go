@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/driusan/dgit/git"
 )
@@ -26,13 +28,35 @@ func parseCommonDiffFlags(c *git.Client, options *git.DiffCommonOptions, default
 	flags.BoolVar(&options.Raw, "raw", true, "Generate the diff in raw format")
 	flags.BoolVar(&options.ExitCode, "exit-code", false, "Exit with an exit code of 1 if there are any diffs")
 
-	flags.Parse(args)
+	flags.BoolVar(&options.NameOnly, "name-only", false, "Show only the names of changed files")
+	flags.BoolVar(&options.NameStatus, "name-status", false, "Show only the names and status of changed files")
+	flags.BoolVar(&options.Stat, "stat", false, "Generate a diffstat")
+	flags.BoolVar(&options.NumStat, "numstat", false, "Show the number of added and deleted lines of each file")
+	flags.BoolVar(&options.ShortStat, "shortstat", false, "Only show the summary line of the diffstat")
+	flags.BoolVar(&options.Summary, "summary", false, "Show a summary of created, deleted, renamed and mode changed files")
+
+	finishRenames := addRenameFlags(c, flags, options)
+
+	flags.Parse(diffAdjustArgs(args))
 	args = flags.Args()
+	if err := finishRenames(); err != nil {
+		return nil, err
+	}
 
 	if *patch || *p || *u {
 		options.Patch = true
 		options.Raw = false
 	}
+	// Other formats replace the default raw format or patch, unless they
+	// were asked for too.
+	if options.NameOnly || options.NameStatus || options.Stat || options.NumStat || options.ShortStat || options.Summary {
+		explicit := make(map[string]bool)
+		flags.Visit(func(f *flag.Flag) {
+			explicit[f.Name] = true
+		})
+		options.Raw = explicit["raw"]
+		options.Patch = explicit["patch"] || explicit["p"] || explicit["u"]
+	}
 	if *nopatch || *s {
 		options.Patch = false
 	}
@@ -53,6 +77,87 @@ func parseCommonDiffFlags(c *git.Client, options *git.DiffCommonOptions, default
 	return args, nil
 }
 
+// Adds the options which control the detection of renames, copies and
+// rewrites to flags. The returned function must be called after the flags
+// are parsed to finish setting options.
+func addRenameFlags(c *git.Client, flags *flag.FlagSet, options *git.DiffCommonOptions) func() error {
+	var renames, copies, breaks []string
+	flags.Var(NewMultiStringValue(&renames), "M", "Detect renames, optionally with the minimum similarity")
+	flags.Var(NewMultiStringValue(&renames), "find-renames", "Alias of -M")
+	flags.Var(NewMultiStringValue(&copies), "C", "Detect copies as well as renames, optionally with the minimum similarity")
+	flags.Var(NewMultiStringValue(&copies), "find-copies", "Alias of -C")
+	flags.BoolVar(&options.FindCopiesHarder, "find-copies-harder", false, "Look for the sources of copies in unmodified files")
+	flags.Var(NewMultiStringValue(&breaks), "B", "Break rewrites into a deletion and a creation, optionally with <n>[/<m>] scores")
+	flags.Var(NewMultiStringValue(&breaks), "break-rewrites", "Alias of -B")
+	noRenames := flags.Bool("no-renames", false, "Do not detect renames")
+	renameLimit := 1000
+	if limit, err := strconv.Atoi(c.GetConfig("diff.renamelimit")); err == nil {
+		renameLimit = limit
+	}
+	flags.IntVar(&options.RenameLimit, "l", renameLimit, "Limit the number of files compared when detecting inexact renames")
+
+	return func() error {
+		var err error
+		// A second -C also looks for copies in unmodified files.
+		for i, score := range append(renames, copies...) {
+			if options.RenameScore, err = git.ParseSimilarityScore(score); err != nil {
+				return err
+			}
+			options.DetectRenames = true
+			if i >= len(renames) {
+				options.FindCopiesHarder = options.FindCopiesHarder || options.DetectCopies
+				options.DetectCopies = true
+			}
+		}
+		if options.FindCopiesHarder {
+			options.DetectRenames, options.DetectCopies = true, true
+		}
+		for _, scores := range breaks {
+			options.BreakRewrites = true
+			brk := strings.SplitN(scores, "/", 2)
+			if options.BreakScore, err = git.ParseSimilarityScore(brk[0]); err != nil {
+				return err
+			}
+			if len(brk) == 2 {
+				if options.MergeScore, err = git.ParseSimilarityScore(brk[1]); err != nil {
+					return err
+				}
+			}
+		}
+		if *noRenames {
+			options.DetectRenames, options.DetectCopies, options.FindCopiesHarder = false, false, false
+		}
+		return nil
+	}
+}
+
+// Converts -M, -C and -B and their long forms with optional scores into
+// -M=<score> and so on, and -l<num> into -l=<num>, so that the flag package
+// can parse them.
+func diffAdjustArgs(args []string) []string {
+	var adjusted []string
+	for i, a := range args {
+		if a == "--" {
+			return append(adjusted, args[i:]...)
+		}
+		adjusted = append(adjusted, adjustRenameArg(a))
+	}
+	return adjusted
+}
+
+// Converts a -M, -C, -B or -l argument, or one of their long forms, into
+// the form expected by the flags from addRenameFlags. Other arguments are
+// returned unchanged.
+func adjustRenameArg(a string) string {
+	switch {
+	case strings.HasPrefix(a, "-M") || strings.HasPrefix(a, "-C") || strings.HasPrefix(a, "-B") || strings.HasPrefix(a, "-l") && a != "-l":
+		return a[:2] + "=" + strings.TrimPrefix(a[2:], "=")
+	case a == "--find-renames" || a == "--find-copies" || a == "--break-rewrites":
+		return a + "="
+	}
+	return a
+}
+
 // Print the diffs that come back from either diff-files, diff-index, or diff-tree
 // in the appropriate format according to options.
 func printDiffs(c *git.Client, options git.DiffCommonOptions, diffs []git.HashDiff) error {
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Credential(c *git.Client, args []string) error {
+	flags := newFlagSet("credential")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if c == nil {
+		// Credentials can be used outside of a repository, in
+		// which case only the global config applies.
+		c = &git.Client{}
+	}
+
+	cred, err := git.ParseCredential(os.Stdin)
+	if err != nil {
+		return err
+	}
+	switch flags.Arg(0) {
+	case "fill":
+		cred, err := git.CredentialFill(c, cred)
+		if err != nil {
+			return err
+		}
+		_, err = cred.WriteTo(os.Stdout)
+		return err
+	case "approve":
+		return git.CredentialApprove(c, cred)
+	case "reject":
+		return git.CredentialReject(c, cred)
+	default:
+		return fmt.Errorf("Invalid credential subcommand: %v", flags.Arg(0))
+	}
+}
+
+func CredentialStore(c *git.Client, args []string) error {
+	flags := newFlagSet("credential-store")
+	opts := git.CredentialStoreOptions{}
+	flags.StringVar(&opts.File, "file", "", "Use the given file to store credentials instead of ~/.git-credentials")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	cred, err := git.ParseCredential(os.Stdin)
+	if err != nil {
+		return err
+	}
+	found, err := git.CredentialStore(c, opts, flags.Arg(0), cred)
+	if err != nil {
+		return err
+	}
+	if flags.Arg(0) == "get" && found.Password != "" {
+		_, err = found.WriteTo(os.Stdout)
+	}
+	return err
+}
+
+func CredentialCache(c *git.Client, args []string) error {
+	flags := newFlagSet("credential-cache")
+	opts := git.CredentialCacheOptions{}
+	flags.IntVar(&opts.Timeout, "timeout", 900, "Number of seconds to cache credentials for")
+	flags.StringVar(&opts.Socket, "socket", "", "Use the given socket to contact the cache daemon")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var cred git.Credential
+	if flags.Arg(0) != "exit" {
+		var err error
+		cred, err = git.ParseCredential(os.Stdin)
+		if err != nil {
+			return err
+		}
+	}
+	found, err := git.CredentialCache(c, opts, flags.Arg(0), cred)
+	if err != nil {
+		return err
+	}
+	if flags.Arg(0) == "get" && found.Password != "" {
+		_, err = found.WriteTo(os.Stdout)
+	}
+	return err
+}
+
+func CredentialCacheDaemon(c *git.Client, args []string) error {
+	if len(args) != 1 {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %v credential-cache--daemon <socket>\n", os.Args[0])
+		os.Exit(2)
+	}
+	return git.CredentialCacheDaemon(args[0])
+}
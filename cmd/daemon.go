@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Daemon(args []string) error {
+	flags := newFlagSet("daemon")
+	opts := git.DaemonOptions{UploadPack: true}
+	flags.StringVar(&opts.Listen, "listen", "", "Listen on a specific IP address or hostname")
+	flags.IntVar(&opts.Port, "port", 9418, "Listen on an alternative port")
+	flags.BoolVar(&opts.InetD, "inetd", false, "Serve a single request on stdin and stdout, as if run by inetd")
+	flags.StringVar(&opts.BasePath, "base-path", "", "Remap all path requests as relative to the given path")
+	flags.BoolVar(&opts.StrictPaths, "strict-paths", false, "Match paths exactly, without trying <path>.git or <path>/.git")
+	flags.BoolVar(&opts.ExportAll, "export-all", false, "Allow pulling from all directories that look like git repositories")
+	flags.BoolVar(&opts.AllowOverride, "allow-override", false, "Allow repositories to enable or disable services with their config")
+	flags.BoolVar(&opts.InformativeErrors, "informative-errors", false, "Report the reason that a request was denied to clients")
+	flags.IntVar(&opts.MaxConnections, "max-connections", 32, "The maximum number of simultaneous connections, or 0 for no limit")
+	flags.StringVar(&opts.PidFile, "pid-file", "", "Save the process id in file")
+	flags.BoolVar(&opts.Verbose, "verbose", false, "Log details about incoming connections and requested files")
+
+	timeout := flags.Int("timeout", 0, "Timeout in seconds between reads or writes of a request")
+	inittimeout := flags.Int("init-timeout", 0, "Timeout in seconds between accepting a connection and receiving the request")
+	flags.Bool("reuseaddr", true, "Use SO_REUSEADDR when binding the listening socket")
+
+	var enable, disable []string
+	flags.Var(NewMultiStringValue(&enable), "enable", "Enable the service for all repositories")
+	flags.Var(NewMultiStringValue(&disable), "disable", "Disable the service for all repositories")
+
+	flags.Var(newNotimplBoolValue(), "detach", "Not implemented")
+	flags.Var(newNotimplStringValue(), "user-path", "Not implemented")
+	flags.Var(newNotimplStringValue(), "interpolated-path", "Not implemented")
+	flags.Var(newNotimplStringValue(), "user", "Not implemented")
+	flags.Var(newNotimplStringValue(), "group", "Not implemented")
+	flags.Parse(args)
+
+	opts.Timeout = time.Duration(*timeout) * time.Second
+	opts.InitTimeout = time.Duration(*inittimeout) * time.Second
+	for _, svcs := range []struct {
+		names []string
+		val   bool
+	}{{enable, true}, {disable, false}} {
+		for _, svc := range svcs.names {
+			switch svc {
+			case "upload-pack":
+				opts.UploadPack = svcs.val
+			case "receive-pack":
+				opts.ReceivePack = svcs.val
+			default:
+				return fmt.Errorf("fatal: unknown service %v", svc)
+			}
+		}
+	}
+	return git.Daemon(opts, flags.Args())
+}
@@ -25,6 +25,9 @@ func Diff(c *git.Client, args []string) error {
 	flags.BoolVar(&cached, "cached", false, "Display changes staged for commit")
 	flags.BoolVar(&options.NoIndex, "no-index", false, "Use diff to display difference between files on the filesystem")
 
+	// Unlike the plumbing commands, diff detects renames by default.
+	options.DiffCommonOptions = git.DiffRenameOptions(c)
+
 	args, err := parseCommonDiffFlags(c, &options.DiffCommonOptions, true, flags, args)
 
 	if staged || cached {
@@ -3,7 +3,6 @@ package cmd
 import (
 	"flag"
 	"fmt"
-	"strings"
 
 	"github.com/driusan/dgit/git"
 )
@@ -17,49 +16,19 @@ func DiffTree(c *git.Client, args []string) error {
 		flags.PrintDefaults()
 	}
 	options := git.DiffTreeOptions{}
-
-	patch := flags.Bool("index", false, "Generate patch")
-	p := flags.Bool("p", false, "Alias for --patch")
-	u := flags.Bool("u", false, "Alias for --patch")
-
-	nopatch := flags.Bool("no-patch", false, "Suppress patch generation")
-	s := flags.Bool("s", false, "Alias of --no-patch")
-
-	unified := flags.Int("unified", 3, "Generate <n> lines of context")
-	U := flags.Int("U", 3, "Alias of --unified")
-	flags.BoolVar(&options.Raw, "raw", true, "Generate the diff in raw format")
 	flags.BoolVar(&options.Recurse, "r", false, "Recurse into subtrees")
 	flags.BoolVar(&options.Root, "root", false, "Diff the initial commit against /dev/null")
 
-	adjustedArgs := []string{}
-	for _, a := range args {
-		if strings.HasPrefix(a, "-U") && a != "-U" {
-			adjustedArgs = append(adjustedArgs, "-U", a[2:])
-			continue
-		}
-		adjustedArgs = append(adjustedArgs, a)
-	}
-
-	flags.Parse(adjustedArgs)
-	args = flags.Args()
-
-	if *patch || *p || *u {
-		options.Patch = true
-	}
-	if *nopatch || *s {
-		options.Patch = false
+	args, err := parseCommonDiffFlags(c, &options.DiffCommonOptions, false, flags, args)
+	if err != nil {
+		return err
 	}
-
-	if unified != nil && U != nil && *unified != *U {
-		return fmt.Errorf("Can not specify both --unified and -U %+v", adjustedArgs)
-	} else if unified != nil {
-		options.NumContextLines = *unified
-	} else if U != nil {
-		options.NumContextLines = *U
+	// As in git, any output but the raw and name formats needs the
+	// files in subtrees.
+	if options.Patch || options.Stat || options.NumStat || options.ShortStat || options.Summary {
+		options.Recurse = true
 	}
 
-	options.NumContextLines = 3
-
 	if len(args) < 1 {
 		flags.Usage()
 		return fmt.Errorf("Must provide at least 1 treeish.")
@@ -70,7 +39,7 @@ func DiffTree(c *git.Client, args []string) error {
 	}
 	var treeish2 git.Treeish = nil
 	var onetree bool = false
-	if len(args) >= 2 {
+	if len(args) >= 2 && args[1] != "--" {
 		t2, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[1])
 		if err != nil {
 			return err
@@ -81,11 +50,14 @@ func DiffTree(c *git.Client, args []string) error {
 		args = args[1:]
 		onetree = true
 	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
 	diffs, err := git.DiffTree(c, &options, treeish, treeish2, args)
 	if err != nil {
 		return err
 	}
-	if onetree {
+	if onetree && len(diffs) > 0 {
 		if c1, ok := treeish.(git.Commitish); ok {
 			cmt, err := c1.CommitID(c)
 			if err != nil {
@@ -99,8 +71,5 @@ func DiffTree(c *git.Client, args []string) error {
 		}
 	}
 
-	for _, diff := range diffs {
-		fmt.Printf("%v\n", diff)
-	}
-	return err
+	return printDiffs(c, options.DiffCommonOptions, diffs)
 }
@@ -3,6 +3,8 @@ package cmd
 import (
 	"flag"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/driusan/dgit/git"
 )
@@ -10,14 +12,34 @@ import (
 // These options can be shared with other subcommands that fetch, such as pull
 func addSharedFetchFlags(flags *flag.FlagSet, options *git.FetchOptions) {
 	// These flags can be moved out of these lists and below as proper flags as they are implemented
-	for _, bf := range []string{"all", "a", "append", "unshallow", "update-shallow", "dry-run", "k", "keep", "multiple", "p", "prune", "P", "prune-tags", "n", "no-tags", "t", "tags", "no-recurse-submodules", "u", "update-head-ok", "q", "quiet", "v", "verbose", "progress", "4", "ipv4", "ipv6"} {
+	for _, bf := range []string{"unshallow", "update-shallow", "k", "keep", "no-recurse-submodules", "progress", "4", "ipv4", "ipv6"} {
 		flags.Var(newNotimplBoolValue(), bf, "Not implemented")
 	}
-	for _, sf := range []string{"deepend", "shallow-since", "shallow-exclude", "refmap", "recurse-submodules", "j", "jobs", "submodule-prefix", "recurse-submodules-default", "upload-pack", "o", "server-option"} {
+	for _, sf := range []string{"deepend", "shallow-since", "shallow-exclude", "refmap", "recurse-submodules", "j", "jobs", "submodule-prefix", "recurse-submodules-default", "o", "server-option"} {
 		flags.Var(newNotimplStringValue(), sf, "Not implemented")
 	}
 
 	options.Depth = int32(*flags.Int("depth", 0, "Limit fetching to the specified number of commits. This is current a no-op."))
+
+	flags.BoolVar(&options.Append, "append", false, "Append to .git/FETCH_HEAD instead of overwriting it")
+	flags.BoolVar(&options.Append, "a", false, "Alias of --append")
+	flags.BoolVar(&options.DryRun, "dry-run", false, "Show what would be done, without making any changes")
+	flags.BoolVar(&options.Prune, "prune", false, "Remove remote-tracking references that no longer exist on the remote")
+	flags.BoolVar(&options.Prune, "p", false, "Alias of --prune")
+	flags.BoolVar(&options.NoPrune, "no-prune", false, "Do not prune, even if fetch.prune is set")
+	flags.BoolVar(&options.PruneTags, "prune-tags", false, "Remove local tags that no longer exist on the remote if --prune is enabled")
+	flags.BoolVar(&options.PruneTags, "P", false, "Alias of --prune-tags")
+	flags.BoolVar(&options.Tags, "tags", false, "Fetch all tags from the remote")
+	flags.BoolVar(&options.Tags, "t", false, "Alias of --tags")
+	flags.BoolVar(&options.NoTags, "no-tags", false, "Disable automatic tag following")
+	flags.BoolVar(&options.NoTags, "n", false, "Alias of --no-tags")
+	flags.BoolVar(&options.UpdateHeadOK, "update-head-ok", false, "Allow updating the ref of the current branch")
+	flags.BoolVar(&options.UpdateHeadOK, "u", false, "Alias of --update-head-ok")
+	flags.BoolVar(&options.Quiet, "quiet", false, "Do not print the refs that were updated")
+	flags.BoolVar(&options.Quiet, "q", false, "Alias of --quiet")
+	flags.BoolVar(&options.Verbose, "verbose", false, "Also print refs that are up to date")
+	flags.BoolVar(&options.Verbose, "v", false, "Alias of --verbose")
+	flags.StringVar(&options.UploadPack, "upload-pack", "", "Path to git-upload-pack on the remote")
 }
 
 func Fetch(c *git.Client, args []string) error {
@@ -33,8 +55,33 @@ func Fetch(c *git.Client, args []string) error {
 	addSharedFetchFlags(flags, &opts)
 	flags.BoolVar(&opts.Force, "force", false, "Do not verify if refs exist before overwriting")
 	flags.BoolVar(&opts.Force, "f", false, "Alias of --force")
+	all := flags.Bool("all", false, "Fetch all remotes")
+	multiple := flags.Bool("multiple", false, "Allow several remotes or groups to be specified")
 	flags.Parse(args)
 
+	if *all || *multiple {
+		var remotes []git.Remote
+		if *all {
+			if flags.NArg() > 0 {
+				return fmt.Errorf("fetch --all does not take a repository argument")
+			}
+			all, err := git.RemoteList(c, git.RemoteOptions{})
+			if err != nil {
+				return err
+			}
+			for _, r := range all {
+				if c.GetConfig(fmt.Sprintf("remote.%v.skipFetchAll", r)) != "true" {
+					remotes = append(remotes, r)
+				}
+			}
+		} else {
+			for _, arg := range flags.Args() {
+				remotes = append(remotes, git.RemoteGroup(c, arg)...)
+			}
+		}
+		return fetchMultiple(c, opts, remotes)
+	}
+
 	var repository git.Remote
 	var refspecs []git.RefSpec
 	if flags.NArg() < 1 {
@@ -51,5 +98,31 @@ func Fetch(c *git.Client, args []string) error {
 			refspecs = append(refspecs, git.RefSpec(ref))
 		}
 	}
+	if len(refspecs) == 0 {
+		if group := git.RemoteGroup(c, repository.String()); len(group) != 1 || group[0] != repository {
+			return fetchMultiple(c, opts, group)
+		}
+	}
 	return git.Fetch(c, opts, repository, refspecs)
 }
+
+// Fetches each of remotes in turn, appending to FETCH_HEAD after the first.
+func fetchMultiple(c *git.Client, opts git.FetchOptions, remotes []git.Remote) error {
+	var failed []string
+	for i, r := range remotes {
+		if i > 0 {
+			opts.Append = true
+		}
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Fetching %v\n", r)
+		}
+		if err := git.Fetch(c, opts, r, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			failed = append(failed, r.String())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not fetch %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -80,3 +80,41 @@ func (b *notimplBoolValue) Get() interface{} { return bool(*b) }
 func (b *notimplBoolValue) String() string { return "false" }
 
 func (b *notimplBoolValue) IsBoolFlag() bool { return true }
+
+// A string value whose argument is optional, such as --color[=<when>]. If
+// the flag is given without an argument, the value is set to noarg.
+type optionalStringValue struct {
+	p     *string
+	noarg string
+}
+
+func newOptionalStringValue(p *string, val, noarg string) *optionalStringValue {
+	*p = val
+	return &optionalStringValue{p, noarg}
+}
+
+func (s *optionalStringValue) Set(val string) error {
+	// The flag package treats the flag as a boolean, so a flag without
+	// an argument is set to "true".
+	if val == "true" {
+		val = s.noarg
+	}
+	*s.p = val
+	return nil
+}
+
+func (s *optionalStringValue) Get() interface{} {
+	if s.p == nil {
+		return ""
+	}
+	return *s.p
+}
+
+func (s *optionalStringValue) String() string {
+	if s.p == nil {
+		return ""
+	}
+	return *s.p
+}
+
+func (s *optionalStringValue) IsBoolFlag() bool { return true }
@@ -78,3 +78,37 @@ func TestNotImplBoolValue(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestOptionalStringValue(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "auto"},
+		{[]string{"--color"}, "always"},
+		{[]string{"--color=never"}, "never"},
+		{[]string{"--color=auto", "path1"}, "auto"},
+	}
+	for i, tc := range tests {
+		flags := flag.NewFlagSet("test5", flag.ContinueOnError)
+		var v string
+		flags.Var(newOptionalStringValue(&v, "auto", "always"), "color", "")
+		if err := flags.Parse(tc.args); err != nil {
+			t.Fatalf("Test %d: unexpected error %v", i, err)
+		}
+		if v != tc.want {
+			t.Errorf("Test %d: got %q want %q", i, v, tc.want)
+		}
+	}
+
+	// The argument must be attached to the flag, since it's optional.
+	flags := flag.NewFlagSet("test6", flag.ContinueOnError)
+	var v string
+	flags.Var(newOptionalStringValue(&v, "auto", "always"), "color", "")
+	if err := flags.Parse([]string{"--color", "never"}); err != nil {
+		t.Fatal(err)
+	}
+	if v != "always" || flags.NArg() != 1 {
+		t.Errorf("got %q with %d args, want \"always\" with 1", v, flags.NArg())
+	}
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/cgi"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+// HTTPBackend runs the http backend as a CGI program, configured from the
+// environment the same way as git http-backend.
+func HTTPBackend(args []string) error {
+	flags := newFlagSet("http-backend")
+	flags.Parse(args)
+
+	opts := git.HTTPBackendOptions{
+		ProjectRoot: os.Getenv("GIT_PROJECT_ROOT"),
+		ExportAll:   os.Getenv("GIT_HTTP_EXPORT_ALL") != "",
+		ReceivePack: os.Getenv("REMOTE_USER") != "",
+	}
+	pathinfo := os.Getenv("PATH_INFO")
+	if opts.ProjectRoot == "" {
+		// Without a project root, the web server is expected to have
+		// translated the path to the repository.
+		pathinfo = os.Getenv("PATH_TRANSLATED")
+	}
+	backend := git.NewHTTPBackend(opts)
+	return cgi.Serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The request URL includes the script name, but repositories
+		// are found relative to the path info.
+		r.URL.Path = pathinfo
+		backend.ServeHTTP(w, r)
+	}))
+}
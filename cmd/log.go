@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	"github.com/driusan/dgit/git"
+
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 func Log(c *git.Client, args []string) error {
@@ -19,90 +21,348 @@ func Log(c *git.Client, args []string) error {
 		flags.PrintDefaults()
 	}
 
-	flags.Var(newNotimplBoolValue(), "follow", "Not implemented")
-	flags.Var(newNotimplBoolValue(), "no-decorate", "Not implemented")
-	flags.Var(newNotimplStringValue(), "decorate", "Not implemented")
-	flags.Var(newNotimplStringValue(), "decorate-refs", "Not implemented")
-	flags.Var(newNotimplStringValue(), "decorate-refs-exclude", "Not implemented")
 	flags.Var(newNotimplBoolValue(), "source", "Not implemented")
-	flags.Var(newNotimplBoolValue(), "use-mailmap", "Not implemented")
-	flags.Var(newNotimplBoolValue(), "full-diff", "Not implemented")
 	flags.Var(newNotimplStringValue(), "log-size", "Not implemented")
 	flags.Var(newNotimplStringValue(), "L", "Not implemented")
 	maxCount := -1
 	flags.IntVar(&maxCount, "n", -1, "Limit the number of commits.")
 	flags.IntVar(&maxCount, "max-count", -1, "Alias for -n")
-	format := "medium" // The default
-	flags.StringVar(&format, "format", "medium", "Pretty print the commit logs")
+	var pretty git.PrettyOptions
+	finishPretty := addPrettyFlags(flags, &pretty)
+	opts := git.RevListOptions{Quiet: true}
+	finish := addRevListFlags(flags, &opts)
+	flags.BoolVar(&opts.Follow, "follow", false, "continue listing the history of a file beyond renames")
+	noFollow := flags.Bool("no-follow", false, "do not follow renames, even if log.follow is set")
+	var diffOpts git.LogDiffOptions
+	finishDiff := addLogDiffFlags(c, flags, &diffOpts, false)
+	fullDiff := flags.Bool("full-diff", false, "show the full diff of commits, not only the changes to the given paths")
+	graph := flags.Bool("graph", false, "draw the history graph beside the commits")
 
-	adjustedArgs := []string{}
-	for i, a := range args {
-		// Go adds an arbitrary -- at the end when doing a go get, we need
-		// to remove it or the flag parsing thinks it's a file.
-		if i == len(args)-1 && a == "--" {
-			continue
-		}
-		if strings.HasPrefix(a, "-n") && a != "-n" {
-			adjustedArgs = append(adjustedArgs, "-n", a[2:])
-			continue
-		}
-		if strings.HasPrefix(a, "-") && len(a) > 1 {
-			if _, err := strconv.Atoi(a[1:]); err == nil {
-				adjustedArgs = append(adjustedArgs, "-n", a[1:])
-				continue
-			}
+	// Go adds an arbitrary -- at the end when doing a go get, we need
+	// to remove it or the flag parsing thinks it's a file.
+	if len(args) > 0 && args[len(args)-1] == "--" {
+		args = args[:len(args)-1]
+	}
+	args, paths := revListAdjustArgs(logAdjustArgs(args))
+	flags.Parse(args)
+	if err := finish(); err != nil {
+		return err
+	}
+	if err := finishPretty(c); err != nil {
+		return err
+	}
+	if err := finishDiff(pretty.Color, opts.FirstParent); err != nil {
+		return err
+	}
+	diffOpts.Pickaxe.IgnoreCase = opts.RegexpIgnoreCase
+	// Object names in raw diffs are abbreviated like commit ids.
+	if diffOpts.Abbrev = pretty.Abbrev; diffOpts.Abbrev <= 0 {
+		diffOpts.Abbrev = 7
+	}
+	if *graph {
+		if opts.Reverse {
+			return fmt.Errorf("fatal: options '--reverse' and '--graph' cannot be used together")
+		}
+		// The graph needs the parents of each commit to be shown
+		// after it, and lines of history not to be intermixed.
+		if !opts.DateOrder {
+			opts.TopoOrder = true
 		}
-		adjustedArgs = append(adjustedArgs, a)
+		opts.RewriteParents = true
 	}
-
-	flags.Parse(adjustedArgs)
-
-	if flags.NArg() > 1 {
-		fmt.Fprintf(flag.CommandLine.Output(), "Paths are not yet implemented, just the revision")
-		flags.Usage()
-		os.Exit(2)
+	if maxCount >= 0 {
+		mc := uint(maxCount)
+		opts.MaxCount = &mc
+	}
+	includes, excludes, err := revListParseArgs(c, &opts, flags.Args(), paths, "HEAD")
+	if err != nil {
+		return err
+	}
+	if *noFollow {
+		opts.Follow = false
+	} else if !opts.Follow && len(opts.Paths) == 1 && c.GetConfig("log.follow") == "true" {
+		opts.Follow = true
 	}
 
-	var commit git.Commitish
-	var err error
-	if flags.NArg() == 0 {
-		commit, err = git.RevParseCommitish(c, &git.RevParseOptions{}, "HEAD")
-	} else {
-		commit, err = git.RevParseCommitish(c, &git.RevParseOptions{}, flags.Arg(0))
+	if !*fullDiff {
+		diffOpts.Paths = opts.Paths
 	}
+
+	printer, err := git.NewPrettyPrinter(c, pretty)
 	if err != nil {
 		return err
 	}
+	var g *git.Graph
+	if *graph {
+		g = git.NewGraph(pretty.Color)
+		printer.SetGraph(g)
+	}
+	return git.RevListWalk(c, opts, includes, excludes, func(e git.RevListEntry) error {
+		if g != nil {
+			g.Update(git.CommitID(e.Id), e.Parents, e.Mark(opts))
+		}
+		shown, err := printer.PrintWithDiff(os.Stdout, git.CommitID(e.Id), e.Mark(opts), diffOpts)
+		if err == nil && !shown {
+			return git.ErrNotShown
+		}
+		return err
+	})
+}
 
-	opts := git.RevListOptions{Quiet: true}
-	if maxCount >= 0 {
-		mc := uint(maxCount)
-		opts.MaxCount = &mc
+// Adjusts arguments of the form -U<n>, -S<string> and -G<regex>, and the
+// rename detection options, which the flag package can't parse. The
+// strings and regexes are kept in the same argument as -S=<string>, in
+// case they look like flags themselves.
+func logAdjustArgs(args []string) []string {
+	var adjusted []string
+	for i, a := range args {
+		switch {
+		case a == "--":
+			return append(adjusted, args[i:]...)
+		case strings.HasPrefix(a, "-U") && a != "-U":
+			adjusted = append(adjusted, "-U", a[2:])
+		case len(a) > 2 && (strings.HasPrefix(a, "-S") || strings.HasPrefix(a, "-G")):
+			adjusted = append(adjusted, a[:2]+"="+a[2:])
+		default:
+			adjusted = append(adjusted, adjustRenameArg(a))
+		}
 	}
+	return adjusted
+}
 
-	var commitPrinter func(s git.Sha1) error
+// Adds the options for the diffs that log and show print after each
+// commit to flags. If show is set, a patch (or a dense combined diff for
+// merges) is shown unless other formats are asked for, as git show does.
+// Renames are detected unless diff.renames is false. The returned function
+// must be called after the flags are parsed to finish setting opts, with
+// whether colours are used and whether only first parents are being
+// followed.
+func addLogDiffFlags(c *git.Client, flags *flag.FlagSet, opts *git.LogDiffOptions, show bool) func(color, firstParent bool) error {
+	opts.DiffCommonOptions = git.DiffRenameOptions(c)
+	finishRenames := addRenameFlags(c, flags, &opts.DiffCommonOptions)
+	patch := flags.Bool("patch", false, "show the patch of each commit")
+	p := flags.Bool("p", false, "alias of --patch")
+	u := flags.Bool("u", false, "alias of --patch")
+	noPatch := flags.Bool("no-patch", false, "do not show the diffs of commits")
+	s := flags.Bool("s", false, "alias of --no-patch")
+	flags.IntVar(&opts.NumContextLines, "unified", 3, "generate patches with <n> lines of context")
+	flags.IntVar(&opts.NumContextLines, "U", 3, "alias of --unified")
+	flags.BoolVar(&opts.Raw, "raw", false, "show the changes of each commit in raw format")
+	var stat string
+	flags.Var(newOptionalStringValue(&stat, "", "0"), "stat", "show a diffstat of each commit, optionally with <width>[,<name-width>[,<count>]]")
+	flags.IntVar(&opts.StatWidth, "stat-width", 0, "limit the width of the diffstat")
+	flags.IntVar(&opts.StatNameWidth, "stat-name-width", 0, "limit the width of file names in the diffstat")
+	flags.IntVar(&opts.StatCount, "stat-count", 0, "limit the number of files in the diffstat")
+	flags.BoolVar(&opts.ShortStat, "shortstat", false, "only show the summary line of the diffstat")
+	flags.BoolVar(&opts.NumStat, "numstat", false, "show the number of added and deleted lines of each file")
+	flags.BoolVar(&opts.Summary, "summary", false, "show created, deleted and mode changed files")
+	flags.BoolVar(&opts.NameOnly, "name-only", false, "show the names of changed files")
+	flags.BoolVar(&opts.NameStatus, "name-status", false, "show the names and status of changed files")
+	m := flags.Bool("m", false, "show the diffs of merges against each parent")
+	combined := flags.Bool("c", false, "show combined diffs of merges")
+	dense := flags.Bool("cc", false, "show dense combined diffs of merges")
+	diffMerges := flags.String("diff-merges", "", "how to show the diffs of merges: off, m, first-parent, c or cc")
+	noDiffMerges := flags.Bool("no-diff-merges", false, "do not show the diffs of merges")
+	flags.StringVar(&opts.Pickaxe.String, "S", "", "only show commits which change the number of occurrences of the string")
+	flags.StringVar(&opts.Pickaxe.Grep, "G", "", "only show commits with added or removed lines matching the regex")
+	flags.BoolVar(&opts.Pickaxe.Regex, "pickaxe-regex", false, "treat the string given to -S as a regex")
+	flags.BoolVar(&opts.Pickaxe.All, "pickaxe-all", false, "show all the changes of commits matching -S or -G, not only the matching files")
 
-	if format == "medium" {
-		commitPrinter = func(s git.Sha1) error {
-			output, err := git.CommitID(s).FormatMedium(c)
-			if err != nil {
-				return err
+	return func(color, firstParent bool) error {
+		if stat != "" {
+			opts.Stat = true
+			limits := strings.Split(stat, ",")
+			if len(limits) > 3 {
+				return fmt.Errorf("fatal: invalid --stat value: %v", stat)
+			}
+			for i, dst := range []*int{&opts.StatWidth, &opts.StatNameWidth, &opts.StatCount} {
+				if i >= len(limits) || limits[i] == "" {
+					continue
+				}
+				n, err := strconv.Atoi(limits[i])
+				if err != nil {
+					return fmt.Errorf("fatal: invalid --stat value: %v", stat)
+				}
+				*dst = n
 			}
-			fmt.Printf("%s", output)
-			return nil
 		}
-	} else if strings.HasPrefix(format, "format:") {
-		commitPrinter = func(s git.Sha1) error {
-			output, err := git.CommitID(s).Format(c, format[7:])
+		if err := finishRenames(); err != nil {
+			return err
+		}
+		opts.Patch = *patch || *p || *u
+		opts.Color = color
+		if opts.Pickaxe.String != "" && opts.Pickaxe.Grep != "" {
+			return fmt.Errorf("fatal: options '-G' and '-S' cannot be used together")
+		}
+		if err := opts.Pickaxe.Validate(); err != nil {
+			return fmt.Errorf("fatal: invalid regex: %v", err)
+		}
+
+		switch *diffMerges {
+		case "":
+			switch {
+			case *dense:
+				opts.Merges = "dense-combined"
+			case *combined:
+				opts.Merges = "combined"
+			case *m:
+				opts.Merges = "separate"
+			case firstParent:
+				opts.Merges = "first-parent"
+			case show:
+				opts.Merges = "dense-combined"
+			}
+		case "off", "none":
+		case "m", "separate":
+			opts.Merges = "separate"
+		case "1", "first-parent":
+			opts.Merges = "first-parent"
+		case "c", "combined":
+			opts.Merges = "combined"
+		case "cc", "dense-combined":
+			opts.Merges = "dense-combined"
+		default:
+			return fmt.Errorf("fatal: unknown value for --diff-merges: %v", *diffMerges)
+		}
+		if *noDiffMerges {
+			opts.Merges = ""
+		}
+		// Combined diffs imply a patch if no other format was given.
+		if (*combined || *dense) && !opts.Raw && !opts.NameOnly && !opts.NameStatus && !opts.Stat && !opts.NumStat && !opts.ShortStat {
+			opts.Patch = true
+		}
+		if *noPatch || *s {
+			opts.Patch, opts.Raw, opts.NameOnly, opts.NameStatus = false, false, false, false
+			opts.Stat, opts.NumStat, opts.ShortStat = false, false, false
+		} else if show && !opts.Patch && !opts.Raw && !opts.NameOnly && !opts.NameStatus && !opts.Stat && !opts.NumStat && !opts.ShortStat && !opts.Summary {
+			opts.Patch = true
+		}
+		return nil
+	}
+}
+
+// Adds the options for how commits are printed, which are shared between
+// log and show, to flags. The returned function must be called after the
+// flags are parsed to finish setting opts from them and the config.
+func addPrettyFlags(flags *flag.FlagSet, opts *git.PrettyOptions) func(c *git.Client) error {
+	flags.StringVar(&opts.Format, "pretty", "", "pretty print the commits in the given format")
+	flags.StringVar(&opts.Format, "format", "", "alias of --pretty")
+	oneline := flags.Bool("oneline", false, "shorthand for --pretty=oneline --abbrev-commit")
+	abbrevCommit := flags.Bool("abbrev-commit", false, "show abbreviated commit ids")
+	noAbbrevCommit := flags.Bool("no-abbrev-commit", false, "show full commit ids")
+	flags.IntVar(&opts.Abbrev, "abbrev", 0, "show abbreviated commit ids with at least n digits")
+	date := flags.String("date", "", "show dates in the given format")
+	relativeDate := flags.Bool("relative-date", false, "synonym for --date=relative")
+	var color string
+	flags.Var(newOptionalStringValue(&color, "", "always"), "color", "use colours in the output always, never or auto (if the output is a terminal)")
+	noColor := flags.Bool("no-color", false, "do not use colours in the output")
+	var decorate string
+	flags.Var(newOptionalStringValue(&decorate, "", "short"), "decorate", "show the refs pointing to commits: short, full, auto or no")
+	noDecorate := flags.Bool("no-decorate", false, "do not show the refs pointing to commits")
+	flags.Var(NewMultiStringValue(&opts.DecorateRefs), "decorate-refs", "only decorate commits with refs matching the pattern")
+	flags.Var(NewMultiStringValue(&opts.DecorateRefsExclude), "decorate-refs-exclude", "do not decorate commits with refs matching the pattern")
+	notes := flags.Bool("notes", false, "show notes after the commit message")
+	noNotes := flags.Bool("no-notes", false, "do not show notes")
+	useMailmap := flags.Bool("use-mailmap", false, "map author and committer names and emails with the mailmap")
+	flags.BoolVar(useMailmap, "mailmap", false, "alias of --use-mailmap")
+	noUseMailmap := flags.Bool("no-use-mailmap", false, "do not map author and committer names and emails")
+	flags.BoolVar(noUseMailmap, "no-mailmap", false, "alias of --no-use-mailmap")
+
+	return func(c *git.Client) error {
+		if *oneline && opts.Format == "" {
+			opts.Format = "oneline"
+		}
+		opts.AbbrevCommit = *oneline || *abbrevCommit || c.GetConfig("log.abbrevcommit") == "true"
+		if *noAbbrevCommit {
+			opts.AbbrevCommit = false
+		}
+		if opts.Abbrev == 0 {
+			if abbrev, err := strconv.Atoi(c.GetConfig("core.abbrev")); err == nil {
+				opts.Abbrev = abbrev
+			}
+		}
+
+		if *relativeDate && *date == "" {
+			*date = "relative"
+		}
+		if *date == "" {
+			*date = c.GetConfig("log.date")
+		}
+		if *date != "" {
+			mode, err := git.ParseDateMode(*date)
 			if err != nil {
 				return err
 			}
-			fmt.Printf("%s\n", output)
-			return nil
+			opts.Date = mode
+		}
+
+		if *noColor {
+			color = "never"
+		}
+		if color == "" {
+			if color = c.GetConfig("color.diff"); color == "" {
+				color = c.GetConfig("color.ui")
+			}
+		}
+		switch color {
+		case "always":
+			opts.Color = true
+		case "never", "false":
+			opts.Color = false
+		case "", "auto", "true":
+			opts.Color = terminal.IsTerminal(int(os.Stdout.Fd()))
+		default:
+			return fmt.Errorf("fatal: invalid --color value: %v", color)
+		}
+
+		if *noDecorate {
+			decorate = "no"
+		}
+		if decorate == "" {
+			decorate = c.GetConfig("log.decorate")
+		}
+		switch decorate {
+		case "short", "full":
+			opts.Decorate = decorate
+		case "true", "yes", "1":
+			opts.Decorate = "short"
+		case "false", "no", "0":
+			opts.Decorate = ""
+		case "", "auto":
+			if terminal.IsTerminal(int(os.Stdout.Fd())) {
+				opts.Decorate = "short"
+			}
+		default:
+			return fmt.Errorf("fatal: invalid --decorate option: %v", decorate)
+		}
+		// Explicit --decorate-refs override the patterns excluded by
+		// the config.
+		if len(opts.DecorateRefs) == 0 {
+			opts.DecorateRefsExclude = append(opts.DecorateRefsExclude, c.GetConfigAll("log.excludeDecoration")...)
 		}
-	} else {
-		return fmt.Errorf("Format %s is not supported\n", format)
-	}
 
-	return git.RevListCallback(c, opts, []git.Commitish{commit}, nil, commitPrinter)
+		switch {
+		case *noNotes:
+			show := false
+			opts.ShowNotes = &show
+		case *notes:
+			show := true
+			opts.ShowNotes = &show
+		}
+
+		switch {
+		case *noUseMailmap:
+			opts.UseMailmap = false
+		case *useMailmap:
+			opts.UseMailmap = true
+		default:
+			switch c.GetConfig("log.mailmap") {
+			case "false", "no", "off", "0":
+				opts.UseMailmap = false
+			default:
+				opts.UseMailmap = true
+			}
+		}
+		return nil
+	}
 }
@@ -20,6 +20,7 @@ func Pull(c *git.Client, args []string) error {
 	opts := git.PullOptions{}
 	addSharedFetchFlags(flags, &opts.FetchOptions)
 	addSharedMergeFlags(flags, &opts.MergeOptions)
+	flags.Var(newNotimplBoolValue(), "all", "Not implemented")
 	flags.Parse(args)
 
 	var repository git.Remote
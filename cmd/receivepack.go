@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func ReceivePack(args []string) error {
+	flags := newFlagSet("receive-pack")
+	opts := git.ReceivePackOptions{}
+	flags.BoolVar(&opts.StatelessRPC, "stateless-rpc", false, "Perform only a single read-write cycle with stdin and stdout")
+	flags.BoolVar(&opts.AdvertiseRefs, "advertise-refs", false, "Only advertise refs and exit")
+	flags.BoolVar(&opts.AdvertiseRefs, "http-backend-info-refs", false, "Alias of --advertise-refs")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	c, err := git.EnterRepo(flags.Arg(0), false)
+	if err != nil {
+		return fmt.Errorf("fatal: '%s' does not appear to be a git repository", flags.Arg(0))
+	}
+	opts.GitProtocol = os.Getenv("GIT_PROTOCOL")
+	return git.ReceivePack(c, opts, os.Stdin, os.Stdout)
+}
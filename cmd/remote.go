@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/driusan/dgit/git"
 )
@@ -70,9 +71,8 @@ func Remote(c *git.Client, args []string) error {
 		uflags := newFlagSet("remote-get-url")
 		urlopts := git.RemoteGetURLOptions{RemoteOptions: opts}
 		uflags.BoolVar(&urlopts.Push, "push", false, "Print push URLs, not fetch URLs")
-		uflags.BoolVar(&urlopts.All, "all", false, "Print all URLs, not just the first (not implemented)")
-		uflags.Parse(args[1:])
-		args = uflags.Args()
+		uflags.BoolVar(&urlopts.All, "all", false, "Print all URLs, not just the first")
+		args = parseInterspersed(uflags, args[1:])
 		if len(args) < 1 {
 			uflags.Usage()
 			os.Exit(1)
@@ -95,6 +95,132 @@ func Remote(c *git.Client, args []string) error {
 			return printRemotes(c, opts)
 		}
 		return git.RemoteShow(c, sopts, git.Remote(args[0]), os.Stdout)
+	case "rename":
+		rflags := newFlagSet("remote-rename")
+		rflags.Var(newNotimplBoolValue(), "progress", "Not implemented")
+		args = parseInterspersed(rflags, args[1:])
+		if len(args) != 2 {
+			rflags.Usage()
+			os.Exit(2)
+		}
+		return git.RemoteRename(c, opts, git.Remote(args[0]), git.Remote(args[1]))
+	case "remove", "rm":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: %v remote remove <name>", os.Args[0])
+		}
+		return git.RemoteRemove(c, opts, git.Remote(args[1]))
+	case "set-url":
+		uflags := newFlagSet("remote-set-url")
+		urlopts := git.RemoteSetURLOptions{RemoteOptions: opts}
+		uflags.BoolVar(&urlopts.Push, "push", false, "Manipulate push URLs instead of fetch URLs")
+		uflags.BoolVar(&urlopts.Add, "add", false, "Add a new URL instead of changing existing URLs")
+		uflags.BoolVar(&urlopts.Delete, "delete", false, "Remove all URLs matching the regex")
+		args = parseInterspersed(uflags, args[1:])
+		switch {
+		case urlopts.Add && urlopts.Delete:
+			return fmt.Errorf("--add and --delete can not be used together")
+		case (urlopts.Add || urlopts.Delete) && len(args) == 2:
+			return git.RemoteSetURL(c, urlopts, git.Remote(args[0]), args[1], "")
+		case !urlopts.Add && !urlopts.Delete && (len(args) == 2 || len(args) == 3):
+			var oldurl string
+			if len(args) == 3 {
+				oldurl = args[2]
+			}
+			return git.RemoteSetURL(c, urlopts, git.Remote(args[0]), args[1], oldurl)
+		default:
+			uflags.Usage()
+			os.Exit(2)
+		}
+		return nil
+	case "set-head":
+		hflags := newFlagSet("remote-set-head")
+		hopts := git.RemoteSetHeadOptions{RemoteOptions: opts}
+		hflags.BoolVar(&hopts.Auto, "auto", false, "Query the remote to determine its HEAD")
+		hflags.BoolVar(&hopts.Auto, "a", false, "Alias of --auto")
+		hflags.BoolVar(&hopts.Delete, "delete", false, "Delete the remote's default branch")
+		hflags.BoolVar(&hopts.Delete, "d", false, "Alias of --delete")
+		args = parseInterspersed(hflags, args[1:])
+		var branch string
+		switch {
+		case len(args) == 2 && !hopts.Auto && !hopts.Delete:
+			branch = args[1]
+		case len(args) == 1 && (hopts.Auto || hopts.Delete):
+		default:
+			hflags.Usage()
+			os.Exit(2)
+		}
+		branch, err := git.RemoteSetHead(c, hopts, git.Remote(args[0]), branch)
+		if err != nil {
+			return err
+		}
+		if hopts.Auto {
+			fmt.Printf("%v/HEAD set to %v\n", args[0], branch)
+		}
+		return nil
+	case "prune":
+		pflags := newFlagSet("remote-prune")
+		popts := git.RemotePruneOptions{RemoteOptions: opts}
+		pflags.BoolVar(&popts.DryRun, "dry-run", false, "Report what would be pruned without pruning")
+		pflags.BoolVar(&popts.DryRun, "n", false, "Alias of --dry-run")
+		for _, name := range parseInterspersed(pflags, args[1:]) {
+			r := git.Remote(name)
+			pruned, err := git.RemotePrune(c, popts, r)
+			if err != nil {
+				return err
+			}
+			if len(pruned) == 0 {
+				continue
+			}
+			url, err := r.FetchURL(c)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Pruning %v\nURL: %v\n", r, url)
+			for _, ref := range pruned {
+				name := strings.TrimPrefix(ref.String(), "refs/remotes/")
+				if popts.DryRun {
+					fmt.Printf(" * [would prune] %v\n", name)
+				} else {
+					fmt.Printf(" * [pruned] %v\n", name)
+				}
+			}
+		}
+		return nil
+	case "set-branches":
+		bflags := newFlagSet("remote-set-branches")
+		bopts := git.RemoteSetBranchesOptions{RemoteOptions: opts}
+		bflags.BoolVar(&bopts.Add, "add", false, "Add to the tracked branches instead of replacing them")
+		args = parseInterspersed(bflags, args[1:])
+		if len(args) < 1 || (bopts.Add && len(args) < 2) {
+			bflags.Usage()
+			os.Exit(2)
+		}
+		return git.RemoteSetBranches(c, bopts, git.Remote(args[0]), args[1:])
+	case "update":
+		fopts := git.FetchOptions{}
+		uflags := newFlagSet("remote-update")
+		uflags.BoolVar(&fopts.Prune, "prune", false, "Prune remote-tracking refs of the remotes being updated")
+		uflags.BoolVar(&fopts.Prune, "p", false, "Alias of --prune")
+		args = parseInterspersed(uflags, args[1:])
+		if len(args) == 0 && len(c.GetConfigAll("remotes.default")) > 0 {
+			args = []string{"default"}
+		}
+		var remotes []git.Remote
+		if len(args) == 0 {
+			all, err := git.RemoteList(c, opts)
+			if err != nil {
+				return err
+			}
+			for _, r := range all {
+				if c.GetConfig(fmt.Sprintf("remote.%v.skipDefaultUpdate", r)) != "true" {
+					remotes = append(remotes, r)
+				}
+			}
+		}
+		for _, name := range args {
+			remotes = append(remotes, git.RemoteGroup(c, name)...)
+		}
+		return fetchMultiple(c, fopts, remotes)
 	default:
 		return fmt.Errorf("Remote subcommand %v not implemented", args[0])
 	}
@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/driusan/dgit/git"
 )
@@ -19,40 +21,212 @@ func RevList(c *git.Client, args []string) error {
 
 	opts := git.RevListOptions{}
 	flags.BoolVar(&opts.Objects, "objects", false, "include non-commit objects in output")
+	flags.BoolVar(&opts.ObjectsEdge, "objects-edge", false, "like --objects, but also print excluded commits prefixed with '-'")
 	flags.BoolVar(&opts.Quiet, "quiet", false, "prevent printing of revisions")
 	flags.BoolVar(&opts.VerifyObjects, "verify-objects", false, "verify objects instead of printing them")
-	flags.BoolVar(&opts.All, "all", false, "pretend as if all refs were passed on the command line")
+	flags.BoolVar(&opts.Count, "count", false, "print the number of commits instead of listing them")
+	maxCount := -1
+	flags.IntVar(&maxCount, "n", -1, "Limit the number of commits.")
+	flags.IntVar(&maxCount, "max-count", -1, "Alias for -n")
+	finish := addRevListFlags(flags, &opts)
 
+	args, paths := revListAdjustArgs(args)
 	flags.Parse(args)
-	args = flags.Args()
+	if err := finish(); err != nil {
+		return err
+	}
+	if maxCount >= 0 {
+		mc := uint(maxCount)
+		opts.MaxCount = &mc
+	}
 	if opts.VerifyObjects {
 		opts.Objects = true
 	}
-	// First get a map of excluded commitIDs
-	var excludes []git.Commitish
-	var includes []git.Commitish
-	for _, rev := range args {
-		if rev == "" {
-			continue
+	includes, excludes, err := revListParseArgs(c, &opts, flags.Args(), paths, "")
+	if err != nil {
+		return err
+	}
+	if len(includes) == 0 && len(excludes) == 0 && !opts.All && len(opts.Branches)+len(opts.Tags)+len(opts.Remotes) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	_, err = git.RevList(c, opts, os.Stdout, includes, excludes)
+	return err
+}
+
+// Adds the options for which commits to list and how to order them, which
+// are shared between rev-list and log, to flags. The returned function must
+// be called after the flags are parsed to finish setting opts.
+func addRevListFlags(flags *flag.FlagSet, opts *git.RevListOptions) func() error {
+	flags.BoolVar(&opts.All, "all", false, "pretend as if all refs were passed on the command line")
+	flags.Var(NewMultiStringValue(&opts.Branches), "branches", "pretend as if all branches matching the glob were passed on the command line")
+	flags.Var(NewMultiStringValue(&opts.Tags), "tags", "pretend as if all tags matching the glob were passed on the command line")
+	flags.Var(NewMultiStringValue(&opts.Remotes), "remotes", "pretend as if all remote-tracking branches matching the glob were passed on the command line")
+
+	flags.BoolVar(&opts.TopoOrder, "topo-order", false, "show no parents before all of their children, and avoid intermixing lines of history")
+	flags.BoolVar(&opts.DateOrder, "date-order", false, "show no parents before all of their children, and otherwise order by commit date")
+	flags.BoolVar(&opts.Reverse, "reverse", false, "output the commits in reverse order")
+
+	flags.UintVar(&opts.Skip, "skip", 0, "skip the given number of commits before starting to show output")
+	flags.BoolVar(&opts.FirstParent, "first-parent", false, "only follow the first parent of merge commits")
+	merges := flags.Bool("merges", false, "only print merge commits")
+	noMerges := flags.Bool("no-merges", false, "do not print commits with more than one parent")
+	minParents := flags.Int("min-parents", 0, "only show commits with at least the given number of parents")
+	maxParents := flags.Int("max-parents", -1, "only show commits with at most the given number of parents")
+	noMinParents := flags.Bool("no-min-parents", false, "reset the minimum number of parents")
+	noMaxParents := flags.Bool("no-max-parents", false, "reset the maximum number of parents")
+
+	var since, until string
+	flags.StringVar(&since, "since", "", "show commits more recent than the given date")
+	flags.StringVar(&since, "after", "", "alias of --since")
+	flags.StringVar(&until, "until", "", "show commits older than the given date")
+	flags.StringVar(&until, "before", "", "alias of --until")
+	var maxAge, minAge string
+	flags.StringVar(&maxAge, "max-age", "", "show commits more recent than the given unix timestamp")
+	flags.StringVar(&minAge, "min-age", "", "show commits older than the given unix timestamp")
+
+	flags.Var(NewMultiStringValue(&opts.Author), "author", "only show commits with an author matching the regular expression")
+	flags.Var(NewMultiStringValue(&opts.Committer), "committer", "only show commits with a committer matching the regular expression")
+	flags.Var(NewMultiStringValue(&opts.Grep), "grep", "only show commits with a log message matching the regular expression")
+	flags.BoolVar(&opts.AllMatch, "all-match", false, "only show commits matching all of the --grep patterns")
+	flags.BoolVar(&opts.RegexpIgnoreCase, "regexp-ignore-case", false, "match the regular expressions case insensitively")
+	flags.BoolVar(&opts.RegexpIgnoreCase, "i", false, "alias of --regexp-ignore-case")
+
+	flags.BoolVar(&opts.FullHistory, "full-history", false, "do not simplify history when limiting to paths")
+	flags.BoolVar(&opts.SimplifyMerges, "simplify-merges", false, "remove merges which don't contribute any changes to the paths from the full history")
+	flags.BoolVar(&opts.AncestryPath, "ancestry-path", false, "only show commits which are descendants of the excluded commits")
+	flags.BoolVar(&opts.Boundary, "boundary", false, "also show excluded boundary commits, prefixed with '-'")
+	flags.BoolVar(&opts.LeftRight, "left-right", false, "mark which side of a symmetric difference commits are reachable from")
+	flags.BoolVar(&opts.CherryMark, "cherry-mark", false, "mark commits with an equivalent change on the other side of a symmetric difference with '='")
+	flags.BoolVar(&opts.CherryPick, "cherry-pick", false, "omit commits with an equivalent change on the other side of a symmetric difference")
+
+	return func() error {
+		// Later options override earlier ones in git, but the flag
+		// package doesn't tell us the order, so the resetting options
+		// take precedence.
+		if *minParents > 0 {
+			opts.MinParents = uint(*minParents)
+		}
+		if *maxParents >= 0 {
+			mp := uint(*maxParents)
+			opts.MaxParents = &mp
+		}
+		if *merges {
+			opts.MinParents = 2
 		}
-		if rev[0] == '^' && len(rev) > 1 {
-			commits, _, err := RevParse(c, []string{rev[1:]})
+		if *noMerges {
+			mp := uint(1)
+			opts.MaxParents = &mp
+		}
+		if *noMinParents {
+			opts.MinParents = 0
+		}
+		if *noMaxParents {
+			opts.MaxParents = nil
+		}
+		if maxAge != "" {
+			since = "@" + maxAge
+		}
+		if minAge != "" {
+			until = "@" + minAge
+		}
+		if since != "" {
+			t, err := git.ParseApproxidate(since, time.Now())
 			if err != nil {
-				return fmt.Errorf("%s:%v", rev, err)
+				return fmt.Errorf("fatal: invalid date '%v'", since)
 			}
-			for _, cmt := range commits {
-				excludes = append(excludes, cmt)
-			}
-		} else {
-			commits, _, err := RevParse(c, []string{rev})
+			opts.Since = t
+		}
+		if until != "" {
+			t, err := git.ParseApproxidate(until, time.Now())
 			if err != nil {
-				return fmt.Errorf("%s:%v", rev, err)
+				return fmt.Errorf("fatal: invalid date '%v'", until)
 			}
+			opts.Until = t
+		}
+		return nil
+	}
+}
+
+// Adjusts args so that they can be parsed by the flag package. Options with
+// optional arguments such as --branches are given their default, and -<n>
+// is converted to -n <n>. Any arguments after a "--" are returned separately
+// as paths.
+func revListAdjustArgs(args []string) (adjusted, paths []string) {
+	for i, a := range args {
+		switch {
+		case a == "--":
+			return adjusted, append([]string{}, args[i+1:]...)
+		case a == "--branches", a == "--tags", a == "--remotes":
+			adjusted = append(adjusted, a+"=*")
+		case strings.HasPrefix(a, "-n") && a != "-n":
+			adjusted = append(adjusted, "-n", a[2:])
+		case len(a) > 1 && a[0] == '-' && a[1] >= '0' && a[1] <= '9':
+			adjusted = append(adjusted, "-n", a[1:])
+		default:
+			adjusted = append(adjusted, a)
+		}
+	}
+	return adjusted, nil
+}
+
+// Parses the revisions and paths in args, which were left over after
+// parsing the flags for rev-list or log, and sets opts.Paths. Arguments
+// which aren't revisions are treated as paths if they exist, and paths
+// contains any arguments which followed a "--". If none of the arguments
+// are revisions, def is used.
+func revListParseArgs(c *git.Client, opts *git.RevListOptions, args, paths []string, def string) (includes, excludes []git.Commitish, err error) {
+	var revs []string
+	for i, arg := range args {
+		if arg == "" {
+			continue
+		}
+		commits, err := git.RevParse(c, git.RevParseOptions{RevsOnly: true}, []string{arg})
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(commits) > 0 {
+			revs = append(revs, arg)
 			for _, cmt := range commits {
-				includes = append(includes, cmt)
+				if cmt.Excluded {
+					excludes = append(excludes, cmt)
+				} else {
+					// Tags are listed by name with --objects.
+					if cmt.Name == "" {
+						cmt.Name = arg
+					}
+					includes = append(includes, cmt)
+				}
+			}
+			continue
+		}
+		// Once a path is found, everything else must be a path.
+		for _, p := range args[i:] {
+			if _, err := os.Lstat(p); err != nil {
+				return nil, nil, fmt.Errorf("fatal: ambiguous argument '%v': unknown revision or path not in the working tree.\nUse '--' to separate paths from revisions, like this:\n'git <command> [<revision>...] -- [<file>...]'", p)
 			}
 		}
+		paths = append(args[i:], paths...)
+		break
 	}
-	_, err := git.RevList(c, opts, os.Stdout, includes, excludes)
-	return err
+	if len(revs) == 0 && def != "" && !opts.All && len(opts.Branches)+len(opts.Tags)+len(opts.Remotes) == 0 {
+		commits, err := git.RevParse(c, git.RevParseOptions{}, []string{def})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, cmt := range commits {
+			includes = append(includes, cmt)
+		}
+	}
+	for _, p := range paths {
+		ip, err := git.File(p).IndexPath(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if string(ip) == string(c.WorkDir) {
+			ip = ""
+		}
+		opts.Paths = append(opts.Paths, ip)
+	}
+	return includes, excludes, nil
 }
@@ -1,11 +1,53 @@
 package cmd
 
 import (
+	"flag"
 	"testing"
+	"time"
 
 	"github.com/driusan/dgit/git"
 )
 
+func TestRevListDateFlags(t *testing.T) {
+	tests := []struct {
+		Args         []string
+		Since, Until time.Time
+	}{
+		{[]string{"--since=2020-01-02T12:00:00Z"}, time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC), time.Time{}},
+		{[]string{"--after=2020-01-02T12:00:00Z", "--before=2020-01-03T00:00:00+01:00"}, time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC), time.Date(2020, 1, 2, 23, 0, 0, 0, time.UTC)},
+		{[]string{"--max-age=1577966400", "--min-age=1000"}, time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC), time.Unix(1000, 0)},
+	}
+	for i, tc := range tests {
+		flags := flag.NewFlagSet("rev-list", flag.ContinueOnError)
+		var opts git.RevListOptions
+		finish := addRevListFlags(flags, &opts)
+		if err := flags.Parse(tc.Args); err != nil {
+			t.Fatal(err)
+		}
+		if err := finish(); err != nil {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+			continue
+		}
+		if !opts.Since.Equal(tc.Since) || !opts.Until.Equal(tc.Until) {
+			t.Errorf("Test %d: got since %v until %v want %v and %v", i, opts.Since, opts.Until, tc.Since, tc.Until)
+		}
+	}
+
+	// Relative dates are relative to now.
+	flags := flag.NewFlagSet("rev-list", flag.ContinueOnError)
+	var opts git.RevListOptions
+	finish := addRevListFlags(flags, &opts)
+	if err := flags.Parse([]string{"--since=2 weeks ago"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := finish(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(opts.Since) - 14*24*time.Hour; d < -time.Hour || d > time.Hour {
+		t.Errorf("Unexpected --since for 2 weeks ago: %v", opts.Since)
+	}
+}
+
 func BenchmarkRevListHead(b *testing.B) {
 	c, err := git.NewClient("../.git", ".")
 	if err != nil {
@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/driusan/dgit/git"
 )
@@ -12,14 +14,61 @@ func RevParse(c *git.Client, args []string) ([]git.ParsedRevision, git.RevParseO
 	// We need to manually parse flags, because they're context sensitive.
 	opts := git.RevParseOptions{}
 
+	if len(args) > 0 && args[0] == "--parseopt" {
+		opts.ParseOpt = true
+		return nil, opts, revParseParseOpt(opts, args[1:])
+	}
+	if c == nil || c.GitDir == "" {
+		// Only --local-env-vars works outside of a repository, and
+		// its output is still printed if a later argument needs one.
+		n := 0
+		for n < len(args) && args[n] == "--local-env-vars" {
+			n++
+		}
+		commits, err := git.RevParse(c, opts, args[:n])
+		if err == nil && (n == 0 || n < len(args)) {
+			err = fmt.Errorf("fatal: not a git repository (or any of the parent directories): .git")
+		}
+		return commits, opts, err
+	}
+
 	var parsedargs []string
 	for i := 0; i < len(args); i++ {
-		switch args[i] {
-		case "--quiet", "-q":
+		switch arg := args[i]; {
+		case arg == "--quiet", arg == "-q":
 			opts.Quiet = true
-		case "--verify":
+		case arg == "--verify":
 			opts.Verify = true
-		case "--default":
+		case arg == "--symbolic":
+			opts.Symbolic = true
+		case arg == "--symbolic-full-name":
+			opts.SymbolicFullName = true
+		case arg == "--abbrev-ref":
+			opts.AbbrefRev = "strict"
+		case strings.HasPrefix(arg, "--abbrev-ref="):
+			opts.AbbrefRev = strings.TrimPrefix(arg, "--abbrev-ref=")
+			if opts.AbbrefRev != "strict" && opts.AbbrefRev != "loose" {
+				return nil, opts, fmt.Errorf("fatal: unknown mode for --abbrev-ref: %v", opts.AbbrefRev)
+			}
+		case arg == "--short", strings.HasPrefix(arg, "--short="):
+			opts.Verify = true
+			opts.Short = 7
+			if abbrev, err := strconv.Atoi(c.GetConfig("core.abbrev")); err == nil {
+				opts.Short = uint(abbrev)
+			}
+			if arg != "--short" {
+				n, err := strconv.Atoi(strings.TrimPrefix(arg, "--short="))
+				if err != nil {
+					return nil, opts, fmt.Errorf("fatal: --short requires a number")
+				}
+				opts.Short = uint(n)
+			}
+			if opts.Short < 4 {
+				opts.Short = 4
+			} else if opts.Short > 40 {
+				opts.Short = 40
+			}
+		case arg == "--default":
 			switch i {
 			case len(args) - 1:
 				return nil, opts, fmt.Errorf("Must provide parameter for --default")
@@ -27,7 +76,7 @@ func RevParse(c *git.Client, args []string) ([]git.ParsedRevision, git.RevParseO
 				opts.Default = args[i+1]
 				i++
 			}
-		case "--help":
+		case arg == "--help":
 			flag.Usage()
 			os.Exit(0)
 		default:
@@ -38,3 +87,36 @@ func RevParse(c *git.Client, args []string) ([]git.ParsedRevision, git.RevParseO
 	commits, err := git.RevParse(c, opts, parsedargs)
 	return commits, opts, err
 }
+
+// Handles "rev-parse --parseopt [options] -- [<args>...]", which reads the
+// option spec from stdin.
+func revParseParseOpt(opts git.RevParseOptions, args []string) error {
+	for len(args) > 0 && args[0] != "--" {
+		switch args[0] {
+		case "--keep-dashdash":
+			opts.KeepDashDash = true
+		case "--stop-at-non-option":
+			opts.StopAtNonOption = true
+		case "--stuck-long":
+			opts.StuckLong = true
+		default:
+			return revParseParseOptUsage()
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return revParseParseOptUsage()
+	}
+	return git.RevParseParseOpt(opts, os.Stdin, args[1:], os.Stdout, os.Stderr)
+}
+
+func revParseParseOptUsage() error {
+	fmt.Fprint(os.Stderr, `usage: git rev-parse --parseopt [<options>] -- [<args>...]
+
+    --keep-dashdash       keep the `+"`--`"+` passed as an arg
+    --stop-at-non-option  stop parsing after the first non-option argument
+    --stuck-long          output in stuck long form
+
+`)
+	return git.UsageShown
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestRevParseOutsideRepository(t *testing.T) {
+	revs, _, err := RevParse(nil, []string{"--local-env-vars"})
+	if err != nil {
+		t.Fatalf("Unexpected error for --local-env-vars: %v", err)
+	}
+	if len(revs) == 0 || revs[0].Name != "GIT_ALTERNATE_OBJECT_DIRECTORIES" || !revs[0].NotRevision {
+		t.Errorf("Unexpected output for --local-env-vars: %v", revs)
+	}
+
+	// Anything else needs a repository, but the variables are still
+	// output.
+	n := len(revs)
+	revs, _, err = RevParse(nil, []string{"--local-env-vars", "--git-dir"})
+	if err == nil {
+		t.Errorf("Expected an error for --git-dir outside of a repository")
+	}
+	if len(revs) != n {
+		t.Errorf("Unexpected output before error: got %d lines want %d", len(revs), n)
+	}
+	if _, _, err := RevParse(nil, nil); err == nil {
+		t.Errorf("Expected an error without arguments outside of a repository")
+	}
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func Shortlog(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("shortlog", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	var opts git.ShortlogOptions
+	flags.BoolVar(&opts.Numbered, "numbered", false, "sort the output by the number of commits per author")
+	flags.BoolVar(&opts.Numbered, "n", false, "alias of --numbered")
+	flags.BoolVar(&opts.Summary, "summary", false, "only show the number of commits per author")
+	flags.BoolVar(&opts.Summary, "s", false, "alias of --summary")
+	flags.BoolVar(&opts.Email, "email", false, "show the email address of each author")
+	flags.BoolVar(&opts.Email, "e", false, "alias of --email")
+	// --committer is converted to -c by shortlogAdjustArgs, since
+	// --committer=<pattern> limits the commits as it does for log.
+	committer := flags.Bool("c", false, "group the commits by committer, rather than author")
+	flags.Var(NewMultiStringValue(&opts.Groups), "group", "group the commits by author, committer or trailer:<key>")
+	maxCount := -1
+	flags.IntVar(&maxCount, "max-count", -1, "Limit the number of commits.")
+	revOpts := git.RevListOptions{Quiet: true}
+	finish := addRevListFlags(flags, &revOpts)
+
+	args, paths := revListAdjustArgs(shortlogAdjustArgs(args))
+	flags.Parse(args)
+	if err := finish(); err != nil {
+		return err
+	}
+	if *committer {
+		opts.Groups = append(opts.Groups, "committer")
+	}
+	if maxCount >= 0 {
+		mc := uint(maxCount)
+		revOpts.MaxCount = &mc
+	}
+
+	shortlog, err := git.NewShortlog(c, opts)
+	if err != nil {
+		return err
+	}
+
+	// If there are no revisions, the output of log is read from stdin,
+	// unless it's a terminal.
+	def := ""
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		def = "HEAD"
+	}
+	includes, excludes, err := revListParseArgs(c, &revOpts, flags.Args(), paths, def)
+	if err != nil {
+		return err
+	}
+	if len(includes)+len(excludes) == 0 && !revOpts.All && len(revOpts.Branches)+len(revOpts.Tags)+len(revOpts.Remotes) == 0 {
+		if err := shortlog.ReadLog(os.Stdin); err != nil {
+			return err
+		}
+		return shortlog.Write(os.Stdout)
+	}
+	if err := git.RevListCallback(c, revOpts, includes, excludes, func(id git.Sha1) error {
+		return shortlog.AddCommit(c, git.CommitID(id))
+	}); err != nil {
+		return err
+	}
+	return shortlog.Write(os.Stdout)
+}
+
+// Converts arguments of the form -<n> to --max-count=<n>, since -n is
+// --numbered for shortlog rather than a limit on the number of commits,
+// and --committer without a pattern to -c.
+func shortlogAdjustArgs(args []string) []string {
+	var adjusted []string
+	for i, a := range args {
+		switch {
+		case a == "--":
+			return append(adjusted, args[i:]...)
+		case a == "--committer":
+			adjusted = append(adjusted, "-c")
+		case len(a) > 1 && a[0] == '-' && a[1] >= '0' && a[1] <= '9':
+			adjusted = append(adjusted, "--max-count="+a[1:])
+		default:
+			adjusted = append(adjusted, a)
+		}
+	}
+	return adjusted
+}
@@ -17,9 +17,18 @@ func Show(c *git.Client, args []string) error {
 	}
 
 	opts := git.ShowOptions{}
-	flags.Var(newAliasedStringValue((*string)(&opts.Format), ""), "format", "Print the contents of commit logs in a specified format")
-	flags.Var(newAliasedStringValue((*string)(&opts.Format), ""), "pretty", "Alias for --format")
-	flags.Parse(args)
+	finish := addPrettyFlags(flags, &opts.PrettyOptions)
+	finishDiff := addLogDiffFlags(c, flags, &opts.LogDiffOptions, true)
+	flags.Parse(logAdjustArgs(args))
+	if err := finish(c); err != nil {
+		return err
+	}
+	if err := finishDiff(opts.PrettyOptions.Color, false); err != nil {
+		return err
+	}
+	if opts.LogDiffOptions.Abbrev = opts.PrettyOptions.Abbrev; opts.LogDiffOptions.Abbrev <= 0 {
+		opts.LogDiffOptions.Abbrev = 7
+	}
 
 	objects := flags.Args()
 	return git.Show(c, opts, objects)
@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/driusan/dgit/git"
+)
+
+func UpdateServerInfo(c *git.Client, args []string) error {
+	flags := newFlagSet("update-server-info")
+	opts := git.UpdateServerInfoOptions{}
+	flags.BoolVar(&opts.Force, "force", false, "Update the info files from scratch")
+	flags.BoolVar(&opts.Force, "f", false, "Alias of --force")
+	flags.Parse(args)
+
+	return git.UpdateServerInfo(c, opts)
+}
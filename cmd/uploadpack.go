@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func UploadPack(args []string) error {
+	flags := newFlagSet("upload-pack")
+	opts := git.UploadPackOptions{}
+	flags.BoolVar(&opts.StatelessRPC, "stateless-rpc", false, "Perform only a single read-write cycle with stdin and stdout")
+	flags.BoolVar(&opts.AdvertiseRefs, "advertise-refs", false, "Only advertise refs and exit")
+	flags.BoolVar(&opts.AdvertiseRefs, "http-backend-info-refs", false, "Alias of --advertise-refs")
+	strict := flags.Bool("strict", false, "Do not try <directory>/.git/ if <directory> is not a git directory")
+	flags.Int("timeout", 0, "Interrupt the transfer after <n> seconds of inactivity (not implemented)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	c, err := git.EnterRepo(flags.Arg(0), *strict)
+	if err != nil {
+		return fmt.Errorf("fatal: '%s' does not appear to be a git repository", flags.Arg(0))
+	}
+	opts.GitProtocol = os.Getenv("GIT_PROTOCOL")
+	return git.UploadPack(c, opts, os.Stdin, os.Stdout)
+}
@@ -0,0 +1,1283 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// BisectTerms are the names of the states of the commits being bisected.
+// Bad is the state of the commit being looked for and its descendants, and
+// Good is the state of the commits before it.
+type BisectTerms struct {
+	Bad, Good string
+}
+
+// A BisectError is an error which stops bisecting, with the status that
+// git bisect exits with for it.
+type BisectError struct {
+	msg    string
+	Status int
+}
+
+func (e BisectError) Error() string {
+	return e.msg
+}
+
+// The results of bisecting which aren't errors.
+type bisectResult int
+
+const (
+	// A commit to test was checked out.
+	bisectTesting bisectResult = iota
+	// Both a good and bad commit are needed before a commit to test
+	// can be chosen.
+	bisectWaiting
+	// The first bad commit was found.
+	bisectFound
+)
+
+// The files in the git dir which hold the state of a bisection, other
+// than BISECT_START which is removed last.
+var bisectStateFiles = []File{
+	"BISECT_EXPECTED_REV",
+	"BISECT_ANCESTORS_OK",
+	"BISECT_LOG",
+	"BISECT_TERMS",
+	"BISECT_NAMES",
+	"BISECT_RUN",
+	"BISECT_FIRST_PARENT",
+	"BISECT_HEAD",
+}
+
+// The subcommands of bisect, which can't be used as terms.
+var bisectCommands = []string{"help", "start", "skip", "next", "reset", "visualize", "view", "replay", "log", "run", "terms"}
+
+// The state of a bisection, read from the git dir.
+type bisect struct {
+	c *Client
+
+	terms    BisectTerms
+	hasTerms bool
+
+	bad        *CommitID
+	good, skip []CommitID
+
+	// Whether only the first parents of merges are followed.
+	firstParent bool
+}
+
+func readBisect(c *Client) (*bisect, error) {
+	b := &bisect{c: c, terms: BisectTerms{"bad", "good"}}
+	if data, err := c.GitDir.ReadFile("BISECT_TERMS"); err == nil {
+		lines := strings.Split(string(data), "\n")
+		if len(lines) >= 2 && lines[0] != "" && lines[1] != "" {
+			b.terms = BisectTerms{lines[0], lines[1]}
+			b.hasTerms = true
+		}
+	}
+	err := ForEachRefCallback(c, "refs/bisect/", func(c *Client, r Ref) error {
+		name := strings.TrimPrefix(r.Name, "refs/bisect/")
+		id, err := r.CommitID(c)
+		if err != nil {
+			return err
+		}
+		switch {
+		case name == b.terms.Bad:
+			b.bad = &id
+		case strings.HasPrefix(name, b.terms.Good+"-"):
+			b.good = append(b.good, id)
+		case strings.HasPrefix(name, "skip-"):
+			b.skip = append(b.skip, id)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Returns true if a bisection has been started.
+func isBisecting(c *Client) bool {
+	start, err := c.GitDir.ReadFile("BISECT_START")
+	return err == nil && len(start) > 0
+}
+
+// Returns an error if term can't be used as the term for orig, which is
+// "bad" or "good".
+func checkBisectTerm(term, orig string) error {
+	if !validRefName("refs/bisect/" + term) {
+		return fmt.Errorf("error: '%s' is not a valid term", term)
+	}
+	for _, cmd := range bisectCommands {
+		if term == cmd {
+			return fmt.Errorf("error: can't use the builtin command '%s' as a term", term)
+		}
+	}
+	if orig != "bad" && (term == "bad" || term == "new") || orig != "good" && (term == "good" || term == "old") {
+		return fmt.Errorf("error: can't change the meaning of the term '%s'", term)
+	}
+	return nil
+}
+
+func (b *bisect) writeTerms(terms BisectTerms) error {
+	if terms.Bad == terms.Good {
+		return fmt.Errorf("error: please use two different terms")
+	}
+	if err := checkBisectTerm(terms.Bad, "bad"); err != nil {
+		return err
+	}
+	if err := checkBisectTerm(terms.Good, "good"); err != nil {
+		return err
+	}
+	b.terms, b.hasTerms = terms, true
+	return b.c.GitDir.WriteFile("BISECT_TERMS", []byte(terms.Bad+"\n"+terms.Good+"\n"), 0644)
+}
+
+// Checks that cmd can be used in the current bisection, choosing the
+// terms from it if they haven't been yet.
+func (b *bisect) checkAndSetTerms(cmd string) error {
+	switch cmd {
+	case "skip", "start", "terms":
+		return nil
+	}
+	if b.hasTerms {
+		if cmd != b.terms.Bad && cmd != b.terms.Good {
+			return fmt.Errorf("error: Invalid command: you're currently in a %s/%s bisect", b.terms.Bad, b.terms.Good)
+		}
+		return nil
+	}
+	switch cmd {
+	case "bad", "good":
+		return b.writeTerms(BisectTerms{"bad", "good"})
+	case "new", "old":
+		return b.writeTerms(BisectTerms{"new", "old"})
+	}
+	return nil
+}
+
+func (b *bisect) appendLog(format string, args ...interface{}) error {
+	f, err := os.OpenFile(b.c.GitDir.File("BISECT_LOG").String(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, format, args...)
+	return err
+}
+
+// Returns the subject of the commit id, for the bisect log.
+func (b *bisect) subject(id CommitID) (string, error) {
+	cm, err := parsePrettyCommit(b.c, id)
+	if err != nil {
+		return "", err
+	}
+	subject, _ := formatSubject(skipBlankLines(cm.Message), " ")
+	return subject, nil
+}
+
+// Marks id with state, which is one of the terms or skip, and logs it.
+// Unless nolog is set, the command which marked it is also logged so that
+// it can be replayed.
+func (b *bisect) write(state string, id CommitID, nolog bool) error {
+	var ref RefSpec
+	switch state {
+	case b.terms.Bad:
+		ref = RefSpec("refs/bisect/" + state)
+		b.bad = &id
+	case b.terms.Good:
+		ref = RefSpec(fmt.Sprintf("refs/bisect/%s-%s", state, id))
+		b.good = append(b.good, id)
+	case "skip":
+		ref = RefSpec(fmt.Sprintf("refs/bisect/%s-%s", state, id))
+		b.skip = append(b.skip, id)
+	default:
+		return fmt.Errorf("error: Bad bisect_write argument: %s", state)
+	}
+	if err := UpdateRefSpec(b.c, UpdateRefOptions{}, ref, id, ""); err != nil {
+		return err
+	}
+	subject, err := b.subject(id)
+	if err != nil {
+		return err
+	}
+	if err := b.appendLog("# %s: [%s] %s\n", state, id, subject); err != nil {
+		return err
+	}
+	if !nolog {
+		return b.appendLog("git bisect %s %s\n", state, id)
+	}
+	return nil
+}
+
+// Removes all of the state of the bisection.
+func cleanBisectState(c *Client) error {
+	if err := os.RemoveAll(c.GitDir.File("refs/bisect").String()); err != nil {
+		return err
+	}
+	for _, name := range bisectStateFiles {
+		if f := c.GitDir.File(name); f.Exists() {
+			if err := f.Remove(); err != nil {
+				return err
+			}
+		}
+	}
+	if f := c.GitDir.File("BISECT_START"); f.Exists() {
+		return f.Remove()
+	}
+	return nil
+}
+
+// BisectStart implements "git bisect start". args are the arguments to
+// it, which are logged so that the start can be replayed: options, then
+// a bad commit and any number of good commits, then the paths to limit
+// the bisection to, optionally after "--".
+func BisectStart(c *Client, args []string, w io.Writer) error {
+	b := &bisect{c: c, terms: BisectTerms{"bad", "good"}}
+	var noCheckout, firstParent, mustWriteTerms bool
+	hasDoubleDash := false
+	for _, arg := range args {
+		if arg == "--" {
+			hasDoubleDash = true
+			break
+		}
+	}
+	var revs []CommitID
+	i := 0
+args:
+	for ; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			break args
+		case arg == "--no-checkout":
+			noCheckout = true
+		case arg == "--first-parent":
+			firstParent = true
+		case arg == "--term-good" || arg == "--term-old" || arg == "--term-bad" || arg == "--term-new":
+			i++
+			if i >= len(args) {
+				return fmt.Errorf("error: '' is not a valid term")
+			}
+			if arg == "--term-good" || arg == "--term-old" {
+				b.terms.Good = args[i]
+			} else {
+				b.terms.Bad = args[i]
+			}
+			mustWriteTerms = true
+		case strings.HasPrefix(arg, "--term-good=") || strings.HasPrefix(arg, "--term-old="):
+			b.terms.Good = arg[strings.IndexByte(arg, '=')+1:]
+			mustWriteTerms = true
+		case strings.HasPrefix(arg, "--term-bad=") || strings.HasPrefix(arg, "--term-new="):
+			b.terms.Bad = arg[strings.IndexByte(arg, '=')+1:]
+			mustWriteTerms = true
+		case strings.HasPrefix(arg, "--"):
+			return fmt.Errorf("error: unrecognized option: '%s'", arg)
+		default:
+			id, err := RevParseCommit(c, &RevParseOptions{}, arg)
+			if err != nil {
+				if hasDoubleDash {
+					return fmt.Errorf("fatal: '%s' does not appear to be a valid revision", arg)
+				}
+				break args
+			}
+			revs = append(revs, id)
+		}
+	}
+	paths := args[i:]
+	if len(revs) > 0 {
+		mustWriteTerms = true
+	}
+
+	var start string
+	if isBisecting(c) {
+		// Go back to where the last bisection started from.
+		data, err := c.GitDir.ReadFile("BISECT_START")
+		if err != nil {
+			return err
+		}
+		start = strings.TrimSpace(string(data))
+		if !noCheckout {
+			if err := Checkout(c, CheckoutOptions{}, start, nil); err != nil {
+				return fmt.Errorf("error: checking out '%s' failed. Try 'git bisect start <valid-branch>'.", start)
+			}
+		}
+	} else {
+		head, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD")
+		switch err {
+		case nil:
+			if !strings.HasPrefix(string(head), "refs/heads/") {
+				return fmt.Errorf("error: bad HEAD - strange symbolic ref")
+			}
+			start = strings.TrimPrefix(string(head), "refs/heads/")
+		case DetachedHead:
+			id, err := c.GetHeadCommit()
+			if err != nil {
+				return err
+			}
+			start = id.String()
+		default:
+			return fmt.Errorf("error: bad HEAD - I need a HEAD")
+		}
+	}
+
+	if err := cleanBisectState(c); err != nil {
+		return err
+	}
+	if err := c.GitDir.WriteFile("BISECT_START", []byte(start+"\n"), 0644); err != nil {
+		return err
+	}
+	if noCheckout {
+		id, err := RevParseCommit(c, &RevParseOptions{}, start)
+		if err != nil {
+			cleanBisectState(c)
+			return fmt.Errorf("error: invalid ref: '%s'", start)
+		}
+		if err := c.GitDir.WriteFile("BISECT_HEAD", []byte(id.String()+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	var names string
+	// git only records the paths if there's more than one argument
+	// left, which is normally "--" followed by at least one path.
+	if len(paths) > 1 {
+		names = bisectQuoteArgs(paths)
+	}
+	if err := c.GitDir.WriteFile("BISECT_NAMES", []byte(names+"\n"), 0644); err != nil {
+		return err
+	}
+	if firstParent {
+		if err := c.GitDir.WriteFile("BISECT_FIRST_PARENT", []byte("\n"), 0644); err != nil {
+			return err
+		}
+	}
+	if mustWriteTerms {
+		if err := b.writeTerms(b.terms); err != nil {
+			cleanBisectState(c)
+			return err
+		}
+	}
+	for i, id := range revs {
+		state := b.terms.Good
+		if i == 0 {
+			state = b.terms.Bad
+		}
+		if err := b.write(state, id, true); err != nil {
+			cleanBisectState(c)
+			return err
+		}
+	}
+	if err := b.appendLog("git bisect start%s\n", bisectQuoteArgs(args)); err != nil {
+		return err
+	}
+	if _, err := b.autoNext(w); err != nil {
+		if _, ok := err.(BisectError); !ok {
+			cleanBisectState(c)
+		}
+		return err
+	}
+	return nil
+}
+
+// Quotes args for the shell, each with a leading space.
+func bisectQuoteArgs(args []string) string {
+	var s strings.Builder
+	for _, arg := range args {
+		s.WriteString(" " + shellQuote(arg))
+	}
+	return s.String()
+}
+
+// Splits s into the arguments quoted by bisectQuoteArgs.
+func bisectDequoteArgs(s string) ([]string, error) {
+	var args []string
+	s = strings.TrimSpace(s)
+	for s != "" {
+		if s[0] != '\'' {
+			return nil, fmt.Errorf("badly quoted arguments: %s", s)
+		}
+		var arg strings.Builder
+		for {
+			end := strings.IndexByte(s[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("badly quoted arguments: %s", s)
+			}
+			arg.WriteString(s[1 : end+1])
+			s = s[end+2:]
+			// An escaped quote or exclamation mark continues the
+			// argument.
+			if len(s) >= 4 && s[0] == '\\' && (s[1] == '\'' || s[1] == '!') && s[2] == '\'' {
+				arg.WriteByte(s[1])
+				s = s[2:]
+				continue
+			}
+			break
+		}
+		args = append(args, arg.String())
+		s = strings.TrimLeft(s, " ")
+	}
+	return args, nil
+}
+
+// BisectState implements "git bisect <state> [<rev>...]", where state is
+// one of the terms or "skip". If no revisions are given, the current
+// commit is marked.
+func BisectState(c *Client, state string, revs []string, w io.Writer) error {
+	b, err := readBisect(c)
+	if err != nil {
+		return err
+	}
+	if !isBisecting(c) {
+		return fmt.Errorf("You need to start by \"git bisect start\"")
+	}
+	if err := b.checkAndSetTerms(state); err != nil {
+		return err
+	}
+	if state != b.terms.Bad && state != b.terms.Good && state != "skip" {
+		return fmt.Errorf("error: unknown command: '%s'", state)
+	}
+	if len(revs) > 1 && state == b.terms.Bad {
+		return fmt.Errorf("error: 'git bisect %s' can take only one argument.", b.terms.Bad)
+	}
+
+	var ids []CommitID
+	if len(revs) == 0 {
+		id, err := bisectHead(c)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	for _, rev := range revs {
+		if state == "skip" && strings.Contains(rev, "..") {
+			// Skip all of the commits in a range.
+			parsed, err := RevParse(c, RevParseOptions{}, []string{rev})
+			if err != nil {
+				return err
+			}
+			var includes, excludes []Commitish
+			for _, p := range parsed {
+				if p.Excluded {
+					excludes = append(excludes, p)
+				} else {
+					includes = append(includes, p)
+				}
+			}
+			err = RevListCallback(c, RevListOptions{Quiet: true}, includes, excludes, func(s Sha1) error {
+				ids = append(ids, CommitID(s))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		id, err := RevParseCommit(c, &RevParseOptions{}, rev)
+		if err != nil {
+			return fmt.Errorf("error: Bad rev input: %s", rev)
+		}
+		ids = append(ids, id)
+	}
+
+	expected, _ := c.GitDir.ReadFile("BISECT_EXPECTED_REV")
+	for _, id := range ids {
+		if err := b.write(state, id, false); err != nil {
+			return err
+		}
+		// Marking a commit other than the one that was checked out
+		// means that the goods may no longer be ancestors of the
+		// bad commit.
+		if len(expected) > 0 && strings.TrimSpace(string(expected)) != id.String() {
+			c.GitDir.File("BISECT_ANCESTORS_OK").Remove()
+			c.GitDir.File("BISECT_EXPECTED_REV").Remove()
+			expected = nil
+		}
+	}
+	_, err = b.autoNext(w)
+	return err
+}
+
+// Returns the commit being tested, which is BISECT_HEAD when bisecting
+// without checking commits out.
+func bisectHead(c *Client) (CommitID, error) {
+	if data, err := c.GitDir.ReadFile("BISECT_HEAD"); err == nil {
+		return CommitIDFromString(strings.TrimSpace(string(data)))
+	}
+	id, err := c.GetHeadCommit()
+	if err != nil {
+		return CommitID{}, fmt.Errorf("error: Bad rev input: HEAD")
+	}
+	return id, nil
+}
+
+// Prints the status if a good and bad commit aren't both known yet, or
+// chooses the next commit to test.
+func (b *bisect) autoNext(w io.Writer) (bisectResult, error) {
+	if b.bad == nil || len(b.good) == 0 {
+		// git uses the default terms here, whatever the terms are.
+		var status string
+		switch {
+		case b.bad == nil && len(b.good) == 0:
+			status = "status: waiting for both good and bad commits"
+		case len(b.good) == 0:
+			status = "status: waiting for good commit(s), bad commit known"
+		case len(b.good) == 1:
+			status = "status: waiting for bad commit, 1 good commit known"
+		default:
+			status = fmt.Sprintf("status: waiting for bad commit, %d good commits known", len(b.good))
+		}
+		fmt.Fprintln(w, status)
+		return bisectWaiting, b.appendLog("# %s\n", status)
+	}
+	return b.next(w)
+}
+
+// BisectNext implements "git bisect next", which checks out the next
+// commit to test.
+func BisectNext(c *Client, w io.Writer) error {
+	b, err := readBisect(c)
+	if err != nil {
+		return err
+	}
+	if !isBisecting(c) {
+		return fmt.Errorf("You need to start by \"git bisect start\"")
+	}
+	if b.bad == nil || len(b.good) == 0 {
+		return fmt.Errorf("error: You need to give me at least one %s and %s revision.\nYou can use \"git bisect %s\" and \"git bisect %s\" for that.", b.terms.Bad, b.terms.Good, b.terms.Bad, b.terms.Good)
+	}
+	_, err = b.next(w)
+	return err
+}
+
+// Chooses the next commit to test and checks it out, or prints the first
+// bad commit if it's been found.
+func (b *bisect) next(w io.Writer) (bisectResult, error) {
+	c := b.c
+	noCheckout := c.GitDir.File("BISECT_HEAD").Exists()
+	if res, err := b.checkGoodAreAncestorsOfBad(w, noCheckout); err != nil || res == bisectTesting {
+		return res, err
+	}
+
+	list, err := b.candidates()
+	if err != nil {
+		return bisectTesting, err
+	}
+	best, reaches, all, err := b.findBisection(list)
+	if err != nil {
+		return bisectTesting, err
+	}
+	best, tried := b.skipAway(best)
+
+	if len(best) == 0 {
+		if err := b.errorIfSkipped(w, tried, nil); err != nil {
+			return bisectTesting, err
+		}
+		fmt.Fprintf(w, "%s was both %s and %s\n", *b.bad, b.terms.Good, b.terms.Bad)
+		return bisectTesting, BisectError{"", 1}
+	}
+	if all == 0 {
+		return bisectTesting, BisectError{"No testable commit found.\nMaybe you started with bad path arguments?", 4}
+	}
+	if rev := best[0].Id; rev == *b.bad {
+		if err := b.errorIfSkipped(w, tried, b.bad); err != nil {
+			return bisectTesting, err
+		}
+		fmt.Fprintf(w, "%s is the first %s commit\n", rev, b.terms.Bad)
+		printer, err := NewPrettyPrinter(c, PrettyOptions{})
+		if err != nil {
+			return bisectTesting, err
+		}
+		opts := LogDiffOptions{DiffCommonOptions: DiffRenameOptions(c), Merges: "first-parent"}
+		opts.Stat, opts.Summary = true, true
+		if _, err := printer.PrintWithDiff(w, rev, "", opts); err != nil {
+			return bisectTesting, err
+		}
+		subject, err := b.subject(rev)
+		if err != nil {
+			return bisectTesting, err
+		}
+		return bisectFound, b.appendLog("# first %s commit: [%s] %s\n", b.terms.Bad, rev, subject)
+	}
+
+	left := all - reaches - 1
+	revisions := "revisions"
+	if left == 1 {
+		revisions = "revision"
+	}
+	steps := estimateBisectSteps(all)
+	stepsMsg := fmt.Sprintf("(roughly %d steps)", steps)
+	if steps == 1 {
+		stepsMsg = "(roughly 1 step)"
+	}
+	fmt.Fprintf(w, "Bisecting: %d %s left to test after this %s\n", left, revisions, stepsMsg)
+	return bisectTesting, b.checkout(w, best[0].Id, noCheckout)
+}
+
+// Returns the number of steps that bisecting all commits is expected to
+// take.
+func estimateBisectSteps(all int) int {
+	if all < 3 {
+		return 0
+	}
+	n := 0
+	for 1<<uint(n+1) <= all {
+		n++
+	}
+	e := 1 << uint(n)
+	if x := all - e; e < 3*x {
+		return n
+	}
+	return n - 1
+}
+
+// Checks out the commit id to be tested, or only points BISECT_HEAD at it
+// if noCheckout is set.
+func (b *bisect) checkout(w io.Writer, id CommitID, noCheckout bool) error {
+	c := b.c
+	if err := c.GitDir.WriteFile("BISECT_EXPECTED_REV", []byte(id.String()+"\n"), 0644); err != nil {
+		return err
+	}
+	if noCheckout {
+		if err := c.GitDir.WriteFile("BISECT_HEAD", []byte(id.String()+"\n"), 0644); err != nil {
+			return err
+		}
+	} else if err := CheckoutCommit(c, CheckoutOptions{}, id); err != nil {
+		return err
+	}
+	subject, err := b.subject(id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "[%s] %s\n", id, subject)
+	return nil
+}
+
+// If the commits tried when skipping away from the skipped commits aren't
+// empty, prints that the first bad commit is one of them or bad, and
+// returns an error because bisecting can't continue.
+func (b *bisect) errorIfSkipped(w io.Writer, tried []*revWalkCommit, bad *CommitID) error {
+	if len(tried) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "There are only 'skip'ped commits left to test.\nThe first %s commit could be any of:\n", b.terms.Bad)
+	for _, cm := range tried {
+		fmt.Fprintln(w, cm.Id)
+	}
+	if bad != nil {
+		fmt.Fprintln(w, *bad)
+	}
+
+	// Log all of the commits which may be the first bad commit.
+	if err := b.appendLog("# only skipped commits left to test\n"); err != nil {
+		return err
+	}
+	var excludes []Commitish
+	for _, good := range b.good {
+		excludes = append(excludes, good)
+	}
+	err := RevListCallback(b.c, RevListOptions{Quiet: true}, []Commitish{*b.bad}, excludes, func(s Sha1) error {
+		subject, err := b.subject(CommitID(s))
+		if err != nil {
+			return err
+		}
+		return b.appendLog("# possible first %s commit: [%s] %s\n", b.terms.Bad, s, subject)
+	})
+	if err != nil {
+		return err
+	}
+	return BisectError{"We cannot bisect more!", 2}
+}
+
+// Checks that the good commits are ancestors of the bad commit. If they
+// aren't, the merge bases of the bad commit and good commits need to be
+// good for bisecting to find the first bad commit, so they're checked out
+// to be tested first and bisectTesting is returned.
+func (b *bisect) checkGoodAreAncestorsOfBad(w io.Writer, noCheckout bool) (bisectResult, error) {
+	c := b.c
+	ok := c.GitDir.File("BISECT_ANCESTORS_OK")
+	if ok.Exists() || len(b.good) == 0 {
+		return bisectWaiting, nil
+	}
+	badAncestors, err := b.bad.AncestorMap(c)
+	if err != nil {
+		return bisectTesting, err
+	}
+	var bases []CommitID
+	for _, good := range b.good {
+		if _, ok := badAncestors[good]; ok {
+			continue
+		}
+		mb, err := MergeBase(c, MergeBaseOptions{}, []Commitish{*b.bad, good})
+		if err != nil {
+			return bisectTesting, err
+		}
+		bases = append(bases, mb)
+	}
+	seen := make(map[CommitID]bool)
+	for _, mb := range bases {
+		if seen[mb] {
+			continue
+		}
+		seen[mb] = true
+		switch {
+		case mb == *b.bad:
+			return bisectTesting, b.badMergeBase()
+		case commitIn(mb, b.good):
+			continue
+		case commitIn(mb, b.skip):
+			fmt.Fprintf(os.Stderr, "warning: the merge base between %s and [%s] must be skipped.\nSo we cannot be sure the first %s commit is between %s and %s.\nWe continue anyway.\n", *b.bad, b.goodHex(), b.terms.Bad, mb, *b.bad)
+		default:
+			fmt.Fprintf(w, "Bisecting: a merge base must be tested\n")
+			return bisectTesting, b.checkout(w, mb, noCheckout)
+		}
+	}
+	return bisectWaiting, c.GitDir.WriteFile("BISECT_ANCESTORS_OK", nil, 0600)
+}
+
+func commitIn(id CommitID, ids []CommitID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bisect) goodHex() string {
+	var s []string
+	for _, g := range b.good {
+		s = append(s, g.String())
+	}
+	return strings.Join(s, " ")
+}
+
+// Returns the error for when the bad commit is the merge base of a good
+// commit and the bad one.
+func (b *bisect) badMergeBase() error {
+	expected, _ := b.c.GitDir.ReadFile("BISECT_EXPECTED_REV")
+	if strings.TrimSpace(string(expected)) != b.bad.String() {
+		return BisectError{fmt.Sprintf("Some %s revs are not ancestors of the %s rev.\ngit bisect cannot work properly in this case.\nMaybe you mistook %s and %s revs?", b.terms.Good, b.terms.Bad, b.terms.Good, b.terms.Bad), 1}
+	}
+	bad, good := b.bad.String(), b.goodHex()
+	switch b.terms {
+	case BisectTerms{"bad", "good"}:
+		return BisectError{fmt.Sprintf("The merge base %s is bad.\nThis means the bug has been fixed between %s and [%s].", bad, bad, good), 3}
+	case BisectTerms{"new", "old"}:
+		return BisectError{fmt.Sprintf("The merge base %s is new.\nThe property has changed between %s and [%s].", bad, bad, good), 3}
+	}
+	return BisectError{fmt.Sprintf("The merge base %s is %s.\nThis means the first '%s' commit is between %s and [%s].", bad, b.terms.Bad, b.terms.Good, bad, good), 3}
+}
+
+// Returns the paths that the bisection is limited to.
+func (b *bisect) paths() ([]IndexPath, error) {
+	data, err := b.c.GitDir.ReadFile("BISECT_NAMES")
+	if err != nil {
+		return nil, nil
+	}
+	names, err := bisectDequoteArgs(string(data))
+	if err != nil {
+		return nil, err
+	}
+	var paths []IndexPath
+	for _, name := range names {
+		if name == "--" {
+			continue
+		}
+		p, err := File(name).IndexPath(b.c)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// Returns the commits which may be the first bad commit: the ancestors of
+// the bad commit which aren't ancestors of good commits, oldest first.
+func (b *bisect) candidates() ([]*revWalkCommit, error) {
+	paths, err := b.paths()
+	if err != nil {
+		return nil, err
+	}
+	b.firstParent = b.c.GitDir.File("BISECT_FIRST_PARENT").Exists()
+	opt := RevListOptions{FirstParent: b.firstParent, Paths: paths}
+	w, err := newRevWalk(b.c, &opt)
+	if err != nil {
+		return nil, err
+	}
+	w.limited = true
+	if err := w.addStart(*b.bad, 0); err != nil {
+		return nil, err
+	}
+	for _, good := range b.good {
+		if err := w.addStart(good, revUninteresting); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.prepare(); err != nil {
+		return nil, err
+	}
+	var list []*revWalkCommit
+	for i := len(w.list) - 1; i >= 0; i-- {
+		if w.list[i].flags&revUninteresting == 0 {
+			list = append(list, w.list[i])
+		}
+	}
+	return list, nil
+}
+
+// Finds the commit in list which best bisects it: the one where the number
+// of commits in the list that are its ancestors is closest to half, found
+// in the same way as git so that the same commits are tested. If commits
+// are being skipped, all of the commits are returned ordered from best to
+// worst. reaches is the number of commits the best one reaches, and all is
+// the number of commits in the list which aren't TREESAME.
+func (b *bisect) findBisection(list []*revWalkCommit) (best []*revWalkCommit, reaches, all int, err error) {
+	findAll := len(b.skip) > 0
+	index := make(map[CommitID]int, len(list))
+	for i, cm := range list {
+		index[cm.Id] = i
+		if cm.flags&revTreeSame == 0 {
+			all++
+		}
+	}
+	if len(list) == 0 {
+		return nil, 0, all, nil
+	}
+	parents := func(cm *revWalkCommit) []int {
+		var ps []int
+		for _, p := range cm.Parents {
+			if i, ok := index[p]; ok {
+				ps = append(ps, i)
+			}
+			if b.firstParent {
+				break
+			}
+		}
+		return ps
+	}
+	treesame := func(i int) bool {
+		return list[i].flags&revTreeSame != 0
+	}
+	weights := make([]int, len(list))
+	halfway := func(i int) bool {
+		if treesame(i) {
+			return false
+		}
+		diff := 2*weights[i] - all
+		return diff >= -1 && diff <= 1
+	}
+
+	// Commits without parents in the list reach only themselves, and
+	// the rest reach one more than their parent unless they're merges.
+	// The number of commits that merges reach is counted from their
+	// ancestors.
+	counted := 0
+	for i, cm := range list {
+		switch len(parents(cm)) {
+		case 0:
+			if !treesame(i) {
+				weights[i] = 1
+				counted++
+			}
+		case 1:
+			weights[i] = -1
+		default:
+			weights[i] = -2
+		}
+	}
+	for i, cm := range list {
+		if weights[i] != -2 {
+			continue
+		}
+		ancestors, err := cm.Id.AncestorMap(b.c)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		n := 0
+		for id := range ancestors {
+			if j, ok := index[id]; ok && !treesame(j) {
+				n++
+			}
+		}
+		weights[i] = n
+		if !findAll && halfway(i) {
+			return []*revWalkCommit{cm}, n, all, nil
+		}
+		counted++
+	}
+	for counted < all {
+		progress := false
+		for i, cm := range list {
+			if weights[i] >= 0 {
+				continue
+			}
+			q := -1
+			for _, p := range parents(cm) {
+				if weights[p] >= 0 {
+					q = p
+					break
+				}
+			}
+			if q < 0 {
+				continue
+			}
+			progress = true
+			weights[i] = weights[q]
+			if !treesame(i) {
+				weights[i]++
+				counted++
+			}
+			if !findAll && halfway(i) {
+				return []*revWalkCommit{cm}, weights[i], all, nil
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+
+	distance := func(i int) int {
+		if d := all - weights[i]; d < weights[i] {
+			return d
+		}
+		return weights[i]
+	}
+	if !findAll {
+		bestIdx, bestDistance := 0, -1
+		for i := range list {
+			if treesame(i) {
+				continue
+			}
+			if d := distance(i); d > bestDistance {
+				bestIdx, bestDistance = i, d
+			}
+		}
+		return []*revWalkCommit{list[bestIdx]}, weights[bestIdx], all, nil
+	}
+
+	var sorted []int
+	for i := range list {
+		if !treesame(i) {
+			sorted = append(sorted, i)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		di, dj := distance(sorted[i]), distance(sorted[j])
+		if di != dj {
+			return di > dj
+		}
+		return list[sorted[i]].Id.String() < list[sorted[j]].Id.String()
+	})
+	if len(sorted) == 0 {
+		return nil, 0, all, nil
+	}
+	for _, i := range sorted {
+		best = append(best, list[i])
+	}
+	return best, weights[sorted[0]], all, nil
+}
+
+// If the best commit to test has been skipped, chooses another one from
+// the rest of best, away from the skipped commits, in the same pseudo
+// random way as git. tried is the skipped commits which were passed over.
+func (b *bisect) skipAway(best []*revWalkCommit) (filtered, tried []*revWalkCommit) {
+	if len(b.skip) == 0 || len(best) == 0 || !commitIn(best[0].Id, b.skip) {
+		return best, nil
+	}
+	for _, cm := range best {
+		if commitIn(cm.Id, b.skip) {
+			tried = append(tried, cm)
+		} else {
+			filtered = append(filtered, cm)
+		}
+	}
+	count := len(filtered)
+	prn := int((uint32(count)*1103515245 + 12345) / 65536 % 32768)
+	index := (count * prn / 32768) * sqrti(prn) / sqrti(32768)
+	for i, cm := range filtered {
+		if i == index {
+			if cm.Id != *b.bad {
+				return filtered[i:], tried
+			}
+			if i > 0 {
+				return filtered[i-1:], tried
+			}
+			return filtered, tried
+		}
+	}
+	return filtered, tried
+}
+
+// Returns the integer square root of val, calculated with the same
+// floating point approximation as git.
+func sqrti(val int) int {
+	if val == 0 {
+		return 0
+	}
+	x := float32(val)
+	for {
+		y := (x + float32(val)/x) / 2
+		d := y - x
+		if d < 0 {
+			d = -d
+		}
+		x = y
+		if d < 0.5 {
+			break
+		}
+	}
+	return int(x)
+}
+
+// BisectReset implements "git bisect reset", which ends the bisection and
+// checks out commit, or the branch that the bisection was started from if
+// commit is empty.
+func BisectReset(c *Client, commit string, w io.Writer) error {
+	if !isBisecting(c) {
+		fmt.Fprintln(w, "We are not bisecting.")
+		return nil
+	}
+	if !c.GitDir.File("BISECT_HEAD").Exists() {
+		if commit == "" {
+			data, err := c.GitDir.ReadFile("BISECT_START")
+			if err != nil {
+				return err
+			}
+			commit = strings.TrimSpace(string(data))
+		} else if _, err := RevParseCommit(c, &RevParseOptions{}, commit); err != nil {
+			return fmt.Errorf("error: '%s' is not a valid commit", commit)
+		}
+		if err := Checkout(c, CheckoutOptions{}, commit, nil); err != nil {
+			return fmt.Errorf("error: could not check out original HEAD '%s'. Try 'git bisect reset <commit>'.", commit)
+		}
+	}
+	return cleanBisectState(c)
+}
+
+// BisectLog implements "git bisect log".
+func BisectLog(c *Client, w io.Writer) error {
+	data, err := c.GitDir.ReadFile("BISECT_LOG")
+	if err != nil || len(data) == 0 {
+		return fmt.Errorf("error: We are not bisecting.")
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// BisectReplay implements "git bisect replay", which resets the bisection
+// and replays the commands in the log file filename.
+func BisectReplay(c *Client, filename string, w io.Writer) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil || len(data) == 0 {
+		return fmt.Errorf("error: cannot read file '%s' for replaying", filename)
+	}
+	if err := BisectReset(c, "", ioutil.Discard); err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimLeft(line, " \t")
+		var rest string
+		switch {
+		case strings.HasPrefix(line, "git bisect"):
+			rest = line[len("git bisect"):]
+		case strings.HasPrefix(line, "git-bisect"):
+			rest = line[len("git-bisect"):]
+		default:
+			continue
+		}
+		if rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+			continue
+		}
+		rest = strings.TrimLeft(rest, " \t")
+		cmd, arg := rest, ""
+		if i := strings.IndexAny(rest, " \t"); i >= 0 {
+			cmd, arg = rest[:i], strings.TrimLeft(rest[i:], " \t")
+		}
+
+		b, err := readBisect(c)
+		if err != nil {
+			return err
+		}
+		if err := b.checkAndSetTerms(cmd); err != nil {
+			return err
+		}
+		switch cmd {
+		case "start":
+			args, err := bisectDequoteArgs(arg)
+			if err != nil {
+				return err
+			}
+			if err := BisectStart(c, args, w); err != nil {
+				return err
+			}
+		case b.terms.Bad, b.terms.Good, "skip":
+			id, err := RevParseCommit(c, &RevParseOptions{}, arg)
+			if err != nil {
+				return err
+			}
+			if err := b.write(cmd, id, false); err != nil {
+				return err
+			}
+		case "terms":
+			args, err := bisectDequoteArgs(arg)
+			if err != nil {
+				return err
+			}
+			var opt string
+			if len(args) == 1 {
+				opt = args[0]
+			}
+			if err := BisectTermsPrint(c, opt, w); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("error: '%s'?? what are you talking about?", cmd)
+		}
+	}
+	b, err := readBisect(c)
+	if err != nil {
+		return err
+	}
+	_, err = b.autoNext(w)
+	return err
+}
+
+// BisectTermsPrint implements "git bisect terms". opt may be one of
+// --term-good, --term-old, --term-bad or --term-new to only print that
+// term, or empty to describe both.
+func BisectTermsPrint(c *Client, opt string, w io.Writer) error {
+	b, err := readBisect(c)
+	if err != nil {
+		return err
+	}
+	if !b.hasTerms {
+		return fmt.Errorf("error: no terms defined")
+	}
+	switch opt {
+	case "":
+		fmt.Fprintf(w, "Your current terms are %s for the old state\nand %s for the new state.\n", b.terms.Good, b.terms.Bad)
+	case "--term-good", "--term-old":
+		fmt.Fprintln(w, b.terms.Good)
+	case "--term-bad", "--term-new":
+		fmt.Fprintln(w, b.terms.Bad)
+	default:
+		return fmt.Errorf("error: invalid argument %s for 'git bisect terms'.\nSupported options are: --term-good|--term-old and --term-bad|--term-new.", opt)
+	}
+	return nil
+}
+
+// BisectRevisions returns the arguments which limit log to the commits
+// which may still be the first bad commit, for "git bisect visualize".
+func BisectRevisions(c *Client) ([]string, error) {
+	b, err := readBisect(c)
+	if err != nil {
+		return nil, err
+	}
+	if b.bad == nil || len(b.good) == 0 {
+		return nil, fmt.Errorf("error: You need to give me at least one %s and %s revision.\nYou can use \"git bisect %s\" and \"git bisect %s\" for that.", b.terms.Bad, b.terms.Good, b.terms.Bad, b.terms.Good)
+	}
+	args := []string{b.bad.String()}
+	for _, good := range b.good {
+		args = append(args, "^"+good.String())
+	}
+	args = append(args, "--")
+	if data, err := c.GitDir.ReadFile("BISECT_NAMES"); err == nil {
+		names, err := bisectDequoteArgs(string(data))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if name != "--" {
+				args = append(args, name)
+			}
+		}
+	}
+	return args, nil
+}
+
+// BisectRun implements "git bisect run", which runs cmd to test each
+// commit until the first bad one is found. If cmd exits with 0 the commit
+// is good, 125 it's skipped, and between 1 and 127 it's bad. Any other
+// status stops bisecting. A single argument is run by the shell, and more
+// are run as a command and its arguments.
+func BisectRun(c *Client, cmd []string, w io.Writer) error {
+	if len(cmd) == 0 {
+		return fmt.Errorf("error: bisect run failed: no command provided.")
+	}
+	b, err := readBisect(c)
+	if err != nil {
+		return err
+	}
+	if b.bad == nil || len(b.good) == 0 {
+		return fmt.Errorf("error: You need to give me at least one %s and %s revision.\nYou can use \"git bisect %s\" and \"git bisect %s\" for that.", b.terms.Bad, b.terms.Good, b.terms.Bad, b.terms.Good)
+	}
+	command := bisectQuoteArgs(cmd)
+	for {
+		fmt.Fprintf(w, "running %s\n", command)
+		var run *exec.Cmd
+		if len(cmd) == 1 {
+			run = exec.Command(shellCommand, "-c", cmd[0])
+		} else {
+			run = exec.Command(cmd[0], cmd[1:]...)
+		}
+		run.Stdin = os.Stdin
+		run.Stdout = w
+		run.Stderr = os.Stderr
+		status := 0
+		if err := run.Run(); err != nil {
+			exit, ok := err.(*exec.ExitError)
+			if !ok {
+				return fmt.Errorf("error: bisect run failed: %v", err)
+			}
+			status = exit.ExitCode()
+		}
+		if status < 0 || status >= 128 {
+			return BisectError{fmt.Sprintf("error: bisect run failed: exit code %d from '%s' is < 0 or >= 128", status, command), status}
+		}
+
+		var state string
+		switch status {
+		case 0:
+			state = b.terms.Good
+		case 125:
+			state = "skip"
+		default:
+			state = b.terms.Bad
+		}
+		id, err := bisectHead(c)
+		if err != nil {
+			return err
+		}
+		if err := b.write(state, id, false); err != nil {
+			return err
+		}
+		if expected, _ := c.GitDir.ReadFile("BISECT_EXPECTED_REV"); strings.TrimSpace(string(expected)) != id.String() {
+			c.GitDir.File("BISECT_ANCESTORS_OK").Remove()
+			c.GitDir.File("BISECT_EXPECTED_REV").Remove()
+		}
+
+		// The output of each step is also saved in BISECT_RUN.
+		var out bytes.Buffer
+		res, err := b.next(io.MultiWriter(w, &out))
+		if werr := c.GitDir.WriteFile("BISECT_RUN", out.Bytes(), 0644); werr != nil {
+			return werr
+		}
+		if err != nil {
+			if e, ok := err.(BisectError); ok && e.Status == 2 {
+				fmt.Fprintln(w, "bisect run cannot continue any more")
+			}
+			return err
+		}
+		if res == bisectFound {
+			fmt.Fprintln(w, "bisect found first bad commit")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEstimateBisectSteps(t *testing.T) {
+	tests := []struct {
+		All, Want int
+	}{
+		{1, 0},
+		{2, 0},
+		{3, 1},
+		{4, 1},
+		{6, 2},
+		{10, 2},
+		{16, 3},
+		{100, 6},
+	}
+	for _, tc := range tests {
+		if got := estimateBisectSteps(tc.All); got != tc.Want {
+			t.Errorf("estimateBisectSteps(%d): got %v want %v", tc.All, got, tc.Want)
+		}
+	}
+}
+
+func TestBisect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitbisect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(content string, time int, parents ...CommitID) CommitID {
+		blob, err := c.WriteObject("blob", []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := c.WriteObject("tree", append([]byte("100644 f\x00"), blob[:]...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "tree %v\n", tree)
+		for _, p := range parents {
+			fmt.Fprintf(&buf, "parent %v\n", p)
+		}
+		fmt.Fprintf(&buf, "author A <a@example.com> %d +0000\ncommitter A <a@example.com> %d +0000\n\n%v\n", time, time, content)
+		id, err := c.WriteObject("commit", buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return CommitID(id)
+	}
+	var commits []CommitID
+	for i := 0; i < 10; i++ {
+		var parents []CommitID
+		if i > 0 {
+			parents = append(parents, commits[i-1])
+		}
+		commits = append(commits, commit(fmt.Sprintf("c%d", i), 1600000000+i*60, parents...))
+	}
+	if err := c.GitDir.WriteFile("refs/heads/master", []byte(commits[9].String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const firstBad = 6
+
+	var buf bytes.Buffer
+	if err := BisectStart(c, []string{"--no-checkout", commits[9].String(), commits[0].String()}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("Bisecting: 4 revisions left to test after this (roughly 2 steps)\n[%v] c4\n", commits[4]); buf.String() != want {
+		t.Errorf("Unexpected start output: got %q want %q", buf.String(), want)
+	}
+	for steps := 0; !strings.Contains(buf.String(), "is the first bad commit"); steps++ {
+		if steps > 4 {
+			t.Fatalf("Bisection did not finish: %v", buf.String())
+		}
+		head, err := c.GitDir.ReadFile("BISECT_HEAD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := "bad"
+		for _, id := range commits[:firstBad] {
+			if strings.TrimSpace(string(head)) == id.String() {
+				state = "good"
+				break
+			}
+		}
+		buf.Reset()
+		if err := BisectState(c, state, nil, &buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := fmt.Sprintf("%v is the first bad commit\n", commits[firstBad]); !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("Unexpected result: got %q want prefix %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := BisectLog(c, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("# first bad commit: [%v] c%d\n", commits[firstBad], firstBad); !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("Unexpected log: got %q want suffix %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := BisectReset(c, "", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if isBisecting(c) {
+		t.Error("Still bisecting after reset")
+	}
+}
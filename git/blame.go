@@ -0,0 +1,1666 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlameOptions control how Blame attributes the lines of a file to the
+// commits which introduced them, and how the result is written.
+type BlameOptions struct {
+	// The commit to blame the file in. If nil, the file in the work tree
+	// is blamed, and lines which haven't been committed are attributed
+	// to a commit with the zero id.
+	Revision *CommitID
+
+	// Commits whose history isn't searched. Lines which came from them
+	// or their ancestors are boundary lines.
+	Excludes []CommitID
+
+	// Follow history forwards from Revision to ReverseEnd, attributing
+	// each line of the file in Revision to the last commit it existed
+	// in, rather than the first.
+	Reverse    bool
+	ReverseEnd CommitID
+
+	// Only follow the first parent of merge commits.
+	FirstParent bool
+
+	// Attribute lines to root commits normally, rather than treating
+	// them as boundary commits.
+	ShowRoot bool
+
+	// The ranges of lines to blame, in the format of the -L option.
+	// All of the lines are blamed if there are none.
+	Ranges []string
+
+	// Ignore whitespace when comparing lines.
+	IgnoreWhitespace bool
+
+	// Detect lines moved or copied within a file. Blocks of lines are
+	// only considered moves if they have more than MoveScore
+	// alphanumeric characters, or 20 if MoveScore is 0.
+	DetectMoves bool
+	MoveScore   int
+
+	// Detect lines moved or copied from other files, which are searched
+	// for blocks of lines with more than CopyScore alphanumeric
+	// characters, or 40 if CopyScore is 0. 1 looks in the files modified
+	// by the same commit, 2 also looks in every file when the file was
+	// created by the commit, and 3 always looks in every file. Detecting
+	// copies also detects moves within the file.
+	DetectCopies int
+	CopyScore    int
+
+	// Commits which are skipped over, attributing the lines which they
+	// changed to the similar lines in their parents where possible.
+	IgnoreRevs []CommitID
+
+	// Write the output in the machine readable porcelain format,
+	// repeating the information about each commit for each line if
+	// LinePorcelain is set.
+	Porcelain, LinePorcelain bool
+
+	// Write the output in the format of git annotate.
+	Annotate bool
+
+	// Show the filename each line came from even if it's the same for
+	// all of the lines, and the line number it had in that file.
+	ShowName, ShowNumber bool
+
+	// Show the author's email instead of their name, or don't show the
+	// author or date.
+	ShowEmail, NoAuthor bool
+
+	// Show full commit ids, or ids abbreviated to Abbrev digits. The
+	// default is the shortest length which makes the ids unique.
+	LongNames bool
+	Abbrev    int
+
+	// Show raw timestamps, or format the dates with Date instead of the
+	// default ISO 8601 format.
+	RawTimestamp bool
+	Date         DateMode
+
+	// Show blanks instead of the ids of boundary commits.
+	BlankBoundary bool
+
+	// Mark lines which couldn't be attributed to a parent of an ignored
+	// commit with "*", and lines which were attributed past one with
+	// "?".
+	MarkUnblamable, MarkIgnored bool
+}
+
+// Blame writes the commit which last changed each line of the file at
+// path to w, following the history of the file through renames.
+func Blame(c *Client, opts BlameOptions, path IndexPath, w io.Writer) error {
+	s, err := newBlameScoreboard(c, opts, path)
+	if err != nil {
+		return err
+	}
+	if err := s.assign(); err != nil {
+		return err
+	}
+	entries := s.sortedEntries()
+	if opts.Porcelain || opts.LinePorcelain {
+		return s.writePorcelain(w, entries)
+	}
+	return s.writeDefault(w, entries)
+}
+
+// A blameCommit is a commit which lines may be attributed to.
+type blameCommit struct {
+	id CommitID
+	cm *prettyCommit
+
+	// The committer date, which commits are searched in order of.
+	date int64
+
+	// The origins of the lines of the blamed file in this commit, in
+	// the order that they were created.
+	origins []*blameOrigin
+
+	// The blobs in the commit, keyed by path, if they've been needed.
+	files map[IndexPath]Sha1
+
+	queued bool
+
+	// The details shown for the commit.
+	info *blameCommitInfo
+}
+
+// A blameOrigin is the version of a file in a commit which lines may have
+// come from.
+type blameOrigin struct {
+	commit *blameCommit
+	path   IndexPath
+	blob   Sha1
+
+	// The lines of the file, including their newlines, and the same
+	// lines as they're compared.
+	lines, keys []string
+
+	// The entries which are currently attributed to this origin.
+	suspects []*blameEntry
+
+	// The first parent's version of the file, if any.
+	previous *blameOrigin
+
+	// Set when lines are finally attributed to this origin.
+	guilty bool
+}
+
+// A blameEntry is a group of consecutive lines of the blamed file which
+// are attributed to the same origin.
+type blameEntry struct {
+	// The 0 based line number in the blamed file, and the line number of
+	// the same line in the suspect's version of the file.
+	lno, sLno int
+	num       int
+
+	suspect *blameOrigin
+
+	// Set if the lines were attributed past an ignored commit, or
+	// couldn't be.
+	ignored, unblamable bool
+
+	// The cached number of alphanumeric characters in the lines, plus
+	// one, or 0 if it hasn't been calculated.
+	score int
+}
+
+// A blameScoreboard keeps track of the lines which haven't been attributed
+// to a commit yet, as in git's blame.
+type blameScoreboard struct {
+	c    *Client
+	opts BlameOptions
+	path IndexPath
+
+	// The lines of the file being blamed and the same lines as they're
+	// compared.
+	final, finalKeys []string
+
+	start   *blameCommit
+	commits map[CommitID]*blameCommit
+
+	// The commits with origins which may have suspects, sorted in the
+	// order that they're searched.
+	queue []*blameCommit
+
+	// The commits whose history isn't searched.
+	excluded map[CommitID]struct{}
+
+	// The commits following each commit in the range being searched by
+	// a reverse blame.
+	children map[CommitID][]CommitID
+
+	ignore map[CommitID]bool
+
+	// Maps the names and emails of authors and committers.
+	mailmap *Mailmap
+
+	// The entries which have been attributed to their suspects.
+	guilty []*blameEntry
+}
+
+func newBlameScoreboard(c *Client, opts BlameOptions, path IndexPath) (*blameScoreboard, error) {
+	if opts.MoveScore <= 0 {
+		opts.MoveScore = 20
+	}
+	if opts.CopyScore <= 0 {
+		opts.CopyScore = 40
+	}
+	if opts.DetectCopies > 0 {
+		opts.DetectMoves = true
+	}
+	s := &blameScoreboard{
+		c:        c,
+		opts:     opts,
+		path:     path,
+		commits:  make(map[CommitID]*blameCommit),
+		excluded: make(map[CommitID]struct{}),
+		ignore:   make(map[CommitID]bool),
+	}
+	for _, id := range opts.IgnoreRevs {
+		s.ignore[id] = true
+	}
+	mailmap, err := ReadMailmap(c)
+	if err != nil {
+		return nil, err
+	}
+	s.mailmap = mailmap
+
+	var origin *blameOrigin
+	if opts.Revision == nil {
+		if opts.Reverse {
+			return nil, fmt.Errorf("fatal: --reverse requires a revision")
+		}
+		o, err := s.workTreeOrigin()
+		if err != nil {
+			return nil, err
+		}
+		origin = o
+	} else {
+		commit, err := s.getCommit(*opts.Revision)
+		if err != nil {
+			return nil, err
+		}
+		o, err := s.findOrigin(commit, path)
+		if err != nil {
+			return nil, err
+		}
+		if o == nil {
+			return nil, fmt.Errorf("fatal: no such path %v in %v", path, commit.id)
+		}
+		origin = o
+	}
+	s.start = origin.commit
+	lines, err := s.lines(origin)
+	if err != nil {
+		return nil, err
+	}
+	s.final = lines
+	s.finalKeys = s.keys(origin)
+
+	if opts.Reverse {
+		if err := s.findChildren(); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, id := range opts.Excludes {
+			ancestors, err := id.AncestorMap(c)
+			if err != nil {
+				return nil, err
+			}
+			for a := range ancestors {
+				s.excluded[a] = struct{}{}
+			}
+		}
+	}
+
+	ranges, err := parseBlameRanges(opts.Ranges, lines, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range ranges {
+		origin.suspects = append(origin.suspects, &blameEntry{lno: r[0], sLno: r[0], num: r[1] - r[0], suspect: origin})
+	}
+	s.enqueue(origin.commit)
+	return s, nil
+}
+
+// Returns the origin for the file in the work tree, in a fake commit with
+// the zero id whose parent is HEAD.
+func (s *blameScoreboard) workTreeOrigin() (*blameOrigin, error) {
+	file, err := s.path.FilePath(s.c)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(file.String())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("fatal: Cannot lstat '%v': No such file or directory", s.path)
+		}
+		return nil, err
+	}
+	blob, _, err := HashSlice("blob", content)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ident := fmt.Sprintf("Not Committed Yet <not.committed.yet> %d %v", now.Unix(), now.Format("-0700"))
+	cm := &prettyCommit{
+		Author:    ident,
+		Committer: ident,
+		Message:   fmt.Sprintf("Version of %v from %v\n", s.path, s.path),
+	}
+	if head, err := s.c.GetHeadCommit(); err == nil {
+		cm.Parents = []CommitID{head}
+	}
+	commit := &blameCommit{cm: cm, date: now.Unix()}
+	s.commits[commit.id] = commit
+
+	origin := &blameOrigin{commit: commit, path: s.path, blob: blob, lines: blameSplitLines(content)}
+	commit.origins = append(commit.origins, origin)
+	return origin, nil
+}
+
+// Finds the children of each commit between the start and end of a
+// reverse blame, which lines are passed to instead of parents.
+func (s *blameScoreboard) findChildren() error {
+	inRange, err := s.opts.ReverseEnd.AncestorMap(s.c)
+	if err != nil {
+		return err
+	}
+	excluded, err := s.start.id.AncestorMap(s.c)
+	if err != nil {
+		return err
+	}
+	s.children = make(map[CommitID][]CommitID)
+	var ids []CommitID
+	for id := range inRange {
+		if _, ok := excluded[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	for _, id := range ids {
+		commit, err := s.getCommit(id)
+		if err != nil {
+			return err
+		}
+		for i, p := range commit.cm.Parents {
+			if i > 0 && s.opts.FirstParent {
+				break
+			}
+			s.children[p] = append(s.children[p], id)
+		}
+	}
+	for _, children := range s.children {
+		sort.SliceStable(children, func(i, j int) bool {
+			return s.commits[children[i]].date < s.commits[children[j]].date
+		})
+	}
+	return nil
+}
+
+func (s *blameScoreboard) getCommit(id CommitID) (*blameCommit, error) {
+	if commit, ok := s.commits[id]; ok {
+		return commit, nil
+	}
+	cm, err := parsePrettyCommit(s.c, id)
+	if err != nil {
+		return nil, err
+	}
+	commit := &blameCommit{id: id, cm: cm}
+	if p, err := parseIdent(cm.Committer); err == nil && p.Time != nil {
+		commit.date = p.Time.Unix()
+	}
+	s.commits[id] = commit
+	return commit, nil
+}
+
+// Returns the commits which the lines in commit may have come from: its
+// parents, or its children in a reverse blame.
+func (s *blameScoreboard) scapegoats(commit *blameCommit) ([]*blameCommit, error) {
+	ids := commit.cm.Parents
+	if s.opts.Reverse {
+		ids = s.children[commit.id]
+	}
+	if s.opts.FirstParent && len(ids) > 1 {
+		ids = ids[:1]
+	}
+	var val []*blameCommit
+	for _, id := range ids {
+		commit, err := s.getCommit(id)
+		if err != nil {
+			return nil, err
+		}
+		val = append(val, commit)
+	}
+	return val, nil
+}
+
+// Returns true if lines attributed to commit are boundary lines.
+func (s *blameScoreboard) boundary(commit *blameCommit) bool {
+	if _, ok := s.excluded[commit.id]; ok {
+		return true
+	}
+	if s.opts.Reverse && commit == s.start {
+		return true
+	}
+	return len(commit.cm.Parents) == 0 && commit.id != (CommitID{}) && !s.opts.ShowRoot
+}
+
+// Adds commit to the queue of commits to search, ordered by date, unless
+// it's already there.
+func (s *blameScoreboard) enqueue(commit *blameCommit) {
+	if commit.queued {
+		return
+	}
+	commit.queued = true
+	i := sort.Search(len(s.queue), func(i int) bool {
+		if s.opts.Reverse {
+			return s.queue[i].date > commit.date
+		}
+		return s.queue[i].date < commit.date
+	})
+	s.queue = append(s.queue, nil)
+	copy(s.queue[i+1:], s.queue[i:])
+	s.queue[i] = commit
+}
+
+// Attributes each line to a commit by passing the lines which each commit
+// didn't change to its parents, newest commits first.
+func (s *blameScoreboard) assign() error {
+	for len(s.queue) > 0 {
+		commit := s.queue[0]
+		s.queue = s.queue[1:]
+		commit.queued = false
+		for _, origin := range commit.origins {
+			if len(origin.suspects) == 0 {
+				continue
+			}
+			if _, ok := s.excluded[commit.id]; !ok {
+				if err := s.passBlame(origin); err != nil {
+					return err
+				}
+			}
+			if len(origin.suspects) > 0 {
+				origin.guilty = true
+				s.guilty = append(s.guilty, origin.suspects...)
+				origin.suspects = nil
+			}
+		}
+	}
+	return nil
+}
+
+// Passes as many of the lines attributed to origin as possible to the
+// versions of the file in its commit's parents.
+func (s *blameScoreboard) passBlame(origin *blameOrigin) error {
+	if _, err := s.lines(origin); err != nil {
+		return err
+	}
+	scapegoats, err := s.scapegoats(origin.commit)
+	if err != nil {
+		return err
+	}
+	porigins := make([]*blameOrigin, len(scapegoats))
+	for pass := 0; pass < 2; pass++ {
+		for i, sg := range scapegoats {
+			if porigins[i] != nil {
+				continue
+			}
+			var po *blameOrigin
+			if pass == 0 {
+				po, err = s.findOrigin(sg, origin.path)
+			} else {
+				po, err = s.findRename(sg, origin)
+			}
+			if err != nil {
+				return err
+			}
+			if po == nil {
+				continue
+			}
+			if po.blob == origin.blob {
+				s.passWholeBlame(origin, po)
+				return nil
+			}
+			same := false
+			for _, other := range porigins[:i] {
+				if other != nil && other.blob == po.blob {
+					same = true
+					break
+				}
+			}
+			if !same {
+				porigins[i] = po
+			}
+		}
+	}
+
+	for _, po := range porigins {
+		if po == nil {
+			continue
+		}
+		if origin.previous == nil {
+			origin.previous = po
+		}
+		if err := s.passBlameToParent(origin, po, false); err != nil {
+			return err
+		}
+		if len(origin.suspects) == 0 {
+			return nil
+		}
+	}
+	if s.ignore[origin.commit.id] {
+		for _, po := range porigins {
+			if po == nil {
+				continue
+			}
+			if err := s.passBlameToParent(origin, po, true); err != nil {
+				return err
+			}
+			if len(origin.suspects) == 0 {
+				return nil
+			}
+		}
+	}
+
+	// Blocks of lines which are too small to be searched for elsewhere
+	// are set aside, and given back to origin at the end.
+	var toosmall []*blameEntry
+	defer func() {
+		origin.suspects = append(origin.suspects, toosmall...)
+	}()
+	if s.opts.DetectMoves {
+		origin.suspects, toosmall = s.filterSmall(origin.suspects, s.opts.MoveScore)
+		for _, po := range porigins {
+			if po == nil {
+				continue
+			}
+			done, err := s.findMoveInParent(origin, po, &toosmall)
+			if err != nil {
+				return err
+			}
+			if done {
+				break
+			}
+		}
+	}
+	if s.opts.DetectCopies > 0 {
+		origin.suspects, toosmall = s.filterSmall(append(origin.suspects, toosmall...), s.opts.CopyScore)
+		for i, sg := range scapegoats {
+			if len(origin.suspects) == 0 {
+				break
+			}
+			if err := s.findCopyInParent(origin, sg, porigins[i], &toosmall); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the origin for the file at path in commit, or nil if it's not a
+// file in commit.
+func (s *blameScoreboard) findOrigin(commit *blameCommit, path IndexPath) (*blameOrigin, error) {
+	for _, o := range commit.origins {
+		if o.path == path {
+			return o, nil
+		}
+	}
+	entry, ok, err := treeEntryAt(s.c, commit.cm.Tree, path)
+	if err != nil || !ok {
+		return nil, err
+	}
+	switch entry.FileMode {
+	case ModeBlob, ModeExec, ModeSymlink:
+	default:
+		return nil, nil
+	}
+	return s.getOrigin(commit, path, entry.Sha1), nil
+}
+
+// Returns the origin for the blob at path in commit, creating it if
+// necessary.
+func (s *blameScoreboard) getOrigin(commit *blameCommit, path IndexPath, blob Sha1) *blameOrigin {
+	for _, o := range commit.origins {
+		if o.path == path {
+			return o
+		}
+	}
+	o := &blameOrigin{commit: commit, path: path, blob: blob}
+	commit.origins = append(commit.origins, o)
+	return o
+}
+
+// Returns the origin in parent of the file which origin was renamed from,
+// if it was renamed from a file which was deleted by origin's commit.
+func (s *blameScoreboard) findRename(parent *blameCommit, origin *blameOrigin) (*blameOrigin, error) {
+	pfiles, err := s.files(parent)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := pfiles[origin.path]; ok {
+		return nil, nil
+	}
+	files, err := s.files(origin.commit)
+	if err != nil {
+		return nil, err
+	}
+	var sources []IndexPath
+	for path := range pfiles {
+		if _, ok := files[path]; !ok {
+			sources = append(sources, path)
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i] < sources[j] })
+	for _, path := range sources {
+		if pfiles[path] == origin.blob {
+			return s.getOrigin(parent, path, pfiles[path]), nil
+		}
+	}
+
+	dst := []byte(strings.Join(origin.lines, ""))
+	var best IndexPath
+	bestScore := defaultRenameScore - 1
+	for _, path := range sources {
+		obj, err := s.c.GetObject(pfiles[path])
+		if err != nil {
+			return nil, err
+		}
+		if score := similarityScore(obj.GetContent(), dst, defaultRenameScore); score > bestScore {
+			best, bestScore = path, score
+		}
+	}
+	if best == "" {
+		return nil, nil
+	}
+	return s.getOrigin(parent, best, pfiles[best]), nil
+}
+
+// Returns the blobs in commit, keyed by path. The files of the fake work
+// tree commit are the ones in the index.
+func (s *blameScoreboard) files(commit *blameCommit) (map[IndexPath]Sha1, error) {
+	if commit.files != nil {
+		return commit.files, nil
+	}
+	files := make(map[IndexPath]Sha1)
+	if commit.id == (CommitID{}) {
+		idx, err := s.c.GitDir.ReadIndex()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range idx.Objects {
+			if entry.Stage() == Stage0 {
+				files[entry.PathName] = entry.Sha1
+			}
+		}
+	} else {
+		all, err := commit.cm.Tree.GetAllObjects(s.c, "", true, false)
+		if err != nil {
+			return nil, err
+		}
+		for path, entry := range all {
+			switch entry.FileMode {
+			case ModeBlob, ModeExec, ModeSymlink:
+				files[path] = entry.Sha1
+			}
+		}
+	}
+	commit.files = files
+	return files, nil
+}
+
+// Returns the lines of the file in origin, loading them if necessary.
+func (s *blameScoreboard) lines(o *blameOrigin) ([]string, error) {
+	if o.lines != nil {
+		return o.lines, nil
+	}
+	obj, err := s.c.GetObject(o.blob)
+	if err != nil {
+		return nil, err
+	}
+	o.lines = blameSplitLines(obj.GetContent())
+	return o.lines, nil
+}
+
+// Returns the lines of the file in origin as they're compared, which must
+// already have been loaded.
+func (s *blameScoreboard) keys(o *blameOrigin) []string {
+	if o.keys == nil {
+		o.keys = s.compareKeys(o.lines)
+	}
+	return o.keys
+}
+
+// Returns lines with whitespace removed if it's being ignored.
+func (s *blameScoreboard) compareKeys(lines []string) []string {
+	if !s.opts.IgnoreWhitespace {
+		return lines
+	}
+	keys := make([]string, len(lines))
+	for i, line := range lines {
+		keys[i] = strings.Map(func(r rune) rune {
+			if r < 0x80 && isSpace(byte(r)) {
+				return -1
+			}
+			return r
+		}, line)
+	}
+	return keys
+}
+
+// Splits content into lines, keeping their newlines so that a missing
+// newline at the end of the file is a difference.
+func blameSplitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if lines == nil {
+		return []string{}
+	}
+	return lines
+}
+
+// Gives the entries of origin to po, when the file wasn't changed.
+func (s *blameScoreboard) passWholeBlame(origin, po *blameOrigin) {
+	for _, e := range origin.suspects {
+		e.suspect = po
+	}
+	po.suspects = append(po.suspects, origin.suspects...)
+	origin.suspects = nil
+	s.enqueue(po.commit)
+}
+
+// Attributes e to the origin o.
+func (s *blameScoreboard) give(e *blameEntry, o *blameOrigin) {
+	e.suspect = o
+	e.score = 0
+	o.suspects = append(o.suspects, e)
+	s.enqueue(o.commit)
+}
+
+// Passes the lines attributed to target which are unchanged in parent to
+// it. If ignore is set, the changed lines are also passed to the most
+// similar changed lines of parent, or the line at the same offset in the
+// parent's side of the hunk if none are similar. Lines past the end of
+// the parent's side are marked as unblamable.
+func (s *blameScoreboard) passBlameToParent(target, parent *blameOrigin, ignore bool) error {
+	plines, err := s.lines(parent)
+	if err != nil {
+		return err
+	}
+	hunks := diffLines(s.keys(parent), s.keys(target))
+
+	// The line of parent which each line of target is the same as, or
+	// -1 if it was changed.
+	tmap := make([]int, len(target.lines))
+	guessed := make([]bool, len(target.lines))
+	t, p := 0, 0
+	for _, h := range hunks {
+		for ; t < h.B; t, p = t+1, p+1 {
+			tmap[t] = p
+		}
+		for i := 0; i < h.BCount; i++ {
+			tmap[t+i] = -1
+		}
+		if ignore {
+			for i, m := range matchSimilarLines(plines[h.A:h.A+h.ACount], target.lines[h.B:h.B+h.BCount]) {
+				if m < 0 && i < h.ACount {
+					m = i
+				}
+				if m >= 0 {
+					tmap[t+i] = h.A + m
+					guessed[t+i] = true
+				}
+			}
+		}
+		t, p = h.B+h.BCount, h.A+h.ACount
+	}
+	for ; t < len(tmap); t, p = t+1, p+1 {
+		tmap[t] = p
+	}
+
+	sort.Slice(target.suspects, func(i, j int) bool { return target.suspects[i].sLno < target.suspects[j].sLno })
+	var remaining []*blameEntry
+	for _, e := range target.suspects {
+		for start := 0; start < e.num; {
+			t := e.sLno + start
+			n := 1
+			for ; start+n < e.num; n++ {
+				next := tmap[t+n]
+				if (tmap[t] < 0) != (next < 0) || next >= 0 && (next != tmap[t]+n || guessed[t+n] != guessed[t]) {
+					break
+				}
+			}
+			split := &blameEntry{lno: e.lno + start, sLno: t, num: n, ignored: e.ignored, unblamable: e.unblamable}
+			if tmap[t] >= 0 {
+				split.sLno = tmap[t]
+				split.ignored = split.ignored || guessed[t]
+				s.give(split, parent)
+			} else {
+				split.suspect = target
+				split.unblamable = split.unblamable || ignore
+				remaining = append(remaining, split)
+			}
+			start += n
+		}
+	}
+	target.suspects = remaining
+	return nil
+}
+
+// A blameFingerprint loosely represents a line as the lower cased pairs
+// of bytes in it and the number of times each occurs, as git does to guess
+// where the changed lines of ignored commits came from. Whitespace is
+// treated as 0, the line is ended by whitespace, and pairs of whitespace
+// are ignored.
+type blameFingerprint map[[2]byte]int
+
+func newBlameFingerprint(line string) blameFingerprint {
+	f := make(blameFingerprint)
+	var c0 byte
+	for i := 0; i <= len(line); i++ {
+		var c1 byte
+		if i < len(line) {
+			switch c := line[i]; c {
+			case ' ', '\t', '\n', '\v', '\f', '\r':
+			default:
+				if c >= 'A' && c <= 'Z' {
+					c += 'a' - 'A'
+				}
+				c1 = c
+			}
+		}
+		if c0 != 0 || c1 != 0 {
+			f[[2]byte{c0, c1}]++
+		}
+		c0 = c1
+	}
+	return f
+}
+
+// Returns the number of pairs of bytes which are in both f and o.
+func (f blameFingerprint) similarity(o blameFingerprint) int {
+	n := 0
+	for pair, count := range o {
+		n += min(count, f[pair])
+	}
+	return n
+}
+
+// Removes the pairs of bytes in o from f.
+func (f blameFingerprint) subtract(o blameFingerprint) {
+	for pair, count := range o {
+		if f[pair] <= count {
+			delete(f, pair)
+		} else {
+			f[pair] -= count
+		}
+	}
+}
+
+const (
+	blameCertainNoMatch    = -2
+	blameCertaintyUnknown  = -1
+	blameMaxSearchDistance = 10
+)
+
+// Matches the lines of b with the lines of a that they most likely came
+// from, as in git's fuzzy_find_matching_lines.
+type blameLineMatcher struct {
+	a, b []blameFingerprint
+
+	// The number of lines of a around the line at the same relative
+	// position as a line of b which it's compared with, and the
+	// furthest apart lines of b can be and still be compared with the
+	// same line of a.
+	maxA, maxB int
+
+	// The scaled similarities of lines of b and a, indexed by the line
+	// of b then of a, which have been calculated.
+	similarities map[[2]int]int
+
+	// How certain the match of each line of b is, and the lines of a
+	// which it's most and second most similar to.
+	certainties, best, second []int
+}
+
+// Returns the index of the line in a which each line in b most likely
+// came from, or -1 if it isn't similar to any. The lines matched in a are
+// in the same order as b, but more than one line of b may match the same
+// line of a.
+func matchSimilarLines(a, b []string) []int {
+	result := make([]int, len(b))
+	for i := range result {
+		result[i] = -1
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return result
+	}
+	m := &blameLineMatcher{
+		maxA:         min(blameMaxSearchDistance, len(a)-1),
+		similarities: make(map[[2]int]int),
+		certainties:  make([]int, len(b)),
+		best:         result,
+		second:       make([]int, len(b)),
+	}
+	m.maxB = ((2*m.maxA+1)*len(b) - 1) / len(a)
+	for _, line := range a {
+		m.a = append(m.a, newBlameFingerprint(line))
+	}
+	for i, line := range b {
+		m.b = append(m.b, newBlameFingerprint(line))
+		m.certainties[i] = blameCertaintyUnknown
+		m.second[i] = -1
+	}
+	m.match(0, len(a), 0, len(b))
+	return result
+}
+
+// Returns the line of a at the same relative position as the line bi of b.
+func (m *blameLineMatcher) closest(bi int) int {
+	return (bi*2 + 1) * len(m.a) / (len(m.b) * 2)
+}
+
+// Finds the lines of a from start to end which are most similar to the
+// line bi of b, if it's not already known.
+func (m *blameLineMatcher) findBest(start, end, bi int) {
+	if m.certainties[bi] != blameCertaintyUnknown {
+		return
+	}
+	closest := m.closest(bi)
+	best, second := 0, 0
+	besti, secondi := start, start
+	for ai := max(closest-m.maxA, start); ai < min(closest+m.maxA+1, end); ai++ {
+		sim, ok := m.similarities[[2]int{bi, ai}]
+		if !ok {
+			// Closer lines win ties.
+			distance := closest - ai
+			if distance < 0 {
+				distance = -distance
+			}
+			sim = m.b[bi].similarity(m.a[ai]) * (1000 - distance)
+			m.similarities[[2]int{bi, ai}] = sim
+		}
+		if sim > best {
+			second, secondi = best, besti
+			best, besti = sim, ai
+		} else if sim > second {
+			second, secondi = sim, ai
+		}
+	}
+	if best == 0 {
+		m.certainties[bi] = blameCertainNoMatch
+		m.best[bi] = -1
+		return
+	}
+	// A line which is similar to two lines is less certain, but still
+	// more than one which is only a little similar to one.
+	m.certainties[bi] = best*2 - second
+	m.best[bi], m.second[bi] = besti, secondi
+}
+
+// Matches the lines of b from bstart to bend with the lines of a from
+// astart to aend by matching the line which is most certain, then the
+// lines on either side of it.
+func (m *blameLineMatcher) match(astart, aend, bstart, bend int) {
+	most, certainty := -1, -1
+	for bi := bstart; bi < bend; bi++ {
+		m.findBest(astart, aend, bi)
+		if m.certainties[bi] > certainty {
+			most, certainty = bi, m.certainties[bi]
+		}
+	}
+	if most < 0 {
+		return
+	}
+	ai := m.best[most]
+
+	// Other lines can't match the same parts of the line of a, so the
+	// matches which may be affected need to be recalculated.
+	m.a[ai].subtract(m.b[most])
+	imin, imax := max(most-m.maxB, bstart), min(most+m.maxB+1, bend)
+	for bi := imin; bi < imax; bi++ {
+		delete(m.similarities, [2]int{bi, ai})
+	}
+	for bi := most - 1; bi >= imin; bi-- {
+		if m.certainties[bi] >= 0 && (m.best[bi] >= ai || m.second[bi] >= ai) {
+			m.certainties[bi] = blameCertaintyUnknown
+		}
+	}
+	for bi := most + 1; bi < imax; bi++ {
+		if m.certainties[bi] >= 0 && (m.best[bi] <= ai || m.second[bi] <= ai) {
+			m.certainties[bi] = blameCertaintyUnknown
+		}
+	}
+
+	if most > bstart {
+		m.match(astart, ai+1, bstart, most)
+	}
+	if most+1 < bend {
+		m.match(ai, aend, most+1, bend)
+	}
+}
+
+// Returns the score of e, which is one more than the number of
+// alphanumeric characters in its lines.
+func (s *blameScoreboard) score(e *blameEntry) int {
+	if e.score != 0 {
+		return e.score
+	}
+	e.score = 1
+	for _, line := range s.final[e.lno : e.lno+e.num] {
+		for i := 0; i < len(line); i++ {
+			if isAlnum(line[i]) {
+				e.score++
+			}
+		}
+	}
+	return e.score
+}
+
+// Splits entries into the ones with a score greater than score, and the
+// ones which are too small.
+func (s *blameScoreboard) filterSmall(entries []*blameEntry, score int) (big, small []*blameEntry) {
+	for _, e := range entries {
+		if s.score(e) <= score {
+			small = append(small, e)
+		} else {
+			big = append(big, e)
+		}
+	}
+	return big, small
+}
+
+// Searches the version of the file in parent for the blocks of lines
+// attributed to target, in case they were moved within the file. Returns
+// true if there are no lines left to search for.
+func (s *blameScoreboard) findMoveInParent(target, parent *blameOrigin, toosmall *[]*blameEntry) (bool, error) {
+	if len(target.suspects) == 0 {
+		return true, nil
+	}
+	if _, err := s.lines(parent); err != nil {
+		return false, err
+	}
+	unblamed := target.suspects
+	var leftover []*blameEntry
+	for len(unblamed) > 0 {
+		var next []*blameEntry
+		for _, e := range unblamed {
+			split := s.findCopyInBlob(e, parent)
+			if split[1] != nil && s.score(split[1]) > s.opts.MoveScore {
+				next = append(next, s.splitBlame(split)...)
+			} else {
+				leftover = append(leftover, e)
+			}
+		}
+		var small []*blameEntry
+		unblamed, small = s.filterSmall(next, s.opts.MoveScore)
+		*toosmall = append(*toosmall, small...)
+	}
+	target.suspects = leftover
+	return false, nil
+}
+
+// Searches the files in parent for the blocks of lines attributed to
+// target, in case they were copied from them. porigin is the version of
+// the file in parent, which has already been searched, if there is one.
+func (s *blameScoreboard) findCopyInParent(target *blameOrigin, parent *blameCommit, porigin *blameOrigin, toosmall *[]*blameEntry) error {
+	pfiles, err := s.files(parent)
+	if err != nil {
+		return err
+	}
+	files, err := s.files(target.commit)
+	if err != nil {
+		return err
+	}
+	harder := s.opts.DetectCopies >= 3 || s.opts.DetectCopies >= 2 && (porigin == nil || porigin.path != target.path)
+	var candidates []*blameOrigin
+	for path, blob := range pfiles {
+		if porigin != nil && path == porigin.path {
+			continue
+		}
+		if blob2, ok := files[path]; !harder && ok && blob2 == blob {
+			continue
+		}
+		o := s.getOrigin(parent, path, blob)
+		if _, err := s.lines(o); err != nil {
+			return err
+		}
+		candidates = append(candidates, o)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].path < candidates[j].path })
+
+	unblamed := target.suspects
+	var leftover []*blameEntry
+	for len(unblamed) > 0 {
+		best := make([][3]*blameEntry, len(unblamed))
+		for _, o := range candidates {
+			for j, e := range unblamed {
+				s.copySplitIfBetter(&best[j], s.findCopyInBlob(e, o))
+			}
+		}
+		var next []*blameEntry
+		for j, e := range unblamed {
+			if best[j][1] != nil && s.score(best[j][1]) > s.opts.CopyScore {
+				next = append(next, s.splitBlame(best[j])...)
+			} else {
+				leftover = append(leftover, e)
+			}
+		}
+		var small []*blameEntry
+		unblamed, small = s.filterSmall(next, s.opts.CopyScore)
+		*toosmall = append(*toosmall, small...)
+	}
+	target.suspects = leftover
+	return nil
+}
+
+// Attributes the middle part of split to its suspect, and returns the
+// parts before and after it which are still to be searched for.
+func (s *blameScoreboard) splitBlame(split [3]*blameEntry) []*blameEntry {
+	s.give(split[1], split[1].suspect)
+	var val []*blameEntry
+	for _, e := range []*blameEntry{split[0], split[2]} {
+		if e != nil {
+			val = append(val, e)
+		}
+	}
+	return val
+}
+
+// Returns the best block of the lines of e which can be found in parent,
+// split into the lines before it, the block attributed to parent, and the
+// lines after it. The middle part is nil if no lines were found.
+func (s *blameScoreboard) findCopyInBlob(e *blameEntry, parent *blameOrigin) [3]*blameEntry {
+	var best [3]*blameEntry
+	hunks := diffLines(s.keys(parent), s.finalKeys[e.lno:e.lno+e.num])
+	tlno, plno := 0, 0
+	handle := func(same int) {
+		if e.num <= tlno || tlno >= same {
+			return
+		}
+		s.copySplitIfBetter(&best, splitOverlap(e, tlno+e.sLno, plno, same+e.sLno, parent))
+	}
+	for _, h := range hunks {
+		handle(h.B)
+		tlno, plno = h.B+h.BCount, h.A+h.ACount
+	}
+	handle(e.num)
+	return best
+}
+
+// Splits e into the lines before the suspect's lines tlno to same, which
+// are the same as parent's lines from plno, those lines, and the lines
+// after them.
+func splitOverlap(e *blameEntry, tlno, plno, same int, parent *blameOrigin) [3]*blameEntry {
+	var split [3]*blameEntry
+	mid := &blameEntry{ignored: e.ignored, unblamable: e.unblamable}
+	if e.sLno < tlno {
+		split[0] = &blameEntry{lno: e.lno, sLno: e.sLno, num: tlno - e.sLno, suspect: e.suspect, ignored: e.ignored, unblamable: e.unblamable}
+		mid.lno = e.lno + tlno - e.sLno
+		mid.sLno = plno
+	} else {
+		mid.lno = e.lno
+		mid.sLno = plno + e.sLno - tlno
+	}
+	end := e.lno + e.num
+	if same < e.sLno+e.num {
+		split[2] = &blameEntry{lno: e.lno + same - e.sLno, sLno: same, num: e.sLno + e.num - same, suspect: e.suspect, ignored: e.ignored, unblamable: e.unblamable}
+		end = split[2].lno
+	}
+	mid.num = end - mid.lno
+	if mid.num < 1 {
+		return [3]*blameEntry{}
+	}
+	mid.suspect = parent
+	split[1] = mid
+	return split
+}
+
+// Replaces best with split if its middle part scores at least as well.
+func (s *blameScoreboard) copySplitIfBetter(best *[3]*blameEntry, split [3]*blameEntry) {
+	if split[1] == nil {
+		return
+	}
+	if best[1] != nil && s.score(split[1]) < s.score(best[1]) {
+		return
+	}
+	*best = split
+}
+
+// Returns the entries which have been attributed, sorted by line, with
+// adjacent entries from the same lines of the same origin merged.
+func (s *blameScoreboard) sortedEntries() []*blameEntry {
+	entries := append([]*blameEntry(nil), s.guilty...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].lno < entries[j].lno })
+	var val []*blameEntry
+	for _, e := range entries {
+		if n := len(val); n > 0 {
+			prev := val[n-1]
+			if prev.suspect == e.suspect && prev.sLno+prev.num == e.sLno && prev.lno+prev.num == e.lno && prev.ignored == e.ignored && prev.unblamable == e.unblamable {
+				prev.num += e.num
+				prev.score = 0
+				continue
+			}
+		}
+		val = append(val, e)
+	}
+	return val
+}
+
+// Parses the -L ranges for the file with the given lines into 0 based
+// [start, end) pairs, sorted with overlapping ranges merged.
+func parseBlameRanges(args []string, lines []string, path IndexPath) ([][2]int, error) {
+	if len(args) == 0 {
+		return [][2]int{{0, len(lines)}}, nil
+	}
+	var ranges [][2]int
+	anchor := 1
+	for _, arg := range args {
+		bottom, top, err := parseLineRange(arg, lines, anchor)
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) == 0 && (top != 0 || bottom != 0) || len(lines) < bottom {
+			if len(lines) == 1 {
+				return nil, fmt.Errorf("fatal: file %v has only 1 line", path)
+			}
+			return nil, fmt.Errorf("fatal: file %v has only %d lines", path, len(lines))
+		}
+		if bottom < 1 {
+			bottom = 1
+		}
+		if top < 1 || len(lines) < top {
+			top = len(lines)
+		}
+		ranges = append(ranges, [2]int{bottom - 1, top})
+		anchor = top + 1
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	var merged [][2]int
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1] {
+			if r[1] > merged[n-1][1] {
+				merged[n-1][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged, nil
+}
+
+// Parses a single -L argument, returning its 1 based bounds. A bound of 0
+// means that it wasn't given. Regular expressions are searched for from
+// the line anchor.
+func parseLineRange(arg string, lines []string, anchor int) (begin, end int, err error) {
+	if anchor < 1 {
+		anchor = 1
+	}
+	if anchor > len(lines) {
+		anchor = len(lines) + 1
+	}
+	if strings.HasPrefix(arg, ":") || strings.HasPrefix(arg, "^:") {
+		return parseLineRangeFuncname(arg, lines, anchor)
+	}
+	rest, err := parseLineLoc(arg, lines, -anchor, &begin)
+	if err != nil {
+		return 0, 0, err
+	}
+	if strings.HasPrefix(rest, ",") {
+		if rest, err = parseLineLoc(rest[1:], lines, begin+1, &end); err != nil {
+			return 0, 0, err
+		}
+	}
+	if rest != "" {
+		return 0, 0, fmt.Errorf("fatal: invalid -L argument %v", arg)
+	}
+	if begin != 0 && end != 0 && end < begin {
+		begin, end = end, begin
+	}
+	return begin, end, nil
+}
+
+// Parses a line number, a relative offset from begin (if it's positive),
+// or a /regex/ at the start of spec into ret, and returns the rest of
+// spec. A negative begin is the line to start searching from.
+func parseLineLoc(spec string, lines []string, begin int, ret *int) (string, error) {
+	if begin >= 1 && (strings.HasPrefix(spec, "+") || strings.HasPrefix(spec, "-")) {
+		digits := len(spec[1:]) - len(strings.TrimLeft(spec[1:], "0123456789"))
+		if digits == 0 {
+			return spec, nil
+		}
+		num, _ := strconv.Atoi(spec[1 : 1+digits])
+		if num == 0 {
+			return "", fmt.Errorf("fatal: -L invalid empty range")
+		}
+		if spec[0] == '+' {
+			*ret = begin + num - 2
+		} else if *ret = begin - num; *ret < 1 {
+			*ret = 1
+		}
+		return spec[1+digits:], nil
+	}
+	if digits := len(spec) - len(strings.TrimLeft(spec, "0123456789")); digits > 0 {
+		num, _ := strconv.Atoi(spec[:digits])
+		if num <= 0 {
+			return "", fmt.Errorf("fatal: -L invalid line number: %d", num)
+		}
+		*ret = num
+		return spec[digits:], nil
+	}
+
+	if begin < 0 {
+		if strings.HasPrefix(spec, "^") {
+			begin = 1
+			spec = spec[1:]
+		} else {
+			begin = -begin
+		}
+	}
+	if !strings.HasPrefix(spec, "/") {
+		return spec, nil
+	}
+	term := 1
+	for ; term < len(spec) && spec[term] != '/'; term++ {
+		if spec[term] == '\\' {
+			term++
+		}
+	}
+	if term >= len(spec) {
+		return spec, nil
+	}
+	pattern := spec[1:term]
+	re, err := regexp.Compile("(?m)" + pattern)
+	if err != nil {
+		return "", fmt.Errorf("fatal: -L parameter '%v' starting at line %d: %v", pattern, begin, err)
+	}
+	begin--
+	text := strings.Join(lines[min(begin, len(lines)):], "")
+	loc := re.FindStringIndex(text)
+	if loc == nil {
+		return "", fmt.Errorf("fatal: -L parameter '%v' starting at line %d: No match", pattern, begin+1)
+	}
+	*ret = begin + strings.Count(text[:loc[0]], "\n") + 1
+	return spec[term+1:], nil
+}
+
+// Parses a :<funcname> range, which starts at the first line matching the
+// regular expression which looks like the start of a function, and ends
+// before the next line which does.
+func parseLineRangeFuncname(arg string, lines []string, anchor int) (begin, end int, err error) {
+	if strings.HasPrefix(arg, "^") {
+		anchor = 1
+		arg = arg[1:]
+	}
+	term := 1
+	for ; term < len(arg) && arg[term] != ':'; term++ {
+		if arg[term] == '\\' && term+1 < len(arg) {
+			term++
+		}
+	}
+	if term == 1 || term != len(arg) {
+		return 0, 0, fmt.Errorf("fatal: invalid -L argument %v", arg)
+	}
+	pattern := arg[1:term]
+	re, err := regexp.Compile("(?m)" + pattern)
+	if err != nil {
+		return 0, 0, fmt.Errorf("fatal: -L parameter '%v': %v", pattern, err)
+	}
+	isFuncname := func(line string) bool {
+		return line != "" && (line[0] == '_' || line[0] == '$' || line[0] >= 'a' && line[0] <= 'z' || line[0] >= 'A' && line[0] <= 'Z')
+	}
+	begin = -1
+	for i := anchor - 1; i < len(lines); i++ {
+		if isFuncname(lines[i]) && re.MatchString(strings.TrimSuffix(lines[i], "\n")) {
+			begin = i
+			break
+		}
+	}
+	if begin < 0 {
+		return 0, 0, fmt.Errorf("fatal: -L parameter '%v' starting at line %d: no match", pattern, anchor)
+	}
+	for end = begin + 1; end < len(lines) && !isFuncname(lines[end]); end++ {
+	}
+	return begin + 1, end, nil
+}
+
+// The details of a commit shown by blame.
+type blameCommitInfo struct {
+	author, authorMail, authorTime, authorTZ             string
+	committer, committerMail, committerTime, committerTZ string
+	authorDate                                           time.Time
+	summary                                              string
+}
+
+func (s *blameScoreboard) commitInfo(commit *blameCommit) *blameCommitInfo {
+	if commit.info != nil {
+		return commit.info
+	}
+	info := &blameCommitInfo{}
+	split := func(ident string) (name, mail, when, tz string) {
+		name, mail = ident, ""
+		if l := strings.IndexByte(ident, '<'); l >= 0 {
+			if r := strings.IndexByte(ident[l:], '>'); r >= 0 {
+				name, mail = s.mailmap.Map(strings.TrimSpace(ident[:l]), ident[l+1:l+r])
+				mail = "<" + mail + ">"
+				if fields := strings.Fields(ident[l+r+1:]); len(fields) == 2 {
+					when, tz = fields[0], fields[1]
+				}
+			}
+		}
+		return
+	}
+	info.author, info.authorMail, info.authorTime, info.authorTZ = split(commit.cm.Author)
+	info.committer, info.committerMail, info.committerTime, info.committerTZ = split(commit.cm.Committer)
+	if p, err := parseIdent(commit.cm.Author); err == nil && p.Time != nil {
+		info.authorDate = *p.Time
+	}
+	msg := skipBlankLines(commit.cm.Message)
+	if eol := strings.IndexByte(msg, '\n'); eol >= 0 {
+		msg = msg[:eol]
+	}
+	info.summary = msg
+	commit.info = info
+	return info
+}
+
+// Writes the entries in the porcelain format, which shows the details of
+// each commit the first time it appears, or for every line if
+// LinePorcelain is set.
+func (s *blameScoreboard) writePorcelain(w io.Writer, entries []*blameEntry) error {
+	// The filename is shown every time for commits which lines from more
+	// than one file are attributed to.
+	paths := make(map[*blameCommit]int)
+	for _, e := range entries {
+		commit := e.suspect.commit
+		if _, ok := paths[commit]; ok {
+			continue
+		}
+		for _, o := range commit.origins {
+			if o.guilty {
+				paths[commit]++
+			}
+		}
+	}
+	shown := make(map[*blameCommit]bool)
+	details := func(o *blameOrigin) string {
+		var sb strings.Builder
+		commit := o.commit
+		if s.opts.LinePorcelain || !shown[commit] {
+			shown[commit] = true
+			info := s.commitInfo(commit)
+			fmt.Fprintf(&sb, "author %v\nauthor-mail %v\nauthor-time %v\nauthor-tz %v\n", info.author, info.authorMail, info.authorTime, info.authorTZ)
+			fmt.Fprintf(&sb, "committer %v\ncommitter-mail %v\ncommitter-time %v\ncommitter-tz %v\n", info.committer, info.committerMail, info.committerTime, info.committerTZ)
+			fmt.Fprintf(&sb, "summary %v\n", info.summary)
+			if s.boundary(commit) {
+				sb.WriteString("boundary\n")
+			}
+		} else if paths[commit] <= 1 {
+			return ""
+		}
+		if o.previous != nil {
+			fmt.Fprintf(&sb, "previous %v %v\n", o.previous.commit.id, o.previous.path)
+		}
+		fmt.Fprintf(&sb, "filename %v\n", o.path)
+		return sb.String()
+	}
+	for _, e := range entries {
+		hex := e.suspect.commit.id.String()
+		for i := 0; i < e.num; i++ {
+			var header string
+			if i == 0 {
+				header = fmt.Sprintf("%v %d %d %d\n%v", hex, e.sLno+1, e.lno+1, e.num, details(e.suspect))
+			} else {
+				header = fmt.Sprintf("%v %d %d\n", hex, e.sLno+1+i, e.lno+1+i)
+				if s.opts.LinePorcelain {
+					header += details(e.suspect)
+				}
+			}
+			if _, err := fmt.Fprintf(w, "%v\t%v", header, blameLine(s.final[e.lno+i])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns line with a newline at the end if it doesn't have one.
+func blameLine(line string) string {
+	if !strings.HasSuffix(line, "\n") {
+		return line + "\n"
+	}
+	return line
+}
+
+// Returns the width that dates are padded to in mode.
+func blameDateWidth(mode DateMode) int {
+	switch mode.Type {
+	case "rfc2822":
+		return len("Thu, 19 Oct 2006 16:00:04 -0700")
+	case "iso8601-strict":
+		return len("2006-10-19T16:00:04-07:00")
+	case "iso8601", "":
+		return len("2006-10-19 16:00:04 -0700")
+	case "raw":
+		return len("1161298804 -0700")
+	case "unix":
+		return len("1161298804")
+	case "short":
+		return len("2006-10-19")
+	case "relative":
+		return len("4 years, 11 months ago")
+	case "human":
+		return len("Thu Oct 19 16:00")
+	case "format":
+		return len(strftime(mode.Format, time.Unix(0, 0).UTC(), mode.Local))
+	}
+	return len("Thu Oct 19 16:00:04 2006 -0700")
+}
+
+// Writes the entries in the default human readable format, or the format
+// of git annotate.
+func (s *blameScoreboard) writeDefault(w io.Writer, entries []*blameEntry) error {
+	opts := s.opts
+	dateMode := opts.Date
+	if dateMode.Type == "" {
+		dateMode.Type = "iso8601"
+	}
+	dateWidth := blameDateWidth(dateMode)
+	now := time.Now()
+	formatTime := func(info *blameCommitInfo) string {
+		if opts.RawTimestamp {
+			return info.authorTime + " " + info.authorTZ
+		}
+		date := FormatDate(info.authorDate, dateMode, now)
+		if n := displayWidth(date); n < dateWidth {
+			date += strings.Repeat(" ", dateWidth-n)
+		}
+		return date
+	}
+	name := func(info *blameCommitInfo) string {
+		if opts.ShowEmail {
+			return info.authorMail
+		}
+		return info.author
+	}
+
+	showName := opts.ShowName
+	longestFile, longestAuthor, maxSrc, maxDst := 0, 0, 0, 0
+	autoAbbrev := 7
+	for _, e := range entries {
+		commit := e.suspect.commit
+		if e.suspect.path != s.path {
+			showName = true
+		}
+		longestFile = max(longestFile, len(e.suspect.path))
+		longestAuthor = max(longestAuthor, displayWidth(name(s.commitInfo(commit))))
+		maxSrc = max(maxSrc, e.sLno+e.num)
+		maxDst = max(maxDst, e.lno+e.num)
+		if opts.Abbrev == 0 && !opts.LongNames && commit.id != (CommitID{}) {
+			autoAbbrev = max(autoAbbrev, len(abbreviateSha1(s.c, Sha1(commit.id), autoAbbrev)))
+		}
+	}
+	abbrev := autoAbbrev + 1
+	switch {
+	case opts.LongNames:
+		abbrev = 40
+	case opts.Abbrev > 0:
+		abbrev = min(opts.Abbrev+1, 40)
+	}
+	srcDigits, dstDigits := len(strconv.Itoa(maxSrc)), len(strconv.Itoa(maxDst))
+
+	for _, e := range entries {
+		commit := e.suspect.commit
+		info := s.commitInfo(commit)
+		for i := 0; i < e.num; i++ {
+			var sb strings.Builder
+			hex := commit.id.String()
+			length := abbrev
+			if s.boundary(commit) {
+				if opts.BlankBoundary {
+					hex = strings.Repeat(" ", len(hex))
+				} else if !opts.Annotate {
+					length--
+					sb.WriteByte('^')
+				}
+			}
+			if opts.MarkUnblamable && e.unblamable {
+				length--
+				sb.WriteByte('*')
+			}
+			if opts.MarkIgnored && e.ignored {
+				length--
+				sb.WriteByte('?')
+			}
+			sb.WriteString(hex[:length])
+			if opts.Annotate {
+				fmt.Fprintf(&sb, "\t(%10s\t%10s\t%d)", name(info), formatTime(info), e.lno+1+i)
+			} else {
+				if showName {
+					fmt.Fprintf(&sb, " %-*s", longestFile, e.suspect.path)
+				}
+				if opts.ShowNumber {
+					fmt.Fprintf(&sb, " %*d", srcDigits, e.sLno+1+i)
+				}
+				if !opts.NoAuthor {
+					author := name(info)
+					fmt.Fprintf(&sb, " (%s%s %10s", author, strings.Repeat(" ", longestAuthor-displayWidth(author)), formatTime(info))
+				}
+				fmt.Fprintf(&sb, " %*d) ", dstDigits, e.lno+1+i)
+			}
+			sb.WriteString(blameLine(s.final[e.lno+i]))
+			if _, err := io.WriteString(w, sb.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Reads the revisions listed in the file named filename, one per line, for
+// blame to ignore. Comments starting with "#" and blank lines are skipped.
+func ReadIgnoreRevsFile(c *Client, filename string) ([]CommitID, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("fatal: could not open object name list: %v", filename)
+	}
+	var ids []CommitID
+	for _, line := range strings.Split(string(buf), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		id, err := CommitIDFromString(line)
+		if err != nil {
+			return nil, fmt.Errorf("fatal: invalid object name: %v", line)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
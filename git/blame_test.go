@@ -0,0 +1,146 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		A, B string
+		Want []lineHunk
+	}{
+		{"a b c", "a b c", nil},
+		{"a b c", "a x c", []lineHunk{{1, 1, 1, 1}}},
+		{"a b c", "a c", []lineHunk{{1, 1, 1, 0}}},
+		{"a c", "a b c", []lineHunk{{1, 0, 1, 1}}},
+		{"", "a b", []lineHunk{{0, 0, 0, 2}}},
+		// Inserted groups are slid down to end at a blank line, as
+		// git does.
+		{"} _ }", "} _ } _ }", []lineHunk{{3, 0, 3, 2}}},
+	}
+	for i, tc := range tests {
+		if got := diffLines(strings.Fields(tc.A), strings.Fields(tc.B)); !reflect.DeepEqual(got, tc.Want) {
+			t.Errorf("Test %d: got %v want %v", i, got, tc.Want)
+		}
+	}
+}
+
+func TestBlame(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitblame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := func(files ...string) Sha1 {
+		var buf bytes.Buffer
+		for i := 0; i < len(files); i += 2 {
+			id, err := c.WriteObject("blob", []byte(files[i+1]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&buf, "100644 %v\x00", files[i])
+			buf.Write(id[:])
+		}
+		id, err := c.WriteObject("tree", buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	commit := func(tree Sha1, author string, time int, parents ...CommitID) CommitID {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "tree %v\n", tree)
+		for _, p := range parents {
+			fmt.Fprintf(&buf, "parent %v\n", p)
+		}
+		fmt.Fprintf(&buf, "author %v <%v@example.com> %d +0000\ncommitter %v <%v@example.com> %d +0000\n\nmsg\n", author, author, time, author, author, time)
+		id, err := c.WriteObject("commit", buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return CommitID(id)
+	}
+	first := commit(tree("f", "one\ntwo\nthree\n"), "A", 1600000000)
+	second := commit(tree("f", "one\n2\nthree\nfour\n"), "B", 1600086400, first)
+	third := commit(tree("f", "one\n2\nthree\nfour\n", "g", "2\nthree\n"), "C", 1600172800, second)
+	unformatted := commit(tree("h", "int main(int argc, char **argv) {\n\tint x = compute(argc);\n\tif (x > 0) { return x; }\n\treturn 0;\n}\n"), "A", 1600000000)
+	reformat := commit(tree("h", "int\nmain(int argc, char **argv)\n{\n    int x = compute(argc);\n    if (x > 0) {\n        return x;\n    }\n    zzz();\n    return 0;\n}\n"), "B", 1600086400, unformatted)
+	unlike := commit(tree("h", "keep\nabc\nqqq\nend\n"), "A", 1600000000)
+	rewrite := commit(tree("h", "keep\nxyz\nwww\nvvv\nend\n"), "B", 1600086400, unlike)
+
+	tests := []struct {
+		Opts BlameOptions
+		Path IndexPath
+		Want string
+	}{
+		{
+			BlameOptions{Revision: &second},
+			"f",
+			fmt.Sprintf(`^%.7v (A 2020-09-13 12:26:40 +0000 1) one
+%.8v (B 2020-09-14 12:26:40 +0000 2) 2
+^%.7v (A 2020-09-13 12:26:40 +0000 3) three
+%.8v (B 2020-09-14 12:26:40 +0000 4) four
+`, first, second, first, second),
+		},
+		{
+			BlameOptions{Revision: &second, Ranges: []string{"2,+2"}, NoAuthor: true, ShowRoot: true},
+			"f",
+			fmt.Sprintf("%.8v 2) 2\n%.8v 3) three\n", second, first),
+		},
+		{
+			BlameOptions{Revision: &third, DetectCopies: 2, CopyScore: 1, NoAuthor: true, ShowName: true},
+			"g",
+			fmt.Sprintf("%.8v f 1) 2\n^%.7v f 2) three\n", second, first),
+		},
+		{
+			BlameOptions{Revision: &third, NoAuthor: true},
+			"g",
+			fmt.Sprintf("%.8v 1) 2\n%.8v 2) three\n", third, third),
+		},
+		{
+			// The same as git: each changed line is passed to the
+			// line it's most similar to, and the added line can't
+			// be.
+			BlameOptions{Revision: &reformat, IgnoreRevs: []CommitID{reformat}, NoAuthor: true, MarkIgnored: true, MarkUnblamable: true},
+			"h",
+			fmt.Sprintf(`^?%.6v  1) int
+^?%.6v  2) main(int argc, char **argv)
+^?%.6v  3) {
+^?%.6v  4)     int x = compute(argc);
+^?%.6v  5)     if (x > 0) {
+^?%.6v  6)         return x;
+^?%.6v  7)     }
+*%.7v  8)     zzz();
+^?%.6v  9)     return 0;
+^%.7v 10) }
+`, unformatted, unformatted, unformatted, unformatted, unformatted, unformatted, unformatted, reformat, unformatted, unformatted),
+		},
+		{
+			// Lines which aren't similar to any are passed to the
+			// line at the same offset in the hunk, if there is one.
+			BlameOptions{Revision: &rewrite, IgnoreRevs: []CommitID{rewrite}, NoAuthor: true, MarkIgnored: true, MarkUnblamable: true},
+			"h",
+			fmt.Sprintf("^%.7v 1) keep\n^?%.6v 2) xyz\n^?%.6v 3) www\n*%.7v 4) vvv\n^%.7v 5) end\n", unlike, unlike, unlike, rewrite, unlike),
+		},
+	}
+	for i, tc := range tests {
+		var buf bytes.Buffer
+		if err := Blame(c, tc.Opts, tc.Path, &buf); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+			continue
+		}
+		if got := buf.String(); got != tc.Want {
+			t.Errorf("Test %d: got\n%v\nwant\n%v", i, got, tc.Want)
+		}
+	}
+}
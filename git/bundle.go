@@ -0,0 +1,460 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	bundleV2Signature = "# v2 git bundle\n"
+	bundleV3Signature = "# v3 git bundle\n"
+)
+
+// A Bundle is a file containing a packfile and the refs that point into
+// it, which can be used to transfer objects between repositories without
+// a network connection.
+type Bundle struct {
+	// The bundle format version, either 2 or 3.
+	Version int
+
+	// Capabilities from a version 3 bundle, such as "object-format".
+	Capabilities map[string]string
+
+	// Commits which are required to be in the repository to use the
+	// pack in the bundle, because they're not included in it.
+	Prerequisites []Sha1
+
+	// The refs contained in the bundle.
+	Refs []Ref
+
+	// The file that the bundle was read from, positioned after the
+	// header so that r returns the packfile.
+	f *os.File
+	r *bufio.Reader
+}
+
+// Returns true if file exists and is a bundle.
+func isBundle(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var sig [len(bundleV2Signature)]byte
+	if _, err := io.ReadFull(f, sig[:]); err != nil {
+		return false
+	}
+	return string(sig[:]) == bundleV2Signature || string(sig[:]) == bundleV3Signature
+}
+
+// OpenBundle opens the bundle file and reads its header. The caller is
+// responsible for closing the returned Bundle.
+func OpenBundle(file string) (*Bundle, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not open '%s'", file)
+	}
+	b, err := readBundleHeader(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("'%s' does not look like a v2 or v3 bundle file: %v", file, err)
+	}
+	b.f = f
+	return b, nil
+}
+
+// Reads the header of a bundle from r, leaving r positioned at the start
+// of the packfile.
+func readBundleHeader(r *bufio.Reader) (*Bundle, error) {
+	b := &Bundle{r: r, Capabilities: make(map[string]string)}
+	sig, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	switch sig {
+	case bundleV2Signature:
+		b.Version = 2
+	case bundleV3Signature:
+		b.Version = 3
+	default:
+		return nil, fmt.Errorf("invalid signature")
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			// The end of the header
+			return b, nil
+		}
+		switch line[0] {
+		case '@':
+			if b.Version < 3 {
+				return nil, fmt.Errorf("capabilities are not supported in v2 bundles")
+			}
+			name, val := line[1:], ""
+			if eq := strings.IndexByte(name, '='); eq >= 0 {
+				name, val = name[:eq], name[eq+1:]
+			}
+			switch name {
+			case "object-format":
+				if val != "sha1" {
+					return nil, fmt.Errorf("unsupported object format '%s'", val)
+				}
+			default:
+				return nil, fmt.Errorf("unknown capability '%s'", name)
+			}
+			b.Capabilities[name] = val
+		case '-':
+			// Prerequisites may be followed by a comment, which
+			// is the subject of the commit.
+			id, err := Sha1FromString(strings.SplitN(line[1:], " ", 2)[0])
+			if err != nil {
+				return nil, err
+			}
+			b.Prerequisites = append(b.Prerequisites, id)
+		default:
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid ref line: %s", line)
+			}
+			id, err := Sha1FromString(parts[0])
+			if err != nil {
+				return nil, err
+			}
+			b.Refs = append(b.Refs, Ref{parts[1], id})
+		}
+	}
+}
+
+// Close closes the file that the bundle was read from.
+func (b *Bundle) Close() error {
+	if b.f == nil {
+		return nil
+	}
+	return b.f.Close()
+}
+
+// Returns the prerequisites of the bundle that aren't commits in the
+// repository.
+func (b *Bundle) missingPrerequisites(c *Client) []Sha1 {
+	var missing []Sha1
+	for _, id := range b.Prerequisites {
+		if have, _, err := c.HaveObject(id); !have || err != nil || id.Type(c) != "commit" {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// Returns an error if the repository lacks the prerequisites of b.
+func (b *Bundle) verify(c *Client) error {
+	missing := b.missingPrerequisites(c)
+	if len(missing) == 0 {
+		return nil
+	}
+	var msg bytes.Buffer
+	msg.WriteString("Repository lacks these prerequisite commits:")
+	for _, id := range missing {
+		fmt.Fprintf(&msg, "\n%v", id)
+	}
+	return fmt.Errorf("%s", msg.String())
+}
+
+type BundleCreateOptions struct {
+	// The bundle format version to write. Defaults to 2.
+	Version int
+}
+
+// BundleCreate creates a bundle named file, or writes it to stdout if file
+// is "-". The objects in the bundle are the ones reachable from the
+// revisions in args, and the refs are the ones named in args.
+func BundleCreate(c *Client, opts BundleCreateOptions, file string, args []string) error {
+	switch opts.Version {
+	case 0:
+		opts.Version = 2
+	case 2, 3:
+	default:
+		return fmt.Errorf("unsupported bundle version %d", opts.Version)
+	}
+	refs, includes, excludes, err := bundleRevisions(c, args)
+	if err != nil {
+		return err
+	}
+
+	var objects []Sha1
+	inpack := make(map[Sha1]struct{})
+	var commits []CommitID
+	if err := RevListCallback(c, RevListOptions{Objects: true}, includes, excludes, func(s Sha1) error {
+		if _, ok := inpack[s]; ok {
+			return nil
+		}
+		inpack[s] = struct{}{}
+		objects = append(objects, s)
+		if s.Type(c) == "commit" {
+			commits = append(commits, CommitID(s))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(refs) == 0 || len(commits) == 0 {
+		return fmt.Errorf("Refusing to create empty bundle.")
+	}
+	// Annotated tags aren't included by rev-list, but the refs point to
+	// them.
+	for _, ref := range refs {
+		for id := ref.Value; id.Type(c) == "tag"; {
+			if _, ok := inpack[id]; !ok {
+				inpack[id] = struct{}{}
+				objects = append(objects, id)
+			}
+			tag, err := c.GetTagObject(id)
+			if err != nil {
+				return err
+			}
+			if id, err = Sha1FromString(tag.GetHeader("object")); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The prerequisites are the parents of the commits in the bundle
+	// which were excluded.
+	var header bytes.Buffer
+	if opts.Version == 3 {
+		header.WriteString(bundleV3Signature)
+		header.WriteString("@object-format=sha1\n")
+	} else {
+		header.WriteString(bundleV2Signature)
+	}
+	prereqs := make(map[CommitID]struct{})
+	for _, cmt := range commits {
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return err
+		}
+		for _, p := range parents {
+			if _, ok := inpack[Sha1(p)]; ok {
+				continue
+			}
+			if _, ok := prereqs[p]; ok {
+				continue
+			}
+			prereqs[p] = struct{}{}
+			var subject string
+			if msg, err := p.GetCommitMessage(c); err == nil {
+				subject = msg.Subject()
+			}
+			fmt.Fprintf(&header, "-%v %s\n", p, subject)
+		}
+	}
+	for _, ref := range refs {
+		fmt.Fprintf(&header, "%v %s\n", ref.Value, ref.Name)
+	}
+	header.WriteString("\n")
+
+	if file == "-" {
+		if _, err := os.Stdout.Write(header.Bytes()); err != nil {
+			return err
+		}
+		_, err := PackObjects(c, PackObjectsOptions{}, os.Stdout, objects)
+		return err
+	}
+
+	// Write to a lock file so that a failure doesn't leave a partial
+	// bundle behind.
+	lock := file + ".lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	_, err = w.Write(header.Bytes())
+	if err == nil {
+		_, err = PackObjects(c, PackObjectsOptions{}, w, objects)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(lock)
+		return err
+	}
+	return os.Rename(lock, file)
+}
+
+// Resolves the revision arguments to bundle create into the refs to
+// record in the bundle and the commits to include and exclude.
+func bundleRevisions(c *Client, args []string) (refs []Ref, includes, excludes []Commitish, err error) {
+	allrefs, err := ShowRef(c, ShowRefOptions{}, nil)
+	if err != nil && len(allrefs) == 0 {
+		return nil, nil, nil, err
+	}
+	if head, err := c.GetHeadCommit(); err == nil {
+		allrefs = append([]Ref{{"HEAD", Sha1(head)}}, allrefs...)
+	}
+
+	seen := make(map[string]struct{})
+	addRef := func(ref Ref) error {
+		peeled, err := peelTag(c, ref.Value)
+		if err != nil {
+			return err
+		}
+		if peeled.Type(c) != "commit" {
+			return fmt.Errorf("%s does not point to a commit", ref.Name)
+		}
+		includes = append(includes, CommitID(peeled))
+		if _, ok := seen[ref.Name]; !ok {
+			seen[ref.Name] = struct{}{}
+			refs = append(refs, ref)
+		}
+		return nil
+	}
+	include := func(rev string) error {
+		if ref, ok := dwimRef(allrefs, rev); ok {
+			return addRef(ref)
+		}
+		cmt, err := RevParseCommit(c, &RevParseOptions{}, rev)
+		if err != nil {
+			return err
+		}
+		includes = append(includes, cmt)
+		return nil
+	}
+	exclude := func(rev string) error {
+		cmt, err := RevParseCommit(c, &RevParseOptions{}, rev)
+		if err != nil {
+			return err
+		}
+		excludes = append(excludes, cmt)
+		return nil
+	}
+	addRefs := func(prefix string) error {
+		for _, ref := range allrefs {
+			if ref.Name != "HEAD" && strings.HasPrefix(ref.Name, prefix) {
+				if err := addRef(ref); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		switch {
+		case arg == "--all":
+			err = addRefs("")
+			if head, ok := dwimRef(allrefs, "HEAD"); ok && err == nil {
+				err = addRef(head)
+			}
+		case arg == "--branches":
+			err = addRefs("refs/heads/")
+		case arg == "--tags":
+			err = addRefs("refs/tags/")
+		case arg == "--remotes":
+			err = addRefs("refs/remotes/")
+		case strings.HasPrefix(arg, "-"):
+			err = fmt.Errorf("unsupported option %s", arg)
+		case strings.HasPrefix(arg, "^"):
+			err = exclude(arg[1:])
+		case strings.Contains(arg, "..."):
+			err = fmt.Errorf("symmetric differences are not supported: %s", arg)
+		case strings.Contains(arg, ".."):
+			dots := strings.Index(arg, "..")
+			from, to := arg[:dots], arg[dots+2:]
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			if err = exclude(from); err == nil {
+				err = include(to)
+			}
+		default:
+			err = include(arg)
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return refs, includes, excludes, nil
+}
+
+// Returns the ref from refs which name refers to, using the same rules as
+// git for expanding short names.
+func dwimRef(refs []Ref, name string) (Ref, bool) {
+	for _, format := range []string{
+		"%s",
+		"refs/%s",
+		"refs/tags/%s",
+		"refs/heads/%s",
+		"refs/remotes/%s",
+		"refs/remotes/%s/HEAD",
+	} {
+		full := fmt.Sprintf(format, name)
+		for _, ref := range refs {
+			if ref.Name == full {
+				return ref, true
+			}
+		}
+	}
+	return Ref{}, false
+}
+
+// BundleVerify checks that file is a valid bundle which can be applied
+// to the repository, and returns it. The returned bundle is closed.
+func BundleVerify(c *Client, file string) (*Bundle, error) {
+	b, err := OpenBundle(file)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	if err := b.verify(c); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// BundleListHeads returns the refs in the bundle file which match one of
+// patterns, or all of them if there are no patterns.
+func BundleListHeads(file string, patterns []string) ([]Ref, error) {
+	b, err := OpenBundle(file)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	return getRefsV1(b.Refs, LsRemoteOptions{}, patterns)
+}
+
+type BundleUnbundleOptions struct {
+	// Show progress while indexing the pack.
+	Progress bool
+}
+
+// BundleUnbundle stores the objects from the bundle file in the repository
+// and returns the refs from the bundle. The refs are not updated.
+func BundleUnbundle(c *Client, opts BundleUnbundleOptions, file string) ([]Ref, error) {
+	b, err := OpenBundle(file)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	if err := b.verify(c); err != nil {
+		return nil, err
+	}
+	if _, err := IndexPack(c, IndexPackOptions{Verbose: opts.Progress}, b.r); err != nil {
+		return nil, err
+	}
+	return b.Refs, nil
+}
@@ -0,0 +1,204 @@
+package git
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadBundleHeader(t *testing.T) {
+	tests := []struct {
+		Header  string
+		Want    Bundle
+		WantErr bool
+	}{
+		{
+			"# v2 git bundle\n" +
+				"-5a6539cfe993c05de469f9cd95d0e9749d4202ca one\n" +
+				"f6ef43f3b43e40d661de45dfd103572fdb6bd7dc refs/heads/master\n\n",
+			Bundle{
+				Version:       2,
+				Capabilities:  map[string]string{},
+				Prerequisites: []Sha1{unsafeSha1FromString("5a6539cfe993c05de469f9cd95d0e9749d4202ca")},
+				Refs:          []Ref{{"refs/heads/master", unsafeSha1FromString("f6ef43f3b43e40d661de45dfd103572fdb6bd7dc")}},
+			},
+			false,
+		},
+		{
+			"# v3 git bundle\n" +
+				"@object-format=sha1\n" +
+				"f6ef43f3b43e40d661de45dfd103572fdb6bd7dc HEAD\n\n",
+			Bundle{
+				Version:      3,
+				Capabilities: map[string]string{"object-format": "sha1"},
+				Refs:         []Ref{{"HEAD", unsafeSha1FromString("f6ef43f3b43e40d661de45dfd103572fdb6bd7dc")}},
+			},
+			false,
+		},
+		// Capabilities are only valid in v3
+		{"# v2 git bundle\n@object-format=sha1\n\n", Bundle{}, true},
+		{"# v3 git bundle\n@object-format=sha256\n\n", Bundle{}, true},
+		{"# v3 git bundle\n@unknown\n\n", Bundle{}, true},
+		{"# v4 git bundle\n\n", Bundle{}, true},
+		// Missing the end of the header
+		{"# v2 git bundle\nf6ef43f3b43e40d661de45dfd103572fdb6bd7dc HEAD\n", Bundle{}, true},
+	}
+	for i, test := range tests {
+		got, err := readBundleHeader(bufio.NewReader(strings.NewReader(test.Header)))
+		if test.WantErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+			continue
+		}
+		got.r = nil
+		if !reflect.DeepEqual(*got, test.Want) {
+			t.Errorf("Test %d: got %+v want %+v", i, *got, test.Want)
+		}
+	}
+}
+
+func TestBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitbundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, env := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		if err := os.Setenv(env, "John Smith"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, env := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		if err := os.Setenv(env, "test@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srcdir := filepath.Join(dir, "src")
+	src, err := Init(nil, InitOptions{Quiet: true}, srcdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcdir); err != nil {
+		t.Fatal(err)
+	}
+	var commits []CommitID
+	for _, content := range []string{"foo\n", "bar\n"} {
+		if err := ioutil.WriteFile("foo.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Add(src, AddOptions{}, []File{"foo.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		// The body must not end up in the prerequisite lines of
+		// the bundle header.
+		msg := CommitMessage("commit " + strings.TrimSpace(content) + "\n\nThe body of the commit.\n")
+		cmt, err := Commit(src, CommitOptions{}, msg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, cmt)
+	}
+	first, second := commits[0], commits[1]
+
+	full := filepath.Join(dir, "full.bundle")
+	if err := BundleCreate(src, BundleCreateOptions{}, full, []string{"master"}); err != nil {
+		t.Fatal(err)
+	}
+	incremental := filepath.Join(dir, "incremental.bundle")
+	if err := BundleCreate(src, BundleCreateOptions{Version: 3}, incremental, []string{first.String() + "..master"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := BundleCreate(src, BundleCreateOptions{}, filepath.Join(dir, "empty.bundle"), []string{"master..master"}); err == nil {
+		t.Errorf("Expected error creating an empty bundle")
+	}
+
+	header, err := ioutil.ReadFile(incremental)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# v3 git bundle\n@object-format=sha1\n-" + first.String() + " commit foo\n" + second.String() + " refs/heads/master\n\n"; !strings.HasPrefix(string(header), want) {
+		t.Errorf("Unexpected bundle header: got %q want %q", header[:len(want)], want)
+	}
+
+	b, err := OpenBundle(incremental)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	if b.Version != 3 {
+		t.Errorf("Unexpected bundle version: got %v want 3", b.Version)
+	}
+	if want := []Sha1{Sha1(first)}; !reflect.DeepEqual(b.Prerequisites, want) {
+		t.Errorf("Unexpected prerequisites: got %v want %v", b.Prerequisites, want)
+	}
+	if want := []Ref{{"refs/heads/master", Sha1(second)}}; !reflect.DeepEqual(b.Refs, want) {
+		t.Errorf("Unexpected refs: got %v want %v", b.Refs, want)
+	}
+
+	// A repository without the first commit can't use the incremental
+	// bundle.
+	dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "dst.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BundleVerify(dst, incremental); err == nil || !strings.Contains(err.Error(), first.String()) {
+		t.Errorf("Unexpected error verifying bundle with missing prerequisites: %v", err)
+	}
+	if _, err := BundleVerify(dst, full); err != nil {
+		t.Errorf("Unexpected error verifying complete bundle: %v", err)
+	}
+
+	refs, err := BundleUnbundle(dst, BundleUnbundleOptions{}, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Ref{{"refs/heads/master", Sha1(second)}}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("Unexpected refs from unbundle: got %v want %v", refs, want)
+	}
+	for _, cmt := range commits {
+		if have, _, err := dst.HaveObject(Sha1(cmt)); !have || err != nil {
+			t.Errorf("Commit %v was not unbundled: %v", cmt, err)
+		}
+	}
+
+	// Fetch the incremental bundle into a repository which only has
+	// the first commit.
+	partial, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "partial.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstbundle := filepath.Join(dir, "first.bundle")
+	if err := UpdateRef(src, UpdateRefOptions{}, "refs/tags/first", first, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := BundleCreate(src, BundleCreateOptions{}, firstbundle, []string{"first"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FetchPack(partial, FetchPackOptions{All: true}, Remote(firstbundle), nil); err != nil {
+		t.Fatal(err)
+	}
+	if have, _, _ := partial.HaveObject(Sha1(second)); have {
+		t.Fatalf("Second commit unexpectedly in first bundle")
+	}
+	refs, err = FetchPack(partial, FetchPackOptions{}, Remote(incremental), []Refname{"refs/heads/master"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Ref{{"refs/heads/master", Sha1(second)}}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("Unexpected refs from fetching bundle: got %v want %v", refs, want)
+	}
+	if have, _, err := partial.HaveObject(Sha1(second)); !have || err != nil {
+		t.Errorf("Commit %v was not fetched from bundle: %v", second, err)
+	}
+}
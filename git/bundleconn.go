@@ -0,0 +1,109 @@
+package git
+
+import (
+	"fmt"
+	"log"
+)
+
+// A bundleConn is a remote which is a bundle file rather than a
+// repository. There's nothing to negotiate, so fetching from it is done
+// by fetchBundle instead of the pack protocol.
+type bundleConn struct {
+	*sharedRemoteConn
+
+	bundle *Bundle
+}
+
+var _ RemoteConn = &bundleConn{}
+
+func (b *bundleConn) OpenConn(srv GitService) error {
+	if srv != UploadPackService {
+		return fmt.Errorf("Can not push to a bundle")
+	}
+	bundle, err := OpenBundle(b.uri.Path)
+	if err != nil {
+		return err
+	}
+	log.Println("Reading bundle", b.uri.Path)
+	b.bundle = bundle
+	b.protocolversion = 1
+	b.refs = bundle.Refs
+	b.capabilities = make(map[string]map[string]struct{})
+	return nil
+}
+
+func (b *bundleConn) GetRefs(opts LsRemoteOptions, patterns []string) ([]Ref, error) {
+	if b.bundle == nil {
+		return nil, fmt.Errorf("Connection is not open")
+	}
+	return getRefsV1(b.refs, opts, patterns)
+}
+
+func (b *bundleConn) Close() error {
+	if b.bundle == nil {
+		return nil
+	}
+	return b.bundle.Close()
+}
+
+func (b *bundleConn) Write(data []byte) (int, error) {
+	return 0, fmt.Errorf("Can not write to a bundle")
+}
+
+func (b *bundleConn) Flush() error {
+	return fmt.Errorf("Can not write to a bundle")
+}
+
+func (b *bundleConn) Delim() error {
+	return fmt.Errorf("Can not write to a bundle")
+}
+
+// Fetches the objects from the bundle that conn refers to, and returns
+// the refs from it that were wanted.
+func fetchBundle(c *Client, opts FetchPackOptions, conn *bundleConn, wants []Refname) ([]Ref, error) {
+	patterns := make([]string, len(wants))
+	for i := range wants {
+		patterns[i] = string(wants[i])
+	}
+	refs, err := conn.GetRefs(LsRemoteOptions{Heads: true, Tags: true, RefsOnly: true}, patterns)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.bundle.verify(c); err != nil {
+		return nil, err
+	}
+	objects := make([]Sha1, 0, len(refs))
+	for _, ref := range refs {
+		objects = append(objects, ref.Value)
+	}
+	// Objects may also be wanted by ID, if they're the value of one of
+	// the bundle's refs.
+	for _, want := range wants {
+		sha, err := Sha1FromString(string(want))
+		if err != nil {
+			continue
+		}
+		for _, ref := range conn.refs {
+			if ref.Value == sha {
+				objects = append(objects, sha)
+				break
+			}
+		}
+	}
+	missing := false
+	for _, obj := range objects {
+		if have, _, err := c.HaveObject(obj); err != nil {
+			return nil, err
+		} else if !have {
+			missing = true
+		}
+	}
+	if !missing {
+		// Nothing wanted, already up to date.
+		return refs, nil
+	}
+	if _, err := IndexPack(c, IndexPackOptions{Verbose: opts.Verbose}, conn.bundle.r); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
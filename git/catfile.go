@@ -101,7 +101,7 @@ func CatFileBatch(c *Client, opts CatFileOptions, r io.Reader, w io.Writer) erro
 			fmt.Fprintf(w, "%v missing\n", id)
 			continue
 		}
-		obj, _ = RevParse(c, RevParseOptions{Quiet: true}, []string{id})
+		obj, _ = RevParse(c, RevParseOptions{Quiet: true, RevsOnly: true}, []string{id})
 		if len(obj) == 0 {
 			fmt.Fprintf(w, "%v missing\n", id)
 			continue
@@ -0,0 +1,55 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Options for check-mailmap.
+type CheckMailmapOptions struct {
+	// Read contacts from stdin, one per line, after those given as
+	// arguments.
+	Stdin bool
+}
+
+// CheckMailmap writes the canonical name and email of each contact of the
+// form "Name <user@host>" or "<user@host>" to w, as mapped by the mailmap.
+func CheckMailmap(c *Client, opts CheckMailmapOptions, contacts []string, w io.Writer) error {
+	if !opts.Stdin && len(contacts) == 0 {
+		return fmt.Errorf("fatal: no contacts specified")
+	}
+	mailmap, err := ReadMailmap(c)
+	if err != nil {
+		return err
+	}
+	for _, contact := range contacts {
+		if err := checkMailmap(mailmap, contact, w); err != nil {
+			return err
+		}
+	}
+	if !opts.Stdin {
+		return nil
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if err := checkMailmap(mailmap, scanner.Text(), w); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func checkMailmap(mailmap *Mailmap, contact string, w io.Writer) error {
+	name, email, _, ok := parseMailmapIdent(contact, true)
+	if !ok {
+		return fmt.Errorf("fatal: unable to parse contact: %s", contact)
+	}
+	name, email = mailmap.Map(name, email)
+	if name != "" {
+		fmt.Fprintf(w, "%s ", name)
+	}
+	fmt.Fprintf(w, "<%s>\n", email)
+	return nil
+}
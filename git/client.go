@@ -86,6 +86,10 @@ type objectLocation struct {
 	packfile File
 	index    *PackfileIndexV2
 	offset   int64
+
+	// The object directory that a loose object was found in, if it
+	// isn't the client's ObjectDir.
+	objdir string
 }
 
 type fileish interface {
@@ -107,6 +111,11 @@ type Client struct {
 
 	ObjectDir string
 
+	// Other object directories which are searched for objects that
+	// aren't in ObjectDir, from objects/info/alternates and the
+	// GIT_ALTERNATE_OBJECT_DIRECTORIES environment variable.
+	alternates []string
+
 	// This is used by the git-read-tree test suite. The description from the
 	// git man page is:
 	//
@@ -126,6 +135,10 @@ type Client struct {
 	// Cache of previous config lookups to avoid re-parsing.
 	configCache               map[string]string
 	localConfig, globalConfig *GitConfig
+
+	// Config variables set with SetCachedConfig, kept so that
+	// multi-valued variables can be looked up.
+	sessionConfig GitConfig
 }
 
 func (c *Client) Close() error {
@@ -236,8 +249,101 @@ func NewClient(gitDir, workDir string) (*Client, error) {
 			workdir = WorkDir(strings.TrimSuffix(gitdir.String(), "/.git"))
 		}
 	}
+	var alternates []string
+	if e := os.Getenv("GIT_ALTERNATE_OBJECT_DIRECTORIES"); e != "" {
+		for _, dir := range filepath.SplitList(e) {
+			alternates = appendAlternates(alternates, objdir, dir, 0)
+		}
+	}
+	alternates = appendAlternatesFile(alternates, objdir, objdir, 0)
+
 	m := make(map[Sha1]objectLocation)
-	return &Client{GitDir(gitdir), WorkDir(workdir), objdir, "", m, make(map[shaRef]GitObject), nil, nil, nil}, nil
+	return &Client{GitDir(gitdir), WorkDir(workdir), objdir, alternates, "", m, make(map[shaRef]GitObject), nil, nil, nil, GitConfig{}}, nil
+}
+
+// Appends the object directory dir, and any alternates that it has, to
+// alternates. Relative paths are relative to the object directory base.
+// Directories which are already in the list or don't exist are skipped.
+func appendAlternates(alternates []string, base, dir string, depth int) []string {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(base, dir)
+	}
+	dir = filepath.Clean(dir)
+	if !File(dir).IsDir() {
+		log.Printf("Ignoring non-existent alternate object directory %v\n", dir)
+		return alternates
+	}
+	for _, alt := range alternates {
+		if alt == dir {
+			return alternates
+		}
+	}
+	alternates = append(alternates, dir)
+	return appendAlternatesFile(alternates, base, dir, depth+1)
+}
+
+// Appends the alternates listed in the info/alternates file of the object
+// directory objdir.
+func appendAlternatesFile(alternates []string, base, objdir string, depth int) []string {
+	// Git limits the nesting of alternates to 5 levels to avoid
+	// loops.
+	if depth > 5 {
+		return alternates
+	}
+	data, err := ioutil.ReadFile(filepath.Join(objdir, "info", "alternates"))
+	if err != nil {
+		return alternates
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line == filepath.Clean(base) {
+			continue
+		}
+		alternates = appendAlternates(alternates, objdir, line, depth)
+	}
+	return alternates
+}
+
+// EnterRepo returns a client for the repository at dir, for use by
+// servers such as upload-pack. A leading ~ or ~user is expanded to the
+// home directory. Unless strict is set, dir may also name a work tree
+// containing a .git directory, or a bare repository without its ".git"
+// suffix.
+func EnterRepo(dir string, strict bool) (*Client, error) {
+	if strings.HasPrefix(dir, "~") {
+		name := dir[1:]
+		rest := ""
+		if slash := strings.IndexByte(name, '/'); slash >= 0 {
+			name, rest = name[:slash], name[slash+1:]
+		}
+		home := homeDir()
+		if name != "" {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' does not appear to be a git repository", dir)
+			}
+			home = u.HomeDir
+		}
+		dir = filepath.Join(home, rest)
+	}
+	dir = strings.TrimSuffix(dir, "/")
+	candidates := []string{dir}
+	if !strict {
+		candidates = []string{dir + ".git/.git", dir + "/.git", dir + ".git", dir}
+	}
+	for _, candidate := range candidates {
+		if File(filepath.Join(candidate, "HEAD")).Exists() && File(filepath.Join(candidate, "objects")).IsDir() {
+			abs, err := filepath.Abs(candidate)
+			if err != nil {
+				return nil, err
+			}
+			return NewClient(abs, "")
+		}
+	}
+	return nil, fmt.Errorf("'%s' does not appear to be a git repository", dir)
 }
 
 // Returns the branchname of the HEAD branch, or the empty string if the
@@ -565,31 +671,48 @@ func (c *Client) HaveObject(id Sha1) (found bool, packedfile File, err error) {
 		return true, val.packfile, nil
 	}
 
+	for _, objdir := range append([]string{c.ObjectDir}, c.alternates...) {
+		if found, packfile := c.findObject(objdir, id); found {
+			return true, packfile, nil
+		}
+	}
+
+	log.Printf("None of the pack files has object %s\n", id)
+	return false, "", nil
+}
+
+// Looks for the object id in the object directory objdir, and returns
+// whether it was found and the pack that it was found in.
+func (c *Client) findObject(objdir string, id Sha1) (bool, File) {
 	// First the easy case
 	file := filepath.Join(
-		c.ObjectDir,
+		objdir,
 		fmt.Sprintf("%02x", id[0]),
 		fmt.Sprintf("%018x", id[1:]),
 	)
 	if f := File(file); f.Exists() {
 		log.Printf("Object %s was found in the objects directory\n", id)
-		c.objectCache[id] = objectLocation{true, "", nil, 0}
-		return true, "", nil
+		loc := objectLocation{loose: true}
+		if objdir != c.ObjectDir {
+			loc.objdir = objdir
+		}
+		c.objectCache[id] = loc
+		return true, ""
 	}
 
 	// Then, check if it's in a pack file.
-	files, err := ioutil.ReadDir(filepath.Join(c.ObjectDir, "pack"))
+	files, err := ioutil.ReadDir(filepath.Join(objdir, "pack"))
 	if err != nil {
 		// The pack directory doesn't exist. It's not an error, but it definitely
 		// doesn't have the file..
 		log.Printf("No pack directories to search for object %s\n", id)
-		return false, "", nil
+		return false, ""
 	}
 	for _, fi := range files {
 		if filepath.Ext(fi.Name()) == ".idx" {
 			// It's ambiguous if Name() has the full path or not according to what
 			// ReadDir returns, so just be very cautious on how we open it.
-			name := File(filepath.Join(c.ObjectDir, "pack", filepath.Base(fi.Name())))
+			name := File(filepath.Join(objdir, "pack", filepath.Base(fi.Name())))
 			f, err := os.Open(name.String())
 			if err != nil {
 				log.Print(err)
@@ -601,14 +724,12 @@ func (c *Client) HaveObject(id Sha1) (found bool, packedfile File, err error) {
 				// We want to return the pack file, not the index.
 				f.Close()
 				log.Printf("Found object %s in pack file %s\n", id, fi.Name())
-				return true, pfile, nil
+				return true, pfile
 			}
 			f.Close()
 		}
 	}
-
-	log.Printf("None of the pack files has object %s\n", id)
-	return false, "", nil
+	return false, ""
 }
 
 // Sets a cached config for this session only. None of these configs
@@ -620,6 +741,7 @@ func (c *Client) SetCachedConfig(varname string, value string) {
 	}
 
 	c.configCache[varname] = value
+	c.sessionConfig.AddConfig(varname, value)
 }
 
 // Gets a cached config variable if it is there. Otherwise, it returns
@@ -632,16 +754,12 @@ func (c *Client) GetCachedConfig(varname string) string {
 	return c.configCache[varname]
 }
 
-// Loads a config variable for the git repo hosted by c.
-// If the local variable exists, it will be used, otherwise
-// it will use the global variable.
-// Non-existent variables will return the empty string.
-func (c *Client) GetConfig(varname string) string {
-	if c.configCache == nil {
-		c.configCache = make(map[string]string)
-	}
-	if val, ok := c.configCache[varname]; ok {
-		return val
+// Loads the local and global config files if they haven't been loaded
+// yet. Errors are not fatal, an unloadable config is treated as empty.
+func (c *Client) loadConfigs() {
+	if c.localConfig == nil && c.GitDir == "" {
+		// Not in a repository, so there's no local config.
+		c.localConfig = &GitConfig{}
 	}
 	if c.localConfig == nil {
 		config, err := LoadLocalConfig(c)
@@ -654,10 +772,6 @@ func (c *Client) GetConfig(varname string) string {
 			c.localConfig = &GitConfig{}
 		}
 	}
-	if val, _ := c.localConfig.GetConfig(varname); val != "" {
-		c.configCache[varname] = val
-		return val
-	}
 	if c.globalConfig == nil {
 		config, err := LoadGlobalConfig()
 		if err == nil {
@@ -669,6 +783,24 @@ func (c *Client) GetConfig(varname string) string {
 			c.globalConfig = &GitConfig{}
 		}
 	}
+}
+
+// Loads a config variable for the git repo hosted by c.
+// If the local variable exists, it will be used, otherwise
+// it will use the global variable.
+// Non-existent variables will return the empty string.
+func (c *Client) GetConfig(varname string) string {
+	if c.configCache == nil {
+		c.configCache = make(map[string]string)
+	}
+	if val, ok := c.configCache[varname]; ok {
+		return val
+	}
+	c.loadConfigs()
+	if val, _ := c.localConfig.GetConfig(varname); val != "" {
+		c.configCache[varname] = val
+		return val
+	}
 	if val, _ := c.globalConfig.GetConfig(varname); val != "" {
 		c.configCache[varname] = val
 		return val
@@ -676,6 +808,62 @@ func (c *Client) GetConfig(varname string) string {
 	return ""
 }
 
+// Returns all values of a multi-valued config variable, in the order that
+// git would read them: global config, then the local config, then any
+// values set for this session with SetCachedConfig.
+func (c *Client) GetConfigAll(varname string) []string {
+	c.loadConfigs()
+	var vals []string
+	vals = append(vals, c.globalConfig.GetConfigAll(varname)...)
+	vals = append(vals, c.localConfig.GetConfigAll(varname)...)
+	vals = append(vals, c.sessionConfig.GetConfigAll(varname)...)
+	return vals
+}
+
+// Returns all sections of the config files (global, local, then session
+// in that order) that have the given section name.
+func (c *Client) configSections(name string) []GitConfigSection {
+	c.loadConfigs()
+	var sections []GitConfigSection
+	sections = append(sections, c.globalConfig.GetConfigSections(name, "")...)
+	sections = append(sections, c.localConfig.GetConfigSections(name, "")...)
+	sections = append(sections, c.sessionConfig.GetConfigSections(name, "")...)
+	return sections
+}
+
+// GetURLConfig looks up a config variable which may be scoped to a URL, such
+// as http.<url>.sslVerify or credential.<url>.username. The value from the
+// subsection which most specifically matches urls is returned, with the
+// unscoped section.key being the least specific match.
+func (c *Client) GetURLConfig(section, urls, key string) string {
+	var val string
+	best := -1
+	for _, sec := range c.configSections(section) {
+		score := urlMatchScore(sec.Subsection(), urls)
+		if score < 0 || score < best {
+			continue
+		}
+		if v, ok := sec.Get(key); ok {
+			val, best = v, score
+		}
+	}
+	return val
+}
+
+// GetURLConfigAll returns all the values for a multi-valued config
+// variable which may be scoped to a URL (such as credential.<url>.helper.)
+// Values from every matching subsection are returned in config order.
+func (c *Client) GetURLConfigAll(section, urls, key string) []string {
+	var vals []string
+	for _, sec := range c.configSections(section) {
+		if urlMatchScore(sec.Subsection(), urls) < 0 {
+			continue
+		}
+		vals = append(vals, sec.GetAll(key)...)
+	}
+	return vals
+}
+
 // Returns the .git/objects directory.
 func (c *Client) GetObjectsDir() File {
 	if objdir := os.Getenv("GIT_OBJECT_DIRECTORY"); objdir != "" {
@@ -2,6 +2,7 @@ package git
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,13 +11,36 @@ import (
 type CloneOptions struct {
 	InitOptions
 	FetchPackOptions
-	Local                      bool
-	NoHardLinks                bool
-	Reference, ReferenceIfAble bool
-	Dissociate                 bool
-	Progress                   bool
-	NoCheckout                 bool
-	Mirror                     bool
+
+	// Copy the objects directly from the repository being cloned instead
+	// of going through the transport. This is the default when the
+	// repository is given as a path rather than a file:// URL.
+	Local bool
+
+	// Copy the object files of a local clone instead of hardlinking
+	// them.
+	NoHardLinks bool
+
+	// Use the objects of a local repository through
+	// objects/info/alternates instead of copying them.
+	Shared bool
+
+	// Borrow objects from the repository Reference through
+	// objects/info/alternates. If ReferenceIfAble is set, a Reference
+	// which isn't a local repository is only a warning.
+	Reference       string
+	ReferenceIfAble bool
+
+	// Copy any borrowed objects into the new repository and stop using
+	// alternates once the clone is done.
+	Dissociate bool
+
+	Progress   bool
+	NoCheckout bool
+
+	// Set up a mirror of the remote, which fetches all refs with
+	// "+refs/*:refs/*". Mirror implies Bare.
+	Mirror bool
 	// use name instead of origin as upstream remote.
 	Origin string
 	// Use branch instead of HEAD as default branch to checkout
@@ -39,109 +63,205 @@ func Clone(opts CloneOptions, rmt Remote, dst File) error {
 	// This basically does the following:
 	// 1. Verify preconditions
 	// 2. Init
-	// 3. Fetch-pack --all
+	// 3. Set up alternates or copy the objects for local clones
 	// 4. Set up some default config variables
-	// 5. UpdateRef master
-	// 6. Reset --hard
+	// 5. Fetch the refs from the remote
+	// 6. Point HEAD at the remote's HEAD branch
+	// 7. Reset --hard
+	if opts.Mirror {
+		opts.Bare = true
+	}
 	if dst == "" {
-		_, last := filepath.Split(rmt.String())
+		name := strings.TrimSuffix(strings.TrimRight(rmt.String(), "/"), "/.git")
+		_, last := filepath.Split(name)
+		if isSCPLikeURL(last) {
+			// host:repo, with no slash in the path
+			last = last[strings.IndexByte(last, ':')+1:]
+		}
+		if isBundle(rmt.String()) {
+			last = strings.TrimSuffix(last, ".bundle")
+		}
+		last = strings.TrimSuffix(last, ".git")
+		if opts.Bare {
+			last += ".git"
+		}
 		dst = File(last)
 	}
 	if dst.Exists() {
 		return fmt.Errorf("Directory %v already exists, can not clone.\n", dst)
 	}
-	c, err := Init(nil, opts.InitOptions, dst.String())
-	if err != nil {
-		return err
-	}
 
-	opts.FetchPackOptions.All = true
-	opts.FetchPackOptions.Verbose = true
+	// Find the repositories that objects are copied or borrowed from
+	// before creating anything.
+	var src *Client
+	if !isBundle(rmt.String()) {
+		path := strings.TrimPrefix(rmt.String(), "file://")
+		if opts.Local || path == rmt.String() {
+			src, _ = EnterRepo(path, false)
+		}
+	}
+	var alternates []string
+	if opts.Shared && src != nil {
+		alternates = append(alternates, src.ObjectDir)
+	}
+	if opts.Reference != "" {
+		ref, err := EnterRepo(opts.Reference, false)
+		if err != nil {
+			if !opts.ReferenceIfAble {
+				return fmt.Errorf("reference repository '%v' is not a local repository.", opts.Reference)
+			}
+			fmt.Fprintf(os.Stderr, "info: Could not add alternate for '%v': %v\n", opts.Reference, err)
+		} else {
+			alternates = append(alternates, ref.ObjectDir)
+		}
+	}
 
-	refs, err := FetchPack(c, opts.FetchPackOptions, rmt, nil)
+	c, err := Init(nil, opts.InitOptions, dst.String())
 	if err != nil {
 		return err
 	}
+	if src != nil && !opts.Shared {
+		if err := copyObjectDir(src.ObjectDir, c.ObjectDir, !opts.NoHardLinks); err != nil {
+			return err
+		}
+		// The copied objects may depend on objects that the source
+		// repository borrows from its own alternates.
+		alternates = append(alternates, src.alternates...)
+	}
+	if len(alternates) > 0 {
+		if err := os.MkdirAll(filepath.Join(c.ObjectDir, "info"), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(c.ObjectDir, "info", "alternates"), []byte(strings.Join(alternates, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
 	config, err := LoadLocalConfig(c)
 	if err != nil {
 		return err
 	}
 
-	br := opts.Branch
-	if br == "" {
-		br = "master"
-	}
 	org := opts.Origin
 	if opts.Origin == "" {
 		org = "origin"
 	}
-	if rmt.IsFile() {
+	url := rmt.String()
+	if rmt.IsFile() && !strings.HasPrefix(url, "file://") {
 		// the url in the config must point to an absolute path if
 		// passed on the command line as a relative one.
-		absurl, err := filepath.Abs(rmt.String())
-		if err != nil {
+		if url, err = filepath.Abs(url); err != nil {
 			return err
 		}
-		config.SetConfig(fmt.Sprintf("remote.%v.url", org), absurl)
+	}
+	config.SetConfig(fmt.Sprintf("remote.%v.url", org), url)
+	// A bare repository that isn't a mirror copies the branches once,
+	// but isn't set up to fetch them again.
+	var refs []RefSpec
+	if opts.Mirror {
+		config.SetConfig(fmt.Sprintf("remote.%v.fetch", org), "+refs/*:refs/*")
+		config.SetConfig(fmt.Sprintf("remote.%v.mirror", org), "true")
+	} else if opts.Bare {
+		refs = []RefSpec{"+refs/heads/*:refs/heads/*"}
 	} else {
-		config.SetConfig(fmt.Sprintf("remote.%v.url", org), rmt.String())
+		config.SetConfig(fmt.Sprintf("remote.%v.fetch", org), fmt.Sprintf("+refs/heads/*:refs/remotes/%v/*", org))
+	}
+	for k, v := range opts.Configs {
+		config.SetConfig(k, v)
 	}
-	config.SetConfig(fmt.Sprintf("branch.%v.remote", br), org)
-	// This should be smarter and get the HEAD symref from the connection.
-	// It isn't necessarily named refs/heads/master
-	config.SetConfig(fmt.Sprintf("branch.%v.merge", br), "refs/heads/master")
 	if err := config.WriteConfig(); err != nil {
 		return err
 	}
 
-	for _, ref := range refs {
-		if !strings.HasPrefix(ref.Name, "refs/heads/") {
-			// FIXME: This should have been done by GetRefs()
-			continue
-		}
-		refname := strings.Replace(ref.Name, "refs/heads/", "refs/remotes/"+org+"/", 1)
-		f := c.GitDir.File(File(refname))
-		cf, err := f.Create()
-		if err != nil {
+	// Use a new client, so that the config and alternates that were
+	// just written are used. The paths are made absolute, since the
+	// checkout below changes the working directory.
+	gitdir, err := filepath.Abs(c.GitDir.String())
+	if err != nil {
+		return err
+	}
+	workdir := ""
+	if !opts.Bare {
+		if workdir, err = filepath.Abs(c.WorkDir.String()); err != nil {
 			return err
 		}
-		cf.Close()
-		if err := f.Append(fmt.Sprintf("%v\n", ref.Value)); err != nil {
+	}
+	if c, err = NewClient(gitdir, workdir); err != nil {
+		return err
+	}
+	fopts := FetchOptions{Tags: !opts.NoTags, NoTags: opts.NoTags, FetchPackOptions: opts.FetchPackOptions}
+	fopts.FetchPackOptions.Quiet = opts.InitOptions.Quiet
+	result, err := fetch(c, fopts, Remote(org), refs)
+	if err != nil {
+		return err
+	}
+	if opts.Dissociate {
+		if err := dissociate(c); err != nil {
 			return err
 		}
 	}
-	// Now that we've populated all the remote names, we need to checkout
-	// the branch.
-	cmtish, err := RevParseCommitish(c, &RevParseOptions{}, org+"/"+br)
+
+	br := opts.Branch
+	if br == "" {
+		br = strings.TrimPrefix(result.Head, "refs/heads/")
+	}
+	if br == "" {
+		fmt.Fprintln(os.Stderr, "warning: You appear to have cloned an empty repository.")
+		return nil
+	}
+	tracking := RefSpec("refs/heads/" + br)
+	if !opts.Bare {
+		tracking = RefSpec(fmt.Sprintf("refs/remotes/%v/%v", org, br))
+	}
+	cmt, err := tracking.CommitID(c)
 	if err != nil {
-		return err
+		return fmt.Errorf("Remote branch %v not found in upstream %v", br, org)
+	}
+	if opts.Bare {
+		return SymbolicRefUpdate(c, SymbolicRefOptions{}, "HEAD", RefSpec("refs/heads/"+br), "")
 	}
-	cmt, err := cmtish.CommitID(c)
+
+	config, err = LoadLocalConfig(c)
 	if err != nil {
 		return err
 	}
-	// Update the master branch to point to the same commit as origin/master
+	config.SetConfig(fmt.Sprintf("branch.%v.remote", br), org)
+	config.SetConfig(fmt.Sprintf("branch.%v.merge", br), "refs/heads/"+br)
+	if err := config.WriteConfig(); err != nil {
+		return err
+	}
+	if strings.HasPrefix(result.Head, "refs/heads/") {
+		remotehead := RefSpec(fmt.Sprintf("refs/remotes/%v/%v", org, strings.TrimPrefix(result.Head, "refs/heads/")))
+		if err := SymbolicRefUpdate(c, SymbolicRefOptions{}, SymbolicRef(fmt.Sprintf("refs/remotes/%v/HEAD", org)), remotehead, ""); err != nil {
+			return err
+		}
+	}
+
+	// Create the local branch pointing to the same commit as the
+	// remote-tracking branch, and point HEAD to it.
 	if err := UpdateRefSpec(
 		c,
 		UpdateRefOptions{CreateReflog: true, OldValue: CommitID{}},
-		RefSpec("refs/heads/master"),
+		RefSpec("refs/heads/"+br),
 		cmt,
-		"clone: "+rmt.String(),
+		"clone: from "+url,
 	); err != nil {
 		return err
 	}
+	if err := SymbolicRefUpdate(c, SymbolicRefOptions{}, "HEAD", RefSpec("refs/heads/"+br), ""); err != nil {
+		return err
+	}
 
-	reflog, err := c.GitDir.ReadFile("logs/refs/heads/master")
+	reflog, err := c.GitDir.ReadFile(File("logs/refs/heads/" + br))
 	if err != nil {
 		return err
 	}
-	// HEAD is already pointing to refs/heads/master from init, but the
-	// logs/HEAD reflog isn't created yet. We cheat by just copying the
-	// one created by UpdateRefSpec above.
+	// The logs/HEAD reflog isn't created yet. We cheat by just copying
+	// the one created by UpdateRefSpec above.
 	if err := c.GitDir.WriteFile("logs/HEAD", reflog, 0755); err != nil {
 		return err
 	}
-	if opts.Bare {
+	if opts.NoCheckout {
 		return nil
 	}
 
@@ -175,3 +295,52 @@ func Clone(opts CloneOptions, rmt Remote, dst File) error {
 	c.GitDir = GitDir(filepath.Join(c.WorkDir.String(), ".git"))
 	return Reset(c, ResetOptions{Hard: true}, nil)
 }
+
+// Copies the files in the object directory src to the object directory
+// dst, hardlinking them if link is set and the filesystem allows it.
+// Files which already exist in dst are left alone.
+func copyObjectDir(src, dst string, link bool) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// info contains the alternates and packs files, which
+			// are specific to src.
+			if rel == "info" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		target := filepath.Join(dst, rel)
+		if File(target).Exists() {
+			return nil
+		}
+		if link {
+			if err := os.Link(path, target); err == nil {
+				return nil
+			}
+		}
+		return copyFile(path, target)
+	})
+}
+
+// Copies the objects that c borrows from its alternates into its own object
+// directory, and removes objects/info/alternates.
+func dissociate(c *Client) error {
+	for _, dir := range c.alternates {
+		if err := copyObjectDir(dir, c.ObjectDir, false); err != nil {
+			return err
+		}
+	}
+	err := os.Remove(filepath.Join(c.ObjectDir, "info", "alternates"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c.alternates = nil
+	return nil
+}
@@ -0,0 +1,153 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCloneLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitclone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcdir := filepath.Join(dir, "src")
+	src, err := Init(nil, InitOptions{Quiet: true}, srcdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcdir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(src, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := Commit(src, CommitOptions{}, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := TagCommit(src, TagOptions{Annotated: true}, "v1", cmt, "v1\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	// The loose object file for the commit, relative to the objects
+	// directory.
+	cmtfile := filepath.Join(cmt.String()[:2], cmt.String()[2:])
+
+	// Local clones hardlink the object files, unless told not to.
+	quiet := CloneOptions{InitOptions: InitOptions{Quiet: true}}
+	if err := Clone(quiet, Remote(srcdir), "linked"); err != nil {
+		t.Fatal(err)
+	}
+	srcinfo, err := os.Stat(filepath.Join(srcdir, ".git", "objects", cmtfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "linked", ".git", "objects", cmtfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(srcinfo, info) {
+		t.Errorf("Object file was not hardlinked")
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "linked", "foo.txt")); err != nil || string(data) != "foo\n" {
+		t.Errorf("Unexpected checkout: %q (%v)", data, err)
+	}
+
+	opts := quiet
+	opts.NoHardLinks = true
+	if err := Clone(opts, Remote(srcdir), "copied"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "copied", ".git", "objects", cmtfile)); err != nil || os.SameFile(srcinfo, info) {
+		t.Errorf("Object file was not copied: %v", err)
+	}
+
+	// Shared clones and clones with a reference use alternates instead
+	// of having their own objects.
+	opts = quiet
+	opts.Shared = true
+	if err := Clone(opts, Remote(srcdir), "shared"); err != nil {
+		t.Fatal(err)
+	}
+	alternates, err := ioutil.ReadFile(filepath.Join(dir, "shared", ".git", "objects", "info", "alternates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(alternates), filepath.Join(srcdir, ".git", "objects")+"\n"; got != want {
+		t.Errorf("Unexpected alternates: got %q want %q", got, want)
+	}
+	if File(filepath.Join(dir, "shared", ".git", "objects", cmtfile)).Exists() {
+		t.Errorf("Object was copied into shared clone")
+	}
+
+	opts = quiet
+	opts.Reference = "missing"
+	if err := Clone(opts, Remote("file://"+srcdir), "reference"); err == nil {
+		t.Errorf("Expected error cloning with a missing reference")
+	}
+	if File("reference").Exists() {
+		t.Errorf("Clone with a missing reference created a repository")
+	}
+	opts.Reference = "shared"
+	if err := Clone(opts, Remote("file://"+srcdir), "reference"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(filepath.Join(dir, "reference", ".git"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "shared", ".git", "objects"), filepath.Join(srcdir, ".git", "objects")}; !reflect.DeepEqual(c.alternates, want) {
+		t.Errorf("Unexpected alternates: got %v want %v", c.alternates, want)
+	}
+
+	// Dissociating copies the borrowed objects.
+	opts.Dissociate = true
+	if err := Clone(opts, Remote("file://"+srcdir), "dissociated"); err != nil {
+		t.Fatal(err)
+	}
+	if File(filepath.Join(dir, "dissociated", ".git", "objects", "info", "alternates")).Exists() {
+		t.Errorf("Alternates were not removed by dissociate")
+	}
+	if !File(filepath.Join(dir, "dissociated", ".git", "objects", cmtfile)).Exists() {
+		t.Errorf("Borrowed object was not copied by dissociate")
+	}
+
+	// Mirrors are bare and fetch every ref.
+	opts = quiet
+	opts.Mirror = true
+	if err := Clone(opts, Remote(srcdir), ""); err != nil {
+		t.Fatal(err)
+	}
+	c, err = NewClient(filepath.Join(dir, "src.git"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.GetConfig("core.bare"); got != "true" {
+		t.Errorf("Mirror is not bare: core.bare = %v", got)
+	}
+	if got := c.GetConfig("remote.origin.mirror"); got != "true" {
+		t.Errorf("Unexpected remote.origin.mirror: %v", got)
+	}
+	if got, want := c.GetConfigAll("remote.origin.fetch"), []string{"+refs/*:refs/*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected mirror refspecs: got %v want %v", got, want)
+	}
+	for _, ref := range []string{"refs/heads/master", "refs/tags/v1"} {
+		if !Refname(ref).Exists(c) {
+			t.Errorf("Ref %v was not mirrored", ref)
+		}
+	}
+	if head, err := c.GitDir.ReadFile("HEAD"); err != nil || strings.TrimSpace(string(head)) != "ref: refs/heads/master" {
+		t.Errorf("Unexpected mirror HEAD: %q (%v)", head, err)
+	}
+}
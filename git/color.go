@@ -0,0 +1,193 @@
+package git
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ANSI escape sequences for the colours that git uses by default.
+const (
+	colorReset       = "\x1b[m"
+	colorBold        = "\x1b[1m"
+	colorRed         = "\x1b[31m"
+	colorGreen       = "\x1b[32m"
+	colorYellow      = "\x1b[33m"
+	colorBlue        = "\x1b[34m"
+	colorMagenta     = "\x1b[35m"
+	colorCyan        = "\x1b[36m"
+	colorBgRed       = "\x1b[41m"
+	colorBoldRed     = "\x1b[1;31m"
+	colorBoldGreen   = "\x1b[1;32m"
+	colorBoldYellow  = "\x1b[1;33m"
+	colorBoldBlue    = "\x1b[1;34m"
+	colorBoldMagenta = "\x1b[1;35m"
+	colorBoldCyan    = "\x1b[1;36m"
+)
+
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// Attributes which can be used in a colour, and the SGR codes to turn them
+// on or off.
+var colorAttributes = []struct {
+	name    string
+	on, off uint
+}{
+	{"bold", 1, 22},
+	{"dim", 2, 22},
+	{"italic", 3, 23},
+	{"ul", 4, 24},
+	{"blink", 5, 25},
+	{"reverse", 7, 27},
+	{"strike", 9, 29},
+}
+
+// A colour parsed from a colour value.
+type ansiColor struct {
+	// One of the colorKind constants.
+	kind int
+
+	// The colour number for ANSI and 256 colour kinds, and the red,
+	// green and blue components for RGB colours.
+	value   int
+	r, g, b uint8
+}
+
+const (
+	colorKindNormal = iota
+	colorKindDefault
+	colorKindANSI
+	colorKindBright
+	colorKind256
+	colorKindRGB
+)
+
+// Returns the SGR parameters to use c as the foreground (or background)
+// colour, or the empty string for "normal".
+func (c ansiColor) code(foreground bool) string {
+	base := 30
+	if !foreground {
+		base = 40
+	}
+	switch c.kind {
+	case colorKindDefault:
+		return strconv.Itoa(base + 9)
+	case colorKindANSI:
+		return strconv.Itoa(base + c.value)
+	case colorKindBright:
+		return strconv.Itoa(base + 60 + c.value)
+	case colorKind256:
+		return fmt.Sprintf("%d;5;%d", base+8, c.value)
+	case colorKindRGB:
+		return fmt.Sprintf("%d;2;%d;%d;%d", base+8, c.r, c.g, c.b)
+	}
+	return ""
+}
+
+// ParseColor converts a colour in git's config syntax, such as "bold red" or
+// "reset #ff0000 blue", to an ANSI escape sequence. The first colour is the
+// foreground and the second the background. Values which don't change
+// anything, such as the empty string or "normal", result in an empty
+// sequence.
+func ParseColor(value string) (string, error) {
+	var colors []ansiColor
+	var reset bool
+	var attrs uint64
+	for _, word := range strings.Fields(value) {
+		if word == "reset" {
+			reset = true
+			continue
+		}
+		if color, ok := parseColorWord(word); ok {
+			if len(colors) == 2 {
+				return "", fmt.Errorf("invalid color value: %v", value)
+			}
+			colors = append(colors, color)
+			continue
+		}
+		attr, ok := parseColorAttribute(word)
+		if !ok {
+			return "", fmt.Errorf("invalid color value: %v", value)
+		}
+		attrs |= 1 << attr
+	}
+
+	var codes []string
+	if reset {
+		codes = append(codes, "")
+	}
+	for i := uint(0); attrs != 0; i++ {
+		if attrs&(1<<i) != 0 {
+			codes = append(codes, strconv.FormatUint(uint64(i), 10))
+			attrs &^= 1 << i
+		}
+	}
+	for i, color := range colors {
+		if code := color.code(i == 0); code != "" {
+			codes = append(codes, code)
+		}
+	}
+	switch {
+	case len(codes) == 0:
+		return "", nil
+	case reset && len(codes) == 1:
+		return colorReset, nil
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m", nil
+}
+
+// Parses a single colour word of a colour value, such as "red",
+// "brightblue", "200" or "#ff0000" and returns whether it was a colour.
+func parseColorWord(word string) (ansiColor, bool) {
+	switch word {
+	case "normal":
+		return ansiColor{kind: colorKindNormal}, true
+	case "default":
+		return ansiColor{kind: colorKindDefault}, true
+	}
+	for i, name := range colorNames {
+		if word == name {
+			return ansiColor{kind: colorKindANSI, value: i}, true
+		}
+		if word == "bright"+name {
+			return ansiColor{kind: colorKindBright, value: i}, true
+		}
+	}
+	if strings.HasPrefix(word, "#") && len(word) == 7 {
+		rgb, err := strconv.ParseUint(word[1:], 16, 32)
+		if err != nil {
+			return ansiColor{}, false
+		}
+		return ansiColor{kind: colorKindRGB, r: uint8(rgb >> 16), g: uint8(rgb >> 8), b: uint8(rgb)}, true
+	}
+	n, err := strconv.Atoi(word)
+	switch {
+	case err != nil, n < -1, n > 255:
+		return ansiColor{}, false
+	case n < 0:
+		return ansiColor{kind: colorKindNormal}, true
+	case n < 8:
+		return ansiColor{kind: colorKindANSI, value: n}, true
+	case n < 16:
+		return ansiColor{kind: colorKindBright, value: n - 8}, true
+	}
+	return ansiColor{kind: colorKind256, value: n}, true
+}
+
+// Returns the SGR code of the attribute word, such as "bold" or "no-ul".
+func parseColorAttribute(word string) (uint, bool) {
+	negate := false
+	if strings.HasPrefix(word, "no") {
+		negate = true
+		word = strings.TrimPrefix(strings.TrimPrefix(word, "no"), "-")
+	}
+	for _, attr := range colorAttributes {
+		if attr.name == word {
+			if negate {
+				return attr.off, true
+			}
+			return attr.on, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,719 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A combinedDiff is the change to a file made by a merge, compared to each
+// of the merge's parents at once.
+type combinedDiff struct {
+	Name    IndexPath
+	Parents []TreeEntry
+	Result  TreeEntry
+}
+
+// Returns the status of the file compared to the parent i, as shown by
+// --name-status.
+func (d combinedDiff) status(i int) string {
+	return HashDiff{Name: d.Name, Src: d.Parents[i], Dst: d.Result}.Status()
+}
+
+// Returns the files in tree which differ from every one of the parents,
+// which are the files shown in a combined diff. Only the files matching
+// paths are compared, unless paths is empty.
+func combinedDiffs(c *Client, parents []TreeID, tree TreeID, paths []IndexPath) ([]combinedDiff, error) {
+	var val []combinedDiff
+	for i, parent := range parents {
+		diffs, err := DiffTree(c, &DiffTreeOptions{Recurse: true}, parent, tree, nil)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			for _, diff := range diffs {
+				if len(paths) > 0 && !pathspecMatches(paths, diff.Name.String()) {
+					continue
+				}
+				val = append(val, combinedDiff{diff.Name, []TreeEntry{diff.Src}, diff.Dst})
+			}
+			continue
+		}
+		byName := make(map[IndexPath]TreeEntry, len(diffs))
+		for _, diff := range diffs {
+			byName[diff.Name] = diff.Src
+		}
+		var still []combinedDiff
+		for _, d := range val {
+			if src, ok := byName[d.Name]; ok {
+				d.Parents = append(d.Parents, src)
+				still = append(still, d)
+			}
+		}
+		val = still
+	}
+	return val, nil
+}
+
+// Writes diffs as a combined diff in the formats enabled in opts. The
+// diffstat formats show the changes from the first parent, which are in
+// firstParent. If dense is set, only the hunks which differ from all of
+// the parents are shown, as with --cc.
+func writeCombinedDiff(c *Client, w io.Writer, opts DiffCommonOptions, dense bool, diffs []combinedDiff, firstParent []HashDiff) error {
+	stat := opts.Stat || opts.NumStat || opts.ShortStat
+	if stat {
+		statOpts := opts
+		statOpts.Raw, statOpts.NameOnly, statOpts.NameStatus, statOpts.Patch = false, false, false, false
+		if err := GeneratePatch(c, statOpts, firstParent, w); err != nil {
+			return err
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	separator := stat
+	if opts.Raw || opts.NameStatus || opts.NameOnly {
+		for _, d := range diffs {
+			if opts.Raw {
+				fmt.Fprint(w, strings.Repeat(":", len(d.Parents)))
+				for _, p := range d.Parents {
+					fmt.Fprintf(w, "%0.6o ", p.FileMode)
+				}
+				fmt.Fprintf(w, "%0.6o", d.Result.FileMode)
+				for _, p := range d.Parents {
+					fmt.Fprintf(w, " %v", abbreviateRaw(c, p.Sha1, opts.Abbrev))
+				}
+				fmt.Fprintf(w, " %v ", abbreviateRaw(c, d.Result.Sha1, opts.Abbrev))
+			}
+			if opts.Raw || opts.NameStatus {
+				for i := range d.Parents {
+					fmt.Fprint(w, d.status(i))
+				}
+				fmt.Fprint(w, "\t")
+			}
+			fmt.Fprintf(w, "%v\n", d.Name)
+		}
+		separator = true
+	}
+	if opts.Patch {
+		if separator {
+			fmt.Fprintln(w)
+		}
+		for _, d := range diffs {
+			if err := d.writePatch(c, w, opts, dense); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns id abbreviated to n digits for raw diff output, or the full id
+// if n is 0.
+func abbreviateRaw(c *Client, id Sha1, n int) string {
+	if n <= 0 {
+		return id.String()
+	}
+	return abbreviateSha1(c, id, n)
+}
+
+// A line lost from some of the parents of a merge, which appears in the
+// combined diff before the line of the result that it was lost before.
+type combinedLostLine struct {
+	text string
+
+	// Each bit is set if the line was lost from that parent.
+	parents uint64
+}
+
+// A line of the result of a merge, with the lines lost from the parents
+// before it. The extra line after the end of the result only has the lines
+// lost at the end of the file.
+type combinedLine struct {
+	text string
+
+	// The lower bits are set if the line is not in the corresponding
+	// parent. The bit after them marks lines which are shown in a hunk,
+	// and the one after that marks context lines which are shown before
+	// a hunk, and so shouldn't show lost lines.
+	flag uint64
+
+	lost []combinedLostLine
+
+	// The lines lost from the parent being compared, which still need
+	// to be combined with lost.
+	plost []combinedLostLine
+
+	// The line number in each parent of the first line shown if a hunk
+	// starts with this line.
+	pLno []int
+}
+
+// Writes the combined diff of d, in the format of a single file of "git
+// diff -c", or "git diff --cc" if dense is set.
+func (d combinedDiff) writePatch(c *Client, w io.Writer, opts DiffCommonOptions, dense bool) error {
+	h := HashDiff{Name: d.Name, Dst: d.Result}
+	_, result, err := h.contents(c, d.Result)
+	if err != nil {
+		return err
+	}
+	numParents := len(d.Parents)
+	parents := make([][]byte, numParents)
+	binary := isBinary(result)
+	for i, p := range d.Parents {
+		if _, parents[i], err = h.contents(c, p); err != nil {
+			return err
+		}
+		binary = binary || isBinary(parents[i])
+	}
+	if binary {
+		d.writeHeader(c, w, opts.Color, dense, false, false)
+		fmt.Fprintln(w, "Binary files differ")
+		return nil
+	}
+
+	lines := splitLines(result)
+	cnt := len(lines)
+	sline := make([]combinedLine, cnt+2)
+	for i := range sline {
+		if i < cnt {
+			sline[i].text = lines[i]
+		}
+		sline[i].pLno = make([]int, numParents)
+	}
+	modeDiffers := false
+	for i, p := range d.Parents {
+		reused := false
+		for j := 0; j < i; j++ {
+			if p.Sha1 == d.Parents[j].Sha1 {
+				reuseCombinedDiff(sline, cnt, i, j)
+				reused = true
+				break
+			}
+		}
+		if !reused && d.Result.FileMode != 0 {
+			if err := combineDiff(sline, cnt, i, parents[i], result); err != nil {
+				return err
+			}
+		}
+		if p.FileMode != d.Result.FileMode {
+			modeDiffers = true
+		}
+	}
+
+	context := opts.NumContextLines
+	showHunks := makeCombinedHunks(sline, cnt, numParents, dense, context)
+	if !showHunks && !modeDiffers {
+		return nil
+	}
+	d.writeHeader(c, w, opts.Color, dense, modeDiffers, true)
+	if d.Result.FileMode != 0 {
+		dumpCombinedLines(w, sline, cnt, numParents, context, opts.Color)
+	}
+	return nil
+}
+
+// Writes the header of the combined diff of d. The modes are only included
+// if modeDiffers is set, and the ---/+++ lines if fileHeader is.
+func (d combinedDiff) writeHeader(c *Client, w io.Writer, color, dense, modeDiffers, fileHeader bool) {
+	meta, reset := "", ""
+	if color {
+		meta, reset = colorBold, colorReset
+	}
+	if dense {
+		fmt.Fprintf(w, "%vdiff --cc %v%v\n", meta, d.Name, reset)
+	} else {
+		fmt.Fprintf(w, "%vdiff --combined %v%v\n", meta, d.Name, reset)
+	}
+	fmt.Fprintf(w, "%vindex ", meta)
+	for i, p := range d.Parents {
+		if i > 0 {
+			fmt.Fprint(w, ",")
+		}
+		fmt.Fprint(w, abbreviateSha1(c, p.Sha1, 7))
+	}
+	fmt.Fprintf(w, "..%v%v\n", abbreviateSha1(c, d.Result.Sha1, 7), reset)
+
+	deleted := d.Result.FileMode == 0
+	added := false
+	if modeDiffers {
+		// It was added if none of the parents had it.
+		added = !deleted
+		for _, p := range d.Parents {
+			if p.FileMode != 0 {
+				added = false
+			}
+		}
+		if added {
+			fmt.Fprintf(w, "%vnew file mode %0.6o", meta, d.Result.FileMode)
+		} else {
+			if deleted {
+				fmt.Fprintf(w, "%vdeleted file ", meta)
+			}
+			fmt.Fprint(w, "mode ")
+			for i, p := range d.Parents {
+				if i > 0 {
+					fmt.Fprint(w, ",")
+				}
+				fmt.Fprintf(w, "%0.6o", p.FileMode)
+			}
+			if !deleted {
+				fmt.Fprintf(w, "..%0.6o", d.Result.FileMode)
+			}
+		}
+		fmt.Fprintf(w, "%v\n", reset)
+	}
+	if !fileHeader {
+		return
+	}
+	if added {
+		fmt.Fprintf(w, "%v--- /dev/null%v\n", meta, reset)
+	} else {
+		fmt.Fprintf(w, "%v--- a/%v%v\n", meta, d.Name, reset)
+	}
+	if deleted {
+		fmt.Fprintf(w, "%v+++ /dev/null%v\n", meta, reset)
+	} else {
+		fmt.Fprintf(w, "%v+++ b/%v%v\n", meta, d.Name, reset)
+	}
+}
+
+// Compares the parent n of a merge with its result, marking the lines of
+// sline which aren't in the parent, and adding the lines which were lost
+// from the parent.
+func combineDiff(sline []combinedLine, cnt, n int, parent, result []byte) error {
+	nmask := uint64(1) << uint(n)
+	patch, err := externalDiff(parent, result, "a", "b", 0)
+	if err != nil {
+		return err
+	}
+	var lost *combinedLine
+	var lno int
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "@@ ") {
+			_, _, nb, nn, ok := parseHunkHeader(line)
+			if !ok {
+				continue
+			}
+			lno = nb
+			// Lines removed without adding any are lost before
+			// the line after them.
+			if nn == 0 {
+				lost = &sline[nb]
+			} else {
+				lost = &sline[nb-1]
+			}
+			continue
+		}
+		if lost == nil || line == "" {
+			continue
+		}
+		switch line[0] {
+		case '-':
+			lost.plost = append(lost.plost, combinedLostLine{line[1:], nmask})
+		case '+':
+			sline[lno-1].flag |= nmask
+			lno++
+		}
+	}
+
+	// Combine the lost lines, and assign the line numbers in this
+	// parent.
+	pLno := 1
+	for lno := 0; lno <= cnt; lno++ {
+		sl := &sline[lno]
+		sl.pLno[n] = pLno
+		if len(sl.plost) > 0 {
+			sl.lost = coalesceLostLines(sl.lost, sl.plost, n)
+			sl.plost = nil
+		}
+		for _, ll := range sl.lost {
+			if ll.parents&nmask != 0 {
+				pLno++
+			}
+		}
+		if lno < cnt && sl.flag&nmask == 0 {
+			pLno++
+		}
+	}
+	sline[cnt+1].pLno[n] = pLno
+	return nil
+}
+
+// Reuses the comparison of the parent j for the parent i, which has the
+// same contents.
+func reuseCombinedDiff(sline []combinedLine, cnt, i, j int) {
+	imask, jmask := uint64(1)<<uint(i), uint64(1)<<uint(j)
+	for lno := 0; lno <= cnt+1; lno++ {
+		sl := &sline[lno]
+		sl.pLno[i] = sl.pLno[j]
+		if lno > cnt {
+			break
+		}
+		for k := range sl.lost {
+			if sl.lost[k].parents&jmask != 0 {
+				sl.lost[k].parents |= imask
+			}
+		}
+		if sl.flag&jmask != 0 {
+			sl.flag |= imask
+		}
+	}
+}
+
+// Merges the lines lost from the parent n into base, the lines lost from
+// the other parents, keeping the longest common subsequence of them as a
+// single line lost from several parents.
+func coalesceLostLines(base, lost []combinedLostLine, n int) []combinedLostLine {
+	if len(base) == 0 {
+		return lost
+	}
+	const (
+		match = iota
+		fromBase
+		fromNew
+	)
+	lcs := make([][]int, len(base)+1)
+	directions := make([][]int, len(base)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(lost)+1)
+		directions[i] = make([]int, len(lost)+1)
+		directions[i][0] = fromBase
+	}
+	for j := 1; j <= len(lost); j++ {
+		directions[0][j] = fromNew
+	}
+	for i := 1; i <= len(base); i++ {
+		for j := 1; j <= len(lost); j++ {
+			switch {
+			case base[i-1].text == lost[j-1].text:
+				lcs[i][j] = lcs[i-1][j-1] + 1
+				directions[i][j] = match
+			case lcs[i][j-1] >= lcs[i-1][j]:
+				lcs[i][j] = lcs[i][j-1]
+				directions[i][j] = fromNew
+			default:
+				lcs[i][j] = lcs[i-1][j]
+				directions[i][j] = fromBase
+			}
+		}
+	}
+
+	// Walk back from the end, building the result in reverse.
+	var reversed []combinedLostLine
+	i, j := len(base), len(lost)
+	for i != 0 || j != 0 {
+		switch directions[i][j] {
+		case match:
+			line := base[i-1]
+			line.parents |= uint64(1) << uint(n)
+			reversed = append(reversed, line)
+			i--
+			j--
+		case fromNew:
+			reversed = append(reversed, lost[j-1])
+			j--
+		default:
+			reversed = append(reversed, base[i-1])
+			i--
+		}
+	}
+	val := make([]combinedLostLine, len(reversed))
+	for k, line := range reversed {
+		val[len(val)-1-k] = line
+	}
+	return val
+}
+
+// Returns true if the line sl differs from any of the parents in
+// allMask.
+func combinedInteresting(sl *combinedLine, allMask uint64) bool {
+	return sl.flag&allMask != 0 || len(sl.lost) > 0
+}
+
+// Marks the lines of sline which are in hunks. If dense is set, hunks
+// which only have changes from one parent are left out. Returns true if
+// any lines are in hunks.
+func makeCombinedHunks(sline []combinedLine, cnt, numParents int, dense bool, context int) bool {
+	allMask := uint64(1)<<uint(numParents) - 1
+	mark := uint64(1) << uint(numParents)
+	for i := 0; i <= cnt; i++ {
+		if combinedInteresting(&sline[i], allMask) {
+			sline[i].flag |= mark
+		} else {
+			sline[i].flag &^= mark
+		}
+	}
+	if !dense {
+		return giveCombinedContext(sline, cnt, numParents, context)
+	}
+
+	i := 0
+	for i <= cnt {
+		for i <= cnt && sline[i].flag&mark == 0 {
+			i++
+		}
+		if cnt < i {
+			break
+		}
+		hunkBegin := i
+		j := i + 1
+		for ; j <= cnt; j++ {
+			if sline[j].flag&mark != 0 {
+				continue
+			}
+			// Look beyond the end to see if there is an
+			// interesting line after this hunk within the
+			// context.
+			la := adjustCombinedHunkTail(sline, allMask, hunkBegin, j)
+			if la+context < cnt+1 {
+				la += context
+			} else {
+				la = cnt + 1
+			}
+			contin := false
+			for la > 0 {
+				la--
+				if la < j {
+					break
+				}
+				if sline[la].flag&mark != 0 {
+					contin = true
+					break
+				}
+			}
+			if !contin {
+				break
+			}
+			j = la
+		}
+		hunkEnd := j
+
+		// The hunk is only interesting if there are more than two
+		// versions of it, or if the result doesn't match either of
+		// them.
+		var sameDiff uint64
+		hasInteresting := false
+		for j := i; j < hunkEnd && !hasInteresting; j++ {
+			if thisDiff := sline[j].flag & allMask; thisDiff != 0 {
+				if sameDiff == 0 {
+					sameDiff = thisDiff
+				} else if sameDiff != thisDiff {
+					hasInteresting = true
+					break
+				}
+			}
+			for _, ll := range sline[j].lost {
+				if hasInteresting {
+					break
+				}
+				if sameDiff == 0 {
+					sameDiff = ll.parents
+				} else if sameDiff != ll.parents {
+					hasInteresting = true
+				}
+			}
+		}
+		if !hasInteresting && sameDiff != allMask {
+			for j := hunkBegin; j < hunkEnd; j++ {
+				sline[j].flag &^= mark
+			}
+		}
+		i = hunkEnd
+	}
+	return giveCombinedContext(sline, cnt, numParents, context)
+}
+
+// Returns the end of the hunk starting at hunkBegin, which ends before
+// the uninteresting line i, for the purpose of adding trailing context.
+// If the last line of the hunk was only interesting because of lost lines
+// before it, it's shown after them, so it's already a context line.
+func adjustCombinedHunkTail(sline []combinedLine, allMask uint64, hunkBegin, i int) int {
+	if hunkBegin+1 <= i && sline[i-1].flag&allMask == 0 {
+		i--
+	}
+	return i
+}
+
+// Returns the next line from i which is marked with mark, or which isn't
+// if uninteresting is set.
+func findNextCombined(sline []combinedLine, mark uint64, i, cnt int, uninteresting bool) int {
+	for ; i <= cnt; i++ {
+		if (sline[i].flag&mark == 0) == uninteresting {
+			return i
+		}
+	}
+	return i
+}
+
+// Marks the lines around the interesting lines of sline as context, and
+// joins hunks which are close together. Returns true if there are any
+// interesting lines.
+func giveCombinedContext(sline []combinedLine, cnt, numParents, context int) bool {
+	allMask := uint64(1)<<uint(numParents) - 1
+	mark := uint64(1) << uint(numParents)
+	noPreDelete := uint64(2) << uint(numParents)
+
+	i := findNextCombined(sline, mark, 0, cnt, false)
+	if cnt < i {
+		return false
+	}
+	for i <= cnt {
+		j := 0
+		if context < i {
+			j = i - context
+		}
+		// Paint a few lines before the first interesting line.
+		for ; j < i; j++ {
+			if sline[j].flag&mark == 0 {
+				sline[j].flag |= noPreDelete
+			}
+			sline[j].flag |= mark
+		}
+		for {
+			// Up to i is included. Find where the next
+			// uninteresting line starts.
+			j = findNextCombined(sline, mark, i, cnt, true)
+			if cnt < j {
+				return true
+			}
+			k := findNextCombined(sline, mark, j, cnt, false)
+			j = adjustCombinedHunkTail(sline, allMask, i, j)
+			if k < j+context {
+				// The gap before the next interesting line
+				// is small, so join them.
+				for ; j < k; j++ {
+					sline[j].flag |= mark
+				}
+				i = k
+				continue
+			}
+			// Paint the trailing edge.
+			i = k
+			k = cnt + 1
+			if j+context < k {
+				k = j + context
+			}
+			for ; j < k; j++ {
+				sline[j].flag |= mark
+			}
+			break
+		}
+	}
+	return true
+}
+
+// Writes the hunks of the marked lines of sline.
+func dumpCombinedLines(w io.Writer, sline []combinedLine, cnt, numParents, context int, color bool) {
+	mark := uint64(1) << uint(numParents)
+	noPreDelete := uint64(2) << uint(numParents)
+	frag, old, new, reset := "", "", "", ""
+	if color {
+		frag, old, new, reset = colorCyan, colorRed, colorGreen, colorReset
+	}
+	markers := strings.Repeat("@", numParents+1)
+
+	lno := 0
+	for {
+		// The nearest preceding function line between the hunks is
+		// shown in the hunk header.
+		comment := ""
+		for lno <= cnt && sline[lno].flag&mark == 0 {
+			if t := sline[lno].text; t != "" && (t[0] == '_' || t[0] == '$' || t[0] >= 'a' && t[0] <= 'z' || t[0] >= 'A' && t[0] <= 'Z') {
+				comment = t
+			}
+			lno++
+		}
+		if cnt < lno {
+			return
+		}
+		hunkEnd := lno + 1
+		for ; hunkEnd <= cnt; hunkEnd++ {
+			if sline[hunkEnd].flag&mark == 0 {
+				break
+			}
+		}
+		rlines := hunkEnd - lno
+		if cnt < hunkEnd {
+			rlines--
+		}
+		nullContext := 0
+		if context == 0 {
+			// Lines which are only there to hang the lost lines
+			// before aren't shown without context.
+			for j := lno; j < hunkEnd; j++ {
+				if sline[j].flag&(mark-1) == 0 {
+					nullContext++
+				}
+			}
+			rlines -= nullContext
+		}
+
+		fmt.Fprint(w, frag+markers)
+		for i := 0; i < numParents; i++ {
+			l0, l1 := sline[lno].pLno[i], sline[hunkEnd].pLno[i]
+			fmt.Fprintf(w, " -%d,%d", l0, l1-l0-nullContext)
+		}
+		fmt.Fprintf(w, " +%d,%d %v", lno+1, rlines, markers)
+		if comment != "" {
+			// As in git, the comment is limited to 40 bytes
+			// and loses its last non-whitespace character.
+			end := 0
+			for i := 0; i < 40 && i < len(comment); i++ {
+				if !isSpace(comment[i]) {
+					end = i
+				}
+			}
+			if end > 0 {
+				fmt.Fprintf(w, "%v %v%v", reset, reset, comment[:end])
+			}
+		}
+		fmt.Fprintf(w, "%v\n", reset)
+
+		for lno < hunkEnd {
+			sl := &sline[lno]
+			lno++
+			if sl.flag&noPreDelete == 0 {
+				for _, ll := range sl.lost {
+					fmt.Fprint(w, old)
+					for j := 0; j < numParents; j++ {
+						if ll.parents&(uint64(1)<<uint(j)) != 0 {
+							fmt.Fprint(w, "-")
+						} else {
+							fmt.Fprint(w, " ")
+						}
+					}
+					writeLineToEOL(w, ll.text, reset)
+				}
+			}
+			if cnt < lno {
+				break
+			}
+			if sl.flag&(mark-1) == 0 {
+				if context == 0 {
+					continue
+				}
+			} else {
+				fmt.Fprint(w, new)
+			}
+			for j := 0; j < numParents; j++ {
+				if sl.flag&(uint64(1)<<uint(j)) != 0 {
+					fmt.Fprint(w, "+")
+				} else {
+					fmt.Fprint(w, " ")
+				}
+			}
+			writeLineToEOL(w, sl.text, reset)
+		}
+	}
+}
+
+// Writes line followed by reset and a newline, keeping any carriage
+// return at the end of the line after reset.
+func writeLineToEOL(w io.Writer, line, reset string) {
+	if strings.HasSuffix(line, "\r") {
+		fmt.Fprintf(w, "%v%v\r\n", line[:len(line)-1], reset)
+		return
+	}
+	fmt.Fprintf(w, "%v%v\n", line, reset)
+}
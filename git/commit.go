@@ -176,11 +176,10 @@ skipemptycheck:
 	}
 
 	// Update the reference
-	var refmsg string
-	if len(cleanMessage) < 50 {
-		refmsg = cleanMessage
-	} else {
-		refmsg = cleanMessage[:50]
+	// Reflog entries are a single line, so only use the subject.
+	refmsg := strings.SplitN(cleanMessage, "\n", 2)[0]
+	if len(refmsg) >= 50 {
+		refmsg = refmsg[:50]
 	}
 	refmsg = fmt.Sprintf("commit: %s (dgit)", refmsg)
 
@@ -235,7 +234,7 @@ func (cm CommitMessage) strip() string {
 }
 
 func (cm CommitMessage) Subject() string {
-	lines := strings.SplitN(cm.whitespace(), "\n", 1)
+	lines := strings.SplitN(cm.whitespace(), "\n", 2)
 	if len(lines) > 0 {
 		return strings.TrimSpace(lines[0])
 	}
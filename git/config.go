@@ -1,10 +1,10 @@
 package git
 
 import (
+	"bufio"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,137 +13,217 @@ import (
 // This file provides a stupid way of parsing git config files.
 // It's not very efficient, but for now it gets the job done.
 // (There's a lot more low hanging fruit before optimizing this..)
-type GitConfigValues map[string]string
+
+// A configEntry is a single "key = value" line in a config section. Keys
+// may appear more than once in a section for multi-valued variables such
+// as remote.<name>.fetch or credential.helper.
+type configEntry struct {
+	key, value string
+}
 
 type GitConfigSection struct {
 	name, subsection string
-	values           GitConfigValues
+	entries          []configEntry
 }
+
 type GitConfig struct {
 	sections []GitConfigSection
 	fname    string
 }
 
-func (g *GitConfig) SetConfig(name, value string) {
-	pieces := strings.Split(name, ".")
-	var key string
-	var sec *GitConfigSection
-	value = strings.TrimSpace(value)
-argChecker:
-	switch len(pieces) {
-	case 2:
-		key = strings.TrimSpace(pieces[1])
-		for _, section := range g.sections {
-			log.Printf("Comparing %s to %s\n", section.name, pieces[0])
-			if section.name == pieces[0] {
-				sec = &section
-				break argChecker
-			}
+// Returns the name of the section (ie "remote" for [remote "origin"])
+func (s GitConfigSection) Name() string {
+	return s.name
+}
+
+// Returns the subsection of the section (ie "origin" for [remote "origin"])
+func (s GitConfigSection) Subsection() string {
+	return s.subsection
+}
+
+// Get returns the last value of key in the section, and whether or not it
+// was found.
+func (s GitConfigSection) Get(key string) (string, bool) {
+	key = strings.ToLower(key)
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if s.entries[i].key == key {
+			return s.entries[i].value, true
 		}
-		log.Printf("Couldn't find %s, creating\n", pieces[0])
-		section := GitConfigSection{pieces[0], "", make(map[string]string, 0)}
-		sec = &section
-		g.sections = append(g.sections, section)
-	case 3:
-		key = strings.TrimSpace(pieces[2])
-		for _, section := range g.sections {
-			log.Printf("Comparing %s to %s and %s to %s\n", section.name, pieces[0], section.subsection, pieces[1])
-			if section.name == pieces[0] && section.subsection == pieces[1] {
-				sec = &section
-				break argChecker
-			}
+	}
+	return "", false
+}
+
+// GetAll returns all values of key in the section in the order that they
+// appear.
+func (s GitConfigSection) GetAll(key string) []string {
+	var vals []string
+	key = strings.ToLower(key)
+	for _, e := range s.entries {
+		if e.key == key {
+			vals = append(vals, e.value)
 		}
-		log.Printf("Couldn't find %s %s, creating\n", pieces[0], pieces[1])
-		section := GitConfigSection{pieces[0], pieces[1], make(map[string]string, 0)}
-		sec = &section
-		g.sections = append(g.sections, section)
 	}
+	return vals
+}
+
+// splitConfigName splits a variable name such as "remote.origin.url" into
+// its section, subsection and key. The subsection is everything between the
+// first and last dot, so that it may contain dots itself (as URLs in
+// credential.<url>.helper do.)
+func splitConfigName(name string) (section, subsection, key string, ok bool) {
+	first := strings.Index(name, ".")
+	last := strings.LastIndex(name, ".")
+	if first <= 0 || last == len(name)-1 {
+		return "", "", "", false
+	}
+	section = strings.ToLower(strings.TrimSpace(name[:first]))
+	key = strings.ToLower(strings.TrimSpace(name[last+1:]))
+	if first != last {
+		subsection = name[first+1 : last]
+	}
+	return section, subsection, key, true
+}
+
+// Returns the section named name and subsection, or nil if it doesn't
+// exist. If there are duplicate sections the last one is returned so that
+// new values get appended to the end of the file.
+func (g *GitConfig) findSection(name, subsection string) *GitConfigSection {
+	for i := len(g.sections) - 1; i >= 0; i-- {
+		if g.sections[i].name == name && g.sections[i].subsection == subsection {
+			return &g.sections[i]
+		}
+	}
+	return nil
+}
+
+// SetConfig sets the variable name to value, replacing any existing values.
+func (g *GitConfig) SetConfig(name, value string) {
+	section, subsection, key, ok := splitConfigName(name)
+	if !ok {
+		return
+	}
+	g.Unset(name)
+	g.addConfig(section, subsection, key, value)
+}
+
+// AddConfig adds a new line for the variable name without altering any
+// existing values, as "git config --add" does.
+func (g *GitConfig) AddConfig(name, value string) {
+	section, subsection, key, ok := splitConfigName(name)
+	if !ok {
+		return
+	}
+	g.addConfig(section, subsection, key, value)
+}
 
-	if sec != nil {
-		sec.values[key] = value
-	} else {
-		// TODO Always auto-create the sections
-		log.Printf("Couldn't find section %v\n", name)
+func (g *GitConfig) addConfig(section, subsection, key, value string) {
+	sec := g.findSection(section, subsection)
+	if sec == nil {
+		g.sections = append(g.sections, GitConfigSection{name: section, subsection: subsection})
+		sec = &g.sections[len(g.sections)-1]
 	}
+	sec.entries = append(sec.entries, configEntry{key, value})
 }
 
+// Unset removes all values of the variable name. It returns 5 if the
+// variable was not set (the same as the exit code of git config --unset)
+// and 0 otherwise.
 func (g *GitConfig) Unset(name string) int {
-	pieces := strings.Split(name, ".")
-	var key string
-	var sec *GitConfigSection
-
-argChecker:
-	switch len(pieces) {
-	case 2:
-		key = strings.TrimSpace(pieces[1])
-		for _, section := range g.sections {
-			log.Printf("Comparing %s to %s\n", section.name, pieces[0])
-			if section.name == pieces[0] {
-				sec = &section
-				break argChecker
-			}
+	section, subsection, key, ok := splitConfigName(name)
+	if !ok {
+		return 5
+	}
+	found := false
+	for i := range g.sections {
+		sec := &g.sections[i]
+		if sec.name != section || sec.subsection != subsection {
+			continue
 		}
-	case 3:
-		key = strings.TrimSpace(pieces[2])
-		for _, section := range g.sections {
-			if section.name == pieces[0] && section.subsection == pieces[1] {
-				sec = &section
-				break argChecker
+		kept := sec.entries[:0]
+		for _, e := range sec.entries {
+			if e.key == key {
+				found = true
+				continue
 			}
+			kept = append(kept, e)
 		}
+		sec.entries = kept
 	}
-
-	if sec != nil {
-		if _, ok := sec.values[key]; !ok {
-			return 5
-		}
-		delete(sec.values, key)
-		return 0
-	} else {
+	if !found {
 		return 5
 	}
+	return 0
 }
 
-func (g *GitConfig) GetConfig(name string) (string, int) {
-
-	pieces := strings.Split(name, ".")
-
-	switch len(pieces) {
-	case 2:
-		for _, section := range g.sections {
-			if section.name == pieces[0] {
-				val, ok := section.values[pieces[1]]
-				if !ok {
-					return "", 1
-				}
-				return val, 0
-			}
+// RemoveSection removes the section name with the given subsection (and any
+// duplicates of it) from the config. It returns false if no such section
+// existed.
+func (g *GitConfig) RemoveSection(name, subsection string) bool {
+	name = strings.ToLower(name)
+	kept := g.sections[:0]
+	found := false
+	for _, sec := range g.sections {
+		if sec.name == name && sec.subsection == subsection {
+			found = true
+			continue
 		}
-	case 3:
-		for _, section := range g.sections {
-			if section.name == pieces[0] && section.subsection == pieces[1] {
-				val, ok := section.values[pieces[2]]
-				if !ok {
-					return "", 1
-				}
-				return val, 0
-			}
+		kept = append(kept, sec)
+	}
+	g.sections = kept
+	return found
+}
+
+// RenameSection renames all sections name.oldsub to name.newsub. It
+// returns false if there was no section to rename.
+func (g *GitConfig) RenameSection(name, oldsub, newsub string) bool {
+	name = strings.ToLower(name)
+	found := false
+	for i := range g.sections {
+		if g.sections[i].name == name && g.sections[i].subsection == oldsub {
+			g.sections[i].subsection = newsub
+			found = true
 		}
+	}
+	return found
+}
 
+// GetConfig returns the value of the variable name. If the variable has
+// multiple values, the last one wins. The int returned is 0 if the
+// variable was found and 1 if it wasn't (the same as the exit code of
+// git config --get.)
+func (g *GitConfig) GetConfig(name string) (string, int) {
+	vals := g.GetConfigAll(name)
+	if len(vals) == 0 {
+		return "", 1
 	}
+	return vals[len(vals)-1], 0
+}
 
-	return "", 1
+// GetConfigAll returns all the values of the variable name in the order
+// that they appear in the config.
+func (g *GitConfig) GetConfigAll(name string) []string {
+	section, subsection, key, ok := splitConfigName(name)
+	if !ok {
+		return nil
+	}
+	var vals []string
+	for _, sec := range g.sections {
+		if sec.name == section && sec.subsection == subsection {
+			vals = append(vals, sec.GetAll(key)...)
+		}
+	}
+	return vals
 }
 
 func (g *GitConfig) GetConfigList() []string {
 	list := []string{}
 
 	for _, section := range g.sections {
-		for key, value := range section.values {
+		for _, e := range section.entries {
 			if section.subsection != "" {
-				list = append(list, section.name+"."+section.subsection+"."+key+"="+value)
+				list = append(list, section.name+"."+section.subsection+"."+e.key+"="+e.value)
 			} else {
-				list = append(list, section.name+"."+key+"="+value)
+				list = append(list, section.name+"."+e.key+"="+e.value)
 			}
 		}
 	}
@@ -156,7 +236,7 @@ func (g *GitConfig) GetConfigList() []string {
 func (g *GitConfig) GetConfigSections(name, subsection string) []GitConfigSection {
 	matches := make([]GitConfigSection, 0, len(g.sections))
 	for _, sect := range g.sections {
-		if name != "" && sect.name != name {
+		if name != "" && sect.name != strings.ToLower(name) {
 			continue
 		}
 		if subsection != "" && subsection != sect.subsection {
@@ -169,122 +249,286 @@ func (g *GitConfig) GetConfigSections(name, subsection string) []GitConfigSectio
 
 func (g GitConfig) WriteFile(w io.Writer) {
 	for _, section := range g.sections {
+		if len(section.entries) == 0 {
+			continue
+		}
 		if section.subsection == "" {
 			fmt.Fprintf(w, "[%s]\n", section.name)
 		} else {
-			fmt.Fprintf(w, "[%s \"%s\"]\n", section.name, section.subsection)
+			sub := strings.Replace(section.subsection, `\`, `\\`, -1)
+			sub = strings.Replace(sub, `"`, `\"`, -1)
+			fmt.Fprintf(w, "[%s \"%s\"]\n", section.name, sub)
 		}
 
-		for key, value := range section.values {
-			fmt.Fprintf(w, "\t%s = %s\n", key, value)
+		for _, e := range section.entries {
+			fmt.Fprintf(w, "\t%s = %s\n", e.key, quoteConfigValue(e.value))
 		}
 
 	}
 }
-func (s *GitConfigSection) ParseValues(valueslines string) {
-	lines := strings.Split(valueslines, "\n")
-	s.values = make(map[string]string)
 
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" {
+// quoteConfigValue quotes v if it would otherwise not survive being parsed
+// back from a config file.
+func quoteConfigValue(v string) string {
+	if v == "" {
+		return v
+	}
+	needsQuote := v[0] == ' ' || v[0] == '\t' ||
+		v[len(v)-1] == ' ' || v[len(v)-1] == '\t' ||
+		strings.ContainsAny(v, "#;")
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	v = strings.Replace(v, "\n", `\n`, -1)
+	v = strings.Replace(v, "\t", `\t`, -1)
+	if needsQuote {
+		return `"` + v + `"`
+	}
+	return v
+}
+
+// ParseValues parses the "key = value" lines of a section.
+func (s *GitConfigSection) ParseValues(valueslines string) {
+	for _, line := range strings.Split(valueslines, "\n") {
+		key, value, ok := parseConfigLine(line)
+		if !ok {
 			continue
 		}
-		split := strings.Split(trimmed, "=")
-		if len(split) < 1 {
-			panic("couldn't parse value")
-		}
-		varname := strings.TrimSpace(split[0])
-
-		log.Printf("Parsed config variable %v\n", varname)
-		s.values[varname] = strings.TrimSpace(strings.Join(split[1:], "="))
+		s.entries = append(s.entries, configEntry{key, value})
+	}
+}
 
+// parseConfigLine parses a single "key = value" line, handling quoting,
+// escapes and comments. A key with no "=" is a boolean which is true, as it
+// is with git, so it's returned with the value "true".
+func parseConfigLine(line string) (key, value string, ok bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';' {
+		return "", "", false
+	}
+	eq := strings.IndexByte(trimmed, '=')
+	if eq < 0 {
+		key = trimmed
+		if idx := strings.IndexAny(key, "#;"); idx >= 0 {
+			key = key[:idx]
+		}
+		return strings.ToLower(strings.TrimSpace(key)), "true", true
 	}
+	key = strings.ToLower(strings.TrimSpace(trimmed[:eq]))
+
+	var val strings.Builder
+	// The length of val the last time that we were inside quotes or
+	// appended an escape, so that trailing whitespace can be trimmed
+	// without trimming whitespace that was explicitly quoted.
+	var keep int
+	inquote := false
+	raw := strings.TrimLeft(trimmed[eq+1:], " \t")
+parse:
+	for i := 0; i < len(raw); i++ {
+		switch ch := raw[i]; ch {
+		case '"':
+			inquote = !inquote
+			keep = val.Len()
+		case '\\':
+			if i+1 >= len(raw) {
+				break parse
+			}
+			i++
+			switch raw[i] {
+			case 'n':
+				val.WriteByte('\n')
+			case 't':
+				val.WriteByte('\t')
+			case 'b':
+				val.WriteByte('\b')
+			default:
+				val.WriteByte(raw[i])
+			}
+			keep = val.Len()
+		case '#', ';':
+			if !inquote {
+				break parse
+			}
+			val.WriteByte(ch)
+			keep = val.Len()
+		default:
+			val.WriteByte(ch)
+			if inquote {
+				keep = val.Len()
+			}
+		}
+	}
+	value = val.String()
+	value = value[:keep] + strings.TrimRight(value[keep:], " \t")
+	return key, value, true
 }
 
 func (s *GitConfigSection) ParseSectionHeader(headerline string) {
-	s.name = headerline
-	parsingSubsection := false
-	subsectionStart := 0
-	for idx, b := range headerline {
-
-		if b == '"' && parsingSubsection == true {
-
-			parsingSubsection = true
-			s.subsection = strings.TrimSpace(headerline[subsectionStart:idx])
+	headerline = strings.TrimSpace(headerline)
+	quote := strings.IndexByte(headerline, '"')
+	if quote < 0 {
+		// Deprecated [section.subsection] syntax, where the
+		// subsection is case insensitive.
+		if dot := strings.IndexByte(headerline, '.'); dot >= 0 {
+			s.name = strings.ToLower(headerline[:dot])
+			s.subsection = strings.ToLower(headerline[dot+1:])
+			return
+		}
+		s.name = strings.ToLower(headerline)
+		return
+	}
+	s.name = strings.ToLower(strings.TrimSpace(headerline[:quote]))
+	var sub strings.Builder
+	for i := quote + 1; i < len(headerline); i++ {
+		switch headerline[i] {
+		case '\\':
+			if i+1 < len(headerline) {
+				i++
+				sub.WriteByte(headerline[i])
+			}
+		case '"':
+			s.subsection = sub.String()
+			return
+		default:
+			sub.WriteByte(headerline[i])
+		}
+	}
+	s.subsection = sub.String()
+}
 
+func ParseConfig(configFile io.Reader) GitConfig {
+	var config GitConfig
+	var cur *GitConfigSection
+
+	scanner := bufio.NewScanner(configFile)
+	var pending string
+	for scanner.Scan() {
+		line := pending + scanner.Text()
+		pending = ""
+		// A trailing backslash continues the value onto the next
+		// line.
+		if strings.HasSuffix(line, `\`) && !strings.HasSuffix(line, `\\`) {
+			pending = strings.TrimSuffix(line, `\`)
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			end := strings.LastIndex(trimmed, "]")
+			if end < 0 {
+				continue
+			}
+			var sec GitConfigSection
+			sec.ParseSectionHeader(trimmed[1:end])
+			if existing := config.findSection(sec.name, sec.subsection); existing != nil {
+				cur = existing
+			} else {
+				config.sections = append(config.sections, sec)
+				cur = &config.sections[len(config.sections)-1]
+			}
+			// Values are allowed on the same line as the header.
+			trimmed = trimmed[end+1:]
 		}
-		if b == '"' && parsingSubsection == false {
-			parsingSubsection = true
-			subsectionStart = idx + 1
+		if cur == nil {
+			continue
+		}
+		if key, value, ok := parseConfigLine(trimmed); ok {
+			cur.entries = append(cur.entries, configEntry{key, value})
+		}
+	}
+	if pending != "" && cur != nil {
+		if key, value, ok := parseConfigLine(pending); ok {
+			cur.entries = append(cur.entries, configEntry{key, value})
+		}
+	}
+	return config
+}
 
-			s.name = strings.TrimSpace(headerline[0:idx])
+// urlMatchScore determines how well the subsection of a URL scoped config
+// section (such as [http "https://example.com/"]) matches the URL u. It
+// returns -1 if pattern doesn't match u, and otherwise a score where
+// higher is a more specific match. An empty pattern is the unscoped section
+// and always matches with a score of 0.
+//
+// As with git, the pattern's scheme and host must match (a host label of
+// "*" matches any single label), the pattern's path must be a prefix of
+// u's path on a path segment boundary, and if the pattern includes a user
+// it must be the same as u's user. Longer path matches beat exact host
+// matches, which beat user matches.
+func urlMatchScore(pattern, u string) int {
+	if pattern == "" {
+		return 0
+	}
+	p, err := url.Parse(pattern)
+	if err != nil || p.Host == "" {
+		return -1
+	}
+	target, err := url.Parse(u)
+	if err != nil {
+		return -1
+	}
+	if !strings.EqualFold(p.Scheme, target.Scheme) {
+		return -1
+	}
+	if urlPort(p) != urlPort(target) {
+		return -1
+	}
 
+	score := 1
+	phost := strings.Split(strings.ToLower(p.Hostname()), ".")
+	thost := strings.Split(strings.ToLower(target.Hostname()), ".")
+	if len(phost) != len(thost) {
+		return -1
+	}
+	exact := true
+	for i := range phost {
+		if phost[i] == "*" {
+			exact = false
+			continue
+		}
+		if phost[i] != thost[i] {
+			return -1
 		}
 	}
-	if subsectionStart == 0 {
-		s.name = headerline
+	if exact {
+		score += 2
 	}
-}
 
-func ParseConfig(configFile io.Reader) GitConfig {
-	rawdata, _ := ioutil.ReadAll(configFile)
-	section := &GitConfigSection{}
-	parsingSectionName := false
-	parsingValues := false
-	var sect *GitConfigSection = nil
-	var sections []GitConfigSection
-	lastBracket := 0
-	lastClosingBracket := 0
-
-	for idx, b := range rawdata {
-		if b == '[' && parsingSectionName == false {
-
-			parsingSectionName = true
-			lastBracket = idx
-			if parsingValues == true {
-				section.ParseValues(string(rawdata[lastClosingBracket+1 : idx]))
-				parsingValues = false
-
-				if sect != nil {
-					for k, v := range section.values {
-						sect.values[k] = v
-					}
-					sect = nil
-				} else {
-					sections = append(sections, *section)
-				}
-			}
-			section = &GitConfigSection{}
+	if p.User != nil {
+		if target.User == nil || p.User.Username() != target.User.Username() {
+			return -1
 		}
-		if b == ']' && parsingSectionName == true {
-			section.ParseSectionHeader(string(rawdata[lastBracket+1 : idx]))
-
-			for _, s := range sections {
-				if s.name == section.name && s.subsection == section.subsection {
-					sect = &s
-					break
-				}
-			}
+		score += 1
+	}
 
-			parsingValues = true
-			parsingSectionName = false
-			lastClosingBracket = idx
+	ppath := strings.TrimSuffix(p.Path, "/")
+	tpath := target.Path
+	if ppath != "" {
+		if !strings.HasPrefix(tpath, ppath) {
+			return -1
 		}
-		if idx == len(rawdata)-1 && parsingValues == true {
-			section.ParseValues(string(rawdata[lastClosingBracket+1 : idx]))
-
-			if sect != nil {
-				for k, v := range section.values {
-					sect.values[k] = v
-				}
-				sect = nil
-			} else {
-				sections = append(sections, *section)
-			}
+		if len(tpath) > len(ppath) && tpath[len(ppath)] != '/' {
+			return -1
 		}
 	}
-	return GitConfig{sections: sections}
+	return score + 4*len(ppath)
+}
+
+// Returns the port of u, or the default port for the scheme if it's not
+// explicitly specified.
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	case "ssh":
+		return "22"
+	case "git":
+		return "9418"
+	}
+	return ""
 }
 
 func findGlobalConfigFile() (string, error) {
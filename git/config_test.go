@@ -0,0 +1,158 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigParsing(t *testing.T) {
+	config := ParseConfig(strings.NewReader(`# A comment
+[core]
+	Bare = false ; trailing comment
+	editor = "vim -c 'set tw=72'"
+[remote "origin"]
+	url = https://example.com/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[credential "https://example.com/path"]
+	helper = store
+[alias]
+	lg = "log --oneline # not a comment"
+	multi = first \
+second
+[Branch.Master]
+	remote = origin
+[section]
+	quoted = "  spaces  "
+	escaped = "a\tb\\c\"d"
+	valueless
+	empty =
+`))
+	tests := []struct {
+		name string
+		want string
+		code int
+	}{
+		{"core.bare", "false", 0},
+		{"CORE.BARE", "false", 0},
+		{"core.editor", "vim -c 'set tw=72'", 0},
+		{"remote.origin.url", "https://example.com/repo.git", 0},
+		{"remote.origin.fetch", "+refs/tags/*:refs/tags/*", 0},
+		{"remote.Origin.url", "", 1},
+		{"credential.https://example.com/path.helper", "store", 0},
+		{"alias.lg", "log --oneline # not a comment", 0},
+		{"alias.multi", "first second", 0},
+		{"branch.master.remote", "origin", 0},
+		{"section.quoted", "  spaces  ", 0},
+		{"section.escaped", "a\tb\\c\"d", 0},
+		{"section.valueless", "true", 0},
+		{"section.empty", "", 0},
+		{"section.missing", "", 1},
+	}
+	for i, tc := range tests {
+		got, code := config.GetConfig(tc.name)
+		if got != tc.want || code != tc.code {
+			t.Errorf("Test %d: unexpected value for %v: got (%q, %d) want (%q, %d)", i, tc.name, got, code, tc.want, tc.code)
+		}
+	}
+
+	fetch := config.GetConfigAll("remote.origin.fetch")
+	if len(fetch) != 2 || fetch[0] != "+refs/heads/*:refs/remotes/origin/*" || fetch[1] != "+refs/tags/*:refs/tags/*" {
+		t.Errorf("Unexpected multi-valued config: got %v", fetch)
+	}
+
+	// A key without a value is a boolean which is true.
+	config = ParseConfig(strings.NewReader("[core]\n\tbare\n"))
+	if got, code := config.GetConfig("core.bare"); got != "true" || code != 0 {
+		t.Errorf("Unexpected value for valueless core.bare: got (%q, %d) want (\"true\", 0)", got, code)
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	var config GitConfig
+	config.SetConfig("core.bare", "false")
+	config.SetConfig("remote.origin.url", "https://example.com/repo.git")
+	config.AddConfig("remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+	config.AddConfig("remote.origin.fetch", "+refs/tags/*:refs/tags/*")
+	config.SetConfig("alias.lg", "log --oneline # not a comment")
+	config.SetConfig("section.quoted", "  spaces  ")
+	config.SetConfig("credential.https://example.com.helper", "cache --timeout 60")
+
+	var b strings.Builder
+	config.WriteFile(&b)
+	want := `[core]
+	bare = false
+[remote "origin"]
+	url = https://example.com/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[alias]
+	lg = "log --oneline # not a comment"
+[section]
+	quoted = "  spaces  "
+[credential "https://example.com"]
+	helper = cache --timeout 60
+`
+	if got := b.String(); got != want {
+		t.Errorf("Unexpected config file: got %v want %v", got, want)
+	}
+
+	reparsed := ParseConfig(strings.NewReader(b.String()))
+	if got, want := reparsed.GetConfigList(), config.GetConfigList(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Config did not survive round trip: got %v want %v", got, want)
+	}
+
+	config.SetConfig("remote.origin.fetch", "+refs/heads/master:refs/remotes/origin/master")
+	if got := config.GetConfigAll("remote.origin.fetch"); len(got) != 1 {
+		t.Errorf("SetConfig did not replace all values: got %v", got)
+	}
+	if code := config.Unset("remote.origin.fetch"); code != 0 {
+		t.Errorf("Unexpected return code from unset: got %v want 0", code)
+	}
+	if code := config.Unset("remote.origin.fetch"); code != 5 {
+		t.Errorf("Unexpected return code from unset of missing variable: got %v want 5", code)
+	}
+}
+
+func TestURLMatchScore(t *testing.T) {
+	tests := []struct {
+		pattern, url string
+		match        bool
+	}{
+		{"", "https://example.com/repo.git", true},
+		{"https://example.com", "https://example.com/repo.git", true},
+		{"https://example.com/", "https://example.com/repo.git", true},
+		{"http://example.com", "https://example.com/repo.git", false},
+		{"https://example.com/repo.git", "https://example.com/repo.git", true},
+		{"https://example.com/re", "https://example.com/repo.git", false},
+		{"https://*.example.com", "https://git.example.com/repo.git", true},
+		{"https://*.example.com", "https://example.com/repo.git", false},
+		{"https://example.com:443", "https://example.com/repo.git", true},
+		{"https://example.com:8443", "https://example.com/repo.git", false},
+		{"https://user@example.com", "https://example.com/repo.git", false},
+		{"https://user@example.com", "https://user@example.com/repo.git", true},
+	}
+	for i, tc := range tests {
+		if got := urlMatchScore(tc.pattern, tc.url) >= 0; got != tc.match {
+			t.Errorf("Test %d: unexpected match for %v against %v: got %v want %v", i, tc.pattern, tc.url, got, tc.match)
+		}
+	}
+
+	// More specific matches should always have a higher score.
+	ordered := []string{
+		"",
+		"https://*.example.com",
+		"https://git.example.com",
+		"https://user@git.example.com",
+		"https://git.example.com/org",
+		"https://git.example.com/org/repo.git",
+	}
+	last := -1
+	for _, pattern := range ordered {
+		score := urlMatchScore(pattern, "https://user@git.example.com/org/repo.git")
+		if score <= last {
+			t.Errorf("Score for %v was not more specific than previous: got %v, previous %v", pattern, score, last)
+		}
+		last = score
+	}
+}
@@ -8,4 +8,8 @@ const (
 	posixDiff3 = "diff3"
 
 	posixPatch = "patch"
+
+	// the shell used to run user provided commands (such as credential
+	// helpers.) It is invoked as shellCommand -c cmdline.
+	shellCommand = "sh"
 )
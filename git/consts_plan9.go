@@ -6,4 +6,8 @@ const (
 	posixDiff3 = "/bin/ape/diff3"
 
 	posixPatch = "/bin/ape/patch"
+
+	// the shell used to run user provided commands (such as credential
+	// helpers.) It is invoked as shellCommand -c cmdline.
+	shellCommand = "rc"
 )
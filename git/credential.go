@@ -0,0 +1,331 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A Credential is a set of attributes used by the git credential helper
+// protocol to describe (and possibly authenticate to) a remote.
+type Credential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+
+	// Set by a helper to indicate that no further helpers should be
+	// consulted.
+	Quit bool
+}
+
+// NewCredentialFromURL returns the credential description for the URL
+// urls, including any username or password in the URL.
+func NewCredentialFromURL(urls string) (Credential, error) {
+	u, err := url.Parse(urls)
+	if err != nil {
+		return Credential{}, err
+	}
+	cred := Credential{
+		Protocol: u.Scheme,
+		Host:     u.Host,
+		Path:     strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.User != nil {
+		cred.Username = u.User.Username()
+		cred.Password, _ = u.User.Password()
+	}
+	return cred, nil
+}
+
+// Applies the credential.* config for cred. The path is removed for
+// http URLs unless credential.useHttpPath is set, and the username is
+// set from credential.username if it isn't known.
+func credentialApplyConfig(c *Client, cred Credential) Credential {
+	urls := cred.URL()
+	if cred.Username == "" {
+		cred.Username = c.GetURLConfig("credential", urls, "username")
+	}
+	if cred.Protocol == "http" || cred.Protocol == "https" {
+		if c.GetURLConfig("credential", urls, "useHttpPath") != "true" {
+			cred.Path = ""
+		}
+	}
+	return cred
+}
+
+// Returns the URL that the credential describes, without the username or
+// password.
+func (cred Credential) URL() string {
+	u := url.URL{Scheme: cred.Protocol, Host: cred.Host}
+	if cred.Path != "" {
+		u.Path = "/" + cred.Path
+	}
+	return u.String()
+}
+
+// ParseCredential reads a credential in the "key=value" format of the
+// credential helper protocol from r. Reading stops at a blank line or EOF.
+// Unknown attributes are ignored.
+func ParseCredential(r io.Reader) (Credential, error) {
+	var cred Credential
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return cred, fmt.Errorf("invalid credential line: %s", line)
+		}
+		key, value := line[:eq], line[eq+1:]
+		switch key {
+		case "protocol":
+			cred.Protocol = value
+		case "host":
+			cred.Host = value
+		case "path":
+			cred.Path = value
+		case "username":
+			cred.Username = value
+		case "password":
+			cred.Password = value
+		case "quit":
+			cred.Quit = value == "1" || value == "true"
+		case "url":
+			u, err := NewCredentialFromURL(value)
+			if err != nil {
+				return cred, err
+			}
+			cred.Protocol, cred.Host, cred.Path = u.Protocol, u.Host, u.Path
+			if u.Username != "" {
+				cred.Username = u.Username
+			}
+			if u.Password != "" {
+				cred.Password = u.Password
+			}
+		}
+	}
+	return cred, scanner.Err()
+}
+
+// WriteTo writes the credential to w in the format of the credential
+// helper protocol, followed by a blank line.
+func (cred Credential) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, attr := range []struct{ key, value string }{
+		{"protocol", cred.Protocol},
+		{"host", cred.Host},
+		{"path", cred.Path},
+		{"username", cred.Username},
+		{"password", cred.Password},
+	} {
+		if attr.value != "" {
+			fmt.Fprintf(&buf, "%s=%s\n", attr.key, attr.value)
+		}
+	}
+	buf.WriteString("\n")
+	return buf.WriteTo(w)
+}
+
+// Returns true if every attribute set in want is the same in have. This is
+// how helpers determine if a stored credential is a match for a request.
+func (want Credential) matches(have Credential) bool {
+	return (want.Protocol == "" || want.Protocol == have.Protocol) &&
+		(want.Host == "" || want.Host == have.Host) &&
+		(want.Path == "" || want.Path == have.Path) &&
+		(want.Username == "" || want.Username == have.Username)
+}
+
+// Returns the list of credential helpers configured for cred. An empty
+// helper value resets the list, so that a more specific config can
+// override a more general one.
+func credentialHelpers(c *Client, cred Credential) []string {
+	var helpers []string
+	for _, h := range c.GetURLConfigAll("credential", cred.URL(), "helper") {
+		if h == "" {
+			helpers = nil
+			continue
+		}
+		helpers = append(helpers, h)
+	}
+	return helpers
+}
+
+// Runs the credential helper helper with the action (get, store, or erase)
+// and returns the credential that it returned for get.
+func runCredentialHelper(c *Client, helper, action string, cred Credential) (Credential, error) {
+	args := strings.Fields(helper)
+	if len(args) == 0 {
+		return Credential{}, nil
+	}
+	switch args[0] {
+	case "store":
+		opts, err := parseCredentialStoreArgs(args[1:])
+		if err != nil {
+			return Credential{}, err
+		}
+		return CredentialStore(c, opts, action, cred)
+	case "cache":
+		opts, err := parseCredentialCacheArgs(args[1:])
+		if err != nil {
+			return Credential{}, err
+		}
+		return CredentialCache(c, opts, action, cred)
+	}
+
+	var cmdline string
+	switch {
+	case helper[0] == '!':
+		cmdline = helper[1:] + " " + action
+	case filepath.IsAbs(args[0]):
+		cmdline = helper + " " + action
+	default:
+		cmdline = "git-credential-" + helper + " " + action
+	}
+	log.Printf("Running credential helper %v\n", cmdline)
+
+	var stdin, stdout bytes.Buffer
+	cred.WriteTo(&stdin)
+	cmd := exec.Command(shellCommand, "-c", cmdline)
+	cmd.Stdin = &stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return Credential{}, err
+	}
+	if action != "get" {
+		return Credential{}, nil
+	}
+	return ParseCredential(&stdout)
+}
+
+// Prompts the user for the missing parts of cred, using an askpass program
+// if one is configured, or the terminal if not.
+func credentialPrompt(c *Client, cred Credential) (Credential, error) {
+	askpass := os.Getenv("GIT_ASKPASS")
+	if askpass == "" {
+		askpass = c.GetConfig("core.askPass")
+	}
+	if askpass == "" {
+		askpass = os.Getenv("SSH_ASKPASS")
+	}
+
+	if askpass == "" {
+		if v := os.Getenv("GIT_TERMINAL_PROMPT"); v == "0" || v == "false" {
+			return cred, fmt.Errorf("could not read Username for '%s': terminal prompts disabled", cred.URL())
+		}
+		userpass, err := getUserPassword(cred.URL(), cred.Username)
+		if err != nil {
+			return cred, err
+		}
+		cred.Username, cred.Password = userpass.user, userpass.password
+		return cred, nil
+	}
+
+	if cred.Username == "" {
+		user, err := runAskpass(askpass, fmt.Sprintf("Username for '%s': ", cred.URL()))
+		if err != nil {
+			return cred, err
+		}
+		cred.Username = user
+	}
+	if cred.Password == "" {
+		u := url.URL{Scheme: cred.Protocol, Host: cred.Host, User: url.User(cred.Username)}
+		if cred.Path != "" {
+			u.Path = "/" + cred.Path
+		}
+		pass, err := runAskpass(askpass, fmt.Sprintf("Password for '%s': ", u.String()))
+		if err != nil {
+			return cred, err
+		}
+		cred.Password = pass
+	}
+	return cred, nil
+}
+
+// Runs the askpass program with prompt as its argument and returns the
+// first line of its output.
+func runAskpass(askpass, prompt string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command(askpass, prompt)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("could not read from %v: %v", askpass, err)
+	}
+	line := stdout.String()
+	if nl := strings.IndexByte(line, '\n'); nl >= 0 {
+		line = line[:nl]
+	}
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// CredentialFill fills in the username and password for cred, by first
+// asking each configured credential helper and then prompting the user
+// if the helpers didn't provide a complete credential.
+func CredentialFill(c *Client, cred Credential) (Credential, error) {
+	cred = credentialApplyConfig(c, cred)
+	if cred.Username != "" && cred.Password != "" {
+		return cred, nil
+	}
+	for _, helper := range credentialHelpers(c, cred) {
+		got, err := runCredentialHelper(c, helper, "get", cred)
+		if err != nil {
+			// git ignores helpers which fail, so that a
+			// misconfigured helper doesn't prevent
+			// prompting.
+			log.Printf("Credential helper %v failed: %v\n", helper, err)
+			continue
+		}
+		if got.Username != "" {
+			cred.Username = got.Username
+		}
+		if got.Password != "" {
+			cred.Password = got.Password
+		}
+		if got.Quit {
+			return cred, fmt.Errorf("credential helper '%s' told us to quit", helper)
+		}
+		if cred.Username != "" && cred.Password != "" {
+			return cred, nil
+		}
+	}
+	return credentialPrompt(c, cred)
+}
+
+// CredentialApprove tells each configured credential helper that cred was
+// accepted, so that helpers may store it for later use.
+func CredentialApprove(c *Client, cred Credential) error {
+	cred = credentialApplyConfig(c, cred)
+	if cred.Username == "" || cred.Password == "" {
+		return nil
+	}
+	for _, helper := range credentialHelpers(c, cred) {
+		if _, err := runCredentialHelper(c, helper, "store", cred); err != nil {
+			log.Printf("Credential helper %v failed: %v\n", helper, err)
+		}
+	}
+	return nil
+}
+
+// CredentialReject tells each configured credential helper that cred was
+// rejected, so that helpers may erase it.
+func CredentialReject(c *Client, cred Credential) error {
+	cred = credentialApplyConfig(c, cred)
+	for _, helper := range credentialHelpers(c, cred) {
+		if _, err := runCredentialHelper(c, helper, "erase", cred); err != nil {
+			log.Printf("Credential helper %v failed: %v\n", helper, err)
+		}
+	}
+	return nil
+}
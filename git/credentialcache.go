@@ -0,0 +1,48 @@
+package git
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+)
+
+// Options for the built in "cache" credential helper.
+type CredentialCacheOptions struct {
+	// Number of seconds to cache credentials for. Defaults to 900.
+	Timeout int
+
+	// The socket used to communicate with the cache daemon.
+	Socket string
+}
+
+// Parses the arguments to the "cache" credential helper from a
+// credential.helper config.
+func parseCredentialCacheArgs(args []string) (CredentialCacheOptions, error) {
+	var opts CredentialCacheOptions
+	flags := flag.NewFlagSet("credential-cache", flag.ContinueOnError)
+	flags.IntVar(&opts.Timeout, "timeout", 900, "")
+	flags.StringVar(&opts.Socket, "socket", "", "")
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// Returns the socket to use for the cache daemon. As with git, the
+// legacy ~/.git-credential-cache directory is used if it exists, and
+// $XDG_CACHE_HOME/git/credential otherwise.
+func (opts CredentialCacheOptions) socket() string {
+	if opts.Socket != "" {
+		return opts.Socket
+	}
+	home := os.Getenv("HOME")
+	legacy := filepath.Join(home, ".git-credential-cache")
+	if fi, err := os.Stat(legacy); err == nil && fi.IsDir() {
+		return filepath.Join(legacy, "socket")
+	}
+	cache := os.Getenv("XDG_CACHE_HOME")
+	if cache == "" {
+		cache = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(cache, "git", "credential", "socket")
+}
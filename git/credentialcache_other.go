@@ -0,0 +1,197 @@
+// +build !plan9
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CredentialCache implements the "cache" credential helper, which keeps
+// credentials in the memory of a daemon process for opts.Timeout seconds.
+// The daemon is started on the first "store" if it isn't already running.
+// action is one of "get", "store", "erase" or "exit".
+func CredentialCache(c *Client, opts CredentialCacheOptions, action string, cred Credential) (Credential, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = 900
+	}
+	socket := opts.socket()
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		if action != "store" {
+			// Nothing is cached if the daemon isn't running.
+			return Credential{}, nil
+		}
+		if err := spawnCredentialCacheDaemon(socket); err != nil {
+			return Credential{}, err
+		}
+		conn, err = net.Dial("unix", socket)
+		if err != nil {
+			return Credential{}, err
+		}
+	}
+	defer conn.Close()
+
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "action=%s\ntimeout=%d\n", action, opts.Timeout)
+	cred.WriteTo(&req)
+	if _, err := req.WriteTo(conn); err != nil {
+		return Credential{}, err
+	}
+	if action != "get" {
+		// Wait for the daemon to finish processing the request
+		// before returning, so that a "get" right after a "store"
+		// sees the stored value.
+		io.Copy(ioutil.Discard, conn)
+		return Credential{}, nil
+	}
+	return ParseCredential(conn)
+}
+
+// Starts the credential cache daemon listening on socket in the
+// background, and waits for it to be ready.
+func spawnCredentialCacheDaemon(socket string) error {
+	cmd := exec.Command(os.Args[0], "credential-cache--daemon", socket)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// The daemon prints "ok" once it's listening.
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ok" {
+		return fmt.Errorf("credential-cache--daemon unable to start")
+	}
+	return nil
+}
+
+type cachedCredential struct {
+	Credential
+	expiry time.Time
+}
+
+// CredentialCacheDaemon runs the daemon for the "cache" credential helper
+// listening on socket. It exits once there are no more unexpired
+// credentials cached, or when it gets an "exit" action.
+func CredentialCacheDaemon(socket string) error {
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return err
+	}
+	// Remove a stale socket from a previous daemon.
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	defer l.Close()
+	ul := l.(*net.UnixListener)
+
+	// Tell whoever spawned us that we're ready.
+	fmt.Println("ok")
+	os.Stdout.Close()
+
+	var cache []cachedCredential
+	// Give the client which spawned us some time to connect before
+	// deciding that there's nothing to do.
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		now := time.Now()
+		kept := cache[:0]
+		for _, cc := range cache {
+			if cc.expiry.After(now) {
+				kept = append(kept, cc)
+				if cc.expiry.After(deadline) {
+					deadline = cc.expiry
+				}
+			}
+		}
+		cache = kept
+		if len(cache) == 0 && now.After(deadline) {
+			return nil
+		}
+		ul.SetDeadline(deadline)
+
+		conn, err := ul.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		// The request is the action and timeout followed by the
+		// credential, which ignores the attributes it doesn't
+		// know about.
+		var req bytes.Buffer
+		r := bufio.NewReader(conn)
+		var action string
+		var timeout int
+		for {
+			line, err := r.ReadString('\n')
+			req.WriteString(line)
+			if err != nil || line == "\n" {
+				break
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if strings.HasPrefix(line, "action=") {
+				action = strings.TrimPrefix(line, "action=")
+			} else if strings.HasPrefix(line, "timeout=") {
+				timeout, _ = strconv.Atoi(strings.TrimPrefix(line, "timeout="))
+			}
+		}
+		cred, err := ParseCredential(&req)
+		if err != nil {
+			log.Println(err)
+			conn.Close()
+			continue
+		}
+
+		switch action {
+		case "get":
+			for _, cc := range cache {
+				if cred.matches(cc.Credential) {
+					cc.Credential.WriteTo(conn)
+					break
+				}
+			}
+		case "store":
+			kept := cache[:0]
+			for _, cc := range cache {
+				if !(cred.matches(cc.Credential) && cc.Path == cred.Path) {
+					kept = append(kept, cc)
+				}
+			}
+			cache = append(kept, cachedCredential{
+				cred,
+				time.Now().Add(time.Duration(timeout) * time.Second),
+			})
+		case "erase":
+			kept := cache[:0]
+			for _, cc := range cache {
+				if !cred.matches(cc.Credential) {
+					kept = append(kept, cc)
+				}
+			}
+			cache = kept
+		case "exit":
+			conn.Close()
+			return nil
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,16 @@
+package git
+
+import (
+	"fmt"
+)
+
+// CredentialCache is not supported on Plan 9, where factotum should be
+// used to cache passwords.
+func CredentialCache(c *Client, opts CredentialCacheOptions, action string, cred Credential) (Credential, error) {
+	return Credential{}, fmt.Errorf("credential-cache is not supported on Plan 9. Use factotum.")
+}
+
+// CredentialCacheDaemon is not supported on Plan 9.
+func CredentialCacheDaemon(socket string) error {
+	return fmt.Errorf("credential-cache--daemon is not supported on Plan 9. Use factotum.")
+}
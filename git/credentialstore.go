@@ -0,0 +1,170 @@
+package git
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Options for the built in "store" credential helper.
+type CredentialStoreOptions struct {
+	// The file to store credentials in. If empty, ~/.git-credentials
+	// and $XDG_CONFIG_HOME/git/credentials are used.
+	File string
+}
+
+// Parses the arguments to the "store" credential helper from a
+// credential.helper config.
+func parseCredentialStoreArgs(args []string) (CredentialStoreOptions, error) {
+	var opts CredentialStoreOptions
+	flags := flag.NewFlagSet("credential-store", flag.ContinueOnError)
+	flags.StringVar(&opts.File, "file", "", "")
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// Returns the files that the store helper reads from. The first one is the
+// one that gets written to.
+func (opts CredentialStoreOptions) files() []string {
+	if opts.File != "" {
+		return []string{opts.File}
+	}
+	var files []string
+	home := os.Getenv("HOME")
+	if home != "" {
+		files = append(files, filepath.Join(home, ".git-credentials"))
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		files = append(files, filepath.Join(xdg, "git", "credentials"))
+	} else if home != "" {
+		files = append(files, filepath.Join(home, ".config", "git", "credentials"))
+	}
+	return files
+}
+
+// Reads the credentials stored in the file fname, one URL per line.
+// A file which doesn't exist has no credentials.
+func readCredentialFile(fname string) ([]Credential, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var creds []Credential
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		cred, err := NewCredentialFromURL(line)
+		if err != nil || cred.Username == "" || cred.Password == "" {
+			// Ignore invalid lines, as git does.
+			continue
+		}
+		creds = append(creds, cred)
+	}
+	return creds, scanner.Err()
+}
+
+// Writes creds to the file fname, which is only readable by the user.
+func writeCredentialFile(fname string, creds []Credential) error {
+	var content strings.Builder
+	for _, cred := range creds {
+		u := url.URL{
+			Scheme: cred.Protocol,
+			Host:   cred.Host,
+			User:   url.UserPassword(cred.Username, cred.Password),
+		}
+		if cred.Path != "" {
+			u.Path = "/" + cred.Path
+		}
+		fmt.Fprintln(&content, u.String())
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, []byte(content.String()), 0600)
+}
+
+// CredentialStore implements the "store" credential helper, which stores
+// credentials unencrypted on disk. action is one of "get", "store", or
+// "erase". For "get", the matching credential (if any) is returned.
+func CredentialStore(c *Client, opts CredentialStoreOptions, action string, cred Credential) (Credential, error) {
+	files := opts.files()
+	if len(files) == 0 {
+		return Credential{}, fmt.Errorf("unable to determine credential file location")
+	}
+	switch action {
+	case "get":
+		for _, fname := range files {
+			creds, err := readCredentialFile(fname)
+			if err != nil {
+				return Credential{}, err
+			}
+			for _, have := range creds {
+				// Stored credentials without a path match any
+				// path
+				want := cred
+				if have.Path == "" {
+					want.Path = ""
+				}
+				if want.matches(have) {
+					return have, nil
+				}
+			}
+		}
+		return Credential{}, nil
+	case "store":
+		if cred.Protocol == "" || cred.Host == "" || cred.Username == "" || cred.Password == "" {
+			return Credential{}, nil
+		}
+		fname := files[0]
+		creds, err := readCredentialFile(fname)
+		if err != nil {
+			return Credential{}, err
+		}
+		kept := []Credential{cred}
+		for _, have := range creds {
+			if !(cred.matches(have) && have.Path == cred.Path) {
+				kept = append(kept, have)
+			}
+		}
+		return Credential{}, writeCredentialFile(fname, kept)
+	case "erase":
+		for _, fname := range files {
+			creds, err := readCredentialFile(fname)
+			if err != nil {
+				return Credential{}, err
+			}
+			var kept []Credential
+			for _, have := range creds {
+				if !cred.matches(have) {
+					kept = append(kept, have)
+				}
+			}
+			if len(kept) == len(creds) {
+				continue
+			}
+			if err := writeCredentialFile(fname, kept); err != nil {
+				return Credential{}, err
+			}
+		}
+		return Credential{}, nil
+	default:
+		// Unknown actions are ignored by helpers so that new
+		// actions can be added to the protocol.
+		return Credential{}, nil
+	}
+}
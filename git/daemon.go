@@ -0,0 +1,377 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DaemonOptions struct {
+	// The address to listen on. If empty, listen on all interfaces.
+	Listen string
+	// The port to listen on. Defaults to 9418.
+	Port int
+
+	// Serve a single connection on stdin and stdout, as if started by
+	// inetd, instead of listening.
+	InetD bool
+
+	// Requested paths are relative to BasePath, if set.
+	BasePath string
+	// Only allow the exact directories in the whitelist, rather than
+	// any repository below them.
+	StrictPaths bool
+	// Serve all repositories, even if they don't contain a
+	// git-daemon-export-ok file.
+	ExportAll bool
+
+	// The services that are enabled. UploadPack is enabled by default
+	// by the daemon command.
+	UploadPack, ReceivePack bool
+	// Allow repositories to override the enabled services with the
+	// daemon.uploadpack and daemon.receivepack config.
+	AllowOverride bool
+
+	// Tell clients why a request was denied, rather than only sending
+	// a generic error.
+	InformativeErrors bool
+
+	// The maximum number of simultaneous connections. 0 means
+	// unlimited.
+	MaxConnections int
+	// The time allowed for a client to send its request once it has
+	// connected.
+	InitTimeout time.Duration
+	// The time allowed between reads and writes of a request once
+	// it has started.
+	Timeout time.Duration
+
+	// Write the process id to PidFile, if set.
+	PidFile string
+	// Log connections and requests to stderr.
+	Verbose bool
+}
+
+// Daemon runs a server for the git:// protocol. If dirs is not empty,
+// only repositories inside of one of the directories are served.
+func Daemon(opts DaemonOptions, dirs []string) error {
+	if opts.PidFile != "" {
+		if err := writePidFile(opts.PidFile); err != nil {
+			return err
+		}
+	}
+	d, err := newDaemon(opts, dirs)
+	if err != nil {
+		return err
+	}
+	if opts.InetD {
+		d.handle(stdioConn{os.Stdin, os.Stdout}, "inetd")
+		return nil
+	}
+	port := opts.Port
+	if port == 0 {
+		port = 9418
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(opts.Listen, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	d.logf("Ready to rumble")
+	return d.serve(l)
+}
+
+func writePidFile(name string) error {
+	return ioutil.WriteFile(name, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+}
+
+// A daemon serves the repositories that it was configured for to
+// connections on a listener.
+type daemon struct {
+	opts DaemonOptions
+
+	// The absolute paths of the whitelisted directories.
+	whitelist []string
+}
+
+func newDaemon(opts DaemonOptions, dirs []string) (*daemon, error) {
+	d := &daemon{opts: opts}
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		d.whitelist = append(d.whitelist, abs)
+	}
+	return d, nil
+}
+
+// Accepts connections from l until it's closed.
+func (d *daemon) serve(l net.Listener) error {
+	var sem chan struct{}
+	if d.opts.MaxConnections > 0 {
+		sem = make(chan struct{}, d.opts.MaxConnections)
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			default:
+				d.logf("Too many connections, dropping %v", conn.RemoteAddr())
+				conn.Close()
+				continue
+			}
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			d.handle(timeoutConn{conn, d.opts.InitTimeout, d.opts.Timeout}, conn.RemoteAddr().String())
+		}(conn)
+	}
+}
+
+func (d *daemon) logf(format string, args ...interface{}) {
+	if d.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "[%d] %s\n", os.Getpid(), fmt.Sprintf(format, args...))
+	}
+}
+
+// Sends an error to the client. msg is only sent if informative errors
+// are enabled, otherwise the client gets a generic message so that it
+// can't probe which repositories exist.
+func (d *daemon) deny(w io.Writer, msg, dir string) {
+	d.logf("%s: %s", msg, dir)
+	if d.opts.InformativeErrors {
+		pktLineWriter{w}.Printf("ERR %s: %s", msg, dir)
+	} else {
+		pktLineWriter{w}.Printf("ERR access denied or repository not exported: %s", dir)
+	}
+}
+
+// A daemonRequest is the initial request sent by a client to the daemon,
+// such as "git-upload-pack /project.git\0host=example.com\0".
+type daemonRequest struct {
+	Service string
+	Path    string
+	Host    string
+
+	// Extra parameters, such as "version=2", which come after a
+	// second NUL byte.
+	Params []string
+}
+
+func parseDaemonRequest(line string) (daemonRequest, error) {
+	var req daemonRequest
+	fields := strings.Split(strings.TrimSuffix(line, "\n"), "\x00")
+	sp := strings.IndexByte(fields[0], ' ')
+	if sp <= 0 || sp == len(fields[0])-1 {
+		return req, fmt.Errorf("Invalid request: %q", line)
+	}
+	req.Service, req.Path = fields[0][:sp], fields[0][sp+1:]
+	fields = fields[1:]
+	for i, field := range fields {
+		if field == "" {
+			for _, param := range fields[i+1:] {
+				if param != "" {
+					req.Params = append(req.Params, param)
+				}
+			}
+			break
+		}
+		if strings.HasPrefix(field, "host=") {
+			req.Host = field[5:]
+		}
+	}
+	return req, nil
+}
+
+// Handles a single connection to the daemon.
+func (d *daemon) handle(conn io.ReadWriter, remote string) {
+	d.logf("Connection from %v", remote)
+	line, err := readDaemonRequest(conn)
+	if err != nil {
+		d.logf("Could not read request from %v: %v", remote, err)
+		return
+	}
+	if tc, ok := conn.(timeoutConn); ok {
+		tc.init = 0
+		conn = tc
+	}
+	req, err := parseDaemonRequest(line)
+	if err != nil {
+		d.logf("%v", err)
+		return
+	}
+	d.logf("Request %s for '%s'", req.Service, req.Path)
+
+	svc := strings.TrimPrefix(req.Service, "git-")
+	if svc == req.Service || (svc != "upload-pack" && svc != "receive-pack") {
+		d.deny(conn, "service not supported", req.Service)
+		return
+	}
+	c, err := d.enterRepo(req.Path)
+	if err != nil {
+		d.deny(conn, err.Error(), req.Path)
+		return
+	}
+	if !d.serviceEnabled(c, svc) {
+		d.deny(conn, "service not enabled", req.Path)
+		return
+	}
+
+	gitprotocol := strings.Join(req.Params, ":")
+	switch svc {
+	case "upload-pack":
+		err = UploadPack(c, UploadPackOptions{GitProtocol: gitprotocol}, conn, conn)
+	case "receive-pack":
+		err = ReceivePack(c, ReceivePackOptions{GitProtocol: gitprotocol}, conn, conn)
+	}
+	if err != nil && err != io.EOF {
+		d.logf("%s failed for '%s': %v", req.Service, req.Path, err)
+	}
+}
+
+// Reads the pkt-line containing the client's request. The line is read
+// directly from r so that nothing after it is buffered.
+func readDaemonRequest(r io.Reader) (string, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(size[:]), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("Invalid pkt-line length: %q", size)
+	}
+	if n <= 4 {
+		return "", fmt.Errorf("Empty request")
+	}
+	line := make([]byte, n-4)
+	if _, err := io.ReadFull(r, line); err != nil {
+		return "", err
+	}
+	return string(line), nil
+}
+
+// Returns a client for the repository requested at path, if it's allowed
+// to be served.
+func (d *daemon) enterRepo(path string) (*Client, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path must be absolute")
+	}
+	for _, elem := range strings.Split(path, "/") {
+		if elem == ".." {
+			return nil, fmt.Errorf("path may not contain '..'")
+		}
+	}
+	dir := filepath.FromSlash(path)
+	if d.opts.BasePath != "" {
+		dir = filepath.Join(d.opts.BasePath, dir)
+	}
+	c, err := EnterRepo(dir, d.opts.StrictPaths)
+	if err != nil {
+		return nil, fmt.Errorf("no such repository")
+	}
+	if !d.whitelisted(c.GitDir.String()) {
+		return nil, fmt.Errorf("repository not whitelisted")
+	}
+	if !d.opts.ExportAll && !c.GitDir.File("git-daemon-export-ok").Exists() {
+		return nil, fmt.Errorf("repository not exported")
+	}
+	return c, nil
+}
+
+// Returns whether the git directory gitdir is allowed by the whitelist.
+func (d *daemon) whitelisted(gitdir string) bool {
+	if len(d.whitelist) == 0 {
+		return true
+	}
+	for _, dir := range d.whitelist {
+		if gitdir == dir {
+			return true
+		}
+		if d.opts.StrictPaths {
+			continue
+		}
+		if strings.HasPrefix(gitdir, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether svc is enabled for the repository, taking the
+// repository's config into account if it's allowed to override the
+// daemon's settings.
+func (d *daemon) serviceEnabled(c *Client, svc string) bool {
+	enabled := false
+	switch svc {
+	case "upload-pack":
+		enabled = d.opts.UploadPack
+	case "receive-pack":
+		enabled = d.opts.ReceivePack
+	default:
+		return false
+	}
+	if d.opts.AllowOverride {
+		switch c.GetConfig("daemon." + strings.Replace(svc, "-", "", -1)) {
+		case "true":
+			enabled = true
+		case "false":
+			enabled = false
+		}
+	}
+	return enabled
+}
+
+// A timeoutConn sets a deadline on the connection before every read or
+// write, so that idle clients are eventually disconnected.
+type timeoutConn struct {
+	net.Conn
+
+	// The timeout before the request has been received, and after.
+	init, timeout time.Duration
+}
+
+func (t timeoutConn) Read(buf []byte) (int, error) {
+	t.setDeadline()
+	return t.Conn.Read(buf)
+}
+
+func (t timeoutConn) Write(buf []byte) (int, error) {
+	t.setDeadline()
+	return t.Conn.Write(buf)
+}
+
+func (t timeoutConn) setDeadline() {
+	timeout := t.timeout
+	if t.init != 0 {
+		timeout = t.init
+	}
+	if timeout != 0 {
+		t.Conn.SetDeadline(time.Now().Add(timeout))
+	} else {
+		t.Conn.SetDeadline(time.Time{})
+	}
+}
+
+// A stdioConn is a connection over stdin and stdout, for a daemon started
+// by inetd.
+type stdioConn struct {
+	io.Reader
+	io.Writer
+}
@@ -0,0 +1,157 @@
+package git
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDaemonRequest(t *testing.T) {
+	tests := []struct {
+		Line    string
+		Want    daemonRequest
+		WantErr bool
+	}{
+		{
+			"git-upload-pack /project.git\x00host=example.com\x00",
+			daemonRequest{"git-upload-pack", "/project.git", "example.com", nil},
+			false,
+		},
+		{
+			"git-upload-pack /project.git\x00host=example.com:9418\x00\x00version=2\x00",
+			daemonRequest{"git-upload-pack", "/project.git", "example.com:9418", []string{"version=2"}},
+			false,
+		},
+		{
+			"git-receive-pack /foo\x00\x00version=1\x00foo=bar\x00",
+			daemonRequest{"git-receive-pack", "/foo", "", []string{"version=1", "foo=bar"}},
+			false,
+		},
+		{
+			"git-upload-pack /foo\n",
+			daemonRequest{"git-upload-pack", "/foo", "", nil},
+			false,
+		},
+		{"git-upload-pack", daemonRequest{}, true},
+		{"git-upload-pack \x00host=foo\x00", daemonRequest{}, true},
+	}
+	for _, test := range tests {
+		got, err := parseDaemonRequest(test.Line)
+		if test.WantErr {
+			if err == nil {
+				t.Errorf("Expected error for request %q", test.Line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for request %q: %v", test.Line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("Unexpected request for %q: got %+v want %+v", test.Line, got, test.Want)
+		}
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitdaemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcdir := filepath.Join(dir, "src")
+	src, err := Init(nil, InitOptions{Quiet: true}, srcdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcdir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(src, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := Commit(src, CommitOptions{}, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "pushed.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "dst.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	d, err := newDaemon(DaemonOptions{
+		BasePath:          dir,
+		UploadPack:        true,
+		AllowOverride:     true,
+		InformativeErrors: true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go d.serve(l)
+	url := "git://" + l.Addr().String()
+
+	// Nothing is exported without git-daemon-export-ok
+	_, err = FetchPack(dst, FetchPackOptions{All: true}, Remote(url+"/src"), nil)
+	if err == nil || !strings.Contains(err.Error(), "repository not exported") {
+		t.Errorf("Unexpected error for unexported repository: %v", err)
+	}
+	if err := ioutil.WriteFile(src.GitDir.File("git-daemon-export-ok").String(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = FetchPack(dst, FetchPackOptions{All: true}, Remote(url+"/../src"), nil)
+	if err == nil || !strings.Contains(err.Error(), "may not contain '..'") {
+		t.Errorf("Unexpected error for path outside of base path: %v", err)
+	}
+
+	refs, err := FetchPack(dst, FetchPackOptions{All: true}, Remote(url+"/src"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Name != "refs/heads/master" || refs[0].Value != Sha1(cmt) {
+		t.Errorf("Unexpected refs from daemon: %v", refs)
+	}
+	if have, _, err := dst.HaveObject(Sha1(cmt)); !have || err != nil {
+		t.Errorf("Commit %v was not fetched: %v", cmt, err)
+	}
+
+	// receive-pack is disabled unless the repository overrides it.
+	if err := ioutil.WriteFile(pushed.GitDir.File("git-daemon-export-ok").String(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = SendPack(src, SendPackOptions{}, Remote(url+"/pushed.git"), []Refname{"refs/heads/master:refs/heads/master"})
+	if err == nil || !strings.Contains(err.Error(), "service not enabled") {
+		t.Errorf("Unexpected error for disabled service: %v", err)
+	}
+	config, err := LoadLocalConfig(pushed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfig("daemon.receivepack", "true")
+	if err := config.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendPack(src, SendPackOptions{}, Remote(url+"/pushed.git"), []Refname{"refs/heads/master:refs/heads/master"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := RefSpec("refs/heads/master").Sha1(pushed); got != Sha1(cmt) {
+		t.Errorf("Unexpected value for pushed ref: got %v want %v", got, cmt)
+	}
+}
@@ -0,0 +1,375 @@
+package git
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseApproxidate parses a date in one of the formats accepted by git for
+// things such as "@{<date>}" and --since. In addition to the absolute
+// formats accepted by parseDate, this accepts "now", "yesterday", YYYY-MM-DD
+// with an optional time, ISO 8601 with a "Z" or numeric timezone, dates with
+// month names such as "Jan 2 2020", unix timestamps (prefixed with "@" or
+// with more than 8 digits), and relative dates such as "2.weeks.ago",
+// "last week" or "3 hours 10 minutes ago". Relative dates are relative to
+// now.
+func ParseApproxidate(str string, now time.Time) (time.Time, error) {
+	str = strings.TrimSpace(str)
+	for _, layout := range []string{
+		"2006-01-02 15:04:05 -0700",
+		"2006-01-02T15:04:05Z07:00",
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+		"2006.01.02",
+		"2006/01/02",
+		"Mon Jan 2 15:04:05 2006 -0700",
+		"Mon Jan 2 15:04:05 2006",
+		"Jan 2 2006 15:04:05",
+		"Jan 2 2006",
+		"Jan 2, 2006",
+		"January 2 2006",
+		"January 2, 2006",
+		"2 Jan 2006",
+		"2 January 2006",
+	} {
+		if t, err := time.ParseInLocation(layout, str, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := parseDate(str); err == nil {
+		return t, nil
+	}
+	// Like git, numbers with more than 8 digits are seconds since the
+	// epoch.
+	if digits := strings.TrimPrefix(str, "@"); digits != str || len(digits) > 8 {
+		if unixtime, err := strconv.ParseInt(digits, 10, 64); err == nil {
+			return time.Unix(unixtime, 0), nil
+		}
+	}
+
+	// Relative dates. Words may be separated by spaces or dots.
+	words := strings.FieldsFunc(strings.ToLower(str), func(r rune) bool {
+		return r == ' ' || r == '.' || r == ','
+	})
+	if len(words) == 0 {
+		return time.Time{}, fmt.Errorf("Invalid date: %v", str)
+	}
+	t := now
+	n := -1
+	for _, word := range words {
+		if num, err := strconv.Atoi(word); err == nil {
+			n = num
+			continue
+		}
+		switch word {
+		case "now", "ago":
+			continue
+		case "yesterday":
+			t = t.AddDate(0, 0, -1)
+			continue
+		case "a", "an", "one", "last":
+			n = 1
+			continue
+		}
+		if n < 0 {
+			return time.Time{}, fmt.Errorf("Invalid date: %v", str)
+		}
+		switch strings.TrimSuffix(word, "s") {
+		case "second", "sec":
+			t = t.Add(-time.Duration(n) * time.Second)
+		case "minute", "min":
+			t = t.Add(-time.Duration(n) * time.Minute)
+		case "hour":
+			t = t.Add(-time.Duration(n) * time.Hour)
+		case "day":
+			t = t.AddDate(0, 0, -n)
+		case "week":
+			t = t.AddDate(0, 0, -7*n)
+		case "month":
+			t = t.AddDate(0, -n, 0)
+		case "year":
+			t = t.AddDate(-n, 0, 0)
+		default:
+			return time.Time{}, fmt.Errorf("Invalid date: %v", str)
+		}
+		n = -1
+	}
+	if n >= 0 {
+		// A number without a unit
+		return time.Time{}, fmt.Errorf("Invalid date: %v", str)
+	}
+	return t, nil
+}
+
+// A DateMode describes how dates are shown by log and show, as set by the
+// --date option.
+type DateMode struct {
+	// One of "default", "relative", "iso8601", "iso8601-strict",
+	// "rfc2822", "short", "raw", "human", "unix" or "format".
+	Type string
+
+	// Show dates in the local timezone instead of the timezone they
+	// were recorded in.
+	Local bool
+
+	// The strftime style format for the "format" type.
+	Format string
+}
+
+// ParseDateMode parses the argument to --date or the log.date config.
+func ParseDateMode(mode string) (DateMode, error) {
+	if strings.HasPrefix(mode, "format:") {
+		return DateMode{Type: "format", Format: mode[7:]}, nil
+	}
+	if strings.HasPrefix(mode, "format-local:") {
+		return DateMode{Type: "format", Format: mode[13:], Local: true}, nil
+	}
+	var dm DateMode
+	name := mode
+	if strings.HasSuffix(name, "-local") {
+		dm.Local = true
+		name = strings.TrimSuffix(name, "-local")
+	}
+	switch name {
+	case "", "default", "normal":
+		dm.Type = "default"
+	case "local":
+		dm.Type, dm.Local = "default", true
+	case "relative", "short", "raw", "human", "unix":
+		dm.Type = name
+	case "iso", "iso8601":
+		dm.Type = "iso8601"
+	case "iso-strict", "iso8601-strict":
+		dm.Type = "iso8601-strict"
+	case "rfc", "rfc2822":
+		dm.Type = "rfc2822"
+	default:
+		return DateMode{}, fmt.Errorf("fatal: unknown date format %v", mode)
+	}
+	return dm, nil
+}
+
+// FormatDate formats t, which should be in the timezone the date was
+// recorded in, according to mode. Relative dates are relative to now.
+func FormatDate(t time.Time, mode DateMode, now time.Time) string {
+	if mode.Local {
+		t = t.In(now.Location())
+	}
+	switch mode.Type {
+	case "relative":
+		return relativeDate(t, now)
+	case "human":
+		return humanDate(t, now, mode.Local)
+	case "iso8601":
+		return t.Format("2006-01-02 15:04:05 -0700")
+	case "iso8601-strict":
+		return t.Format("2006-01-02T15:04:05-07:00")
+	case "rfc2822":
+		return t.Format("Mon, 2 Jan 2006 15:04:05 -0700")
+	case "short":
+		return t.Format("2006-01-02")
+	case "raw":
+		return fmt.Sprintf("%d %v", t.Unix(), t.Format("-0700"))
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "format":
+		return strftime(mode.Format, t, mode.Local)
+	}
+	if mode.Local {
+		return t.Format("Mon Jan 2 15:04:05 2006")
+	}
+	return t.Format("Mon Jan 2 15:04:05 2006 -0700")
+}
+
+// Returns a description of how long ago t was relative to now, such as
+// "3 hours ago" or "2 years, 1 month ago", using the same rounding as git.
+func relativeDate(t, now time.Time) string {
+	diff := now.Unix() - t.Unix()
+	if diff < 0 {
+		return "in the future"
+	}
+	plural := func(n int64, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %v", n, unit)
+		}
+		return fmt.Sprintf("%d %vs", n, unit)
+	}
+	if diff < 90 {
+		return plural(diff, "second") + " ago"
+	}
+	// Minutes
+	if diff = (diff + 30) / 60; diff < 90 {
+		return plural(diff, "minute") + " ago"
+	}
+	// Hours
+	if diff = (diff + 30) / 60; diff < 36 {
+		return plural(diff, "hour") + " ago"
+	}
+	// Days
+	if diff = (diff + 12) / 24; diff < 14 {
+		return plural(diff, "day") + " ago"
+	}
+	if diff < 70 {
+		return plural((diff+3)/7, "week") + " ago"
+	}
+	if diff < 365 {
+		return plural((diff+15)/30, "month") + " ago"
+	}
+	if diff < 1825 {
+		totalMonths := (diff*12*2 + 365) / (365 * 2)
+		years, months := totalMonths/12, totalMonths%12
+		if months != 0 {
+			return plural(years, "year") + ", " + plural(months, "month") + " ago"
+		}
+		return plural(years, "year") + " ago"
+	}
+	return plural((diff+183)/365, "year") + " ago"
+}
+
+// Returns t in the "human" date format, which only includes the parts of
+// the date which differ from now.
+func humanDate(t, now time.Time, local bool) string {
+	_, tz := t.Zone()
+	_, nowtz := now.Zone()
+	hideTZ := local || tz == nowtz
+	hideYear := t.Year() == now.Year()
+	var hideDate, hideWday bool
+	if hideYear && t.Month() == now.Month() {
+		switch {
+		case t.Day() > now.Day():
+			// Future date, leave it alone.
+		case t.Day() == now.Day():
+			hideDate, hideWday = true, true
+		case t.Day()+5 > now.Day():
+			// Leave just the weekday if it was a few days ago.
+			hideDate = true
+		}
+	}
+	// Times from today are shown as relative times.
+	if hideWday {
+		return relativeDate(t, now)
+	}
+
+	// Seconds are always hidden, and the timezone if showing the
+	// date. The weekday and time are hidden if showing the year.
+	hideTZ = hideTZ || !hideDate
+	hideWday, hideTime := !hideYear, !hideYear
+
+	var s strings.Builder
+	if !hideWday {
+		s.WriteString(t.Format("Mon "))
+	}
+	if !hideDate {
+		s.WriteString(t.Format("Jan 2 "))
+	}
+	if !hideTime {
+		s.WriteString(t.Format("15:04"))
+	}
+	str := strings.TrimRight(s.String(), " ")
+	if !hideYear {
+		str += t.Format(" 2006")
+	}
+	if !hideTZ {
+		str += t.Format(" -0700")
+	}
+	return str
+}
+
+// Formats t according to the strftime style format. The timezone name
+// (%Z) is only known for local times.
+func strftime(format string, t time.Time, local bool) string {
+	var s strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			s.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'a':
+			s.WriteString(t.Format("Mon"))
+		case 'A':
+			s.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			s.WriteString(t.Format("Jan"))
+		case 'B':
+			s.WriteString(t.Format("January"))
+		case 'c':
+			s.WriteString(t.Format("Mon Jan _2 15:04:05 2006"))
+		case 'C':
+			fmt.Fprintf(&s, "%02d", t.Year()/100)
+		case 'd':
+			s.WriteString(t.Format("02"))
+		case 'D':
+			s.WriteString(t.Format("01/02/06"))
+		case 'e':
+			s.WriteString(t.Format("_2"))
+		case 'F':
+			s.WriteString(t.Format("2006-01-02"))
+		case 'H':
+			s.WriteString(t.Format("15"))
+		case 'I':
+			s.WriteString(t.Format("03"))
+		case 'j':
+			fmt.Fprintf(&s, "%03d", t.YearDay())
+		case 'k':
+			fmt.Fprintf(&s, "%2d", t.Hour())
+		case 'l':
+			s.WriteString(t.Format("_3"))
+		case 'm':
+			s.WriteString(t.Format("01"))
+		case 'M':
+			s.WriteString(t.Format("04"))
+		case 'n':
+			s.WriteByte('\n')
+		case 'p':
+			s.WriteString(t.Format("PM"))
+		case 'P':
+			s.WriteString(strings.ToLower(t.Format("PM")))
+		case 'r':
+			s.WriteString(t.Format("03:04:05 PM"))
+		case 'R':
+			s.WriteString(t.Format("15:04"))
+		case 's':
+			fmt.Fprintf(&s, "%d", t.Unix())
+		case 'S':
+			s.WriteString(t.Format("05"))
+		case 't':
+			s.WriteByte('\t')
+		case 'T':
+			s.WriteString(t.Format("15:04:05"))
+		case 'u':
+			wd := int(t.Weekday())
+			if wd == 0 {
+				wd = 7
+			}
+			fmt.Fprintf(&s, "%d", wd)
+		case 'w':
+			fmt.Fprintf(&s, "%d", int(t.Weekday()))
+		case 'x':
+			s.WriteString(t.Format("01/02/06"))
+		case 'X':
+			s.WriteString(t.Format("15:04:05"))
+		case 'y':
+			s.WriteString(t.Format("06"))
+		case 'Y':
+			fmt.Fprintf(&s, "%d", t.Year())
+		case 'z':
+			s.WriteString(t.Format("-0700"))
+		case 'Z':
+			if local {
+				s.WriteString(t.Format("MST"))
+			}
+		case '%':
+			s.WriteByte('%')
+		default:
+			s.WriteByte('%')
+			s.WriteByte(format[i])
+		}
+	}
+	return s.String()
+}
@@ -0,0 +1,192 @@
+package git
+
+import (
+	"sort"
+	"strings"
+)
+
+// The kinds of refs that commits are decorated with, which determine the
+// colour of the decoration.
+type decorationType int
+
+const (
+	decorationBranch decorationType = iota
+	decorationRemoteBranch
+	decorationTag
+	decorationStash
+	decorationHead
+)
+
+var decorationColors = map[decorationType]string{
+	decorationBranch:       colorBoldGreen,
+	decorationRemoteBranch: colorBoldRed,
+	decorationTag:          colorBoldYellow,
+	decorationStash:        colorBoldMagenta,
+	decorationHead:         colorBoldCyan,
+}
+
+// A decoration is a ref pointing to a commit that's shown beside it in the
+// output of log.
+type decoration struct {
+	Name Refname
+	Type decorationType
+}
+
+// decorations are the refs pointing to each commit, in the order that git
+// shows them.
+type decorations struct {
+	byCommit map[CommitID][]decoration
+
+	// The branch that HEAD points to, if it's not detached.
+	head Refname
+}
+
+// Loads the refs which commits are decorated with by default. Tags are
+// peeled to the commit that they point to. If include isn't empty, only
+// the refs matching one of its patterns are loaded, and refs matching a
+// pattern in exclude never are.
+func loadDecorations(c *Client, include, exclude []string) (*decorations, error) {
+	d := &decorations{byCommit: make(map[CommitID][]decoration)}
+	refs, err := ShowRef(c, ShowRefOptions{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	for _, ref := range refs {
+		var typ decorationType
+		switch {
+		case strings.HasPrefix(ref.Name, "refs/heads/"):
+			typ = decorationBranch
+		case strings.HasPrefix(ref.Name, "refs/remotes/"):
+			typ = decorationRemoteBranch
+		case strings.HasPrefix(ref.Name, "refs/tags/"):
+			typ = decorationTag
+		case ref.Name == "refs/stash":
+			typ = decorationStash
+		default:
+			continue
+		}
+		if !decorationFilterMatches(ref.Name, include, exclude) {
+			continue
+		}
+		id, err := peelRevision(c, ref.Value, "commit")
+		if err != nil {
+			continue
+		}
+		d.add(CommitID(id), decoration{Refname(ref.Name), typ})
+	}
+	if head, err := c.GetHeadCommit(); err == nil && decorationFilterMatches("HEAD", include, exclude) {
+		d.add(head, decoration{"HEAD", decorationHead})
+	}
+	if branch, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD"); err == nil {
+		d.head = Refname(branch)
+	}
+	return d, nil
+}
+
+// Returns true if the ref name should decorate commits given the include
+// and exclude patterns of --decorate-refs and --decorate-refs-exclude.
+func decorationFilterMatches(name string, include, exclude []string) bool {
+	for _, pattern := range exclude {
+		if decorationPatternMatches(pattern, name) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pattern := range include {
+		if decorationPatternMatches(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if name matches pattern. Patterns without wildcards match
+// the ref with that name and any refs under it, so "refs/tags" matches
+// every tag.
+func decorationPatternMatches(pattern, name string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		return wildmatch(pattern, name)
+	}
+	pattern = strings.TrimSuffix(pattern, "/")
+	return name == pattern || strings.HasPrefix(name, pattern+"/")
+}
+
+// Adds a decoration to id. Decorations added later are shown first.
+func (d *decorations) add(id CommitID, dec decoration) {
+	d.byCommit[id] = append([]decoration{dec}, d.byCommit[id]...)
+}
+
+// Formats the decorations of id as git does for --decorate and %d, with
+// each ref name separated by sep and surrounded by prefix and suffix. The
+// empty string is returned if nothing points to id. Full ref names are
+// used if full is set.
+func (d *decorations) format(id CommitID, prefix, sep, suffix string, full, color bool) string {
+	decs := d.byCommit[id]
+	if len(decs) == 0 {
+		return ""
+	}
+	colorCommit, colorNone := "", ""
+	if color {
+		colorCommit, colorNone = colorYellow, colorReset
+	}
+	name := func(dec decoration) string {
+		if full {
+			return string(dec.Name)
+		}
+		switch dec.Type {
+		case decorationBranch:
+			return strings.TrimPrefix(string(dec.Name), "refs/heads/")
+		case decorationRemoteBranch:
+			return strings.TrimPrefix(string(dec.Name), "refs/remotes/")
+		case decorationTag:
+			return strings.TrimPrefix(string(dec.Name), "refs/tags/")
+		}
+		return string(dec.Name)
+	}
+	decColor := func(dec decoration) string {
+		if !color {
+			return ""
+		}
+		return decorationColors[dec.Type]
+	}
+
+	// If HEAD points to a branch which decorates the commit, they're
+	// combined into "HEAD -> branch".
+	var current *decoration
+	if decs[0].Type == decorationHead {
+		for i, dec := range decs {
+			if dec.Type == decorationBranch && dec.Name == d.head {
+				current = &decs[i]
+				break
+			}
+		}
+	}
+
+	var s strings.Builder
+	s.WriteString(colorCommit + prefix + colorNone)
+	first := true
+	for _, dec := range decs {
+		if current != nil && dec == *current {
+			continue
+		}
+		if !first {
+			s.WriteString(colorCommit + sep + colorNone)
+		}
+		first = false
+		s.WriteString(decColor(dec))
+		switch {
+		case dec.Type == decorationHead && current != nil:
+			s.WriteString("HEAD -> " + colorNone + decColor(*current) + name(*current))
+		case dec.Type == decorationTag:
+			s.WriteString("tag: " + name(dec))
+		default:
+			s.WriteString(name(dec))
+		}
+		s.WriteString(colorNone)
+	}
+	s.WriteString(colorCommit + suffix + colorNone)
+	return s.String()
+}
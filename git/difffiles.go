@@ -17,6 +17,53 @@ type DiffCommonOptions struct {
 	// Generate the diff in raw format, not a unified diff
 	Raw bool
 
+	// Only show the names, or the names and status, of changed files.
+	NameOnly, NameStatus bool
+
+	// Show a diffstat, the number of added and deleted lines of each
+	// file, or only the last line of the diffstat before any patch.
+	Stat, NumStat, ShortStat bool
+
+	// Limits for the diffstat: its total width, the width of the file
+	// names and the number of files shown. The 0 values imply the width
+	// of the terminal, no limit on the names and all files.
+	StatWidth, StatNameWidth, StatCount int
+
+	// Show a summary of created, deleted and mode changed files.
+	Summary bool
+
+	// Detect renamed files, and copied files if DetectCopies is also
+	// set, which are at least RenameScore similar. RenameScore is on
+	// the scale of ParseSimilarityScore, and 0 implies 50%.
+	DetectRenames, DetectCopies bool
+	RenameScore                 int
+
+	// Look for the sources of copies in unmodified files, not only in
+	// modified ones.
+	FindCopiesHarder bool
+
+	// Break modifications which change at least BreakScore of a file
+	// into a deletion and a creation, so that either side can be part
+	// of a rename. Pairs which aren't renamed are shown as rewrites if
+	// at least MergeScore of the file was removed. The 0 values imply
+	// 50% and 60%.
+	BreakRewrites          bool
+	BreakScore, MergeScore int
+
+	// The maximum number of sources and destinations compared when
+	// detecting inexact renames, or 0 for no practical limit.
+	RenameLimit int
+
+	// The minimum number of hex digits to abbreviate object names to in
+	// raw output, or 0 for full object names.
+	Abbrev int
+
+	// Use colours in patches and diffstats.
+	Color bool
+
+	// Only show the files whose changes match the -S or -G options.
+	Pickaxe PickaxeOptions
+
 	// Exit with a exit code of 1 if there are any diffs
 	ExitCode bool
 }
@@ -42,7 +89,7 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 		return nil, err
 	}
 
-	var val []HashDiff
+	var val, unmodified []HashDiff
 
 	for _, idx := range indexentries {
 		fs := TreeEntry{}
@@ -52,12 +99,12 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 		if err != nil || !f.Exists() {
 			// If there was an error, treat it as a non-existant file
 			// and just use the empty Sha1
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), 0})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize)})
 			continue
 		}
 		stat, err := f.Lstat()
 		if err != nil {
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), 0})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize)})
 			continue
 		}
 
@@ -66,7 +113,7 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 			// Since we're diffing files in the index (which only holds files)
 			// against a directory, it means that the file was deleted and
 			// replaced by a directory.
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), 0})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize)})
 			continue
 		case !stat.Mode().IsRegular():
 			// FIXME: This doesn't take into account that the file
@@ -80,7 +127,7 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 		size := stat.Size()
 		if err := idx.CompareStat(f); err != nil {
 			log.Printf("Stat information does not match for %v: %v\n", f, err)
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), uint(size)})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize), DstSize: uint(size)})
 			continue
 		}
 
@@ -89,11 +136,13 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 		hash, _, err := HashFile("blob", f.String())
 
 		if err != nil || hash != idx.Sha1 {
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), uint(size)})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize), DstSize: uint(size)})
+		} else if opt.FindCopiesHarder {
+			unmodified = append(unmodified, HashDiff{Name: idx.PathName, Src: idxtree, Dst: idxtree, SrcSize: uint(idx.Fsize), DstSize: uint(size)})
 		}
 	}
 
 	sort.Sort(ByName(val))
 
-	return val, nil
+	return detectRenames(c, opt.DiffCommonOptions, val, unmodified)
 }
@@ -1,7 +1,7 @@
 package git
 
 import (
-//	"fmt"
+	"sort"
 )
 
 // Describes the options that may be specified on the command line for
@@ -49,9 +49,11 @@ func DiffIndex(c *Client, opt DiffIndexOptions, index *Index, tree Treeish, path
 		}{TreeEntry{path.Sha1, path.Mode}, uint(path.Fsize)}
 	}
 
-	var val []HashDiff
+	var val, unmodified []HashDiff
 
+	inIndex := make(map[IndexPath]bool, len(index.Objects))
 	for _, entry := range index.Objects {
+		inIndex[entry.PathName] = true
 		f, err := entry.PathName.FilePath(c)
 		if err != nil {
 			return nil, err
@@ -77,16 +79,22 @@ func DiffIndex(c *Client, opt DiffIndexOptions, index *Index, tree Treeish, path
 		}
 
 		if entry.Sha1 != fssha {
-			val = append(val, HashDiff{entry.PathName, treeObjects[entry.PathName].Tree, TreeEntry{Sha1: Sha1{}, FileMode: mode}, treeObjects[entry.PathName].Size, 0})
+			val = append(val, HashDiff{Name: entry.PathName, Src: treeObjects[entry.PathName].Tree, Dst: TreeEntry{FileMode: mode}, SrcSize: treeObjects[entry.PathName].Size})
 		} else if !ok {
-			val = append(val, HashDiff{entry.PathName, TreeEntry{}, TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, 0, fsize})
+			val = append(val, HashDiff{Name: entry.PathName, Dst: TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, DstSize: fsize})
 		} else if entry.Sha1 != treeSha.Tree.Sha1 {
-			val = append(val, HashDiff{entry.PathName, treeSha.Tree, TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, treeSha.Size, fsize})
-		} else {
-			if err != nil {
-				return nil, err
-			}
+			val = append(val, HashDiff{Name: entry.PathName, Src: treeSha.Tree, Dst: TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, SrcSize: treeSha.Size, DstSize: fsize})
+		} else if opt.FindCopiesHarder {
+			unmodified = append(unmodified, HashDiff{Name: entry.PathName, Src: treeSha.Tree, Dst: treeSha.Tree, SrcSize: treeSha.Size, DstSize: fsize})
+		}
+	}
+
+	// Files in the tree which aren't in the index were deleted.
+	for name, obj := range treeObjects {
+		if !inIndex[name] {
+			val = append(val, HashDiff{Name: name, Src: obj.Tree, SrcSize: obj.Size})
 		}
 	}
-	return val, nil
+	sort.Sort(ByName(val))
+	return detectRenames(c, opt.DiffCommonOptions, val, unmodified)
 }
@@ -0,0 +1,297 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A fileStat is the number of lines added to and deleted from a file, or
+// the new and old sizes of a binary file, as shown in a diffstat.
+type fileStat struct {
+	Name           string
+	Added, Deleted int
+	Binary         bool
+}
+
+// Calculates the diffstat for each file in diffs which changed.
+func diffStats(c *Client, diffs []HashDiff) ([]fileStat, error) {
+	var stats []fileStat
+	for _, diff := range diffs {
+		srcSha, src, err := diff.contents(c, diff.Src)
+		if err != nil {
+			return nil, err
+		}
+		dstSha, dst, err := diff.contents(c, diff.Dst)
+		if err != nil {
+			return nil, err
+		}
+		if srcSha == dstSha && diff.Src.FileMode == diff.Dst.FileMode && diff.OldName == "" {
+			continue
+		}
+		stat := fileStat{Name: diff.Name.String()}
+		if diff.OldName != "" {
+			stat.Name = prettyRename(diff.OldName.String(), diff.Name.String())
+		}
+		switch {
+		case isBinary(src) || isBinary(dst):
+			stat.Binary = true
+			if srcSha != dstSha {
+				stat.Added, stat.Deleted = len(dst), len(src)
+			}
+		case !bytes.Equal(src, dst):
+			patch, err := externalDiff(src, dst, "a", "b", 0)
+			if err != nil {
+				return nil, err
+			}
+			stat.Added, stat.Deleted = countChangedLines(patch)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// Returns the name of a file which was renamed or copied from a to b, as
+// shown in diffstats, with the common leading and trailing directories in
+// braces outside of the change. For example, "dir/{a => b}/file".
+func prettyRename(a, b string) string {
+	// The common prefix and suffix must end and start at a slash.
+	pfx := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			pfx = i + 1
+		}
+	}
+	sfx := 0
+	adjust := 0
+	if pfx > 0 {
+		// Let the suffix start at the slash which ends the prefix.
+		adjust = 1
+	}
+	for i, j := len(a)-1, len(b)-1; i >= pfx-adjust && j >= pfx-adjust && a[i] == b[j]; i, j = i-1, j-1 {
+		if a[i] == '/' {
+			sfx = len(a) - i
+		}
+	}
+	aMid, bMid := len(a)-pfx-sfx, len(b)-pfx-sfx
+	if aMid < 0 {
+		aMid = 0
+	}
+	if bMid < 0 {
+		bMid = 0
+	}
+	if pfx+sfx == 0 {
+		return a + " => " + b
+	}
+	return a[:pfx] + "{" + a[pfx:pfx+aMid] + " => " + b[pfx:pfx+bMid] + "}" + a[len(a)-sfx:]
+}
+
+// Counts the lines added and deleted by the unified diff patch.
+func countChangedLines(patch string) (added, deleted int) {
+	inHunk := false
+	for _, line := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+		case !inHunk:
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			deleted++
+		}
+	}
+	return added, deleted
+}
+
+// Writes stats in the format of --numstat.
+func writeNumStat(w io.Writer, stats []fileStat) {
+	for _, stat := range stats {
+		if stat.Binary {
+			fmt.Fprintf(w, "-\t-\t%v\n", stat.Name)
+		} else {
+			fmt.Fprintf(w, "%d\t%d\t%v\n", stat.Added, stat.Deleted, stat.Name)
+		}
+	}
+}
+
+// Writes the last line of the diffstat of stats, which summarizes the
+// number of files changed and lines inserted and deleted.
+func writeShortStat(w io.Writer, stats []fileStat) {
+	var adds, dels int
+	for _, stat := range stats {
+		if !stat.Binary {
+			adds += stat.Added
+			dels += stat.Deleted
+		}
+	}
+	writeStatSummary(w, len(stats), adds, dels)
+}
+
+func writeStatSummary(w io.Writer, files, adds, dels int) {
+	if files == 0 {
+		fmt.Fprintln(w, " 0 files changed")
+		return
+	}
+	plural := func(n int, singular, plural string) string {
+		if n == 1 {
+			return fmt.Sprintf(singular, n)
+		}
+		return fmt.Sprintf(plural, n)
+	}
+	s := plural(files, " %d file changed", " %d files changed")
+	// As in git, a lack of insertions is only mentioned if there were
+	// no deletions either.
+	if adds != 0 || dels == 0 {
+		s += plural(adds, ", %d insertion(+)", ", %d insertions(+)")
+	}
+	if dels != 0 || adds == 0 {
+		s += plural(dels, ", %d deletion(-)", ", %d deletions(-)")
+	}
+	fmt.Fprintln(w, s)
+}
+
+// Writes stats as a diffstat, with a graph of the changes to each file
+// which is scaled to fit the width of the terminal or opts.StatWidth.
+func writeStat(w io.Writer, stats []fileStat, opts DiffCommonOptions) {
+	if len(stats) == 0 {
+		return
+	}
+	reset, addColor, delColor := "", "", ""
+	if opts.Color {
+		reset, addColor, delColor = colorReset, colorGreen, colorRed
+	}
+	count := len(stats)
+	if opts.StatCount > 0 && opts.StatCount < count {
+		count = opts.StatCount
+	}
+
+	// Find the longest name and the largest change of the shown files.
+	var maxLen, maxChange, binWidth, numberWidth int
+	for _, stat := range stats[:count] {
+		if l := displayWidth(stat.Name); l > maxLen {
+			maxLen = l
+		}
+		if stat.Binary {
+			// "Bin XXX -> YYY bytes"
+			if w := 14 + decimalWidth(stat.Added) + decimalWidth(stat.Deleted); w > binWidth {
+				binWidth = w
+			}
+			numberWidth = 3
+			continue
+		}
+		if change := stat.Added + stat.Deleted; change > maxChange {
+			maxChange = change
+		}
+	}
+	if w := decimalWidth(maxChange); w > numberWidth {
+		numberWidth = w
+	}
+
+	// Each line is " name | NNN +++---", so the name and graph share
+	// what's left of the width after the other 6 columns and the number.
+	width := opts.StatWidth
+	if width <= 0 {
+		width = terminalColumns()
+	}
+	if width < 16+6+numberWidth {
+		width = 16 + 6 + numberWidth
+	}
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxLen
+	if opts.StatNameWidth > 0 && opts.StatNameWidth < maxLen {
+		nameWidth = opts.StatNameWidth
+	}
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = width*3/8 - numberWidth - 6
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	for _, stat := range stats[:count] {
+		// Long names are truncated from the start, at a directory
+		// boundary if possible.
+		name, prefix := stat.Name, ""
+		if displayWidth(name) > nameWidth {
+			prefix = "..."
+			for displayWidth(name) > nameWidth-3 && name != "" {
+				_, n := utf8.DecodeRuneInString(name)
+				name = name[n:]
+			}
+			if slash := strings.IndexByte(name, '/'); slash >= 0 {
+				name = name[slash:]
+			}
+		}
+		padding := nameWidth - len(prefix) - displayWidth(name)
+		if padding < 0 {
+			padding = 0
+		}
+		name = prefix + name + strings.Repeat(" ", padding)
+
+		if stat.Binary {
+			if stat.Added == 0 && stat.Deleted == 0 {
+				fmt.Fprintf(w, " %v | %*v\n", name, numberWidth, "Bin")
+			} else {
+				fmt.Fprintf(w, " %v | %*v %v%d%v -> %v%d%v bytes\n", name, numberWidth, "Bin", delColor, stat.Deleted, reset, addColor, stat.Added, reset)
+			}
+			continue
+		}
+
+		add, del := stat.Added, stat.Deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(add+del, graphWidth, maxChange)
+			if total < 2 && add != 0 && del != 0 {
+				total = 2
+			}
+			if add < del {
+				add = scaleLinear(add, graphWidth, maxChange)
+				del = total - add
+			} else {
+				del = scaleLinear(del, graphWidth, maxChange)
+				add = total - del
+			}
+		}
+		space := ""
+		if stat.Added+stat.Deleted != 0 {
+			space = " "
+		}
+		fmt.Fprintf(w, " %v | %*d%v", name, numberWidth, stat.Added+stat.Deleted, space)
+		if add > 0 {
+			fmt.Fprint(w, addColor+strings.Repeat("+", add)+reset)
+		}
+		if del > 0 {
+			fmt.Fprint(w, delColor+strings.Repeat("-", del)+reset)
+		}
+		fmt.Fprintln(w)
+	}
+	if count < len(stats) {
+		fmt.Fprintln(w, " ...")
+	}
+	writeShortStat(w, stats)
+}
+
+// Scales it to fit in width, such that max fills the width and anything
+// other than 0 uses at least one column.
+func scaleLinear(it, width, max int) int {
+	if it == 0 {
+		return 0
+	}
+	return 1 + it*(width-1)/max
+}
+
+func decimalWidth(n int) int {
+	return len(strconv.Itoa(n))
+}
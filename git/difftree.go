@@ -7,15 +7,10 @@ import (
 )
 
 // Describes the options that may be specified on the command line for
-// "git diff-index". Note that only raw mode is currently supported, even
-// though all the other options are parsed/set in this struct.
+// "git diff-tree". Note that many of the options are parsed/set in this
+// struct without being supported.
 type DiffTreeOptions struct {
-	Patch bool
-
-	// The 0 value implies 3.
-	NumContextLines int
-
-	Raw bool
+	DiffCommonOptions
 
 	// Unimplemented. Probably never will be.
 	CompactionHeuristic bool
@@ -23,30 +18,17 @@ type DiffTreeOptions struct {
 	// Can be "default", "myers", "minimal", "patience", or "histogram"
 	DiffAlgorithm string
 
-	StatWidth, StatNameWidth, StatCount int
-	NumStat                             bool
-	ShortStat                           bool
-
 	DirStat string
 
-	Summary bool
-
 	NullTerminate bool
 
-	NameOnly, NameStatus bool
-
 	Submodule string
 
-	// Colour can have three states: "always" (true), "never" (false), or "auto" (nil)
-	Color *bool
-
 	// "color", "plain", "porcelain", or "none"
 	WordDiff string
 
 	WordDiffRegex *regexp.Regexp
 
-	NoRenames bool
-
 	// Warn if changes introduce conflict markers or whitespace errors.
 	Check bool
 
@@ -55,9 +37,6 @@ type DiffTreeOptions struct {
 
 	FullIndex, Binary bool
 
-	// Number of characters to abbreviate the hexadecimal object name to.
-	Abbrev int
-
 	// Recurse into subtrees.
 	Recurse bool
 
@@ -68,7 +47,31 @@ type DiffTreeOptions struct {
 	// format anyways.
 }
 
+// DiffTree compares the trees tree1 and tree2, or tree1 against its parent
+// if tree2 is nil, and returns the changes to the files matching paths (or
+// all files if paths is empty). Renames are detected among the matching
+// files as set in opt.
 func DiffTree(c *Client, opt *DiffTreeOptions, tree1, tree2 Treeish, paths []string) ([]HashDiff, error) {
+	var pathspec []IndexPath
+	for _, p := range paths {
+		pathspec = append(pathspec, IndexPath(p))
+	}
+	matches := func(diffs []HashDiff) []HashDiff {
+		if len(pathspec) == 0 {
+			return diffs
+		}
+		var val []HashDiff
+		for _, diff := range diffs {
+			name := diff.Name.String()
+			// Without recursing, the subtree containing a path is
+			// shown.
+			if pathspecMatches(pathspec, name) || (!opt.Recurse && pathspecMayMatchUnder(pathspec, name)) {
+				val = append(val, diff)
+			}
+		}
+		return val
+	}
+
 	t1, err := tree1.TreeID(c)
 	if err != nil {
 		return nil, err
@@ -82,7 +85,7 @@ func DiffTree(c *Client, opt *DiffTreeOptions, tree1, tree2 Treeish, paths []str
 		}
 		t2 = t
 	} else {
-		// No tree, use tree 1's parent
+		// No tree, compare tree 1 against its parent
 		if c1, ok := tree1.(Commitish); ok {
 			c1a, err := c1.CommitID(c)
 			if err != nil {
@@ -92,19 +95,20 @@ func DiffTree(c *Client, opt *DiffTreeOptions, tree1, tree2 Treeish, paths []str
 			if err != nil {
 				return nil, err
 			}
+			t2 = t1
 			if len(parents) > 1 {
 				return nil, fmt.Errorf("Parent is a merge commit")
 			} else if len(parents) == 0 {
 				if !opt.Root {
 					return nil, nil
 				}
-				t2 = TreeID{}
+				t1 = TreeID{}
 			} else {
 				ptree, err := parents[0].TreeID(c)
 				if err != nil {
 					return nil, err
 				}
-				t2 = ptree
+				t1 = ptree
 			}
 
 		} else {
@@ -112,31 +116,33 @@ func DiffTree(c *Client, opt *DiffTreeOptions, tree1, tree2 Treeish, paths []str
 		}
 	}
 
-	tree1Objects, err := t1.GetAllObjects(c, "", opt.Recurse, opt.Recurse)
+	tree2Objects, err := diffTreeObjects(c, t2, opt.Recurse)
 	if err != nil {
 		return nil, err
 	}
-	if opt.Root && t2 == (TreeID{}) {
+	if opt.Root && t1 == (TreeID{}) {
 		// There is no parent to check against and we --root was
-		// passed, so just include everything from tree1
-		var val []HashDiff = make([]HashDiff, 0, len(tree1Objects))
-		for name, sha := range tree1Objects {
-			val = append(val, HashDiff{name, TreeEntry{}, sha, 0, 0})
+		// passed, so just include everything from tree2
+		var val []HashDiff = make([]HashDiff, 0, len(tree2Objects))
+		for name, sha := range tree2Objects {
+			val = append(val, HashDiff{Name: name, Dst: sha})
 		}
 		sort.Sort(ByName(val))
 
-		return val, nil
+		return detectRenames(c, opt.DiffCommonOptions, matches(val), nil)
 	}
-	tree2Objects, err := t2.GetAllObjects(c, "", opt.Recurse, opt.Recurse)
+	tree1Objects, err := diffTreeObjects(c, t1, opt.Recurse)
 	if err != nil {
 		return nil, err
 	}
 
-	var val []HashDiff
+	var val, unmodified []HashDiff
 
 	for name, sha := range tree1Objects {
 		if osha := tree2Objects[name]; sha != osha {
-			val = append(val, HashDiff{name, sha, osha, 0, 0})
+			val = append(val, HashDiff{Name: name, Src: sha, Dst: osha})
+		} else if opt.FindCopiesHarder {
+			unmodified = append(unmodified, HashDiff{Name: name, Src: sha, Dst: osha})
 		}
 	}
 
@@ -144,11 +150,26 @@ func DiffTree(c *Client, opt *DiffTreeOptions, tree1, tree2 Treeish, paths []str
 	// would have gotten caught by the above ranging.
 	for name, sha := range tree2Objects {
 		if _, ok := tree1Objects[name]; !ok {
-			val = append(val, HashDiff{name, TreeEntry{Sha1{}, 0}, sha, 0, 0})
+			val = append(val, HashDiff{Name: name, Dst: sha})
 		}
 	}
 
 	sort.Sort(ByName(val))
 
-	return val, nil
+	return detectRenames(c, opt.DiffCommonOptions, matches(val), matches(unmodified))
+}
+
+// Returns the entries of t which are compared by DiffTree. When recursing,
+// only the files inside subtrees are compared, not the subtrees themselves.
+func diffTreeObjects(c *Client, t TreeID, recurse bool) (map[IndexPath]TreeEntry, error) {
+	objects, err := t.GetAllObjects(c, "", recurse, recurse)
+	if err != nil || !recurse {
+		return objects, err
+	}
+	for name, entry := range objects {
+		if entry.FileMode == ModeTree || entry.FileMode == modeGit9Tree {
+			delete(objects, name)
+		}
+	}
+	return objects, nil
 }
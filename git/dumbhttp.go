@@ -0,0 +1,367 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Tries to parse body as the info/refs file from a dumb http server. If it
+// is one, s is set up to use the dumb protocol and true is returned.
+func (s *smartHTTPConn) openDumb(body io.Reader) bool {
+	refs, err := parseInfoRefs(body)
+	if err != nil || len(refs) == 0 {
+		log.Printf("Not a dumb http server: %v\n", err)
+		return false
+	}
+	log.Printf("Using dumb http protocol for %v\n", s.giturl)
+
+	// info/refs doesn't include HEAD, so get it separately in order to
+	// advertise it the same way that the smart protocol would.
+	if resp, err := s.get("HEAD"); err == nil {
+		head, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == 200 {
+			if target := strings.TrimSpace(strings.TrimPrefix(string(head), "ref: ")); target != "" {
+				for _, r := range refs {
+					if r.Name == target {
+						refs = append([]Ref{{"HEAD", r.Value}}, refs...)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	s.dumb = true
+	s.protocolversion = 1
+	s.capabilities = make(map[string]map[string]struct{})
+	s.refs = refs
+	return true
+}
+
+// Parses an info/refs file, as generated by update-server-info.
+func parseInfoRefs(r io.Reader) ([]Ref, error) {
+	var refs []Ref
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		tab := strings.IndexByte(line, '\t')
+		if tab != 40 {
+			return nil, fmt.Errorf("Invalid info/refs line: %v", line)
+		}
+		sha, err := Sha1FromString(line[:tab])
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, Ref{Name: line[tab+1:], Value: sha})
+	}
+	return refs, scanner.Err()
+}
+
+// Gets the file at path relative to the repository over http.
+func (s *smartHTTPConn) get(path string) (*http.Response, error) {
+	req, err := s.client.newRequest("GET", s.giturl+"/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == 401 {
+		resp.Body.Close()
+		s.rejectCredentials()
+		return nil, fmt.Errorf("Authentication failed for '%s'", s.giturl)
+	}
+	return resp, nil
+}
+
+// A dumbHTTPWalker retrieves objects from a dumb http server by walking
+// the object graph, getting each object either loosely or from the pack
+// that contains it.
+type dumbHTTPWalker struct {
+	c    *Client
+	conn *smartHTTPConn
+	opts FetchPackOptions
+
+	// The packs on the server which haven't been downloaded yet.
+	// nil until objects/info/packs has been loaded.
+	packs []string
+
+	// The objects in each pack, from the pack's index.
+	packObjects map[string]map[Sha1]struct{}
+}
+
+// fetchDumbHTTP fetches the objects reachable from wants from a dumb
+// http server, and returns the remote refs that were fetched.
+//
+// As with git's walker, any object that we already have is assumed to be
+// complete, and the walk doesn't continue past it.
+func fetchDumbHTTP(c *Client, opts FetchPackOptions, conn *smartHTTPConn, wants []Refname) ([]Ref, error) {
+	rs := make([]string, len(wants))
+	for i := range wants {
+		rs[i] = string(wants[i])
+	}
+	refs, err := conn.GetRefs(LsRemoteOptions{Heads: true, Tags: true, RefsOnly: true}, rs)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []Sha1
+	for _, refname := range wants {
+		if sha, err := Sha1FromString(string(refname)); err == nil {
+			objects = append(objects, sha)
+		}
+	}
+	for _, ref := range refs {
+		objects = append(objects, ref.Value)
+	}
+
+	w := &dumbHTTPWalker{
+		c:           c,
+		conn:        conn,
+		opts:        opts,
+		packObjects: make(map[string]map[Sha1]struct{}),
+	}
+	if err := w.walk(objects); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// Retrieves the objects in roots, and everything that they reference.
+func (w *dumbHTTPWalker) walk(roots []Sha1) error {
+	seen := make(map[Sha1]struct{})
+	for len(roots) > 0 {
+		id := roots[len(roots)-1]
+		roots = roots[:len(roots)-1]
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+
+		have, _, err := w.c.HaveObject(id)
+		if err != nil {
+			return err
+		}
+		if have {
+			continue
+		}
+		if err := w.fetch(id); err != nil {
+			return err
+		}
+		if w.opts.Verbose {
+			progressF("Walking objects: %d", false, len(seen))
+		}
+		refs, err := w.references(id)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, refs...)
+	}
+	if w.opts.Verbose && len(seen) > 0 {
+		progressF("Walking objects: %d", true, len(seen))
+	}
+	return nil
+}
+
+// Returns the objects that the object id references.
+func (w *dumbHTTPWalker) references(id Sha1) ([]Sha1, error) {
+	obj, err := w.c.GetObject(id)
+	if err != nil {
+		return nil, err
+	}
+	var refs []Sha1
+	switch obj.GetType() {
+	case "commit":
+		tree, err := Sha1FromString(getObjectHeader(obj.GetContent(), "tree"))
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, tree)
+		parents, err := CommitID(id).Parents(w.c)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range parents {
+			refs = append(refs, Sha1(p))
+		}
+	case "tag":
+		obj, err := Sha1FromString(getObjectHeader(obj.GetContent(), "object"))
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, obj)
+	case "tree":
+		content := obj.GetContent()
+		for i := 0; i < len(content); {
+			_, entry, size, err := parseRawTreeLine(i, content)
+			if err != nil {
+				return nil, err
+			}
+			i += size
+			if entry.FileMode == ModeCommit {
+				// Submodules aren't part of this repository.
+				continue
+			}
+			refs = append(refs, entry.Sha1)
+		}
+	}
+	return refs, nil
+}
+
+// Retrieves the object id from the server, either loosely or by
+// downloading the pack that contains it.
+func (w *dumbHTTPWalker) fetch(id Sha1) error {
+	if ok, err := w.fetchLoose(id); ok || err != nil {
+		return err
+	}
+	if w.packs == nil {
+		if err := w.loadPacks(); err != nil {
+			return err
+		}
+	}
+	for i, pack := range w.packs {
+		objects, err := w.packIndex(pack)
+		if err != nil {
+			return err
+		}
+		if _, ok := objects[id]; !ok {
+			continue
+		}
+		if err := w.fetchPack(pack); err != nil {
+			return err
+		}
+		w.packs = append(w.packs[:i], w.packs[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("Could not find object %v on remote", id)
+}
+
+// Tries to retrieve the loose object id from the server. Returns false
+// if the server doesn't have it loosely.
+func (w *dumbHTTPWalker) fetchLoose(id Sha1) (bool, error) {
+	path := fmt.Sprintf("objects/%02x/%018x", id[0], id[1:])
+	resp, err := w.conn.get(path)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		log.Printf("Could not get %v: %v\n", path, resp.Status)
+		return false, nil
+	}
+	zr, err := zlib.NewReader(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	defer zr.Close()
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return false, err
+	}
+	if got := Sha1(sha1.Sum(raw)); got != id {
+		return false, fmt.Errorf("Object %v from remote has hash %v", id, got)
+	}
+	nul := bytes.IndexByte(raw, 0)
+	if nul < 0 {
+		return false, fmt.Errorf("Invalid object %v from remote", id)
+	}
+	header := strings.Fields(string(raw[:nul]))
+	if len(header) != 2 {
+		return false, fmt.Errorf("Invalid object header for %v from remote", id)
+	}
+	if size, err := strconv.Atoi(header[1]); err != nil || size != len(raw)-nul-1 {
+		return false, fmt.Errorf("Invalid object size for %v from remote", id)
+	}
+	if _, err := w.c.WriteObject(header[0], raw[nul+1:]); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Loads the list of packs on the server from objects/info/packs.
+func (w *dumbHTTPWalker) loadPacks() error {
+	w.packs = []string{}
+	resp, err := w.conn.get("objects/info/packs")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		// There are no packs.
+		return nil
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "P ") {
+			continue
+		}
+		name := strings.TrimSpace(line[2:])
+		if !strings.HasPrefix(name, "pack-") || !strings.HasSuffix(name, ".pack") {
+			continue
+		}
+		w.packs = append(w.packs, strings.TrimSuffix(name, ".pack"))
+	}
+	return scanner.Err()
+}
+
+// Returns the set of objects in pack, downloading the pack's index if it
+// hasn't been downloaded yet.
+func (w *dumbHTTPWalker) packIndex(pack string) (map[Sha1]struct{}, error) {
+	if objects, ok := w.packObjects[pack]; ok {
+		return objects, nil
+	}
+	resp, err := w.conn.get("objects/pack/" + pack + ".idx")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Could not get index for %v: %v", pack, resp.Status)
+	}
+	idx, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// Only version 2 indexes are supported.
+	if len(idx) < 8+256*4 || !bytes.Equal(idx[:8], []byte{0377, 't', 'O', 'c', 0, 0, 0, 2}) {
+		return nil, fmt.Errorf("Unsupported pack index for %v", pack)
+	}
+	if n := binary.BigEndian.Uint32(idx[8+255*4:]); len(idx) < 8+256*4+int(n)*20 {
+		return nil, fmt.Errorf("Truncated pack index for %v", pack)
+	}
+	objects := make(map[Sha1]struct{})
+	for _, obj := range v2PackObjectListFromIndex(bytes.NewReader(idx)) {
+		objects[obj] = struct{}{}
+	}
+	w.packObjects[pack] = objects
+	return objects, nil
+}
+
+// Downloads pack from the server, and indexes it into the local
+// repository.
+func (w *dumbHTTPWalker) fetchPack(pack string) error {
+	log.Printf("Fetching pack %v\n", pack)
+	resp, err := w.conn.get("objects/pack/" + pack + ".pack")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Could not get %v: %v", pack, resp.Status)
+	}
+	_, err = IndexAndCopyPack(w.c, IndexPackOptions{Verbose: w.opts.Verbose}, resp.Body)
+	return err
+}
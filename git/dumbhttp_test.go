@@ -0,0 +1,166 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDumbHTTPFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitdumbhttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcdir := filepath.Join(dir, "src")
+	src, err := Init(nil, InitOptions{Quiet: true}, srcdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcdir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(src, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := Commit(src, CommitOptions{}, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move everything from the first commit into a pack, so that the
+	// fetch needs to use both packs and loose objects.
+	objects, err := first.GetAllObjectsExcept(src, make(map[Sha1]struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects = append(objects, Sha1(first))
+	var pack bytes.Buffer
+	if _, err := PackObjects(src, PackObjectsOptions{}, &pack, objects); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IndexAndCopyPack(src, IndexPackOptions{}, &pack); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objects {
+		os.Remove(filepath.Join(srcdir, ".git", "objects", obj.String()[:2], obj.String()[2:]))
+	}
+
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\nbar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(src, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := Commit(src, CommitOptions{}, "second", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateServerInfo(src, UpdateServerInfoOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ioutil.ReadFile(filepath.Join(srcdir, ".git", "info", "refs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := second.String() + "\trefs/heads/master\n"; string(info) != want {
+		t.Errorf("Unexpected info/refs: got %q want %q", info, want)
+	}
+	packs, err := ioutil.ReadFile(filepath.Join(srcdir, ".git", "objects", "info", "packs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(packs, []byte("P pack-")) || !bytes.HasSuffix(packs, []byte(".pack\n\n")) {
+		t.Errorf("Unexpected objects/info/packs: got %q", packs)
+	}
+
+	ts := httptest.NewServer(http.FileServer(http.Dir(filepath.Join(srcdir, ".git"))))
+	defer ts.Close()
+
+	dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "dst.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs, err := FetchPack(dst, FetchPackOptions{All: true}, Remote(ts.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].Name != "HEAD" || refs[1].Name != "refs/heads/master" || refs[1].Value != Sha1(second) {
+		t.Errorf("Unexpected refs from dumb http remote: %v", refs)
+	}
+	for _, obj := range append(objects, Sha1(second)) {
+		if have, _, err := dst.HaveObject(obj); !have || err != nil {
+			t.Errorf("Object %v was not fetched: %v", obj, err)
+		}
+	}
+}
+
+func TestUpdateServerInfoPackedRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitserverinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := Commit(c, CommitOptions{}, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := TagCommit(c, TagOptions{Annotated: true}, "v1", cmt, "v1\n"); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := RefSpec("refs/tags/v1").Sha1(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move both refs into packed-refs, the way git pack-refs --all does.
+	packed := "# pack-refs with: peeled fully-peeled sorted \n" +
+		cmt.String() + " refs/heads/master\n" +
+		tag.String() + " refs/tags/v1\n" +
+		"^" + cmt.String() + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, ".git", "packed-refs"), []byte(packed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"refs/heads/master", "refs/tags/v1"} {
+		if err := os.Remove(filepath.Join(dir, ".git", ref)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := UpdateServerInfo(c, UpdateServerInfoOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ioutil.ReadFile(filepath.Join(dir, ".git", "info", "refs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := cmt.String() + "\trefs/heads/master\n" +
+		tag.String() + "\trefs/tags/v1\n" +
+		cmt.String() + "\trefs/tags/v1^{}\n"
+	if string(info) != want {
+		t.Errorf("Unexpected info/refs: got %q want %q", info, want)
+	}
+}
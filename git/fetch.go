@@ -2,75 +2,712 @@ package git
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"strings"
 )
 
 type FetchOptions struct {
 	Force bool
+
+	// Append to FETCH_HEAD instead of overwriting it.
+	Append bool
+
+	// Show what would be done, without fetching objects or
+	// updating any refs.
+	DryRun bool
+
+	// Remove remote-tracking refs which no longer exist on the remote.
+	// If neither Prune nor NoPrune is set, the remote.<name>.prune and
+	// fetch.prune config values are used.
+	Prune, NoPrune bool
+
+	// Also prune local tags which no longer exist on the remote.
+	PruneTags bool
+
+	// Fetch all tags from the remote (Tags) or don't follow tags at
+	// all (NoTags). By default tags which point to fetched objects are
+	// fetched too.
+	Tags, NoTags bool
+
+	// Allow the ref of the current branch to be updated.
+	UpdateHeadOK bool
+
 	FetchPackOptions
 }
 
+// A fetchRef is a remote ref being fetched and the local ref, if any, which
+// it's being stored in.
+type fetchRef struct {
+	Ref
+	Local Refname
+	Force bool
+
+	// Whether the ref is marked for merging in FETCH_HEAD
+	Merge bool
+
+	// Set for remote-tracking refs which are opportunistically updated
+	// when fetching a refspec given on the command line. They aren't
+	// written to FETCH_HEAD.
+	NoFetchHead bool
+}
+
+// A fetchStatus is a line of the summary of ref updates printed by fetch.
+type fetchStatus struct {
+	Flag     byte
+	Summary  string
+	From, To string
+	Reason   string
+}
+
 // Fetch implements the "git fetch" command, fetching  refs from rmt.
-// If refs is nil, all remote refs will be fetched from the remote.
+// If refs is nil, the refspecs configured for the remote will be used.
 func Fetch(c *Client, opts FetchOptions, rmt Remote, refs []RefSpec) error {
-	opts.FetchPackOptions.All = (refs == nil)
-	opts.FetchPackOptions.Verbose = true
+	result, err := fetch(c, opts, rmt, refs)
+	if err != nil {
+		return err
+	}
+	if !opts.DryRun {
+		if err := writeFetchHead(c, opts.Append, result.URL, result.Refs); err != nil {
+			return err
+		}
+	}
+	if !opts.Quiet {
+		printFetchStatus(result.URL, result.Status)
+	}
+	if result.Rejected {
+		return fmt.Errorf("some local refs could not be updated")
+	}
+	return nil
+}
+
+// The result of fetching from a remote.
+type fetchResult struct {
+	URL string
 
-	// If none were provided then we check to see if there are any
-	//  configured refspecs for this remote
+	// The branch that the remote's HEAD points to, if known.
+	Head string
+
+	Refs     []fetchRef
+	Status   []fetchStatus
+	Rejected bool
+}
+
+// Fetches refs from rmt and updates the local refs, without writing
+// FETCH_HEAD or printing anything.
+func fetch(c *Client, opts FetchOptions, rmt Remote, refs []RefSpec) (*fetchResult, error) {
+	url := c.GetConfig(fmt.Sprintf("remote.%v.url", rmt))
+	named := url != ""
+	if !named {
+		url = rmt.String()
+	}
+	configured := make([]RefSpec, 0)
+	for _, spec := range c.GetConfigAll(fmt.Sprintf("remote.%v.fetch", rmt)) {
+		configured = append(configured, RefSpec(spec))
+	}
+
+	specs := refs
 	if refs == nil {
-		// FIXME this only handles one value for the configuration while there can be many with different refspecs for this operation
-		cfg := c.GetConfig(fmt.Sprintf("remote.%s.fetch", rmt))
-		if cfg != "" {
-			refs = []RefSpec{RefSpec(cfg)}
+		specs = configured
+		if !named && len(specs) == 0 {
+			// Fetching from a URL without any refspecs only
+			// fetches HEAD into FETCH_HEAD.
+			specs = []RefSpec{"HEAD"}
 		}
 	}
 
-	var wants []Refname
+	prune, prunetags := opts.Prune, opts.PruneTags
+	if !opts.Prune && !opts.NoPrune {
+		prune = fetchConfigBool(c, rmt, "prune")
+		prunetags = prunetags || fetchConfigBool(c, rmt, "pruneTags")
+	}
+	if prune && prunetags {
+		specs = append(specs, "+refs/tags/*:refs/tags/*")
+	}
+
+	tagopt := c.GetConfig(fmt.Sprintf("remote.%v.tagOpt", rmt))
+	tags := opts.Tags || (!opts.NoTags && tagopt == "--tags")
+	followtags := !tags && !opts.NoTags && tagopt != "--no-tags"
+
+	conn, err := NewRemoteConn(c, rmt)
+	if err != nil {
+		return nil, err
+	}
+	if opts.UploadPack == "" {
+		opts.UploadPack = "git-upload-pack"
+	}
+	if err := conn.SetService(opts.UploadPack); err != nil {
+		return nil, err
+	}
+	if err := conn.OpenConn(UploadPackService); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	advertised, err := conn.GetRefs(LsRemoteOptions{SymRef: true}, nil)
+	if err != nil {
+		return nil, err
+	}
+	rmtrefs, peeled := splitPeeledRefs(advertised)
+
+	mapped, err := mapFetchRefs(rmtrefs, specs)
+	if err != nil {
+		return nil, err
+	}
+	if refs != nil {
+		// Everything given on the command line is merged.
+		for i := range mapped {
+			mapped[i].Merge = true
+		}
+		mapped = append(mapped, trackingFetchRefs(mapped, configured)...)
+	} else {
+		markFetchMerges(c, rmt, mapped, specs)
+	}
+	if tags {
+		// Tags fetched because of --tags aren't treated as an
+		// explicit refspec, so they're never merged or pruned.
+		tagspecs := []RefSpec{"refs/tags/*:refs/tags/*"}
+		for _, spec := range specs {
+			if strings.HasPrefix(string(spec), "^") {
+				tagspecs = append(tagspecs, spec)
+			}
+		}
+		tagrefs, err := mapFetchRefs(rmtrefs, tagspecs)
+		if err != nil {
+			return nil, err
+		}
+		mapped = appendFetchRefs(mapped, tagrefs)
+	}
+	if followtags {
+		mapped = appendFetchRefs(mapped, followFetchTags(c, rmtrefs, peeled, mapped))
+	}
+	log.Printf("fetch: refs to fetch are %+v\n", mapped)
+
+	head := c.GetHeadBranch()
+	for _, ref := range mapped {
+		if !opts.UpdateHeadOK && !c.IsBare() && ref.Local != "" && ref.Local == Refname(head) {
+			return nil, fmt.Errorf("Refusing to fetch into current branch %v of non-bare repository", head)
+		}
+	}
+
+	wants := make(map[Sha1]struct{})
+	for _, ref := range mapped {
+		if have, _, err := c.HaveObject(ref.Value); err != nil {
+			return nil, err
+		} else if !have {
+			wants[ref.Value] = struct{}{}
+		}
+	}
+	if len(wants) > 0 && !opts.DryRun {
+		// Declare everything we have for this remote as a have, the
+		// same as FetchPack does.
+		haves, err := rmt.GetLocalRefs(c)
+		if err != nil {
+			return nil, err
+		}
+		havemap := make(map[Sha1]struct{})
+		for _, h := range haves {
+			havemap[h.Value] = struct{}{}
+		}
+		var wantnames []Refname
+		for sha := range wants {
+			wantnames = append(wantnames, Refname(sha.String()))
+		}
+		fpopts := opts.FetchPackOptions
+		fpopts.All = false
+		fpopts.IncludeTag = followtags
+		fpopts.Verbose = !opts.Quiet
+		fpopts.NoProgress = fpopts.NoProgress || opts.Quiet
+		if _, err := fetchPackDone(c, fpopts, conn, wantnames, havemap); err != nil && err.Error() != "Already up to date." {
+			return nil, err
+		}
+		if followtags {
+			// The server may have sent annotated tags which we
+			// didn't know to ask for because of include-tag.
+			mapped = appendFetchRefs(mapped, followFetchTags(c, rmtrefs, peeled, mapped))
+		}
+	}
+
+	var status []fetchStatus
+	if prune {
+		stale, err := staleFetchRefs(c, rmtrefs, specs)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range stale {
+			if !opts.DryRun {
+				if err := UpdateRef(c, UpdateRefOptions{Delete: true}, string(ref), CommitID{}, ""); err != nil {
+					return nil, err
+				}
+			}
+			status = append(status, fetchStatus{'-', "[deleted]", "(none)", shortFetchRefName(string(ref)), ""})
+		}
+	}
+	updated, rejected, err := updateFetchRefs(c, opts, rmt, mapped)
+	if err != nil {
+		return nil, err
+	}
+	status = append(status, updated...)
+	return &fetchResult{
+		URL:      url,
+		Head:     remoteHead(conn, advertised, rmtrefs),
+		Refs:     mapped,
+		Status:   status,
+		Rejected: rejected,
+	}, nil
+}
+
+// Returns the value of the remote.<name>.<key> config variable as a boolean,
+// falling back to fetch.<key> if it's not set.
+func fetchConfigBool(c *Client, rmt Remote, key string) bool {
+	if v := c.GetConfig(fmt.Sprintf("remote.%v.%v", rmt, key)); v != "" {
+		return v == "true"
+	}
+	return c.GetConfig("fetch."+key) == "true"
+}
+
+// Appends the refs from add to refs, skipping any which would be stored in
+// a local ref that's already being updated.
+func appendFetchRefs(refs, add []fetchRef) []fetchRef {
+	stored := make(map[Refname]bool)
 	for _, ref := range refs {
-		wants = append(wants, ref.Src())
+		if ref.Local != "" {
+			stored[ref.Local] = true
+		}
 	}
-	newrefs, err := FetchPack(c, opts.FetchPackOptions, rmt, wants)
+	for _, ref := range add {
+		if ref.Local != "" && stored[ref.Local] {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// Separates the peeled values of annotated tags, which are advertised either
+// as a "name^{}" ref (protocol v1) or a "peeled:" attribute (protocol v2),
+// from the advertised refs. The returned map contains the peeled value of
+// every ref, which is the ref's own value if it's not an annotated tag.
+func splitPeeledRefs(advertised []Ref) ([]Ref, map[string]Sha1) {
+	var refs []Ref
+	peeled := make(map[string]Sha1)
+	for _, ref := range advertised {
+		if strings.HasSuffix(ref.Name, "^{}") {
+			peeled[strings.TrimSuffix(ref.Name, "^{}")] = ref.Value
+			continue
+		}
+		fields := strings.Fields(ref.Name)
+		if len(fields) == 0 {
+			continue
+		}
+		ref.Name = fields[0]
+		if _, ok := peeled[ref.Name]; !ok {
+			peeled[ref.Name] = ref.Value
+		}
+		for _, attr := range fields[1:] {
+			if strings.HasPrefix(attr, "peeled:") {
+				if sha, err := Sha1FromString(strings.TrimPrefix(attr, "peeled:")); err == nil {
+					peeled[ref.Name] = sha
+				}
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs, peeled
+}
+
+// Matches name against a side of a refspec which may contain a "*". If it
+// matches, it returns the portion of name which matched the "*".
+func matchRefGlob(pattern, name string) (string, bool) {
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return "", pattern == name
+	}
+	prefix, suffix := pattern[:star], pattern[star+1:]
+	if len(name) < len(prefix)+len(suffix) {
+		return "", false
+	}
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
+
+// Finds the remote ref referred to by the non-glob refspec source src,
+// using the same rules as git to expand abbreviated names.
+func findRemoteRef(rmtrefs []Ref, src Refname) (Ref, bool) {
+	for _, candidate := range []string{
+		string(src),
+		"refs/" + string(src),
+		"refs/tags/" + string(src),
+		"refs/heads/" + string(src),
+		"refs/remotes/" + string(src),
+		"refs/remotes/" + string(src) + "/HEAD",
+	} {
+		for _, ref := range rmtrefs {
+			if ref.Name == candidate {
+				return ref, true
+			}
+		}
+	}
+	return Ref{}, false
+}
+
+// Maps the remote refs to the local refs that they should be stored in
+// according to specs. Refs matching a negative refspec (one starting with
+// "^") are excluded.
+func mapFetchRefs(rmtrefs []Ref, specs []RefSpec) ([]fetchRef, error) {
+	var negative []string
+	for _, spec := range specs {
+		if strings.HasPrefix(string(spec), "^") {
+			negative = append(negative, strings.TrimPrefix(string(spec), "^"))
+		}
+	}
+	excluded := func(name string) bool {
+		for _, n := range negative {
+			if _, ok := matchRefGlob(n, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	var mapped []fetchRef
+	seen := make(map[fetchRef]bool)
+	add := func(ref fetchRef) {
+		if seen[ref] {
+			return
+		}
+		seen[ref] = true
+		mapped = append(mapped, ref)
+	}
+	for _, spec := range specs {
+		if strings.HasPrefix(string(spec), "^") {
+			continue
+		}
+		force := strings.HasPrefix(string(spec), "+")
+		src, dst := string(spec.Src()), string(spec.Dst())
+		if strings.Contains(src, "*") {
+			if dst != "" && !strings.Contains(dst, "*") {
+				return nil, fmt.Errorf("invalid refspec '%v'", spec)
+			}
+			for _, ref := range rmtrefs {
+				m, ok := matchRefGlob(src, ref.Name)
+				if !ok || excluded(ref.Name) {
+					continue
+				}
+				var local Refname
+				if dst != "" {
+					local = Refname(strings.Replace(dst, "*", m, 1))
+				}
+				add(fetchRef{Ref: ref, Local: local, Force: force})
+			}
+			continue
+		}
+		ref, ok := findRemoteRef(rmtrefs, Refname(src))
+		if !ok {
+			sha, err := Sha1FromString(src)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't find remote ref %v", src)
+			}
+			ref = Ref{Name: src, Value: sha}
+		}
+		if excluded(ref.Name) {
+			continue
+		}
+		add(fetchRef{Ref: ref, Local: Refname(dst), Force: force})
+	}
+	return mapped, nil
+}
+
+// Returns the remote-tracking refs that refs given on the command line
+// should update according to the remote's configured refspecs.
+func trackingFetchRefs(mapped []fetchRef, configured []RefSpec) []fetchRef {
+	var rmtrefs []Ref
+	for _, ref := range mapped {
+		rmtrefs = append(rmtrefs, ref.Ref)
+	}
+	var globs []RefSpec
+	for _, spec := range configured {
+		if strings.Contains(string(spec.Src()), "*") || strings.HasPrefix(string(spec), "^") {
+			globs = append(globs, spec)
+		}
+	}
+	tracked, err := mapFetchRefs(rmtrefs, globs)
 	if err != nil {
-		if err.Error() == "Already up to date." {
-			return nil
+		return nil
+	}
+	var tracking []fetchRef
+	for _, ref := range tracked {
+		if ref.Local == "" {
+			continue
 		}
-		return err
+		ref.NoFetchHead = true
+		tracking = append(tracking, ref)
 	}
-	if refs == nil {
-		// Fake a refspec if one wasn't specified so that things to
-		// to the default location under refs.
-		refs = append(
-			refs,
-			RefSpec(fmt.Sprintf("refs/heads/*:refs/remotes/%s/*", rmt)),
-		)
-	}
-	log.Printf("fetch: refspecs are %+v\n", refs)
-	if c.GitDir != "" {
-		log.Printf("fetch: newrefs is %+v\n", newrefs)
-		for _, ref := range newrefs {
-			for _, spec := range refs {
-				if match, dst := ref.MatchesRefSpecSrc(spec); match {
-					if !dst.Exists(c) {
-						fmt.Printf("[new branch] %v", dst)
-					} else {
-						fmt.Printf("%v %v", ref.Value, dst)
-					}
-					err := UpdateRef(
-						c,
-						UpdateRefOptions{NoDeref: true},
-						string(dst),
-						CommitID(ref.Value),
-						"Ref updated by fetch",
-					)
-					if err != nil {
-						// FIXME: I don't think we should be
-						// erroring out here.
-						return err
+	return appendFetchRefs(mapped, tracking)[len(mapped):]
+}
+
+// Marks the refs which should be merged in FETCH_HEAD when the remote's
+// configured refspecs are used. These are the branch.<name>.merge refs of
+// the current branch if it tracks rmt, otherwise the ref from the first
+// refspec if it's not a glob.
+func markFetchMerges(c *Client, rmt Remote, mapped []fetchRef, specs []RefSpec) {
+	branch := c.GetHeadBranch().BranchName()
+	if branch != "" && c.GetConfig("branch."+branch+".remote") == rmt.String() {
+		if merges := c.GetConfigAll("branch." + branch + ".merge"); len(merges) > 0 {
+			for i := range mapped {
+				for _, merge := range merges {
+					if mapped[i].Name == merge {
+						mapped[i].Merge = true
 					}
 				}
 			}
+			return
 		}
 	}
-	return nil
+	if len(specs) == 0 || len(mapped) == 0 {
+		return
+	}
+	if first := string(specs[0]); !strings.HasPrefix(first, "^") && !strings.Contains(first, "*") {
+		mapped[0].Merge = true
+	}
+}
+
+// Returns the remote tags which should be automatically followed, which are
+// tags that don't exist locally and point to an object that is being fetched
+// or that we already have.
+func followFetchTags(c *Client, rmtrefs []Ref, peeled map[string]Sha1, mapped []fetchRef) []fetchRef {
+	fetching := make(map[Sha1]bool)
+	stored := make(map[string]bool)
+	for _, ref := range mapped {
+		fetching[ref.Value] = true
+		stored[ref.Name] = true
+		stored[string(ref.Local)] = true
+	}
+	var tags []fetchRef
+	for _, ref := range rmtrefs {
+		if !strings.HasPrefix(ref.Name, "refs/tags/") || stored[ref.Name] {
+			continue
+		}
+		if Refname(ref.Name).Exists(c) {
+			continue
+		}
+		// Annotated tags are followed based on the object they
+		// peel to, since the tag object itself isn't local yet.
+		target, ok := peeled[ref.Name]
+		if !ok {
+			target = ref.Value
+		}
+		if !fetching[target] {
+			have, _, err := c.HaveObject(target)
+			if err != nil || !have {
+				continue
+			}
+		}
+		tags = append(tags, fetchRef{Ref: ref, Local: Refname(ref.Name)})
+	}
+	return tags
+}
+
+// Returns the name of ref to display in the fetch summary.
+func shortFetchRefName(ref string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if strings.HasPrefix(ref, prefix) {
+			return strings.TrimPrefix(ref, prefix)
+		}
+	}
+	return ref
+}
+
+// Returns the local refs matching the destination of specs which no longer
+// have a corresponding ref on the remote.
+func staleFetchRefs(c *Client, rmtrefs []Ref, specs []RefSpec) ([]Refname, error) {
+	localrefs, err := ShowRef(c, ShowRefOptions{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool)
+	for _, ref := range rmtrefs {
+		exists[ref.Name] = true
+	}
+	var stale []Refname
+	for _, local := range localrefs {
+		if _, err := SymbolicRefGet(c, SymbolicRefOptions{}, SymbolicRef(local.Name)); err == nil {
+			// Symbolic refs such as refs/remotes/origin/HEAD are
+			// never stale.
+			continue
+		}
+		for _, spec := range specs {
+			if strings.HasPrefix(string(spec), "^") || spec.Dst() == "" {
+				continue
+			}
+			m, ok := matchRefGlob(string(spec.Dst()), local.Name)
+			if !ok {
+				continue
+			}
+			if !exists[strings.Replace(string(spec.Src()), "*", m, 1)] {
+				stale = append(stale, Refname(local.Name))
+			}
+			break
+		}
+	}
+	return stale, nil
+}
+
+// Updates the local refs that were fetched. It returns the status of each
+// ref, and whether any were rejected.
+func updateFetchRefs(c *Client, opts FetchOptions, rmt Remote, mapped []fetchRef) ([]fetchStatus, bool, error) {
+	var status []fetchStatus
+	rejected := false
+	for _, ref := range mapped {
+		from := shortFetchRefName(ref.Name)
+		if ref.Local == "" {
+			kind := "branch"
+			if strings.HasPrefix(ref.Name, "refs/tags/") {
+				kind = "tag"
+			} else if strings.HasPrefix(ref.Name, "refs/remotes/") {
+				kind = "remote-tracking branch"
+			}
+			status = append(status, fetchStatus{'*', kind, from, "FETCH_HEAD", ""})
+			continue
+		}
+		to := shortFetchRefName(string(ref.Local))
+		istag := strings.HasPrefix(string(ref.Local), "refs/tags/")
+		force := opts.Force || ref.Force
+
+		var st fetchStatus
+		var reason string
+		old, err := RefSpec(ref.Local).Sha1(c)
+		switch {
+		case err != nil && !os.IsNotExist(err):
+			return nil, false, err
+		case err != nil:
+			reason = "storing head"
+			st = fetchStatus{'*', "[new branch]", from, to, ""}
+			if istag {
+				reason = "storing tag"
+				st.Summary = "[new tag]"
+			} else if !strings.HasPrefix(ref.Name, "refs/heads/") {
+				reason = "storing ref"
+				st.Summary = "[new ref]"
+			}
+		case old == ref.Value:
+			if opts.Verbose {
+				status = append(status, fetchStatus{'=', "[up to date]", from, to, ""})
+			}
+			continue
+		case istag && !force:
+			status = append(status, fetchStatus{'!', "[rejected]", from, to, "(would clobber existing tag)"})
+			rejected = true
+			continue
+		case istag:
+			reason = "updating tag"
+			st = fetchStatus{'t', "[tag update]", from, to, ""}
+		case CommitID(old).IsAncestor(c, CommitID(ref.Value)):
+			reason = "fast-forward"
+			st = fetchStatus{' ', old.String()[:7] + ".." + ref.Value.String()[:7], from, to, ""}
+		case force:
+			reason = "forced-update"
+			st = fetchStatus{'+', old.String()[:7] + "..." + ref.Value.String()[:7], from, to, "(forced update)"}
+		default:
+			status = append(status, fetchStatus{'!', "[rejected]", from, to, "(non-fast-forward)"})
+			rejected = true
+			continue
+		}
+		if !opts.DryRun {
+			err := UpdateRef(
+				c,
+				UpdateRefOptions{NoDeref: true},
+				string(ref.Local),
+				CommitID(ref.Value),
+				fmt.Sprintf("fetch %v: %v", rmt, reason),
+			)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		status = append(status, st)
+	}
+	return status, rejected, nil
+}
+
+// Writes the refs that were fetched to .git/FETCH_HEAD, with the refs to
+// be merged first.
+func writeFetchHead(c *Client, appendfile bool, url string, mapped []fetchRef) error {
+	url = fetchDisplayURL(url)
+
+	var merge, notformerge string
+	for _, ref := range mapped {
+		if ref.NoFetchHead {
+			continue
+		}
+		var desc string
+		switch {
+		case ref.Name == "HEAD":
+			desc = url
+		case strings.HasPrefix(ref.Name, "refs/heads/"):
+			desc = fmt.Sprintf("branch '%s' of %s", strings.TrimPrefix(ref.Name, "refs/heads/"), url)
+		case strings.HasPrefix(ref.Name, "refs/tags/"):
+			desc = fmt.Sprintf("tag '%s' of %s", strings.TrimPrefix(ref.Name, "refs/tags/"), url)
+		case strings.HasPrefix(ref.Name, "refs/remotes/"):
+			desc = fmt.Sprintf("remote-tracking branch '%s' of %s", strings.TrimPrefix(ref.Name, "refs/remotes/"), url)
+		default:
+			desc = fmt.Sprintf("'%s' of %s", ref.Name, url)
+		}
+		if ref.Merge {
+			merge += fmt.Sprintf("%v\t\t%s\n", ref.Value, desc)
+		} else {
+			notformerge += fmt.Sprintf("%v\tnot-for-merge\t%s\n", ref.Value, desc)
+		}
+	}
+	fetchhead := c.GitDir.File("FETCH_HEAD")
+	if appendfile {
+		return fetchhead.Append(merge + notformerge)
+	}
+	return ioutil.WriteFile(fetchhead.String(), []byte(merge+notformerge), 0644)
+}
+
+// Returns the remote's URL as it's shown in FETCH_HEAD and the summary of
+// ref updates. Like git, this is the URL as configured, without any user
+// or password, trailing slashes or ".git" suffix.
+func fetchDisplayURL(url string) string {
+	if scheme := strings.Index(url, "://"); scheme >= 0 {
+		host := url[scheme+3:]
+		if slash := strings.IndexByte(host, '/'); slash >= 0 {
+			host = host[:slash]
+		}
+		if at := strings.LastIndexByte(host, '@'); at >= 0 {
+			url = url[:scheme+3] + url[scheme+3+at+1:]
+		}
+	} else if isSCPLikeURL(url) {
+		colon := scpColon(url)
+		if at := strings.LastIndexByte(url[:colon], '@'); at >= 0 {
+			url = url[at+1:]
+		}
+	}
+	url = strings.TrimRight(url, "/")
+	return strings.TrimSuffix(url, ".git")
+}
+
+// Prints the summary of ref updates in the same format as git.
+func printFetchStatus(url string, status []fetchStatus) {
+	if len(status) == 0 {
+		return
+	}
+	width := 10
+	for _, st := range status {
+		if len(st.From) > width {
+			width = len(st.From)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "From %s\n", fetchDisplayURL(url))
+	for _, st := range status {
+		line := fmt.Sprintf(" %c %-17s %-*s -> %s", st.Flag, st.Summary, width, st.From, st.To)
+		if st.Reason != "" {
+			line += "  " + st.Reason
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
 }
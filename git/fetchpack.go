@@ -14,7 +14,7 @@ type Refname string
 
 // returns true if the reference name exists under the client's GitDir.
 func (rn Refname) Exists(c *Client) bool {
-	return c.GitDir.File(File(rn)).Exists()
+	return refExists(c, string(rn))
 }
 
 func (rn Refname) String() string {
@@ -108,6 +108,13 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 		return nil, nil
 	}
 
+	if h, ok := conn.(*smartHTTPConn); ok && h.dumb {
+		return fetchDumbHTTP(c, opts, h, wants)
+	}
+	if b, ok := conn.(*bundleConn); ok {
+		return fetchBundle(c, opts, b, wants)
+	}
+
 	// FIXME: This should be configurable
 	conn.SetSideband(os.Stderr)
 
@@ -164,6 +171,9 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 		if opts.NoProgress {
 			fmt.Fprintf(conn, "no-progress\n")
 		}
+		if opts.IncludeTag {
+			fmt.Fprintf(conn, "include-tag\n")
+		}
 		wanted := false
 		for object, _ := range objects {
 			have, _, err := c.HaveObject(object)
@@ -254,6 +264,11 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 						caps += " no-progress"
 					}
 				}
+				if opts.IncludeTag {
+					if _, ok := capabilities["include-tag"]; ok {
+						caps += " include-tag"
+					}
+				}
 				if _, ok := capabilities["side-band-64k"]; ok {
 					caps += " side-band-64k"
 					sideband = true
@@ -29,13 +29,16 @@ func (g *gitConn) OpenConn(srv GitService) error {
 	g.conn = conn
 	g.packProtocolReader = &packProtocolReader{g.conn, PktLineMode, nil, nil}
 
-	// Advertise the connection and try to negotiate protocol version 2
-	fmt.Fprintf(
-		g,
+	// Advertise the connection and try to negotiate protocol version 2.
+	// The request is always a pkt-line, regardless of the write mode.
+	if err := (pktLineWriter{conn}).Printf(
 		g.service+" %s\x00host=%s\x00\x00version=2\x00",
 		g.uri.Path,
 		host,
-	)
+	); err != nil {
+		conn.Close()
+		return err
+	}
 
 	v, cap, refs, err := parseRemoteInitialConnection(conn, false)
 	if err != nil {
@@ -0,0 +1,162 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The result of verifying the signature of a commit, as shown by the %G
+// placeholders of a format string.
+type signatureCheck struct {
+	// 'G' for a good signature, 'B' for a bad one, 'U' for a good
+	// signature with unknown validity, 'X' or 'Y' for a good
+	// signature which has expired or was made by an expired key, 'R'
+	// for a revoked key, 'E' if it couldn't be checked and 'N' if
+	// there's no signature.
+	Result byte
+
+	// The output of gpg when verifying the signature.
+	Output string
+
+	Signer, Key, Fingerprint, PrimaryFingerprint string
+
+	// The trust level of the key, such as "undefined" or "ultimate".
+	Trust string
+}
+
+// The status lines printed by gpg which determine the signature result.
+var gpgSignatureStatuses = []struct {
+	result byte
+	status string
+}{
+	{'G', "GOODSIG "},
+	{'B', "BADSIG "},
+	{'E', "ERRSIG "},
+	{'X', "EXPSIG "},
+	{'Y', "EXPKEYSIG "},
+	{'R', "REVKEYSIG "},
+}
+
+// Splits the signature from a signed commit object, returning the commit
+// without the signature header (which is what was signed) and the
+// signature. The signature is nil if the commit isn't signed.
+func splitCommitSignature(content []byte) (payload, signature []byte) {
+	var p bytes.Buffer
+	inSig, inHeaders := false, true
+	for _, line := range bytes.SplitAfter(content, []byte{'\n'}) {
+		switch {
+		case !inHeaders:
+		case len(line) == 1 && line[0] == '\n', len(line) == 0:
+			inHeaders, inSig = false, false
+		case inSig && line[0] == ' ':
+			signature = append(signature, line[1:]...)
+			continue
+		case bytes.HasPrefix(line, []byte("gpgsig ")):
+			inSig = true
+			signature = append(signature, line[len("gpgsig "):]...)
+			continue
+		default:
+			inSig = false
+		}
+		p.Write(line)
+	}
+	return p.Bytes(), signature
+}
+
+// Verifies the signature of a commit with gpg.
+func verifyCommitSignature(c *Client, id CommitID) (signatureCheck, error) {
+	obj, err := c.GetCommitObject(id)
+	if err != nil {
+		return signatureCheck{}, err
+	}
+	payload, signature := splitCommitSignature(obj.GetContent())
+	if signature == nil {
+		return signatureCheck{Result: 'N', Trust: "undefined"}, nil
+	}
+	return verifySignature(c, payload, signature)
+}
+
+// Verifies that signature is a valid signature of payload by running gpg
+// and interpreting its status output.
+func verifySignature(c *Client, payload, signature []byte) (signatureCheck, error) {
+	check := signatureCheck{Result: 'N'}
+	sigfile, err := ioutil.TempFile("", ".git_vtag")
+	if err != nil {
+		return check, err
+	}
+	defer os.Remove(sigfile.Name())
+	if _, err := sigfile.Write(signature); err != nil {
+		sigfile.Close()
+		return check, err
+	}
+	if err := sigfile.Close(); err != nil {
+		return check, err
+	}
+
+	program := c.GetConfig("gpg.openpgp.program")
+	if program == "" {
+		program = c.GetConfig("gpg.program")
+	}
+	if program == "" {
+		program = "gpg"
+	}
+	var status, output bytes.Buffer
+	cmd := exec.Command(program, "--keyid-format=long", "--status-fd=1", "--verify", sigfile.Name(), "-")
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &status
+	cmd.Stderr = &output
+	// A bad signature makes gpg exit with an error, which is reported
+	// by the result instead.
+	cmd.Run()
+	check.Output = output.String()
+
+	seen := false
+	for _, line := range strings.Split(status.String(), "\n") {
+		line = strings.TrimPrefix(line, "[GNUPG:] ")
+		for _, s := range gpgSignatureStatuses {
+			if !strings.HasPrefix(line, s.status) {
+				continue
+			}
+			if seen {
+				// More than one signature is treated as an
+				// error, since the caller can't tell which
+				// was checked.
+				check.Result = 'E'
+				check.Signer, check.Key = "", ""
+				return check, nil
+			}
+			seen = true
+			check.Result = s.result
+			fields := strings.SplitN(strings.TrimPrefix(line, s.status), " ", 2)
+			check.Key = fields[0]
+			if s.result != 'E' && len(fields) > 1 {
+				check.Signer = fields[1]
+			}
+		}
+		if strings.HasPrefix(line, "VALIDSIG ") {
+			fields := strings.Fields(line)
+			if len(fields) > 1 {
+				check.Fingerprint = fields[1]
+			}
+			if len(fields) > 10 {
+				check.PrimaryFingerprint = fields[10]
+			}
+		}
+		if strings.HasPrefix(line, "TRUST_") {
+			trust := strings.Fields(strings.TrimPrefix(line, "TRUST_"))
+			if len(trust) > 0 {
+				check.Trust = strings.ToLower(trust[0])
+			}
+		}
+	}
+	if check.Result == 'G' && (check.Trust == "undefined" || check.Trust == "never") {
+		check.Result = 'U'
+	}
+	if check.Trust == "" {
+		check.Trust = "undefined"
+	}
+	return check, nil
+}
@@ -0,0 +1,708 @@
+package git
+
+import (
+	"io"
+	"strings"
+)
+
+// The colours that the lines of the history graph are drawn in, in the
+// order that they're used.
+var graphColumnColors = []string{
+	colorRed, colorGreen, colorYellow, colorBlue, colorMagenta, colorCyan,
+	colorBoldRed, colorBoldGreen, colorBoldYellow, colorBoldBlue, colorBoldMagenta, colorBoldCyan,
+}
+
+// The states that a Graph goes through while drawing the lines for a
+// commit.
+type graphState int
+
+const (
+	graphPadding graphState = iota
+	graphSkip
+	graphPreCommit
+	graphCommit
+	graphPostMerge
+	graphCollapsing
+)
+
+// A graphColumn is a line of history in the graph, leading to the commit
+// which is drawn in it.
+type graphColumn struct {
+	commit CommitID
+	// An index into graphColumnColors, or -1 for no colour.
+	color int
+}
+
+// A Graph draws the lines of history beside commits, in the same way as
+// log --graph. The commits are passed to Update in the order that they're
+// shown, and the lines for each commit are taken from the Graph before it
+// moves on to the next one.
+type Graph struct {
+	// The commit being drawn, its parents which are shown and the
+	// character that it's drawn as.
+	commit     CommitID
+	hasCommit  bool
+	parents    []CommitID
+	commitChar string
+
+	// The number of characters needed for the widest line of the
+	// current commit.
+	width int
+
+	// The number of extra rows drawn before an octopus merge so far.
+	expansionRow int
+
+	state, prevState graphState
+
+	// The column of the current and previous commits.
+	commitIndex, prevCommitIndex int
+
+	// For merges, -1 until the layout is chosen, 0 if the first parent
+	// is to the left of the merge and 1 otherwise.
+	mergeLayout int
+
+	// The number of columns added by the current and previous commits.
+	edgesAdded, prevEdgesAdded int
+
+	// The columns for the current commit, and those after it.
+	columns, newColumns []graphColumn
+
+	// Which of newColumns each character of the current row is moving
+	// towards, or -1. mapping is updated as lines are collapsed.
+	mapping, oldMapping []int
+
+	color        bool
+	defaultColor int
+}
+
+// NewGraph returns a Graph which is ready to draw the first commit. The
+// lines are coloured if color is set.
+func NewGraph(color bool) *Graph {
+	return &Graph{
+		state:     graphPadding,
+		prevState: graphPadding,
+		color:     color,
+		// The first increment makes the first colour the default.
+		defaultColor: len(graphColumnColors) - 1,
+	}
+}
+
+// Update moves the graph on to the commit id, whose parents are the ones
+// which will be shown after it. mark is the mark for the commit returned by
+// RevListEntry.Mark: it's drawn as the commit, or "*" if mark is empty or
+// the mark of CherryMark, or "o" for boundary commits.
+func (g *Graph) Update(id CommitID, parents []CommitID, mark string) {
+	g.commit, g.hasCommit, g.parents = id, true, parents
+	switch mark {
+	case "", "+":
+		g.commitChar = "*"
+	case "-":
+		g.commitChar = "o"
+	default:
+		g.commitChar = mark
+	}
+	g.prevCommitIndex = g.commitIndex
+	g.updateColumns()
+	g.expansionRow = 0
+
+	// If the previous commit wasn't finished, the rest of it is never
+	// drawn, so a "..." is drawn instead.
+	switch {
+	case g.state != graphPadding:
+		g.state = graphSkip
+	case g.needsPreCommitLine():
+		g.state = graphPreCommit
+	default:
+		g.state = graphCommit
+	}
+}
+
+func (g *Graph) updateState(s graphState) {
+	g.prevState = g.state
+	g.state = s
+}
+
+// Returns the current colour, or -1 if the graph isn't coloured.
+func (g *Graph) currentColor() int {
+	if !g.color {
+		return -1
+	}
+	return g.defaultColor
+}
+
+func (g *Graph) incrementColor() {
+	g.defaultColor = (g.defaultColor + 1) % len(graphColumnColors)
+}
+
+// Returns the colour of the column that commit is already in, or the
+// current colour if it isn't in one.
+func (g *Graph) findCommitColor(commit CommitID) int {
+	for _, col := range g.columns {
+		if col.commit == commit {
+			return col.color
+		}
+	}
+	return g.currentColor()
+}
+
+func (g *Graph) findNewColumn(commit CommitID) int {
+	for i, col := range g.newColumns {
+		if col.commit == commit {
+			return i
+		}
+	}
+	return -1
+}
+
+// Adds commit to the columns for the next commit, unless it's already
+// there, and maps the character at the end of the current row to it. idx
+// is the column of the current commit if commit is one of its parents, or
+// -1.
+func (g *Graph) insertIntoNewColumns(commit CommitID, idx int) {
+	i := g.findNewColumn(commit)
+	if i < 0 {
+		i = len(g.newColumns)
+		g.newColumns = append(g.newColumns, graphColumn{commit, g.findCommitColor(commit)})
+	}
+
+	var mappingIdx int
+	switch {
+	case len(g.parents) > 1 && idx > -1 && g.mergeLayout == -1:
+		// The layout of a merge depends on whether its first parent
+		// is in a column to the left of it.
+		dist := idx - i
+		shift := 1
+		if dist > 1 {
+			shift = 2*dist - 3
+		}
+		g.mergeLayout = 1
+		if dist > 0 {
+			g.mergeLayout = 0
+		}
+		g.edgesAdded = len(g.parents) + g.mergeLayout - 2
+		mappingIdx = g.width + (g.mergeLayout-1)*shift
+		g.width += 2 * g.mergeLayout
+	case g.edgesAdded > 0 && i == g.mapping[g.width-2]:
+		// A column was added by a merge, but this commit is in the
+		// last existing column, so the two edges join immediately.
+		mappingIdx = g.width - 2
+		g.edgesAdded = -1
+	default:
+		mappingIdx = g.width
+		g.width += 2
+	}
+	g.mapping[mappingIdx] = i
+}
+
+// Works out the columns after the current commit and how the columns
+// before it map onto them.
+func (g *Graph) updateColumns() {
+	g.columns, g.newColumns = g.newColumns, g.columns[:0]
+
+	maxNewColumns := len(g.columns) + len(g.parents)
+	g.mapping = make([]int, 2*maxNewColumns)
+	for i := range g.mapping {
+		g.mapping[i] = -1
+	}
+	g.width = 0
+	g.prevEdgesAdded = g.edgesAdded
+	g.edgesAdded = 0
+
+	// The current commit may not be in any of the columns if none of
+	// its children have been shown, in which case it gets a new column
+	// at the end.
+	seenThis := false
+	inColumns := true
+	for i := 0; i <= len(g.columns); i++ {
+		var commit CommitID
+		if i == len(g.columns) {
+			if seenThis {
+				break
+			}
+			inColumns = false
+			commit = g.commit
+		} else {
+			commit = g.columns[i].commit
+		}
+
+		if commit != g.commit {
+			g.insertIntoNewColumns(commit, -1)
+			continue
+		}
+		seenThis = true
+		g.commitIndex = i
+		g.mergeLayout = -1
+		for _, parent := range g.parents {
+			// Merges and new lines of history get new colours.
+			if len(g.parents) > 1 || !inColumns {
+				g.incrementColor()
+			}
+			g.insertIntoNewColumns(parent, i)
+		}
+		// The commit takes up a column even without any parents.
+		if len(g.parents) == 0 {
+			g.width += 2
+		}
+	}
+
+	for len(g.mapping) > 1 && g.mapping[len(g.mapping)-1] < 0 {
+		g.mapping = g.mapping[:len(g.mapping)-1]
+	}
+}
+
+// The number of parents of an octopus merge which are joined to it with
+// dashes.
+func (g *Graph) numDashedParents() int {
+	return len(g.parents) + g.mergeLayout - 3
+}
+
+// The number of rows needed before an octopus merge to make room for the
+// lines to its parents.
+func (g *Graph) numExpansionRows() int {
+	return g.numDashedParents() * 2
+}
+
+func (g *Graph) needsPreCommitLine() bool {
+	return len(g.parents) >= 3 && g.commitIndex < len(g.columns)-1 && g.expansionRow < g.numExpansionRows()
+}
+
+// Returns true if every line is in the column that it's moving towards, or
+// one character to the right of it, where it will be drawn as "/".
+func (g *Graph) mappingCorrect() bool {
+	for i, target := range g.mapping {
+		if target >= 0 && target != i/2 {
+			return false
+		}
+	}
+	return true
+}
+
+// A graphLine is a line of the graph being drawn, and its width without
+// any colour codes.
+type graphLine struct {
+	strings.Builder
+	width int
+}
+
+func (l *graphLine) addChars(c byte, n int) {
+	l.WriteString(strings.Repeat(string(c), n))
+	l.width += n
+}
+
+func (l *graphLine) addString(s string) {
+	l.WriteString(s)
+	l.width += len(s)
+}
+
+// Draws c in the colour of col.
+func (l *graphLine) writeColumn(col graphColumn, c byte) {
+	if col.color >= 0 {
+		l.WriteString(graphColumnColors[col.color])
+	}
+	l.addChars(c, 1)
+	if col.color >= 0 {
+		l.WriteString(colorReset)
+	}
+}
+
+// Pads l so that all the lines of a commit have the same width, and the
+// text beside them is aligned.
+func (g *Graph) padHorizontally(l *graphLine) {
+	if l.width < g.width {
+		l.addChars(' ', g.width-l.width)
+	}
+}
+
+func (g *Graph) outputPaddingLine(l *graphLine) {
+	for _, col := range g.newColumns {
+		l.writeColumn(col, '|')
+		l.addChars(' ', 1)
+	}
+}
+
+func (g *Graph) outputSkipLine(l *graphLine) {
+	l.addString("...")
+	if g.needsPreCommitLine() {
+		g.updateState(graphPreCommit)
+	} else {
+		g.updateState(graphCommit)
+	}
+}
+
+// Draws a row which widens the space around an octopus merge to make room
+// for the lines to its parents.
+func (g *Graph) outputPreCommitLine(l *graphLine) {
+	seenThis := false
+	for i, col := range g.columns {
+		switch {
+		case col.commit == g.commit:
+			seenThis = true
+			l.writeColumn(col, '|')
+			l.addChars(' ', g.expansionRow)
+		case seenThis && g.expansionRow == 0:
+			// If the previous commit was a merge, the lines after
+			// it were drawn as "\", so they continue as it.
+			if g.prevState == graphPostMerge && g.prevCommitIndex < i {
+				l.writeColumn(col, '\\')
+			} else {
+				l.writeColumn(col, '|')
+			}
+		case seenThis:
+			l.writeColumn(col, '\\')
+		default:
+			l.writeColumn(col, '|')
+		}
+		l.addChars(' ', 1)
+	}
+	g.expansionRow++
+	if !g.needsPreCommitLine() {
+		g.updateState(graphCommit)
+	}
+}
+
+// Draws the dashes which join an octopus merge to its parents after the
+// first two.
+func (g *Graph) drawOctopusMerge(l *graphLine) {
+	dashed := g.numDashedParents()
+	for i := 0; i < dashed; i++ {
+		col := g.newColumns[g.mapping[(g.commitIndex+i+2)*2]]
+		l.writeColumn(col, '-')
+		if i == dashed-1 {
+			l.writeColumn(col, '.')
+		} else {
+			l.writeColumn(col, '-')
+		}
+	}
+}
+
+func (g *Graph) outputCommitLine(l *graphLine) {
+	seenThis := false
+	for i := 0; i <= len(g.columns); i++ {
+		var col graphColumn
+		if i == len(g.columns) {
+			if seenThis {
+				break
+			}
+			col.commit = g.commit
+		} else {
+			col = g.columns[i]
+		}
+
+		switch {
+		case col.commit == g.commit:
+			seenThis = true
+			l.addString(g.commitChar)
+			if len(g.parents) > 2 {
+				g.drawOctopusMerge(l)
+			}
+		case seenThis && g.edgesAdded > 1:
+			l.writeColumn(col, '\\')
+		case seenThis && g.edgesAdded == 1:
+			// This is the first line of a merge which didn't need
+			// any rows before it. If the previous commit was a
+			// merge, the line into this one may have been a "\",
+			// so it continues as one.
+			if g.prevState == graphPostMerge && g.prevEdgesAdded > 0 && g.prevCommitIndex < i {
+				l.writeColumn(col, '\\')
+			} else {
+				l.writeColumn(col, '|')
+			}
+		case g.prevState == graphCollapsing && 2*i+1 < len(g.oldMapping) && g.oldMapping[2*i+1] == i && 2*i < len(g.mapping) && g.mapping[2*i] < i:
+			l.writeColumn(col, '/')
+		default:
+			l.writeColumn(col, '|')
+		}
+		l.addChars(' ', 1)
+	}
+
+	switch {
+	case len(g.parents) > 1:
+		g.updateState(graphPostMerge)
+	case g.mappingCorrect():
+		g.updateState(graphPadding)
+	default:
+		g.updateState(graphCollapsing)
+	}
+}
+
+// Draws the row after a merge, which branches out to its parents.
+func (g *Graph) outputPostMergeLine(l *graphLine) {
+	mergeChars := []byte{'/', '|', '\\'}
+	seenThis := false
+	var parentCol *graphColumn
+	for i := 0; i <= len(g.columns); i++ {
+		var col graphColumn
+		if i == len(g.columns) {
+			if seenThis {
+				break
+			}
+			col.commit = g.commit
+		} else {
+			col = g.columns[i]
+		}
+
+		switch {
+		case col.commit == g.commit:
+			seenThis = true
+			idx := g.mergeLayout
+			for j, parent := range g.parents {
+				l.writeColumn(g.newColumns[g.findNewColumn(parent)], mergeChars[idx])
+				if idx == 2 {
+					if g.edgesAdded > 0 || j < len(g.parents)-1 {
+						l.addChars(' ', 1)
+					}
+				} else {
+					idx++
+				}
+			}
+			if g.edgesAdded == 0 {
+				l.addChars(' ', 1)
+			}
+		case seenThis:
+			if g.edgesAdded > 0 {
+				l.writeColumn(col, '\\')
+			} else {
+				l.writeColumn(col, '|')
+			}
+			l.addChars(' ', 1)
+		default:
+			l.writeColumn(col, '|')
+			if g.mergeLayout != 0 || i != g.commitIndex-1 {
+				if parentCol != nil {
+					l.writeColumn(*parentCol, '_')
+				} else {
+					l.addChars(' ', 1)
+				}
+			}
+		}
+
+		if col.commit == g.parents[0] {
+			c := col
+			parentCol = &c
+		}
+	}
+
+	if g.mappingCorrect() {
+		g.updateState(graphPadding)
+	} else {
+		g.updateState(graphCollapsing)
+	}
+}
+
+// Draws a row which moves the lines of history towards their columns,
+// crossing over at most one other line.
+func (g *Graph) outputCollapsingLine(l *graphLine) {
+	g.mapping, g.oldMapping = g.oldMapping, g.mapping
+	if len(g.mapping) < len(g.oldMapping) {
+		g.mapping = make([]int, len(g.oldMapping))
+	}
+	g.mapping = g.mapping[:len(g.oldMapping)]
+	for i := range g.mapping {
+		g.mapping[i] = -1
+	}
+
+	usedHorizontal := false
+	horizontalEdge, horizontalEdgeTarget := -1, -1
+	for i, target := range g.oldMapping {
+		if target < 0 {
+			continue
+		}
+		// Lines only ever move to the left, so whenever they cross,
+		// only one of them is moving.
+		switch {
+		case target*2 == i:
+			g.mapping[i] = target
+		case g.mapping[i-1] < 0:
+			// Nothing is to the left, so move left by one.
+			g.mapping[i-1] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		case g.mapping[i-1] == target:
+			// The line to the left goes to the same commit, so
+			// they're combined.
+		default:
+			// Cross over the line to the left, which isn't going to
+			// the same commit.
+			g.mapping[i-2] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i-1, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		}
+	}
+
+	g.oldMapping = append(g.oldMapping[:0], g.mapping...)
+	if g.mapping[len(g.mapping)-1] < 0 {
+		g.mapping = g.mapping[:len(g.mapping)-1]
+	}
+
+	for i, target := range g.mapping {
+		switch {
+		case target < 0:
+			l.addChars(' ', 1)
+		case target*2 == i:
+			l.writeColumn(g.newColumns[target], '|')
+		case target == horizontalEdgeTarget && i != horizontalEdge-1:
+			// Only the first segment of the horizontal edge
+			// continues into the next row.
+			if i != target*2+3 {
+				g.mapping[i] = -1
+			}
+			usedHorizontal = true
+			l.writeColumn(g.newColumns[target], '_')
+		default:
+			if usedHorizontal && i < horizontalEdge {
+				g.mapping[i] = -1
+			}
+			l.writeColumn(g.newColumns[target], '/')
+		}
+	}
+
+	if g.mappingCorrect() {
+		g.updateState(graphPadding)
+	}
+}
+
+// NextLine returns the next line of the graph for the current commit,
+// without a newline, and whether it's the line with the commit on it.
+func (g *Graph) NextLine() (string, bool) {
+	if !g.hasCommit {
+		return "", false
+	}
+	var l graphLine
+	commitLine := false
+	switch g.state {
+	case graphPadding:
+		g.outputPaddingLine(&l)
+	case graphSkip:
+		g.outputSkipLine(&l)
+	case graphPreCommit:
+		g.outputPreCommitLine(&l)
+	case graphCommit:
+		g.outputCommitLine(&l)
+		commitLine = true
+	case graphPostMerge:
+		g.outputPostMergeLine(&l)
+	case graphCollapsing:
+		g.outputCollapsingLine(&l)
+	}
+	g.padHorizontally(&l)
+	return l.String(), commitLine
+}
+
+// PaddingLine returns a line of the graph which leaves all the lines of
+// history unchanged, to put beside extra text. Before the line with the
+// commit has been taken, it's a line which keeps the commit's column.
+func (g *Graph) PaddingLine() string {
+	if !g.hasCommit {
+		return ""
+	}
+	if g.state != graphCommit {
+		line, _ := g.NextLine()
+		return line
+	}
+	var l graphLine
+	for _, col := range g.columns {
+		l.writeColumn(col, '|')
+		if col.commit == g.commit && len(g.parents) > 2 {
+			l.addChars(' ', (len(g.parents)-2)*2)
+		} else {
+			l.addChars(' ', 1)
+		}
+	}
+	g.padHorizontally(&l)
+	g.prevState = graphPadding
+	return l.String()
+}
+
+// Finished returns true if all the lines for the current commit have been
+// taken.
+func (g *Graph) Finished() bool {
+	return g.state == graphPadding
+}
+
+// WriteCommit writes the lines of the graph up to and including the line
+// with the current commit on it, without a newline after it. If that line
+// has already been taken, a padding line is written instead.
+func (g *Graph) WriteCommit(w io.Writer) error {
+	if g.Finished() {
+		_, err := io.WriteString(w, g.PaddingLine())
+		return err
+	}
+	for !g.Finished() {
+		line, commitLine := g.NextLine()
+		if !commitLine {
+			line += "\n"
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+		if commitLine {
+			break
+		}
+	}
+	return nil
+}
+
+// WriteRemainder writes the rest of the lines for the current commit,
+// separated by newlines, and returns true if there were any.
+func (g *Graph) WriteRemainder(w io.Writer) (bool, error) {
+	shown := false
+	for !g.Finished() {
+		if shown {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return shown, err
+			}
+		}
+		line, _ := g.NextLine()
+		if _, err := io.WriteString(w, line); err != nil {
+			return shown, err
+		}
+		shown = true
+	}
+	return shown, nil
+}
+
+// WriteText writes text beside the graph, which should follow the line
+// written by WriteCommit. Each line of text after the first is preceded
+// by the next line of the graph, and any lines left for the current
+// commit are written after the text.
+func (g *Graph) WriteText(w io.Writer, text string) error {
+	terminated := strings.HasSuffix(text, "\n")
+	for rest := text; rest != ""; {
+		line := rest
+		if nl := strings.IndexByte(rest, '\n'); nl >= 0 {
+			line = rest[:nl+1]
+		}
+		rest = rest[len(line):]
+		if rest != "" {
+			next, _ := g.NextLine()
+			line += next
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	if g.Finished() {
+		return nil
+	}
+	if !terminated {
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	if _, err := g.WriteRemainder(w); err != nil {
+		return err
+	}
+	if terminated {
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGraph(t *testing.T) {
+	id := func(name string) CommitID {
+		var c CommitID
+		copy(c[:], name)
+		return c
+	}
+	// An octopus merge M of A, B and C, which are all children of R,
+	// merged with D, another child of B.
+	commits := []struct {
+		Name    string
+		Parents []string
+	}{
+		{"N", []string{"M", "D"}},
+		{"D", []string{"B"}},
+		{"M", []string{"A", "B", "C"}},
+		{"C", []string{"R"}},
+		{"B", []string{"R"}},
+		{"A", []string{"R"}},
+		{"R", nil},
+	}
+	// The lines are padded with spaces to the width of the graph.
+	want := strings.Join([]string{
+		"*   N",
+		"|\\  ",
+		"| * D",
+		"| |     ",
+		"|  \\    ",
+		"*-. \\   M",
+		"|\\ \\ \\  ",
+		"| | |/  ",
+		"| |/|   ",
+		"| | * C",
+		"| * | B",
+		"| |/  ",
+		"* / A",
+		"|/  ",
+		"* R",
+	}, "\n") + "\n"
+
+	g := NewGraph(false)
+	var sb strings.Builder
+	for _, cm := range commits {
+		var parents []CommitID
+		for _, p := range cm.Parents {
+			parents = append(parents, id(p))
+		}
+		g.Update(id(cm.Name), parents, "")
+		if err := g.WriteCommit(&sb); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.WriteText(&sb, cm.Name); err != nil {
+			t.Fatal(err)
+		}
+		sb.WriteString("\n")
+	}
+	if got := sb.String(); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
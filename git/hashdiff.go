@@ -1,12 +1,15 @@
 package git
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"regexp"
 	"strconv"
+	"strings"
 )
 
 // A HashDiff represents a single line in a git diff-index type output.
@@ -14,80 +17,156 @@ type HashDiff struct {
 	Name             IndexPath
 	Src, Dst         TreeEntry
 	SrcSize, DstSize uint
+
+	// The path that Src was at, if the file was renamed or copied to
+	// Name.
+	OldName IndexPath
+
+	// The similarity of renamed and copied files, or the dissimilarity
+	// of rewritten files, on the scale of ParseSimilarityScore.
+	Score int
+
+	// Whether the file was copied from OldName, rather than renamed.
+	copied bool
 }
 
 func (h HashDiff) String() string {
-	var status string = "?"
+	return fmt.Sprintf(":%0.6o %0.6o %v %v %v	%v", h.Src.FileMode, h.Dst.FileMode, h.Src.Sha1, h.Dst.Sha1, h.scoredStatus(), h.names("\t"))
+}
 
+// Returns the status letter of the diff, as shown by --name-status.
+func (h HashDiff) Status() string {
 	empty := Sha1{}
+	if h.OldName != "" {
+		if h.copied {
+			return "C"
+		}
+		return "R"
+	}
 	if h.Src.Sha1 == empty && h.Dst.Sha1 != empty {
-		status = "A"
-	} else if h.Src.Sha1 != empty && h.Dst.Sha1 == empty {
-		if h.Dst.FileMode == 0 {
-			status = "D"
-		} else {
-			status = "M"
+		return "A"
+	} else if h.Src.Sha1 != empty && h.Dst.Sha1 == empty && h.Dst.FileMode == 0 {
+		return "D"
+	}
+	return "M"
+}
+
+// Returns the status letter followed by the score, if there is one, as
+// shown by raw diffs and --name-status.
+func (h HashDiff) scoredStatus() string {
+	if h.Score == 0 {
+		return h.Status()
+	}
+	return fmt.Sprintf("%v%03d", h.Status(), similarityPercent(h.Score))
+}
+
+// Returns the name of the file, preceded by its old name and sep if it
+// was renamed or copied.
+func (h HashDiff) names(sep string) string {
+	if h.OldName == "" {
+		return h.Name.String()
+	}
+	return h.OldName.String() + sep + h.Name.String()
+}
+
+// Returns the path that the source of the diff was at.
+func (h HashDiff) srcName() IndexPath {
+	if h.OldName != "" {
+		return h.OldName
+	}
+	return h.Name
+}
+
+// Returns the contents of e, which is one side of h, and its hash. Files
+// which aren't in the object database are read from the work tree.
+func (h HashDiff) contents(c *Client, e TreeEntry) (Sha1, []byte, error) {
+	if e.Sha1 != (Sha1{}) {
+		obj, err := c.GetObject(e.Sha1)
+		if err != nil {
+			return Sha1{}, nil, err
 		}
-	} else {
-		status = "M"
+		return e.Sha1, obj.GetContent(), nil
+	}
+	if e.FileMode == 0 {
+		return Sha1{}, nil, nil
 	}
-	return fmt.Sprintf(":%0.6o %0.6o %v %v %v	%v", h.Src.FileMode, h.Dst.FileMode, h.Src.Sha1, h.Dst.Sha1, status, h.Name)
+	f, err := h.Name.FilePath(c)
+	if err != nil {
+		return Sha1{}, nil, err
+	}
+	return HashFile("blob", f.String())
 }
 
 // Returns a diff in the format of the command "diff". Note: this invokes
 // an external diff tool. It should be rewritten in Go to avoid the overhead
 // (and the possibility that diff isn't installed.)
 func (h HashDiff) ExternalDiff(c *Client, s1, s2 TreeEntry, f File, opts DiffCommonOptions) (string, error) {
-	tmpfile1, err := ioutil.TempFile("", "gitdiff")
-	if err != nil {
-		return "", err
-	}
-	defer os.Remove(tmpfile1.Name())
-
 	var emptySha Sha1
+	var content1, content2 []byte
 	if s1.Sha1 != emptySha {
 		obj, err := c.GetObject(s1.Sha1)
 		if err != nil {
 			return "", err
 		}
-		tmpfile1.Write(obj.GetContent())
+		content1 = obj.GetContent()
 	}
-
-	tmpfile1.Close()
-
-	tmpfile2, err := ioutil.TempFile("", "gitdiff")
-	if err != nil {
-		return "", err
-	}
-	defer os.Remove(tmpfile2.Name())
-
-	var file2 = tmpfile2.Name()
 	if s2.Sha1 != emptySha {
 		obj, err := c.GetObject(s2.Sha1)
 		if err != nil {
 			return "", err
 		}
-		tmpfile2.Write(obj.GetContent())
+		content2 = obj.GetContent()
 	} else if s2.FileMode != 0 {
-		file2 = f.String()
+		_, content, err := HashFile("blob", f.String())
+		if err != nil {
+			return "", err
+		}
+		content2 = content
 	}
-	tmpfile2.Close()
 
 	indexPath, err := f.IndexPath(c)
 	if err != nil {
 		// If it couldn't be converted, fall back on the file name.
 		indexPath = IndexPath(f)
 	}
-	diffcmd := exec.Command(posixDiff, "-u", "-U", strconv.Itoa(opts.NumContextLines), "-L", ("a/" + indexPath).String(), "-L", ("b/" + indexPath).String(), tmpfile1.Name(), file2)
-	// diff returns an error code if there's any differences, so just throw
-	// away the error.
+	label1, label2 := "a/"+indexPath.String(), "b/"+indexPath.String()
+	if s1.Sha1 == emptySha && s1.FileMode == 0 {
+		label1 = "/dev/null"
+	}
+	if s2.Sha1 == emptySha && s2.FileMode == 0 {
+		label2 = "/dev/null"
+	}
+	return externalDiff(content1, content2, label1, label2, opts.NumContextLines)
+}
+
+// Runs the external diff tool to compare a and b, which are labelled with
+// label1 and label2 in the header of the unified diff.
+func externalDiff(a, b []byte, label1, label2 string, context int) (string, error) {
+	tmpfile1, err := ioutil.TempFile("", "gitdiff")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpfile1.Name())
+	tmpfile1.Write(a)
+	tmpfile1.Close()
+
+	tmpfile2, err := ioutil.TempFile("", "gitdiff")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpfile2.Name())
+	tmpfile2.Write(b)
+	tmpfile2.Close()
+
+	// -U implies a unified diff. It's not combined with -u, because
+	// diff uses the largest number of context lines given.
+	diffcmd := exec.Command(posixDiff, "-U", strconv.Itoa(context), "-L", label1, "-L", label2, tmpfile1.Name(), tmpfile2.Name())
 	diffcmd.Stderr = os.Stderr
 
 	// diff returns > 0 if any diffs are found, but we don't want to treat
 	// it as an error.
 	out, _ := diffcmd.Output()
-	return string(out), err
-
+	return string(out), nil
 }
 
 // Implement the sort interface on *GitIndexEntry, so that
@@ -105,28 +184,369 @@ func printDiffHeader(w io.Writer, name IndexPath, full bool) {
 	}
 }
 
+// GeneratePatch writes diffs to dst in each of the formats enabled in
+// options, in the order that git uses: the raw or name formats, then the
+// diffstats and summary, and then the patch.
 func GeneratePatch(c *Client, options DiffCommonOptions, diffs []HashDiff, dst io.Writer) error {
 	if dst == nil {
 		dst = os.Stdout
 	}
-	for _, diff := range diffs {
-		if options.Raw {
-			fmt.Fprintf(dst, "%v\n", diff)
+	separator := false
+	if options.Raw || options.NameStatus || options.NameOnly {
+		for _, diff := range diffs {
+			switch {
+			case options.Raw && options.Abbrev > 0:
+				fmt.Fprintf(dst, ":%0.6o %0.6o %v %v %v\t%v\n", diff.Src.FileMode, diff.Dst.FileMode, abbreviateSha1(c, diff.Src.Sha1, options.Abbrev), abbreviateSha1(c, diff.Dst.Sha1, options.Abbrev), diff.scoredStatus(), diff.names("\t"))
+			case options.Raw:
+				fmt.Fprintf(dst, "%v\n", diff)
+			case options.NameStatus:
+				fmt.Fprintf(dst, "%v\t%v\n", diff.scoredStatus(), diff.names("\t"))
+			default:
+				fmt.Fprintf(dst, "%v\n", diff.Name)
+			}
+		}
+		separator = true
+	}
+	if options.NumStat || options.Stat || options.ShortStat {
+		stats, err := diffStats(c, diffs)
+		if err != nil {
+			return err
+		}
+		if options.NumStat {
+			writeNumStat(dst, stats)
+		}
+		if options.Stat {
+			writeStat(dst, stats, options)
+		}
+		if options.ShortStat {
+			writeShortStat(dst, stats)
+		}
+		separator = true
+	}
+	if options.Summary {
+		for _, diff := range diffs {
+			modeChange := diff.Src.FileMode != diff.Dst.FileMode
+			switch {
+			case diff.Src.FileMode == 0:
+				fmt.Fprintf(dst, " create mode %0.6o %v\n", diff.Dst.FileMode, diff.Name)
+			case diff.Dst.FileMode == 0:
+				fmt.Fprintf(dst, " delete mode %0.6o %v\n", diff.Src.FileMode, diff.Name)
+			case diff.OldName != "" || diff.Score != 0:
+				// The mode change of a rename, copy or rewrite
+				// follows it without the name.
+				switch {
+				case diff.copied:
+					fmt.Fprintf(dst, " copy %v (%d%%)\n", prettyRename(diff.OldName.String(), diff.Name.String()), similarityPercent(diff.Score))
+				case diff.OldName != "":
+					fmt.Fprintf(dst, " rename %v (%d%%)\n", prettyRename(diff.OldName.String(), diff.Name.String()), similarityPercent(diff.Score))
+				default:
+					fmt.Fprintf(dst, " rewrite %v (%d%%)\n", diff.Name, similarityPercent(diff.Score))
+				}
+				if modeChange {
+					fmt.Fprintf(dst, " mode change %0.6o => %0.6o\n", diff.Src.FileMode, diff.Dst.FileMode)
+				}
+			case modeChange:
+				fmt.Fprintf(dst, " mode change %0.6o => %0.6o %v\n", diff.Src.FileMode, diff.Dst.FileMode, diff.Name)
+			}
 		}
-		if options.Patch {
-			f, err := diff.Name.FilePath(c)
-			if err != nil {
+		separator = true
+	}
+	if options.Patch {
+		if separator {
+			fmt.Fprintln(dst)
+		}
+		for _, diff := range diffs {
+			if err := diff.writePatch(c, dst, options); err != nil {
 				return err
 			}
+		}
+	}
+	return nil
+}
+
+// Writes the patch for h to w, including git's extended header lines.
+// Nothing is written if the file didn't change.
+func (h HashDiff) writePatch(c *Client, w io.Writer, opts DiffCommonOptions) error {
+	srcSha, src, err := h.contents(c, h.Src)
+	if err != nil {
+		return err
+	}
+	dstSha, dst, err := h.contents(c, h.Dst)
+	if err != nil {
+		return err
+	}
+	if srcSha == dstSha && h.Src.FileMode == h.Dst.FileMode && h.OldName == "" {
+		return nil
+	}
+
+	meta, reset := "", ""
+	if opts.Color {
+		meta, reset = colorBold, colorReset
+	}
+	fmt.Fprintf(w, "%vdiff --git a/%v b/%v%v\n", meta, h.srcName(), h.Name, reset)
+	switch {
+	case h.Src.FileMode == 0:
+		fmt.Fprintf(w, "%vnew file mode %0.6o%v\n", meta, h.Dst.FileMode, reset)
+	case h.Dst.FileMode == 0:
+		fmt.Fprintf(w, "%vdeleted file mode %0.6o%v\n", meta, h.Src.FileMode, reset)
+	case h.Src.FileMode != h.Dst.FileMode:
+		fmt.Fprintf(w, "%vold mode %0.6o%v\n%vnew mode %0.6o%v\n", meta, h.Src.FileMode, reset, meta, h.Dst.FileMode, reset)
+	}
+	switch {
+	case h.OldName != "":
+		verb := "rename"
+		if h.copied {
+			verb = "copy"
+		}
+		fmt.Fprintf(w, "%vsimilarity index %d%%%v\n", meta, similarityPercent(h.Score), reset)
+		fmt.Fprintf(w, "%v%v from %v%v\n%v%v to %v%v\n", meta, verb, h.OldName, reset, meta, verb, h.Name, reset)
+	case h.Score != 0:
+		fmt.Fprintf(w, "%vdissimilarity index %d%%%v\n", meta, similarityPercent(h.Score), reset)
+	}
+	if srcSha == dstSha {
+		return nil
+	}
+	fmt.Fprintf(w, "%vindex %v..%v", meta, abbreviateSha1(c, srcSha, 7), abbreviateSha1(c, dstSha, 7))
+	if h.Src.FileMode == h.Dst.FileMode {
+		fmt.Fprintf(w, " %0.6o", h.Src.FileMode)
+	}
+	fmt.Fprintf(w, "%v\n", reset)
+
+	label1, label2 := "a/"+h.srcName().String(), "b/"+h.Name.String()
+	if h.Src.FileMode == 0 {
+		label1 = "/dev/null"
+	}
+	if h.Dst.FileMode == 0 {
+		label2 = "/dev/null"
+	}
+	if isBinary(src) || isBinary(dst) {
+		fmt.Fprintf(w, "Binary files %v and %v differ\n", label1, label2)
+		return nil
+	}
+	var patch string
+	if h.Score != 0 && h.OldName == "" {
+		patch = rewritePatch(src, dst, label1, label2)
+	} else if patch, err = externalDiff(src, dst, label1, label2, opts.NumContextLines); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, formatHunks(patch, src, dst, opts.Color))
+	return err
+}
+
+// Returns a patch which removes every line of src and adds every line of
+// dst, which is how git shows files that were rewritten.
+func rewritePatch(src, dst []byte, label1, label2 string) string {
+	lines := func(buf []byte) []string {
+		l := strings.SplitAfter(string(buf), "\n")
+		if l[len(l)-1] == "" {
+			l = l[:len(l)-1]
+		}
+		return l
+	}
+	lineCount := func(n int) string {
+		switch n {
+		case 0:
+			return "0,0"
+		case 1:
+			return "1"
+		}
+		return fmt.Sprintf("1,%d", n)
+	}
+	srcLines, dstLines := lines(src), lines(dst)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %v\n+++ %v\n@@ -%v +%v @@\n", label1, label2, lineCount(len(srcLines)), lineCount(len(dstLines)))
+	for _, side := range []struct {
+		prefix string
+		lines  []string
+	}{{"-", srcLines}, {"+", dstLines}} {
+		for _, line := range side.lines {
+			sb.WriteString(side.prefix + line)
+			if !strings.HasSuffix(line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return sb.String()
+}
 
-			patch, err := diff.ExternalDiff(c, diff.Src, diff.Dst, f, options)
-			if err != nil {
-				return err
+// Returns true if git would consider buf to be the contents of a binary
+// file, because it has a NUL byte near the start.
+func isBinary(buf []byte) bool {
+	if len(buf) > 8000 {
+		buf = buf[:8000]
+	}
+	return bytes.IndexByte(buf, 0) >= 0
+}
+
+// Formats the output of the external diff tool as git does, adding the
+// nearest preceding function line from the preimage src to each hunk
+// header and colouring the lines if color is set. dst is the postimage.
+func formatHunks(patch string, src, dst []byte, color bool) string {
+	srcLines := splitLines(src)
+	var pre, post int
+	blankPre, blankPost := blankAtEOF(src, dst)
+
+	var sb strings.Builder
+	for _, line := range strings.SplitAfter(patch, "\n") {
+		if line == "" {
+			continue
+		}
+		eol := ""
+		if strings.HasSuffix(line, "\n") {
+			line, eol = line[:len(line)-1], "\n"
+		}
+		switch {
+		case strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ "):
+			if color {
+				line = colorBold + line + colorReset
+			}
+		case strings.HasPrefix(line, "@@ "):
+			a, b, c, _, ok := parseHunkHeader(line)
+			if !ok {
+				break
+			}
+			pre, post = a, c
+			// The function line is searched for before the first
+			// line of the hunk, which is the line after a for
+			// empty hunks.
+			start := a - 1
+			if b == 0 {
+				start = a
+			}
+			funcname := findFuncname(srcLines, start-1)
+			switch {
+			case color && funcname != "":
+				line = colorCyan + line + colorReset + " " + colorReset + funcname + colorReset
+			case color:
+				line = colorCyan + line + colorReset
+			case funcname != "":
+				line += " " + funcname
+			}
+		case color && strings.HasPrefix(line, "-"):
+			pre++
+			line = colorRed + line + colorReset
+		case color && strings.HasPrefix(line, "+"):
+			post++
+			if blankPre > 0 && blankPost > 0 && blankPre <= pre && blankPost <= post && isBlank(line[1:]) {
+				line = colorBgRed + line + colorReset
 			} else {
-				printDiffHeader(dst, diff.Name, false)
-				fmt.Fprintf(dst, "%v\n", patch)
+				line = colorGreen + "+" + colorReset + highlightWhitespace(line[1:], colorGreen)
 			}
+		case color && strings.HasPrefix(line, " "):
+			pre++
+			post++
+			line += colorReset
+		case color && strings.HasPrefix(line, "\\"):
+			line += colorReset
 		}
+		sb.WriteString(line + eol)
 	}
-	return nil
+	return sb.String()
+}
+
+var hunkHeaderRE = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// Parses the hunk header line of a unified diff, which says that the hunk
+// replaces the b lines from line a of the preimage with the d lines from
+// line c of the postimage.
+func parseHunkHeader(line string) (a, b, c, d int, ok bool) {
+	m := hunkHeaderRE.FindStringSubmatch(line)
+	if m == nil {
+		return 0, 0, 0, 0, false
+	}
+	num := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	return num(m[1]), num(m[2]), num(m[3]), num(m[4]), true
+}
+
+// Splits buf into lines, without their line endings.
+func splitLines(buf []byte) []string {
+	s := string(buf)
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// Returns the line before index start+1 of lines, searching backwards,
+// which git's default hunk header function matching considers to be the
+// start of a function, truncated to 80 bytes.
+func findFuncname(lines []string, start int) string {
+	if start >= len(lines) {
+		start = len(lines) - 1
+	}
+	for i := start; i >= 0; i-- {
+		line := lines[i]
+		if line == "" {
+			continue
+		}
+		if ch := line[0]; ch == '_' || ch == '$' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' {
+			if len(line) > 80 {
+				line = line[:80]
+			}
+			return strings.TrimRight(line, " \t\r\n\v\f")
+		}
+	}
+	return ""
+}
+
+// Returns true if line only contains whitespace.
+func isBlank(line string) bool {
+	return strings.TrimLeft(line, " \t\r\n\v\f") == ""
+}
+
+// Returns the line numbers in the preimage and postimage from which the
+// lines added to the end of dst are blank, if dst ends in more blank lines
+// than src, or 0 and 0.
+func blankAtEOF(src, dst []byte) (int, int) {
+	trailingBlank := func(buf []byte) int {
+		lines := splitLines(buf)
+		n := 0
+		for i := len(lines) - 1; i > 0 && isBlank(lines[i]); i-- {
+			n++
+		}
+		return n
+	}
+	l1, l2 := trailingBlank(src), trailingBlank(dst)
+	if l2 <= l1 {
+		return 0, 0
+	}
+	return len(splitLines(src)) - l1 + 1, len(splitLines(dst)) - l2 + 1
+}
+
+// Colours the added line with color, as git does, and highlights any
+// whitespace errors in it: trailing whitespace, and spaces before a tab
+// in the indentation.
+func highlightWhitespace(line, color string) string {
+	trailing := len(strings.TrimRight(line, " \t\r\n\v\f"))
+	var sb strings.Builder
+	written := 0
+	for i := 0; i < trailing; i++ {
+		if line[i] == ' ' {
+			continue
+		}
+		if line[i] != '\t' {
+			break
+		}
+		if written < i {
+			sb.WriteString(colorBgRed + line[written:i] + colorReset + "\t")
+		} else {
+			sb.WriteString(line[written : i+1])
+		}
+		written = i + 1
+	}
+	if trailing > written {
+		sb.WriteString(color + line[written:trailing] + colorReset)
+	}
+	if trailing < len(line) {
+		sb.WriteString(colorBgRed + line[trailing:] + colorReset)
+	}
+	return sb.String()
 }
@@ -0,0 +1,69 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Returns the path of the hook named name, or the empty string if there
+// is no such hook or it isn't executable.
+func findHook(c *Client, name string) string {
+	dir := c.GetConfig("core.hooksPath")
+	switch {
+	case dir == "":
+		dir = c.GitDir.File("hooks").String()
+	case !filepath.IsAbs(dir):
+		if c.IsBare() || c.WorkDir == "" {
+			dir = filepath.Join(c.GitDir.String(), dir)
+		} else {
+			dir = filepath.Join(c.WorkDir.String(), dir)
+		}
+	}
+	path := filepath.Join(dir, name)
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() || fi.Mode()&0111 == 0 {
+		return ""
+	}
+	return path
+}
+
+// Runs the hook name with the arguments args, if it exists. The hook's
+// stdin is read from stdin and both its stdout and stderr are written to
+// out. env is added to the hook's environment. An error is returned if the
+// hook exits with a non-zero status.
+func runHook(c *Client, name string, args []string, stdin io.Reader, out io.Writer, env []string) error {
+	path := findHook(c, name)
+	if path == "" {
+		return nil
+	}
+	gitdir, err := filepath.Abs(c.GitDir.String())
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(path, args...)
+	// Hooks which are run during a push always run in the git dir.
+	// Others run at the root of the work tree, if there is one.
+	switch name {
+	case "pre-receive", "update", "post-receive", "post-update", "push-to-checkout":
+		cmd.Dir = gitdir
+	default:
+		if c.IsBare() || c.WorkDir == "" {
+			cmd.Dir = gitdir
+		} else {
+			cmd.Dir = c.WorkDir.String()
+		}
+	}
+	cmd.Env = append(os.Environ(), "GIT_DIR="+gitdir)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = stdin
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package git
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type HTTPBackendOptions struct {
+	// The directory containing the repositories to serve. The path
+	// of a request is relative to this.
+	ProjectRoot string
+
+	// Serve all repositories under ProjectRoot, even if they don't
+	// contain a git-daemon-export-ok file.
+	ExportAll bool
+
+	// Allow pushes to repositories that don't set http.receivepack.
+	// This should be set if the client has been authenticated.
+	ReceivePack bool
+}
+
+// An httpBackend is an http.Handler which serves git repositories to
+// clients over the smart http protocol, and the dumb protocol for old
+// clients.
+type httpBackend struct {
+	opts HTTPBackendOptions
+}
+
+// NewHTTPBackend returns an http.Handler which serves the repositories
+// under opts.ProjectRoot over http, the same way as git http-backend.
+func NewHTTPBackend(opts HTTPBackendOptions) http.Handler {
+	return httpBackend{opts}
+}
+
+// A route for the http backend, with the pattern matching the end of the
+// request path (after the repository) and the function that handles it.
+type httpBackendRoute struct {
+	method  string
+	pattern *regexp.Regexp
+	handler func(h httpBackend, w http.ResponseWriter, r *http.Request, c *Client, file string)
+}
+
+var httpBackendRoutes = []httpBackendRoute{
+	{"GET", regexp.MustCompile("/HEAD$"), serveTextFile},
+	{"GET", regexp.MustCompile("/info/refs$"), serveInfoRefs},
+	{"GET", regexp.MustCompile("/objects/info/alternates$"), serveTextFile},
+	{"GET", regexp.MustCompile("/objects/info/http-alternates$"), serveTextFile},
+	{"GET", regexp.MustCompile("/objects/info/packs$"), serveTextFile},
+	{"GET", regexp.MustCompile("/objects/[0-9a-f]{2}/[0-9a-f]{38}$"), serveObjectFile},
+	{"GET", regexp.MustCompile("/objects/pack/pack-[0-9a-f]{40}\\.pack$"), serveObjectFile},
+	{"GET", regexp.MustCompile("/objects/pack/pack-[0-9a-f]{40}\\.idx$"), serveObjectFile},
+	{"POST", regexp.MustCompile("/git-upload-pack$"), serveRPC},
+	{"POST", regexp.MustCompile("/git-receive-pack$"), serveRPC},
+}
+
+func (h httpBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reqpath := r.URL.Path
+	if reqpath == "" || path.Clean(reqpath) != reqpath || strings.Contains(reqpath, "/../") {
+		http.Error(w, "Request not supported", http.StatusNotFound)
+		return
+	}
+	for _, route := range httpBackendRoutes {
+		loc := route.pattern.FindStringIndex(reqpath)
+		if loc == nil {
+			continue
+		}
+		if r.Method != route.method && !(route.method == "GET" && r.Method == "HEAD") {
+			if r.Proto == "HTTP/1.1" {
+				http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			} else {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+			}
+			return
+		}
+		dir := filepath.Join(h.opts.ProjectRoot, filepath.FromSlash(reqpath[:loc[0]]))
+		c, err := EnterRepo(dir, false)
+		if err != nil {
+			http.Error(w, "Repository not found", http.StatusNotFound)
+			return
+		}
+		if !h.opts.ExportAll && !c.GitDir.File("git-daemon-export-ok").Exists() {
+			http.Error(w, "Repository not exported", http.StatusNotFound)
+			return
+		}
+		route.handler(h, w, r, c, reqpath[loc[0]+1:])
+		return
+	}
+	http.Error(w, "Request not supported", http.StatusNotFound)
+}
+
+// Returns whether the service svc (upload-pack or receive-pack) is
+// enabled for the repository.
+func (h httpBackend) serviceEnabled(c *Client, svc string) bool {
+	switch svc {
+	case "upload-pack":
+		return c.GetConfig("http.uploadpack") != "false"
+	case "receive-pack":
+		switch c.GetConfig("http.receivepack") {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+		return h.opts.ReceivePack
+	}
+	return false
+}
+
+// Sets the headers which prevent caching of responses that change when
+// the repository does.
+func httpNoCache(w http.ResponseWriter) {
+	w.Header().Set("Expires", "Fri, 01 Jan 1980 00:00:00 GMT")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+}
+
+// Sets the headers which allow caching of responses that never change,
+// such as objects.
+func httpCacheForever(w http.ResponseWriter) {
+	now := time.Now()
+	w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+	w.Header().Set("Expires", now.Add(365*24*time.Hour).UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "public, max-age=31536000")
+}
+
+// Serves the file named file from the git directory of c.
+func sendGitDirFile(w http.ResponseWriter, r *http.Request, c *Client, file, contenttype string) {
+	if c.GetConfig("http.getanyfile") == "false" {
+		http.Error(w, "Unsupported service: getanyfile", http.StatusForbidden)
+		return
+	}
+	f, err := os.Open(filepath.Join(c.GitDir.String(), filepath.FromSlash(file)))
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", contenttype)
+	w.Header().Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+func serveTextFile(h httpBackend, w http.ResponseWriter, r *http.Request, c *Client, file string) {
+	httpNoCache(w)
+	sendGitDirFile(w, r, c, file, "text/plain")
+}
+
+func serveObjectFile(h httpBackend, w http.ResponseWriter, r *http.Request, c *Client, file string) {
+	httpCacheForever(w)
+	switch path.Ext(file) {
+	case ".pack":
+		sendGitDirFile(w, r, c, file, "application/x-git-packed-objects")
+	case ".idx":
+		sendGitDirFile(w, r, c, file, "application/x-git-packed-objects-toc")
+	default:
+		sendGitDirFile(w, r, c, file, "application/x-git-loose-object")
+	}
+}
+
+// Serves the ref advertisement for the smart protocol, or the info/refs
+// file for the dumb protocol if the client didn't ask for a service.
+func serveInfoRefs(h httpBackend, w http.ResponseWriter, r *http.Request, c *Client, file string) {
+	httpNoCache(w)
+	service := r.URL.Query().Get("service")
+	if service == "" {
+		if c.GetConfig("http.getanyfile") == "false" {
+			http.Error(w, "Unsupported service: getanyfile", http.StatusForbidden)
+			return
+		}
+		refs, err := ShowRef(c, ShowRefOptions{Dereference: true}, nil)
+		if err != nil && len(refs) > 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		for _, ref := range refs {
+			fmt.Fprintf(w, "%s\n", ref.TabString())
+		}
+		return
+	}
+
+	svc := strings.TrimPrefix(service, "git-")
+	if svc == service || !h.serviceEnabled(c, svc) {
+		http.Error(w, "Unsupported service: "+service, http.StatusForbidden)
+		return
+	}
+	gitprotocol := r.Header.Get("Git-Protocol")
+	w.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+	if svc == "upload-pack" && requestedProtocolVersion(gitprotocol) == 2 {
+		// Protocol version 2 starts with the capabilities
+		// instead of the service announcement.
+		if err := UploadPack(c, UploadPackOptions{StatelessRPC: true, AdvertiseRefs: true, GitProtocol: gitprotocol}, r.Body, w); err != nil {
+			logHTTPBackendError(err)
+		}
+		return
+	}
+	pw := pktLineWriter{w}
+	pw.Printf("# service=%s\n", service)
+	pw.Flush()
+	var err error
+	switch svc {
+	case "upload-pack":
+		err = UploadPack(c, UploadPackOptions{StatelessRPC: true, AdvertiseRefs: true, GitProtocol: gitprotocol}, r.Body, w)
+	case "receive-pack":
+		err = ReceivePack(c, ReceivePackOptions{StatelessRPC: true, AdvertiseRefs: true, GitProtocol: gitprotocol}, r.Body, w)
+	}
+	if err != nil {
+		logHTTPBackendError(err)
+	}
+}
+
+// Serves a request for the smart http protocol by passing the request
+// body to upload-pack or receive-pack.
+func serveRPC(h httpBackend, w http.ResponseWriter, r *http.Request, c *Client, file string) {
+	service := file
+	svc := strings.TrimPrefix(service, "git-")
+	if !h.serviceEnabled(c, svc) {
+		http.Error(w, "Unsupported service: "+service, http.StatusForbidden)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/x-"+service+"-request" {
+		http.Error(w, "Unsupported media type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "":
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid gzip request body", http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		http.Error(w, "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	httpNoCache(w)
+	w.Header().Set("Content-Type", "application/x-"+service+"-result")
+	gitprotocol := r.Header.Get("Git-Protocol")
+	var err error
+	switch svc {
+	case "upload-pack":
+		err = UploadPack(c, UploadPackOptions{StatelessRPC: true, GitProtocol: gitprotocol}, body, w)
+	case "receive-pack":
+		err = ReceivePack(c, ReceivePackOptions{StatelessRPC: true, GitProtocol: gitprotocol}, body, w)
+	}
+	if err != nil {
+		logHTTPBackendError(err)
+	}
+}
+
+// Errors from upload-pack and receive-pack have usually already been sent
+// to the client, and the response status can't be changed after the body
+// has started, so they're only logged to the server's stderr.
+func logHTTPBackendError(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+}
@@ -0,0 +1,134 @@
+package git
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTTPBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "githttpbackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcdir := filepath.Join(dir, "src")
+	src, err := Init(nil, InitOptions{Quiet: true}, srcdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcdir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(src, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := Commit(src, CommitOptions{}, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "pushed.git")); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewHTTPBackend(HTTPBackendOptions{ProjectRoot: dir})
+	ts := httptest.NewServer(backend)
+	defer ts.Close()
+
+	// Nothing is exported without git-daemon-export-ok
+	resp, err := http.Get(ts.URL + "/src/info/refs?service=git-upload-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Unexported repository: got status %v want 404", resp.StatusCode)
+	}
+
+	ts.Config.Handler = NewHTTPBackend(HTTPBackendOptions{ProjectRoot: dir, ExportAll: true})
+	resp, err = http.Get(ts.URL + "/pushed.git/info/refs?service=git-receive-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("receive-pack without authentication: got status %v want 403", resp.StatusCode)
+	}
+
+	// Fetch with the smart http client, which uses protocol v2.
+	dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "dst.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs, err := FetchPack(dst, FetchPackOptions{All: true}, Remote(ts.URL+"/src"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Name != "refs/heads/master" || refs[0].Value != Sha1(cmt) {
+		t.Errorf("Unexpected refs from http-backend: %v", refs)
+	}
+	if have, _, err := dst.HaveObject(Sha1(cmt)); !have || err != nil {
+		t.Errorf("Commit %v was not fetched: %v", cmt, err)
+	}
+
+	// A gzipped protocol v2 request
+	var req, gzreq bytes.Buffer
+	w := pktLineWriter{&req}
+	w.Printf("command=ls-refs\n")
+	w.Delim()
+	w.Printf("ref-prefix refs/heads/\n")
+	w.Flush()
+	gz := gzip.NewWriter(&gzreq)
+	gz.Write(req.Bytes())
+	gz.Close()
+	r, err := http.NewRequest("POST", ts.URL+"/src/git-upload-pack", &gzreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	r.Header.Set("Content-Encoding", "gzip")
+	r.Header.Set("Git-Protocol", "version=2")
+	resp, err = http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-git-upload-pack-result" {
+		t.Errorf("Unexpected Content-Type: got %v", ct)
+	}
+	if want := "003f" + cmt.String() + " refs/heads/master\n0000"; string(body) != want {
+		t.Errorf("Unexpected ls-refs response: got %q want %q", body, want)
+	}
+
+	// Push with the smart http client. The credentials are included in
+	// the URL so that the client doesn't prompt for them.
+	ts.Config.Handler = NewHTTPBackend(HTTPBackendOptions{ProjectRoot: dir, ExportAll: true, ReceivePack: true})
+	pushurl := strings.Replace(ts.URL, "http://", "http://user:pass@", 1) + "/pushed.git"
+	if err := SendPack(src, SendPackOptions{}, Remote(pushurl), []Refname{"refs/heads/master:refs/heads/master"}); err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := EnterRepo(filepath.Join(dir, "pushed.git"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := RefSpec("refs/heads/master").Sha1(pushed); got != Sha1(cmt) {
+		t.Errorf("Unexpected value for pushed ref: got %v want %v", got, cmt)
+	}
+	if have, _, err := pushed.HaveObject(Sha1(cmt)); !have || err != nil {
+		t.Errorf("Commit %v was not pushed: %v", cmt, err)
+	}
+}
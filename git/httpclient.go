@@ -0,0 +1,237 @@
+package git
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An httpClient is an http.Client which has been configured with git's
+// http.* configuration for a URL.
+type httpClient struct {
+	*http.Client
+
+	// The User-Agent header to send with every request
+	userAgent string
+
+	// Headers from http.extraHeader to send with every request
+	extraHeader http.Header
+}
+
+// Returns true if the config value v is one of git's boolean false
+// values. The empty string is not false, so that unset values can use
+// the default.
+func configIsFalse(v string) bool {
+	switch strings.ToLower(v) {
+	case "false", "no", "off", "0":
+		return true
+	}
+	return false
+}
+
+// Looks up an http.<url>.* config variable for urls, with the environment
+// variable env taking precedence if it's set.
+func httpConfig(c *Client, urls, key, env string) string {
+	if env != "" {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	return c.GetURLConfig("http", urls, key)
+}
+
+// newHTTPClient returns a client for making requests to urls with the
+// proxy, TLS, cookie and header configuration from the http.* config
+// variables (including http.<url>.* overrides) and their GIT_*
+// environment variable equivalents.
+func newHTTPClient(c *Client, urls string) (*httpClient, error) {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	// http.proxy takes precedence over the http_proxy, https_proxy
+	// and no_proxy environment variables. As with git, a proxy without
+	// a scheme is assumed to be an http proxy.
+	if proxy := c.GetURLConfig("http", urls, "proxy"); proxy != "" {
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+		proxyurl, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid http.proxy %v: %v", proxy, err)
+		}
+		log.Printf("Using proxy %v for %v\n", proxyurl.Host, urls)
+		transport.Proxy = http.ProxyURL(proxyurl)
+	}
+
+	tlsconfig := &tls.Config{}
+	if os.Getenv("GIT_SSL_NO_VERIFY") != "" || configIsFalse(c.GetURLConfig("http", urls, "sslVerify")) {
+		tlsconfig.InsecureSkipVerify = true
+	}
+
+	cainfo := httpConfig(c, urls, "sslCAInfo", "GIT_SSL_CAINFO")
+	capath := httpConfig(c, urls, "sslCAPath", "GIT_SSL_CAPATH")
+	if cainfo != "" || capath != "" {
+		pool := x509.NewCertPool()
+		var files []string
+		if cainfo != "" {
+			files = append(files, cainfo)
+		}
+		if capath != "" {
+			dir, err := ioutil.ReadDir(capath)
+			if err != nil {
+				return nil, err
+			}
+			for _, fi := range dir {
+				if !fi.IsDir() {
+					files = append(files, filepath.Join(capath, fi.Name()))
+				}
+			}
+		}
+		for _, f := range files {
+			pem, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) && f == cainfo {
+				return nil, fmt.Errorf("Could not load CA certificates from %v", f)
+			}
+		}
+		tlsconfig.RootCAs = pool
+	}
+
+	if cert := httpConfig(c, urls, "sslCert", "GIT_SSL_CERT"); cert != "" {
+		// If there's no separate key, it's assumed that the
+		// key is in the same PEM file as the cert.
+		key := httpConfig(c, urls, "sslKey", "GIT_SSL_KEY")
+		if key == "" {
+			key = cert
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsconfig.Certificates = []tls.Certificate{pair}
+	}
+	transport.TLSClientConfig = tlsconfig
+
+	client := &httpClient{
+		Client:      &http.Client{Transport: transport},
+		userAgent:   httpConfig(c, urls, "userAgent", "GIT_HTTP_USER_AGENT"),
+		extraHeader: make(http.Header),
+	}
+	if client.userAgent == "" {
+		client.userAgent = "dgit/0.0.2"
+	}
+
+	// An empty extraHeader resets the list, so that a more specific
+	// http.<url>.extraHeader can override the less specific ones.
+	for _, h := range c.GetURLConfigAll("http", urls, "extraHeader") {
+		if h == "" {
+			client.extraHeader = make(http.Header)
+			continue
+		}
+		colon := strings.IndexByte(h, ':')
+		if colon < 0 {
+			return nil, fmt.Errorf("Invalid http.extraHeader: %v", h)
+		}
+		client.extraHeader.Add(strings.TrimSpace(h[:colon]), strings.TrimSpace(h[colon+1:]))
+	}
+
+	if cookies := c.GetURLConfig("http", urls, "cookieFile"); cookies != "" {
+		jar, err := loadCookieFile(cookies)
+		if err != nil {
+			return nil, err
+		}
+		client.Jar = jar
+	}
+	return client, nil
+}
+
+// Creates a new request with the headers that should be sent with every
+// request.
+func (h *httpClient) newRequest(method, urls string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, urls, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", h.userAgent)
+	for k, vals := range h.extraHeader {
+		for _, v := range vals {
+			req.Header.Add(k, v)
+		}
+	}
+	return req, nil
+}
+
+// Loads a cookie file in the Netscape cookie file format (the same
+// format used by curl, which git uses for http.cookieFile) into a
+// cookie jar. A file which doesn't exist is treated as having no cookies.
+func loadCookieFile(fname string) (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(fname, "~/") {
+		fname = filepath.Join(os.Getenv("HOME"), fname[2:])
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return jar, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		httponly := false
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+			httponly = true
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			continue
+		}
+		cookie := &http.Cookie{
+			Path:     fields[2],
+			Secure:   fields[3] == "TRUE",
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httponly,
+		}
+		domain := strings.TrimPrefix(fields[0], ".")
+		if fields[1] == "TRUE" {
+			cookie.Domain = domain
+		}
+		if expiry, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expiry != 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: domain, Path: cookie.Path}, []*http.Cookie{cookie})
+	}
+	return jar, scanner.Err()
+}
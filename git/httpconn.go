@@ -17,14 +17,28 @@ import (
 type smartHTTPConn struct {
 	*sharedRemoteConn
 
+	// The client, used for looking up config such as credential
+	// helpers.
+	c *Client
+
 	// The giturl is the URL for this connection. It may or may not be
 	// the same as what it was initiated as, depending on whether or not
 	// ".git" had to be appended to the URL.
 	giturl string
 
+	// The client used to make requests, configured from the http.*
+	// config for giturl.
+	client *httpClient
+
 	// username/password to use over HTTP basic auth.
 	username, password string
 
+	// The credential that username and password were filled from, if
+	// they came from CredentialFill, so that it can be approved or
+	// rejected.
+	cred     *Credential
+	approved bool
+
 	// nil we haven't tried to open yet, true if successfully got initial
 	// git-upload-pack response, and false if there was a problem getting
 	// the upload-pack response
@@ -41,6 +55,11 @@ type smartHTTPConn struct {
 	almostdone bool
 
 	openmode GitService
+
+	// Set if the server only supports the dumb http protocol, in which
+	// case objects need to be fetched with fetchDumbHTTP rather than
+	// by negotiating a pack.
+	dumb bool
 }
 
 var _ RemoteConn = &smartHTTPConn{}
@@ -54,13 +73,12 @@ func (s *smartHTTPConn) OpenConn(srv GitService) error {
 	case UploadPackService:
 		expectedmime = "application/x-git-upload-pack-advertisement"
 	case ReceivePackService:
-		userpass, err := getUserPassword(s.giturl)
-		if err != nil {
+		// Pushing always requires authentication, so get the
+		// credentials before the first request rather than
+		// waiting for a 401.
+		if err := s.fillCredentials(); err != nil {
 			return err
 		}
-		s.username = userpass.user
-		s.password = userpass.password
-
 		expectedmime = "application/x-git-receive-pack-advertisement"
 	default:
 		return fmt.Errorf("Unsupported service")
@@ -76,21 +94,24 @@ func (s *smartHTTPConn) OpenConn(srv GitService) error {
 	var trueref bool = true
 	var falseref bool = false
 
-	req, err := http.NewRequest("GET", s.giturl+"/info/refs?service="+s.service, nil)
+	if s.client == nil {
+		client, err := newHTTPClient(s.c, s.giturl)
+		if err != nil {
+			return err
+		}
+		s.client = client
+	}
+
+	req, err := s.client.newRequest("GET", s.giturl+"/info/refs?service="+s.service, nil)
 	if err != nil {
 		// We couldn't even try making a request, so give up early.
 		return err
 	}
 
-	// Set username and password if applicable
-	if s.username != "" || s.password != "" {
-		req.SetBasicAuth(s.username, s.password)
-	}
-
 	if srv == UploadPackService {
 		req.Header.Set("Git-Protocol", "version=2")
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		// If we couldn't perform the request, there's probably a
 		// network issue so give up.
@@ -102,34 +123,47 @@ func (s *smartHTTPConn) OpenConn(srv GitService) error {
 		// The git protocol only responds 403, 200, or 304.
 		// 401 means our credentials are wrong regardless of
 		// whether or not the URL ends in .git
+		s.rejectCredentials()
 		var buf bytes.Buffer
 		io.Copy(&buf, resp.Body)
-		return fmt.Errorf("%s", buf.String())
+		return fmt.Errorf("Authentication failed for '%s': %s", s.giturl, buf.String())
 	}
 	if ct := resp.Header.Get("Content-Type"); ct != expectedmime || resp.StatusCode != 200 {
+		if resp.StatusCode == 200 && srv == UploadPackService && s.openDumb(resp.Body) {
+			s.isopen = &trueref
+			return nil
+		}
 		// If the content-type was wrong, try again at "url.git"
 		log.Printf("Unexpected Content-Type for %v: got %v\n", s.giturl, ct)
 		if strings.HasSuffix(s.giturl, ".git") {
 			return fmt.Errorf("Remote did not speak git protocol")
 		}
 		s.giturl = s.giturl + ".git"
-		req, err = http.NewRequest("GET", s.giturl+"/info/refs?service="+s.service, nil)
-		if s.username != "" || s.password != "" {
-			req.SetBasicAuth(s.username, s.password)
+		req, err = s.client.newRequest("GET", s.giturl+"/info/refs?service="+s.service, nil)
+		if err != nil {
+			return err
 		}
 		req.Header.Set("Git-Protocol", "version=2")
-		newresp, err := http.DefaultClient.Do(req)
+		newresp, err := s.do(req)
 		if err != nil {
 			s.isopen = &falseref
 			return fmt.Errorf("Could not connect to remote")
 		}
 		defer newresp.Body.Close()
 		if ct := newresp.Header.Get("Content-Type"); ct != expectedmime || newresp.StatusCode != 200 {
+			if newresp.StatusCode == 200 && srv == UploadPackService && s.openDumb(newresp.Body) {
+				s.isopen = &trueref
+				return nil
+			}
 			log.Printf("Unexpected Content-Type for %v: got %v\n", s.giturl, ct)
 			s.isopen = &falseref
+			if newresp.StatusCode == 401 {
+				s.rejectCredentials()
+				return fmt.Errorf("Authentication failed for '%s'", s.giturl)
+			}
 			if newresp.StatusCode == 403 {
 				var buf bytes.Buffer
-				io.Copy(&buf, resp.Body)
+				io.Copy(&buf, newresp.Body)
 				return fmt.Errorf("%s", buf.String())
 			}
 			return fmt.Errorf("Remote did not speak git protocol")
@@ -160,6 +194,77 @@ func (s *smartHTTPConn) OpenConn(srv GitService) error {
 	}
 }
 
+// Performs the request req with the credentials for s. If the server
+// responds that authentication is required and no credentials have been
+// tried yet, the credentials are filled in and the request is retried.
+func (s *smartHTTPConn) do(req *http.Request) (*http.Response, error) {
+	if s.username != "" || s.password != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 401 || s.cred != nil {
+		s.approveCredentials(resp)
+		return resp, nil
+	}
+	resp.Body.Close()
+	log.Printf("Authentication required for %v\n", req.URL)
+	if err := s.fillCredentials(); err != nil {
+		return nil, err
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+	req.SetBasicAuth(s.username, s.password)
+	resp, err = s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	s.approveCredentials(resp)
+	return resp, nil
+}
+
+// Tells the credential helpers that the credentials were accepted, if
+// they were and haven't already been approved.
+func (s *smartHTTPConn) approveCredentials(resp *http.Response) {
+	if s.cred == nil || s.approved || resp.StatusCode != 200 {
+		return
+	}
+	s.approved = true
+	CredentialApprove(s.c, *s.cred)
+}
+
+// Fills in the username and password for s from the credential helpers
+// or by prompting the user.
+func (s *smartHTTPConn) fillCredentials() error {
+	cred, err := NewCredentialFromURL(s.giturl)
+	if err != nil {
+		return err
+	}
+	cred, err = CredentialFill(s.c, cred)
+	if err != nil {
+		return err
+	}
+	s.cred = &cred
+	s.username = cred.Username
+	s.password = cred.Password
+	return nil
+}
+
+// Tells the credential helpers that the credentials that were used were
+// rejected by the server.
+func (s *smartHTTPConn) rejectCredentials() {
+	if s.cred != nil {
+		CredentialReject(s.c, *s.cred)
+	}
+}
+
 func parseRemoteInitialConnection(r io.Reader, stateless bool) (uint8, map[string]map[string]struct{}, []Ref, error) {
 	line := loadLine(r)
 	switch line {
@@ -173,6 +278,10 @@ func parseRemoteInitialConnection(r io.Reader, stateless bool) (uint8, map[strin
 		}
 	}
 
+	if strings.HasPrefix(line, "ERR ") {
+		return 0, nil, nil, fmt.Errorf("remote error: %s", strings.TrimSpace(line[4:]))
+	}
+
 	switch line {
 	case "version 2", "version 2\n":
 		cap := make(map[string]map[string]struct{})
@@ -208,7 +317,9 @@ func parseRemoteInitialConnection(r io.Reader, stateless bool) (uint8, map[strin
 			//  can cause a name to end)
 			var nameEnd int
 			for idx, char := range s {
-				if char == ' ' && ret.Value == (Sha1{}) {
+				// An empty repository advertises the zero id, so
+				// check firstSpace rather than ret.Value.
+				if char == ' ' && firstSpace == 0 {
 					sha1, err := Sha1FromString(s[0:idx])
 					if err != nil {
 						return nil, err
@@ -292,16 +403,22 @@ func (s smartHTTPConn) GetRefs(opts LsRemoteOptions, patterns []string) ([]Ref,
 		if err != nil {
 			return nil, err
 		}
-		r, err := http.NewRequest("POST", s.giturl+"/git-upload-pack", strings.NewReader(topost))
-		r.Header.Set("User-Agent", "dgit/0.0.2")
+		r, err := s.client.newRequest("POST", s.giturl+"/git-upload-pack", strings.NewReader(topost))
+		if err != nil {
+			return nil, err
+		}
 		r.Header.Set("Git-Protocol", "version=2")
 		r.Header.Set("Content-Type", "application/x-git-upload-pack-request")
 		r.ContentLength = int64(len([]byte(topost)))
-		resp, err := http.DefaultClient.Do(r)
+		resp, err := s.do(r)
 		if err != nil {
 			return nil, err
 		}
 		defer resp.Body.Close()
+		if resp.StatusCode == 401 {
+			s.rejectCredentials()
+			return nil, fmt.Errorf("Authentication failed for '%s'", s.giturl)
+		}
 		for line := loadLine(resp.Body); line != ""; line = loadLine(resp.Body) {
 			ref, err := parseLsRef(line)
 			if err != nil {
@@ -373,8 +490,10 @@ func (s *smartHTTPConn) Delim() error {
 func (s *smartHTTPConn) sendRequest(expectedmime string) error {
 	log.Println("Sending HTTP Request")
 	topost := s.buf.String()
-	r, err := http.NewRequest("POST", s.giturl+"/"+s.service, strings.NewReader(topost))
-	r.Header.Set("User-Agent", "dgit/0.0.2")
+	r, err := s.client.newRequest("POST", s.giturl+"/"+s.service, strings.NewReader(topost))
+	if err != nil {
+		return err
+	}
 	if s.protocolversion == 2 {
 		r.Header.Set("Git-Protocol", "version=2")
 	}
@@ -388,16 +507,17 @@ func (s *smartHTTPConn) sendRequest(expectedmime string) error {
 		return fmt.Errorf("Invalid request type")
 	}
 	r.ContentLength = int64(len([]byte(topost)))
-	if s.username != "" || s.password != "" {
-		r.SetBasicAuth(s.username, s.password)
-	}
-	resp, err := http.DefaultClient.Do(r)
+	resp, err := s.do(r)
 	if err != nil {
 		return err
 	}
 	// If the response or status code is wrong we return an
 	// error and don't try alternatives, because this should
 	// have all been negotiated correctly during OpenConn()
+	if resp.StatusCode == 401 {
+		s.rejectCredentials()
+		return fmt.Errorf("Authentication failed for '%s'", s.giturl)
+	}
 	if ct := resp.Header.Get("Content-Type"); ct != expectedmime {
 		return fmt.Errorf("Unexpected Content-Type for %v: got %v\n", s.giturl, ct)
 	}
@@ -0,0 +1,151 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Returns a client with no config other than the name/value pairs given
+// (in order), so that tests are not affected by the user's config.
+func newTestConfigClient(configs [][2]string) *Client {
+	c := &Client{}
+	c.localConfig = &GitConfig{}
+	c.globalConfig = &GitConfig{}
+	for _, cfg := range configs {
+		c.SetCachedConfig(cfg[0], cfg[1])
+	}
+	return c
+}
+
+func TestSmartHTTPAuthAndHeaders(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if got := r.Header.Get("User-Agent"); got != "test-agent/1.0" {
+			t.Errorf("Unexpected User-Agent: got %v", got)
+		}
+		if got := r.Header.Get("X-Extra"); got != "second" {
+			t.Errorf("Unexpected X-Extra header: got %v", got)
+		}
+		if got := r.Header.Get("X-Reset"); got != "" {
+			t.Errorf("Header should have been reset by empty extraHeader: got %v", got)
+		}
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "abc" {
+			t.Errorf("Missing cookie from cookie file: %v", err)
+		}
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			w.WriteHeader(401)
+			return
+		}
+		if user != "bob" || pass != "s3cr3t" {
+			w.WriteHeader(401)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		fmt.Fprintf(w, "001e# service=git-upload-pack\n0000")
+		line, _ := PktLineEncodeNoNl([]byte("0123456789012345678901234567890123456789 refs/heads/master\x00ofs-delta\n"))
+		fmt.Fprintf(w, "%s0000", line)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "githttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	u, _ := url.Parse(ts.URL)
+	cookies := filepath.Join(dir, "cookies")
+	if err := ioutil.WriteFile(cookies, []byte("# Netscape HTTP Cookie File\n"+u.Hostname()+"\tFALSE\t/\tFALSE\t0\tsession\tabc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	repourl := ts.URL + "/repo.git"
+	c := newTestConfigClient([][2]string{
+		{"http.userAgent", "test-agent/1.0"},
+		{"http.extraHeader", "X-Reset: yes"},
+		{"http.extraHeader", ""},
+		{"http.extraHeader", "X-Extra: first"},
+		{"http." + ts.URL + "/repo.git.extraHeader", ""},
+		{"http." + ts.URL + "/repo.git.extraHeader", "X-Extra: second"},
+		{"http.cookieFile", cookies},
+		{"credential.helper", "!f() { echo username=bob; echo password=s3cr3t; }; f"},
+	})
+
+	conn := &smartHTTPConn{
+		sharedRemoteConn: &sharedRemoteConn{},
+		c:                c,
+		giturl:           repourl,
+	}
+	conn.SetService("git-upload-pack")
+	if err := conn.OpenConn(UploadPackService); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected request to be retried after 401: got %d requests", requests)
+	}
+	if conn.ProtocolVersion() != 1 || len(conn.refs) != 1 || conn.refs[0].Name != "refs/heads/master" {
+		t.Errorf("Unexpected result from connection: version %v refs %v", conn.ProtocolVersion(), conn.refs)
+	}
+
+	// Wrong credentials should be rejected without retrying forever.
+	requests = 0
+	c = newTestConfigClient([][2]string{
+		{"http.userAgent", "test-agent/1.0"},
+		{"http.extraHeader", "X-Extra: second"},
+		{"http.cookieFile", cookies},
+		{"credential.helper", "!f() { echo username=bob; echo password=wrong; }; f"},
+	})
+	conn = &smartHTTPConn{
+		sharedRemoteConn: &sharedRemoteConn{},
+		c:                c,
+		giturl:           repourl,
+	}
+	conn.SetService("git-upload-pack")
+	if err := conn.OpenConn(UploadPackService); err == nil {
+		t.Error("Expected authentication failure with wrong password")
+	}
+	if requests != 2 {
+		t.Errorf("Unexpected number of requests with wrong password: got %d want 2", requests)
+	}
+}
+
+func TestHTTPProxyConfig(t *testing.T) {
+	c := newTestConfigClient([][2]string{
+		{"http.proxy", "proxy.example.com:3128"},
+		{"http.https://internal.example.com.proxy", "http://other.example.com:8080"},
+		{"http.https://example.com.sslVerify", "false"},
+	})
+	tests := []struct {
+		url, proxy string
+		insecure   bool
+	}{
+		{"https://example.com/repo.git", "proxy.example.com:3128", true},
+		{"https://github.com/repo.git", "proxy.example.com:3128", false},
+		{"https://internal.example.com/repo.git", "other.example.com:8080", false},
+	}
+	for i, tc := range tests {
+		client, err := newHTTPClient(c, tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := client.Transport.(*http.Transport)
+		req, _ := http.NewRequest("GET", tc.url, nil)
+		proxy, err := transport.Proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proxy == nil || proxy.Host != tc.proxy {
+			t.Errorf("Test %d: unexpected proxy for %v: got %v want %v", i, tc.url, proxy, tc.proxy)
+		}
+		if got := transport.TLSClientConfig.InsecureSkipVerify; got != tc.insecure {
+			t.Errorf("Test %d: unexpected sslVerify for %v: got insecure %v want %v", i, tc.url, got, tc.insecure)
+		}
+	}
+}
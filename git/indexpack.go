@@ -147,7 +147,7 @@ func v2PackIndexHasSha1(c *Client, pfile File, r io.Reader, obj Sha1) bool {
 		} else {
 			offset = int64(pack.FourByteOffsets[i])
 		}
-		c.objectCache[pack.Sha1Table[i]] = objectLocation{false, pfile, &pack, offset}
+		c.objectCache[pack.Sha1Table[i]] = objectLocation{false, pfile, &pack, offset, ""}
 	}
 	return pack.HasObject(obj)
 }
@@ -573,11 +573,11 @@ func (idx PackfileIndexV2) writeIndex(w io.Writer, withTrailer bool) error {
 }
 func (idx PackfileIndexV2) HasObject(s Sha1) bool {
 	startIdx := idx.Fanout[s[0]]
-	if startIdx <= 0 {
-		// The fanout table holds the number of entries less than x, so we
-		// subtract 1 to make sure we don't miss the hash we're looking for,
-		// but we need a special case for s[0] == 0 to prevent underflow
-		startIdx = 1
+	if startIdx == 0 || int(startIdx) > len(idx.Sha1Table) {
+		// The fanout table holds the number of entries less than or
+		// equal to x, so if it's 0 there's nothing to find (and the
+		// index may be empty.)
+		return false
 	}
 
 	// Packfiles are designed so that we could do a binary search here, but
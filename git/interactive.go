@@ -44,8 +44,9 @@ func splitPatch(fullpatch string, nameonly bool) ([]patchHunk, error) {
 }
 
 func extractPatchHunks(name IndexPath, filepatch string) []patchHunk {
-	// Regex to extract the hunk header which delineates different hunks
-	hunkRE := regexp.MustCompile(`(?m)^@@ -([\d]+)(?:,[\d]+)? \+([\d]+)(?:,[\d]+)? @@$`)
+	// Regex to extract the hunk header which delineates different hunks,
+	// which may be followed by the function that the hunk is in.
+	hunkRE := regexp.MustCompile(`(?m)^@@ -([\d]+)(?:,[\d]+)? \+([\d]+)(?:,[\d]+)? @@(?: .*)?$`)
 
 	// Regex to extract parts of that hunk that are actually part of the patch.
 	// Must start with a space, a plus, or a minus sign (for context diff)
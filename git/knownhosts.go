@@ -0,0 +1,294 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// knownHosts verifies ssh host keys against OpenSSH known_hosts files.
+type knownHosts struct {
+	// The files to check.
+	files []string
+
+	// The file that new keys get added to. If empty, new keys are
+	// not saved.
+	userfile string
+
+	// The value of the StrictHostKeyChecking ssh option, which
+	// determines what happens when a host isn't known. One of
+	// "yes" (refuse to connect), "accept-new" or "no" (add the key
+	// without asking), or "ask" (prompt the user. This is the
+	// default.)
+	strict string
+}
+
+// The result of looking a host up in known_hosts.
+type knownHostStatus uint8
+
+const (
+	hostUnknown = knownHostStatus(iota)
+	hostKnown
+	hostKeyChanged
+	hostKeyRevoked
+)
+
+// Returns the knownHosts for a connection to host as user, using the
+// UserKnownHostsFile and StrictHostKeyChecking options from cfg if set.
+func newKnownHosts(cfg sshHostConfig, host, user string) knownHosts {
+	var k knownHosts
+	if len(cfg.UserKnownHostsFiles) > 0 {
+		for _, f := range cfg.UserKnownHostsFiles {
+			if f == "none" || f == "/dev/null" {
+				continue
+			}
+			k.files = append(k.files, expandSSHPath(f, host, user))
+		}
+	} else {
+		home := homeDir()
+		k.files = []string{
+			filepath.Join(home, ".ssh", "known_hosts"),
+			filepath.Join(home, ".ssh", "known_hosts2"),
+		}
+	}
+	if len(k.files) > 0 {
+		k.userfile = k.files[0]
+	}
+	// The system wide known hosts are checked, but never written to.
+	k.files = append(k.files, "/etc/ssh/ssh_known_hosts")
+
+	k.strict = cfg.StrictHostKeyChecking
+	switch k.strict {
+	case "off":
+		k.strict = "no"
+	case "":
+		k.strict = "ask"
+	}
+	return k
+}
+
+// Converts the "host:port" address passed to the HostKeyCallback into the
+// format used in known_hosts files.
+func knownHostsAddr(hostport string) string {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport
+	}
+	if port == "22" {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+// Returns true if pattern is a hashed host (|1|salt|hash) that matches
+// host.
+func knownHostsHashMatches(pattern, host string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// Returns true if the list of patterns from a known_hosts line matches
+// host.
+func knownHostsMatches(patterns []string, host string) bool {
+	matched := false
+	host = strings.ToLower(host)
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "|") {
+			if knownHostsHashMatches(p, host) {
+				matched = true
+			}
+			continue
+		}
+		negate := strings.HasPrefix(p, "!")
+		p = strings.ToLower(strings.TrimPrefix(p, "!"))
+		// Brackets surround the host of a [host]:port entry, they
+		// aren't character classes.
+		p = strings.NewReplacer("[", `\[`, "]", `\]`).Replace(p)
+		if ok, _ := path.Match(p, host); !ok {
+			continue
+		}
+		if negate {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// Calls fn for every valid host key line in the known hosts files.
+func (k knownHosts) each(fn func(marker string, hosts []string, key ssh.PublicKey)) {
+	for _, fname := range k.files {
+		f, err := os.Open(fname)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			marker, hosts, key, _, _, err := ssh.ParseKnownHosts(scanner.Bytes())
+			if err != nil {
+				// Comments, blank lines, and lines that
+				// we don't understand.
+				continue
+			}
+			fn(marker, hosts, key)
+		}
+		f.Close()
+	}
+}
+
+// Looks up host in the known hosts files and determines the status of
+// key for it.
+func (k knownHosts) lookup(host string, key ssh.PublicKey) knownHostStatus {
+	status := hostUnknown
+	keybytes := key.Marshal()
+	k.each(func(marker string, hosts []string, known ssh.PublicKey) {
+		if !knownHostsMatches(hosts, host) {
+			return
+		}
+		same := bytes.Equal(known.Marshal(), keybytes)
+		switch marker {
+		case "revoked":
+			if same {
+				status = hostKeyRevoked
+			}
+		case "":
+			if status == hostKeyRevoked {
+				return
+			}
+			if same {
+				status = hostKnown
+			} else if known.Type() == key.Type() && status != hostKnown {
+				status = hostKeyChanged
+			}
+		}
+	})
+	return status
+}
+
+// Returns the host key algorithms for the keys known for hostport, so
+// that the server is asked for a key type we can verify. Returns nil if
+// the host isn't known.
+func (k knownHosts) hostKeyAlgorithms(hostport string) []string {
+	host := knownHostsAddr(hostport)
+	var algos []string
+	seen := make(map[string]bool)
+	k.each(func(marker string, hosts []string, known ssh.PublicKey) {
+		if marker != "" || !knownHostsMatches(hosts, host) || seen[known.Type()] {
+			return
+		}
+		seen[known.Type()] = true
+		algos = append(algos, known.Type())
+	})
+	return algos
+}
+
+// Returns the name of the key type in the format that ssh prints in
+// messages (ie. "ED25519" for "ssh-ed25519")
+func sshKeyTypeName(key ssh.PublicKey) string {
+	switch t := key.Type(); {
+	case strings.HasPrefix(t, "ecdsa-"):
+		return "ECDSA"
+	default:
+		return strings.ToUpper(strings.TrimPrefix(t, "ssh-"))
+	}
+}
+
+// Adds key for host to the first known hosts file.
+func (k knownHosts) add(host string, key ssh.PublicKey) error {
+	if k.userfile == "" {
+		return nil
+	}
+	fname := k.userfile
+	if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s", host, ssh.MarshalAuthorizedKey(key)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Asks the user whether to trust key for host.
+func (k knownHosts) prompt(host string, remote net.Addr, key ssh.PublicKey) bool {
+	if v := os.Getenv("GIT_TERMINAL_PROMPT"); v == "0" || v == "false" {
+		return false
+	}
+	desc := host
+	if tcp, ok := remote.(*net.TCPAddr); ok && tcp.IP.String() != host {
+		desc = fmt.Sprintf("%s (%s)", host, tcp.IP)
+	}
+	fmt.Fprintf(os.Stderr, "The authenticity of host '%s' can't be established.\n", desc)
+	fmt.Fprintf(os.Stderr, "%s key fingerprint is %s.\n", sshKeyTypeName(key), ssh.FingerprintSHA256(key))
+	for {
+		answer := strings.ToLower(readLine("Are you sure you want to continue connecting (yes/no)? "))
+		switch answer {
+		case "yes":
+			return true
+		case "no", "":
+			// readLine returns "" on EOF
+			return false
+		}
+	}
+}
+
+// HostKeyCallback verifies the key for the host that we're connecting to.
+func (k knownHosts) HostKeyCallback(hostport string, remote net.Addr, key ssh.PublicKey) error {
+	host := knownHostsAddr(hostport)
+	switch k.lookup(host, key) {
+	case hostKnown:
+		return nil
+	case hostKeyRevoked:
+		return fmt.Errorf("Host key for %s has been revoked", host)
+	case hostKeyChanged:
+		return fmt.Errorf("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n"+
+			"@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\n"+
+			"@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n"+
+			"IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!\n"+
+			"The %s key sent by the remote host is\n%s.\n"+
+			"Host key verification failed.",
+			sshKeyTypeName(key), ssh.FingerprintSHA256(key))
+	}
+
+	switch k.strict {
+	case "yes":
+		return fmt.Errorf("No %s host key is known for %s and you have requested strict checking.\nHost key verification failed.", sshKeyTypeName(key), host)
+	case "ask":
+		if !k.prompt(host, remote, key) {
+			return fmt.Errorf("Host key verification failed.")
+		}
+	}
+	if err := k.add(host, key); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to add the host to the list of known hosts (%s): %v\n", k.userfile, err)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Warning: Permanently added '%s' (%s) to the list of known hosts.\n", host, sshKeyTypeName(key))
+	return nil
+}
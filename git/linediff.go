@@ -0,0 +1,509 @@
+package git
+
+// A lineHunk is a range of lines in one version of a file which replaces a
+// range of lines in another version. The line numbers are 0 based, and a
+// count of 0 means that lines were only added or only removed, in which
+// case the start is the line the change comes before.
+type lineHunk struct {
+	A, ACount int
+	B, BCount int
+}
+
+// Returns the hunks which change the lines a into the lines b, calculated
+// with the same variation of Myers' algorithm as git's xdiff, so that
+// ambiguous changes are resolved in the same way. The lines are compared
+// exactly, so a caller which wants to ignore some differences should
+// normalize them first.
+func diffLines(a, b []string) []lineHunk {
+	d := newLineDiffer(a, b)
+	d.compare(0, len(d.ha1), 0, len(d.ha2), false)
+	compactChanges(d.a, d.ca, d.cb)
+	compactChanges(d.b, d.cb, d.ca)
+
+	var hunks []lineHunk
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		if i < len(a) && j < len(b) && !d.ca[i] && !d.cb[j] {
+			i++
+			j++
+			continue
+		}
+		si, sj := i, j
+		for i < len(a) && d.ca[i] {
+			i++
+		}
+		for j < len(b) && d.cb[j] {
+			j++
+		}
+		hunks = append(hunks, lineHunk{si, i - si, sj, j - sj})
+	}
+	return hunks
+}
+
+// Constants from xdiff's heuristics.
+const (
+	xdlMaxEqLimit     = 1024
+	xdlSimscanWindow  = 100
+	xdlKeepDiscardRun = 4
+	xdlMaxCostMin     = 256
+	xdlHeurMinCost    = 256
+	xdlSnakeCount     = 20
+	xdlKHeur          = 4
+	xdlLineMax        = int(^uint(0) >> 2)
+)
+
+// A lineDiffer marks the lines of a and b which aren't part of a short
+// edit script between them as changed in ca and cb.
+type lineDiffer struct {
+	// The lines of each file, identified by numbers which are the same
+	// for equal lines.
+	a, b   []int
+	ca, cb []bool
+
+	// The lines which may be unchanged, and their indexes in a and b.
+	// Lines which only appear in one file, and lines which appear too
+	// often among lines which only appear in one file, are marked as
+	// changed without being compared.
+	ha1, ha2         []int
+	rindex1, rindex2 []int
+
+	// The furthest reaching paths on each diagonal searching forwards
+	// and backwards, offset by kvOff.
+	kvdf, kvdb []int
+	kvOff      int
+
+	maxCost int
+}
+
+func newLineDiffer(a, b []string) *lineDiffer {
+	ids := make(map[string]int)
+	var count1, count2 []int
+	intern := func(lines []string, count *[]int) []int {
+		val := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+				count1 = append(count1, 0)
+				count2 = append(count2, 0)
+			}
+			(*count)[id]++
+			val[i] = id
+		}
+		return val
+	}
+	d := &lineDiffer{
+		a:  intern(a, &count1),
+		b:  intern(b, &count2),
+		ca: make([]bool, len(a)),
+		cb: make([]bool, len(b)),
+	}
+
+	// The common lines at the start and end are never changed.
+	dstart := 0
+	for dstart < len(a) && dstart < len(b) && d.a[dstart] == d.b[dstart] {
+		dstart++
+	}
+	tail := 0
+	for lim := min(len(a), len(b)) - dstart; tail < lim && d.a[len(a)-1-tail] == d.b[len(b)-1-tail]; tail++ {
+	}
+
+	classify := func(lines []int, other []int, changed []bool) (ha, rindex []int) {
+		mlim := min(bogoSqrt(len(lines)), xdlMaxEqLimit)
+		dend := len(lines) - tail - 1
+		dis := make([]byte, len(lines))
+		for i := dstart; i <= dend; i++ {
+			switch nm := other[lines[i]]; {
+			case nm == 0:
+				dis[i] = 0
+			case nm >= mlim:
+				dis[i] = 2
+			default:
+				dis[i] = 1
+			}
+		}
+		for i := dstart; i <= dend; i++ {
+			if dis[i] == 1 || dis[i] == 2 && !cleanMultiMatch(dis, i, dstart, dend) {
+				rindex = append(rindex, i)
+				ha = append(ha, lines[i])
+			} else {
+				changed[i] = true
+			}
+		}
+		return ha, rindex
+	}
+	d.ha1, d.rindex1 = classify(d.a, count2, d.ca)
+	d.ha2, d.rindex2 = classify(d.b, count1, d.cb)
+
+	ndiags := len(d.ha1) + len(d.ha2) + 3
+	d.kvdf = make([]int, ndiags)
+	d.kvdb = make([]int, ndiags)
+	d.kvOff = len(d.ha2) + 1
+	if d.maxCost = bogoSqrt(ndiags); d.maxCost < xdlMaxCostMin {
+		d.maxCost = xdlMaxCostMin
+	}
+	return d
+}
+
+// Returns an approximation of the square root of n.
+func bogoSqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+// Returns true if the line i, which has many matches in the other file,
+// should be discarded because it's in the middle of a run of lines which
+// mostly have no matches. dis is 0 for lines without matches, 1 for lines
+// with some and 2 for lines with many.
+func cleanMultiMatch(dis []byte, i, s, e int) bool {
+	if i-s > xdlSimscanWindow {
+		s = i - xdlSimscanWindow
+	}
+	if e-i > xdlSimscanWindow {
+		e = i + xdlSimscanWindow
+	}
+	rdis0, rpdis0 := 0, 1
+	for r := 1; i-r >= s; r++ {
+		if dis[i-r] == 0 {
+			rdis0++
+		} else if dis[i-r] == 2 {
+			rpdis0++
+		} else {
+			break
+		}
+	}
+	if rdis0 == 0 {
+		return false
+	}
+	rdis1, rpdis1 := 0, 1
+	for r := 1; i+r <= e; r++ {
+		if dis[i+r] == 0 {
+			rdis1++
+		} else if dis[i+r] == 2 {
+			rpdis1++
+		} else {
+			break
+		}
+	}
+	if rdis1 == 0 {
+		return false
+	}
+	rdis1 += rdis0
+	rpdis1 += rpdis0
+	return rpdis1*xdlKeepDiscardRun < rpdis1+rdis1
+}
+
+// Marks the changed lines between ha1[off1:lim1] and ha2[off2:lim2] by
+// splitting them on the shortest edit script, or a good enough one if it
+// would be too expensive to find, and recursing.
+func (d *lineDiffer) compare(off1, lim1, off2, lim2 int, needMin bool) {
+	for off1 < lim1 && off2 < lim2 && d.ha1[off1] == d.ha2[off2] {
+		off1++
+		off2++
+	}
+	for off1 < lim1 && off2 < lim2 && d.ha1[lim1-1] == d.ha2[lim2-1] {
+		lim1--
+		lim2--
+	}
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2++ {
+			d.cb[d.rindex2[off2]] = true
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1++ {
+			d.ca[d.rindex1[off1]] = true
+		}
+	default:
+		i1, i2, minLo, minHi := d.split(off1, lim1, off2, lim2, needMin)
+		d.compare(off1, i1, off2, i2, minLo)
+		d.compare(i1, lim1, i2, lim2, minHi)
+	}
+}
+
+// Returns the point to split ha1[off1:lim1] and ha2[off2:lim2] at, and
+// whether the halves need a minimal edit script, found by searching for
+// the middle of the shortest edit script from both ends. Unless needMin is
+// set, the search gives up on long scripts and uses the furthest reaching
+// or most promising paths instead, as xdiff does.
+func (d *lineDiffer) split(off1, lim1, off2, lim2 int, needMin bool) (int, int, bool, bool) {
+	ha1, ha2 := d.ha1, d.ha2
+	kvdf := func(k int) *int { return &d.kvdf[k+d.kvOff] }
+	kvdb := func(k int) *int { return &d.kvdb[k+d.kvOff] }
+
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+	*kvdf(fmid) = off1
+	*kvdb(bmid) = lim1
+
+	for ec := 1; ; ec++ {
+		gotSnake := false
+		if fmin > dmin {
+			fmin--
+			*kvdf(fmin - 1) = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			*kvdf(fmax + 1) = -1
+		} else {
+			fmax--
+		}
+		for k := fmax; k >= fmin; k -= 2 {
+			var i1 int
+			if *kvdf(k - 1) >= *kvdf(k + 1) {
+				i1 = *kvdf(k - 1) + 1
+			} else {
+				i1 = *kvdf(k + 1)
+			}
+			prev1 := i1
+			i2 := i1 - k
+			for i1 < lim1 && i2 < lim2 && ha1[i1] == ha2[i2] {
+				i1++
+				i2++
+			}
+			if i1-prev1 > xdlSnakeCount {
+				gotSnake = true
+			}
+			*kvdf(k) = i1
+			if odd && bmin <= k && k <= bmax && *kvdb(k) <= i1 {
+				return i1, i2, true, true
+			}
+		}
+
+		if bmin > dmin {
+			bmin--
+			*kvdb(bmin - 1) = xdlLineMax
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			*kvdb(bmax + 1) = xdlLineMax
+		} else {
+			bmax--
+		}
+		for k := bmax; k >= bmin; k -= 2 {
+			var i1 int
+			if *kvdb(k - 1) < *kvdb(k + 1) {
+				i1 = *kvdb(k - 1)
+			} else {
+				i1 = *kvdb(k + 1) - 1
+			}
+			prev1 := i1
+			i2 := i1 - k
+			for i1 > off1 && i2 > off2 && ha1[i1-1] == ha2[i2-1] {
+				i1--
+				i2--
+			}
+			if prev1-i1 > xdlSnakeCount {
+				gotSnake = true
+			}
+			*kvdb(k) = i1
+			if !odd && fmin <= k && k <= fmax && i1 <= *kvdf(k) {
+				return i1, i2, true, true
+			}
+		}
+
+		if needMin {
+			continue
+		}
+
+		// If the edit script is getting long, look for a diagonal
+		// which has reached far with a long snake at its end.
+		if gotSnake && ec > xdlHeurMinCost {
+			best, s1, s2 := 0, 0, 0
+			for k := fmax; k >= fmin; k -= 2 {
+				dd := k - fmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdf(k)
+				i2 := i1 - k
+				v := (i1 - off1) + (i2 - off2) - dd
+				if v > xdlKHeur*ec && v > best && off1+xdlSnakeCount <= i1 && i1 < lim1 && off2+xdlSnakeCount <= i2 && i2 < lim2 {
+					for n := 1; ha1[i1-n] == ha2[i2-n]; n++ {
+						if n == xdlSnakeCount {
+							best, s1, s2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return s1, s2, true, false
+			}
+			for k := bmax; k >= bmin; k -= 2 {
+				dd := k - bmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdb(k)
+				i2 := i1 - k
+				v := (lim1 - i1) + (lim2 - i2) - dd
+				if v > xdlKHeur*ec && v > best && off1 < i1 && i1 <= lim1-xdlSnakeCount && off2 < i2 && i2 <= lim2-xdlSnakeCount {
+					for n := 0; ha1[i1+n] == ha2[i2+n]; n++ {
+						if n == xdlSnakeCount-1 {
+							best, s1, s2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return s1, s2, false, true
+			}
+		}
+
+		// Give up and use the furthest reaching path.
+		if ec >= d.maxCost {
+			fbest, fbest1 := -1, -1
+			for k := fmax; k >= fmin; k -= 2 {
+				i1 := min(*kvdf(k), lim1)
+				i2 := i1 - k
+				if lim2 < i2 {
+					i1, i2 = lim2+k, lim2
+				}
+				if fbest < i1+i2 {
+					fbest, fbest1 = i1+i2, i1
+				}
+			}
+			bbest, bbest1 := xdlLineMax, xdlLineMax
+			for k := bmax; k >= bmin; k -= 2 {
+				i1 := max(off1, *kvdb(k))
+				i2 := i1 - k
+				if i2 < off2 {
+					i1, i2 = off2+k, off2
+				}
+				if i1+i2 < bbest {
+					bbest, bbest1 = i1+i2, i1
+				}
+			}
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return fbest1, fbest - fbest1, true, false
+			}
+			return bbest1, bbest - bbest1, false, true
+		}
+	}
+}
+
+// A lineGroup is a run of changed lines [start, end) in one file, which may
+// be empty.
+type lineGroup struct {
+	start, end int
+}
+
+// Returns the first group of changed lines, which may be empty.
+func firstLineGroup(changed []bool) lineGroup {
+	g := lineGroup{}
+	for g.end < len(changed) && changed[g.end] {
+		g.end++
+	}
+	return g
+}
+
+// Moves g to the next group, returning false if it's the last one.
+func (g *lineGroup) next(changed []bool) bool {
+	if g.end == len(changed) {
+		return false
+	}
+	g.start = g.end + 1
+	g.end = g.start
+	for g.end < len(changed) && changed[g.end] {
+		g.end++
+	}
+	return true
+}
+
+// Moves g to the previous group, returning false if it's the first one.
+func (g *lineGroup) previous(changed []bool) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	for g.start = g.end; g.start > 0 && changed[g.start-1]; g.start-- {
+	}
+	return true
+}
+
+// Shifts g down a line if the line after it is the same as its first line,
+// merging it with the following group if they meet.
+func (g *lineGroup) slideDown(lines []int, changed []bool) bool {
+	if g.end >= len(lines) || lines[g.start] != lines[g.end] {
+		return false
+	}
+	changed[g.start] = false
+	changed[g.end] = true
+	g.start++
+	for g.end++; g.end < len(changed) && changed[g.end]; g.end++ {
+	}
+	return true
+}
+
+// Shifts g up a line if the line before it is the same as its last line,
+// merging it with the preceding group if they meet.
+func (g *lineGroup) slideUp(lines []int, changed []bool) bool {
+	if g.start == 0 || lines[g.start-1] != lines[g.end-1] {
+		return false
+	}
+	g.start--
+	g.end--
+	changed[g.start] = true
+	changed[g.end] = false
+	for g.start > 0 && changed[g.start-1] {
+		g.start--
+	}
+	return true
+}
+
+// Slides each group of changed lines in one file to where it's most
+// intuitive, as xdiff does: lined up with a group of changed lines in the
+// other file if possible, and otherwise as far down as it can go. Groups
+// which meet are merged.
+func compactChanges(lines []int, changed []bool, otherChanged []bool) {
+	g := firstLineGroup(changed)
+	og := firstLineGroup(otherChanged)
+	for {
+		if g.end != g.start {
+			var earliestEnd int
+			endMatchingOther := -1
+			for {
+				size := g.end - g.start
+				endMatchingOther = -1
+				for g.slideUp(lines, changed) {
+					og.previous(otherChanged)
+				}
+				earliestEnd = g.end
+				if og.end > og.start {
+					endMatchingOther = g.end
+				}
+				for g.slideDown(lines, changed) {
+					og.next(otherChanged)
+					if og.end > og.start {
+						endMatchingOther = g.end
+					}
+				}
+				if size == g.end-g.start {
+					break
+				}
+			}
+			if g.end != earliestEnd && endMatchingOther != -1 {
+				for og.end == og.start {
+					g.slideUp(lines, changed)
+					og.previous(otherChanged)
+				}
+			}
+		}
+		if !g.next(changed) {
+			break
+		}
+		og.next(otherChanged)
+	}
+}
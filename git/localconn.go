@@ -25,7 +25,7 @@ var _ RemoteConn = &localConn{}
 func (s *localConn) OpenConn(srv GitService) error {
 	var cmd *exec.Cmd
 	log.Println("Connecting locally via", s.uri.Path)
-	if s.service == "" {
+	if s.service != "" {
 		cmd = exec.Command(s.service, s.uri.Path)
 	} else {
 		switch srv {
@@ -0,0 +1,219 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// LogDiffOptions control the diffs that log shows after each commit.
+type LogDiffOptions struct {
+	DiffCommonOptions
+
+	// How the changes made by merges are shown. The empty string, or
+	// "off", doesn't show them. "separate" compares the merge with each
+	// parent in turn, as -m does, "first-parent" only compares it with
+	// its first parent, and "combined" and "dense-combined" show the
+	// combined diffs of -c and --cc.
+	Merges string
+
+	// Only show the changes to files matching Paths, unless it's empty.
+	Paths []IndexPath
+}
+
+// Returns true if any of the diff output formats are enabled.
+func (opts LogDiffOptions) enabled() bool {
+	o := opts.DiffCommonOptions
+	return o.Patch || o.Raw || o.NameOnly || o.NameStatus || o.Stat || o.NumStat || o.ShortStat || o.Summary
+}
+
+// PrintWithDiff prints the commit id to w as Print does, followed by the
+// changes that it made in the formats enabled in opts. If opts.Pickaxe
+// is enabled, commits without any matching changes aren't printed, and
+// false is returned.
+func (p *PrettyPrinter) PrintWithDiff(w io.Writer, id CommitID, mark string, opts LogDiffOptions) (bool, error) {
+	pickaxe := opts.Pickaxe.enabled()
+	if !opts.enabled() && !pickaxe {
+		return true, p.Print(w, id, mark)
+	}
+	parents, err := id.Parents(p.c)
+	if err != nil {
+		return false, err
+	}
+	if len(parents) <= 1 {
+		diffs, err := opts.diffTree(p.c, id, nil, true)
+		if err != nil {
+			return false, err
+		}
+		if pickaxe && len(diffs) == 0 {
+			return false, nil
+		}
+		return true, p.printDiffs(w, id, mark, nil, opts, diffs)
+	}
+
+	switch opts.Merges {
+	case "separate":
+		shown := false
+		for i := range parents {
+			diffs, err := opts.diffTree(p.c, parents[i], id, false)
+			if err != nil {
+				return false, err
+			}
+			if len(diffs) == 0 {
+				continue
+			}
+			if err := p.printDiffs(w, id, mark, &parents[i], opts, diffs); err != nil {
+				return false, err
+			}
+			shown = true
+		}
+		if !shown && !pickaxe {
+			return true, p.Print(w, id, mark)
+		}
+		return shown, nil
+	case "first-parent":
+		diffs, err := opts.diffTree(p.c, parents[0], id, false)
+		if err != nil {
+			return false, err
+		}
+		if pickaxe && len(diffs) == 0 {
+			return false, nil
+		}
+		return true, p.printDiffs(w, id, mark, nil, opts, diffs)
+	case "combined", "dense-combined":
+		trees := make([]TreeID, len(parents))
+		for i, parent := range parents {
+			if trees[i], err = parent.TreeID(p.c); err != nil {
+				return false, err
+			}
+		}
+		tree, err := id.TreeID(p.c)
+		if err != nil {
+			return false, err
+		}
+		diffs, err := combinedDiffs(p.c, trees, tree, opts.Paths)
+		if err != nil {
+			return false, err
+		}
+		if diffs, err = pickaxeFilterCombined(p.c, opts.Pickaxe, diffs); err != nil {
+			return false, err
+		}
+		if pickaxe && len(diffs) == 0 {
+			return false, nil
+		}
+		firstParent, err := DiffTree(p.c, &DiffTreeOptions{Recurse: true}, trees[0], tree, nil)
+		if err != nil {
+			return false, err
+		}
+		if firstParent, err = pickaxeFilter(p.c, opts.Pickaxe, filterDiffs(firstParent, opts.Paths)); err != nil {
+			return false, err
+		}
+		if err := p.Print(w, id, mark); err != nil {
+			return false, err
+		}
+		// Unlike other diffs, the separator is always shown after
+		// the commit and it's never "---".
+		dw := p.diffWriter(w)
+		if p.format != "format" || p.userFormat != "" {
+			fmt.Fprintln(dw)
+		}
+		return true, writeCombinedDiff(p.c, dw, opts.DiffCommonOptions, opts.Merges == "dense-combined", diffs, firstParent)
+	}
+	// Merges which aren't diffed never match the pickaxe.
+	if pickaxe {
+		return false, nil
+	}
+	return true, p.Print(w, id, mark)
+}
+
+// Prints the commit id, compared with its parent from if it's not nil,
+// followed by diffs.
+func (p *PrettyPrinter) printDiffs(w io.Writer, id CommitID, mark string, from *CommitID, opts LogDiffOptions, diffs []HashDiff) error {
+	if err := p.print(w, id, mark, from); err != nil {
+		return err
+	}
+	if len(diffs) == 0 || !opts.enabled() {
+		return nil
+	}
+	dw := p.diffWriter(w)
+	p.writeDiffSeparator(dw, opts)
+	return GeneratePatch(p.c, opts.DiffCommonOptions, diffs, dw)
+}
+
+// Returns the writer that diffs after commits are written to, which puts
+// each line beside the graph if there is one.
+func (p *PrettyPrinter) diffWriter(w io.Writer) io.Writer {
+	if p.graph == nil {
+		return w
+	}
+	return &graphPaddingWriter{w: w, graph: p.graph, bol: true}
+}
+
+// A graphPaddingWriter writes lines beside padding lines of a graph.
+type graphPaddingWriter struct {
+	w     io.Writer
+	graph *Graph
+	// Whether the next byte written starts a line.
+	bol bool
+}
+
+func (pw *graphPaddingWriter) Write(buf []byte) (int, error) {
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(buf, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		if pw.bol {
+			out.WriteString(pw.graph.PaddingLine())
+		}
+		out.Write(line)
+		pw.bol = line[len(line)-1] == '\n'
+	}
+	if _, err := pw.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// Writes the line between a commit's message and its diff. As in git,
+// there's none after oneline or empty formats, and it's "---" if there's
+// both a diffstat and a patch.
+func (p *PrettyPrinter) writeDiffSeparator(w io.Writer, opts LogDiffOptions) {
+	if p.format == "oneline" || p.format == "format" && p.userFormat == "" {
+		return
+	}
+	if opts.Patch && opts.Stat {
+		fmt.Fprint(w, "---")
+	}
+	fmt.Fprintln(w)
+}
+
+// Returns the changes from t1 to t2 (or from the parent of t1, if t2 is
+// nil) to files matching opts.Paths and opts.Pickaxe. Renames are detected
+// before the pickaxe is applied, so pure renames don't match it.
+func (opts LogDiffOptions) diffTree(c *Client, t1, t2 Treeish, root bool) ([]HashDiff, error) {
+	paths := make([]string, len(opts.Paths))
+	for i, p := range opts.Paths {
+		paths[i] = p.String()
+	}
+	diffs, err := DiffTree(c, &DiffTreeOptions{DiffCommonOptions: opts.DiffCommonOptions, Recurse: true, Root: root}, t1, t2, paths)
+	if err != nil {
+		return nil, err
+	}
+	return pickaxeFilter(c, opts.Pickaxe, diffs)
+}
+
+// Returns the diffs for files matching paths, or all of them if paths is
+// empty.
+func filterDiffs(diffs []HashDiff, paths []IndexPath) []HashDiff {
+	if len(paths) == 0 {
+		return diffs
+	}
+	var val []HashDiff
+	for _, diff := range diffs {
+		if pathspecMatches(paths, diff.Name.String()) {
+			val = append(val, diff)
+		}
+	}
+	return val
+}
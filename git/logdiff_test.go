@@ -0,0 +1,170 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Creates a repository with a root commit adding a file, a child which
+// modifies it and adds another, a merge of the two which only keeps the
+// changes from the child, and a child of the merge which renames the added
+// file.
+func testLogDiffSetup(t *testing.T) (c *Client, dir string, first, second, merge, rename CommitID) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gitlogdiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := func(content string) Sha1 {
+		id, err := c.WriteObject("blob", []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	tree := func(files ...string) Sha1 {
+		var buf bytes.Buffer
+		for i := 0; i < len(files); i += 2 {
+			id := blob(files[i+1])
+			fmt.Fprintf(&buf, "100644 %v\x00", files[i])
+			buf.Write(id[:])
+		}
+		id, err := c.WriteObject("tree", buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	commit := func(tree Sha1, msg string, parents ...CommitID) CommitID {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "tree %v\n", tree)
+		for _, p := range parents {
+			fmt.Fprintf(&buf, "parent %v\n", p)
+		}
+		fmt.Fprintf(&buf, "author A <a@example.com> 1600000000 +0000\ncommitter A <a@example.com> 1600000000 +0000\n\n%v\n", msg)
+		id, err := c.WriteObject("commit", buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return CommitID(id)
+	}
+	first = commit(tree("a", "one\ntwo\n"), "first")
+	second = commit(tree("a", "one\n2\n", "b", "new\n"), "second", first)
+	merge = commit(tree("a", "one\n2\n", "b", "new\n"), "merge", second, first)
+	rename = commit(tree("a", "one\n2\n", "c", "new\n"), "rename", merge)
+	return
+}
+
+func TestPrintWithDiff(t *testing.T) {
+	c, dir, first, second, merge, rename := testLogDiffSetup(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		Id   CommitID
+		Opts LogDiffOptions
+		Want string
+	}{
+		{second, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{NameStatus: true}}, fmt.Sprintf("%.7v second\nM\ta\nA\tb\n", second)},
+		{second, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{NameOnly: true}, Paths: []IndexPath{"b"}}, fmt.Sprintf("%.7v second\nb\n", second)},
+		{first, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{NumStat: true}}, fmt.Sprintf("%.7v first\n2\t0\ta\n", first)},
+		{second, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{ShortStat: true}}, fmt.Sprintf("%.7v second\n 2 files changed, 2 insertions(+), 1 deletion(-)\n", second)},
+		{second, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{Patch: true, NumContextLines: 3}, Paths: []IndexPath{"a"}}, fmt.Sprintf(`%.7v second
+diff --git a/a b/a
+index 814f4a4..99b356d 100644
+--- a/a
++++ b/a
+@@ -1,2 +1,2 @@
+ one
+-two
++2
+`, second)},
+		// Merges aren't diffed unless asked to be.
+		{merge, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{NameStatus: true}}, fmt.Sprintf("%.7v merge\n", merge)},
+		{merge, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{NameStatus: true}, Merges: "first-parent"}, fmt.Sprintf("%.7v merge\n", merge)},
+		{merge, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{NameStatus: true}, Merges: "separate"}, fmt.Sprintf("%.7v (from %.7v) merge\nM\ta\nA\tb\n", merge, first)},
+		// Nothing in the merge differs from both parents.
+		{merge, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{NameStatus: true}, Merges: "combined"}, fmt.Sprintf("%.7v merge\n\n", merge)},
+		// Commits without changes matching the pickaxe aren't shown.
+		{second, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{Pickaxe: PickaxeOptions{String: "new"}}}, fmt.Sprintf("%.7v second\n", second)},
+		{first, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{Pickaxe: PickaxeOptions{String: "new"}}}, ""},
+		{second, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{NameOnly: true, Pickaxe: PickaxeOptions{Grep: "2"}}}, fmt.Sprintf("%.7v second\na\n", second)},
+		// Renames are only detected if asked to be, and pure renames
+		// don't match the pickaxe when they are.
+		{rename, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{NameStatus: true}}, fmt.Sprintf("%.7v rename\nD\tb\nA\tc\n", rename)},
+		{rename, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{NameStatus: true, DetectRenames: true}}, fmt.Sprintf("%.7v rename\nR100\tb\tc\n", rename)},
+		{rename, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{NameStatus: true, DetectRenames: true}, Paths: []IndexPath{"c"}}, fmt.Sprintf("%.7v rename\nA\tc\n", rename)},
+		{rename, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{Pickaxe: PickaxeOptions{String: "new"}}}, fmt.Sprintf("%.7v rename\n", rename)},
+		{rename, LogDiffOptions{DiffCommonOptions: DiffCommonOptions{DetectRenames: true, Pickaxe: PickaxeOptions{String: "new"}}}, ""},
+	}
+	for i, tc := range tests {
+		printer, err := NewPrettyPrinter(c, PrettyOptions{Format: "oneline", AbbrevCommit: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := printer.PrintWithDiff(&buf, tc.Id, "", tc.Opts); err != nil {
+			t.Errorf("Case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if got := buf.String(); got != tc.Want {
+			t.Errorf("Case %d: got %q want %q", i, got, tc.Want)
+		}
+	}
+}
+
+func TestWriteStat(t *testing.T) {
+	stats := []fileStat{
+		{Name: "a", Added: 10, Deleted: 5},
+		{Name: "dir/long-name", Added: 1},
+		{Name: "bin", Binary: true, Added: 20, Deleted: 10},
+	}
+	tests := []struct {
+		Opts DiffCommonOptions
+		Want string
+	}{
+		{DiffCommonOptions{StatWidth: 80}, ` a             |  15 ++++++++++-----
+ dir/long-name |   1 +
+ bin           | Bin 10 -> 20 bytes
+ 3 files changed, 11 insertions(+), 5 deletions(-)
+`},
+		{DiffCommonOptions{StatWidth: 80, StatCount: 1}, ` a | 15 ++++++++++-----
+ ...
+ 3 files changed, 11 insertions(+), 5 deletions(-)
+`},
+	}
+	for i, tc := range tests {
+		var buf bytes.Buffer
+		writeStat(&buf, stats, tc.Opts)
+		if got := buf.String(); got != tc.Want {
+			t.Errorf("Case %d: got\n%v\nwant\n%v", i, got, tc.Want)
+		}
+	}
+}
+
+func TestDecorationFilterMatches(t *testing.T) {
+	tests := []struct {
+		Name             string
+		Include, Exclude []string
+		Want             bool
+	}{
+		{"refs/heads/master", nil, nil, true},
+		{"refs/heads/master", []string{"refs/heads"}, nil, true},
+		{"refs/heads/master", []string{"refs/head"}, nil, false},
+		{"refs/tags/v1", []string{"refs/tags/v*"}, nil, true},
+		{"refs/tags/v1", []string{"refs/tags"}, []string{"refs/tags/v1"}, false},
+		{"HEAD", nil, []string{"HEAD"}, false},
+		{"HEAD", []string{"refs/heads/*"}, nil, false},
+	}
+	for i, tc := range tests {
+		if got := decorationFilterMatches(tc.Name, tc.Include, tc.Exclude); got != tc.Want {
+			t.Errorf("Case %d: got %v want %v", i, got, tc.Want)
+		}
+	}
+}
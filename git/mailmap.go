@@ -0,0 +1,200 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A Mailmap maps the names and email addresses in commits to the canonical
+// ones given by a .mailmap file.
+type Mailmap struct {
+	// The entries, keyed by the lower cased email address that they
+	// replace.
+	entries map[string]*mailmapEntry
+}
+
+// A mailmapEntry is the replacement name and email for an email address,
+// and the replacements for it when it's used with particular names.
+type mailmapEntry struct {
+	mailmapInfo
+
+	// Keyed by the lower cased name that's replaced.
+	names map[string]mailmapInfo
+}
+
+// The name and email replacing those in a commit. Either of them may be
+// empty if it's not replaced.
+type mailmapInfo struct {
+	Name, Email string
+}
+
+// ReadMailmap reads the mailmaps that git uses: the .mailmap file at the
+// top of the work tree, then the blob named by mailmap.blob (HEAD:.mailmap
+// by default in a bare repository), and then the file named by
+// mailmap.file. Entries from later mailmaps override earlier ones. It's
+// not an error if there's no mailmap, and the empty Mailmap which doesn't
+// change anything is returned.
+func ReadMailmap(c *Client) (*Mailmap, error) {
+	m := &Mailmap{entries: make(map[string]*mailmapEntry)}
+	bare := c.IsBare() || c.WorkDir == ""
+	if !bare {
+		if err := m.readFile(filepath.Join(c.WorkDir.String(), ".mailmap")); err != nil {
+			return nil, err
+		}
+	}
+	blob := c.GetConfig("mailmap.blob")
+	if blob == "" && bare {
+		blob = "HEAD:.mailmap"
+	}
+	if blob != "" {
+		if err := m.readBlob(c, blob); err != nil {
+			return nil, err
+		}
+	}
+	if file := c.GetConfig("mailmap.file"); file != "" {
+		if strings.HasPrefix(file, "~/") {
+			file = filepath.Join(os.Getenv("HOME"), file[2:])
+		}
+		if err := m.readFile(file); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Adds the entries in the file named filename to m. A missing file is
+// ignored.
+func (m *Mailmap) readFile(filename string) error {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return m.Parse(bytes.NewReader(buf))
+}
+
+// Adds the entries in the blob named by rev, such as HEAD:.mailmap, to m.
+// A missing blob is ignored.
+func (m *Mailmap) readBlob(c *Client, rev string) error {
+	id, err := RevParsePath(c, &RevParseOptions{}, rev)
+	if err != nil {
+		return nil
+	}
+	obj, err := c.GetObject(id)
+	if err != nil {
+		return err
+	}
+	if obj.GetType() != "blob" {
+		return nil
+	}
+	return m.Parse(bytes.NewReader(obj.GetContent()))
+}
+
+// Parse adds the entries read from r, in the format of a .mailmap file,
+// to m. Each line maps a commit email, optionally with a commit name, to
+// a proper name, a proper email, or both:
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+func (m *Mailmap) Parse(r io.Reader) error {
+	if m.entries == nil {
+		m.entries = make(map[string]*mailmapEntry)
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name1, email1, rest, ok := parseMailmapIdent(line, false)
+		if !ok {
+			continue
+		}
+		name2, email2, _, ok := parseMailmapIdent(rest, true)
+		if !ok {
+			m.add(name1, "", "", email1)
+			continue
+		}
+		m.add(name1, email1, name2, email2)
+	}
+	return scanner.Err()
+}
+
+// Parses the name and "<email>" at the start of line, returning the rest
+// of the line after them. The name may be empty, and the email may be too
+// if allowEmpty is set.
+func parseMailmapIdent(line string, allowEmpty bool) (name, email, rest string, ok bool) {
+	left := strings.IndexByte(line, '<')
+	if left < 0 {
+		return "", "", "", false
+	}
+	right := strings.IndexByte(line[left+1:], '>')
+	if right < 0 || right == 0 && !allowEmpty {
+		return "", "", "", false
+	}
+	right += left + 1
+	return strings.TrimSpace(line[:left]), line[left+1 : right], line[right+1:], true
+}
+
+// Adds a mapping from the commit email oldEmail, and the commit name
+// oldName if it's not empty, to newName and newEmail.
+func (m *Mailmap) add(newName, newEmail, oldName, oldEmail string) {
+	key := strings.ToLower(oldEmail)
+	e, ok := m.entries[key]
+	if !ok {
+		e = &mailmapEntry{}
+		m.entries[key] = e
+	}
+	if oldName == "" {
+		if newName != "" {
+			e.Name = newName
+		}
+		if newEmail != "" {
+			e.Email = newEmail
+		}
+		return
+	}
+	if e.names == nil {
+		e.names = make(map[string]mailmapInfo)
+	}
+	e.names[strings.ToLower(oldName)] = mailmapInfo{newName, newEmail}
+}
+
+// Map returns the canonical name and email for the name and email from a
+// commit. They're returned unchanged if m doesn't map them. Emails and
+// names are compared case insensitively.
+func (m *Mailmap) Map(name, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+	e, ok := m.entries[strings.ToLower(email)]
+	if !ok {
+		return name, email
+	}
+	info := e.mailmapInfo
+	if byName, ok := e.names[strings.ToLower(name)]; ok {
+		info = byName
+	}
+	if info.Name != "" {
+		name = info.Name
+	}
+	if info.Email != "" {
+		email = info.Email
+	}
+	return name, email
+}
+
+// MapPerson returns p with its name and email mapped by m.
+func (m *Mailmap) MapPerson(p Person) Person {
+	p.Name, p.Email = m.Map(p.Name, p.Email)
+	return p
+}
@@ -0,0 +1,74 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMailmap(t *testing.T) {
+	var m Mailmap
+	if err := m.Parse(strings.NewReader(`# A comment <ignored@example.com>
+Proper Name <commit@example.com>
+<proper@example.com> <old@example.com>
+Other Name <other@example.com> <both@example.com>
+Right Name <right@example.com> Wrong Name <shared@example.com>
+Not An Entry
+`)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Name, Email         string
+		WantName, WantEmail string
+	}{
+		{"Commit", "commit@example.com", "Proper Name", "commit@example.com"},
+		{"Commit", "COMMIT@example.com", "Proper Name", "COMMIT@example.com"},
+		{"Old", "old@example.com", "Old", "proper@example.com"},
+		{"Both", "both@example.com", "Other Name", "other@example.com"},
+		{"wrong name", "shared@example.com", "Right Name", "right@example.com"},
+		{"Someone Else", "shared@example.com", "Someone Else", "shared@example.com"},
+		{"Unmapped", "ignored@example.com", "Unmapped", "ignored@example.com"},
+	}
+	for i, tc := range tests {
+		name, email := m.Map(tc.Name, tc.Email)
+		if name != tc.WantName || email != tc.WantEmail {
+			t.Errorf("Case %d: got %v <%v> want %v <%v>", i, name, email, tc.WantName, tc.WantEmail)
+		}
+	}
+}
+
+func TestCheckMailmap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitmailmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".mailmap"), []byte("Work Tree <tree@example.com>\nOverridden <file@example.com>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "mailmap")
+	if err := ioutil.WriteFile(file, []byte("From File <file@example.com>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.SetCachedConfig("mailmap.file", file)
+
+	var buf bytes.Buffer
+	contacts := []string{"<tree@example.com>", "Someone <file@example.com>", "Unmapped <other@example.com>", "<>"}
+	if err := CheckMailmap(c, CheckMailmapOptions{}, contacts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "Work Tree <tree@example.com>\nFrom File <file@example.com>\nUnmapped <other@example.com>\n<>\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if err := CheckMailmap(c, CheckMailmapOptions{}, []string{"no email"}, &buf); err == nil {
+		t.Error("Expected an error for a contact without an email")
+	}
+}
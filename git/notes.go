@@ -0,0 +1,109 @@
+package git
+
+import (
+	"os"
+	"strings"
+)
+
+// notes are the notes attached to commits by a notes ref.
+type notes struct {
+	// The short name of the notes ref, used in the header when
+	// notes are displayed.
+	name string
+
+	// The blob containing the note for each object.
+	blobs map[Sha1]Sha1
+}
+
+// Returns the notes ref that notes are shown from by default.
+func defaultNotesRef(c *Client) Refname {
+	if ref := os.Getenv("GIT_NOTES_REF"); ref != "" {
+		return Refname(ref)
+	}
+	if ref := c.GetConfig("core.notesref"); ref != "" {
+		return Refname(ref)
+	}
+	return "refs/notes/commits"
+}
+
+// Loads the notes from the notes ref. A ref which doesn't exist has no
+// notes.
+func loadNotes(c *Client, ref Refname) (*notes, error) {
+	n := &notes{
+		name:  strings.TrimPrefix(strings.TrimPrefix(string(ref), "refs/"), "notes/"),
+		blobs: make(map[Sha1]Sha1),
+	}
+	id, err := RevParseCommit(c, &RevParseOptions{}, string(ref))
+	if err != nil {
+		return n, nil
+	}
+	tree, err := id.TreeID(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.loadTree(c, tree, ""); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Adds the notes from tree, which is the subdirectory prefix of the notes
+// tree. Notes trees may be fanned out into subdirectories named after the
+// leading characters of the annotated object's id.
+func (n *notes) loadTree(c *Client, tree TreeID, prefix string) error {
+	entries, err := treeEntries(c, tree)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := prefix + string(e.Name)
+		switch {
+		case e.FileMode == ModeTree && len(name) < 40:
+			if err := n.loadTree(c, TreeID(e.Sha1), name); err != nil {
+				return err
+			}
+		case len(name) == 40:
+			if id, err := Sha1FromString(name); err == nil {
+				n.blobs[id] = e.Sha1
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the note attached to id, or the empty string if there is none.
+func (n *notes) get(c *Client, id Sha1) (string, error) {
+	blob, ok := n.blobs[id]
+	if !ok {
+		return "", nil
+	}
+	obj, err := c.GetObject(blob)
+	if err != nil {
+		return "", err
+	}
+	return string(obj.GetContent()), nil
+}
+
+// Formats the note attached to id for display after the commit message, as
+// log does by default, or without any header or indentation if raw is set.
+// The empty string is returned if there is no note.
+func (n *notes) format(c *Client, id Sha1, raw bool) (string, error) {
+	note, err := n.get(c, id)
+	if err != nil || note == "" {
+		return "", err
+	}
+	var s strings.Builder
+	indent := ""
+	if !raw {
+		indent = "    "
+		if n.name == "commits" {
+			s.WriteString("\nNotes:\n")
+		} else {
+			s.WriteString("\nNotes (" + n.name + "):\n")
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(note, "\n"), "\n") {
+		s.WriteString(indent + line + "\n")
+	}
+	return s.String(), nil
+}
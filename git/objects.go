@@ -282,7 +282,11 @@ func (c *Client) getObject(sha1 Sha1, metaOnly bool) (GitObject, error) {
 		c.objcache[shaRef{sha1, metaOnly}] = gobj
 		return gobj, nil
 	} else {
-		objectname := filepath.Join(c.ObjectDir,
+		objdir := c.ObjectDir
+		if loc := c.objectCache[sha1]; loc.objdir != "" {
+			objdir = loc.objdir
+		}
+		objectname := filepath.Join(objdir,
 			fmt.Sprintf("%02x", sha1[0:1]),
 			fmt.Sprintf("%18x", sha1[1:]),
 		)
@@ -0,0 +1,148 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A packedRef is an entry in the packed-refs file.
+type packedRef struct {
+	Ref
+
+	// The object that an annotated tag peels to, if it was recorded
+	// in the packed-refs file.
+	Peeled *Sha1
+}
+
+// Reads the refs from the packed-refs file in c's GitDir. It is not an
+// error for the file to not exist, it just means there are no packed
+// refs.
+func readPackedRefs(c *Client) ([]packedRef, error) {
+	f, err := os.Open(c.GitDir.File("packed-refs").String())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var refs []packedRef
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "", line[0] == '#':
+			continue
+		case line[0] == '^':
+			if len(refs) == 0 {
+				return nil, fmt.Errorf("unexpected line in packed-refs: %v", line)
+			}
+			peeled, err := Sha1FromString(line[1:])
+			if err != nil {
+				return nil, err
+			}
+			refs[len(refs)-1].Peeled = &peeled
+		default:
+			sp := strings.IndexByte(line, ' ')
+			if sp < 0 {
+				return nil, fmt.Errorf("unexpected line in packed-refs: %v", line)
+			}
+			sha1, err := Sha1FromString(line[:sp])
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, packedRef{Ref: Ref{line[sp+1:], sha1}})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// Returns the packed ref named name, or nil if it's not in the packed-refs
+// file.
+func getPackedRef(c *Client, name string) (*packedRef, error) {
+	refs, err := readPackedRefs(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		if ref.Name == name {
+			return &ref, nil
+		}
+	}
+	return nil, nil
+}
+
+// Replaces the packed-refs file in c's GitDir with refs. If there are no
+// refs left, the file is removed.
+func writePackedRefs(c *Client, refs []packedRef) error {
+	file := c.GitDir.File("packed-refs").String()
+	if len(refs) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+
+	lock := file + ".lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# pack-refs with: peeled fully-peeled sorted \n")
+	for _, ref := range refs {
+		fmt.Fprintf(w, "%v %v\n", ref.Value, ref.Name)
+		if ref.Peeled != nil {
+			fmt.Fprintf(w, "^%v\n", *ref.Peeled)
+		}
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(lock)
+		return err
+	}
+	return os.Rename(lock, file)
+}
+
+// Removes the refs named names from the packed-refs file, if they're in it.
+func removePackedRefs(c *Client, names ...string) error {
+	refs, err := readPackedRefs(c)
+	if err != nil {
+		return err
+	}
+	remove := make(map[string]bool)
+	for _, name := range names {
+		remove[name] = true
+	}
+	var kept []packedRef
+	for _, ref := range refs {
+		if !remove[ref.Name] {
+			kept = append(kept, ref)
+		}
+	}
+	if len(kept) == len(refs) {
+		return nil
+	}
+	return writePackedRefs(c, kept)
+}
+
+// Returns true if the ref named name exists, either as a loose ref file
+// or in the packed-refs file.
+func refExists(c *Client, name string) bool {
+	if c.GitDir.File(File(name)).Exists() {
+		return true
+	}
+	ref, err := getPackedRef(c, name)
+	return err == nil && ref != nil
+}
@@ -152,6 +152,23 @@ func (v VariableLengthInt) WriteVariable(w io.Writer, typ PackEntryType) (int, e
 	return w.Write(b)
 }
 
+// Writes offset in the variable length format used for the base offset of
+// an OFS_DELTA, which is the inverse of ReadDeltaOffset. Unlike other
+// variable length integers in the pack format, it's big endian and adds 1
+// for each continuation byte, so that there's only one valid encoding for
+// each value.
+func WriteDeltaOffset(w io.Writer, offset uint64) (int, error) {
+	var buf [10]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(offset & 127)
+	for offset >>= 7; offset != 0; offset >>= 7 {
+		offset--
+		pos--
+		buf[pos] = byte(128 | (offset & 127))
+	}
+	return w.Write(buf[pos:])
+}
+
 // Reads a delta offset from the io.Reader, and returns both the value
 // and the list of bytes consumed from the reader.
 func ReadDeltaOffset(src flate.Reader) (uint64, []byte) {
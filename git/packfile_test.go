@@ -137,3 +137,20 @@ func TestPackfileUnpack(t *testing.T) {
 		runCase(fmt.Sprintf("Test %d", i), tc, t)
 	}
 }
+
+func TestDeltaOffset(t *testing.T) {
+	for _, offset := range []uint64{0, 1, 127, 128, 129, 16383, 16384, 16511, 16512, 1 << 20, 1<<32 + 5} {
+		var buf bytes.Buffer
+		n, err := WriteDeltaOffset(&buf, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, consumed := ReadDeltaOffset(&buf)
+		if got != offset {
+			t.Errorf("Unexpected offset: got %d want %d", got, offset)
+		}
+		if len(consumed) != n {
+			t.Errorf("Unexpected length for %d: wrote %d bytes, read %d", offset, n, len(consumed))
+		}
+	}
+}
@@ -47,6 +47,7 @@ func iteratePack(c *Client, r io.Reader, initcallback func(int), callback packIt
 	// if the reader is not a file, tee it into a temp file to resolve
 	// deltas from.
 	var pack *os.File
+	teed := false
 
 	counter := &byteCounter{r, 0}
 	if f, ok := r.(*os.File); ok && f != os.Stdin {
@@ -68,6 +69,7 @@ func iteratePack(c *Client, r io.Reader, initcallback func(int), callback packIt
 
 		// Only tee into the pack file if it's not a file
 		r = io.TeeReader(counter, pack)
+		teed = true
 	}
 
 	var p PackfileHeader
@@ -125,6 +127,14 @@ func iteratePack(c *Client, r io.Reader, initcallback func(int), callback packIt
 	if err := binary.Read(br, binary.BigEndian, &trailer.Packfile); err != nil {
 		return nil, err
 	}
+	if teed {
+		// The buffered reader may have read past the end of the pack
+		// into whatever the sender wrote after it, which shouldn't be
+		// in the packfile.
+		if err := pack.Truncate(loc + int64(len(trailer.Packfile))); err != nil {
+			return nil, err
+		}
+	}
 	if err := trailerCB(pack, int(p.Size), trailer.Packfile); err != nil {
 		return nil, err
 	}
@@ -22,6 +22,12 @@ type PackObjectsOptions struct {
 
 	// Use offset deltas instead of refdeltas when calculating delta
 	DeltaBaseOffset bool
+
+	// Objects that the receiver of the pack already has, keyed by the
+	// object that they should be tried as a delta base for. Since the
+	// bases aren't in the pack, deltas against them are always
+	// REF_DELTAs and the pack is thin.
+	ThinBases map[Sha1]Sha1
 }
 
 // Used for keeping track of the previous window objects to encode
@@ -66,10 +72,25 @@ func PackObjects(c *Client, opts PackObjectsOptions, w io.Writer, objects []Sha1
 		written := 0
 		var ref *packWindow
 
+		if base, ok := opts.ThinBases[obj]; ok {
+			if baseobj, err := c.GetObject(base); err == nil && baseobj.GetType() == objcontent.GetType() {
+				basebytes := baseobj.GetContent()
+				var newdelta bytes.Buffer
+				if err := delta.CalculateWithIndex(suffixarray.New(basebytes), &newdelta, basebytes, objbytes, len(best)/2); err == nil {
+					if d := newdelta.Bytes(); len(d) < len(best) {
+						best = d
+						otyp = OBJ_REF_DELTA
+						ref = &packWindow{oid: base, location: -1}
+					}
+				}
+			}
+		}
+
 		// We don't bother trying to calculate how close the object
 		// is, we just blindly calculate a delta and calculate the
 		// size.
-		for _, tryobj := range window {
+		for j := range window {
+			tryobj := &window[j]
 			basebytes := tryobj.cache
 			if tryobj.typ != otypreal {
 				continue
@@ -85,7 +106,7 @@ func PackObjects(c *Client, opts PackObjectsOptions, w io.Writer, objects []Sha1
 					} else {
 						otyp = OBJ_REF_DELTA
 					}
-					ref = &tryobj
+					ref = tryobj
 				}
 			} else {
 				log.Println(err)
@@ -101,11 +122,8 @@ func PackObjects(c *Client, opts PackObjectsOptions, w io.Writer, objects []Sha1
 		}
 
 		if ref != nil {
-			if opts.DeltaBaseOffset {
-				offset := pos - ref.location
-				var buf [128]byte
-				n := binary.PutUvarint(buf[:], uint64(offset))
-				n, err := w.Write(buf[:n])
+			if otyp == OBJ_OFS_DELTA {
+				n, err := WriteDeltaOffset(w, uint64(pos-ref.location))
 				if err != nil {
 					return Sha1{}, err
 				}
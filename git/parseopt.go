@@ -0,0 +1,446 @@
+package git
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// UsageShown is returned by RevParseParseOpt when the usage was printed,
+// either because it was asked for or because the arguments were invalid.
+// git exits with status 129 in this case.
+var UsageShown error = errors.New("usage shown")
+
+// An option described by the spec given to rev-parse --parseopt.
+type parseOptOption struct {
+	Short byte
+	Long  string
+	Help  string
+
+	// The hint for the argument in the usage.
+	ArgHint string
+
+	// The type of the option. A group header only has Help set.
+	Group                                bool
+	TakesArg, OptionalArg, NoNeg, Hidden bool
+}
+
+// Parses the spec for rev-parse --parseopt into the usage lines and the
+// options. The spec is the usage lines, a line with "--", and then one
+// option per line of the form
+//
+//	<opt-spec><flags>*<arg-hint>? SP+ help
+//
+// or a group header, starting with a space.
+func parseOptSpec(spec io.Reader) (usage []string, opts []parseOptOption, err error) {
+	scanner := bufio.NewScanner(spec)
+	for {
+		if !scanner.Scan() {
+			return nil, nil, fmt.Errorf("fatal: premature end of input")
+		}
+		line := scanner.Text()
+		if line == "--" {
+			if len(usage) == 0 {
+				return nil, nil, fmt.Errorf("fatal: no usage string given before the `--' separator")
+			}
+			break
+		}
+		usage = append(usage, line)
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		space := strings.IndexAny(line, " \t")
+		if space <= 0 {
+			opts = append(opts, parseOptOption{
+				Group: true,
+				Help:  strings.TrimLeft(line, " \t"),
+			})
+			continue
+		}
+		o := parseOptOption{Help: strings.TrimLeft(line[space+1:], " \t")}
+		optspec := line[:space]
+		flags := strings.IndexAny(optspec, "*=?!")
+		if flags < 0 {
+			flags = len(optspec)
+		}
+		if flags == 0 {
+			return nil, nil, fmt.Errorf("fatal: missing opt-spec before option flags")
+		}
+		switch name := optspec[:flags]; {
+		case len(name) == 1:
+			o.Short = name[0]
+		case name[1] != ',':
+			o.Long = name
+		default:
+			o.Short = name[0]
+			o.Long = name[2:]
+		}
+	flagloop:
+		for ; flags < len(optspec); flags++ {
+			switch optspec[flags] {
+			case '=':
+				o.TakesArg = true
+			case '?':
+				o.TakesArg = true
+				o.OptionalArg = true
+			case '!':
+				o.NoNeg = true
+			case '*':
+				o.Hidden = true
+			default:
+				break flagloop
+			}
+		}
+		o.ArgHint = optspec[flags:]
+		opts = append(opts, o)
+	}
+	return usage, opts, scanner.Err()
+}
+
+// Prints the usage for rev-parse --parseopt to w. If all is set, hidden
+// options are included.
+func parseOptUsage(w io.Writer, usage []string, opts []parseOptOption, all bool) {
+	fmt.Fprintf(w, "usage: %s\n", usage[0])
+	i := 1
+	for ; i < len(usage) && usage[i] != ""; i++ {
+		fmt.Fprintf(w, "   or: %s\n", usage[i])
+	}
+	for ; i < len(usage); i++ {
+		if usage[i] == "" {
+			fmt.Fprintln(w)
+		} else {
+			fmt.Fprintf(w, "    %s\n", usage[i])
+		}
+	}
+
+	needNewline := true
+	for _, o := range opts {
+		if o.Group {
+			fmt.Fprintln(w)
+			needNewline = false
+			if o.Help != "" {
+				fmt.Fprintln(w, o.Help)
+			}
+			continue
+		}
+		if o.Hidden && !all {
+			continue
+		}
+		if needNewline {
+			fmt.Fprintln(w)
+			needNewline = false
+		}
+		line := "    "
+		if o.Short != 0 {
+			line += "-" + string(o.Short)
+		}
+		if o.Short != 0 && o.Long != "" {
+			line += ", "
+		}
+		if o.Long != "" {
+			line += "--" + o.Long
+		}
+		if o.TakesArg {
+			hint := o.ArgHint
+			if hint == "" {
+				hint = "..."
+			} else if !strings.ContainsAny(hint, "()<>[]|") {
+				hint = "<" + hint + ">"
+			}
+			switch {
+			case o.OptionalArg && o.Long != "":
+				line += "[=" + hint + "]"
+			case o.OptionalArg:
+				line += "[" + hint + "]"
+			default:
+				line += " " + hint
+			}
+		}
+		// The help is aligned to the 26th column, or on the next line
+		// if the option doesn't fit.
+		if len(line) <= 24 {
+			line += strings.Repeat(" ", 24-len(line))
+		} else {
+			line += "\n" + strings.Repeat(" ", 24)
+		}
+		fmt.Fprintf(w, "%s  %s\n", line, o.Help)
+	}
+	fmt.Fprintln(w)
+}
+
+// The state of parsing the arguments for rev-parse --parseopt.
+type parseOptState struct {
+	opt  *RevParseOptions
+	opts []parseOptOption
+
+	// The arguments which haven't been parsed yet.
+	args []string
+
+	// The output, in the form of the arguments to "set".
+	parsed []string
+
+	// Errors which are printed before the usage.
+	stderr io.Writer
+}
+
+// Returns the name of the option o as used in error messages.
+func (p *parseOptState) optname(o parseOptOption, short, unset bool) string {
+	switch {
+	case short:
+		return fmt.Sprintf("switch `%c'", o.Short)
+	case unset:
+		return fmt.Sprintf("option `no-%s'", o.Long)
+	default:
+		return fmt.Sprintf("option `%s'", o.Long)
+	}
+}
+
+// Adds the option o to the output. value is the value that was attached
+// to the option (ie. the rest of the short option cluster or the value
+// after an "=" in a long option), if any. If the option takes an argument
+// which isn't attached, it's taken from the remaining arguments.
+func (p *parseOptState) add(o parseOptOption, value *string, short, unset bool) error {
+	if !short && value != nil && (!o.TakesArg || unset) {
+		return fmt.Errorf("error: %s takes no value", p.optname(o, short, unset))
+	}
+	if unset && o.NoNeg {
+		return fmt.Errorf("error: %s isn't available", p.optname(o, short, unset))
+	}
+	switch {
+	case unset:
+		p.parsed = append(p.parsed, "--no-"+o.Long)
+	case o.Short != 0 && (o.Long == "" || !p.opt.StuckLong):
+		p.parsed = append(p.parsed, "-"+string(o.Short))
+	default:
+		p.parsed = append(p.parsed, "--"+o.Long)
+	}
+	if unset || !o.TakesArg || (o.OptionalArg && value == nil) {
+		return nil
+	}
+	if value == nil {
+		if len(p.args) == 0 {
+			return fmt.Errorf("error: %s requires a value", p.optname(o, short, unset))
+		}
+		value = &p.args[0]
+		p.args = p.args[1:]
+	}
+	if !p.opt.StuckLong {
+		p.parsed = append(p.parsed, shellQuote(*value))
+		return nil
+	}
+	sep := ""
+	if o.Long != "" {
+		sep = "="
+	}
+	p.parsed[len(p.parsed)-1] += sep + shellQuote(*value)
+	return nil
+}
+
+// Errors for options which look like long options that were given with
+// a single dash.
+func (p *parseOptState) checkTypos(arg string) error {
+	if len(arg) < 3 {
+		return nil
+	}
+	if strings.HasPrefix(arg, "no-") {
+		return fmt.Errorf("error: did you mean `--%s` (with two dashes)?", arg)
+	}
+	for _, o := range p.opts {
+		if o.Long != "" && strings.HasPrefix(o.Long, arg) {
+			return fmt.Errorf("error: did you mean `--%s` (with two dashes)?", arg)
+		}
+	}
+	return nil
+}
+
+// Parses a cluster of short options, without the leading dash. Returns
+// the option that was unknown, if any.
+func (p *parseOptState) parseShort(cluster string) (unknown byte, err error) {
+	for first := true; cluster != ""; first = false {
+		var o *parseOptOption
+		for i := range p.opts {
+			if !p.opts[i].Group && p.opts[i].Short == cluster[0] {
+				o = &p.opts[i]
+				break
+			}
+		}
+		if o == nil {
+			if first {
+				if err := p.checkTypos(cluster); err != nil {
+					return 0, err
+				}
+			}
+			return cluster[0], nil
+		}
+		var value *string
+		if rest := cluster[1:]; rest != "" {
+			value = &rest
+		}
+		if err := p.add(*o, value, true, false); err != nil {
+			return 0, err
+		}
+		if o.TakesArg {
+			break
+		}
+		// A cluster of options which don't take arguments might have
+		// been meant to be a long option.
+		if first && len(cluster) > 1 {
+			if err := p.checkTypos(cluster); err != nil {
+				return 0, err
+			}
+		}
+		cluster = cluster[1:]
+	}
+	return 0, nil
+}
+
+// Parses a long option, without the leading dashes. Returns false if the
+// option is unknown. Long options may be abbreviated to any unambiguous
+// prefix, and negated with "no-" unless they have the "!" flag.
+func (p *parseOptState) parseLong(arg string) (known bool, err error) {
+	name := arg
+	var value *string
+	if eq := strings.IndexByte(arg, '='); eq >= 0 {
+		name = arg[:eq]
+		v := arg[eq+1:]
+		value = &v
+	}
+
+	var abbrev, ambiguous *parseOptOption
+	var abbrevUnset, ambiguousUnset bool
+	for i := range p.opts {
+		o := &p.opts[i]
+		if o.Group || o.Long == "" {
+			continue
+		}
+		if name == o.Long {
+			return true, p.add(*o, value, false, false)
+		}
+		unset := false
+		if negated := strings.TrimPrefix(name, "no-"); negated != name && !o.NoNeg {
+			if negated == o.Long {
+				return true, p.add(*o, value, false, true)
+			}
+			if strings.HasPrefix(o.Long, negated) {
+				unset = true
+			}
+		} else if strings.HasPrefix("no-", name) && !o.NoNeg {
+			// "--no" or "--no-" is an abbreviation of every
+			// negated option.
+			unset = true
+		}
+		if !unset && !strings.HasPrefix(o.Long, name) {
+			continue
+		}
+		if abbrev != nil {
+			ambiguous, ambiguousUnset = abbrev, abbrevUnset
+		}
+		abbrev, abbrevUnset = o, unset
+	}
+	if ambiguous != nil {
+		no := func(unset bool) string {
+			if unset {
+				return "no-"
+			}
+			return ""
+		}
+		fmt.Fprintf(p.stderr, "error: ambiguous option: %s (could be --%s%s or --%s%s)\n",
+			arg, no(ambiguousUnset), ambiguous.Long, no(abbrevUnset), abbrev.Long)
+		return true, UsageShown
+	}
+	if abbrev != nil {
+		return true, p.add(*abbrev, value, false, abbrevUnset)
+	}
+	return false, nil
+}
+
+// Implements "git rev-parse --parseopt". The option spec is read from spec,
+// and the "set -- ..." command with args normalized is written to stdout.
+// If the arguments are invalid or help was requested, the usage is printed
+// and UsageShown is returned.
+func RevParseParseOpt(opt RevParseOptions, spec io.Reader, args []string, stdout, stderr io.Writer) error {
+	usage, opts, err := parseOptSpec(spec)
+	if err != nil {
+		return err
+	}
+	p := &parseOptState{opt: &opt, opts: opts, args: args, stderr: stderr}
+	// Prints the usage for -h or --help. Since the output is meant to be
+	// evaluated by the shell, it's wrapped in a command which prints it.
+	help := func(all bool) error {
+		fmt.Fprint(stdout, "cat <<\\EOF\n")
+		parseOptUsage(stdout, usage, opts, all)
+		fmt.Fprint(stdout, "EOF\n")
+		return UsageShown
+	}
+	// Prints an error and the usage for invalid options.
+	invalid := func(err error) error {
+		fmt.Fprintln(stderr, err)
+		parseOptUsage(stderr, usage, opts, false)
+		return UsageShown
+	}
+
+	var nonopts []string
+	total := len(args)
+parse:
+	for len(p.args) > 0 {
+		arg := p.args[0]
+		p.args = p.args[1:]
+		switch {
+		case arg == "-" || !strings.HasPrefix(arg, "-"):
+			if opt.StopAtNonOption {
+				p.args = append([]string{arg}, p.args...)
+				break parse
+			}
+			nonopts = append(nonopts, arg)
+		case arg == "-h" && total == 1:
+			return help(false)
+		case arg == "--":
+			if opt.KeepDashDash {
+				p.args = append([]string{arg}, p.args...)
+			}
+			break parse
+		case arg == "--end-of-options":
+			break parse
+		case arg == "--help-all":
+			return help(true)
+		case arg == "--help":
+			return help(false)
+		case !strings.HasPrefix(arg, "--"):
+			unknown, err := p.parseShort(arg[1:])
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return UsageShown
+			}
+			if unknown == 'h' {
+				return help(false)
+			}
+			if unknown != 0 {
+				return invalid(fmt.Errorf("error: unknown switch `%c'", unknown))
+			}
+		default:
+			known, err := p.parseLong(arg[2:])
+			if err == UsageShown {
+				return help(false)
+			} else if err != nil {
+				fmt.Fprintln(stderr, err)
+				return UsageShown
+			}
+			if !known {
+				return invalid(fmt.Errorf("error: unknown option `%s'", arg[2:]))
+			}
+		}
+	}
+
+	out := append([]string{"set", "--"}, p.parsed...)
+	out = append(out, "--")
+	for _, arg := range append(nonopts, p.args...) {
+		out = append(out, shellQuote(arg))
+	}
+	fmt.Fprintln(stdout, strings.Join(out, " "))
+	return nil
+}
@@ -0,0 +1,116 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testParseOptSpec = `some-command [<options>] <args>...
+
+some-command does foo and bar!
+--
+h,help    show the help
+
+foo       some nifty option --foo
+bar=      some cool option --bar with an argument
+baz=arg   another cool option --baz with a named argument
+qux?path  qux may take a path argument but has meaning by itself
+
+  An option group Header
+C?        option C with an optional argument
+f,force!  no negation
+S*        hidden option
+`
+
+func TestRevParseParseOpt(t *testing.T) {
+	tests := []struct {
+		Opts    RevParseOptions
+		Args    []string
+		Want    string
+		WantErr error
+	}{
+		{
+			RevParseOptions{},
+			[]string{"--foo", "--bar", "x", "-C", "-Cy", "--baz", "z", "-S", "--no-foo", "arg1", "--", "rest", "it's"},
+			`set -- --foo --bar 'x' -C -C 'y' --baz 'z' -S --no-foo -- 'arg1' 'rest' 'it'\''s'` + "\n",
+			nil,
+		},
+		{
+			RevParseOptions{StuckLong: true},
+			[]string{"--bar", "x", "-Cy", "-f", "--qux", "--qux=p"},
+			`set -- --bar='x' -C'y' --force --qux --qux='p' --` + "\n",
+			nil,
+		},
+		{
+			RevParseOptions{KeepDashDash: true},
+			[]string{"--foo", "--", "a"},
+			`set -- --foo -- '--' 'a'` + "\n",
+			nil,
+		},
+		{
+			RevParseOptions{StopAtNonOption: true},
+			[]string{"a", "--foo"},
+			`set -- -- 'a' '--foo'` + "\n",
+			nil,
+		},
+		// Unambiguous abbreviations are expanded.
+		{RevParseOptions{}, []string{"--for"}, "set -- -f --\n", nil},
+		{RevParseOptions{}, []string{"a!b"}, `set -- -- 'a'\!'b'` + "\n", nil},
+		{RevParseOptions{}, []string{"--fo"}, "", UsageShown},
+		{RevParseOptions{}, []string{"--no-force"}, "", UsageShown},
+		{RevParseOptions{}, []string{"--nope"}, "", UsageShown},
+		{RevParseOptions{}, []string{"--bar"}, "", UsageShown},
+	}
+	for i, test := range tests {
+		var stdout, stderr bytes.Buffer
+		err := RevParseParseOpt(test.Opts, strings.NewReader(testParseOptSpec), test.Args, &stdout, &stderr)
+		if err != test.WantErr {
+			t.Errorf("Test %d: got error %v want %v", i, err, test.WantErr)
+		}
+		if test.WantErr == nil && stdout.String() != test.Want {
+			t.Errorf("Test %d: got %q want %q", i, stdout.String(), test.Want)
+		}
+	}
+}
+
+func TestRevParseParseOptUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	err := RevParseParseOpt(RevParseOptions{}, strings.NewReader(testParseOptSpec), []string{"-h"}, &stdout, &stderr)
+	if err != UsageShown {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	want := `cat <<\EOF
+usage: some-command [<options>] <args>...
+
+    some-command does foo and bar!
+
+    -h, --help            show the help
+    --foo                 some nifty option --foo
+    --bar ...             some cool option --bar with an argument
+    --baz <arg>           another cool option --baz with a named argument
+    --qux[=<path>]        qux may take a path argument but has meaning by itself
+
+An option group Header
+    -C[...]               option C with an optional argument
+    -f, --force           no negation
+
+EOF
+`
+	if got := stdout.String(); got != want {
+		t.Errorf("Unexpected usage: got %q want %q", got, want)
+	}
+
+	// Unknown options print the usage to stderr, without wrapping it
+	// for the shell.
+	stdout.Reset()
+	if err := RevParseParseOpt(RevParseOptions{}, strings.NewReader(testParseOptSpec), []string{"-k"}, &stdout, &stderr); err != UsageShown {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("Unexpected output for invalid option: %q", stdout.String())
+	}
+	if got := stderr.String(); !strings.HasPrefix(got, "error: unknown switch `k'\nusage: some-command") {
+		t.Errorf("Unexpected error output: %q", got)
+	}
+}
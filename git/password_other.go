@@ -9,10 +9,15 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 )
 
-func getUserPassword(url string) (userPasswd, error) {
-	user := readLine("Username: ")
+// Prompts for a username and password on the terminal. If user is not
+// empty, it's used as the username without prompting.
+func getUserPassword(url, user string) (userPasswd, error) {
+	if user == "" {
+		user = readLine(fmt.Sprintf("Username for '%s': ", url))
+	}
 
-	fmt.Fprintf(os.Stderr, "Password: ")
+	fmt.Fprintf(os.Stderr, "Password for '%s': ", url)
 	pwb, err := terminal.ReadPassword(0)
+	fmt.Fprintln(os.Stderr)
 	return userPasswd{user, string(pwb)}, err
 }
@@ -4,11 +4,16 @@ import (
 	"bitbucket.org/mischief/libauth"
 )
 
-func getUserPassword(url string) (userPasswd, error) {
-	val, err := libauth.Getuserpasswd(
-		"proto=pass service=git role=client server=%s",
-		url,
-	)
+// Gets a username and password from factotum. If user is not empty, it's
+// used to select the key.
+func getUserPassword(url, user string) (userPasswd, error) {
+	params := "proto=pass service=git role=client server=%s"
+	args := []interface{}{url}
+	if user != "" {
+		params += " user=%s"
+		args = append(args, user)
+	}
+	val, err := libauth.Getuserpasswd(params, args...)
 	if err != nil {
 		return userPasswd{}, err
 	}
@@ -0,0 +1,176 @@
+package git
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// PickaxeOptions select the changes which add or remove a string, as the
+// -S and -G options of diff and log do.
+type PickaxeOptions struct {
+	// Only show the files where the number of occurrences of String
+	// changed. String is a regular expression if Regex is set.
+	String string
+	Regex  bool
+
+	// Only show the files with added or removed lines matching the
+	// regular expression Grep.
+	Grep string
+
+	// Match String and Grep case insensitively.
+	IgnoreCase bool
+
+	// Show all of the files if any of them match, not only the ones
+	// which match.
+	All bool
+}
+
+// Returns true if either -S or -G was given.
+func (o PickaxeOptions) enabled() bool {
+	return o.String != "" || o.Grep != ""
+}
+
+// Returns the function which tells whether a file's contents changed from
+// src to dst in the way that o is looking for. As in git, "^" and "$" in
+// the regular expressions match at the start and end of each line.
+func (o PickaxeOptions) compile() (func(src, dst []byte) (bool, error), error) {
+	if o.Grep != "" {
+		re, err := o.compileRegex(o.Grep)
+		if err != nil {
+			return nil, err
+		}
+		return func(src, dst []byte) (bool, error) {
+			patch, err := externalDiff(src, dst, "a", "b", 0)
+			if err != nil {
+				return false, err
+			}
+			return changedLinesMatch(patch, re), nil
+		}, nil
+	}
+	if o.Regex {
+		re, err := o.compileRegex(o.String)
+		if err != nil {
+			return nil, err
+		}
+		return func(src, dst []byte) (bool, error) {
+			return len(re.FindAllIndex(src, -1)) != len(re.FindAllIndex(dst, -1)), nil
+		}, nil
+	}
+	needle := []byte(o.String)
+	if o.IgnoreCase {
+		needle = bytes.ToLower(needle)
+	}
+	return func(src, dst []byte) (bool, error) {
+		if o.IgnoreCase {
+			src, dst = bytes.ToLower(src), bytes.ToLower(dst)
+		}
+		return bytes.Count(src, needle) != bytes.Count(dst, needle), nil
+	}, nil
+}
+
+// Compiles the regular expression expr with the flags given by o.
+func (o PickaxeOptions) compileRegex(expr string) (*regexp.Regexp, error) {
+	// The expression is checked by itself first so that errors don't
+	// mention the flags.
+	if _, err := regexp.Compile(expr); err != nil {
+		return nil, err
+	}
+	if o.IgnoreCase {
+		return regexp.Compile("(?mi)" + expr)
+	}
+	return regexp.Compile("(?m)" + expr)
+}
+
+// Validate returns an error if the regular expressions in o aren't valid.
+func (o PickaxeOptions) Validate() error {
+	_, err := o.compile()
+	return err
+}
+
+// Returns true if any line added or removed by the unified diff patch
+// matches re.
+func changedLinesMatch(patch string, re *regexp.Regexp) bool {
+	inHunk := false
+	for _, line := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+		case !inHunk:
+		case strings.HasPrefix(line, "+"), strings.HasPrefix(line, "-"):
+			if re.MatchString(line[1:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the diffs which match the pickaxe options o. If o.All is set,
+// all of the diffs are returned if any of them match. Binary files never
+// match.
+func pickaxeFilter(c *Client, o PickaxeOptions, diffs []HashDiff) ([]HashDiff, error) {
+	if !o.enabled() {
+		return diffs, nil
+	}
+	matches, err := o.compile()
+	if err != nil {
+		return nil, err
+	}
+	var val []HashDiff
+	for _, diff := range diffs {
+		srcSha, src, err := diff.contents(c, diff.Src)
+		if err != nil {
+			return nil, err
+		}
+		dstSha, dst, err := diff.contents(c, diff.Dst)
+		if err != nil {
+			return nil, err
+		}
+		if srcSha == dstSha || isBinary(src) || isBinary(dst) {
+			continue
+		}
+		ok, err := matches(src, dst)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if o.All {
+			return diffs, nil
+		}
+		val = append(val, diff)
+	}
+	return val, nil
+}
+
+// Returns the combined diffs where the changes from every parent match the
+// pickaxe options o. If o.All is set, all of the diffs are returned if any
+// of them match.
+func pickaxeFilterCombined(c *Client, o PickaxeOptions, diffs []combinedDiff) ([]combinedDiff, error) {
+	if !o.enabled() {
+		return diffs, nil
+	}
+	each := o
+	each.All = false
+	var val []combinedDiff
+	for _, d := range diffs {
+		pairs := make([]HashDiff, len(d.Parents))
+		for i, parent := range d.Parents {
+			pairs[i] = HashDiff{Name: d.Name, Src: parent, Dst: d.Result}
+		}
+		matched, err := pickaxeFilter(c, each, pairs)
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) != len(pairs) {
+			continue
+		}
+		if o.All {
+			return diffs, nil
+		}
+		val = append(val, d)
+	}
+	return val, nil
+}
@@ -0,0 +1,47 @@
+package git
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPickaxeFilter(t *testing.T) {
+	c, dir, _, second, _, _ := testLogDiffSetup(t)
+	defer os.RemoveAll(dir)
+
+	diffs, err := DiffTree(c, &DiffTreeOptions{Recurse: true, Root: true}, second, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Opts PickaxeOptions
+		Want []IndexPath
+	}{
+		{PickaxeOptions{String: "two"}, []IndexPath{"a"}},
+		// The number of occurrences of "one" didn't change.
+		{PickaxeOptions{String: "one"}, nil},
+		{PickaxeOptions{String: "TWO", IgnoreCase: true}, []IndexPath{"a"}},
+		{PickaxeOptions{String: "n.w"}, nil},
+		{PickaxeOptions{String: "n.w", Regex: true}, []IndexPath{"b"}},
+		{PickaxeOptions{Grep: "^2$"}, []IndexPath{"a"}},
+		{PickaxeOptions{Grep: "ne"}, []IndexPath{"b"}},
+		{PickaxeOptions{Grep: "ne", All: true}, []IndexPath{"a", "b"}},
+	}
+	for i, tc := range tests {
+		got, err := pickaxeFilter(c, tc.Opts, diffs)
+		if err != nil {
+			t.Errorf("Case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if len(got) != len(tc.Want) {
+			t.Errorf("Case %d: got %v want %v", i, got, tc.Want)
+			continue
+		}
+		for j := range got {
+			if got[j].Name != tc.Want[j] {
+				t.Errorf("Case %d: got %v want %v", i, got, tc.Want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A pktLineWriter writes lines in the pkt-line format used by the git
+// protocol to an underlying writer.
+type pktLineWriter struct {
+	w io.Writer
+}
+
+// Printf writes a single formatted pkt-line.
+func (p pktLineWriter) Printf(format string, args ...interface{}) error {
+	l, err := PktLineEncodeNoNl([]byte(fmt.Sprintf(format, args...)))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(p.w, l.String())
+	return err
+}
+
+// Flush writes a flush packet.
+func (p pktLineWriter) Flush() error {
+	_, err := io.WriteString(p.w, "0000")
+	return err
+}
+
+// Delim writes a protocol v2 delimiter packet.
+func (p pktLineWriter) Delim() error {
+	_, err := io.WriteString(p.w, "0001")
+	return err
+}
+
+// Returns a packProtocolReader which decodes pkt-lines from r, for use
+// by the server side of the protocol.
+func newPktLineReader(r io.Reader) *packProtocolReader {
+	return &packProtocolReader{conn: bufio.NewReader(r), state: PktLineMode}
+}
+
+// Reads the next pkt-line from r and returns it with any trailing newline
+// removed. Flush and delimiter packets are returned as the errors flushPkt
+// and delimPkt.
+func readPktLine(r *packProtocolReader) (string, error) {
+	buf := make([]byte, 65520)
+	n, err := r.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(buf[:n]), "\n"), nil
+}
+
+// A sidebandWriter multiplexes data written to it onto a side-band
+// channel, for sending a pack with side-band or side-band-64k.
+type sidebandWriter struct {
+	w       io.Writer
+	channel byte
+
+	// The maximum size of a packet, including the length and channel.
+	max int
+}
+
+func (s sidebandWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		n := len(data)
+		if n > s.max-5 {
+			n = s.max - 5
+		}
+		if _, err := fmt.Fprintf(s.w, "%04x%c", n+5, s.channel); err != nil {
+			return written, err
+		}
+		if _, err := s.w.Write(data[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		data = data[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,1606 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// PrettyOptions control how log and show print commits.
+type PrettyOptions struct {
+	// The argument to --pretty or --format. This may be the name of a
+	// built-in format, "format:" or "tformat:" followed by a format
+	// string, a format string on its own, or a pretty.<name> alias
+	// from the config. The empty string is the medium format.
+	Format string
+
+	// How dates are shown. The zero value is git's default format,
+	// except in the reference format which shows short dates unless
+	// another mode is set.
+	Date DateMode
+
+	// Abbreviate commit ids in the header of built-in formats. Abbrev
+	// is the minimum length of abbreviated ids, or 7 if it's 0.
+	AbbrevCommit bool
+	Abbrev       int
+
+	// Use colours in the output.
+	Color bool
+
+	// How to decorate commits with the refs that point to them in
+	// built-in formats: "short" or "full", or "" for no decorations.
+	// This also decides whether %d and %D use full ref names.
+	Decorate string
+
+	// Only decorate commits with the refs matching one of the patterns
+	// in DecorateRefs, if it's not empty, and never with those matching
+	// DecorateRefsExclude.
+	DecorateRefs, DecorateRefsExclude []string
+
+	// Whether to show notes. If nil, notes are shown after the message
+	// when the format is the default and by the %N placeholder.
+	ShowNotes *bool
+
+	// Map the author and committer of built-in formats with the
+	// mailmap. The %aN, %aE and %aL placeholders and their committer
+	// equivalents are always mapped.
+	UseMailmap bool
+}
+
+// The built-in formats, in the order that git checks them when resolving
+// abbreviated format names.
+var builtinPrettyFormats = []string{"raw", "medium", "short", "email", "mboxrd", "fuller", "full", "oneline", "reference"}
+
+// The format string used for the reference format.
+const referenceFormat = "%C(auto)%h (%s, %ad)"
+
+// A PrettyPrinter prints commits in the format given by its PrettyOptions,
+// including the separators between them.
+type PrettyPrinter struct {
+	c    *Client
+	opts PrettyOptions
+
+	// The name of the built-in format, or "format" for format strings.
+	format string
+
+	// The format string for user formats.
+	userFormat string
+
+	// Whether each commit is terminated with a newline, rather than
+	// commits being separated by newlines.
+	terminator bool
+
+	// The number of commits printed.
+	shown int
+
+	now         time.Time
+	decorations *decorations
+	notes       *notes
+
+	// The mailmap, which is read the first time that it's needed.
+	mailmap *Mailmap
+
+	// The history graph that commits are drawn beside, if any, and
+	// whether the last commit printed didn't end in a newline.
+	graph          *Graph
+	missingNewline bool
+}
+
+// NewPrettyPrinter returns a PrettyPrinter which prints commits from the
+// repository of c according to opts.
+func NewPrettyPrinter(c *Client, opts PrettyOptions) (*PrettyPrinter, error) {
+	p := &PrettyPrinter{c: c, opts: opts, now: time.Now()}
+	format := opts.Format
+	switch {
+	case format == "":
+		p.format = "medium"
+	case strings.HasPrefix(format, "format:"):
+		p.format, p.userFormat = "format", format[7:]
+	case strings.HasPrefix(format, "tformat:"):
+		p.format, p.userFormat, p.terminator = "format", format[8:], true
+	case strings.Contains(format, "%"):
+		p.format, p.userFormat, p.terminator = "format", format, true
+	default:
+		name, user, terminator, err := findPrettyFormat(c, format, format, 0)
+		if err != nil {
+			return nil, err
+		}
+		p.format, p.userFormat, p.terminator = name, user, terminator
+	}
+	switch p.format {
+	case "oneline":
+		p.terminator = true
+	case "reference":
+		p.format, p.userFormat, p.terminator = "format", referenceFormat, true
+		if p.opts.Date.Type == "" {
+			p.opts.Date.Type = "short"
+		}
+	}
+	return p, nil
+}
+
+// Finds the built-in format or pretty.<name> alias that name is an
+// unambiguous prefix of. Aliases may refer to other aliases, up to depth
+// redirections.
+func findPrettyFormat(c *Client, name, original string, depth int) (format, user string, terminator bool, err error) {
+	type candidate struct{ name, value string }
+	var candidates []candidate
+	for _, f := range builtinPrettyFormats {
+		candidates = append(candidates, candidate{f, ""})
+	}
+	for _, sec := range c.configSections("pretty") {
+		for _, e := range sec.entries {
+			builtin := false
+			for _, f := range builtinPrettyFormats {
+				builtin = builtin || f == e.key
+			}
+			if !builtin {
+				candidates = append(candidates, candidate{e.key, e.value})
+			}
+		}
+	}
+	if depth >= len(candidates) {
+		return "", "", false, fmt.Errorf("fatal: invalid --pretty format: '%v' references an alias which points to itself", original)
+	}
+
+	var found *candidate
+	for i, cand := range candidates {
+		if !strings.HasPrefix(cand.name, strings.ToLower(name)) {
+			continue
+		}
+		if found == nil || len(found.name) > len(cand.name) {
+			found = &candidates[i]
+		}
+	}
+	switch {
+	case found == nil:
+		return "", "", false, fmt.Errorf("fatal: invalid --pretty format: %v", original)
+	case found.value == "":
+		return found.name, "", false, nil
+	case strings.HasPrefix(found.value, "format:"):
+		return "format", found.value[7:], false, nil
+	case strings.HasPrefix(found.value, "tformat:"):
+		return "format", found.value[8:], true, nil
+	case strings.Contains(found.value, "%"):
+		return "format", found.value, true, nil
+	}
+	return findPrettyFormat(c, found.value, original, depth+1)
+}
+
+// Print writes the commit id to w, preceded or followed by a newline as
+// appropriate for the format. mark is the mark for the commit returned by
+// RevListEntry.Mark, if any.
+func (p *PrettyPrinter) Print(w io.Writer, id CommitID, mark string) error {
+	return p.print(w, id, mark, nil)
+}
+
+// Prints id as Print does. If from isn't nil, the built-in formats say
+// that the commit is being compared to its parent from, as log -m does.
+func (p *PrettyPrinter) print(w io.Writer, id CommitID, mark string, from *CommitID) error {
+	if p.graph != nil {
+		// The mark is drawn in the graph instead.
+		mark = ""
+	}
+	s, err := p.formatCommit(id, mark, from)
+	if err != nil {
+		return err
+	}
+	if p.graph != nil {
+		return p.printGraph(w, s)
+	}
+	if p.shown > 0 && !p.terminator {
+		s = "\n" + s
+	}
+	if p.terminator {
+		s += "\n"
+	}
+	p.shown++
+	_, err = io.WriteString(w, s)
+	return err
+}
+
+// SetGraph makes p draw commits beside the history graph g, which must be
+// updated with each commit before it's printed.
+func (p *PrettyPrinter) SetGraph(g *Graph) {
+	p.graph = g
+}
+
+// Prints the formatted commit s beside the graph, with a padding line of
+// the graph before any separators and terminators.
+func (p *PrettyPrinter) printGraph(w io.Writer, s string) error {
+	var buf strings.Builder
+	if p.shown > 0 && !p.terminator {
+		if !p.missingNewline {
+			buf.WriteString(p.graph.PaddingLine())
+		}
+		buf.WriteString("\n")
+	}
+	if err := p.graph.WriteCommit(&buf); err != nil {
+		return err
+	}
+	if err := p.graph.WriteText(&buf, s); err != nil {
+		return err
+	}
+	p.missingNewline = !strings.HasSuffix(s, "\n")
+	if p.terminator {
+		if !p.missingNewline {
+			buf.WriteString(p.graph.PaddingLine())
+		}
+		buf.WriteString("\n")
+	}
+	p.shown++
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// FormatCommit returns the commit id in the format of p, without any
+// separator before or terminator after it.
+func (p *PrettyPrinter) FormatCommit(id CommitID, mark string) (string, error) {
+	return p.formatCommit(id, mark, nil)
+}
+
+func (p *PrettyPrinter) formatCommit(id CommitID, mark string, from *CommitID) (string, error) {
+	cm, err := parsePrettyCommit(p.c, id)
+	if err != nil {
+		return "", err
+	}
+	if p.format == "format" {
+		ctx := &prettyContext{p: p, cm: cm, mark: mark}
+		return ctx.expand(p.userFormat)
+	}
+	s, err := p.formatBuiltin(cm, mark, from)
+	if err != nil {
+		return "", err
+	}
+	if p.opts.ShowNotes == nil && p.opts.Format == "" || p.opts.ShowNotes != nil && *p.opts.ShowNotes {
+		note, err := p.note(id, false)
+		if err != nil {
+			return "", err
+		}
+		if note != "" && (p.format == "email" || p.format == "mboxrd") {
+			s += "---\n"
+		}
+		s += note
+	}
+	return s, nil
+}
+
+// Returns the decorations of id for built-in formats, prefixed with a
+// space.
+func (p *PrettyPrinter) decorate(id CommitID, color bool) (string, error) {
+	if p.decorations == nil {
+		d, err := loadDecorations(p.c, p.opts.DecorateRefs, p.opts.DecorateRefsExclude)
+		if err != nil {
+			return "", err
+		}
+		p.decorations = d
+	}
+	return p.decorations.format(id, " (", ", ", ")", p.opts.Decorate == "full", color), nil
+}
+
+// Returns the note for id, formatted as it is after the message of
+// built-in formats or for %N if raw is set.
+func (p *PrettyPrinter) note(id CommitID, raw bool) (string, error) {
+	if p.notes == nil {
+		n, err := loadNotes(p.c, defaultNotesRef(p.c))
+		if err != nil {
+			return "", err
+		}
+		p.notes = n
+	}
+	return p.notes.format(p.c, Sha1(id), raw)
+}
+
+// Returns the abbreviation of id.
+func (p *PrettyPrinter) abbrev(id Sha1) string {
+	n := p.opts.Abbrev
+	if n <= 0 {
+		n = 7
+	}
+	return abbreviateSha1(p.c, id, n)
+}
+
+func (p *PrettyPrinter) color(color string) string {
+	if !p.opts.Color {
+		return ""
+	}
+	return color
+}
+
+// Formats cm in one of the built-in formats other than reference, noting
+// the parent that it's compared with if from isn't nil.
+func (p *PrettyPrinter) formatBuiltin(cm *prettyCommit, mark string, from *CommitID) (string, error) {
+	var s strings.Builder
+	mail := p.format == "email" || p.format == "mboxrd"
+	if mail {
+		fmt.Fprintf(&s, "From %v Mon Sep 17 00:00:00 2001\n", cm.Id)
+	} else {
+		s.WriteString(p.color(colorYellow))
+		if p.format != "oneline" {
+			s.WriteString("commit ")
+		}
+		if mark != "" {
+			s.WriteString(mark + " ")
+		}
+		if p.opts.AbbrevCommit {
+			s.WriteString(p.abbrev(Sha1(cm.Id)))
+		} else {
+			s.WriteString(cm.Id.String())
+		}
+		if from != nil {
+			if p.opts.AbbrevCommit {
+				fmt.Fprintf(&s, " (from %v)", p.abbrev(Sha1(*from)))
+			} else {
+				fmt.Fprintf(&s, " (from %v)", *from)
+			}
+		}
+		s.WriteString(p.color(colorReset))
+		if p.opts.Decorate != "" {
+			decorations, err := p.decorate(cm.Id, p.opts.Color)
+			if err != nil {
+				return "", err
+			}
+			s.WriteString(decorations)
+		}
+		if p.format == "oneline" {
+			s.WriteString(" ")
+		} else {
+			s.WriteString("\n")
+		}
+	}
+
+	switch p.format {
+	case "raw":
+		s.WriteString(cm.Header)
+	case "oneline":
+	default:
+		if len(cm.Parents) > 1 && !mail {
+			s.WriteString("Merge:")
+			for _, parent := range cm.Parents {
+				s.WriteString(" " + p.abbrev(Sha1(parent)))
+			}
+			s.WriteString("\n")
+		}
+		if err := p.formatPeople(&s, cm, mail); err != nil {
+			return "", err
+		}
+	}
+	if !mail && p.format != "oneline" {
+		s.WriteString("\n")
+	}
+
+	msg := skipBlankLines(cm.Message)
+	if mail || p.format == "oneline" {
+		subject, rest := formatSubject(msg, " ")
+		msg = rest
+		if mail {
+			s.WriteString(formatMailSubject(subject))
+			s.WriteString("\n")
+			if hasNonASCII(cm.Message) {
+				s.WriteString("MIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n")
+			}
+			s.WriteString("\n")
+		} else {
+			s.WriteString(subject)
+		}
+	}
+	bodyStart := s.Len()
+	if p.format != "oneline" {
+		indent := 4
+		if mail {
+			indent = 0
+		}
+		s.WriteString(p.formatRemainder(msg, indent))
+	}
+	str := strings.TrimRight(s.String(), " \t\n\r\v\f")
+	if p.format != "oneline" {
+		str += "\n"
+		if mail && len(str) <= bodyStart {
+			str += "\n"
+		}
+	}
+	return str, nil
+}
+
+// Returns the mailmap, reading it if it hasn't been read yet. Like git, an
+// unreadable mailmap doesn't map anything.
+func (p *PrettyPrinter) getMailmap() *Mailmap {
+	if p.mailmap == nil {
+		m, err := ReadMailmap(p.c)
+		if err != nil {
+			m = &Mailmap{}
+		}
+		p.mailmap = m
+	}
+	return p.mailmap
+}
+
+// Writes the author and committer header lines of the built-in formats
+// to s.
+func (p *PrettyPrinter) formatPeople(s *strings.Builder, cm *prettyCommit, mail bool) error {
+	author, err := parseIdent(cm.Author)
+	if err != nil {
+		return err
+	}
+	if p.opts.UseMailmap {
+		author = p.getMailmap().MapPerson(author)
+	}
+	if mail {
+		s.WriteString(formatMailFrom(author))
+		fmt.Fprintf(s, "Date: %v\n", FormatDate(*author.Time, DateMode{Type: "rfc2822"}, p.now))
+		return nil
+	}
+	padding := ""
+	if p.format == "fuller" {
+		padding = "    "
+	}
+	fmt.Fprintf(s, "Author: %v%v <%v>\n", padding, author.Name, author.Email)
+	switch p.format {
+	case "medium":
+		fmt.Fprintf(s, "Date:   %v\n", FormatDate(*author.Time, p.opts.Date, p.now))
+	case "fuller":
+		fmt.Fprintf(s, "AuthorDate: %v\n", FormatDate(*author.Time, p.opts.Date, p.now))
+	}
+	if p.format != "full" && p.format != "fuller" {
+		return nil
+	}
+	committer, err := parseIdent(cm.Committer)
+	if err != nil {
+		return err
+	}
+	if p.opts.UseMailmap {
+		committer = p.getMailmap().MapPerson(committer)
+	}
+	fmt.Fprintf(s, "Commit: %v%v <%v>\n", padding, committer.Name, committer.Email)
+	if p.format == "fuller" {
+		fmt.Fprintf(s, "CommitDate: %v\n", FormatDate(*committer.Time, p.opts.Date, p.now))
+	}
+	return nil
+}
+
+// Returns the message msg after the subject of built-in formats, indented
+// by indent spaces. Leading blank lines are skipped, and the short format
+// stops at the first blank line.
+func (p *PrettyPrinter) formatRemainder(msg string, indent int) string {
+	var s strings.Builder
+	first := true
+	for _, line := range strings.SplitAfter(msg, "\n") {
+		if line == "" {
+			break
+		}
+		line = strings.TrimRight(line, " \t\n\r\v\f")
+		if line == "" {
+			if first {
+				continue
+			}
+			if p.format == "short" {
+				break
+			}
+		}
+		first = false
+		switch {
+		case indent > 0:
+			s.WriteString(strings.Repeat(" ", indent))
+			if p.format == "medium" || p.format == "full" || p.format == "fuller" {
+				line = expandTabs(line, 8)
+			}
+		case p.format == "mboxrd" && isMboxrdFrom(line):
+			s.WriteString(">")
+		}
+		s.WriteString(line + "\n")
+	}
+	return s.String()
+}
+
+// Returns whether line needs to be quoted with ">" in the mboxrd format.
+func isMboxrdFrom(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, ">"), "From ")
+}
+
+// Replaces tabs in line with spaces up to the next tab stop.
+func expandTabs(line string, tabwidth int) string {
+	var s strings.Builder
+	for {
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			break
+		}
+		width := displayWidth(line[:tab])
+		s.WriteString(line[:tab])
+		s.WriteString(strings.Repeat(" ", tabwidth-width%tabwidth))
+		line = line[tab+1:]
+	}
+	s.WriteString(line)
+	return s.String()
+}
+
+// A prettyCommit is a commit parsed for formatting.
+type prettyCommit struct {
+	Id        CommitID
+	Tree      TreeID
+	Parents   []CommitID
+	Author    string
+	Committer string
+	Encoding  string
+
+	// The raw header lines of the commit.
+	Header string
+
+	// The commit message, after the blank line separating it from
+	// the header.
+	Message string
+}
+
+func parsePrettyCommit(c *Client, id CommitID) (*prettyCommit, error) {
+	obj, err := c.GetCommitObject(id)
+	if err != nil {
+		return nil, err
+	}
+	cm := &prettyCommit{Id: id}
+	content := string(obj.GetContent())
+	cm.Header = content
+	if i := strings.Index(content, "\n\n"); i >= 0 {
+		cm.Header, cm.Message = content[:i+1], content[i+2:]
+	}
+	for _, line := range strings.Split(cm.Header, "\n") {
+		pieces := strings.SplitN(line, " ", 2)
+		if len(pieces) != 2 {
+			continue
+		}
+		switch pieces[0] {
+		case "tree":
+			tree, err := Sha1FromString(pieces[1])
+			if err != nil {
+				return nil, err
+			}
+			cm.Tree = TreeID(tree)
+		case "parent":
+			parent, err := CommitIDFromString(pieces[1])
+			if err != nil {
+				return nil, err
+			}
+			cm.Parents = append(cm.Parents, parent)
+		case "author":
+			cm.Author = pieces[1]
+		case "committer":
+			cm.Committer = pieces[1]
+		case "encoding":
+			cm.Encoding = pieces[1]
+		}
+	}
+	return cm, nil
+}
+
+// Parses an ident such as "Name <email> 1600000000 +0200" from a commit
+// header. The Time is nil if the ident has no date.
+func parseIdent(ident string) (Person, error) {
+	emailStart := strings.IndexByte(ident, '<')
+	emailEnd := strings.LastIndexByte(ident, '>')
+	if emailStart < 0 || emailEnd < emailStart {
+		return Person{}, fmt.Errorf("malformed identity %q", ident)
+	}
+	p := Person{
+		Name:  strings.TrimSpace(ident[:emailStart]),
+		Email: ident[emailStart+1 : emailEnd],
+	}
+	date := strings.Fields(ident[emailEnd+1:])
+	if len(date) != 2 {
+		return p, nil
+	}
+	unixtime, err := strconv.ParseInt(date[0], 10, 64)
+	if err != nil {
+		return p, nil
+	}
+	tz, err := strconv.Atoi(date[1])
+	if err != nil {
+		return p, nil
+	}
+	t := time.Unix(unixtime, 0).In(time.FixedZone(date[1], (tz/100)*60*60+(tz%100)*60))
+	p.Time = &t
+	return p, nil
+}
+
+// Returns msg with any leading blank lines removed.
+func skipBlankLines(msg string) string {
+	for msg != "" {
+		eol := strings.IndexByte(msg, '\n')
+		if eol < 0 {
+			if strings.TrimSpace(msg) == "" {
+				return ""
+			}
+			return msg
+		}
+		if strings.TrimSpace(msg[:eol]) != "" {
+			return msg
+		}
+		msg = msg[eol+1:]
+	}
+	return msg
+}
+
+// Returns the subject of msg, which is the first paragraph with its lines
+// joined by sep, and the rest of the message after it.
+func formatSubject(msg, sep string) (subject, rest string) {
+	var lines []string
+	for msg != "" {
+		line := msg
+		eol := strings.IndexByte(msg, '\n')
+		if eol >= 0 {
+			line = msg[:eol]
+		}
+		line = strings.TrimRight(line, " \t\n\r\v\f")
+		if line == "" {
+			break
+		}
+		lines = append(lines, line)
+		if eol < 0 {
+			msg = ""
+			break
+		}
+		msg = msg[eol+1:]
+	}
+	if msg != "" {
+		// Skip the blank line which ended the subject.
+		if eol := strings.IndexByte(msg, '\n'); eol >= 0 {
+			msg = msg[eol+1:]
+		} else {
+			msg = ""
+		}
+	}
+	return strings.Join(lines, sep), msg
+}
+
+// Returns the subject converted to a form suitable for a filename, as with
+// %f.
+func sanitizeSubject(subject string) string {
+	var s []byte
+	space := 2
+	for i := 0; i < len(subject); i++ {
+		c := subject[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' {
+			if space == 1 {
+				s = append(s, '-')
+			}
+			space = 0
+			s = append(s, c)
+			if c == '.' {
+				for i+1 < len(subject) && subject[i+1] == '.' {
+					i++
+				}
+			}
+		} else {
+			space |= 1
+		}
+	}
+	return strings.TrimRight(string(s), ".-")
+}
+
+func hasNonASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether s needs to be encoded to be used in an email header.
+func needsRFC2047(s string) bool {
+	return hasNonASCII(s) || strings.Contains(s, "\n") || strings.Contains(s, "=?")
+}
+
+// Encodes s as an RFC 2047 "Q" encoded word to be appended to a header
+// line which is already lineLen long. Addresses restrict the characters
+// that can be left unencoded.
+func encodeRFC2047(s string, lineLen int, address bool) string {
+	const maxLen = 76
+	var b strings.Builder
+	b.WriteString("=?UTF-8?q?")
+	lineLen += len("UTF-8") + 5
+	for len(s) > 0 {
+		_, n := utf8.DecodeRuneInString(s)
+		ch := s[0]
+		special := n > 1 || ch < 0x20 || ch > 0x7e || ch == ' ' || ch == '=' || ch == '?' || ch == '_'
+		if address && !special {
+			special = !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || strings.IndexByte("!*+-/", ch) >= 0)
+		}
+		encodedLen := 1
+		if special {
+			encodedLen = 3 * n
+		}
+		if lineLen+encodedLen+2 > maxLen {
+			b.WriteString("?=\n =?UTF-8?q?")
+			lineLen = len("UTF-8") + 5 + 1
+		}
+		for i := 0; i < n; i++ {
+			if special {
+				fmt.Fprintf(&b, "=%02X", s[i])
+			} else {
+				b.WriteByte(s[i])
+			}
+		}
+		lineLen += encodedLen
+		s = s[n:]
+	}
+	b.WriteString("?=")
+	return b.String()
+}
+
+// Returns the Subject header line for the email formats, wrapped if it's
+// too long.
+func formatMailSubject(subject string) string {
+	const prefix = "Subject: [PATCH] "
+	if needsRFC2047(subject) {
+		return prefix + encodeRFC2047(subject, len(prefix), false)
+	}
+	return string(appendWrapped([]byte("Subject: "), "[PATCH] "+subject, -len("Subject: "), 1, 78))
+}
+
+// Returns the From header line for the email formats, including the
+// trailing newline.
+func formatMailFrom(author Person) string {
+	const prefix = "From: "
+	maxLen := 78
+	s := []byte(prefix)
+	switch {
+	case needsRFC2047(author.Name):
+		s = append(s, encodeRFC2047(author.Name, len(prefix), true)...)
+		maxLen = 76
+	case strings.ContainsAny(author.Name, "()<>@,;:\\\".[]"):
+		quoted := `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(author.Name) + `"`
+		s = appendWrapped(s, quoted, -len(prefix), 1, maxLen)
+	default:
+		s = appendWrapped(s, author.Name, -len(prefix), 1, maxLen)
+	}
+	lastLine := len(s) - (strings.LastIndexByte(string(s), '\n') + 1)
+	if maxLen < lastLine+len(" <")+len(author.Email)+len(">") {
+		s = append(s, '\n')
+	}
+	return string(s) + " <" + author.Email + ">\n"
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// Returns the length of the ANSI colour escape sequence at the start of
+// s, or 0 if s doesn't start with one.
+func escapeSequenceLen(s string) int {
+	if len(s) < 2 || s[0] != '\x1b' || s[1] != '[' {
+		return 0
+	}
+	for i := 2; i < len(s); i++ {
+		switch {
+		case s[i] == 'm':
+			return i + 1
+		case s[i] != ';' && (s[i] < '0' || s[i] > '9'):
+			return 0
+		}
+	}
+	return 0
+}
+
+// Returns the number of columns that s takes up on a terminal, ignoring
+// colour escape sequences.
+func displayWidth(s string) int {
+	w := 0
+	for i := 0; i < len(s); {
+		if n := escapeSequenceLen(s[i:]); n > 0 {
+			i += n
+			continue
+		}
+		_, n := utf8.DecodeRuneInString(s[i:])
+		i += n
+		w++
+	}
+	return w
+}
+
+// Appends text to buf, indenting the first line by indent1 and the others
+// by indent2 columns and wrapping lines at width columns. Single newlines
+// followed by an alphanumeric character are treated as spaces. A negative
+// indent1 means that the first line already has that many columns of text
+// on it. A width of 0 only indents the text.
+func appendWrapped(buf []byte, text string, indent1, indent2, width int) []byte {
+	if width <= 0 {
+		indent := indent1
+		if indent < 0 {
+			indent = 0
+		}
+		for text != "" {
+			eol := strings.IndexByte(text, '\n') + 1
+			if eol == 0 {
+				eol = len(text)
+			}
+			buf = append(buf, strings.Repeat(" ", indent)...)
+			buf = append(buf, text[:eol]...)
+			text = text[eol:]
+			indent = indent2
+		}
+		return buf
+	}
+	at := func(i int) byte {
+		if i < len(text) {
+			return text[i]
+		}
+		return 0
+	}
+	bol, i := 0, 0
+	w, indent := indent1, indent1
+	space := -1
+	if indent < 0 {
+		w = -indent
+		space = 0
+	}
+	for {
+		for n := escapeSequenceLen(text[i:]); n > 0; n = escapeSequenceLen(text[i:]) {
+			i += n
+		}
+		c := at(i)
+		if c != 0 && !isSpace(c) {
+			_, n := utf8.DecodeRuneInString(text[i:])
+			i += n
+			w++
+			continue
+		}
+		newLine := false
+		if w <= width || space < 0 {
+			start := bol
+			if c == 0 && i == start {
+				return buf
+			}
+			if space >= 0 {
+				start = space
+			} else {
+				buf = append(buf, strings.Repeat(" ", indent)...)
+			}
+			buf = append(buf, text[start:i]...)
+			if c == 0 {
+				return buf
+			}
+			space = i
+			switch c {
+			case '\t':
+				w |= 0x07
+			case '\n':
+				space++
+				switch {
+				case at(space) == '\n':
+					buf = append(buf, '\n')
+					newLine = true
+				case !isAlnum(at(space)):
+					newLine = true
+				default:
+					buf = append(buf, ' ')
+				}
+			}
+			if !newLine {
+				w++
+				i++
+				continue
+			}
+		}
+		buf = append(buf, '\n')
+		i = space
+		if isSpace(at(space)) {
+			i++
+		}
+		bol = i
+		space = -1
+		w, indent = indent2, indent2
+	}
+}
+
+// A prettyContext holds the state while expanding a format string for a
+// commit.
+type prettyContext struct {
+	p    *PrettyPrinter
+	cm   *prettyCommit
+	mark string
+
+	// Whether %C(auto) has enabled automatic colouring of the
+	// following placeholders.
+	autoColor bool
+
+	// Padding for the next placeholder set by %<, %> and friends. A
+	// negative padding pads to the column -padding.
+	padding  int
+	flush    int
+	truncate string
+
+	// The wrapping set by %w, which applies to the output since
+	// wrapStart.
+	wrapStart               int
+	width, indent1, indent2 int
+
+	signature *signatureCheck
+}
+
+// The ways that the next placeholder can be aligned by padding.
+const (
+	flushNone = iota
+	flushLeft
+	flushRight
+	flushBoth
+	flushLeftAndSteal
+)
+
+// Expands the format string for the commit.
+func (ctx *prettyContext) expand(format string) (string, error) {
+	var sb []byte
+	for {
+		pct := strings.IndexByte(format, '%')
+		if pct < 0 {
+			sb = append(sb, format...)
+			break
+		}
+		sb = append(sb, format[:pct]...)
+		format = format[pct+1:]
+		if strings.HasPrefix(format, "%") {
+			sb = append(sb, '%')
+			format = format[1:]
+			continue
+		}
+		consumed, err := ctx.formatItem(&sb, format)
+		if err != nil {
+			return "", err
+		}
+		if consumed == 0 {
+			sb = append(sb, '%')
+		}
+		format = format[consumed:]
+	}
+	ctx.rewrap(&sb, 0, 0, 0)
+	return string(sb), nil
+}
+
+// Expands the placeholder at the start of placeholder, after the "%",
+// with any magic prefix. It returns the number of bytes of placeholder
+// that were used, or 0 if it isn't a valid placeholder.
+func (ctx *prettyContext) formatItem(sb *[]byte, placeholder string) (int, error) {
+	magic := byte(0)
+	if placeholder != "" && strings.IndexByte("+- ", placeholder[0]) >= 0 {
+		magic = placeholder[0]
+		placeholder = placeholder[1:]
+		if strings.HasPrefix(placeholder, "w") {
+			// %+w() can't expand to anything, but can change
+			// the layout of what's before it.
+			return 0, nil
+		}
+	}
+
+	origLen := len(*sb)
+	var consumed int
+	var err error
+	if ctx.flush != flushNone {
+		consumed, err = ctx.formatAndPad(sb, placeholder)
+	} else {
+		consumed, err = ctx.formatOne(sb, placeholder)
+	}
+	if err != nil || magic == 0 {
+		return consumed, err
+	}
+
+	switch {
+	case origLen == len(*sb) && magic == '-':
+		for len(*sb) > 0 && (*sb)[len(*sb)-1] == '\n' {
+			*sb = (*sb)[:len(*sb)-1]
+		}
+	case origLen != len(*sb) && magic == '+':
+		*sb = append((*sb)[:origLen], append([]byte{'\n'}, (*sb)[origLen:]...)...)
+	case origLen != len(*sb) && magic == ' ':
+		*sb = append((*sb)[:origLen], append([]byte{' '}, (*sb)[origLen:]...)...)
+	}
+	return consumed + 1, nil
+}
+
+// Expands the next placeholder, and any colour placeholders before it,
+// into sb with the padding set by the preceding %< or %>.
+func (ctx *prettyContext) formatAndPad(sb *[]byte, placeholder string) (int, error) {
+	padding := ctx.padding
+	if padding < 0 {
+		start := strings.LastIndexByte(string(*sb), '\n') + 1
+		padding = -padding - displayWidth(string((*sb)[start:]))
+	}
+
+	var local []byte
+	total := 0
+	for {
+		isColor := strings.HasPrefix(placeholder, "C")
+		consumed, err := ctx.formatOne(&local, placeholder)
+		if err != nil {
+			return 0, err
+		}
+		total += consumed
+		if !isColor {
+			break
+		}
+		placeholder = placeholder[consumed:]
+		if !strings.HasPrefix(placeholder, "%") {
+			break
+		}
+		placeholder = placeholder[1:]
+		total++
+	}
+	width := displayWidth(string(local))
+
+	if ctx.flush == flushLeftAndSteal {
+		// Take spaces from the end of what's already been output to
+		// make room, moving any colours there into the padded text.
+		ch := len(*sb) - 1
+		for width > padding && ch > 0 {
+			if (*sb)[ch] == ' ' {
+				ch--
+				padding++
+				continue
+			}
+			if (*sb)[ch] != 'm' {
+				break
+			}
+			p := ch - 1
+			for ch-p < 10 && p > 0 && (*sb)[p] != '\x1b' {
+				p--
+			}
+			if (*sb)[p] != '\x1b' || ch+1-p != escapeSequenceLen(string((*sb)[p:])) {
+				break
+			}
+			local = append(append([]byte{}, (*sb)[p:ch+1]...), local...)
+			ch = p - 1
+		}
+		*sb = (*sb)[:ch+1]
+		ctx.flush = flushLeft
+	}
+
+	if width > padding {
+		switch ctx.truncate {
+		case "ltrunc":
+			local = replaceColumns(local, 0, width-(padding-2), "..")
+		case "mtrunc":
+			local = replaceColumns(local, padding/2-1, width-(padding-2), "..")
+		case "trunc":
+			local = replaceColumns(local, padding-2, width-(padding-2), "..")
+		}
+		*sb = append(*sb, local...)
+	} else {
+		pad := padding - width
+		var left int
+		switch ctx.flush {
+		case flushLeft:
+			left = pad
+		case flushBoth:
+			left = pad / 2
+		}
+		*sb = append(*sb, strings.Repeat(" ", left)...)
+		*sb = append(*sb, local...)
+		*sb = append(*sb, strings.Repeat(" ", pad-left)...)
+	}
+	ctx.flush = flushNone
+	return total, nil
+}
+
+// Replaces width columns of s starting at column pos with subst, keeping
+// any colour escape sequences.
+func replaceColumns(s []byte, pos, width int, subst string) []byte {
+	var dst []byte
+	w := 0
+	substituted := false
+	for i := 0; i < len(s); {
+		if n := escapeSequenceLen(string(s[i:])); n > 0 {
+			dst = append(dst, s[i:i+n]...)
+			i += n
+			continue
+		}
+		_, n := utf8.DecodeRune(s[i:])
+		if w >= pos && w < pos+width {
+			if !substituted {
+				dst = append(dst, subst...)
+				substituted = true
+			}
+		} else {
+			dst = append(dst, s[i:i+n]...)
+		}
+		w++
+		i += n
+	}
+	return dst
+}
+
+// Wraps the output since the last %w according to its settings, and
+// starts wrapping anything after it with the new settings.
+func (ctx *prettyContext) rewrap(sb *[]byte, width, indent1, indent2 int) {
+	if ctx.width == width && ctx.indent1 == indent1 && ctx.indent2 == indent2 {
+		return
+	}
+	if ctx.wrapStart < len(*sb) {
+		tail := string((*sb)[ctx.wrapStart:])
+		*sb = appendWrapped((*sb)[:ctx.wrapStart], tail, ctx.indent1, ctx.indent2, ctx.width)
+	}
+	ctx.wrapStart = len(*sb)
+	ctx.width, ctx.indent1, ctx.indent2 = width, indent1, indent2
+}
+
+// Parses a padding placeholder such as "<(10,trunc)" or ">|(20)".
+func (ctx *prettyContext) parsePadding(placeholder string) int {
+	var flush int
+	i := 1
+	switch {
+	case strings.HasPrefix(placeholder, "<"):
+		flush = flushRight
+	case strings.HasPrefix(placeholder, "><"):
+		flush, i = flushBoth, 2
+	case strings.HasPrefix(placeholder, ">>"):
+		flush, i = flushLeftAndSteal, 2
+	default:
+		flush = flushLeft
+	}
+	toColumn := false
+	if strings.HasPrefix(placeholder[i:], "|") {
+		toColumn = true
+		i++
+	}
+	if !strings.HasPrefix(placeholder[i:], "(") {
+		return 0
+	}
+	args := placeholder[i+1:]
+	end := strings.IndexByte(args, ')')
+	if end < 0 {
+		return 0
+	}
+	args = args[:end]
+	pieces := strings.SplitN(args, ",", 2)
+	width, err := strconv.Atoi(pieces[0])
+	if err != nil || width == 0 || width < -16384 || width > 16384 {
+		return 0
+	}
+	if width < 0 {
+		if toColumn {
+			width += terminalColumns()
+		}
+		if width < 0 {
+			return 0
+		}
+	}
+	truncate := ""
+	if len(pieces) == 2 {
+		switch pieces[1] {
+		case "trunc", "ltrunc", "mtrunc":
+			truncate = pieces[1]
+		default:
+			return 0
+		}
+	}
+	ctx.padding = width
+	if toColumn {
+		ctx.padding = -width
+	}
+	ctx.flush, ctx.truncate = flush, truncate
+	return i + 1 + end + 1
+}
+
+// Returns the width of the terminal, as git guesses it.
+func terminalColumns() int {
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		return cols
+	}
+	if cols, _, err := terminal.GetSize(int(os.Stdout.Fd())); err == nil && cols > 0 {
+		return cols
+	}
+	return 80
+}
+
+// Parses a colour placeholder such as "Cred" or "C(bold blue)" and writes
+// the colour to sb if colours are enabled.
+func (ctx *prettyContext) parseColor(sb *[]byte, placeholder string) (int, error) {
+	if strings.HasPrefix(placeholder, "C(") {
+		end := strings.IndexByte(placeholder, ')')
+		if end < 0 {
+			return 0, nil
+		}
+		spec := placeholder[2:end]
+		want := ctx.p.opts.Color
+		switch {
+		case strings.HasPrefix(spec, "auto,"):
+			spec = spec[5:]
+		case strings.HasPrefix(spec, "always,"):
+			spec, want = spec[7:], true
+		}
+		if !want {
+			return end + 1, nil
+		}
+		color, err := ParseColor(spec)
+		if err != nil {
+			return 0, fmt.Errorf("fatal: unable to parse --pretty format")
+		}
+		*sb = append(*sb, color...)
+		return end + 1, nil
+	}
+	for _, basic := range []struct{ name, color string }{
+		{"red", colorRed},
+		{"green", colorGreen},
+		{"blue", colorBlue},
+		{"reset", colorReset},
+	} {
+		if strings.HasPrefix(placeholder[1:], basic.name) {
+			if ctx.p.opts.Color {
+				*sb = append(*sb, basic.color...)
+			}
+			return 1 + len(basic.name), nil
+		}
+	}
+	// A lone "C" is consumed without doing anything, like git.
+	return 1, nil
+}
+
+// Expands a single placeholder into sb, returning the number of bytes
+// consumed or 0 if it isn't valid.
+func (ctx *prettyContext) formatOne(sb *[]byte, placeholder string) (int, error) {
+	if placeholder == "" {
+		return 0, nil
+	}
+	p, cm := ctx.p, ctx.cm
+
+	// These don't depend on the commit.
+	switch placeholder[0] {
+	case 'C':
+		if strings.HasPrefix(placeholder, "C(auto)") {
+			ctx.autoColor = p.opts.Color
+			if ctx.autoColor && len(*sb) > 0 {
+				*sb = append(*sb, colorReset...)
+			}
+			return 7, nil
+		}
+		consumed, err := ctx.parseColor(sb, placeholder)
+		if consumed > 0 {
+			ctx.autoColor = false
+		}
+		return consumed, err
+	case 'n':
+		*sb = append(*sb, '\n')
+		return 1, nil
+	case 'x':
+		if len(placeholder) < 3 {
+			return 0, nil
+		}
+		ch, err := strconv.ParseUint(placeholder[1:3], 16, 8)
+		if err != nil {
+			return 0, nil
+		}
+		*sb = append(*sb, byte(ch))
+		return 3, nil
+	case 'w':
+		if !strings.HasPrefix(placeholder, "w(") {
+			return 0, nil
+		}
+		end := strings.IndexByte(placeholder, ')')
+		if end < 0 {
+			return 0, nil
+		}
+		var args [3]int
+		if end > 2 {
+			pieces := strings.Split(placeholder[2:end], ",")
+			if len(pieces) > 3 {
+				return 0, nil
+			}
+			for i, piece := range pieces {
+				n, err := strconv.ParseUint(piece, 10, 16)
+				if err != nil {
+					return 0, nil
+				}
+				args[i] = int(n)
+			}
+		}
+		ctx.rewrap(sb, args[0], args[1], args[2])
+		return end + 1, nil
+	case '<', '>':
+		return ctx.parsePadding(placeholder), nil
+	}
+
+	// These depend on the commit.
+	switch placeholder[0] {
+	case 'H':
+		*sb = append(*sb, ctx.autoColored(colorYellow, cm.Id.String())...)
+		return 1, nil
+	case 'h':
+		*sb = append(*sb, ctx.autoColored(colorYellow, p.abbrev(Sha1(cm.Id)))...)
+		return 1, nil
+	case 'T':
+		*sb = append(*sb, cm.Tree.String()...)
+		return 1, nil
+	case 't':
+		*sb = append(*sb, p.abbrev(Sha1(cm.Tree))...)
+		return 1, nil
+	case 'P', 'p':
+		for i, parent := range cm.Parents {
+			if i > 0 {
+				*sb = append(*sb, ' ')
+			}
+			if placeholder[0] == 'P' {
+				*sb = append(*sb, parent.String()...)
+			} else {
+				*sb = append(*sb, p.abbrev(Sha1(parent))...)
+			}
+		}
+		return 1, nil
+	case 'm':
+		switch ctx.mark {
+		case "-", "=", "<", ">":
+			*sb = append(*sb, ctx.mark...)
+		default:
+			*sb = append(*sb, '>')
+		}
+		return 1, nil
+	case 'd', 'D':
+		if p.decorations == nil {
+			if _, err := p.decorate(cm.Id, false); err != nil {
+				return 0, err
+			}
+		}
+		full := p.opts.Decorate == "full"
+		if placeholder[0] == 'd' {
+			*sb = append(*sb, p.decorations.format(cm.Id, " (", ", ", ")", full, ctx.autoColor)...)
+		} else {
+			*sb = append(*sb, p.decorations.format(cm.Id, "", ", ", "", full, ctx.autoColor)...)
+		}
+		return 1, nil
+	case 'N':
+		if p.opts.ShowNotes != nil && !*p.opts.ShowNotes {
+			return 0, nil
+		}
+		note, err := p.note(cm.Id, true)
+		if err != nil {
+			return 0, err
+		}
+		*sb = append(*sb, note...)
+		return 1, nil
+	case 'G':
+		if len(placeholder) < 2 || strings.IndexByte("G?SKFPT", placeholder[1]) < 0 {
+			return 0, nil
+		}
+		if ctx.signature == nil {
+			check, err := verifyCommitSignature(p.c, cm.Id)
+			if err != nil {
+				return 0, err
+			}
+			ctx.signature = &check
+		}
+		sig := ctx.signature
+		switch placeholder[1] {
+		case 'G':
+			*sb = append(*sb, sig.Output...)
+		case '?':
+			*sb = append(*sb, sig.Result)
+		case 'S':
+			*sb = append(*sb, sig.Signer...)
+		case 'K':
+			*sb = append(*sb, sig.Key...)
+		case 'F':
+			*sb = append(*sb, sig.Fingerprint...)
+		case 'P':
+			*sb = append(*sb, sig.PrimaryFingerprint...)
+		case 'T':
+			*sb = append(*sb, sig.Trust...)
+		}
+		return 2, nil
+	case 'a', 'c':
+		ident := cm.Author
+		if placeholder[0] == 'c' {
+			ident = cm.Committer
+		}
+		if len(placeholder) < 2 {
+			return 0, nil
+		}
+		return ctx.formatPerson(sb, placeholder[1], ident), nil
+	case 'e':
+		*sb = append(*sb, cm.Encoding...)
+		return 1, nil
+	case 'B':
+		*sb = append(*sb, cm.Message...)
+		return 1, nil
+	}
+
+	msg := skipBlankLines(cm.Message)
+	switch placeholder[0] {
+	case 's':
+		subject, _ := formatSubject(msg, " ")
+		*sb = append(*sb, subject...)
+		return 1, nil
+	case 'f':
+		line := msg
+		if eol := strings.IndexByte(msg, '\n'); eol >= 0 {
+			line = msg[:eol]
+		}
+		*sb = append(*sb, sanitizeSubject(line)...)
+		return 1, nil
+	case 'b':
+		_, body := formatSubject(msg, " ")
+		*sb = append(*sb, skipBlankLines(body)...)
+		return 1, nil
+	}
+
+	if strings.HasPrefix(placeholder, "(trailers") {
+		args := placeholder[len("(trailers"):]
+		var opts trailerOptions
+		consumed := len("(trailers")
+		if strings.HasPrefix(args, ":") {
+			n, ok := parseTrailerOptions(args[1:], &opts)
+			if !ok {
+				return 0, nil
+			}
+			args = args[1+n:]
+			consumed += 1 + n
+		}
+		if !strings.HasPrefix(args, ")") {
+			return 0, nil
+		}
+		*sb = append(*sb, formatTrailers(msg, opts)...)
+		return consumed + 1, nil
+	}
+	return 0, nil
+}
+
+// Returns s coloured with color if %C(auto) is in effect.
+func (ctx *prettyContext) autoColored(color, s string) string {
+	if !ctx.autoColor {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// Expands the author or committer placeholder part for the ident.
+func (ctx *prettyContext) formatPerson(sb *[]byte, part byte, ident string) int {
+	person, err := parseIdent(ident)
+	if err != nil {
+		// Placeholders which can be expanded are dropped for
+		// malformed idents, and the rest are left alone.
+		if strings.IndexByte("netdDri", part) >= 0 {
+			return 2
+		}
+		return 0
+	}
+	if part == 'N' || part == 'E' || part == 'L' {
+		person = ctx.p.getMailmap().MapPerson(person)
+	}
+	switch part {
+	case 'n', 'N':
+		*sb = append(*sb, person.Name...)
+		return 2
+	case 'e', 'E':
+		*sb = append(*sb, person.Email...)
+		return 2
+	case 'l', 'L':
+		local := person.Email
+		if at := strings.IndexByte(local, '@'); at >= 0 {
+			local = local[:at]
+		}
+		*sb = append(*sb, local...)
+		return 2
+	}
+	if person.Time == nil {
+		if strings.IndexByte("tdDri", part) >= 0 {
+			return 2
+		}
+		return 0
+	}
+	t := *person.Time
+	var mode DateMode
+	switch part {
+	case 't':
+		mode.Type = "unix"
+	case 'd':
+		mode = ctx.p.opts.Date
+	case 'D':
+		mode.Type = "rfc2822"
+	case 'r':
+		mode.Type = "relative"
+	case 'i':
+		mode.Type = "iso8601"
+	case 'I':
+		mode.Type = "iso8601-strict"
+	case 'h':
+		mode.Type = "human"
+	case 's':
+		mode.Type = "short"
+	default:
+		return 0
+	}
+	*sb = append(*sb, FormatDate(t, mode, ctx.p.now)...)
+	return 2
+}
+
+// Parses the options of a %(trailers:...) placeholder up to the closing
+// parenthesis, returning the number of bytes used and whether they were
+// valid.
+func parseTrailerOptions(args string, opts *trailerOptions) (int, bool) {
+	i := 0
+	for !strings.HasPrefix(args[i:], ")") {
+		end := strings.IndexAny(args[i:], ",)")
+		if end < 0 {
+			return 0, false
+		}
+		arg := args[i : i+end]
+		i += end
+		if strings.HasPrefix(args[i:], ",") {
+			i++
+		}
+		name, value, hasValue := arg, "", false
+		if eq := strings.IndexByte(arg, '='); eq >= 0 {
+			name, value, hasValue = arg[:eq], arg[eq+1:], true
+		}
+		switch name {
+		case "key":
+			if !hasValue {
+				return 0, false
+			}
+			opts.Keys = append(opts.Keys, value)
+			opts.Only = true
+		case "separator", "key_value_separator":
+			expanded := expandLiteralPlaceholders(value)
+			if name == "separator" {
+				opts.Separator = &expanded
+			} else {
+				opts.KeyValueSeparator = &expanded
+			}
+		case "only", "unfold", "keyonly", "valueonly":
+			b := true
+			if hasValue {
+				switch strings.ToLower(value) {
+				case "true", "yes", "on", "1":
+				case "false", "no", "off", "0":
+					b = false
+				default:
+					return 0, false
+				}
+			}
+			switch name {
+			case "only":
+				opts.Only = b
+			case "unfold":
+				opts.Unfold = b
+			case "keyonly":
+				opts.KeyOnly = b
+			case "valueonly":
+				opts.ValueOnly = b
+			}
+		default:
+			return 0, false
+		}
+	}
+	return i, true
+}
+
+// Expands %n and %xNN in s, which is the only expansion done in the
+// separators of %(trailers).
+func expandLiteralPlaceholders(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch {
+		case s[i+1] == 'n':
+			b.WriteByte('\n')
+			i++
+		case s[i+1] == 'x' && i+3 < len(s):
+			if ch, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(ch))
+				i += 3
+				continue
+			}
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+		}
+	}
+	return b.String()
+}
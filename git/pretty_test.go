@@ -0,0 +1,394 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Creates a repository with a commit with a known author, committer and
+// message, and a child of it, for testing formatting.
+func testPrettySetup(t *testing.T) (c *Client, dir string, first, second CommitID) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gitpretty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := c.WriteObject("tree", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := c.WriteObject("commit", []byte(fmt.Sprintf(`tree %v
+author Ann Author <ann@example.com> 1600100000 +0200
+committer Cal Committer <cal@example.com> 1600100050 -0530
+
+First commit
+`, tree)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first = CommitID(id)
+	id, err = c.WriteObject("commit", []byte(fmt.Sprintf(`tree %v
+parent %v
+author Ann Author <ann@example.com> 1600200000 +0200
+committer Cal Committer <cal@example.com> 1600200050 -0530
+
+Second commit with a
+wrapped subject
+
+Body	with a tab.
+
+Signed-off-by: Ann Author <ann@example.com>
+Reviewed-by: Someone
+  continued <x@y>
+`, tree, first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second = CommitID(id)
+	if err := UpdateRef(c, UpdateRefOptions{}, "refs/heads/master", second, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef(c, UpdateRefOptions{}, "refs/tags/v1", first, "test"); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestPrettyPrinterBuiltinFormats(t *testing.T) {
+	c, dir, first, second := testPrettySetup(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		Opts PrettyOptions
+		Want string
+	}{
+		{
+			PrettyOptions{Format: "oneline", AbbrevCommit: true},
+			fmt.Sprintf("%.7v Second commit with a wrapped subject\n%.7v First commit\n", second, first),
+		},
+		{
+			PrettyOptions{Format: "short"},
+			fmt.Sprintf(`commit %v
+Author: Ann Author <ann@example.com>
+
+    Second commit with a
+    wrapped subject
+
+commit %v
+Author: Ann Author <ann@example.com>
+
+    First commit
+`, second, first),
+		},
+		{
+			PrettyOptions{Date: DateMode{Type: "iso8601"}},
+			fmt.Sprintf(`commit %v
+Author: Ann Author <ann@example.com>
+Date:   2020-09-15 22:00:00 +0200
+
+    Second commit with a
+    wrapped subject
+    
+    Body    with a tab.
+    
+    Signed-off-by: Ann Author <ann@example.com>
+    Reviewed-by: Someone
+      continued <x@y>
+
+commit %v
+Author: Ann Author <ann@example.com>
+Date:   2020-09-14 18:13:20 +0200
+
+    First commit
+`, second, first),
+		},
+		{
+			PrettyOptions{Format: "fuller", Decorate: "short"},
+			fmt.Sprintf(`commit %v (HEAD -> master)
+Author:     Ann Author <ann@example.com>
+AuthorDate: Tue Sep 15 22:00:00 2020 +0200
+Commit:     Cal Committer <cal@example.com>
+CommitDate: Tue Sep 15 14:30:50 2020 -0530
+
+    Second commit with a
+    wrapped subject
+    
+    Body    with a tab.
+    
+    Signed-off-by: Ann Author <ann@example.com>
+    Reviewed-by: Someone
+      continued <x@y>
+
+commit %v (tag: v1)
+Author:     Ann Author <ann@example.com>
+AuthorDate: Mon Sep 14 18:13:20 2020 +0200
+Commit:     Cal Committer <cal@example.com>
+CommitDate: Mon Sep 14 10:44:10 2020 -0530
+
+    First commit
+`, second, first),
+		},
+		{
+			PrettyOptions{Format: "email"},
+			fmt.Sprintf(`From %v Mon Sep 17 00:00:00 2001
+From: Ann Author <ann@example.com>
+Date: Tue, 15 Sep 2020 22:00:00 +0200
+Subject: [PATCH] Second commit with a wrapped subject
+
+Body	with a tab.
+
+Signed-off-by: Ann Author <ann@example.com>
+Reviewed-by: Someone
+  continued <x@y>
+
+From %v Mon Sep 17 00:00:00 2001
+From: Ann Author <ann@example.com>
+Date: Mon, 14 Sep 2020 18:13:20 +0200
+Subject: [PATCH] First commit
+
+`, second, first),
+		},
+		{
+			PrettyOptions{Format: "reference"},
+			fmt.Sprintf("%.7v (Second commit with a wrapped subject, 2020-09-15)\n%.7v (First commit, 2020-09-14)\n", second, first),
+		},
+		{
+			PrettyOptions{Format: "format:%h %s"},
+			fmt.Sprintf("%.7v Second commit with a wrapped subject\n%.7v First commit", second, first),
+		},
+	}
+	for i, test := range tests {
+		p, err := NewPrettyPrinter(c, test.Opts)
+		if err != nil {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+			continue
+		}
+		var out bytes.Buffer
+		for _, id := range []CommitID{second, first} {
+			if err := p.Print(&out, id, ""); err != nil {
+				t.Errorf("Test %d: unexpected error %v", i, err)
+			}
+		}
+		if got := out.String(); got != test.Want {
+			t.Errorf("Test %d: got %q want %q", i, got, test.Want)
+		}
+	}
+}
+
+func TestPrettyPrinterPlaceholders(t *testing.T) {
+	c, dir, first, second := testPrettySetup(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		Format string
+		Color  bool
+		Want   string
+	}{
+		{"%H|%h|%p", false, fmt.Sprintf("%v|%.7v|%.7v", second, second, first)},
+		{"%an <%ae> %al|%cn|%ct|%ci|%as|%cI", false, "Ann Author <ann@example.com> ann|Cal Committer|1600200050|2020-09-15 14:30:50 -0530|2020-09-15|2020-09-15T14:30:50-05:30"},
+		{"%d|%D", false, " (HEAD -> master)|HEAD -> master"},
+		{"%s|%f", false, "Second commit with a wrapped subject|Second-commit-with-a"},
+		{"%b", false, "Body\twith a tab.\n\nSigned-off-by: Ann Author <ann@example.com>\nReviewed-by: Someone\n  continued <x@y>\n"},
+		{"%(trailers:key=reviewed-by,unfold)", false, "Reviewed-by: Someone continued <x@y>\n"},
+		{"%(trailers:only,keyonly,separator=%x2C )", false, "Signed-off-by, Reviewed-by"},
+		{"%<(10,trunc)%s|%>(8)%an|%<(12,mtrunc)%s|", false, "Second c..|Ann Author|Secon..bject|"},
+		{"%><(9)%al|%<|(6)x|", false, "   ann   |x|"},
+		{"%w(12,1,2)%s", false, " Second\n  commit\n  with a\n  wrapped\n  subject"},
+		{"a%+b%-n%n% al", false, "a\nBody\twith a tab.\n\nSigned-off-by: Ann Author <ann@example.com>\nReviewed-by: Someone\n  continued <x@y>\n\n\n ann"},
+		{"%Cred%h%Creset", false, fmt.Sprintf("%.7v", second)},
+		{"%C(auto)%h%C(bold blue)%d", true, fmt.Sprintf("\x1b[33m%.7v\x1b[m\x1b[1;34m (HEAD -> master)", second)},
+		{"%C(auto)%D", true, "\x1b[33m\x1b[m\x1b[1;36mHEAD -> \x1b[m\x1b[1;32mmaster\x1b[m\x1b[33m\x1b[m"},
+		{"%m %G? %x41 %q %%", false, "> N A %q %"},
+	}
+	for i, test := range tests {
+		p, err := NewPrettyPrinter(c, PrettyOptions{Format: "format:" + test.Format, Color: test.Color})
+		if err != nil {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+			continue
+		}
+		got, err := p.FormatCommit(second, "")
+		if err != nil {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+			continue
+		}
+		if got != test.Want {
+			t.Errorf("Test %d: got %q want %q", i, got, test.Want)
+		}
+	}
+}
+
+func TestCommitIDFormat(t *testing.T) {
+	c, dir, first, second := testPrettySetup(t)
+	defer os.RemoveAll(dir)
+
+	got, err := second.Format(c, "%H%n%D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%v\nHEAD -> master", second); got != want {
+		t.Errorf("Unexpected Format: got %q want %q", got, want)
+	}
+
+	got, err = first.FormatMedium(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(`commit %v (tag: v1)
+Author: Ann Author <ann@example.com>
+Date:   Mon Sep 14 18:13:20 2020 +0200
+
+    First commit
+
+`, first)
+	if got != want {
+		t.Errorf("Unexpected FormatMedium: got %q want %q", got, want)
+	}
+}
+
+func TestPrettyFormatAliases(t *testing.T) {
+	c, dir, _, second := testPrettySetup(t)
+	defer os.RemoveAll(dir)
+
+	config, err := LoadLocalConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfig("pretty.subj", "format:%s")
+	config.SetConfig("pretty.alias", "subj")
+	config.SetConfig("pretty.loop", "loop")
+	if err := config.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = NewClient(c.GitDir.String(), c.WorkDir.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"subj", "alias", "al"} {
+		p, err := NewPrettyPrinter(c, PrettyOptions{Format: format})
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", format, err)
+			continue
+		}
+		if got, err := p.FormatCommit(second, ""); err != nil || got != "Second commit with a wrapped subject" {
+			t.Errorf("%v: got %q, %v", format, got, err)
+		}
+	}
+	for _, format := range []string{"loop", "nonexistent"} {
+		if _, err := NewPrettyPrinter(c, PrettyOptions{Format: format}); err == nil {
+			t.Errorf("%v: expected an error", format)
+		}
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	tm := time.Unix(1600200050, 0).In(time.FixedZone("", -(5*60+30)*60))
+	now := time.Unix(1600200050+3*24*60*60, 0).In(time.UTC)
+	tests := []struct {
+		Mode string
+		Want string
+	}{
+		{"", "Tue Sep 15 14:30:50 2020 -0530"},
+		{"relative", "3 days ago"},
+		{"iso", "2020-09-15 14:30:50 -0530"},
+		{"iso-strict", "2020-09-15T14:30:50-05:30"},
+		{"rfc", "Tue, 15 Sep 2020 14:30:50 -0530"},
+		{"short", "2020-09-15"},
+		{"raw", "1600200050 -0530"},
+		{"unix", "1600200050"},
+		{"local", "Tue Sep 15 20:00:50 2020"},
+		{"human", "Tue 14:30 -0530"},
+		{"format:%Y/%m/%d %H:%M %a %z %q", "2020/09/15 14:30 Tue -0530 %q"},
+		{"format-local:%F %T %Z", "2020-09-15 20:00:50 UTC"},
+	}
+	for _, test := range tests {
+		mode, err := ParseDateMode(test.Mode)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.Mode, err)
+			continue
+		}
+		if got := FormatDate(tm, mode, now); got != test.Want {
+			t.Errorf("%v: got %q want %q", test.Mode, got, test.Want)
+		}
+	}
+	if _, err := ParseDateMode("nonsense"); err == nil {
+		t.Error("expected an error for an unknown date mode")
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		Value string
+		Want  string
+	}{
+		{"", ""},
+		{"reset", "\x1b[m"},
+		{"red", "\x1b[31m"},
+		{"bold red blue", "\x1b[1;31;44m"},
+		{"normal red", "\x1b[41m"},
+		{"brightgreen ul", "\x1b[4;92m"},
+		{"nobold 200 #ff0010", "\x1b[22;38;5;200;48;2;255;0;16m"},
+	}
+	for _, test := range tests {
+		got, err := ParseColor(test.Value)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.Value, err)
+			continue
+		}
+		if got != test.Want {
+			t.Errorf("%q: got %q want %q", test.Value, got, test.Want)
+		}
+	}
+	for _, value := range []string{"red green blue", "notacolour"} {
+		if _, err := ParseColor(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestFindTrailerBlock(t *testing.T) {
+	tests := []struct {
+		Msg  string
+		Want string
+	}{
+		{"Subject\n\nBody\n\nKey: value\nOther-Key: value\n", "Key: value\nOther-Key: value\n"},
+		{"Subject: not a trailer\n", ""},
+		{"Subject\n\nNot: a\ntrailer block\nat all\n", ""},
+		{"Subject\n\nSome text\nSigned-off-by: Someone\nmore text\n", "Some text\nSigned-off-by: Someone\nmore text\n"},
+		{"Subject\n\nKey: value\n  continued\n\n# comment\n", "Key: value\n  continued\n"},
+	}
+	for i, test := range tests {
+		if got := findTrailerBlock(test.Msg); got != test.Want {
+			t.Errorf("Test %d: got %q want %q", i, got, test.Want)
+		}
+	}
+}
+
+func TestAppendWrapped(t *testing.T) {
+	tests := []struct {
+		Text                    string
+		Indent1, Indent2, Width int
+		Want                    string
+	}{
+		{"one two three four", 0, 0, 9, "one two\nthree\nfour"},
+		{"one two three four", 2, 4, 10, "  one two\n    three\n    four"},
+		{"one\ntwo\n\nthree", 0, 0, 20, "one two\n\nthree"},
+		{"one two three", 0, 0, 0, "one two three"},
+	}
+	for i, test := range tests {
+		if got := string(appendWrapped(nil, test.Text, test.Indent1, test.Indent2, test.Width)); got != test.Want {
+			t.Errorf("Test %d: got %q want %q", i, got, test.Want)
+		}
+	}
+	if got := string(appendWrapped([]byte("Subject: "), "[PATCH] "+strings.Repeat("word ", 16)+"end", -len("Subject: "), 1, 78)); !strings.HasSuffix(got, "word\n word word word word end") {
+		t.Errorf("long subject not wrapped: %q", got)
+	}
+}
@@ -14,6 +14,8 @@ var lastProgress int64
 func progressF(fmtS string, done bool, args ...interface{}) {
 	if done {
 		fmt.Fprintf(os.Stderr, "\r"+fmtS+", done\n", args...)
+		lastProgress = 0
+		return
 	}
 	now := time.Now().Unix()
 	if lastProgress > 0 && now-lastProgress < 3 {
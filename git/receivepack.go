@@ -0,0 +1,726 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type ReceivePackOptions struct {
+	// Serve a single request and response and then exit. This is used
+	// by the smart http protocol.
+	StatelessRPC bool
+
+	// Only advertise the refs and then exit.
+	AdvertiseRefs bool
+
+	// The value of the GIT_PROTOCOL environment variable (or
+	// Git-Protocol http header) sent by the client.
+	GitProtocol string
+}
+
+// A receiveCommand is a ref update requested by the client.
+type receiveCommand struct {
+	old, new Sha1
+	ref      string
+
+	// The reason that the update was rejected, or the empty string if
+	// it succeeded.
+	err string
+
+	// Set if the ref is the checked out branch, and the work tree
+	// should be updated with it because receive.denyCurrentBranch is
+	// updateInstead.
+	updateWorktree bool
+}
+
+func (r receiveCommand) isDelete() bool {
+	return r.new == (Sha1{})
+}
+
+// The state of a receive-pack session
+type receivePack struct {
+	c    *Client
+	opts ReceivePackOptions
+
+	r   *packProtocolReader
+	w   pktLineWriter
+	out io.Writer
+
+	caps        map[string]struct{}
+	commands    []*receiveCommand
+	pushOptions []string
+
+	// Where the output from hooks is written, which is the progress
+	// side-band if the client supports it.
+	progress io.Writer
+
+	// The client for the quarantine directory that the pack is received
+	// into, and the directory itself.
+	qc   *Client
+	qdir string
+}
+
+// ReceivePack accepts a push from the client on the other end of r and w,
+// receiving the pack into a quarantine directory and updating the refs
+// after running the pre-receive and update hooks.
+func ReceivePack(c *Client, opts ReceivePackOptions, r io.Reader, w io.Writer) error {
+	rp := &receivePack{
+		c:        c,
+		opts:     opts,
+		r:        newPktLineReader(r),
+		w:        pktLineWriter{w},
+		out:      w,
+		caps:     make(map[string]struct{}),
+		progress: os.Stderr,
+	}
+
+	if !opts.StatelessRPC || opts.AdvertiseRefs {
+		// Protocol version 2 isn't defined for pushes, so
+		// version 0 is used instead.
+		if requestedProtocolVersion(opts.GitProtocol) == 1 {
+			if err := rp.w.Printf("version 1\n"); err != nil {
+				return err
+			}
+		}
+		refs, err := ShowRef(c, ShowRefOptions{}, nil)
+		if err != nil && len(refs) > 0 {
+			return err
+		}
+		// We can't fix thin packs when indexing, so ask the client not
+		// to send them.
+		caps := "report-status delete-refs side-band-64k quiet ofs-delta no-thin"
+		if c.GetConfig("receive.advertiseAtomic") != "false" {
+			caps += " atomic"
+		}
+		if c.GetConfig("receive.advertisePushOptions") == "true" {
+			caps += " push-options"
+		}
+		caps += " agent=dgit/0.0.2"
+		if err := writeRefAdvertisement(c, rp.w, refs, caps, false); err != nil {
+			return err
+		}
+	}
+	if opts.AdvertiseRefs {
+		return nil
+	}
+
+	shallow, err := rp.readCommands()
+	if err != nil || len(rp.commands) == 0 {
+		return err
+	}
+	if rp.hasCap("side-band-64k") {
+		rp.progress = sidebandWriter{w, sidebandChannel, 65520}
+	}
+	if rp.hasCap("push-options") {
+		if err := rp.readPushOptions(); err != nil {
+			return err
+		}
+	}
+
+	unpackErr := rp.unpack()
+	if rp.qdir != "" {
+		defer os.RemoveAll(rp.qdir)
+	}
+	switch {
+	case unpackErr != nil:
+		for _, cmd := range rp.commands {
+			cmd.err = "unpacker error"
+		}
+	case shallow:
+		for _, cmd := range rp.commands {
+			cmd.err = "shallow update not allowed"
+		}
+	default:
+		rp.executeCommands()
+	}
+
+	if rp.hasCap("report-status") {
+		if err := rp.report(unpackErr); err != nil {
+			return err
+		}
+	}
+	if unpackErr == nil {
+		rp.runPostHooks()
+	}
+	if rp.hasCap("side-band-64k") {
+		if err := rp.w.Flush(); err != nil {
+			return err
+		}
+	}
+	if unpackErr != nil {
+		return unpackErr
+	}
+	if c.GetConfig("receive.updateServerInfo") == "true" {
+		return UpdateServerInfo(c, UpdateServerInfoOptions{})
+	}
+	return nil
+}
+
+// Runs the post-receive and post-update hooks for the refs which were
+// updated. As with git, they're run after the status has been reported
+// but before the final flush, so that their output is sent to the client
+// on the progress channel if it requested side-band-64k.
+func (rp *receivePack) runPostHooks() {
+	var updated []string
+	for _, cmd := range rp.commands {
+		if cmd.err == "" {
+			updated = append(updated, cmd.ref)
+		}
+	}
+	if len(updated) == 0 {
+		return
+	}
+	if err := runHook(rp.c, "post-receive", nil, rp.hookInput(), rp.progress, rp.pushOptionEnv()); err != nil {
+		fmt.Fprintln(rp.progress, err)
+	}
+	if err := runHook(rp.c, "post-update", updated, nil, rp.progress, nil); err != nil {
+		fmt.Fprintln(rp.progress, err)
+	}
+}
+
+// Returns true if the client requested the capability cap.
+func (rp *receivePack) hasCap(cap string) bool {
+	_, ok := rp.caps[cap]
+	return ok
+}
+
+// Reads the commands from the client up to the flush. Returns true if the
+// client sent shallow lines, which means that it's pushing from a shallow
+// repository.
+func (rp *receivePack) readCommands() (bool, error) {
+	shallow := false
+	for {
+		line, err := readPktLine(rp.r)
+		if err == flushPkt {
+			return shallow, nil
+		} else if err == io.EOF && len(rp.commands) == 0 {
+			// The client only wanted the ref advertisement.
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		if strings.HasPrefix(line, "shallow ") {
+			shallow = true
+			continue
+		}
+		if nul := strings.IndexByte(line, 0); nul >= 0 {
+			for _, cap := range strings.Fields(line[nul+1:]) {
+				rp.caps[cap] = struct{}{}
+			}
+			line = line[:nul]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return false, fmt.Errorf("protocol error: expected old/new/ref, got '%s'", line)
+		}
+		old, err := Sha1FromString(fields[0])
+		if err != nil {
+			return false, fmt.Errorf("protocol error: expected old/new/ref, got '%s'", line)
+		}
+		new, err := Sha1FromString(fields[1])
+		if err != nil {
+			return false, fmt.Errorf("protocol error: expected old/new/ref, got '%s'", line)
+		}
+		rp.commands = append(rp.commands, &receiveCommand{old: old, new: new, ref: fields[2]})
+	}
+}
+
+// Reads the push options sent by the client.
+func (rp *receivePack) readPushOptions() error {
+	for {
+		line, err := readPktLine(rp.r)
+		if err == flushPkt {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		rp.pushOptions = append(rp.pushOptions, line)
+	}
+}
+
+// Receives the pack from the client into a quarantine directory, so that
+// the objects don't become part of the repository unless the pre-receive
+// hook accepts them.
+func (rp *receivePack) unpack() error {
+	// If there's nothing but deletes, the client doesn't send a
+	// pack.
+	needPack := false
+	for _, cmd := range rp.commands {
+		if !cmd.isDelete() {
+			needPack = true
+		}
+	}
+	if !needPack {
+		return nil
+	}
+
+	qdir, err := ioutil.TempDir(rp.c.ObjectDir, "tmp_objdir-incoming-")
+	if err != nil {
+		return err
+	}
+	rp.qdir = qdir
+	rp.qc = &Client{
+		GitDir:      rp.c.GitDir,
+		WorkDir:     rp.c.WorkDir,
+		ObjectDir:   qdir,
+		alternates:  append([]string{rp.c.ObjectDir}, rp.c.alternates...),
+		objectCache: make(map[Sha1]objectLocation),
+		objcache:    make(map[shaRef]GitObject),
+	}
+	rp.r.SetReadMode(DirectMode)
+	defer rp.r.SetReadMode(PktLineMode)
+	idx, err := IndexPack(rp.qc, IndexPackOptions{}, rp.r)
+	if err != nil {
+		return err
+	}
+	// Don't keep empty packs, which the client sends when all of the
+	// objects are already in the repository.
+	if v2, ok := idx.(*PackfileIndexV2); ok && v2.Fanout[255] == 0 {
+		packfile, _ := idx.GetTrailer()
+		base := filepath.Join(qdir, "pack", "pack-"+packfile.String())
+		os.Remove(base + ".idx")
+		os.Remove(base + ".pack")
+	}
+	return nil
+}
+
+// Returns the environment for hooks run while the objects are still in
+// the quarantine directory.
+func (rp *receivePack) quarantineEnv() []string {
+	if rp.qdir == "" {
+		return nil
+	}
+	return []string{
+		"GIT_QUARANTINE_PATH=" + rp.qdir,
+		"GIT_OBJECT_DIRECTORY=" + rp.qdir,
+		"GIT_ALTERNATE_OBJECT_DIRECTORIES=" + strings.Join(rp.qc.alternates, string(filepath.ListSeparator)),
+	}
+}
+
+// Returns the environment variables which pass the push options to
+// hooks.
+func (rp *receivePack) pushOptionEnv() []string {
+	if !rp.hasCap("push-options") {
+		return nil
+	}
+	env := []string{fmt.Sprintf("GIT_PUSH_OPTION_COUNT=%d", len(rp.pushOptions))}
+	for i, opt := range rp.pushOptions {
+		env = append(env, fmt.Sprintf("GIT_PUSH_OPTION_%d=%s", i, opt))
+	}
+	return env
+}
+
+// Returns the input for the pre-receive and post-receive hooks, which is
+// a line for each ref that is being (or was) updated.
+func (rp *receivePack) hookInput() io.Reader {
+	var buf bytes.Buffer
+	for _, cmd := range rp.commands {
+		if cmd.err == "" {
+			fmt.Fprintf(&buf, "%v %v %s\n", cmd.old, cmd.new, cmd.ref)
+		}
+	}
+	return &buf
+}
+
+// Checks and applies the ref updates requested by the client.
+func (rp *receivePack) executeCommands() {
+	c := rp.c
+	if err := rp.checkConnected(); err != nil {
+		fmt.Fprintf(rp.progress, "%v\n", err)
+		for _, cmd := range rp.commands {
+			cmd.err = "missing necessary objects"
+		}
+		return
+	}
+
+	env := append(rp.quarantineEnv(), rp.pushOptionEnv()...)
+	if err := runHook(c, "pre-receive", nil, rp.hookInput(), rp.progress, env); err != nil {
+		for _, cmd := range rp.commands {
+			cmd.err = "pre-receive hook declined"
+		}
+		return
+	}
+
+	// The objects were accepted, so move them into the repository
+	// before updating any refs.
+	if rp.qdir != "" {
+		if err := migrateObjects(rp.qdir, c.ObjectDir); err != nil {
+			fmt.Fprintf(rp.progress, "error: unable to migrate objects to permanent storage: %v\n", err)
+			for _, cmd := range rp.commands {
+				cmd.err = "unable to migrate objects to permanent storage"
+			}
+			return
+		}
+	}
+
+	atomic := rp.hasCap("atomic")
+	for _, cmd := range rp.commands {
+		cmd.err = rp.checkCommand(cmd)
+		if cmd.err == "" {
+			if err := runHook(c, "update", []string{cmd.ref, cmd.old.String(), cmd.new.String()}, nil, rp.progress, nil); err != nil {
+				cmd.err = "hook declined"
+			} else if cmd.updateWorktree {
+				cmd.err = rp.updateWorktree(cmd)
+			}
+		}
+		if cmd.err != "" {
+			if atomic {
+				rp.failAtomic()
+				return
+			}
+			continue
+		}
+		if !atomic {
+			if err := updateReceivedRef(c, cmd); err != nil {
+				fmt.Fprintf(rp.progress, "error: %v\n", err)
+				cmd.err = "failed to update ref"
+			}
+		}
+	}
+	if !atomic {
+		return
+	}
+
+	// Everything was accepted, so apply all of the updates.
+	for i, cmd := range rp.commands {
+		if err := updateReceivedRef(c, cmd); err != nil {
+			fmt.Fprintf(rp.progress, "error: %v\n", err)
+			cmd.err = "failed to update ref"
+			// Put back the refs that were already updated.
+			for _, done := range rp.commands[:i] {
+				updateReceivedRef(c, &receiveCommand{old: done.new, new: done.old, ref: done.ref})
+			}
+			rp.failAtomic()
+			return
+		}
+	}
+}
+
+// Rejects all of the commands which didn't already fail, because an
+// atomic push failed.
+func (rp *receivePack) failAtomic() {
+	for _, cmd := range rp.commands {
+		if cmd.err == "" {
+			cmd.err = "atomic push failure"
+		}
+	}
+}
+
+// Checks if the command is allowed, and returns the reason for rejecting
+// it if it isn't.
+func (rp *receivePack) checkCommand(cmd *receiveCommand) string {
+	c := rp.c
+	if !strings.HasPrefix(cmd.ref, "refs/") || !validRefName(cmd.ref) {
+		return "funny refname"
+	}
+	if cur, err := RefSpec(cmd.ref).Sha1(c); err == nil {
+		if cur != cmd.old {
+			return "stale info"
+		}
+	} else if cmd.old != (Sha1{}) {
+		return "stale info"
+	}
+
+	current := false
+	if !c.IsBare() {
+		if head, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD"); err == nil && head.String() == cmd.ref {
+			current = true
+		}
+	}
+
+	if cmd.isDelete() {
+		if c.GetConfig("receive.denyDeletes") == "true" && strings.HasPrefix(cmd.ref, "refs/heads/") {
+			return "deletion prohibited"
+		}
+		if current {
+			switch c.GetConfig("receive.denyDeleteCurrent") {
+			case "warn":
+				fmt.Fprintf(rp.progress, "warning: deleting the current branch\n")
+			case "ignore", "false":
+			default:
+				fmt.Fprintf(rp.progress, "error: refusing to delete the current branch: %s\n", cmd.ref)
+				return "deletion of the current branch prohibited"
+			}
+		}
+		return ""
+	}
+
+	if have, _, err := c.HaveObject(cmd.new); !have || err != nil {
+		return "bad pack"
+	}
+	if current {
+		switch c.GetConfig("receive.denyCurrentBranch") {
+		case "warn":
+			fmt.Fprintf(rp.progress, "warning: updating the current branch\n")
+		case "ignore", "false":
+		case "updateInstead", "updateinstead":
+			// Other checks come first, the work tree is
+			// updated after the update hook.
+			cmd.updateWorktree = true
+		default:
+			fmt.Fprintf(rp.progress, "error: refusing to update checked out branch: %s\n", cmd.ref)
+			return "branch is currently checked out"
+		}
+	}
+	if cmd.old != (Sha1{}) && strings.HasPrefix(cmd.ref, "refs/heads/") && c.GetConfig("receive.denyNonFastForwards") == "true" {
+		if cmd.old.Type(c) == "commit" && cmd.new.Type(c) == "commit" && !CommitID(cmd.old).IsAncestor(c, CommitID(cmd.new)) {
+			return "non-fast-forward"
+		}
+	}
+	return ""
+}
+
+// Updates the work tree and index to the new value of the checked out
+// branch, for receive.denyCurrentBranch=updateInstead. If there's a
+// push-to-checkout hook it's responsible for the update, otherwise the
+// work tree must be clean. Returns the reason for rejecting cmd if it
+// can't be updated.
+func (rp *receivePack) updateWorktree(cmd *receiveCommand) string {
+	c := rp.c
+	// As in clone, paths are relative to the current directory, so the
+	// work tree is updated from within it.
+	gwd, ggd := c.WorkDir, c.GitDir
+	pwd, err := os.Getwd()
+	if err != nil {
+		return "Could not update working tree to new HEAD"
+	}
+	absworkdir, err := filepath.Abs(c.WorkDir.String())
+	if err != nil {
+		return "Could not update working tree to new HEAD"
+	}
+	absgitdir, err := filepath.Abs(c.GitDir.String())
+	if err != nil {
+		return "Could not update working tree to new HEAD"
+	}
+	if err := os.Chdir(absworkdir); err != nil {
+		return "Could not update working tree to new HEAD"
+	}
+	c.WorkDir, c.GitDir = WorkDir(absworkdir), GitDir(absgitdir)
+	defer func() {
+		os.Chdir(pwd)
+		c.WorkDir, c.GitDir = gwd, ggd
+	}()
+
+	if findHook(c, "push-to-checkout") != "" {
+		env := []string{"GIT_WORK_TREE=" + c.WorkDir.String()}
+		if err := runHook(c, "push-to-checkout", []string{cmd.new.String()}, nil, rp.progress, env); err != nil {
+			return "push-to-checkout hook declined"
+		}
+		return ""
+	}
+
+	// Like git, refresh the stat info first so that files which were
+	// only touched aren't treated as modified.
+	if err := refreshIndex(c); err != nil {
+		return "Could not refresh index"
+	}
+	unstaged, err := DiffFiles(c, DiffFilesOptions{}, nil)
+	if err != nil || len(unstaged) > 0 {
+		return "Working directory has unstaged changes"
+	}
+	if cmd.old == (Sha1{}) {
+		idx, err := c.GitDir.ReadIndex()
+		if err != nil || len(idx.Objects) > 0 {
+			return "Working directory has staged changes"
+		}
+		if _, err := ReadTree(c, ReadTreeOptions{Merge: true, Update: true}, CommitID(cmd.new)); err != nil {
+			return "Could not update working tree to new HEAD"
+		}
+		return ""
+	}
+	staged, err := DiffIndex(c, DiffIndexOptions{Cached: true}, nil, CommitID(cmd.old), nil)
+	if err != nil || len(staged) > 0 {
+		return "Working directory has staged changes"
+	}
+	if _, err := ReadTreeFastForward(c, ReadTreeOptions{Merge: true, Update: true}, CommitID(cmd.old), CommitID(cmd.new)); err != nil {
+		return "Could not update working tree to new HEAD"
+	}
+	return ""
+}
+
+// Checks that everything reachable from the new values of the refs is
+// either in the repository or was received in the pack.
+func (rp *receivePack) checkConnected() error {
+	if rp.qc == nil {
+		return nil
+	}
+	var stack []Sha1
+	for _, cmd := range rp.commands {
+		if cmd.err == "" && !cmd.isDelete() {
+			stack = append(stack, cmd.new)
+		}
+	}
+	seen := make(map[Sha1]struct{})
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+
+		// Anything that was already in the repository is assumed to
+		// be complete.
+		if have, _, err := rp.c.HaveObject(id); have && err == nil {
+			continue
+		}
+		obj, err := rp.qc.GetObject(id)
+		if err != nil {
+			return fmt.Errorf("error: object %v is missing", id)
+		}
+		switch o := obj.(type) {
+		case GitCommitObject:
+			tree, err := Sha1FromString(o.GetHeader("tree"))
+			if err != nil {
+				return err
+			}
+			stack = append(stack, tree)
+			parents, err := CommitID(id).Parents(rp.qc)
+			if err != nil {
+				return err
+			}
+			for _, p := range parents {
+				stack = append(stack, Sha1(p))
+			}
+		case GitTagObject:
+			target, err := Sha1FromString(o.GetHeader("object"))
+			if err != nil {
+				return err
+			}
+			stack = append(stack, target)
+		case GitTreeObject:
+			content := o.GetContent()
+			for i := 0; i < len(content); {
+				_, entry, size, err := parseRawTreeLine(i, content)
+				if err != nil {
+					return err
+				}
+				i += size
+				if entry.FileMode != ModeCommit {
+					stack = append(stack, entry.Sha1)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Updates the ref for a command that was accepted.
+func updateReceivedRef(c *Client, cmd *receiveCommand) error {
+	if cmd.isDelete() {
+		if err := UpdateRef(c, UpdateRefOptions{Delete: true}, cmd.ref, CommitID{}, "push"); err != nil {
+			return err
+		}
+		os.Remove(c.GitDir.File(File("logs/" + cmd.ref)).String())
+		return nil
+	}
+	return UpdateRefSpec(c, UpdateRefOptions{}, RefSpec(cmd.ref), CommitID(cmd.new), "push")
+}
+
+// Sends the report-status response to the client.
+func (rp *receivePack) report(unpackErr error) error {
+	var buf bytes.Buffer
+	w := pktLineWriter{&buf}
+	if unpackErr != nil {
+		w.Printf("unpack %v\n", strings.Replace(unpackErr.Error(), "\n", " ", -1))
+	} else {
+		w.Printf("unpack ok\n")
+	}
+	for _, cmd := range rp.commands {
+		if cmd.err == "" {
+			w.Printf("ok %s\n", cmd.ref)
+		} else {
+			w.Printf("ng %s %s\n", cmd.ref, cmd.err)
+		}
+	}
+	w.Flush()
+
+	if !rp.hasCap("side-band-64k") {
+		_, err := rp.out.Write(buf.Bytes())
+		return err
+	}
+	// The final flush is sent by ReceivePack after the post-receive
+	// hooks have run.
+	_, err := (sidebandWriter{rp.out, sidebandDataChannel, 65520}).Write(buf.Bytes())
+	return err
+}
+
+// Moves the objects from the quarantine directory qdir into the object
+// directory objdir. Packs are moved before their indexes, so that a pack
+// never appears to be available before it's complete.
+func migrateObjects(qdir, objdir string) error {
+	entries, err := ioutil.ReadDir(qdir)
+	if err != nil {
+		return err
+	}
+	for _, dir := range entries {
+		if !dir.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(qdir, dir.Name()))
+		if err != nil {
+			return err
+		}
+		order := func(name string) int {
+			switch filepath.Ext(name) {
+			case ".idx":
+				return 2
+			case ".keep":
+				return 0
+			}
+			return 1
+		}
+		for pass := 0; pass < 3; pass++ {
+			for _, fi := range files {
+				if fi.IsDir() || order(fi.Name()) != pass || strings.HasPrefix(fi.Name(), ".tmp") {
+					continue
+				}
+				src := filepath.Join(qdir, dir.Name(), fi.Name())
+				dst := filepath.Join(objdir, dir.Name(), fi.Name())
+				if File(dst).Exists() {
+					// Objects are immutable, so if it's
+					// already there it's the same.
+					continue
+				}
+				if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+					return err
+				}
+				if err := os.Rename(src, dst); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns true if name is a valid ref name, according to the rules in
+// git-check-ref-format(1).
+func validRefName(name string) bool {
+	if name == "" || name == "@" || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") {
+		return false
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, "@{") || strings.Contains(name, "//") {
+		return false
+	}
+	for _, r := range name {
+		if r < 040 || r == 0177 {
+			return false
+		}
+		switch r {
+		case ' ', '~', '^', ':', '?', '*', '[', '\\':
+			return false
+		}
+	}
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	return true
+}
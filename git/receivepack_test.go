@@ -0,0 +1,320 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReceivePack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitreceivepack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcdir := filepath.Join(dir, "src")
+	src, err := Init(nil, InitOptions{Quiet: true}, srcdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcdir); err != nil {
+		t.Fatal(err)
+	}
+	var commits []CommitID
+	for _, content := range []string{"foo\n", "bar\n"} {
+		if err := ioutil.WriteFile("foo.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Add(src, AddOptions{}, []File{"foo.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		cmt, err := Commit(src, CommitOptions{}, "commit", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, cmt)
+	}
+	first, second := commits[0], commits[1]
+
+	dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "dst.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress bytes.Buffer
+	push := func(caps string, lines []string, objects []Sha1) []string {
+		t.Helper()
+		return testReceivePackPush(t, src, dst, &progress, caps, lines, objects)
+	}
+	checkReport := func(got []string, want ...string) {
+		t.Helper()
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("Unexpected report: got %q want %q", got, want)
+		}
+	}
+	checkRef := func(ref string, want Sha1) {
+		t.Helper()
+		if got, _ := RefSpec(ref).Sha1(dst); got != want {
+			t.Errorf("Unexpected value for %v: got %v want %v", ref, got, want)
+		}
+	}
+
+	var zero Sha1
+	objects, err := first.GetAllObjectsExcept(src, make(map[Sha1]struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects = append(objects, Sha1(first))
+	report := push("", []string{
+		zero.String() + " " + first.String() + " refs/heads/master",
+		zero.String() + " " + first.String() + " refs/heads/topic",
+		zero.String() + " " + first.String() + " refs/heads/bad..name",
+	}, objects)
+	checkReport(report, "unpack ok", "ok refs/heads/master", "ok refs/heads/topic", "ng refs/heads/bad..name funny refname")
+	checkRef("refs/heads/master", Sha1(first))
+	checkRef("refs/heads/topic", Sha1(first))
+	if have, _, err := dst.HaveObject(Sha1(first)); !have || err != nil {
+		t.Errorf("Pushed commit %v was not migrated from quarantine: %v", first, err)
+	}
+
+	// A pre-receive hook which rejects everything. The objects should
+	// not be moved out of the quarantine.
+	hook := dst.GitDir.File("hooks/pre-receive").String()
+	if err := ioutil.WriteFile(hook, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	objects, err = second.GetAllObjectsExcept(src, map[Sha1]struct{}{Sha1(first): {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects = append(objects, Sha1(second))
+	update := first.String() + " " + second.String() + " refs/heads/master"
+	report = push("", []string{update}, objects)
+	checkReport(report, "unpack ok", "ng refs/heads/master pre-receive hook declined")
+	checkRef("refs/heads/master", Sha1(first))
+	if have, _, _ := dst.HaveObject(Sha1(second)); have {
+		t.Errorf("Object %v from a rejected push was kept", second)
+	}
+	if err := os.Remove(hook); err != nil {
+		t.Fatal(err)
+	}
+
+	// An atomic push where one of the updates isn't allowed.
+	dst.SetCachedConfig("receive.denyDeletes", "true")
+	deletion := first.String() + " " + zero.String() + " refs/heads/topic"
+	report = push("atomic", []string{update, deletion}, objects)
+	checkReport(report, "unpack ok", "ng refs/heads/master atomic push failure", "ng refs/heads/topic deletion prohibited")
+	checkRef("refs/heads/master", Sha1(first))
+	checkRef("refs/heads/topic", Sha1(first))
+
+	dst.SetCachedConfig("receive.denyDeletes", "false")
+	report = push("atomic", []string{update, deletion}, []Sha1{})
+	checkReport(report, "unpack ok", "ok refs/heads/master", "ok refs/heads/topic")
+	checkRef("refs/heads/master", Sha1(second))
+	checkRef("refs/heads/topic", Sha1{})
+
+	dst.SetCachedConfig("receive.denyNonFastForwards", "true")
+	report = push("", []string{second.String() + " " + first.String() + " refs/heads/master"}, []Sha1{})
+	checkReport(report, "unpack ok", "ng refs/heads/master non-fast-forward")
+	checkRef("refs/heads/master", Sha1(second))
+
+	// The output of the post-receive and post-update hooks is sent to
+	// the client on the progress channel.
+	for _, name := range []string{"post-receive", "post-update"} {
+		script := "#!/bin/sh\necho " + name + ": $(cat) \"$@\"\n"
+		if err := ioutil.WriteFile(dst.GitDir.File(File("hooks/"+name)).String(), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	progress.Reset()
+	report = push("", []string{zero.String() + " " + first.String() + " refs/heads/topic"}, []Sha1{})
+	checkReport(report, "unpack ok", "ok refs/heads/topic")
+	want := "post-receive: " + zero.String() + " " + first.String() + " refs/heads/topic\n" +
+		"post-update: refs/heads/topic\n"
+	if got := progress.String(); got != want {
+		t.Errorf("Unexpected hook output: got %q want %q", got, want)
+	}
+
+	files, err := ioutil.ReadDir(dst.ObjectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), "tmp_objdir-incoming-") {
+			t.Errorf("Quarantine directory %v was not removed", fi.Name())
+		}
+	}
+}
+
+func TestReceivePackUpdateInstead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitreceivepackupdate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcdir := filepath.Join(dir, "src")
+	src, err := Init(nil, InitOptions{Quiet: true}, srcdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcdir); err != nil {
+		t.Fatal(err)
+	}
+	var commits []CommitID
+	for _, content := range []string{"foo\n", "bar\n"} {
+		if err := ioutil.WriteFile("foo.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Add(src, AddOptions{}, []File{"foo.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		cmt, err := Commit(src, CommitOptions{}, "commit", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, cmt)
+	}
+	first, second := commits[0], commits[1]
+	var objects []Sha1
+	for _, cmt := range commits {
+		cmtobjects, err := cmt.GetAllObjectsExcept(src, make(map[Sha1]struct{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(append(objects, cmtobjects...), Sha1(cmt))
+	}
+
+	dstdir := filepath.Join(dir, "dst")
+	dst, err := Init(nil, InitOptions{Quiet: true}, dstdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var progress bytes.Buffer
+	push := func(old, new CommitID, objects []Sha1) []string {
+		t.Helper()
+		return testReceivePackPush(t, src, dst, &progress, "", []string{Sha1(old).String() + " " + Sha1(new).String() + " refs/heads/master"}, objects)
+	}
+	checkReport := func(got []string, want ...string) {
+		t.Helper()
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("Unexpected report: got %q want %q", got, want)
+		}
+	}
+	checkFile := func(want string) {
+		t.Helper()
+		if got, err := ioutil.ReadFile(filepath.Join(dstdir, "foo.txt")); err != nil || string(got) != want {
+			t.Errorf("Unexpected work tree file: got %q (%v) want %q", got, err, want)
+		}
+	}
+
+	// The checked out branch is refused by default.
+	report := push(CommitID{}, first, objects)
+	checkReport(report, "unpack ok", "ng refs/heads/master branch is currently checked out")
+
+	dst.SetCachedConfig("receive.denyCurrentBranch", "updateInstead")
+	report = push(CommitID{}, first, []Sha1{})
+	checkReport(report, "unpack ok", "ok refs/heads/master")
+	checkFile("foo\n")
+
+	// Changes in the work tree aren't overwritten.
+	if err := ioutil.WriteFile(filepath.Join(dstdir, "foo.txt"), []byte("local\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report = push(first, second, []Sha1{})
+	checkReport(report, "unpack ok", "ng refs/heads/master Working directory has unstaged changes")
+	checkFile("local\n")
+
+	if err := ioutil.WriteFile(filepath.Join(dstdir, "foo.txt"), []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report = push(first, second, []Sha1{})
+	checkReport(report, "unpack ok", "ok refs/heads/master")
+	checkFile("bar\n")
+	if got, _ := RefSpec("refs/heads/master").Sha1(dst); got != Sha1(second) {
+		t.Errorf("Unexpected value for master: got %v want %v", got, second)
+	}
+}
+
+// Sends the ref update lines and a pack of objects from src to dst, and
+// returns the report-status lines. Anything sent on the progress channel
+// before the final flush is written to progress.
+func testReceivePackPush(t *testing.T, src, dst *Client, progress *bytes.Buffer, caps string, lines []string, objects []Sha1) []string {
+	t.Helper()
+	var req, resp bytes.Buffer
+	w := pktLineWriter{&req}
+	for i, line := range lines {
+		if i == 0 {
+			line += "\x00report-status side-band-64k " + caps
+		}
+		w.Printf("%s\n", line)
+	}
+	w.Flush()
+	if objects != nil {
+		if _, err := PackObjects(src, PackObjectsOptions{}, &req, objects); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ReceivePack(dst, ReceivePackOptions{StatelessRPC: true}, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	var status bytes.Buffer
+	sideband := newPktLineReader(&resp)
+	buf := make([]byte, 65520)
+demux:
+	for {
+		n, err := sideband.Read(buf)
+		if err == flushPkt {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		switch buf[0] {
+		case sidebandDataChannel:
+			status.Write(buf[1:n])
+		case sidebandChannel:
+			progress.Write(buf[1:n])
+		default:
+			t.Errorf("Unexpected side-band channel %d: %q", buf[0], buf[1:n])
+			break demux
+		}
+	}
+	r := newPktLineReader(&status)
+	var report []string
+	for {
+		line, err := readPktLine(r)
+		if err == flushPkt {
+			return report
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		report = append(report, line)
+	}
+}
+
+func TestValidRefName(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Valid bool
+	}{
+		{"refs/heads/master", true},
+		{"refs/heads/feature/foo-bar", true},
+		{"refs/heads/foo..bar", false},
+		{"refs/heads/.hidden", false},
+		{"refs/heads/foo.lock", false},
+		{"refs/heads/foo bar", false},
+		{"refs/heads/foo~1", false},
+		{"refs/heads/foo@{1}", false},
+		{"refs/heads/", false},
+		{"refs//heads", false},
+	}
+	for _, test := range tests {
+		if got := validRefName(test.Name); got != test.Valid {
+			t.Errorf("validRefName(%q): got %v want %v", test.Name, got, test.Valid)
+		}
+	}
+}
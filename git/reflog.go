@@ -1,9 +1,13 @@
 package git
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type ReflogDeleteOptions struct{}
@@ -21,6 +25,92 @@ func ReflogExists(c *Client, r Refname) bool {
 	return c.GitDir.File(File(path)).Exists()
 }
 
+// A ReflogEntry is a single entry in a reflog.
+type ReflogEntry struct {
+	Old, New Sha1
+
+	// The person who made the change. Committer.Time is always set.
+	Committer Person
+	Message   string
+}
+
+// Reads the reflog for r, with the oldest entry first. It's not an error
+// for r not to have a reflog.
+func readReflog(c *Client, r Refname) ([]ReflogEntry, error) {
+	f, err := c.GitDir.Open(File("logs/" + string(r)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []ReflogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		entry, err := parseReflogLine(line)
+		if err != nil {
+			// Older versions of dgit wrote multi-line commit
+			// messages into the reflog, so treat lines that don't
+			// parse as a continuation of the previous message.
+			if len(entries) > 0 {
+				entries[len(entries)-1].Message += "\n" + line
+				continue
+			}
+			return nil, fmt.Errorf("Invalid reflog for %v: %v", r, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Parses a reflog line, which is in the format
+// "old new Name <email> unixtime timezone<tab>message".
+func parseReflogLine(line string) (ReflogEntry, error) {
+	var entry ReflogEntry
+	if len(line) < 82 || line[40] != ' ' || line[81] != ' ' {
+		return entry, fmt.Errorf("malformed line %q", line)
+	}
+	var err error
+	if entry.Old, err = Sha1FromString(line[:40]); err != nil {
+		return entry, err
+	}
+	if entry.New, err = Sha1FromString(line[41:81]); err != nil {
+		return entry, err
+	}
+	who := line[82:]
+	if tab := strings.IndexByte(who, '\t'); tab >= 0 {
+		who, entry.Message = who[:tab], who[tab+1:]
+	}
+	emailEnd := strings.LastIndexByte(who, '>')
+	emailStart := strings.LastIndexByte(who, '<')
+	if emailStart < 0 || emailEnd < emailStart {
+		return entry, fmt.Errorf("malformed identity %q", who)
+	}
+	entry.Committer.Name = strings.TrimSpace(who[:emailStart])
+	entry.Committer.Email = who[emailStart+1 : emailEnd]
+	date := strings.Fields(who[emailEnd+1:])
+	if len(date) != 2 {
+		return entry, fmt.Errorf("malformed date %q", who[emailEnd+1:])
+	}
+	unixtime, err := strconv.ParseInt(date[0], 10, 64)
+	if err != nil {
+		return entry, err
+	}
+	tz, err := strconv.Atoi(date[1])
+	if err != nil {
+		return entry, err
+	}
+	t := time.Unix(unixtime, 0).In(time.FixedZone(date[1], (tz/100)*60*60+(tz%100)*60))
+	entry.Committer.Time = &t
+	return entry, nil
+}
+
 func ReflogExpire(c *Client, opts ReflogExpireOptions, refpatterns []string) error {
 	if opts.Expire != "now" || len(refpatterns) != 0 {
 		return fmt.Errorf("Only reflog --expire=now --all currently supported")
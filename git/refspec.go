@@ -3,6 +3,7 @@ package git
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"strings"
 )
 
@@ -68,10 +69,20 @@ func (r RefSpec) File(c *Client) File {
 }
 
 // Returns the value of RefSpec in Client's GitDir, or the empty string
-// if it doesn't exist.
+// if it doesn't exist. Refs which aren't loose are looked up in the
+// packed-refs file.
 func (r RefSpec) Value(c *Client) (string, error) {
 	f := r.File(c)
 	val, err := f.ReadAll()
+	if os.IsNotExist(err) {
+		packed, perr := getPackedRef(c, r.String())
+		if perr != nil {
+			return "", perr
+		}
+		if packed != nil {
+			return packed.Value.String(), nil
+		}
+	}
 	return strings.TrimSpace(val), err
 }
 
@@ -80,6 +91,9 @@ func (r RefSpec) Sha1(c *Client) (Sha1, error) {
 	if err != nil {
 		return Sha1{}, err
 	}
+	if strings.HasPrefix(v, "ref: ") {
+		return RefSpec(strings.TrimPrefix(v, "ref: ")).Sha1(c)
+	}
 	return Sha1FromString(v)
 }
 
@@ -152,7 +166,7 @@ func GetBranch(c *Client, branchname string) (Branch, error) {
 
 // Returns true if the branch exists under c's GitDir
 func (b Branch) Exists(c *Client) bool {
-	return c.GitDir.File(File(b)).Exists()
+	return refExists(c, string(b))
 }
 
 // Implements Commitish interface on Branch.
@@ -186,9 +200,10 @@ func (b Branch) BranchName() string {
 // Delete a branch
 func (b Branch) DeleteBranch(c *Client) error {
 	location := c.GitDir.File(File(b))
-	err := location.Remove()
-	if err != nil {
-		return err
+	if location.Exists() {
+		if err := location.Remove(); err != nil {
+			return err
+		}
 	}
-	return nil
+	return removePackedRefs(c, string(b))
 }
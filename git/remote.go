@@ -4,63 +4,103 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
 type Remote string
 
 func (r Remote) RemoteURL(c *Client) (string, error) {
+	if strings.Index(r.String(), "://") != -1 || isSCPLikeURL(r.String()) || File(r.String()).Exists() {
+		// It's already a URL or a path rather than a remote name.
+		return resolveRemoteURL(c.rewriteURL(r.String(), false))
+	}
+	// If it might be a remote name, look it up in the config.
 	config, err := LoadLocalConfig(c)
 	if err != nil {
 		return "", err
 	}
-	if strings.Index(r.String(), "://") != -1 {
+	cfg, _ := config.GetConfig(fmt.Sprintf("remote.%v.url", r))
+	if cfg == "" {
+		return "", fmt.Errorf("Unknown remote")
+	}
+	return resolveRemoteURL(c.rewriteURL(cfg, false))
+}
+
+// Converts a remote URL which is a local path into a file:// URL. Other
+// URLs are returned unmodified.
+func resolveRemoteURL(u string) (string, error) {
+	if strings.Index(u, "://") != -1 {
 		// It's already a URL
-		return string(r), nil
+		return u, nil
 	}
-	if File(r.String()).Exists() {
+	if isSCPLikeURL(u) {
+		// It's an scp-style ssh remote, which NewRemoteConn will
+		// convert when it parses the URL.
+		return u, nil
+	}
+	if File(u).Exists() {
 		// It's a known file path, so convert it to a file url
 		// and let localConn handle it.
 		// It needs to be absolute for the file:// url to work.
-		abs, err := filepath.Abs(string(r))
+		abs, err := filepath.Abs(u)
 		if err != nil {
 			return "", err
 		}
 		return "file://" + abs, nil
 	}
-	// If it might be a remote name, look it up in the config.
-	cfg, _ := config.GetConfig(fmt.Sprintf("remote.%v.url", r))
-	if cfg == "" {
-		return "", fmt.Errorf("Unknown remote")
+	return u, nil
+}
+
+// Rewrites urls according to the url.<base>.insteadOf config variables,
+// using the longest matching prefix. If push is true, url.<base>.pushInsteadOf
+// takes precedence over insteadOf.
+func (c *Client) rewriteURL(urls string, push bool) string {
+	if push {
+		if rewritten, ok := c.rewriteURLWith(urls, "pushInsteadOf"); ok {
+			return rewritten
+		}
 	}
-	if File(cfg).Exists() {
-		// The config pointed to a path but we already handled
-		// that case above, so now try it with the config setting
-		abs, err := filepath.Abs(string(cfg))
-		if err != nil {
-			return "", err
+	rewritten, _ := c.rewriteURLWith(urls, "insteadOf")
+	return rewritten
+}
+
+func (c *Client) rewriteURLWith(urls, key string) (string, bool) {
+	var base, prefix string
+	found := false
+	for _, sec := range c.configSections("url") {
+		for _, p := range sec.GetAll(key) {
+			if strings.HasPrefix(urls, p) && (!found || len(p) > len(prefix)) {
+				base, prefix, found = sec.Subsection(), p, true
+			}
 		}
-		return "file://" + abs, nil
 	}
-	return cfg, nil
+	if !found {
+		return urls, false
+	}
+	return base + strings.TrimPrefix(urls, prefix), true
 }
 
 // Returns the URL used to fetch from remote.
 func (r Remote) FetchURL(c *Client) (string, error) {
-	// FIXME: Handle fetch url config settings if they don't match url
 	return r.RemoteURL(c)
 }
 
-// Returns the URL used to push to a remote.
+// Returns the URL used to push to a remote. This is remote.<name>.pushurl
+// if set, otherwise the remote's URL with pushInsteadOf rewriting applied.
 func (r Remote) PushURL(c *Client) (string, error) {
 	if config := c.GetConfig("remote." + r.String() + ".pushurl"); config != "" {
-		return config, nil
+		return resolveRemoteURL(c.rewriteURL(config, false))
 	}
 	if config := c.GetConfig("remote." + r.String() + ".url"); config != "" {
-		return config, nil
+		return resolveRemoteURL(c.rewriteURL(config, true))
 	}
-	return r.RemoteURL(c)
+	if _, err := r.RemoteURL(c); err != nil {
+		return "", err
+	}
+	return resolveRemoteURL(c.rewriteURL(r.String(), true))
 }
 
 func (r Remote) String() string {
@@ -172,12 +212,85 @@ type RemoteConn interface {
 	Delim() error
 }
 
+// Returns true if s is an scp-style ssh remote of the form [user@]host:path.
+// As with git, it's only considered an ssh remote if there's no slash before
+// the first colon, so that local paths containing a colon can be specified
+// as ./foo:bar. A single letter host is treated as a Windows drive letter.
+func isSCPLikeURL(s string) bool {
+	if strings.Contains(s, "://") {
+		return false
+	}
+	colon := scpColon(s)
+	if colon < 0 {
+		return false
+	}
+	if strings.IndexByte(s[:colon], '/') >= 0 {
+		return false
+	}
+	host := s[:colon]
+	if at := strings.LastIndexByte(host, '@'); at >= 0 {
+		host = host[at+1:]
+	}
+	return len(host) > 1
+}
+
+// Returns the index of the colon separating the host from the path in an
+// scp-style remote, skipping over any colons in a [bracketed] IPv6 host.
+func scpColon(s string) int {
+	start := 0
+	if open := strings.IndexByte(s, '['); open >= 0 {
+		if end := strings.IndexByte(s[open:], ']'); end >= 0 {
+			start = open + end
+		}
+	}
+	if colon := strings.IndexByte(s[start:], ':'); colon >= 0 {
+		return start + colon
+	}
+	return -1
+}
+
+// Parses a remote URL, converting scp-style [user@]host:path remotes to the
+// equivalent ssh:// URL. The path of an scp-style remote is relative to the
+// user's home directory unless it starts with a slash, so a relative path
+// is converted to /~/path.
+func parseRemoteURL(urls string) (*url.URL, error) {
+	if !isSCPLikeURL(urls) {
+		return url.Parse(urls)
+	}
+	colon := scpColon(urls)
+	host, path := urls[:colon], urls[colon+1:]
+	uri := &url.URL{Scheme: "ssh"}
+	if at := strings.LastIndexByte(host, '@'); at >= 0 {
+		uri.User = url.User(host[:at])
+		host = host[at+1:]
+	}
+	// [host]:path is used for IPv6 addresses
+	uri.Host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.Contains(uri.Host, ":") {
+		uri.Host = "[" + uri.Host + "]"
+	}
+	switch {
+	case strings.HasPrefix(path, "/"):
+		uri.Path = path
+	case strings.HasPrefix(path, "~"):
+		uri.Path = "/" + path
+	default:
+		uri.Path = "/~/" + path
+	}
+	return uri, nil
+}
+
 func NewRemoteConn(c *Client, r Remote) (RemoteConn, error) {
 	urls, err := r.RemoteURL(c)
 	if err != nil {
 		return nil, err
 	}
-	uri, err := url.Parse(urls)
+	return newRemoteConnURL(c, urls)
+}
+
+// Creates a RemoteConn for the already resolved URL urls.
+func newRemoteConnURL(c *Client, urls string) (RemoteConn, error) {
+	uri, err := parseRemoteURL(urls)
 	if err != nil {
 		return nil, err
 	}
@@ -185,6 +298,7 @@ func NewRemoteConn(c *Client, r Remote) (RemoteConn, error) {
 	case "http", "https":
 		conn := &smartHTTPConn{
 			sharedRemoteConn: &sharedRemoteConn{uri: uri},
+			c:                c,
 			giturl:           urls,
 		}
 		return conn, nil
@@ -196,13 +310,19 @@ func NewRemoteConn(c *Client, r Remote) (RemoteConn, error) {
 	case "ssh":
 		return &sshConn{
 			sharedRemoteConn: &sharedRemoteConn{uri: uri},
+			c:                c,
 		}, nil
 	case "file":
+		if isBundle(uri.Path) {
+			return &bundleConn{
+				sharedRemoteConn: &sharedRemoteConn{uri: uri},
+			}, nil
+		}
 		return &localConn{
 			sharedRemoteConn: &sharedRemoteConn{uri: uri},
 		}, nil
 	default:
-		return nil, fmt.Errorf("Unsupported remote type for: %v", r)
+		return nil, fmt.Errorf("Unsupported remote type for: %v", urls)
 	}
 }
 
@@ -376,11 +496,11 @@ func RemoteShow(c *Client, opts RemoteShowOptions, r Remote, w io.Writer) error
 	}
 	var longest int
 	for _, branch := range branchconfigs {
-		if branch.values["remote"] == r.Name() {
+		if remote, _ := branch.Get("remote"); remote == r.Name() {
 			bname := struct {
 				local, remote string
 			}{local: branch.subsection}
-			if remote, ok := branch.values["merge"]; ok {
+			if remote, ok := branch.Get("merge"); !ok {
 				bname.remote = branch.subsection
 			} else {
 				bname.remote = strings.TrimPrefix(remote, "refs/heads/")
@@ -402,6 +522,25 @@ func RemoteShow(c *Client, opts RemoteShowOptions, r Remote, w io.Writer) error
 
 // Implements the "git remote get-url" command.
 func RemoteGetURL(c *Client, opts RemoteGetURLOptions, r Remote) ([]string, error) {
+	if opts.All {
+		key, push := "url", false
+		if opts.Push && len(c.GetConfigAll("remote."+r.String()+".pushurl")) > 0 {
+			key = "pushurl"
+		} else if opts.Push {
+			push = true
+		}
+		var urls []string
+		for _, u := range c.GetConfigAll(fmt.Sprintf("remote.%v.%v", r, key)) {
+			resolved, err := resolveRemoteURL(c.rewriteURL(u, push))
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, resolved)
+		}
+		if len(urls) > 0 {
+			return urls, nil
+		}
+	}
 	if opts.Push {
 		u, err := r.PushURL(c)
 		if err != nil {
@@ -415,3 +554,446 @@ func RemoteGetURL(c *Client, opts RemoteGetURLOptions, r Remote) ([]string, erro
 	}
 	return []string{u}, nil
 }
+
+// Returns an error if r is not a remote configured in the local config.
+func (r Remote) verifyConfigured(config GitConfig) error {
+	if len(config.GetConfigSections("remote", r.String())) == 0 {
+		return fmt.Errorf("No such remote: '%v'", r)
+	}
+	return nil
+}
+
+// Implements the "git remote rename" command. The remote's config section,
+// its remote-tracking refs, the default refspecs and any branches which
+// track it are all renamed.
+func RemoteRename(c *Client, opts RemoteOptions, old, new Remote) error {
+	config, err := LoadLocalConfig(c)
+	if err != nil {
+		return err
+	}
+	if err := old.verifyConfigured(config); err != nil {
+		return err
+	}
+	if len(config.GetConfigSections("remote", new.String())) != 0 {
+		return fmt.Errorf("remote %v already exists.", new)
+	}
+	if new == "" || strings.ContainsAny(new.String(), " \t\n~^:?*[\\") {
+		return fmt.Errorf("'%v' is not a valid remote name", new)
+	}
+
+	config.RenameSection("remote", old.String(), new.String())
+	fetchkey := fmt.Sprintf("remote.%v.fetch", new)
+	specs := config.GetConfigAll(fetchkey)
+	config.Unset(fetchkey)
+	for _, spec := range specs {
+		spec = strings.Replace(spec, ":refs/remotes/"+old.String()+"/", ":refs/remotes/"+new.String()+"/", 1)
+		config.AddConfig(fetchkey, spec)
+	}
+	for _, branch := range config.GetConfigSections("branch", "") {
+		for _, key := range []string{"remote", "pushRemote"} {
+			if v, ok := branch.Get(key); ok && v == old.String() {
+				config.SetConfig(fmt.Sprintf("branch.%v.%v", branch.Subsection(), key), new.String())
+			}
+		}
+	}
+	if v, _ := config.GetConfig("remote.pushDefault"); v == old.String() {
+		config.SetConfig("remote.pushDefault", new.String())
+	}
+	if err := config.WriteConfig(); err != nil {
+		return err
+	}
+
+	// Move the remote-tracking refs and their reflogs.
+	for _, dir := range []string{"refs/remotes/", "logs/refs/remotes/"} {
+		olddir := c.GitDir.File(File(dir + old.String()))
+		if !olddir.Exists() {
+			continue
+		}
+		newdir := c.GitDir.File(File(dir + new.String()))
+		if err := os.MkdirAll(filepath.Dir(newdir.String()), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(olddir.String(), newdir.String()); err != nil {
+			return err
+		}
+	}
+	// Rename any remote-tracking refs which were packed.
+	packed, err := readPackedRefs(c)
+	if err != nil {
+		return err
+	}
+	oldprefix, newprefix := "refs/remotes/"+old.String()+"/", "refs/remotes/"+new.String()+"/"
+	renamed := false
+	for i, ref := range packed {
+		if strings.HasPrefix(ref.Name, oldprefix) {
+			packed[i].Name = newprefix + strings.TrimPrefix(ref.Name, oldprefix)
+			renamed = true
+		}
+	}
+	if renamed {
+		if err := writePackedRefs(c, packed); err != nil {
+			return err
+		}
+	}
+
+	head := SymbolicRef("refs/remotes/" + new.String() + "/HEAD")
+	if target, err := SymbolicRefGet(c, SymbolicRefOptions{}, head); err == nil {
+		if target.HasPrefix(oldprefix) {
+			newtarget := RefSpec(newprefix + strings.TrimPrefix(target.String(), oldprefix))
+			if err := SymbolicRefUpdate(c, SymbolicRefOptions{}, head, newtarget, ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Implements the "git remote remove" command. The remote's config, its
+// remote-tracking refs, and the upstream configuration of any branches
+// tracking it are removed.
+func RemoteRemove(c *Client, opts RemoteOptions, r Remote) error {
+	config, err := LoadLocalConfig(c)
+	if err != nil {
+		return err
+	}
+	if err := r.verifyConfigured(config); err != nil {
+		return err
+	}
+	specs := config.GetConfigAll(fmt.Sprintf("remote.%v.fetch", r))
+	config.RemoveSection("remote", r.String())
+	for _, branch := range config.GetConfigSections("branch", "") {
+		if v, ok := branch.Get("remote"); ok && v == r.String() {
+			config.Unset(fmt.Sprintf("branch.%v.remote", branch.Subsection()))
+			config.Unset(fmt.Sprintf("branch.%v.merge", branch.Subsection()))
+		}
+		if v, ok := branch.Get("pushRemote"); ok && v == r.String() {
+			config.Unset(fmt.Sprintf("branch.%v.pushRemote", branch.Subsection()))
+		}
+	}
+	if v, _ := config.GetConfig("remote.pushDefault"); v == r.String() {
+		config.Unset("remote.pushDefault")
+	}
+	if err := config.WriteConfig(); err != nil {
+		return err
+	}
+
+	localrefs, err := ShowRef(c, ShowRefOptions{}, nil)
+	if err != nil {
+		return err
+	}
+	head := c.GitDir.File(File("refs/remotes/" + r.String() + "/HEAD"))
+	if head.Exists() {
+		if err := head.Remove(); err != nil {
+			return err
+		}
+	}
+	var deleted []string
+	for _, ref := range localrefs {
+		for _, spec := range specs {
+			if strings.HasPrefix(spec, "^") || RefSpec(spec).Dst() == "" {
+				continue
+			}
+			if _, ok := matchRefGlob(string(RefSpec(spec).Dst()), ref.Name); ok {
+				if f := c.GitDir.File(File(ref.Name)); f.Exists() {
+					if err := f.Remove(); err != nil {
+						return err
+					}
+				}
+				deleted = append(deleted, ref.Name)
+				break
+			}
+		}
+	}
+	// Remove all the packed refs at once, rather than rewriting the
+	// packed-refs file for each one.
+	if err := removePackedRefs(c, deleted...); err != nil {
+		return err
+	}
+	// Clean up the directories, if they're now empty.
+	os.Remove(c.GitDir.File(File("refs/remotes/" + r.String())).String())
+	os.RemoveAll(c.GitDir.File(File("logs/refs/remotes/" + r.String())).String())
+	return nil
+}
+
+type RemoteSetURLOptions struct {
+	RemoteOptions
+
+	// Manipulate the push URLs instead of the fetch URLs.
+	Push bool
+
+	// Add a URL instead of changing the existing one.
+	Add bool
+
+	// Delete all URLs matching the regex instead of changing one.
+	Delete bool
+}
+
+// Implements the "git remote set-url" command. oldurl is a regular
+// expression. If it's set, the first URL matching it is replaced with
+// newurl, otherwise all URLs are replaced. With the Delete option, newurl
+// is the regular expression of the URLs to delete.
+func RemoteSetURL(c *Client, opts RemoteSetURLOptions, r Remote, newurl, oldurl string) error {
+	config, err := LoadLocalConfig(c)
+	if err != nil {
+		return err
+	}
+	if err := r.verifyConfigured(config); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("remote.%v.url", r)
+	if opts.Push {
+		key = fmt.Sprintf("remote.%v.pushurl", r)
+	}
+	if opts.Add {
+		config.AddConfig(key, newurl)
+		return config.WriteConfig()
+	}
+
+	pattern := oldurl
+	if opts.Delete {
+		pattern = newurl
+	}
+	urls := config.GetConfigAll(key)
+	if pattern == "" {
+		config.SetConfig(key, newurl)
+		return config.WriteConfig()
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Invalid old URL pattern: %v", pattern)
+	}
+	var kept []string
+	found := false
+	for _, u := range urls {
+		if !re.MatchString(u) {
+			kept = append(kept, u)
+			continue
+		}
+		if opts.Delete {
+			found = true
+			continue
+		}
+		if !found {
+			found = true
+			kept = append(kept, newurl)
+		} else {
+			kept = append(kept, u)
+		}
+	}
+	if !found {
+		return fmt.Errorf("No such URL found: %v", pattern)
+	}
+	if opts.Delete && !opts.Push && len(kept) == 0 {
+		return fmt.Errorf("Will not delete all non-push URLs")
+	}
+	config.Unset(key)
+	for _, u := range kept {
+		config.AddConfig(key, u)
+	}
+	return config.WriteConfig()
+}
+
+type RemoteSetHeadOptions struct {
+	RemoteOptions
+
+	// Query the remote to determine its HEAD.
+	Auto bool
+
+	// Delete the refs/remotes/<name>/HEAD symbolic ref.
+	Delete bool
+}
+
+// Implements the "git remote set-head" command, setting the default branch
+// for the remote r to branch (or the remote's HEAD if opts.Auto is set.) It
+// returns the branch that refs/remotes/<name>/HEAD was set to.
+func RemoteSetHead(c *Client, opts RemoteSetHeadOptions, r Remote, branch string) (string, error) {
+	head := SymbolicRef("refs/remotes/" + r.String() + "/HEAD")
+	if opts.Delete {
+		if !c.GitDir.File(File(head)).Exists() {
+			return "", nil
+		}
+		return "", SymbolicRefDelete(c, SymbolicRefOptions{}, head)
+	}
+	if opts.Auto {
+		config, err := LoadLocalConfig(c)
+		if err != nil {
+			return "", err
+		}
+		if err := r.verifyConfigured(config); err != nil {
+			return "", err
+		}
+		_, rmthead, err := lsRemoteHead(c, r)
+		if err != nil {
+			return "", err
+		}
+		if rmthead == "" {
+			return "", fmt.Errorf("Cannot determine remote HEAD")
+		}
+		branch = strings.TrimPrefix(rmthead, "refs/heads/")
+	}
+	if branch == "" {
+		return "", fmt.Errorf("Must specify a branch or --auto")
+	}
+	target := RefSpec("refs/remotes/" + r.String() + "/" + branch)
+	if !refExists(c, string(target)) {
+		return "", fmt.Errorf("Not a valid ref: %v", target)
+	}
+	return branch, SymbolicRefUpdate(c, SymbolicRefOptions{}, head, target, "remote set-head")
+}
+
+// Lists the refs on the remote r, and returns the branch that the
+// remote's HEAD points to.
+func lsRemoteHead(c *Client, r Remote) ([]Ref, string, error) {
+	conn, err := NewRemoteConn(c, r)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := conn.SetService("git-upload-pack"); err != nil {
+		return nil, "", err
+	}
+	if err := conn.OpenConn(UploadPackService); err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+	advertised, err := conn.GetRefs(LsRemoteOptions{SymRef: true, RefsOnly: true}, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	refs, _ := splitPeeledRefs(advertised)
+	return refs, remoteHead(conn, advertised, refs), nil
+}
+
+// Returns the branch that the remote's HEAD points to, given the refs that
+// were advertised over conn and the same refs after splitPeeledRefs. The
+// branch comes from the symref capability (protocol v1) or symref-target
+// attribute (protocol v2) if the remote reports it, and is otherwise guessed
+// from the branches with the same value as HEAD, preferring master.
+func remoteHead(conn RemoteConn, advertised, refs []Ref) string {
+	var head string
+	for symref := range conn.Capabilities()["symref"] {
+		if strings.HasPrefix(symref, "HEAD:") {
+			head = strings.TrimPrefix(symref, "HEAD:")
+		}
+	}
+	for _, ref := range advertised {
+		fields := strings.Fields(ref.Name)
+		if len(fields) < 2 || fields[0] != "HEAD" {
+			continue
+		}
+		for _, attr := range fields[1:] {
+			if strings.HasPrefix(attr, "symref-target:") {
+				head = strings.TrimPrefix(attr, "symref-target:")
+			}
+		}
+	}
+	if head != "" {
+		return head
+	}
+
+	var headval Sha1
+	found := false
+	for _, ref := range refs {
+		if ref.Name == "HEAD" {
+			headval, found = ref.Value, true
+		}
+	}
+	if !found {
+		return ""
+	}
+	for _, ref := range refs {
+		if ref.Value != headval || !strings.HasPrefix(ref.Name, "refs/heads/") {
+			continue
+		}
+		if head == "" || ref.Name == "refs/heads/master" {
+			head = ref.Name
+		}
+	}
+	return head
+}
+
+type RemotePruneOptions struct {
+	RemoteOptions
+
+	// Report what would be pruned without deleting anything.
+	DryRun bool
+}
+
+// Implements the "git remote prune" command, deleting the remote-tracking
+// refs of r which no longer exist on the remote. It returns the refs which
+// were (or with DryRun, would be) deleted.
+func RemotePrune(c *Client, opts RemotePruneOptions, r Remote) ([]Refname, error) {
+	config, err := LoadLocalConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.verifyConfigured(config); err != nil {
+		return nil, err
+	}
+	rmtrefs, _, err := lsRemoteHead(c, r)
+	if err != nil {
+		return nil, err
+	}
+	var specs []RefSpec
+	for _, spec := range config.GetConfigAll(fmt.Sprintf("remote.%v.fetch", r)) {
+		specs = append(specs, RefSpec(spec))
+	}
+	stale, err := staleFetchRefs(c, rmtrefs, specs)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		return stale, nil
+	}
+	for _, ref := range stale {
+		if err := UpdateRef(c, UpdateRefOptions{Delete: true}, string(ref), CommitID{}, ""); err != nil {
+			return nil, err
+		}
+	}
+	return stale, nil
+}
+
+type RemoteSetBranchesOptions struct {
+	RemoteOptions
+
+	// Add to the tracked branches instead of replacing them.
+	Add bool
+}
+
+// Implements the "git remote set-branches" command, changing the branches
+// tracked by r's fetch refspecs.
+func RemoteSetBranches(c *Client, opts RemoteSetBranchesOptions, r Remote, branches []string) error {
+	config, err := LoadLocalConfig(c)
+	if err != nil {
+		return err
+	}
+	if err := r.verifyConfigured(config); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("remote.%v.fetch", r)
+	if !opts.Add {
+		config.Unset(key)
+	}
+	for _, branch := range branches {
+		config.AddConfig(key, fmt.Sprintf("+refs/heads/%v:refs/remotes/%v/%v", branch, r, branch))
+	}
+	return config.WriteConfig()
+}
+
+// Returns the remotes in the group named name from the remotes.<name> config
+// variables. If name is a configured remote, or there is no such group, it's
+// treated as a single remote.
+func RemoteGroup(c *Client, name string) []Remote {
+	if c.GetConfig("remote."+name+".url") != "" {
+		return []Remote{Remote(name)}
+	}
+	var remotes []Remote
+	for _, group := range c.GetConfigAll("remotes." + name) {
+		for _, r := range strings.Fields(group) {
+			remotes = append(remotes, Remote(r))
+		}
+	}
+	if len(remotes) == 0 {
+		return []Remote{Remote(name)}
+	}
+	return remotes
+}
@@ -0,0 +1,243 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRewriteURL(t *testing.T) {
+	c := &Client{}
+	c.SetCachedConfig("url.https://github.com/.insteadOf", "gh:")
+	c.SetCachedConfig("url.https://github.com/driusan/.insteadOf", "gh:driusan/")
+	c.SetCachedConfig("url.git@github.com:.pushInsteadOf", "https://github.com/")
+
+	tests := []struct {
+		URL  string
+		Push bool
+		Want string
+	}{
+		{"gh:foo/bar", false, "https://github.com/foo/bar"},
+		// The longest match wins
+		{"gh:driusan/dgit", false, "https://github.com/driusan/dgit"},
+		{"https://github.com/foo/bar", false, "https://github.com/foo/bar"},
+		{"https://github.com/foo/bar", true, "git@github.com:foo/bar"},
+		// pushInsteadOf isn't applied to the result of insteadOf
+		{"gh:foo/bar", true, "https://github.com/foo/bar"},
+		{"https://example.com/foo", true, "https://example.com/foo"},
+	}
+	for _, test := range tests {
+		if got := c.rewriteURL(test.URL, test.Push); got != test.Want {
+			t.Errorf("rewriteURL(%q, %v): got %q want %q", test.URL, test.Push, got, test.Want)
+		}
+	}
+}
+
+func TestRemoteSubcommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitremote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcdir := filepath.Join(dir, "src")
+	src, err := Init(nil, InitOptions{Quiet: true}, srcdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcdir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(src, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := Commit(src, CommitOptions{}, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef(src, UpdateRefOptions{}, "refs/heads/gone", cmt, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	dstdir := filepath.Join(dir, "dst.git")
+	if _, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dstdir); err != nil {
+		t.Fatal(err)
+	}
+	// Create a new client whenever the config is changed, so that
+	// nothing is cached.
+	client := func() *Client {
+		c, err := NewClient(dstdir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	if err := RemoteAdd(client(), RemoteAddOptions{}, "origin", srcdir); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadLocalConfig(client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfig("branch.master.remote", "origin")
+	if err := config.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Fetch(client(), FetchOptions{FetchPackOptions: FetchPackOptions{Quiet: true}}, "origin", nil); err != nil {
+		t.Fatal(err)
+	}
+	if branch, err := RemoteSetHead(client(), RemoteSetHeadOptions{Auto: true}, "origin", ""); err != nil || branch != "master" {
+		t.Errorf("Unexpected remote HEAD: %v (%v)", branch, err)
+	}
+
+	if err := RemoteRename(client(), RemoteOptions{}, "origin", "upstream"); err != nil {
+		t.Fatal(err)
+	}
+	c := client()
+	if got := c.GetConfig("branch.master.remote"); got != "upstream" {
+		t.Errorf("Branch upstream was not renamed: got %v", got)
+	}
+	if got, want := c.GetConfigAll("remote.upstream.fetch"), []string{"+refs/heads/*:refs/remotes/upstream/*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected refspecs after rename: got %v want %v", got, want)
+	}
+	if !Refname("refs/remotes/upstream/gone").Exists(c) || Refname("refs/remotes/origin/gone").Exists(c) {
+		t.Errorf("Remote-tracking refs were not renamed")
+	}
+	if target, err := SymbolicRefGet(c, SymbolicRefOptions{}, "refs/remotes/upstream/HEAD"); err != nil || target != "refs/remotes/upstream/master" {
+		t.Errorf("Unexpected remote HEAD after rename: %v (%v)", target, err)
+	}
+
+	if err := RemoteSetURL(client(), RemoteSetURLOptions{Add: true}, "upstream", "https://example.com/repo", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoteSetURL(client(), RemoteSetURLOptions{}, "upstream", "https://example.com/other", "example"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := client().GetConfigAll("remote.upstream.url"), []string{srcdir, "https://example.com/other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected URLs: got %v want %v", got, want)
+	}
+	if err := RemoteSetURL(client(), RemoteSetURLOptions{Delete: true}, "upstream", ".", ""); err == nil {
+		t.Errorf("Expected error deleting all URLs")
+	}
+	if err := RemoteSetURL(client(), RemoteSetURLOptions{Delete: true}, "upstream", "example", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Prune the branch that was deleted on the remote.
+	if err := UpdateRef(src, UpdateRefOptions{Delete: true}, "refs/heads/gone", CommitID{}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	pruned, err := RemotePrune(client(), RemotePruneOptions{DryRun: true}, "upstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Refname{"refs/remotes/upstream/gone"}; !reflect.DeepEqual(pruned, want) {
+		t.Errorf("Unexpected pruned refs: got %v want %v", pruned, want)
+	}
+	if !Refname("refs/remotes/upstream/gone").Exists(client()) {
+		t.Errorf("Ref was deleted by dry run")
+	}
+	if _, err := RemotePrune(client(), RemotePruneOptions{}, "upstream"); err != nil {
+		t.Fatal(err)
+	}
+	if Refname("refs/remotes/upstream/gone").Exists(client()) {
+		t.Errorf("Ref was not pruned")
+	}
+
+	if err := RemoteSetBranches(client(), RemoteSetBranchesOptions{}, "upstream", []string{"master"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := client().GetConfigAll("remote.upstream.fetch"), []string{"+refs/heads/master:refs/remotes/upstream/master"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected refspecs after set-branches: got %v want %v", got, want)
+	}
+
+	if err := RemoteRemove(client(), RemoteOptions{}, "upstream"); err != nil {
+		t.Fatal(err)
+	}
+	c = client()
+	if remotes, err := RemoteList(c, RemoteOptions{}); err != nil || len(remotes) != 0 {
+		t.Errorf("Unexpected remotes after remove: %v (%v)", remotes, err)
+	}
+	if got := c.GetConfig("branch.master.remote"); got != "" {
+		t.Errorf("Branch upstream was not removed: got %v", got)
+	}
+	if Refname("refs/remotes/upstream/master").Exists(c) || Refname("refs/remotes/upstream/HEAD").Exists(c) {
+		t.Errorf("Remote-tracking refs were not removed")
+	}
+}
+
+func TestRemotePackedRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitremotepacked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir); err != nil {
+		t.Fatal(err)
+	}
+	client := func() *Client {
+		c, err := NewClient(dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	if err := RemoteAdd(client(), RemoteAddOptions{}, "origin", "https://example.com/repo"); err != nil {
+		t.Fatal(err)
+	}
+	packed := "# pack-refs with: peeled fully-peeled sorted \n" +
+		"1111111111111111111111111111111111111111 refs/heads/master\n" +
+		"1111111111111111111111111111111111111111 refs/remotes/origin/master\n" +
+		"2222222222222222222222222222222222222222 refs/remotes/origin/topic\n" +
+		"3333333333333333333333333333333333333333 refs/remotes/originals/master\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "packed-refs"), []byte(packed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	refnames := func() []string {
+		refs, err := ShowRef(client(), ShowRefOptions{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, ref := range refs {
+			names = append(names, ref.Name)
+		}
+		return names
+	}
+
+	// The remote-tracking refs of a clone are only in packed-refs.
+	if branch, err := RemoteSetHead(client(), RemoteSetHeadOptions{}, "origin", "topic"); err != nil || branch != "topic" {
+		t.Fatalf("Unexpected result from set-head: %v (%v)", branch, err)
+	}
+	if sha, err := RefSpec("refs/remotes/origin/HEAD").Sha1(client()); err != nil || sha.String() != "2222222222222222222222222222222222222222" {
+		t.Errorf("Unexpected value for remote HEAD: %v (%v)", sha, err)
+	}
+	if _, err := RemoteSetHead(client(), RemoteSetHeadOptions{Delete: true}, "origin", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RemoteRename(client(), RemoteOptions{}, "origin", "upstream"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"refs/heads/master", "refs/remotes/originals/master", "refs/remotes/upstream/master", "refs/remotes/upstream/topic"}
+	if got := refnames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected refs after rename: got %v want %v", got, want)
+	}
+	if sha, err := RefSpec("refs/remotes/upstream/topic").Sha1(client()); err != nil || sha.String() != "2222222222222222222222222222222222222222" {
+		t.Errorf("Unexpected value for renamed ref: %v (%v)", sha, err)
+	}
+
+	if err := RemoteRemove(client(), RemoteOptions{}, "upstream"); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"refs/heads/master", "refs/remotes/originals/master"}
+	if got := refnames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected refs after remove: got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,719 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// The default scores of DiffCommonOptions for -B: modifications
+	// which remove 50% of a file are broken up, and shown as rewrites if
+	// they remove 60%.
+	defaultBreakScore = maxSimilarityScore / 2
+	defaultMergeScore = maxSimilarityScore * 3 / 5
+
+	// Files smaller than this are never broken up by -B.
+	minimumBreakSize = 400
+
+	// The number of possible sources remembered for each destination
+	// when looking for inexact renames.
+	renameCandidates = 4
+)
+
+// A diffPair is a HashDiff which is being considered by rename detection.
+type diffPair struct {
+	HashDiff
+
+	// Whether the pair is half of a modification which was broken up
+	// by -B, or an unmodified file which is only a possible source of
+	// copies.
+	broken, unmodified bool
+}
+
+// A renameSrc is a file which may have been renamed or copied.
+type renameSrc struct {
+	path  IndexPath
+	entry TreeEntry
+	size  uint
+
+	// The score of a broken modification, which is kept if it's put
+	// back together.
+	score int
+
+	unmodified bool
+}
+
+// A renameDst is a file which may be the result of a rename or copy.
+type renameDst struct {
+	path  IndexPath
+	entry TreeEntry
+	size  uint
+
+	// The rename or copy which created the file, once one is found.
+	pair *HashDiff
+}
+
+// A renameScore is a possible rename from srcs[src] to dsts[dst].
+type renameScore struct {
+	dst, src         int
+	score, nameScore int
+}
+
+// A renameDetector finds renames, copies and rewrites in the same way as
+// git's diffcore.
+type renameDetector struct {
+	c    *Client
+	opts DiffCommonOptions
+
+	// The contents and similarity hashes of files, keyed by their hash,
+	// or by their path for files in the work tree.
+	contents map[string]loadedFile
+
+	// The number of times each source path is used by a rename or a
+	// copy, or by staying where it was.
+	used map[IndexPath]int
+}
+
+type loadedFile struct {
+	sha    Sha1
+	data   []byte
+	hashes map[uint32]int
+}
+
+// Returns the rename detection options which the porcelain commands that
+// show diffs (diff, log, show and status) use by default, from the
+// diff.renames and diff.renameLimit config. Unlike the plumbing commands,
+// they detect renames unless diff.renames is false.
+func DiffRenameOptions(c *Client) DiffCommonOptions {
+	return renameOptions(c.GetConfig("diff.renames"), c.GetConfig("diff.renamelimit"))
+}
+
+// Returns the rename options for the value of a renames config variable
+// and a renameLimit config variable.
+func renameOptions(renames, limit string) DiffCommonOptions {
+	opts := DiffCommonOptions{DetectRenames: true, RenameLimit: 1000}
+	switch renames {
+	case "false", "no", "off", "0":
+		opts.DetectRenames = false
+	case "copies", "copy":
+		opts.DetectCopies = true
+	}
+	if n, err := strconv.Atoi(limit); err == nil {
+		opts.RenameLimit = n
+	}
+	return opts
+}
+
+// Detects the renames, copies and rewrites in diffs which are enabled in
+// opts, and returns the resulting diffs sorted by name. unmodified are the
+// unmodified files, which are only used by opts.FindCopiesHarder.
+func detectRenames(c *Client, opts DiffCommonOptions, diffs, unmodified []HashDiff) ([]HashDiff, error) {
+	if opts.FindCopiesHarder {
+		opts.DetectCopies = true
+	}
+	if opts.DetectCopies {
+		opts.DetectRenames = true
+	}
+	if !opts.DetectRenames && !opts.BreakRewrites {
+		return diffs, nil
+	}
+	d := &renameDetector{
+		c:        c,
+		opts:     opts,
+		contents: make(map[string]loadedFile),
+		used:     make(map[IndexPath]int),
+	}
+
+	q := make([]diffPair, 0, len(diffs)+len(unmodified))
+	for _, diff := range diffs {
+		q = append(q, diffPair{HashDiff: diff})
+	}
+	if opts.FindCopiesHarder {
+		for _, diff := range unmodified {
+			q = append(q, diffPair{HashDiff: diff, unmodified: true})
+		}
+	}
+	sort.SliceStable(q, func(i, j int) bool { return q[i].Name < q[j].Name })
+
+	var err error
+	if opts.BreakRewrites {
+		if q, err = d.breakRewrites(q); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DetectRenames {
+		if q, err = d.findRenames(q); err != nil {
+			return nil, err
+		}
+	}
+	if opts.BreakRewrites {
+		q = d.mergeBroken(q)
+	}
+
+	var val []HashDiff
+	for _, p := range q {
+		if p.unmodified {
+			continue
+		}
+		h := p.HashDiff
+		if h.OldName != "" {
+			// Every use of a source but the last is a copy, and the
+			// last is a rename unless the source is still there.
+			d.used[h.OldName]--
+			h.copied = d.used[h.OldName] > 0
+		}
+		val = append(val, h)
+	}
+	return val, nil
+}
+
+// Returns the contents of the file at path with the entry e, and its hash.
+func (d *renameDetector) load(path IndexPath, e TreeEntry) (loadedFile, error) {
+	key := e.Sha1.String()
+	if e.Sha1 == (Sha1{}) {
+		key = "/" + path.String()
+	}
+	if f, ok := d.contents[key]; ok {
+		return f, nil
+	}
+	sha, data, err := HashDiff{Name: path}.contents(d.c, e)
+	if err != nil {
+		return loadedFile{}, err
+	}
+	f := loadedFile{sha: sha, data: data}
+	d.contents[key] = f
+	return f, nil
+}
+
+// Returns the hash of the file at path with the entry e, without reading
+// it unless it's in the work tree.
+func (d *renameDetector) hash(path IndexPath, e TreeEntry) (Sha1, error) {
+	if e.Sha1 != (Sha1{}) || !isBlobMode(e.FileMode) {
+		return e.Sha1, nil
+	}
+	f, err := d.load(path, e)
+	return f.sha, err
+}
+
+// Returns the similarity hashes of the file at path with the entry e.
+func (d *renameDetector) similarityHashes(path IndexPath, e TreeEntry) (map[uint32]int, error) {
+	f, err := d.load(path, e)
+	if err != nil {
+		return nil, err
+	}
+	if f.hashes == nil {
+		f.hashes = similarityHashes(f.data)
+		key := e.Sha1.String()
+		if e.Sha1 == (Sha1{}) {
+			key = "/" + path.String()
+		}
+		d.contents[key] = f
+	}
+	return f.hashes, nil
+}
+
+// Splits the modifications in q which rewrite most of a file into a
+// deletion and a creation, so that each can be part of a rename.
+func (d *renameDetector) breakRewrites(q []diffPair) ([]diffPair, error) {
+	breakScore, mergeScore := d.opts.BreakScore, d.opts.MergeScore
+	if breakScore == 0 {
+		breakScore = defaultBreakScore
+	}
+	if mergeScore == 0 {
+		mergeScore = defaultMergeScore
+	}
+	val := make([]diffPair, 0, len(q))
+	for _, p := range q {
+		if p.unmodified || !isBlobMode(p.Src.FileMode) || !isBlobMode(p.Dst.FileMode) {
+			val = append(val, p)
+			continue
+		}
+		brk, score, err := d.shouldBreak(p, breakScore)
+		if err != nil {
+			return nil, err
+		}
+		if !brk {
+			val = append(val, p)
+			continue
+		}
+		// Rewrites which don't remove enough of the file to be shown
+		// as one are shown as modifications if they aren't renamed.
+		if score < mergeScore {
+			score = 0
+		}
+		val = append(val,
+			diffPair{HashDiff: HashDiff{Name: p.Name, Src: p.Src, SrcSize: p.SrcSize, Score: score}, broken: true},
+			diffPair{HashDiff: HashDiff{Name: p.Name, Dst: p.Dst, DstSize: p.DstSize, Score: score}, broken: true},
+		)
+	}
+	return val, nil
+}
+
+// Returns whether the modification p changes enough of a file to be
+// broken up, and the amount of the source which was removed.
+func (d *renameDetector) shouldBreak(p diffPair, breakScore int) (bool, int, error) {
+	if isRegularMode(p.Src.FileMode) != isRegularMode(p.Dst.FileMode) {
+		return true, maxSimilarityScore, nil
+	}
+	if p.Src.Sha1 == p.Dst.Sha1 {
+		return false, 0, nil
+	}
+	src, err := d.load(p.Name, p.Src)
+	if err != nil {
+		return false, 0, err
+	}
+	dst, err := d.load(p.Name, p.Dst)
+	if err != nil {
+		return false, 0, err
+	}
+	srcSize, dstSize := len(src.data), len(dst.data)
+	if max(srcSize, dstSize) < minimumBreakSize || srcSize == 0 {
+		return false, 0, nil
+	}
+
+	copied, added := countChanges(src.data, dst.data)
+	if copied > srcSize {
+		copied = srcSize
+	}
+	if dstSize < added+copied {
+		if copied < dstSize {
+			added = dstSize - copied
+		} else {
+			added = 0
+		}
+	}
+	removed := srcSize - copied
+	score := removed * maxSimilarityScore / srcSize
+	if score > breakScore {
+		return true, score, nil
+	}
+
+	// Extensive changes which aren't mostly removals are also broken up.
+	if (removed+added)*maxSimilarityScore/max(srcSize, dstSize) < breakScore {
+		return false, score, nil
+	}
+	if srcSize*breakScore < removed*maxSimilarityScore && added*20 < removed && added*20 < copied {
+		return false, score, nil
+	}
+	return true, score, nil
+}
+
+// Puts the broken modifications in q which weren't renamed back together.
+func (d *renameDetector) mergeBroken(q []diffPair) []diffPair {
+	val := make([]diffPair, 0, len(q))
+	merged := make([]bool, len(q))
+outer:
+	for i, p := range q {
+		if merged[i] {
+			continue
+		}
+		if p.broken {
+			for j := i + 1; j < len(q); j++ {
+				if merged[j] || !q[j].broken || q[j].Name != p.Name {
+					continue
+				}
+				del, cre := p, q[j]
+				if del.Src.FileMode == 0 {
+					del, cre = cre, del
+				}
+				val = append(val, diffPair{HashDiff: HashDiff{
+					Name:    p.Name,
+					Src:     del.Src,
+					Dst:     cre.Dst,
+					SrcSize: del.SrcSize,
+					DstSize: cre.DstSize,
+					Score:   p.Score,
+				}})
+				merged[j] = true
+				// The source stays where it was, so any other
+				// users of it are copies.
+				d.used[p.Name]++
+				continue outer
+			}
+		}
+		val = append(val, p)
+	}
+	return val
+}
+
+// Finds the renames and copies in q, which must be sorted by name, and
+// replaces the pairs which they came from.
+func (d *renameDetector) findRenames(q []diffPair) ([]diffPair, error) {
+	copies := d.opts.DetectCopies
+	var srcs []renameSrc
+	var dsts []renameDst
+	dstOf := make(map[int]int)
+	brokenDst := make(map[IndexPath]int)
+	for i, p := range q {
+		switch {
+		case p.Src.FileMode == 0 && p.Dst.FileMode != 0:
+			if p.broken {
+				brokenDst[p.Name] = len(dsts)
+			}
+			dstOf[i] = len(dsts)
+			dsts = append(dsts, renameDst{path: p.Name, entry: p.Dst, size: p.DstSize})
+		case p.Src.FileMode != 0 && p.Dst.FileMode == 0:
+			// A broken deletion which isn't shown as a rewrite is
+			// used by the creation at the same path, so it's only
+			// copied if something else takes it.
+			if p.broken && p.Score == 0 {
+				d.used[p.Name]++
+			}
+			srcs = append(srcs, renameSrc{path: p.Name, entry: p.Src, size: p.SrcSize, score: p.Score})
+		case copies && p.Src.FileMode != 0:
+			d.used[p.Name]++
+			srcs = append(srcs, renameSrc{path: p.Name, entry: p.Src, size: p.SrcSize, unmodified: p.unmodified})
+		}
+	}
+	if len(srcs) > 0 && len(dsts) > 0 {
+		if err := d.findAllRenames(srcs, dsts, len(brokenDst) > 0); err != nil {
+			return nil, err
+		}
+	}
+
+	val := make([]diffPair, 0, len(q))
+	for i, p := range q {
+		switch {
+		case p.unmodified:
+		case p.Src.FileMode == 0 && p.Dst.FileMode != 0:
+			if pair := dsts[dstOf[i]].pair; pair != nil {
+				val = append(val, diffPair{HashDiff: *pair})
+			} else {
+				val = append(val, p)
+			}
+		case p.Src.FileMode != 0 && p.Dst.FileMode == 0:
+			if p.broken {
+				if j, ok := brokenDst[p.Name]; ok && dsts[j].pair != nil {
+					// The other half was renamed, or went back
+					// together with this one.
+					continue
+				}
+			} else if d.used[p.Name] > 0 {
+				continue
+			}
+			val = append(val, p)
+		default:
+			val = append(val, p)
+		}
+	}
+	return val, nil
+}
+
+// Finds the sources of the destinations in dsts. Exact renames are found
+// first, then renames between files with the same basename, and then the
+// most similar files.
+func (d *renameDetector) findAllRenames(srcs []renameSrc, dsts []renameDst, broken bool) error {
+	copies := d.opts.DetectCopies
+	for i := range dsts {
+		if err := d.findExactRename(srcs, dsts, i); err != nil {
+			return err
+		}
+	}
+	if !copies {
+		srcs = d.unusedSources(srcs)
+	}
+
+	minScore := d.opts.RenameScore
+	if minScore == 0 {
+		minScore = defaultRenameScore
+	}
+	if minScore == maxSimilarityScore {
+		return nil
+	}
+	if !copies && !broken {
+		if err := d.findBasenameRenames(srcs, dsts, minScore+(maxSimilarityScore-minScore)/2); err != nil {
+			return err
+		}
+		srcs = d.unusedSources(srcs)
+	}
+
+	numDsts := 0
+	for _, dst := range dsts {
+		if dst.pair == nil {
+			numDsts++
+		}
+	}
+	if numDsts == 0 || len(srcs) == 0 {
+		return nil
+	}
+	skipUnmodified := false
+	limit := d.opts.RenameLimit
+	if limit <= 0 {
+		limit = 32767
+	}
+	if numDsts*len(srcs) > limit*limit {
+		needed := max(numDsts, len(srcs))
+		modified := 0
+		for _, src := range srcs {
+			if !src.unmodified {
+				modified++
+			}
+		}
+		if d.opts.FindCopiesHarder && numDsts*modified <= limit*limit {
+			fmt.Fprintln(os.Stderr, "warning: only found copies from modified paths due to too many files.")
+			skipUnmodified = true
+		} else {
+			fmt.Fprintln(os.Stderr, "warning: exhaustive rename detection was skipped due to too many files.")
+		}
+		fmt.Fprintf(os.Stderr, "warning: you may want to set your diff.renameLimit variable to at least %d and retry the command.\n", needed)
+		if !skipUnmodified {
+			return nil
+		}
+	}
+
+	var scores []renameScore
+	for i, dst := range dsts {
+		if dst.pair != nil {
+			continue
+		}
+		best := make([]renameScore, renameCandidates)
+		for j := range best {
+			best[j].dst = -1
+		}
+		for j, src := range srcs {
+			if skipUnmodified && src.unmodified {
+				continue
+			}
+			score, err := d.estimateSimilarity(src, dst, minScore)
+			if err != nil {
+				return err
+			}
+			recordIfBetter(best, renameScore{
+				dst:       i,
+				src:       j,
+				score:     score,
+				nameScore: basenameSame(src.path, dst.path),
+			})
+		}
+		scores = append(scores, best...)
+	}
+	sort.SliceStable(scores, func(i, j int) bool {
+		return compareRenameScores(scores[i], scores[j]) < 0
+	})
+	d.assignRenames(scores, srcs, dsts, minScore, false)
+	if copies {
+		d.assignRenames(scores, srcs, dsts, minScore, true)
+	}
+	return nil
+}
+
+// Returns the sources in srcs which haven't been used.
+func (d *renameDetector) unusedSources(srcs []renameSrc) []renameSrc {
+	var val []renameSrc
+	for _, src := range srcs {
+		if d.used[src.path] == 0 {
+			val = append(val, src)
+		}
+	}
+	return val
+}
+
+// Finds the best source of dsts[i] which is identical to it, preferring
+// sources which haven't been used yet and which have the same basename.
+func (d *renameDetector) findExactRename(srcs []renameSrc, dsts []renameDst, i int) error {
+	dst := dsts[i]
+	dstSha, err := d.hash(dst.path, dst.entry)
+	if err != nil {
+		return err
+	}
+	if dstSha == (Sha1{}) {
+		return nil
+	}
+	best, bestScore := -1, -1
+	for j, src := range srcs {
+		srcSha, err := d.hash(src.path, src.entry)
+		if err != nil {
+			return err
+		}
+		if srcSha != dstSha {
+			continue
+		}
+		// Symlinks and other special files must keep their type.
+		if (!isRegularMode(src.entry.FileMode) || !isRegularMode(dst.entry.FileMode)) && src.entry.FileMode != dst.entry.FileMode {
+			continue
+		}
+		score := 0
+		if d.used[src.path] == 0 {
+			score = 1
+		} else if !d.opts.DetectCopies {
+			continue
+		}
+		score += basenameSame(src.path, dst.path)
+		if score > bestScore {
+			best, bestScore = j, score
+			if score == 2 {
+				break
+			}
+		}
+	}
+	if best >= 0 {
+		d.recordRename(srcs, dsts, best, i, maxSimilarityScore)
+	}
+	return nil
+}
+
+// Pairs up the sources and destinations which are the only ones with
+// their basename, if they're at least minScore similar.
+func (d *renameDetector) findBasenameRenames(srcs []renameSrc, dsts []renameDst, minScore int) error {
+	srcByBase := make(map[string]int)
+	for i, src := range srcs {
+		b := basename(src.path)
+		if _, ok := srcByBase[b]; ok {
+			srcByBase[b] = -1
+		} else {
+			srcByBase[b] = i
+		}
+	}
+	dstByBase := make(map[string]int)
+	for i, dst := range dsts {
+		if dst.pair != nil {
+			continue
+		}
+		b := basename(dst.path)
+		if _, ok := dstByBase[b]; ok {
+			dstByBase[b] = -1
+		} else {
+			dstByBase[b] = i
+		}
+	}
+	for i, src := range srcs {
+		b := basename(src.path)
+		j, ok := dstByBase[b]
+		if !ok || j < 0 || srcByBase[b] != i {
+			continue
+		}
+		score, err := d.estimateSimilarity(src, dsts[j], minScore)
+		if err != nil {
+			return err
+		}
+		if score < minScore {
+			continue
+		}
+		d.recordRename(srcs, dsts, i, j, score)
+	}
+	return nil
+}
+
+// Records the renames or copies in scores, which are sorted from the best,
+// for the destinations which don't have a source yet. Sources which were
+// already used are only taken if copies is set.
+func (d *renameDetector) assignRenames(scores []renameScore, srcs []renameSrc, dsts []renameDst, minScore int, copies bool) {
+	for _, s := range scores {
+		if s.dst < 0 || s.score < minScore {
+			break
+		}
+		if dsts[s.dst].pair != nil {
+			continue
+		}
+		if !copies && d.used[srcs[s.src].path] > 0 {
+			continue
+		}
+		d.recordRename(srcs, dsts, s.src, s.dst, s.score)
+	}
+}
+
+// Records that dsts[j] came from srcs[i]. A broken modification which is
+// put back together keeps its own score.
+func (d *renameDetector) recordRename(srcs []renameSrc, dsts []renameDst, i, j, score int) {
+	src, dst := srcs[i], &dsts[j]
+	d.used[src.path]++
+	pair := &HashDiff{
+		Name:    dst.path,
+		OldName: src.path,
+		Src:     src.entry,
+		Dst:     dst.entry,
+		SrcSize: src.size,
+		DstSize: dst.size,
+		Score:   score,
+	}
+	if src.path == dst.path {
+		pair.OldName, pair.Score = "", src.score
+	}
+	dst.pair = pair
+}
+
+// Returns the similarity of the regular files src and dst, or 0 if either
+// is a special file.
+func (d *renameDetector) estimateSimilarity(src renameSrc, dst renameDst, minScore int) (int, error) {
+	if !isRegularMode(src.entry.FileMode) || !isRegularMode(dst.entry.FileMode) {
+		return 0, nil
+	}
+	srcFile, err := d.load(src.path, src.entry)
+	if err != nil {
+		return 0, err
+	}
+	dstFile, err := d.load(dst.path, dst.entry)
+	if err != nil {
+		return 0, err
+	}
+	srcSize, dstSize := len(srcFile.data), len(dstFile.data)
+	if dstSize == 0 || tooDifferentInSize(srcSize, dstSize, minScore) {
+		return 0, nil
+	}
+	srcHashes, err := d.similarityHashes(src.path, src.entry)
+	if err != nil {
+		return 0, err
+	}
+	dstHashes, err := d.similarityHashes(dst.path, dst.entry)
+	if err != nil {
+		return 0, err
+	}
+	copied, _ := countHashChanges(srcHashes, dstHashes)
+	return copied * maxSimilarityScore / max(srcSize, dstSize), nil
+}
+
+// Replaces the worst of the candidates in best with s, if s is better.
+func recordIfBetter(best []renameScore, s renameScore) {
+	worst := 0
+	for i := 1; i < len(best); i++ {
+		if compareRenameScores(best[i], best[worst]) > 0 {
+			worst = i
+		}
+	}
+	if compareRenameScores(best[worst], s) > 0 {
+		best[worst] = s
+	}
+}
+
+// Returns a negative number if a is a better rename than b, a positive
+// number if it's worse, and 0 if they're as good as each other. Unused
+// candidates are the worst.
+func compareRenameScores(a, b renameScore) int {
+	switch {
+	case a.dst < 0 && b.dst < 0:
+		return 0
+	case a.dst < 0:
+		return 1
+	case b.dst < 0:
+		return -1
+	case a.score == b.score:
+		return b.nameScore - a.nameScore
+	default:
+		return b.score - a.score
+	}
+}
+
+// Returns 1 if a and b have the same basename, and 0 otherwise.
+func basenameSame(a, b IndexPath) int {
+	if basename(a) == basename(b) {
+		return 1
+	}
+	return 0
+}
+
+func basename(p IndexPath) string {
+	s := p.String()
+	return s[strings.LastIndexByte(s, '/')+1:]
+}
+
+func isRegularMode(m EntryMode) bool {
+	return m == ModeBlob || m == ModeExec
+}
+
+// Returns true if m is the mode of a file whose contents are a blob.
+func isBlobMode(m EntryMode) bool {
+	return isRegularMode(m) || m == ModeSymlink
+}
@@ -0,0 +1,135 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseSimilarityScore(t *testing.T) {
+	tests := []struct {
+		Score string
+		Want  int
+	}{
+		{"", 0},
+		{"5", 30000},
+		{"50%", 30000},
+		{"05", 3000},
+		{"90", 54000},
+		{"0.9", 54000},
+		{"100%", 60000},
+		{"200%", 60000},
+		{"x", -1},
+		{"50%x", -1},
+	}
+	for _, tc := range tests {
+		got, err := ParseSimilarityScore(tc.Score)
+		if tc.Want < 0 {
+			if err == nil {
+				t.Errorf("ParseSimilarityScore(%q): expected an error", tc.Score)
+			}
+			continue
+		}
+		if err != nil || got != tc.Want {
+			t.Errorf("ParseSimilarityScore(%q): got %v, %v want %v", tc.Score, got, err, tc.Want)
+		}
+	}
+}
+
+func TestPrettyRename(t *testing.T) {
+	tests := []struct {
+		A, B, Want string
+	}{
+		{"a", "b", "a => b"},
+		{"dir/a", "dir/b", "dir/{a => b}"},
+		{"dir/a", "dir2/a", "{dir => dir2}/a"},
+		{"a/b/c", "a/d/c", "a/{b => d}/c"},
+		{"a/b/c", "a/c", "a/{b => }/c"},
+		{"a", "dir/a", "a => dir/a"},
+	}
+	for _, tc := range tests {
+		if got := prettyRename(tc.A, tc.B); got != tc.Want {
+			t.Errorf("prettyRename(%q, %q): got %q want %q", tc.A, tc.B, got, tc.Want)
+		}
+	}
+}
+
+func TestDetectRenames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrenames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := func(from, to int) string {
+		var sb strings.Builder
+		for i := from; i <= to; i++ {
+			fmt.Fprintf(&sb, "%d\n", i)
+		}
+		return sb.String()
+	}
+	tree := func(files ...string) TreeID {
+		var buf bytes.Buffer
+		for i := 0; i < len(files); i += 2 {
+			blob, err := c.WriteObject("blob", []byte(files[i+1]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&buf, "100644 %v\x00", files[i])
+			buf.Write(blob[:])
+		}
+		id, err := c.WriteObject("tree", buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return TreeID(id)
+	}
+	// "moved" is an exact rename, "edited" is an inexact one, "kept"
+	// is copied to "kept2" and "rewritten" is completely rewritten.
+	t1 := tree(
+		"edited", lines(1, 100),
+		"kept", lines(200, 300),
+		"moved", lines(400, 500),
+		"rewritten", lines(600, 800),
+	)
+	t2 := tree(
+		"edited2", lines(1, 90),
+		"kept", lines(200, 300),
+		"kept2", lines(200, 300),
+		"moved2", lines(400, 500),
+		"rewritten", lines(1000, 1200),
+	)
+	tests := []struct {
+		Opts DiffCommonOptions
+		Want string
+	}{
+		{DiffCommonOptions{}, "D\tedited\nA\tedited2\nA\tkept2\nD\tmoved\nA\tmoved2\nM\trewritten\n"},
+		{DiffCommonOptions{DetectRenames: true}, "R089\tedited\tedited2\nA\tkept2\nR100\tmoved\tmoved2\nM\trewritten\n"},
+		{DiffCommonOptions{DetectRenames: true, RenameScore: 57000}, "D\tedited\nA\tedited2\nA\tkept2\nR100\tmoved\tmoved2\nM\trewritten\n"},
+		{DiffCommonOptions{DetectCopies: true}, "R089\tedited\tedited2\nA\tkept2\nR100\tmoved\tmoved2\nM\trewritten\n"},
+		{DiffCommonOptions{FindCopiesHarder: true}, "R089\tedited\tedited2\nC100\tkept\tkept2\nR100\tmoved\tmoved2\nM\trewritten\n"},
+		{DiffCommonOptions{BreakRewrites: true}, "D\tedited\nA\tedited2\nA\tkept2\nD\tmoved\nA\tmoved2\nM100\trewritten\n"},
+	}
+	for i, tc := range tests {
+		opts := &DiffTreeOptions{DiffCommonOptions: tc.Opts}
+		diffs, err := DiffTree(c, opts, t1, t2, nil)
+		if err != nil {
+			t.Errorf("Case %d: unexpected error: %v", i, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := GeneratePatch(c, DiffCommonOptions{NameStatus: true}, diffs, &buf); err != nil {
+			t.Errorf("Case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if got := buf.String(); got != tc.Want {
+			t.Errorf("Case %d: got %q want %q", i, got, tc.Want)
+		}
+	}
+}
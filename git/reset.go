@@ -146,9 +146,11 @@ func ResetUnstage(c *Client, opts ResetOptions, tree Treeish, files []File) erro
 		if err != nil {
 			return err
 		}
-		mtime, err := f.MTime()
-		if err != nil {
-			return err
+		var mtime int64
+		if f.Exists() {
+			if mtime, err = f.MTime(); err != nil {
+				return err
+			}
 		}
 		if entry.Src == (TreeEntry{}) {
 			// The file wasn't in HEAD. Remove it from the index,
@@ -0,0 +1,584 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resolveRevision resolves a single revision in the syntax described in
+// gitrevisions(7) into the object that it names. Annotated tags are only
+// peeled if a modifier asks for it. Ranges are handled by RevParse, not
+// here.
+func resolveRevision(c *Client, opt *RevParseOptions, arg string) (Sha1, error) {
+	if strings.HasPrefix(arg, ":/") {
+		return findCommitByMessage(c, nil, arg[2:])
+	}
+	if strings.HasPrefix(arg, ":") {
+		return revParseIndexPath(c, arg[1:])
+	}
+	if sep := revisionPathSeparator(arg); sep >= 0 {
+		obj, err := resolveRevision(c, opt, arg[:sep])
+		if err != nil {
+			return Sha1{}, err
+		}
+		tree, err := peelRevision(c, obj, "tree")
+		if err != nil {
+			return Sha1{}, err
+		}
+		return treePathEntry(c, TreeID(tree), arg[sep+1:])
+	}
+	base, mods := splitRevisionModifiers(arg)
+	cmtish, err := revParseBase(c, opt, base)
+	if err != nil {
+		return Sha1{}, err
+	}
+	obj, err := commitishObject(c, cmtish)
+	if err != nil {
+		return Sha1{}, err
+	}
+	return applyRevisionModifiers(c, obj, mods)
+}
+
+// Returns the index of the ":" separating a revision from a path in arg,
+// or -1 if there isn't one. Colons inside of "^{...}" and "@{...}" don't
+// count.
+func revisionPathSeparator(arg string) int {
+	depth := 0
+	for i, r := range arg {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case ':':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Splits a revision into the ref (or object name) that it starts with and
+// the chain of "^" and "~" modifiers that follow it.
+func splitRevisionModifiers(arg string) (base, mods string) {
+	depth := 0
+	for i, r := range arg {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case '^', '~':
+			if depth == 0 {
+				return arg[:i], arg[i:]
+			}
+		}
+	}
+	return arg, ""
+}
+
+// Returns the object that cmtish refers to, without peeling annotated
+// tags.
+func commitishObject(c *Client, cmtish Commitish) (Sha1, error) {
+	switch r := cmtish.(type) {
+	case RefSpec:
+		return r.Sha1(c)
+	case Branch:
+		return RefSpec(r).Sha1(c)
+	case CommitID:
+		return Sha1(r), nil
+	case ParsedRevision:
+		return r.Id, nil
+	default:
+		cmt, err := cmtish.CommitID(c)
+		return Sha1(cmt), err
+	}
+}
+
+// Peels obj until it's an object of type typ. typ may be "commit", "tree",
+// "blob", "tag", or "" to peel to the first object which isn't a tag. A
+// commit may be peeled to its tree.
+func peelRevision(c *Client, obj Sha1, typ string) (Sha1, error) {
+	for i := 0; i < 100; i++ {
+		t, _, err := c.GetObjectMetadata(obj)
+		if err != nil {
+			return Sha1{}, err
+		}
+		switch {
+		case t == typ, typ == "" && t != "tag":
+			return obj, nil
+		case t == "tag":
+			tag, err := c.GetTagObject(obj)
+			if err != nil {
+				return Sha1{}, err
+			}
+			if obj, err = Sha1FromString(tag.GetHeader("object")); err != nil {
+				return Sha1{}, err
+			}
+		case t == "commit" && typ == "tree":
+			tree, err := CommitID(obj).TreeID(c)
+			return Sha1(tree), err
+		default:
+			return Sha1{}, fmt.Errorf("%v is a %v, not a %v", obj, t, typ)
+		}
+	}
+	return Sha1{}, fmt.Errorf("Tag nesting too deep for %v", obj)
+}
+
+// Applies the chain of modifiers mods, such as "~2^{tree}", to obj.
+func applyRevisionModifiers(c *Client, obj Sha1, mods string) (Sha1, error) {
+	for mods != "" {
+		op := mods[0]
+		mods = mods[1:]
+		if op == '^' && strings.HasPrefix(mods, "{") {
+			end := strings.IndexByte(mods, '}')
+			if end < 0 {
+				return Sha1{}, fmt.Errorf("Missing } in revision modifier")
+			}
+			var err error
+			switch peel := mods[1:end]; {
+			case strings.HasPrefix(peel, "/"):
+				var cmt Sha1
+				if cmt, err = peelRevision(c, obj, "commit"); err == nil {
+					obj, err = findCommitByMessage(c, []CommitID{CommitID(cmt)}, peel[1:])
+				}
+			case peel == "object":
+			case peel == "", peel == "commit", peel == "tree", peel == "blob", peel == "tag":
+				obj, err = peelRevision(c, obj, peel)
+			default:
+				err = fmt.Errorf("Invalid object type in ^{%v}", peel)
+			}
+			if err != nil {
+				return Sha1{}, err
+			}
+			mods = mods[end+1:]
+			continue
+		}
+
+		digits := 0
+		for digits < len(mods) && mods[digits] >= '0' && mods[digits] <= '9' {
+			digits++
+		}
+		n := 1
+		if digits > 0 {
+			var err error
+			if n, err = strconv.Atoi(mods[:digits]); err != nil {
+				return Sha1{}, err
+			}
+			mods = mods[digits:]
+		}
+		cmt, err := peelRevision(c, obj, "commit")
+		if err != nil {
+			return Sha1{}, err
+		}
+		if op == '^' {
+			if n == 0 {
+				obj = cmt
+				continue
+			}
+			parents, err := CommitID(cmt).Parents(c)
+			if err != nil {
+				return Sha1{}, err
+			}
+			if n > len(parents) {
+				return Sha1{}, fmt.Errorf("Commit %v does not have a parent %d", cmt, n)
+			}
+			obj = Sha1(parents[n-1])
+			continue
+		}
+		for ; n > 0; n-- {
+			parents, err := CommitID(cmt).Parents(c)
+			if err != nil {
+				return Sha1{}, err
+			}
+			if len(parents) == 0 {
+				return Sha1{}, fmt.Errorf("Commit %v does not have a parent", cmt)
+			}
+			cmt = Sha1(parents[0])
+		}
+		obj = cmt
+	}
+	return obj, nil
+}
+
+// Returns the youngest commit reachable from from (or any ref if from is
+// nil) whose message matches the regular expression pattern. A pattern
+// starting with "!-" matches commits which don't match the rest of the
+// pattern, and "!!" is a literal "!".
+func findCommitByMessage(c *Client, from []CommitID, pattern string) (Sha1, error) {
+	negate := false
+	switch {
+	case strings.HasPrefix(pattern, "!-"):
+		negate, pattern = true, pattern[2:]
+	case strings.HasPrefix(pattern, "!!"):
+		pattern = pattern[1:]
+	case strings.HasPrefix(pattern, "!"):
+		return Sha1{}, fmt.Errorf("Invalid search pattern: %v", pattern)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Sha1{}, err
+	}
+	if from == nil {
+		refs, err := ShowRef(c, ShowRefOptions{}, nil)
+		if err != nil {
+			return Sha1{}, err
+		}
+		for _, ref := range refs {
+			if cmt, err := peelRevision(c, ref.Value, "commit"); err == nil {
+				from = append(from, CommitID(cmt))
+			}
+		}
+		if head, err := c.GetHeadCommit(); err == nil {
+			from = append(from, head)
+		}
+	}
+
+	// Walk the commits from youngest to oldest by committer date.
+	seen := make(map[CommitID]struct{})
+	var queue []CommitID
+	dates := make(map[CommitID]time.Time)
+	push := func(cmts []CommitID) error {
+		for _, cmt := range cmts {
+			if _, ok := seen[cmt]; ok {
+				continue
+			}
+			seen[cmt] = struct{}{}
+			date, err := cmt.GetCommitterDate(c)
+			if err != nil {
+				return err
+			}
+			dates[cmt] = date
+			queue = append(queue, cmt)
+		}
+		sort.SliceStable(queue, func(i, j int) bool { return dates[queue[i]].After(dates[queue[j]]) })
+		return nil
+	}
+	if err := push(from); err != nil {
+		return Sha1{}, err
+	}
+	for len(queue) > 0 {
+		cmt := queue[0]
+		queue = queue[1:]
+		msg, err := cmt.GetCommitMessage(c)
+		if err != nil {
+			return Sha1{}, err
+		}
+		if re.MatchString(msg.String()) != negate {
+			return Sha1(cmt), nil
+		}
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return Sha1{}, err
+		}
+		if err := push(parents); err != nil {
+			return Sha1{}, err
+		}
+	}
+	return Sha1{}, fmt.Errorf("No commit message matches %v", pattern)
+}
+
+// Parses the "<path>" or "<n>:<path>" part of a ":<n>:<path>" revision, and
+// returns the blob in that stage of the index.
+func revParseIndexPath(c *Client, arg string) (Sha1, error) {
+	stage := Stage0
+	if len(arg) > 1 && arg[1] == ':' && arg[0] >= '0' && arg[0] <= '3' {
+		stage = Stage(arg[0] - '0')
+		arg = arg[2:]
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return Sha1{}, err
+	}
+	for _, entry := range idx.Objects {
+		if entry.PathName == IndexPath(arg) && entry.Stage() == stage {
+			return entry.Sha1, nil
+		}
+	}
+	if stage == Stage0 {
+		return Sha1{}, fmt.Errorf("path '%v' does not exist in the index", arg)
+	}
+	return Sha1{}, fmt.Errorf("path '%v' is not in the index at stage %d", arg, stage)
+}
+
+// Returns the object at path in tree. An empty path refers to the tree
+// itself.
+func treePathEntry(c *Client, tree TreeID, path string) (Sha1, error) {
+	obj := Sha1(tree)
+	for _, component := range strings.Split(path, "/") {
+		if component == "" {
+			continue
+		}
+		if t, _, err := c.GetObjectMetadata(obj); err != nil || t != "tree" {
+			return Sha1{}, fmt.Errorf("path '%v' does not exist in %v", path, tree)
+		}
+		entries, err := TreeID(obj).GetAllObjects(c, "", false, false)
+		if err != nil {
+			return Sha1{}, err
+		}
+		entry, ok := entries[IndexPath(component)]
+		if !ok {
+			return Sha1{}, fmt.Errorf("path '%v' does not exist in %v", path, tree)
+		}
+		obj = entry.Sha1
+	}
+	return obj, nil
+}
+
+// Resolves the part of a revision before any "^" or "~" modifiers. This is
+// usually a ref name or object name, but may also be "@" or use one of the
+// "@{...}" forms.
+func revParseBase(c *Client, opt *RevParseOptions, base string) (Commitish, error) {
+	if base == "@" {
+		base = "HEAD"
+	}
+	if at := strings.Index(base, "@{"); at >= 0 && strings.HasSuffix(base, "}") {
+		return revParseAtBrace(c, opt, base[:at], base[at+2:len(base)-1])
+	}
+	return revParseRef(c, opt, base)
+}
+
+// Resolves ref@{spec}.
+func revParseAtBrace(c *Client, opt *RevParseOptions, ref, spec string) (Commitish, error) {
+	if ref == "@" {
+		ref = "HEAD"
+	}
+	if strings.HasPrefix(spec, "-") {
+		if ref != "" {
+			return nil, fmt.Errorf("Invalid revision %v@{%v}", ref, spec)
+		}
+		n, err := strconv.Atoi(spec[1:])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("Invalid revision @{%v}", spec)
+		}
+		branch, err := previousBranch(c, n)
+		if err != nil {
+			return nil, err
+		}
+		return revParseRef(c, opt, branch)
+	}
+	switch strings.ToLower(spec) {
+	case "u", "upstream", "push":
+		branch, err := atBraceBranch(c, ref)
+		if err != nil {
+			return nil, err
+		}
+		var tracking Refname
+		if strings.ToLower(spec) == "push" {
+			tracking, err = branchPushTracking(c, branch)
+		} else {
+			tracking, err = branchUpstream(c, branch)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !tracking.Exists(c) {
+			return nil, fmt.Errorf("%v does not exist", tracking)
+		}
+		return RefSpec(tracking), nil
+	}
+
+	// Everything else is a reflog lookup. With no ref, it's the current
+	// branch's reflog.
+	var refname Refname
+	if ref == "" {
+		if head, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD"); err == nil {
+			refname = Refname(head)
+		} else {
+			refname = "HEAD"
+		}
+	} else if full, err := dwimRefname(c, ref); err == nil {
+		refname = full
+	} else {
+		return nil, err
+	}
+	entries, err := readReflog(c, refname)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("log for '%v' is empty", refname)
+	}
+	if n, err := strconv.Atoi(spec); err == nil && n >= 0 {
+		switch {
+		case n < len(entries):
+			return CommitID(entries[len(entries)-1-n].New), nil
+		case n == len(entries):
+			return CommitID(entries[0].Old), nil
+		default:
+			return nil, fmt.Errorf("log for '%v' only has %d entries", refname, len(entries))
+		}
+	}
+	date, err := ParseApproxidate(spec, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].Committer.Time.After(date) {
+			return CommitID(entries[i].New), nil
+		}
+	}
+	fmt.Fprintf(os.Stderr, "warning: log for '%v' only goes back to %v\n", strings.TrimPrefix(string(refname), "refs/heads/"), entries[0].Committer.Time.Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	return CommitID(entries[0].New), nil
+}
+
+// Returns the name of the branch that was checked out n branch switches
+// ago, according to the HEAD reflog. If HEAD was detached, it's the commit
+// ID instead.
+func previousBranch(c *Client, n int) (string, error) {
+	entries, err := readReflog(c, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg := entries[i].Message
+		if !strings.HasPrefix(msg, "checkout: moving from ") {
+			continue
+		}
+		if n--; n > 0 {
+			continue
+		}
+		msg = strings.TrimPrefix(msg, "checkout: moving from ")
+		if to := strings.Index(msg, " to "); to >= 0 {
+			return msg[:to], nil
+		}
+	}
+	return "", fmt.Errorf("Not enough branch switches in the HEAD reflog")
+}
+
+// Returns the local branch name that ref refers to for @{upstream} and
+// @{push}, where "" or "HEAD" is the current branch.
+func atBraceBranch(c *Client, ref string) (string, error) {
+	if ref == "" || ref == "HEAD" {
+		head, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD")
+		if err != nil {
+			return "", fmt.Errorf("HEAD does not point to a branch")
+		}
+		ref = head.String()
+	}
+	ref = strings.TrimPrefix(ref, "refs/heads/")
+	if !refExists(c, "refs/heads/"+ref) {
+		return "", fmt.Errorf("no such branch: '%v'", ref)
+	}
+	return ref, nil
+}
+
+// Returns the remote-tracking ref of the branch that branch merges from.
+func branchUpstream(c *Client, branch string) (Refname, error) {
+	remote := c.GetConfig("branch." + branch + ".remote")
+	merge := c.GetConfig("branch." + branch + ".merge")
+	if remote == "" || merge == "" {
+		return "", fmt.Errorf("no upstream configured for branch '%v'", branch)
+	}
+	if remote == "." {
+		return Refname(merge), nil
+	}
+	tracking := remoteTrackingRef(c, remote, Refname(merge))
+	if tracking == "" {
+		return "", fmt.Errorf("upstream branch '%v' not stored as a remote-tracking branch", merge)
+	}
+	return tracking, nil
+}
+
+// Returns the remote-tracking ref of the branch that "git push" would push
+// branch to.
+func branchPushTracking(c *Client, branch string) (Refname, error) {
+	remote := c.GetConfig("branch." + branch + ".pushRemote")
+	if remote == "" {
+		remote = c.GetConfig("remote.pushDefault")
+	}
+	if remote == "" {
+		remote = c.GetConfig("branch." + branch + ".remote")
+	}
+	if remote == "" {
+		return "", fmt.Errorf("branch '%v' has no remote for pushing", branch)
+	}
+	switch c.GetConfig("push.default") {
+	case "nothing":
+		return "", fmt.Errorf("push has no destination (push.default is 'nothing')")
+	case "upstream", "tracking":
+		return branchUpstream(c, branch)
+	case "", "simple":
+		if remote == c.GetConfig("branch."+branch+".remote") {
+			up, err := branchUpstream(c, branch)
+			if err != nil {
+				return "", err
+			}
+			tracking, err := pushDestTracking(c, remote, branch)
+			if err != nil {
+				return "", err
+			}
+			if tracking != up {
+				return "", fmt.Errorf("cannot resolve 'simple' push to a single destination")
+			}
+			return tracking, nil
+		}
+	}
+	return pushDestTracking(c, remote, branch)
+}
+
+// Returns the remote-tracking ref of the branch of the same name as branch
+// on remote. A local remote (".") has no remote-tracking refs, so there's
+// never one for it.
+func pushDestTracking(c *Client, remote, branch string) (Refname, error) {
+	tracking := remoteTrackingRef(c, remote, Refname("refs/heads/"+branch))
+	if tracking == "" {
+		return "", fmt.Errorf("push destination 'refs/heads/%v' on remote '%v' has no local tracking branch", branch, remote)
+	}
+	return tracking, nil
+}
+
+// Returns the local ref that the remote's ref is fetched into by the
+// remote's configured refspecs, or "" if it isn't fetched into one.
+func remoteTrackingRef(c *Client, remote string, ref Refname) Refname {
+	for _, spec := range c.GetConfigAll("remote." + remote + ".fetch") {
+		if strings.HasPrefix(spec, "^") {
+			continue
+		}
+		rs := RefSpec(spec)
+		src, dst := rs.Src(), rs.Dst()
+		if dst == "" {
+			continue
+		}
+		if match, ok := matchRefGlob(string(src), string(ref)); ok {
+			return Refname(strings.Replace(string(dst), "*", match, 1))
+		}
+	}
+	return ""
+}
+
+// The rules used to expand an abbreviated ref name, in order of
+// precedence.
+var refnameRules = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
+}
+
+// Expands an abbreviated ref name to the full name of an existing ref,
+// using the same rules as git.
+func dwimRefname(c *Client, ref string) (Refname, error) {
+	for _, rule := range refnameRules {
+		candidate := fmt.Sprintf(rule, ref)
+		if candidate != "HEAD" && !strings.HasPrefix(candidate, "refs/") {
+			continue
+		}
+		if refExists(c, candidate) {
+			return Refname(candidate), nil
+		}
+	}
+	return "", fmt.Errorf("Could not find %v", ref)
+}
@@ -0,0 +1,298 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseApproxidate(t *testing.T) {
+	now := time.Date(2020, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		Date    string
+		Want    time.Time
+		WantErr bool
+	}{
+		{"now", now, false},
+		{"yesterday", time.Date(2020, 3, 14, 12, 0, 0, 0, time.UTC), false},
+		{"2.weeks.ago", time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC), false},
+		{"1 hour 30 minutes ago", time.Date(2020, 3, 15, 10, 30, 0, 0, time.UTC), false},
+		{"a month ago", time.Date(2020, 2, 15, 12, 0, 0, 0, time.UTC), false},
+		{"2019-12-25", time.Date(2019, 12, 25, 0, 0, 0, 0, time.UTC), false},
+		{"2019-12-25 08:30:00", time.Date(2019, 12, 25, 8, 30, 0, 0, time.UTC), false},
+		{"@1577836800", time.Unix(1577836800, 0), false},
+		{"1577836800", time.Unix(1577836800, 0), false},
+		{"2020-01-02T12:00:00Z", time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC), false},
+		{"2020-01-02T12:00:00+01:00", time.Date(2020, 1, 2, 11, 0, 0, 0, time.UTC), false},
+		{"Jan 2 2020", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), false},
+		{"January 2, 2020", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), false},
+		{"Thu Jan 2 12:00:00 2020 +0000", time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC), false},
+		{"last week", time.Date(2020, 3, 8, 12, 0, 0, 0, time.UTC), false},
+		{"2 weeks ago", time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC), false},
+		{"3 fortnights ago", time.Time{}, true},
+		{"3", time.Time{}, true},
+	}
+	for _, test := range tests {
+		got, err := ParseApproxidate(test.Date, now)
+		if test.WantErr {
+			if err == nil {
+				t.Errorf("%q: expected error", test.Date)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.Date, err)
+			continue
+		}
+		if !got.Equal(test.Want) {
+			t.Errorf("%q: got %v want %v", test.Date, got, test.Want)
+		}
+	}
+}
+
+// Sets up a repository with the history
+//
+//	A---B---M (master)
+//	 \     /
+//	  C----  (side)
+//
+// where B is tagged with the annotated tag v1, and returns the commits.
+// The caller must cleanup dir when done.
+func testRevisionSetup(t *testing.T) (c *Client, dir string, A, B, C, M CommitID) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gitrevision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	commit := func(content, msg string) CommitID {
+		if err := ioutil.WriteFile("foo.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		cmt, err := Commit(c, CommitOptions{}, CommitMessage(msg), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmt
+	}
+	A = commit("foo\n", "first")
+	B = commit("bar\n", "second")
+	if err := TagCommit(c, TagOptions{Annotated: true}, "v1", B, "v1\n"); err != nil {
+		t.Fatal(err)
+	}
+	if C, err = CommitTree(c, CommitTreeOptions{}, A, []CommitID{A}, "third"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef(c, UpdateRefOptions{CreateReflog: true}, "refs/heads/side", C, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if M, err = CommitTree(c, CommitTreeOptions{}, B, []CommitID{B, C}, "merge"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef(c, UpdateRefOptions{}, "refs/heads/master", M, "test"); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestResolveRevision(t *testing.T) {
+	c, dir, A, B, C, M := testRevisionSetup(t)
+	defer os.RemoveAll(dir)
+
+	config, err := LoadLocalConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfig("branch.side.remote", ".")
+	config.SetConfig("branch.side.merge", "refs/heads/master")
+	if err := config.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = NewClient(c.GitDir.String(), c.WorkDir.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	tag, err := RefSpec("refs/tags/v1").Sha1(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := M.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := treePathEntry(c, tree, "foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Rev     string
+		Want    Sha1
+		WantErr bool
+	}{
+		{"master", Sha1(M), false},
+		{"@", Sha1(M), false},
+		{"master^", Sha1(B), false},
+		{"master^1", Sha1(B), false},
+		{"master^2", Sha1(C), false},
+		{"master^3", Sha1{}, true},
+		{"master~2", Sha1(A), false},
+		{"master^2~1", Sha1(A), false},
+		{"master~^0", Sha1(B), false},
+		{"master~3", Sha1{}, true},
+		{"v1", tag, false},
+		{"v1^{tag}", tag, false},
+		{"v1^{}", Sha1(B), false},
+		{"v1^{commit}", Sha1(B), false},
+		{"v1~1", Sha1(A), false},
+		{"master^{tree}", Sha1(tree), false},
+		{"master^{object}", Sha1(M), false},
+		{"master^{blob}", Sha1{}, true},
+		{"master^{/^second}", Sha1(B), false},
+		{"master^{/!-merge}", Sha1(B), false},
+		{":/third", Sha1(C), false},
+		{":/nomatch", Sha1{}, true},
+		{"master:foo.txt", blob, false},
+		{"master:", Sha1(tree), false},
+		{"master:missing", Sha1{}, true},
+		{":foo.txt", blob, false},
+		{":0:foo.txt", blob, false},
+		{":2:foo.txt", Sha1{}, true},
+		{"master@{0}", Sha1(M), false},
+		{"master@{1}", Sha1(B), false},
+		{"master@{2}", Sha1(A), false},
+		{"master@{9}", Sha1{}, true},
+		{"master@{now}", Sha1(M), false},
+		{"side@{u}", Sha1(M), false},
+		{"side@{upstream}^2", Sha1(C), false},
+		{"master@{u}", Sha1{}, true},
+		// A local remote has no remote-tracking branches to push to.
+		{"side@{push}", Sha1{}, true},
+	}
+	for _, test := range tests {
+		got, err := resolveRevision(c, &RevParseOptions{}, test.Rev)
+		if test.WantErr {
+			if err == nil {
+				t.Errorf("%v: expected error, got %v", test.Rev, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.Rev, err)
+			continue
+		}
+		if got != test.Want {
+			t.Errorf("%v: got %v want %v", test.Rev, got, test.Want)
+		}
+	}
+}
+
+func TestRevParseRanges(t *testing.T) {
+	c, dir, A, B, C, M := testRevisionSetup(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		Rev  string
+		Want []ParsedRevision
+	}{
+		{"^side", []ParsedRevision{{Id: Sha1(C), Excluded: true}}},
+		{"side..master", []ParsedRevision{{Id: Sha1(M)}, {Id: Sha1(C), Excluded: true}}},
+		{"side..", []ParsedRevision{{Id: Sha1(M)}, {Id: Sha1(C), Excluded: true}}},
+		{"v1...side", []ParsedRevision{{Id: Sha1(C)}, {Id: Sha1(B), Left: true}, {Id: Sha1(A), Excluded: true}}},
+		{"master^@", []ParsedRevision{{Id: Sha1(B)}, {Id: Sha1(C)}}},
+		{"master^!", []ParsedRevision{{Id: Sha1(M)}, {Id: Sha1(B), Excluded: true}, {Id: Sha1(C), Excluded: true}}},
+		{"master^-", []ParsedRevision{{Id: Sha1(M)}, {Id: Sha1(B), Excluded: true}}},
+		{"master^-2", []ParsedRevision{{Id: Sha1(M)}, {Id: Sha1(C), Excluded: true}}},
+	}
+	for _, test := range tests {
+		got, err := RevParse(c, RevParseOptions{}, []string{test.Rev})
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.Rev, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("%v: got %v want %v", test.Rev, got, test.Want)
+		}
+	}
+	if _, err := RevParse(c, RevParseOptions{Verify: true}, []string{"side..master"}); err == nil {
+		t.Errorf("Expected error verifying a range")
+	}
+}
+
+func TestRevParseNames(t *testing.T) {
+	c, dir, _, B, C, M := testRevisionSetup(t)
+	defer os.RemoveAll(dir)
+
+	// A tag with the same name as a branch makes the short name of the
+	// branch ambiguous.
+	if err := UpdateRef(c, UpdateRefOptions{}, "refs/tags/side", C, "test"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Opts RevParseOptions
+		Args []string
+		Want []ParsedRevision
+	}{
+		{
+			RevParseOptions{SymbolicFullName: true},
+			[]string{"HEAD", "master~1", "v1", "^master"},
+			[]ParsedRevision{
+				{Id: Sha1(M), Name: "refs/heads/master"},
+				{Id: mustResolve(t, c, "v1"), Name: "refs/tags/v1"},
+				{Id: Sha1(M), Excluded: true, Name: "refs/heads/master"},
+			},
+		},
+		{
+			RevParseOptions{AbbrefRev: "strict"},
+			[]string{"HEAD", "heads/side", "v1"},
+			[]ParsedRevision{
+				{Id: Sha1(M), Name: "master"},
+				{Id: Sha1(C), Name: "heads/side"},
+				{Id: mustResolve(t, c, "v1"), Name: "v1"},
+			},
+		},
+		{
+			RevParseOptions{Symbolic: true},
+			[]string{"master^!"},
+			[]ParsedRevision{
+				{Id: Sha1(M), Name: "master"},
+				{Id: Sha1(B), Excluded: true, Name: "master^1"},
+				{Id: Sha1(C), Excluded: true, Name: "master^2"},
+			},
+		},
+		{
+			RevParseOptions{Short: 7, Verify: true},
+			[]string{"master"},
+			[]ParsedRevision{{Id: Sha1(M), Name: M.String()[:7]}},
+		},
+	}
+	for i, test := range tests {
+		got, err := RevParse(c, test.Opts, test.Args)
+		if err != nil {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("Test %d: got %v want %v", i, got, test.Want)
+		}
+	}
+}
+
+func mustResolve(t *testing.T, c *Client, rev string) Sha1 {
+	t.Helper()
+	id, err := resolveRevision(c, &RevParseOptions{}, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
@@ -1,9 +1,17 @@
 package git
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // List of command line options that may be passed to RevList
@@ -12,16 +20,155 @@ type RevListOptions struct {
 	MaxCount       *uint
 	VerifyObjects  bool
 	All            bool
+
+	// Include the refs matching globs under refs/heads/, refs/tags/ or
+	// refs/remotes/, as if they were passed on the command line. A glob
+	// without any wildcards matches the refs under it, as if it ended in
+	// "/*".
+	Branches, Tags, Remotes []string
+
+	// By default, commits are listed in reverse chronological order.
+	// DateOrder ensures that no parents are listed before all of their
+	// children, and TopoOrder also avoids showing commits on multiple
+	// lines of history intermixed.
+	TopoOrder, DateOrder bool
+	Reverse              bool
+
+	// Options for limiting which commits are listed.
+	Skip        uint
+	FirstParent bool
+	MinParents  uint
+	MaxParents  *uint
+	Since       time.Time
+	Until       time.Time
+
+	// Regular expressions that the author, committer or commit message
+	// must match. Commits match if any of the patterns for each of
+	// Author, Committer and Grep match, or if all of the Grep patterns
+	// match with AllMatch.
+	Author, Committer, Grep    []string
+	AllMatch, RegexpIgnoreCase bool
+
+	// Only list commits which change Paths. Unless FullHistory is set,
+	// merges are simplified by only following a parent that the paths
+	// are the same as.
+	Paths       []IndexPath
+	FullHistory bool
+
+	// Like FullHistory, but removes merges which don't contribute any
+	// changes to Paths from the history after walking it. This implies
+	// TopoOrder.
+	SimplifyMerges bool
+
+	// Only list commits which are descendants of the excluded commits
+	// and ancestors of the included ones.
+	AncestryPath bool
+
+	// Follow the history of the single file in Paths across renames,
+	// instead of limiting the walk to it. Commits which don't change the
+	// file or which are merges aren't listed, but still count towards
+	// Skip.
+	Follow bool
+
+	// Also list the parents of the commits listed which were excluded.
+	Boundary bool
+
+	// Mark which side of a symmetric difference each commit is on, and
+	// whether there's an equivalent change on the other side. CherryPick
+	// omits the commits with equivalent changes instead of marking them.
+	LeftRight, CherryMark, CherryPick bool
+
+	// Print the number of commits that would have been listed instead
+	// of listing them.
+	Count bool
+
+	// Like Objects, but also lists the excluded commits at the edges of
+	// the range.
+	ObjectsEdge bool
+
+	// When limiting to paths, replace the parents of listed commits
+	// which aren't listed with their nearest ancestors which are, so
+	// that the Parents of RevListEntry join up the listed commits.
+	RewriteParents bool
+}
+
+// A RevListEntry is a commit or object listed by RevListWalk.
+type RevListEntry struct {
+	Id Sha1
+
+	// Set for trees, blobs and tags listed because of the Objects
+	// option. Name is the path of the tree or blob, or the name of the
+	// tag.
+	Object bool
+	Name   string
+
+	// Boundary commits are excluded commits which are listed because of
+	// the Boundary or ObjectsEdge options.
+	Boundary bool
+
+	// Whether the commit is on the left side of a symmetric difference,
+	// and whether there's a commit with the same change on the other
+	// side.
+	Left, PatchSame bool
+
+	// The parents of the commit which are listed, or which will be
+	// listed as boundary commits, as needed to draw the history graph.
+	Parents []CommitID
+}
+
+// Returns the mark that git prints before the commit e when listing it
+// with the options opt.
+func (e RevListEntry) Mark(opt RevListOptions) string {
+	switch {
+	case e.Object:
+		return ""
+	case e.Boundary:
+		return "-"
+	case e.PatchSame:
+		return "="
+	case opt.LeftRight && e.Left:
+		return "<"
+	case opt.LeftRight:
+		return ">"
+	case opt.CherryMark:
+		return "+"
+	}
+	return ""
 }
 
 var maxCountError = fmt.Errorf("Maximum number of objects has been reached")
 
+// ErrNotShown may be returned by the callback of RevListWalk for a commit
+// that it decided not to show, such as one without changes matching the
+// pickaxe of log -S. The walk continues, and the commit doesn't count
+// towards MaxCount.
+var ErrNotShown = fmt.Errorf("Commit was not shown")
+
 func RevList(c *Client, opt RevListOptions, w io.Writer, includes, excludes []Commitish) ([]Sha1, error) {
 	var vals []Sha1
-	err := RevListCallback(c, opt, includes, excludes, func(s Sha1) error {
+	var left, right, same int
+	err := RevListWalk(c, opt, includes, excludes, func(e RevListEntry) error {
+		s := e.Id
 		vals = append(vals, s)
+		if opt.Count {
+			if !e.Object {
+				switch {
+				case e.PatchSame:
+					same++
+				case e.Left:
+					left++
+				default:
+					right++
+				}
+			}
+			return nil
+		}
 		if !opt.Quiet {
-			fmt.Fprintf(w, "%v\n", s)
+			if e.Object {
+				fmt.Fprintf(w, "%v %v\n", s, e.Name)
+			} else {
+				fmt.Fprintf(w, "%v%v\n", e.Mark(opt), s)
+			}
 		}
 		if opt.VerifyObjects {
 			switch t := s.Type(c); t {
@@ -53,96 +200,1739 @@ func RevList(c *Client, opt RevListOptions, w io.Writer, includes, excludes []Co
 	if err != nil {
 		return nil, err
 	}
+	if opt.Count {
+		switch {
+		case opt.LeftRight && opt.CherryMark:
+			fmt.Fprintf(w, "%d\t%d\t%d\n", left, right, same)
+		case opt.LeftRight:
+			fmt.Fprintf(w, "%d\t%d\n", left, right)
+		case opt.CherryMark:
+			fmt.Fprintf(w, "%d\t%d\n", left+right, same)
+		default:
+			fmt.Fprintf(w, "%d\n", left+right)
+		}
+	}
 	return vals, nil
 }
 
+// RevListCallback is like RevListWalk, but only passes the object names
+// to the callback.
 func RevListCallback(c *Client, opt RevListOptions, includes, excludes []Commitish, callback func(Sha1) error) error {
-	excludeList := make(map[Sha1]struct{})
-	buildExcludeList := func(s Sha1) error {
-		if _, ok := excludeList[s]; ok {
-			return nil
+	return RevListWalk(c, opt, includes, excludes, func(e RevListEntry) error {
+		return callback(e.Id)
+	})
+}
+
+// RevListWalk lists the commits reachable from includes but not from
+// excludes, and their objects if opt.Objects is set, in the order given by
+// opt. The commits are passed to callback as they're found, followed by
+// the objects. If callback returns ErrNotShown, the walk continues as if
+// the commit was never listed.
+func RevListWalk(c *Client, opt RevListOptions, includes, excludes []Commitish, callback func(RevListEntry) error) error {
+	w, err := newRevWalk(c, &opt)
+	if err != nil {
+		return err
+	}
+	refs, err := revListRefs(c, &opt)
+	if err != nil {
+		return err
+	}
+	for _, cmt := range append(includes, refs...) {
+		if err := w.addStart(cmt, 0); err != nil {
+			return err
+		}
+	}
+	for _, cmt := range excludes {
+		if err := w.addStart(cmt, revUninteresting); err != nil {
+			return err
+		}
+	}
+	if err := w.prepare(); err != nil {
+		return err
+	}
+
+	// Objects are listed after all the commits, so the trees of the
+	// commits are kept until then.
+	var trees []TreeID
+	if opt.Objects || opt.ObjectsEdge {
+		if err := w.markEdges(callback); err != nil {
+			return err
 		}
-		if opt.Objects {
-			cmt := CommitID(s)
-			_, err := cmt.GetAllObjectsExcept(c, excludeList)
+	}
+	show := func(cm *revWalkCommit) error {
+		if opt.Follow {
+			changed, err := w.follow(cm)
 			if err != nil {
 				return err
 			}
+			if !changed {
+				// The commit wasn't really listed, so it
+				// shouldn't count towards MaxCount.
+				if w.maxCount >= 0 {
+					w.maxCount++
+				}
+				return nil
+			}
 		}
-		excludeList[s] = struct{}{}
-		return nil
-	}
-
-	if len(excludes) > 0 {
-		var cIDs []CommitID = make([]CommitID, 0, len(excludes))
-		for _, e := range excludes {
-			cmt, err := e.CommitID(c)
+		if opt.Objects || opt.ObjectsEdge {
+			tree, err := cm.Id.TreeID(c)
 			if err != nil {
 				return err
 			}
-			cIDs = append(cIDs, cmt)
+			trees = append(trees, tree)
+		}
+		if opt.RewriteParents && w.prune {
+			if err := w.rewriteParents(cm); err != nil {
+				return err
+			}
 		}
-		if err := revListCallback(c, opt, cIDs, excludeList, buildExcludeList); err != nil {
+		parents, err := w.listedParents(cm)
+		if err != nil {
 			return err
 		}
+		err = callback(RevListEntry{
+			Id:        Sha1(cm.Id),
+			Boundary:  cm.flags&revBoundary != 0,
+			Left:      cm.flags&revLeft != 0,
+			PatchSame: cm.flags&revPatchSame != 0,
+			Parents:   parents,
+		})
+		if err == ErrNotShown {
+			if w.maxCount >= 0 {
+				w.maxCount++
+			}
+			return nil
+		}
+		return err
+	}
+	if opt.Reverse {
+		var commits []*revWalkCommit
+		for {
+			cm, err := w.next()
+			if err != nil {
+				return err
+			}
+			if cm == nil {
+				break
+			}
+			commits = append(commits, cm)
+		}
+		for i := len(commits) - 1; i >= 0; i-- {
+			if err := show(commits[i]); err != nil {
+				return err
+			}
+		}
+	} else {
+		for {
+			cm, err := w.next()
+			if err != nil {
+				return err
+			}
+			if cm == nil {
+				break
+			}
+			if err := show(cm); err != nil {
+				return err
+			}
+		}
 	}
 
-	cIDs := make([]CommitID, 0, len(includes))
-	for _, i := range includes {
-		cmt, err := i.CommitID(c)
-		if err != nil {
+	if !opt.Objects && !opt.ObjectsEdge {
+		return nil
+	}
+	for _, tag := range w.tags {
+		if _, ok := w.objects[tag.Id]; ok {
+			continue
+		}
+		w.objects[tag.Id] = struct{}{}
+		if err := callback(RevListEntry{Id: tag.Id, Object: true, Name: tag.Name}); err != nil {
+			return err
+		}
+	}
+	for _, tree := range trees {
+		if err := w.listTree(tree, "", callback); err != nil {
 			return err
 		}
-		cIDs = append(cIDs, cmt)
+	}
+	return nil
+}
+
+// Returns the refs which should be included because of the All, Branches,
+// Tags and Remotes options.
+func revListRefs(c *Client, opt *RevListOptions) ([]Commitish, error) {
+	if !opt.All && len(opt.Branches) == 0 && len(opt.Tags) == 0 && len(opt.Remotes) == 0 {
+		return nil, nil
+	}
+	var globs []string
+	if opt.All {
+		globs = append(globs, "refs/*")
+	}
+	for _, kind := range []struct {
+		prefix string
+		globs  []string
+	}{
+		{"refs/heads/", opt.Branches},
+		{"refs/tags/", opt.Tags},
+		{"refs/remotes/", opt.Remotes},
+	} {
+		for _, glob := range kind.globs {
+			if !strings.ContainsAny(glob, "*?[") {
+				glob = strings.TrimSuffix(glob, "/") + "/*"
+			}
+			globs = append(globs, kind.prefix+strings.TrimPrefix(glob, "/"))
+		}
 	}
 
-	callbackCount := uint(0)
-	callbackCountWrapper := func(s Sha1) error {
-		callbackCount++
-		if opt.MaxCount != nil && callbackCount > *opt.MaxCount {
-			return maxCountError
+	var refs []Commitish
+	if opt.All {
+		if head, err := c.GetHeadCommit(); err == nil {
+			refs = append(refs, head)
+		}
+	}
+	all, err := ShowRef(c, ShowRefOptions{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range all {
+		for _, glob := range globs {
+			if wildmatch(glob, ref.Name) {
+				// Refs which don't point to commits can't be
+				// walked, so are skipped.
+				if _, err := peelRevision(c, ref.Value, "commit"); err == nil {
+					refs = append(refs, ParsedRevision{Id: ref.Value, Name: shortenRefname(c, Refname(ref.Name), false)})
+				}
+				break
+			}
 		}
+	}
+	return refs, nil
+}
 
-		return callback(s)
+// Returns true if name matches the wildcard pattern. Unlike filepath.Match,
+// a "*" matches any string including slashes, as it does for git
+// pathspecs and ref globs.
+func wildmatch(pattern, name string) bool {
+	re, ok := wildmatchCache[pattern]
+	if !ok {
+		var expr strings.Builder
+		expr.WriteString("^")
+		for i := 0; i < len(pattern); i++ {
+			switch ch := pattern[i]; ch {
+			case '*':
+				expr.WriteString(".*")
+			case '?':
+				expr.WriteString(".")
+			case '[':
+				end := strings.IndexByte(pattern[i+1:], ']')
+				if end < 0 {
+					expr.WriteString(`\[`)
+					continue
+				}
+				class := pattern[i+1 : i+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				expr.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+				i += end + 1
+			default:
+				expr.WriteString(regexp.QuoteMeta(string(ch)))
+			}
+		}
+		expr.WriteString("$")
+		var err error
+		if re, err = regexp.Compile(expr.String()); err != nil {
+			re = nil
+		}
+		if wildmatchCache == nil {
+			wildmatchCache = make(map[string]*regexp.Regexp)
+		}
+		wildmatchCache[pattern] = re
 	}
+	if re == nil {
+		return pattern == name
+	}
+	return re.MatchString(name)
+}
 
-	err := revListCallback(c, opt, cIDs, excludeList, callbackCountWrapper)
-	if err == maxCountError {
-		return nil
+var wildmatchCache map[string]*regexp.Regexp
+
+// The state of a commit in a revWalk.
+type revWalkFlags uint
+
+const (
+	// The commit is reachable from an excluded commit.
+	revUninteresting revWalkFlags = 1 << iota
+	// The commit has been added to the queue.
+	revSeen
+	// The parents of the commit have been added to the queue.
+	revAdded
+	// The commit is reachable from the left side of a symmetric
+	// difference.
+	revLeft
+	// The commit doesn't change any of the paths being limited to.
+	revTreeSame
+	revShown
+	// A child of the commit was shown, so it may be a boundary commit.
+	revChildShown
+	revBoundary
+	// There's a commit on the other side of a symmetric difference with
+	// the same patch.
+	revPatchSame
+	// The commit was excluded on the command line.
+	revBottom
+)
+
+type revWalkCommit struct {
+	Id CommitID
+
+	// The parents of the commit. If history is being simplified, these
+	// may only be a subset of the real parents.
+	Parents []CommitID
+
+	// The committer date, as a unix timestamp.
+	Date int64
+
+	// Whether the commit is TREESAME to each of its Parents when
+	// limiting to paths.
+	treesame []bool
+
+	parsed bool
+	flags  revWalkFlags
+}
+
+// A queue of commits. Commits are taken from the queue ordered by date,
+// newest first, or in last-in first-out order if lifo is set. Commits with
+// the same date are taken in the order that they were added.
+type revWalkQueue struct {
+	lifo  bool
+	items []revWalkQueueItem
+	ctr   int
+}
+
+type revWalkQueueItem struct {
+	commit *revWalkCommit
+	ctr    int
+}
+
+func (q *revWalkQueue) less(i, j int) bool {
+	if q.items[i].commit.Date != q.items[j].commit.Date {
+		return q.items[i].commit.Date > q.items[j].commit.Date
 	}
-	return err
+	return q.items[i].ctr < q.items[j].ctr
 }
 
-func revListCallback(c *Client, opt RevListOptions, commits []CommitID, excludeList map[Sha1]struct{}, callback func(Sha1) error) error {
-	for _, cmt := range commits {
-		if _, ok := excludeList[Sha1(cmt)]; ok {
-			continue
+func (q *revWalkQueue) Len() int {
+	return len(q.items)
+}
+
+func (q *revWalkQueue) Put(cm *revWalkCommit) {
+	q.items = append(q.items, revWalkQueueItem{cm, q.ctr})
+	q.ctr++
+	if q.lifo {
+		return
+	}
+	for i := len(q.items) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !q.less(i, parent) {
+			break
 		}
+		q.items[i], q.items[parent] = q.items[parent], q.items[i]
+		i = parent
+	}
+}
 
-		if err := callback(Sha1(cmt)); err != nil {
-			return err
+// Returns the next commit in the queue without removing it.
+func (q *revWalkQueue) Peek() *revWalkCommit {
+	if q.lifo {
+		return q.items[len(q.items)-1].commit
+	}
+	return q.items[0].commit
+}
+
+func (q *revWalkQueue) Get() *revWalkCommit {
+	n := len(q.items) - 1
+	if q.lifo {
+		cm := q.items[n].commit
+		q.items = q.items[:n]
+		return cm
+	}
+	cm := q.items[0].commit
+	q.items[0] = q.items[n]
+	q.items = q.items[:n]
+	for i := 0; ; {
+		smallest := i
+		if l := 2*i + 1; l < n && q.less(l, smallest) {
+			smallest = l
+		}
+		if r := 2*i + 2; r < n && q.less(r, smallest) {
+			smallest = r
+		}
+		if smallest == i {
+			break
+		}
+		q.items[i], q.items[smallest] = q.items[smallest], q.items[i]
+		i = smallest
+	}
+	return cm
+}
+
+// Reverses the order of a last-in first-out queue.
+func (q *revWalkQueue) Reverse() {
+	for i, j := 0, len(q.items)-1; i < j; i, j = i+1, j-1 {
+		q.items[i], q.items[j] = q.items[j], q.items[i]
+	}
+}
+
+// A revWalk walks the commit graph for rev-list and log, in the same way
+// as git.
+type revWalk struct {
+	c   *Client
+	opt *RevListOptions
+
+	commits map[CommitID]*revWalkCommit
+
+	// The commits which haven't been walked yet.
+	queue revWalkQueue
+
+	// If the walk is limited, the whole graph is walked before any
+	// commits are listed, and list is the commits which may be listed.
+	limited bool
+	list    []*revWalkCommit
+
+	maxCount int
+	skip     uint
+
+	// The commits which may be listed as boundary commits once all the
+	// other commits have been listed.
+	boundary     []*revWalkCommit
+	boundaryMode bool
+
+	authors, committers, greps []*regexp.Regexp
+
+	// Whether commits which don't change the paths being limited to are
+	// removed, and whether merges are simplified to a parent that they
+	// don't change the paths from while walking.
+	prune, simplifyHistory bool
+
+	// The current name of the file being followed.
+	followPath IndexPath
+
+	// The commits which were excluded on the command line.
+	bottoms []*revWalkCommit
+
+	// The tags passed on the command line, which are listed with the
+	// objects.
+	tags []RevListEntry
+
+	// The trees and blobs that have been listed or are excluded.
+	objects map[Sha1]struct{}
+}
+
+func newRevWalk(c *Client, opt *RevListOptions) (*revWalk, error) {
+	w := &revWalk{
+		c:        c,
+		opt:      opt,
+		commits:  make(map[CommitID]*revWalkCommit),
+		maxCount: -1,
+		skip:     opt.Skip,
+		objects:  make(map[Sha1]struct{}),
+	}
+	if opt.MaxCount != nil {
+		w.maxCount = int(*opt.MaxCount)
+	}
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		var res []*regexp.Regexp
+		for _, pattern := range patterns {
+			if opt.RegexpIgnoreCase {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("fatal: command line, '%v': %v", pattern, err)
+			}
+			res = append(res, re)
+		}
+		return res, nil
+	}
+	var err error
+	if w.authors, err = compile(opt.Author); err != nil {
+		return nil, err
+	}
+	if w.committers, err = compile(opt.Committer); err != nil {
+		return nil, err
+	}
+	if w.greps, err = compile(opt.Grep); err != nil {
+		return nil, err
+	}
+	if opt.Follow {
+		if len(opt.Paths) != 1 {
+			return nil, fmt.Errorf("fatal: --follow requires exactly one pathspec")
 		}
-		excludeList[Sha1(cmt)] = struct{}{}
+		w.followPath = opt.Paths[0]
+	}
+	w.prune = len(opt.Paths) > 0 && !opt.Follow
+	w.simplifyHistory = !opt.FullHistory && !opt.SimplifyMerges && !opt.AncestryPath
+	if opt.SimplifyMerges {
+		opt.TopoOrder = true
+	}
+	if opt.TopoOrder || opt.DateOrder || opt.CherryMark || opt.CherryPick || opt.AncestryPath {
+		w.limited = true
+	}
+	return w, nil
+}
 
-		if opt.Objects {
-			objs, err := cmt.GetAllObjectsExcept(c, excludeList)
+// Returns the commit id in the walk, without parsing it.
+func (w *revWalk) lookup(id CommitID) *revWalkCommit {
+	if cm, ok := w.commits[id]; ok {
+		return cm
+	}
+	cm := &revWalkCommit{Id: id}
+	w.commits[id] = cm
+	return cm
+}
+
+// Reads the parents and date of cm.
+func (w *revWalk) parse(cm *revWalkCommit) error {
+	if cm.parsed {
+		return nil
+	}
+	obj, err := w.c.GetCommitObject(cm.Id)
+	if err != nil {
+		return err
+	}
+	for _, line := range bytes.Split(obj.GetContent(), []byte{'\n'}) {
+		if len(line) == 0 {
+			break
+		}
+		switch {
+		case bytes.HasPrefix(line, []byte("parent ")):
+			parent, err := CommitIDFromString(string(line[7:]))
 			if err != nil {
 				return err
 			}
-			for _, o := range objs {
-				excludeList[o] = struct{}{}
-				if err := callback(o); err != nil {
-					return err
-				}
+			cm.Parents = append(cm.Parents, parent)
+		case bytes.HasPrefix(line, []byte("committer ")):
+			pieces := strings.Fields(string(line))
+			if len(pieces) >= 3 {
+				cm.Date, _ = strconv.ParseInt(pieces[len(pieces)-2], 10, 64)
+			}
+		}
+	}
+	cm.parsed = true
+	return nil
+}
+
+// Adds cmt as one of the commits to start walking from.
+func (w *revWalk) addStart(cmt Commitish, flags revWalkFlags) error {
+	if pr, ok := cmt.(ParsedRevision); ok {
+		if pr.Left {
+			flags |= revLeft
+		}
+		if flags&revUninteresting == 0 && (w.opt.Objects || w.opt.ObjectsEdge) && pr.Id.Type(w.c) == "tag" {
+			w.tags = append(w.tags, RevListEntry{Id: pr.Id, Object: true, Name: pr.Name})
+		}
+	}
+	id, err := cmt.CommitID(w.c)
+	if err != nil {
+		return err
+	}
+	cm := w.lookup(id)
+	if err := w.parse(cm); err != nil {
+		return err
+	}
+	cm.flags |= flags
+	if flags&revUninteresting != 0 {
+		cm.flags |= revBottom
+		w.bottoms = append(w.bottoms, cm)
+		w.limited = true
+		w.markParentsUninteresting(cm)
+	}
+	if cm.flags&revSeen == 0 {
+		cm.flags |= revSeen
+		w.queue.Put(cm)
+	}
+	return nil
+}
+
+// Marks all the known ancestors of cm as uninteresting.
+func (w *revWalk) markParentsUninteresting(cm *revWalkCommit) {
+	pending := append([]CommitID(nil), cm.Parents...)
+	for len(pending) > 0 {
+		p := w.lookup(pending[len(pending)-1])
+		pending = pending[:len(pending)-1]
+		if p.flags&revUninteresting != 0 {
+			continue
+		}
+		p.flags |= revUninteresting
+		// Commits which haven't been parsed yet will have their parents
+		// marked when they're walked.
+		pending = append(pending, p.Parents...)
+	}
+}
+
+// Adds the parents of cm to the queue, simplifying history first if
+// limiting to paths.
+func (w *revWalk) processParents(cm *revWalkCommit, queue *revWalkQueue) error {
+	if cm.flags&revAdded != 0 {
+		return nil
+	}
+	cm.flags |= revAdded
+	if cm.flags&revUninteresting != 0 {
+		for _, pid := range cm.Parents {
+			p := w.lookup(pid)
+			p.flags |= revUninteresting
+			if err := w.parse(p); err != nil {
+				return err
+			}
+			w.markParentsUninteresting(p)
+			if p.flags&revSeen != 0 {
+				continue
 			}
+			p.flags |= revSeen
+			queue.Put(p)
+		}
+		return nil
+	}
+	if err := w.simplify(cm); err != nil {
+		return err
+	}
+	for _, pid := range cm.Parents {
+		p := w.lookup(pid)
+		if err := w.parse(p); err != nil {
+			return err
+		}
+		p.flags |= cm.flags & revLeft
+		if p.flags&revSeen == 0 {
+			p.flags |= revSeen
+			queue.Put(p)
+		}
+		if w.opt.FirstParent {
+			break
+		}
+	}
+	return nil
+}
+
+// Returns true if the commit cm is relevant for history simplification,
+// which is if it's interesting or was excluded on the command line.
+func (cm *revWalkCommit) relevant() bool {
+	return cm.flags&(revUninteresting|revBottom) != revUninteresting
+}
+
+// Marks cm as TREESAME if it doesn't change any of the paths being limited
+// to. Unless the full history was asked for, a merge which is TREESAME to
+// one of its parents only has that parent followed.
+func (w *revWalk) simplify(cm *revWalkCommit) error {
+	if !w.prune {
+		return nil
+	}
+	tree, err := cm.Id.TreeID(w.c)
+	if err != nil {
+		return err
+	}
+	if len(cm.Parents) == 0 {
+		same, err := pathspecTreeSame(w.c, TreeID{}, tree, w.opt.Paths, "")
+		if err != nil {
+			return err
 		}
-		parents, err := cmt.Parents(c)
+		if same {
+			cm.flags |= revTreeSame
+		}
+		return nil
+	}
+	cm.treesame = make([]bool, len(cm.Parents))
+	for i, pid := range cm.Parents {
+		if i == 1 && w.opt.FirstParent {
+			break
+		}
+		p := w.lookup(pid)
+		if err := w.parse(p); err != nil {
+			return err
+		}
+		ptree, err := pid.TreeID(w.c)
 		if err != nil {
 			return err
 		}
-		if err := revListCallback(c, opt, parents, excludeList, callback); err != nil {
+		same, err := pathspecTreeSame(w.c, ptree, tree, w.opt.Paths, "")
+		if err != nil {
 			return err
 		}
+		if same && w.simplifyHistory && p.relevant() {
+			cm.Parents = []CommitID{pid}
+			cm.treesame = []bool{true}
+			cm.flags |= revTreeSame
+			return nil
+		}
+		cm.treesame[i] = same
+	}
+	w.updateTreeSame(cm)
+	return nil
+}
+
+// Sets the TREESAME flag of cm from whether it's TREESAME to each of its
+// parents. A merge is TREESAME if it doesn't change the paths from any of
+// its relevant parents, or from any parent if none of them are relevant.
+func (w *revWalk) updateTreeSame(cm *revWalkCommit) {
+	var relevantParents int
+	var relevantChange, irrelevantChange bool
+	for i, pid := range cm.Parents {
+		if i == 1 && w.opt.FirstParent {
+			break
+		}
+		if w.lookup(pid).relevant() {
+			relevantParents++
+			relevantChange = relevantChange || !cm.treesame[i]
+		} else {
+			irrelevantChange = irrelevantChange || !cm.treesame[i]
+		}
+	}
+	if relevantParents > 0 && relevantChange || relevantParents == 0 && irrelevantChange {
+		cm.flags &^= revTreeSame
+	} else {
+		cm.flags |= revTreeSame
+	}
+}
+
+// Returns true if the commit cm changes the file being followed. If cm
+// created the file, the file that it was renamed or copied from is followed
+// from then on. Merges are never considered to change the file.
+func (w *revWalk) follow(cm *revWalkCommit) (bool, error) {
+	if len(cm.Parents) > 1 {
+		return false, nil
+	}
+	tree, err := cm.Id.TreeID(w.c)
+	if err != nil {
+		return false, err
+	}
+	var ptree TreeID
+	if len(cm.Parents) == 1 {
+		if ptree, err = cm.Parents[0].TreeID(w.c); err != nil {
+			return false, err
+		}
+	}
+	same, err := pathspecTreeSame(w.c, ptree, tree, []IndexPath{w.followPath}, "")
+	if err != nil || same || ptree == (TreeID{}) {
+		return !same, err
+	}
+	entry, ok, err := treeEntryAt(w.c, tree, w.followPath)
+	if err != nil || !ok || (entry.FileMode != ModeBlob && entry.FileMode != ModeExec) {
+		return true, err
+	}
+	if _, existed, err := treeEntryAt(w.c, ptree, w.followPath); err != nil || existed {
+		return true, err
+	}
+	src, err := renameSource(w.c, ptree, w.followPath, entry.Sha1)
+	if err != nil {
+		return false, err
+	}
+	if src != "" {
+		w.followPath = src
+	}
+	return true, nil
+}
+
+// Returns the path of the file in tree which the blob dst at the path name
+// is most similar to, if any are similar enough to be considered a rename
+// or copy. An identical file is preferred, and then one with the same base
+// name.
+func renameSource(c *Client, tree TreeID, name IndexPath, dst Sha1) (IndexPath, error) {
+	all, err := tree.GetAllObjects(c, "", true, false)
+	if err != nil {
+		return "", err
+	}
+	var paths []IndexPath
+	for path, entry := range all {
+		if entry.FileMode == ModeBlob || entry.FileMode == ModeExec {
+			paths = append(paths, path)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i] < paths[j] })
+
+	sameBase := func(path IndexPath) bool {
+		return filepath.Base(string(path)) == filepath.Base(string(name))
+	}
+	var best IndexPath
+	for _, path := range paths {
+		if all[path].Sha1 == dst && (best == "" || sameBase(path) && !sameBase(best)) {
+			best = path
+		}
+	}
+	if best != "" {
+		return best, nil
+	}
+
+	dstObj, err := c.GetObject(dst)
+	if err != nil {
+		return "", err
+	}
+	bestScore := defaultRenameScore - 1
+	for _, path := range paths {
+		srcObj, err := c.GetObject(all[path].Sha1)
+		if err != nil {
+			return "", err
+		}
+		score := similarityScore(srcObj.GetContent(), dstObj.GetContent(), defaultRenameScore)
+		if score > bestScore || score == bestScore && best != "" && sameBase(path) && !sameBase(best) {
+			best, bestScore = path, score
+		}
+	}
+	return best, nil
+}
+
+// Returns the entry for path in tree, and whether it exists.
+func treeEntryAt(c *Client, tree TreeID, path IndexPath) (TreeEntry, bool, error) {
+	pieces := strings.Split(string(path), "/")
+	for i, piece := range pieces {
+		entries, err := treeEntries(c, tree)
+		if err != nil {
+			return TreeEntry{}, false, err
+		}
+		var found *TreeEntry
+		for _, e := range entries {
+			if string(e.Name) == piece {
+				entry := e.TreeEntry
+				found = &entry
+				break
+			}
+		}
+		switch {
+		case found == nil:
+			return TreeEntry{}, false, nil
+		case i == len(pieces)-1:
+			return *found, true, nil
+		case found.FileMode != ModeTree:
+			return TreeEntry{}, false, nil
+		}
+		tree = TreeID(found.Sha1)
+	}
+	return TreeEntry{}, false, nil
+}
+
+// Returns true if the trees t1 and t2, which may be the zero TreeID for an
+// empty tree, have the same entries for the paths in pathspec. prefix is
+// the path of the trees.
+func pathspecTreeSame(c *Client, t1, t2 TreeID, pathspec []IndexPath, prefix string) (bool, error) {
+	if t1 == t2 {
+		return true, nil
+	}
+	entries1, err := treeEntries(c, t1)
+	if err != nil {
+		return false, err
+	}
+	entries2, err := treeEntries(c, t2)
+	if err != nil {
+		return false, err
+	}
+	byName := make(map[IndexPath]TreeEntry)
+	for _, e := range entries1 {
+		byName[e.Name] = e.TreeEntry
+	}
+	seen := make(map[IndexPath]struct{})
+	compare := func(name IndexPath, e1, e2 TreeEntry) (bool, error) {
+		if e1 == e2 {
+			return true, nil
+		}
+		path := prefix + string(name)
+		if pathspecMatches(pathspec, path) {
+			return false, nil
+		}
+		if !pathspecMayMatchUnder(pathspec, path) {
+			return true, nil
+		}
+		var sub1, sub2 TreeID
+		if e1.FileMode == ModeTree {
+			sub1 = TreeID(e1.Sha1)
+		}
+		if e2.FileMode == ModeTree {
+			sub2 = TreeID(e2.Sha1)
+		}
+		return pathspecTreeSame(c, sub1, sub2, pathspec, path+"/")
+	}
+	for _, e := range entries2 {
+		seen[e.Name] = struct{}{}
+		if same, err := compare(e.Name, byName[e.Name], e.TreeEntry); err != nil || !same {
+			return false, err
+		}
+	}
+	for _, e := range entries1 {
+		if _, ok := seen[e.Name]; ok {
+			continue
+		}
+		if same, err := compare(e.Name, e.TreeEntry, TreeEntry{}); err != nil || !same {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Returns true if path matches one of the paths in pathspec, either
+// exactly, by being under it, or by matching it as a wildcard.
+func pathspecMatches(pathspec []IndexPath, path string) bool {
+	for _, spec := range pathspec {
+		s := string(spec)
+		if s == "" || s == path || strings.HasPrefix(path, s+"/") {
+			return true
+		}
+		if strings.ContainsAny(s, "*?[") && wildmatch(s, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if something under the directory dir may match one of the
+// paths in pathspec.
+func pathspecMayMatchUnder(pathspec []IndexPath, dir string) bool {
+	for _, spec := range pathspec {
+		s := string(spec)
+		if strings.HasPrefix(s, dir+"/") {
+			return true
+		}
+		if wild := strings.IndexAny(s, "*?["); wild >= 0 {
+			literal := s[:wild]
+			if strings.HasPrefix(literal, dir+"/") || strings.HasPrefix(dir+"/", literal) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type namedTreeEntry struct {
+	Name IndexPath
+	TreeEntry
+}
+
+// Returns the entries of the tree t, in the order that they're stored. The
+// zero TreeID is treated as an empty tree.
+func treeEntries(c *Client, t TreeID) ([]namedTreeEntry, error) {
+	if t == (TreeID{}) {
+		return nil, nil
+	}
+	o, err := c.GetObject(Sha1(t))
+	if err != nil {
+		return nil, err
+	}
+	if o.GetType() != "tree" {
+		return nil, fmt.Errorf("%s is not a tree object", t)
+	}
+	content := o.GetContent()
+	var entries []namedTreeEntry
+	for i := 0; i < len(content); {
+		name, entry, size, err := parseRawTreeLine(i, content)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, namedTreeEntry{name, entry})
+		i += size
+	}
+	return entries, nil
+}
+
+// Walks the graph, if the walk is limited, so that the commits to list
+// are known, and sorts them.
+func (w *revWalk) prepare() error {
+	if !w.limited {
+		return nil
+	}
+	if err := w.limitList(); err != nil {
+		return err
+	}
+	switch {
+	case w.opt.TopoOrder:
+		w.list = w.sortTopo(w.list, false)
+	case w.opt.DateOrder:
+		w.list = w.sortTopo(w.list, true)
+	}
+	if w.opt.SimplifyMerges && w.prune {
+		return w.simplifyMerges()
+	}
+	return nil
+}
+
+// The number of extra commits to walk after all the commits remaining are
+// uninteresting, in case of clock skew.
+const revWalkSlop = 5
+
+// Walks the graph until there are no more interesting commits to find,
+// and sets w.list to the commits found.
+func (w *revWalk) limitList() error {
+	since := w.opt.Since.Unix()
+	date := int64(1<<63 - 1)
+	slop := revWalkSlop
+	for w.queue.Len() > 0 {
+		cm := w.queue.Get()
+		if !w.opt.Since.IsZero() && cm.Date < since {
+			cm.flags |= revUninteresting
+		}
+		if err := w.processParents(cm, &w.queue); err != nil {
+			return err
+		}
+		if cm.flags&revUninteresting != 0 {
+			w.markParentsUninteresting(cm)
+			if slop = w.stillInteresting(date, slop); slop > 0 {
+				continue
+			}
+			break
+		}
+		date = cm.Date
+		w.list = append(w.list, cm)
+	}
+	if w.opt.CherryMark || w.opt.CherryPick {
+		if err := w.cherryPick(); err != nil {
+			return err
+		}
+	}
+	if w.opt.AncestryPath {
+		w.limitToAncestry()
+	}
+	// Merges may have become TREESAME from parents becoming
+	// uninteresting.
+	if w.prune && !w.simplifyHistory && !w.opt.FirstParent {
+		for _, cm := range w.list {
+			if cm.flags&(revUninteresting|revTreeSame) == 0 && len(cm.treesame) > 1 {
+				w.updateTreeSame(cm)
+			}
+		}
+	}
+	return nil
+}
+
+// Marks the commits in the list which aren't descendants of any of the
+// commits excluded on the command line as uninteresting.
+func (w *revWalk) limitToAncestry() {
+	descendant := make(map[*revWalkCommit]bool)
+	for _, cm := range w.bottoms {
+		descendant[cm] = true
+	}
+	// The list is walked in reverse so that parents are likely to be
+	// found before their children.
+	for progress := true; progress; {
+		progress = false
+		for i := len(w.list) - 1; i >= 0; i-- {
+			cm := w.list[i]
+			if descendant[cm] || cm.flags&revUninteresting != 0 {
+				continue
+			}
+			for _, pid := range cm.Parents {
+				if descendant[w.lookup(pid)] {
+					descendant[cm] = true
+					progress = true
+					break
+				}
+			}
+		}
+	}
+	for _, cm := range w.list {
+		if !descendant[cm] {
+			cm.flags |= revUninteresting
+		}
+	}
+}
+
+// Rewrites the parents of the commits in the list to their nearest
+// ancestors which change the paths being limited to, and removes the
+// commits which don't change the paths and aren't needed to join the
+// history together.
+func (w *revWalk) simplifyMerges() error {
+	simplified := make(map[*revWalkCommit]*revWalkCommit)
+	// The list is fed in reverse so that parents are likely to be
+	// simplified before their children.
+	var todo []*revWalkCommit
+	for i := len(w.list) - 1; i >= 0; i-- {
+		todo = append(todo, w.list[i])
+	}
+	for len(todo) > 0 {
+		var next []*revWalkCommit
+		for _, cm := range todo {
+			var err error
+			if next, err = w.simplifyOne(cm, simplified, next); err != nil {
+				return err
+			}
+		}
+		todo = next
+	}
+	var list []*revWalkCommit
+	for _, cm := range w.list {
+		if simplified[cm] == cm {
+			list = append(list, cm)
+		}
+	}
+	w.list = list
+	return nil
+}
+
+// Finds the commit that cm simplifies to for simplifyMerges. If the
+// parents of cm haven't been simplified yet, they and cm are added to
+// todo to try again.
+func (w *revWalk) simplifyOne(cm *revWalkCommit, simplified map[*revWalkCommit]*revWalkCommit, todo []*revWalkCommit) ([]*revWalkCommit, error) {
+	if simplified[cm] != nil {
+		return todo, nil
+	}
+	if cm.flags&revUninteresting != 0 || len(cm.Parents) == 0 {
+		simplified[cm] = cm
+		return todo, nil
+	}
+	parents := cm.Parents
+	if w.opt.FirstParent {
+		parents = parents[:1]
+	}
+	ready := true
+	for _, pid := range parents {
+		p := w.lookup(pid)
+		if err := w.parse(p); err != nil {
+			return nil, err
+		}
+		if simplified[p] == nil {
+			todo = append(todo, p)
+			ready = false
+		}
+	}
+	if !ready {
+		return append(todo, cm), nil
+	}
+
+	// Rewrite the parents, removing any duplicates. A commit is always
+	// TREESAME to its simplification, so this doesn't change whether cm
+	// is TREESAME to them.
+	rewritten := make([]CommitID, 0, len(cm.Parents))
+	treesame := make([]bool, 0, len(cm.Parents))
+	seen := make(map[CommitID]bool)
+	for i, pid := range cm.Parents {
+		if i < len(parents) {
+			pid = simplified[w.lookup(pid)].Id
+		}
+		if seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		rewritten = append(rewritten, pid)
+		treesame = append(treesame, len(cm.treesame) > i && cm.treesame[i])
+	}
+	cm.Parents, cm.treesame = rewritten, treesame
+	if len(cm.Parents) == 1 && !w.opt.FirstParent {
+		if cm.treesame[0] {
+			cm.flags |= revTreeSame
+		} else {
+			cm.flags &^= revTreeSame
+		}
+	}
+
+	if len(cm.Parents) > 1 && !w.opt.FirstParent {
+		// Parents which are ancestors of another parent, or roots which
+		// don't have any of the paths, don't contribute anything, so are
+		// removed. If cm is only TREESAME to one of them, the first such
+		// parent is kept, since it's the one that would be followed by
+		// default.
+		remove := make(map[CommitID]bool)
+		for _, pid := range cm.Parents {
+			p := w.lookup(pid)
+			if len(p.Parents) == 0 && p.flags&revTreeSame != 0 {
+				remove[pid] = true
+				continue
+			}
+			for _, other := range cm.Parents {
+				if other == pid || remove[other] {
+					continue
+				}
+				ancestor, err := w.isAncestor(pid, other)
+				if err != nil {
+					return nil, err
+				}
+				if ancestor {
+					remove[pid] = true
+					break
+				}
+			}
+		}
+		if len(remove) > 0 {
+			var keep CommitID
+			for i, pid := range cm.Parents {
+				if !cm.treesame[i] {
+					continue
+				}
+				if !remove[pid] {
+					keep = CommitID{}
+					break
+				}
+				if keep == (CommitID{}) {
+					keep = pid
+				}
+			}
+			delete(remove, keep)
+		}
+		if len(remove) > 0 {
+			rewritten, treesame := cm.Parents[:0], cm.treesame[:0]
+			for i, pid := range cm.Parents {
+				if !remove[pid] {
+					rewritten = append(rewritten, pid)
+					treesame = append(treesame, cm.treesame[i])
+				}
+			}
+			cm.Parents, cm.treesame = rewritten, treesame
+			if len(cm.Parents) == 1 {
+				if cm.treesame[0] {
+					cm.flags |= revTreeSame
+				} else {
+					cm.flags &^= revTreeSame
+				}
+			} else if cm.flags&revTreeSame == 0 {
+				w.updateTreeSame(cm)
+			}
+		}
+	}
+
+	// A commit which changes the paths simplifies to itself, as does a
+	// merge whose parents don't simplify to a single relevant commit.
+	// Otherwise, it simplifies to what its relevant parent does.
+	if cm.flags&revTreeSame == 0 {
+		simplified[cm] = cm
+		return todo, nil
+	}
+	if relevant := w.oneRelevantParent(cm); relevant == nil {
+		simplified[cm] = cm
+	} else {
+		simplified[cm] = simplified[relevant]
+	}
+	return todo, nil
+}
+
+// Returns true if the commit ancestor is reachable from descendant.
+func (w *revWalk) isAncestor(ancestor, descendant CommitID) (bool, error) {
+	seen := map[CommitID]bool{descendant: true}
+	pending := []CommitID{descendant}
+	for len(pending) > 0 {
+		cm := w.lookup(pending[len(pending)-1])
+		pending = pending[:len(pending)-1]
+		if cm.Id == ancestor {
+			return true, nil
+		}
+		if err := w.parse(cm); err != nil {
+			return false, err
+		}
+		for _, pid := range cm.Parents {
+			if !seen[pid] {
+				seen[pid] = true
+				pending = append(pending, pid)
+			}
+		}
+	}
+	return false, nil
+}
+
+// Returns the remaining slop if all the commits remaining in the queue are
+// uninteresting and older than date.
+func (w *revWalk) stillInteresting(date int64, slop int) int {
+	if w.queue.Len() == 0 {
+		return 0
+	}
+	if date <= w.queue.Peek().Date {
+		return revWalkSlop
+	}
+	for _, item := range w.queue.items {
+		if item.commit.flags&revUninteresting == 0 {
+			return revWalkSlop
+		}
+	}
+	return slop - 1
+}
+
+// Sorts commits so that no parent comes before all of its children. If
+// byDate is set, commits are otherwise ordered by date, and if not,
+// commits on the same line of history are kept together.
+func (w *revWalk) sortTopo(commits []*revWalkCommit, byDate bool) []*revWalkCommit {
+	indegree := make(map[*revWalkCommit]int, len(commits))
+	for _, cm := range commits {
+		indegree[cm] = 1
+	}
+	for _, cm := range commits {
+		for _, pid := range cm.Parents {
+			if p := w.commits[pid]; p != nil && indegree[p] > 0 {
+				indegree[p]++
+			}
+		}
+	}
+	queue := revWalkQueue{lifo: !byDate}
+	for _, cm := range commits {
+		if indegree[cm] == 1 {
+			queue.Put(cm)
+		}
+	}
+	if !byDate {
+		// The tips are shown in the order that they were found.
+		queue.Reverse()
+	}
+	sorted := make([]*revWalkCommit, 0, len(commits))
+	for queue.Len() > 0 {
+		cm := queue.Get()
+		for _, pid := range cm.Parents {
+			p := w.commits[pid]
+			if p == nil || indegree[p] == 0 {
+				continue
+			}
+			indegree[p]--
+			if indegree[p] == 1 {
+				queue.Put(p)
+			}
+		}
+		indegree[cm] = 0
+		sorted = append(sorted, cm)
+	}
+	return sorted
+}
+
+// Marks the commits in the list which have the same patch as a commit on
+// the other side of a symmetric difference.
+func (w *revWalk) cherryPick() error {
+	var left, right int
+	for _, cm := range w.list {
+		if cm.flags&revLeft != 0 {
+			left++
+		} else {
+			right++
+		}
+	}
+	if left == 0 || right == 0 {
+		return nil
+	}
+	// The patch ids are found for the side with fewer commits, and
+	// then looked up for the other side.
+	leftFirst := left < right
+	ids := make(map[Sha1][]*revWalkCommit)
+	for _, cm := range w.list {
+		if leftFirst != (cm.flags&revLeft != 0) {
+			continue
+		}
+		id, err := w.patchID(cm)
+		if err != nil {
+			return err
+		}
+		if id != (Sha1{}) {
+			ids[id] = append(ids[id], cm)
+		}
+	}
+	flag := revShown
+	if w.opt.CherryMark {
+		flag = revPatchSame
+	}
+	for _, cm := range w.list {
+		if leftFirst == (cm.flags&revLeft != 0) {
+			continue
+		}
+		id, err := w.patchID(cm)
+		if err != nil {
+			return err
+		}
+		same, ok := ids[id]
+		if id == (Sha1{}) || !ok {
+			continue
+		}
+		cm.flags |= flag
+		for _, other := range same {
+			other.flags |= flag
+		}
+	}
+	return nil
+}
+
+// Returns an id for the change introduced by cm, which is the same for
+// commits that introduce the same change regardless of line numbers and
+// whitespace. Merges don't have a patch id, and the zero Sha1 is returned.
+func (w *revWalk) patchID(cm *revWalkCommit) (Sha1, error) {
+	var diffs []HashDiff
+	var err error
+	switch len(cm.Parents) {
+	case 0:
+		diffs, err = DiffTree(w.c, &DiffTreeOptions{Recurse: true, Root: true}, cm.Id, nil, nil)
+	case 1:
+		diffs, err = DiffTree(w.c, &DiffTreeOptions{Recurse: true}, cm.Parents[0], cm.Id, nil)
+	default:
+		return Sha1{}, nil
+	}
+	if err != nil {
+		return Sha1{}, err
+	}
+	h := sha1.New()
+	for _, diff := range diffs {
+		if len(w.opt.Paths) > 0 && !pathspecMatches(w.opt.Paths, diff.Name.String()) {
+			continue
+		}
+		patch, err := diff.ExternalDiff(w.c, diff.Src, diff.Dst, File(diff.Name), DiffCommonOptions{NumContextLines: 3})
+		if err != nil {
+			return Sha1{}, err
+		}
+		fmt.Fprintf(h, "diff --git a/%v b/%v\n", diff.Name, diff.Name)
+		for _, line := range strings.Split(patch, "\n") {
+			if strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "@@") {
+				continue
+			}
+			h.Write([]byte(strings.Join(strings.Fields(line), "")))
+		}
+	}
+	var id Sha1
+	copy(id[:], h.Sum(nil))
+	return id, nil
+}
+
+// Returns the only parent of cm which is relevant for simplification, or
+// nil if there are none or more than one. The first parent is always the
+// only one if following first parents.
+func (w *revWalk) oneRelevantParent(cm *revWalkCommit) *revWalkCommit {
+	if len(cm.Parents) == 0 {
+		return nil
+	}
+	if len(cm.Parents) == 1 || w.opt.FirstParent {
+		return w.lookup(cm.Parents[0])
+	}
+	var relevant *revWalkCommit
+	for _, pid := range cm.Parents {
+		if p := w.lookup(pid); p.relevant() {
+			if relevant != nil {
+				return nil
+			}
+			relevant = p
+		}
+	}
+	return relevant
+}
+
+// Replaces each parent of cm which won't be listed because it's TREESAME
+// with its nearest ancestor which changes the paths, or is excluded, as
+// git does for --parents and --graph. Parents which have no such
+// ancestors are removed, as are duplicates.
+func (w *revWalk) rewriteParents(cm *revWalkCommit) error {
+	var rewritten []CommitID
+	var treesame []bool
+	seen := make(map[CommitID]bool)
+	for i, pid := range cm.Parents {
+		p := w.lookup(pid)
+		for {
+			if !w.limited {
+				if err := w.parse(p); err != nil {
+					return err
+				}
+				if err := w.processParents(p, &w.queue); err != nil {
+					return err
+				}
+			}
+			if p.flags&(revUninteresting|revTreeSame) != revTreeSame {
+				break
+			}
+			if len(p.Parents) == 0 {
+				p = nil
+				break
+			}
+			next := w.oneRelevantParent(p)
+			if next == nil {
+				break
+			}
+			p = next
+		}
+		if p == nil || seen[p.Id] {
+			continue
+		}
+		seen[p.Id] = true
+		rewritten = append(rewritten, p.Id)
+		treesame = append(treesame, i < len(cm.treesame) && cm.treesame[i])
+	}
+	cm.Parents, cm.treesame = rewritten, treesame
+	return nil
+}
+
+// Returns the parents of cm which the walk lists, or will list as boundary
+// commits.
+func (w *revWalk) listedParents(cm *revWalkCommit) ([]CommitID, error) {
+	var parents []CommitID
+	for _, pid := range cm.Parents {
+		p := w.lookup(pid)
+		if err := w.parse(p); err != nil {
+			return nil, err
+		}
+		if w.opt.Boundary && p.flags&revChildShown != 0 {
+			parents = append(parents, pid)
+		} else if wanted, err := w.wanted(p); err != nil {
+			return nil, err
+		} else if wanted {
+			parents = append(parents, pid)
+		}
+		if w.opt.FirstParent {
+			break
+		}
+	}
+	return parents, nil
+}
+
+// Returns the next commit which matches the options, without regard to
+// the count or boundary options.
+func (w *revWalk) next1() (*revWalkCommit, error) {
+	for {
+		var cm *revWalkCommit
+		if w.limited {
+			if len(w.list) == 0 {
+				return nil, nil
+			}
+			cm, w.list = w.list[0], w.list[1:]
+		} else {
+			if w.queue.Len() == 0 {
+				return nil, nil
+			}
+			cm = w.queue.Get()
+			if !w.opt.Since.IsZero() && cm.Date < w.opt.Since.Unix() {
+				continue
+			}
+			if err := w.processParents(cm, &w.queue); err != nil {
+				return nil, err
+			}
+		}
+		show, err := w.wanted(cm)
+		if err != nil {
+			return nil, err
+		}
+		if show {
+			return cm, nil
+		}
+	}
+}
+
+// Returns true if the commit cm matches the options for which commits to
+// list.
+func (w *revWalk) wanted(cm *revWalkCommit) (bool, error) {
+	if cm.flags&(revShown|revUninteresting) != 0 {
+		return false, nil
+	}
+	if !w.opt.Until.IsZero() && cm.Date > w.opt.Until.Unix() {
+		return false, nil
+	}
+	if n := uint(len(cm.Parents)); n < w.opt.MinParents || (w.opt.MaxParents != nil && n > *w.opt.MaxParents) {
+		return false, nil
+	}
+	if len(w.authors) > 0 || len(w.committers) > 0 || len(w.greps) > 0 {
+		match, err := w.matches(cm)
+		if err != nil || !match {
+			return false, err
+		}
+	}
+	if cm.flags&revTreeSame != 0 {
+		// When the history is being rewritten, merges are kept to
+		// join the lines of history together.
+		if !w.opt.SimplifyMerges && !w.opt.RewriteParents {
+			return false, nil
+		}
+		var relevant int
+		for _, pid := range cm.Parents {
+			if w.lookup(pid).relevant() {
+				relevant++
+			}
+		}
+		return relevant >= 2, nil
+	}
+	return true, nil
+}
+
+// Returns true if the commit matches the Author, Committer and Grep
+// options.
+func (w *revWalk) matches(cm *revWalkCommit) (bool, error) {
+	obj, err := w.c.GetCommitObject(cm.Id)
+	if err != nil {
+		return false, err
+	}
+	var author, committer string
+	content := string(obj.GetContent())
+	headers, message := content, ""
+	if end := strings.Index(content, "\n\n"); end >= 0 {
+		headers, message = content[:end], content[end+2:]
+	}
+	for _, line := range strings.Split(headers, "\n") {
+		// Only the name and email are matched, not the date.
+		switch {
+		case strings.HasPrefix(line, "author "):
+			author = line[7:]
+			if end := strings.LastIndexByte(author, '>'); end >= 0 {
+				author = author[:end+1]
+			}
+		case strings.HasPrefix(line, "committer "):
+			committer = line[10:]
+			if end := strings.LastIndexByte(committer, '>'); end >= 0 {
+				committer = committer[:end+1]
+			}
+		}
+	}
+	anyMatch := func(res []*regexp.Regexp, str string) bool {
+		for _, re := range res {
+			if re.MatchString(str) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(w.authors) > 0 && !anyMatch(w.authors, author) {
+		return false, nil
+	}
+	if len(w.committers) > 0 && !anyMatch(w.committers, committer) {
+		return false, nil
+	}
+	if len(w.greps) == 0 {
+		return true, nil
+	}
+	lines := strings.Split(message, "\n")
+	for _, re := range w.greps {
+		matched := false
+		for _, line := range lines {
+			if re.MatchString(line) {
+				matched = true
+				break
+			}
+		}
+		if matched && !w.opt.AllMatch {
+			return true, nil
+		}
+		if !matched && w.opt.AllMatch {
+			return false, nil
+		}
+	}
+	return w.opt.AllMatch, nil
+}
+
+// Returns the next commit to list, or nil when there are no more.
+func (w *revWalk) next() (*revWalkCommit, error) {
+	if w.boundaryMode {
+		if len(w.list) == 0 {
+			return nil, nil
+		}
+		cm := w.list[0]
+		w.list = w.list[1:]
+		cm.flags |= revShown
+		return cm, nil
+	}
+
+	var cm *revWalkCommit
+	if w.maxCount != 0 {
+		var err error
+		if cm, err = w.next1(); err != nil {
+			return nil, err
+		}
+		for ; cm != nil && w.skip > 0; w.skip-- {
+			if cm, err = w.next1(); err != nil {
+				return nil, err
+			}
+		}
+		if w.maxCount > 0 {
+			w.maxCount--
+		}
+	}
+	if cm != nil {
+		cm.flags |= revShown
+	}
+	if !w.opt.Boundary {
+		return cm, nil
+	}
+	if cm == nil {
+		// All the commits have been listed, so the parents of
+		// them which weren't listed are the boundary.
+		w.boundaryMode = true
+		w.list = nil
+		for _, b := range w.boundary {
+			if b.flags&revChildShown == 0 || b.flags&(revShown|revBoundary) != 0 {
+				continue
+			}
+			b.flags |= revBoundary
+			w.list = append([]*revWalkCommit{b}, w.list...)
+		}
+		w.list = w.sortTopo(w.list, false)
+		return w.next()
+	}
+	for _, pid := range cm.Parents {
+		p := w.lookup(pid)
+		if p.flags&(revChildShown|revShown) != 0 {
+			continue
+		}
+		p.flags |= revChildShown
+		w.boundary = append(w.boundary, p)
+	}
+	return cm, nil
+}
+
+// Excludes the objects of the uninteresting commits at the edges of the
+// range from being listed, and lists the commits with callback if
+// ObjectsEdge is set.
+func (w *revWalk) markEdges(callback func(RevListEntry) error) error {
+	for _, cm := range w.list {
+		if cm.flags&revUninteresting != 0 {
+			if err := w.markTreeUninteresting(cm.Id); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, pid := range cm.Parents {
+			p := w.lookup(pid)
+			if p.flags&revUninteresting == 0 {
+				continue
+			}
+			if err := w.markTreeUninteresting(pid); err != nil {
+				return err
+			}
+			if w.opt.ObjectsEdge && p.flags&revShown == 0 {
+				p.flags |= revShown
+				if err := callback(RevListEntry{Id: Sha1(pid), Boundary: true}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Excludes all the objects in the tree of cmt from being listed.
+func (w *revWalk) markTreeUninteresting(cmt CommitID) error {
+	tree, err := cmt.TreeID(w.c)
+	if err != nil {
+		return err
+	}
+	if _, ok := w.objects[Sha1(tree)]; ok {
+		return nil
+	}
+	_, err = tree.GetAllObjectsExcept(w.c, w.objects, "", true, false)
+	return err
+}
+
+// Lists the objects in tree which haven't already been listed or excluded.
+// The tree itself is listed with the name path.
+func (w *revWalk) listTree(tree TreeID, path string, callback func(RevListEntry) error) error {
+	if _, ok := w.objects[Sha1(tree)]; ok {
+		return nil
+	}
+	w.objects[Sha1(tree)] = struct{}{}
+	if err := callback(RevListEntry{Id: Sha1(tree), Object: true, Name: path}); err != nil {
+		return err
+	}
+	entries, err := treeEntries(w.c, tree)
+	if err != nil {
+		return err
+	}
+	prefix := path
+	if prefix != "" {
+		prefix += "/"
+	}
+	for _, e := range entries {
+		name := prefix + string(e.Name)
+		isTree := e.FileMode == ModeTree
+		if len(w.opt.Paths) > 0 && !pathspecMatches(w.opt.Paths, name) && !(isTree && pathspecMayMatchUnder(w.opt.Paths, name)) {
+			continue
+		}
+		switch {
+		case isTree:
+			if err := w.listTree(TreeID(e.Sha1), name, callback); err != nil {
+				return err
+			}
+		case e.FileMode != ModeCommit:
+			if _, ok := w.objects[e.Sha1]; ok {
+				continue
+			}
+			w.objects[e.Sha1] = struct{}{}
+			if err := callback(RevListEntry{Id: e.Sha1, Object: true, Name: name}); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -0,0 +1,199 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRevListOptions(t *testing.T) {
+	c, dir, A, B, C, M := testRevisionSetup(t)
+	defer os.RemoveAll(dir)
+
+	one := uint(1)
+	tests := []struct {
+		Opt      RevListOptions
+		Includes []CommitID
+		Excludes []CommitID
+		Want     string
+	}{
+		{RevListOptions{FirstParent: true}, []CommitID{M}, nil, fmt.Sprintf("%v\n%v\n%v\n", M, B, A)},
+		{RevListOptions{FirstParent: true, Reverse: true}, []CommitID{M}, nil, fmt.Sprintf("%v\n%v\n%v\n", A, B, M)},
+		{RevListOptions{FirstParent: true, Skip: 1, MaxCount: &one}, []CommitID{M}, nil, fmt.Sprintf("%v\n", B)},
+		{RevListOptions{MinParents: 2}, []CommitID{M}, nil, fmt.Sprintf("%v\n", M)},
+		{RevListOptions{MaxParents: &one, Count: true}, []CommitID{M}, nil, "3\n"},
+		{RevListOptions{Grep: []string{"merge"}}, []CommitID{M}, nil, fmt.Sprintf("%v\n", M)},
+		{RevListOptions{Grep: []string{"THIRD"}, RegexpIgnoreCase: true}, []CommitID{M}, nil, fmt.Sprintf("%v\n", C)},
+		{RevListOptions{Grep: []string{"third", "merge"}, AllMatch: true}, []CommitID{M}, nil, ""},
+		{RevListOptions{Boundary: true}, []CommitID{M}, []CommitID{C}, fmt.Sprintf("%v\n%v\n-%v\n-%v\n", M, B, C, A)},
+		{RevListOptions{Objects: true}, []CommitID{M}, []CommitID{B}, fmt.Sprintf("%v\n%v\n", M, C)},
+		// C doesn't change foo.txt, so the merge is simplified to only
+		// follow B.
+		{RevListOptions{Paths: []IndexPath{"foo.txt"}}, []CommitID{M}, nil, fmt.Sprintf("%v\n%v\n", B, A)},
+		{RevListOptions{Paths: []IndexPath{"foo.txt"}, FullHistory: true, Count: true}, []CommitID{M}, nil, "3\n"},
+		{RevListOptions{Paths: []IndexPath{"bar.txt"}}, []CommitID{M}, nil, ""},
+		// C is simplified to A, which is redundant since it's an
+		// ancestor of B, so the merge is simplified to B.
+		{RevListOptions{Paths: []IndexPath{"foo.txt"}, SimplifyMerges: true}, []CommitID{M}, nil, fmt.Sprintf("%v\n%v\n", B, A)},
+		{RevListOptions{AncestryPath: true}, []CommitID{M}, []CommitID{C}, fmt.Sprintf("%v\n", M)},
+	}
+	for i, test := range tests {
+		var includes, excludes []Commitish
+		for _, cmt := range test.Includes {
+			includes = append(includes, cmt)
+		}
+		for _, cmt := range test.Excludes {
+			excludes = append(excludes, cmt)
+		}
+		var out bytes.Buffer
+		if _, err := RevList(c, test.Opt, &out, includes, excludes); err != nil {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+			continue
+		}
+		if got := out.String(); got != test.Want {
+			t.Errorf("Test %d: got %q want %q", i, got, test.Want)
+		}
+	}
+}
+
+func TestRevListLeftRight(t *testing.T) {
+	c, dir, _, _, _, _ := testRevisionSetup(t)
+	defer os.RemoveAll(dir)
+
+	revs, err := RevParse(c, RevParseOptions{}, []string{"v1...side"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var includes, excludes []Commitish
+	for _, rev := range revs {
+		if rev.Excluded {
+			excludes = append(excludes, rev)
+		} else {
+			includes = append(includes, rev)
+		}
+	}
+	var out bytes.Buffer
+	if _, err := RevList(c, RevListOptions{LeftRight: true, Count: true}, &out, includes, excludes); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "1\t1\n" {
+		t.Errorf("got %q want %q", got, "1\t1\n")
+	}
+}
+
+func TestRevListFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrevlistfollow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for i := 0; i < 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	commit := func(msg string, files map[string]string) CommitID {
+		t.Helper()
+		for name, content := range files {
+			if content == "" {
+				if err := Rm(c, RmOptions{Quiet: true}, []File{File(name)}); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Add(c, AddOptions{}, []File{File(name)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cmt, err := Commit(c, CommitOptions{}, CommitMessage(msg), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmt
+	}
+	A := commit("add", map[string]string{"a.txt": strings.Join(lines, "\n") + "\n"})
+	B := commit("other", map[string]string{"other.txt": "other\n"})
+	C := commit("rename", map[string]string{"a.txt": "", "b.txt": strings.Join(lines[1:], "\n") + "\n"})
+	D := commit("modify", map[string]string{"b.txt": strings.Join(lines[2:], "\n") + "\n"})
+	commit("other again", map[string]string{"other.txt": "changed\n"})
+
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := uint(1)
+	tests := []struct {
+		Opt  RevListOptions
+		Want []CommitID
+	}{
+		{RevListOptions{Paths: []IndexPath{"b.txt"}}, []CommitID{D, C}},
+		{RevListOptions{Paths: []IndexPath{"b.txt"}, Follow: true}, []CommitID{D, C, A}},
+		{RevListOptions{Paths: []IndexPath{"b.txt"}, Follow: true, MaxCount: &one}, []CommitID{D}},
+		{RevListOptions{Paths: []IndexPath{"other.txt"}, Follow: true}, []CommitID{head, B}},
+	}
+	for i, test := range tests {
+		var got []CommitID
+		if err := RevListCallback(c, test.Opt, []Commitish{head}, nil, func(s Sha1) error {
+			got = append(got, CommitID(s))
+			return nil
+		}); err != nil {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.Want) {
+			t.Errorf("Test %d: got %v want %v", i, got, test.Want)
+		}
+	}
+}
+
+func TestSimilarityScore(t *testing.T) {
+	tests := []struct {
+		Src, Dst string
+		Want     int
+	}{
+		{"a\nb\nc\nd\n", "a\nb\nc\nd\n", maxSimilarityScore},
+		{"a\nb\nc\nd\n", "a\nb\n", maxSimilarityScore / 2},
+		{"a\nb\nc\nd\n", "a\nb\nx\ny\n", maxSimilarityScore / 2},
+		{"a\r\nb\r\n", "a\nb\n", maxSimilarityScore * 4 / 6},
+		{"a\nb\nc\nd\n", "e\nf\ng\nh\n", 0},
+		{"", "", 0},
+	}
+	for _, test := range tests {
+		if got := similarityScore([]byte(test.Src), []byte(test.Dst), 0); got != test.Want {
+			t.Errorf("similarityScore(%q, %q): got %v want %v", test.Src, test.Dst, got, test.Want)
+		}
+	}
+}
+
+func TestWildmatch(t *testing.T) {
+	tests := []struct {
+		Pattern, Name string
+		Want          bool
+	}{
+		{"refs/heads/*", "refs/heads/master", true},
+		{"refs/heads/*", "refs/heads/topic/a", true},
+		{"refs/heads/*", "refs/tags/v1", false},
+		{"refs/tags/v[0-9]", "refs/tags/v1", true},
+		{"refs/tags/v[!0-9]", "refs/tags/v1", false},
+		{"foo?.txt", "foo1.txt", true},
+		{"foo.txt", "foo.txt", true},
+		{"foo.txt", "fooxtxt", false},
+	}
+	for _, test := range tests {
+		if got := wildmatch(test.Pattern, test.Name); got != test.Want {
+			t.Errorf("wildmatch(%q, %q): got %v want %v", test.Pattern, test.Name, got, test.Want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -14,26 +15,38 @@ type Pattern string
 type ParsedRevision struct {
 	Id       Sha1
 	Excluded bool
+
+	// The name that rev-parse prints instead of Id, if set by one of
+	// the Short, Symbolic, SymbolicFullName or AbbrefRev options.
+	Name string
+
+	// Set for the left side of a symmetric difference (A...B), so that
+	// the commits reachable from it can be told apart by rev-list.
+	Left bool
+
+	// Set for the output of an argument to rev-parse which isn't a
+	// revision, such as --git-dir or a file name. Only Name is set, and
+	// is printed as is.
+	NotRevision bool
 }
 
 func (pr ParsedRevision) CommitID(c *Client) (CommitID, error) {
-	if pr.Id.Type(c) != "commit" {
+	cmt, err := peelRevision(c, pr.Id, "commit")
+	if err != nil {
 		return CommitID{}, fmt.Errorf("Invalid revision commit: %v", pr.Id)
 	}
-	return CommitID(pr.Id), nil
+	return CommitID(cmt), nil
 }
 
 func (pr ParsedRevision) TreeID(c *Client) (TreeID, error) {
-	if pr.Id.Type(c) != "commit" {
+	tree, err := peelRevision(c, pr.Id, "tree")
+	if err != nil {
 		return TreeID{}, fmt.Errorf("Invalid revision commit")
 	}
-	return CommitID(pr.Id).TreeID(c)
+	return TreeID(tree), nil
 }
 
 func (pr ParsedRevision) IsAncestor(c *Client, parent Commitish) bool {
-	if pr.Id.Type(c) != "commit" {
-		return false
-	}
 	com, err := pr.CommitID(c)
 	if err != nil {
 		return false
@@ -99,75 +112,16 @@ type RevParseOptions struct {
 
 // RevParsePath parses a path spec such as `HEAD:README.md` into the value that
 // it represents. The Sha1 returned may be either a tree or a blob, depending on
-// the pathspec.
+// the pathspec. An arg without a path refers to the tree of the revision.
 func RevParsePath(c *Client, opt *RevParseOptions, arg string) (Sha1, error) {
-	var tree Treeish
-	var err error
-	var treepart string
-	pathcomponent := strings.Index(arg, ":")
-	if pathcomponent < 0 {
-		treepart = arg
-	} else if pathcomponent == 0 {
-		// Nothing specified for rev refers to the
-		// index, not the head.
-		files, err := LsFiles(c, LsFilesOptions{Cached: true}, []File{File(arg[1:])})
-		if len(files) != 1 || err != nil {
-			return Sha1{}, fmt.Errorf("%v not found", arg)
-		}
-
-		for _, entry := range files {
-			if entry.IndexEntry.PathName == IndexPath(arg[1:]) {
-				return entry.Sha1, nil
-			}
-			return Sha1{}, fmt.Errorf("%v not found", arg)
-		}
-	} else {
-		treepart = arg[0:pathcomponent]
-	}
-	if len(arg) == 40 {
-		comm, err := Sha1FromString(arg)
-		if err != nil {
-			goto notsha1
-		}
-		switch comm.Type(c) {
-		case "blob":
-			if pathcomponent >= 0 {
-				// There was a path part, but there's no way for a path
-				// to be in a blob.
-				return Sha1{}, fmt.Errorf("Could not parse %v", arg)
-			}
-			return comm, nil
-		case "tree":
-			tree = TreeID(comm)
-			goto extractpath
-		case "commit":
-			tree = CommitID(comm)
-			goto extractpath
-		default:
-			return Sha1{}, fmt.Errorf("%s is not a valid sha1", arg)
-		}
-	}
-notsha1:
-	tree, err = RevParseTreeish(c, opt, treepart)
+	obj, err := resolveRevision(c, opt, arg)
 	if err != nil {
 		return Sha1{}, err
 	}
-extractpath:
-	if pathcomponent < 0 {
-		treeid, err := tree.TreeID(c)
-		if err != nil {
-			return Sha1{}, err
-		}
-		return Sha1(treeid), nil
-	}
-	path := arg[pathcomponent+1:]
-	indexes, err := expandGitTreeIntoIndexes(c, tree, true, true, false)
-	for _, entry := range indexes {
-		if entry.PathName == IndexPath(path) {
-			return entry.Sha1, nil
-		}
+	if strings.HasPrefix(arg, ":") || revisionPathSeparator(arg) >= 0 {
+		return obj, nil
 	}
-	return Sha1{}, fmt.Errorf("%v not found", arg)
+	return peelRevision(c, obj, "tree")
 }
 
 // RevParseTreeish will parse a single revision into a Treeish structure.
@@ -190,6 +144,17 @@ func RevParseTreeish(c *Client, opt *RevParseOptions, arg string) (Treeish, erro
 	if arg == "HEAD" {
 		return c.GetHeadCommit()
 	}
+	if strings.HasPrefix(arg, ":") || revisionPathSeparator(arg) >= 0 || strings.Contains(arg, "^{") {
+		obj, err := resolveRevision(c, opt, arg)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := peelRevision(c, obj, "tree")
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a tree-ish", arg)
+		}
+		return TreeID(tree), nil
+	}
 
 	refs, err := ShowRef(c, ShowRefOptions{}, []string{arg})
 	if err == nil && len(refs) > 0 {
@@ -204,49 +169,28 @@ func RevParseTreeish(c *Client, opt *RevParseOptions, arg string) (Treeish, erro
 	return cid.CommitID(c)
 }
 
-// RevParse will parse a single revision into a Commitish object.
+// RevParse will parse a single revision into a Commitish object. arg may use
+// any of the syntax described in gitrevisions(7) other than ranges, but
+// must name a commit or tag.
 func RevParseCommitish(c *Client, opt *RevParseOptions, arg string) (cmt Commitish, err error) {
-	var cmtbase string
-	if pos := strings.IndexAny(arg, "@^"); pos >= 0 {
-		cmtbase = arg[:pos]
-		defer func(mod string) {
-			if err != nil {
-				// If there was already an error, then just let it be.
-				return
-			}
-			// FIXME: This should actually implement various ^ and @{} modifiers
-			switch mod {
-			case "^0":
-				basecmt, newerr := cmt.CommitID(c)
-				if newerr != nil {
-					err = newerr
-					return
-				}
-				cmt = basecmt
-				return
-			case "^":
-				basecmt, newerr := cmt.CommitID(c)
-				if newerr != nil {
-					err = newerr
-					return
-				}
-				parents, newerr := basecmt.Parents(c)
-				if newerr != nil {
-					err = newerr
-					return
-				}
-				if len(parents) != 1 {
-					err = fmt.Errorf("Can not use ^ modifier on merge commit.")
-					return
-				}
-				cmt = parents[0]
-				return
-			}
-			err = fmt.Errorf("Unhandled commit modifier: %v", mod)
-		}(arg[pos:])
-	} else {
-		cmtbase = arg
+	if base, mods := splitRevisionModifiers(arg); mods == "" && !strings.HasPrefix(arg, ":") && revisionPathSeparator(arg) < 0 {
+		return revParseBase(c, opt, base)
+	}
+	obj, err := resolveRevision(c, opt, arg)
+	if err != nil {
+		return nil, err
 	}
+	commit, err := peelRevision(c, obj, "commit")
+	if err != nil {
+		return nil, err
+	}
+	return CommitID(commit), nil
+}
+
+// Resolves a ref name or (possibly abbreviated) object name into a
+// Commitish.
+func revParseRef(c *Client, opt *RevParseOptions, cmtbase string) (Commitish, error) {
+	arg := cmtbase
 	if len(cmtbase) == 40 {
 		sha1, err := Sha1FromString(cmtbase)
 		return CommitID(sha1), err
@@ -265,11 +209,11 @@ func RevParseCommitish(c *Client, opt *RevParseOptions, arg string) (cmt Commiti
 		}
 	}
 	if strings.HasPrefix(cmtbase, "refs/") {
-		if rs := c.GitDir.File(File(cmtbase)); rs.Exists() {
+		if refExists(c, cmtbase) {
 			return RefSpec(cmtbase), nil
 		}
 	}
-	if rs := c.GitDir.File("refs/tags/" + File(cmtbase)); rs.Exists() {
+	if refExists(c, "refs/tags/"+cmtbase) {
 		return RefSpec("refs/tags/" + cmtbase), nil
 	}
 
@@ -278,78 +222,19 @@ func RevParseCommitish(c *Client, opt *RevParseOptions, arg string) (cmt Commiti
 	if b, err := GetBranch(c, cmtbase); err == nil {
 		return b, nil
 	}
+	if ref, err := dwimRefname(c, cmtbase); err == nil {
+		return RefSpec(ref), nil
+	}
 
 	// Try seeing if it's an abbreviation of a commit as a last
 	// resort. We require a length of at least 3, so that we only
 	// need to search one directory of the objects directory.
 	if len(cmtbase) > 2 && len(cmtbase) < 40 {
-		dir := cmtbase[:2]
-		var candidates []CommitID
-
-		fulldir := filepath.Join(c.GitDir.String(), "objects", dir)
-		files, err := ioutil.ReadDir(fulldir)
-		if err == nil {
-			for _, f := range files {
-				cand := dir + f.Name()
-				if strings.HasPrefix(cand, cmtbase) {
-					cid, err := Sha1FromString(cand)
-					if err != nil {
-						continue
-					}
-					candidates = append(candidates, CommitID(cid))
-				}
-			}
-		}
-
-		// We need to check the pack file indexes even
-		// if we already found something in order to
-		// ensure that it's not an ambiguous reference.
-		packdir := filepath.Join(c.GitDir.String(), "objects", "pack")
-		packs, err := ioutil.ReadDir(packdir)
-		if err != nil {
-			// There was an error getting the packfiles,
-			// so assume there aren't any.
-			goto donecommit
-		}
-
-		for _, fi := range packs {
-			if filepath.Ext(fi.Name()) != ".idx" {
-				continue
-			}
-			packfile := filepath.Join(
-				c.GitDir.String(),
-				"objects",
-				"pack",
-				filepath.Base(fi.Name()),
-			)
-			f, err := os.Open(packfile)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-			defer f.Close()
-
-			objects := v2PackObjectListFromIndex(f)
-			for _, obj := range objects {
-				cand := obj.String()
-				if strings.HasPrefix(cand, cmtbase) {
-					candidates = append(candidates, CommitID(obj))
-				}
-			}
-		}
-
-	donecommit:
-		if len(candidates) == 1 {
-			return candidates[0], nil
-		} else if len(candidates) > 1 {
-			// Remove duplicates before declaring it ambiguous
-			m := make(map[CommitID]struct{})
-			for _, c := range candidates {
-				m[c] = struct{}{}
-			}
-			if len(m) == 1 {
-				return candidates[0], nil
-			}
+		switch candidates := objectsWithPrefix(c, cmtbase); len(candidates) {
+		case 0:
+		case 1:
+			return CommitID(candidates[0]), nil
+		default:
 			return nil, fmt.Errorf("Ambiguous reference: '%v', %v", arg, candidates)
 		}
 	}
@@ -371,12 +256,28 @@ func RevParse(c *Client, opt RevParseOptions, args []string) (commits []ParsedRe
 	if opt.Default != "" && len(args) == 0 {
 		args = []string{opt.Default}
 	}
-	if opt.Verify {
-		if len(args) != 1 {
-			return nil, fmt.Errorf("fatal: need a single revision")
-		}
+	// Output for arguments which aren't revisions is returned in place,
+	// so that everything is printed in the order of the arguments.
+	output := func(s string) {
+		commits = append(commits, ParsedRevision{Name: s, NotRevision: true})
 	}
+	// As with git, once an argument is a file, the rest of them are
+	// files too.
+	asIs := false
 	for _, arg := range args {
+		if asIs {
+			if opt.RevsOnly {
+				continue
+			}
+			output(arg)
+			if len(arg) > 0 && arg[0] == '-' {
+				return commits, fmt.Errorf("fatal: option '%v' must come before non-option arguments", arg)
+			}
+			if !File(arg).Exists() {
+				return commits, fmt.Errorf("fatal: %v: no such path in the working tree.\nUse 'git <command> -- <path>...' to specify paths that do not exist locally.", arg)
+			}
+			continue
+		}
 		switch arg {
 		case "--git-dir":
 			wd, err := os.Getwd()
@@ -386,45 +287,99 @@ func RevParse(c *Client, opt RevParseOptions, args []string) (commits []ParsedRe
 					// absolute path and when it uses the relative path,
 					// but in this case the rev-parse test suite depends
 					// on "."
-					fmt.Println(".")
+					output(".")
 				} else {
-					fmt.Println(strings.TrimPrefix(c.GitDir.String(), wd+"/"))
+					output(strings.TrimPrefix(c.GitDir.String(), wd+"/"))
 				}
 			} else {
-				fmt.Println(c.GitDir)
+				output(c.GitDir.String())
 			}
 		case "--is-inside-git-dir":
 			if c.IsInsideGitDir(".") {
-				fmt.Printf("true\n")
+				output("true")
 			} else {
-				fmt.Printf("false\n")
+				output("false")
 			}
 		case "--is-inside-work-tree":
 			if c.IsInsideWorkTree(".") {
-				fmt.Printf("true\n")
+				output("true")
 			} else {
-				fmt.Printf("false\n")
+				output("false")
 			}
 		case "--is-bare-repository":
 			if c.IsBare() {
-				fmt.Printf("true\n")
+				output("true")
 			} else {
-				fmt.Printf("false\n")
+				output("false")
+			}
+		case "--absolute-git-dir":
+			absgd, err := filepath.Abs(c.GitDir.String())
+			if err != nil {
+				return nil, err
+			}
+			output(absgd)
+		case "--git-common-dir":
+			commondir, err := filepath.Abs(c.GitDir.String())
+			if err != nil {
+				return nil, err
+			}
+			if dir, err := c.GitDir.ReadFile("commondir"); err == nil {
+				if d := strings.TrimSpace(string(dir)); filepath.IsAbs(d) {
+					commondir = d
+				} else {
+					commondir = filepath.Join(commondir, d)
+				}
+			}
+			// Unlike --git-dir, this is relative to the current
+			// directory even when it's not a parent of it.
+			if wd, err := os.Getwd(); err == nil {
+				if rel, err := filepath.Rel(wd, commondir); err == nil {
+					commondir = rel
+				}
+			}
+			output(commondir)
+		case "--local-env-vars":
+			for _, v := range localEnvVars {
+				output(v)
 			}
 		case "--show-toplevel":
+			if c.IsBare() || !c.IsInsideWorkTree(".") {
+				return nil, fmt.Errorf("fatal: this operation must be run in a work tree")
+			}
 			absgd, err := filepath.Abs(c.WorkDir.String())
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				continue
 			}
-			fmt.Println(absgd)
+			output(absgd)
+		case "--show-cdup":
+			if c.IsBare() || !c.IsInsideWorkTree(".") {
+				continue
+			}
+			absgd, err := filepath.Abs(c.WorkDir.String())
+			if err != nil {
+				return nil, err
+			}
+			pwd, err := os.Getwd()
+			if err != nil {
+				return nil, err
+			}
+			cdup, err := filepath.Rel(pwd, absgd)
+			if err != nil {
+				return nil, err
+			}
+			if cdup == "." {
+				output("")
+			} else {
+				output(filepath.ToSlash(cdup) + "/")
+			}
 		case "--show-prefix":
 			// I don't know why, but the git test suite tests that
 			// prefix prints "" when GIT_DIR is set, even when it's
 			// set to the same .git directory that would be evaluated
 			// without it.
 			if c.IsBare() || c.IsInsideGitDir(".") || os.Getenv("GIT_DIR") != "" {
-				fmt.Println("")
+				output("")
 				continue
 			}
 			absgd, err := filepath.Abs(c.WorkDir.String())
@@ -438,76 +393,365 @@ func RevParse(c *Client, opt RevParseOptions, args []string) (commits []ParsedRe
 				continue
 			}
 			if pwd == absgd {
-				fmt.Println("")
+				output("")
 			} else {
-				fmt.Println(strings.TrimPrefix(pwd, absgd+"/") + "/")
+				output(strings.TrimPrefix(pwd, absgd+"/") + "/")
 			}
 		default:
 			if len(arg) > 0 && arg[0] == '-' {
-				fmt.Printf("%s\n", arg)
-			} else {
-				var sha string
-				var exclude bool
-				if arg[0] == '^' {
-					sha = arg[1:]
-					exclude = true
-				} else {
-					sha = arg
-					exclude = false
+				// Unknown flags are passed through, unless only
+				// revisions are being output.
+				if !opt.Verify && !opt.RevsOnly {
+					output(arg)
 				}
-				if strings.Contains(arg, ":") {
-					sha, err := RevParsePath(c, &opt, arg)
-					if err != nil {
-						err2 = err
-					} else {
-						commits = append(commits, ParsedRevision{sha, exclude})
-					}
-				} else if strings.HasSuffix(arg, "^{tree}") {
-					tree, err := RevParseTreeish(c, &opt, strings.TrimSuffix(sha, "^{tree}"))
-					if err != nil {
-						err2 = err
-					} else {
-						treeid, err := tree.TreeID(c)
-						if err != nil {
-							err2 = err
-						}
-						commits = append(commits, ParsedRevision{Sha1(treeid), exclude})
-					}
-				} else {
-					obj, err := RevParseCommitish(c, &opt, sha)
-					if err != nil {
-						err2 = err
-						continue
-					}
-					switch r := obj.(type) {
-					case RefSpec:
-						// If it's an annotated tag,
-						// don't dereference to a commit
-						obj, err := r.Sha1(c)
-						if err != nil {
-							err2 = err
-						} else {
-							commits = append(commits, ParsedRevision{obj, exclude})
-						}
-					default:
-						cmt, err := obj.CommitID(c)
-						if err != nil {
-							err2 = err
-						} else {
-							commits = append(commits, ParsedRevision{Sha1(cmt), exclude})
-						}
+				continue
+			}
+			revs, err := revParseRevision(c, &opt, arg)
+			if err != nil {
+				if opt.Verify {
+					err2 = err
+					continue
+				}
+				asIs = true
+				if opt.RevsOnly {
+					// Only revisions are output, so anything
+					// else is ignored.
+					continue
+				}
+				if strings.Contains(arg, "@{") {
+					// The reflog or branch lookup failed, and
+					// the reason is more useful than the
+					// generic error.
+					return commits, fmt.Errorf("fatal: %v", err)
+				}
+				// Anything else that isn't a revision is a
+				// file, which must exist. It's output either
+				// way, along with everything before it.
+				output(arg)
+				if !File(arg).Exists() {
+					return commits, fmt.Errorf("fatal: ambiguous argument '%v': unknown revision or path not in the working tree.\nUse '--' to separate paths from revisions, like this:\n'git <command> [<revision>...] -- [<file>...]'", arg)
+				}
+				continue
+			}
+			commits = append(commits, nameRevisions(c, &opt, revs)...)
+		}
+	}
+	if opt.Verify {
+		// Only the revisions count, not the output of other
+		// arguments such as --git-dir.
+		nrevs := 0
+		for _, rev := range commits {
+			if !rev.NotRevision {
+				nrevs++
+			}
+		}
+		if err2 != nil || nrevs != 1 {
+			return nil, fmt.Errorf("fatal: Needed a single revision")
+		}
+	}
+	return
+}
+
+// The environment variables which are local to a repository, for
+// --local-env-vars.
+var localEnvVars = []string{
+	"GIT_ALTERNATE_OBJECT_DIRECTORIES",
+	"GIT_CONFIG",
+	"GIT_CONFIG_PARAMETERS",
+	"GIT_CONFIG_COUNT",
+	"GIT_OBJECT_DIRECTORY",
+	"GIT_DIR",
+	"GIT_WORK_TREE",
+	"GIT_IMPLICIT_WORK_TREE",
+	"GIT_GRAFT_FILE",
+	"GIT_INDEX_FILE",
+	"GIT_NO_REPLACE_OBJECTS",
+	"GIT_REPLACE_REF_BASE",
+	"GIT_PREFIX",
+	"GIT_INTERNAL_SUPER_PREFIX",
+	"GIT_SHALLOW_FILE",
+	"GIT_COMMON_DIR",
+}
+
+// Converts the Name of revisions into the name that should be printed for
+// the Short, SymbolicFullName and AbbrefRev options. Revisions which have a
+// name that isn't a ref are omitted for SymbolicFullName and AbbrefRev,
+// while revisions without any name are printed as object names.
+func nameRevisions(c *Client, opt *RevParseOptions, revs []ParsedRevision) []ParsedRevision {
+	if opt.Short > 0 {
+		for i := range revs {
+			revs[i].Name = abbreviateSha1(c, revs[i].Id, int(opt.Short))
+		}
+		return revs
+	}
+	if opt.Symbolic || (!opt.SymbolicFullName && opt.AbbrefRev == "") {
+		return revs
+	}
+	var named []ParsedRevision
+	for _, rev := range revs {
+		if rev.Name == "" {
+			named = append(named, rev)
+			continue
+		}
+		full, ok := symbolicFullName(c, opt, rev.Name)
+		if !ok {
+			continue
+		}
+		if opt.AbbrefRev != "" {
+			rev.Name = shortenRefname(c, full, opt.AbbrefRev != "loose")
+		} else {
+			rev.Name = string(full)
+		}
+		named = append(named, rev)
+	}
+	return named
+}
+
+// Returns the full name of the ref that the revision name refers to, or
+// false if it isn't a ref.
+func symbolicFullName(c *Client, opt *RevParseOptions, name string) (Refname, bool) {
+	if name == "@" {
+		name = "HEAD"
+	}
+	if name == "HEAD" {
+		if head, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD"); err == nil {
+			return Refname(head), true
+		}
+		return "HEAD", true
+	}
+	if base, mods := splitRevisionModifiers(name); mods != "" || base != name || revisionPathSeparator(name) >= 0 {
+		return "", false
+	}
+	cmtish, err := revParseBase(c, opt, name)
+	if err != nil {
+		return "", false
+	}
+	switch r := cmtish.(type) {
+	case RefSpec:
+		return Refname(r.String()), true
+	case Branch:
+		return Refname(r.String()), true
+	}
+	return "", false
+}
+
+// Returns the shortest name which unambiguously refers to the ref full.
+// If strict is set, the name must not match any other existing ref using
+// any of the rules for expanding names, otherwise it only needs to not be
+// shadowed by rules that take precedence.
+func shortenRefname(c *Client, full Refname, strict bool) string {
+	for i := len(refnameRules) - 1; i > 0; i-- {
+		prefix, suffix := refnameRules[i][:strings.Index(refnameRules[i], "%s")], refnameRules[i][strings.Index(refnameRules[i], "%s")+2:]
+		if !strings.HasPrefix(string(full), prefix) || !strings.HasSuffix(string(full), suffix) || len(full) <= len(prefix)+len(suffix) {
+			continue
+		}
+		short := string(full)[len(prefix) : len(full)-len(suffix)]
+		ambiguous := false
+		for j, rule := range refnameRules {
+			if j == i || (!strict && j > i) {
+				continue
+			}
+			if refExists(c, fmt.Sprintf(rule, short)) {
+				ambiguous = true
+				break
+			}
+		}
+		if !ambiguous {
+			return short
+		}
+	}
+	return string(full)
+}
+
+var revParseParentShorthand = regexp.MustCompile(`\^-([0-9]*)$`)
+
+// Parses a single revision argument into the revisions that it's made of.
+// The argument may be a single revision, an excluded revision such as
+// "^A", or a range such as "A..B", "A...B", "A^@", "A^!" or "A^-<n>". A
+// missing end of a range defaults to HEAD.
+func revParseRevision(c *Client, opt *RevParseOptions, arg string) ([]ParsedRevision, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("Could not find %v", arg)
+	}
+	// The name of each revision is kept for the options that print
+	// revisions symbolically, and resolved by nameRevisions.
+	name := func(n string) string {
+		if opt.Symbolic || opt.SymbolicFullName || opt.AbbrefRev != "" {
+			return n
+		}
+		return ""
+	}
+	// Paths and message searches may contain things that look like
+	// ranges.
+	if arg[0] == ':' || revisionPathSeparator(arg) >= 0 {
+		obj, err := resolveRevision(c, opt, arg)
+		if err != nil {
+			return nil, err
+		}
+		return []ParsedRevision{{Id: obj, Name: name(arg)}}, nil
+	}
+	if arg[0] == '^' && len(arg) > 1 {
+		obj, err := resolveRevision(c, opt, arg[1:])
+		if err != nil {
+			return nil, err
+		}
+		return []ParsedRevision{{Id: obj, Excluded: true, Name: name(arg[1:])}}, nil
+	}
+	rangeEnd := func(end *string) (CommitID, error) {
+		if *end == "" {
+			*end = "HEAD"
+		}
+		obj, err := resolveRevision(c, opt, *end)
+		if err != nil {
+			return CommitID{}, err
+		}
+		cmt, err := peelRevision(c, obj, "commit")
+		return CommitID(cmt), err
+	}
+	if dots := strings.Index(arg, "..."); dots >= 0 {
+		leftname, rightname := arg[:dots], arg[dots+3:]
+		left, err := rangeEnd(&leftname)
+		if err != nil {
+			return nil, err
+		}
+		right, err := rangeEnd(&rightname)
+		if err != nil {
+			return nil, err
+		}
+		revs := []ParsedRevision{{Id: Sha1(right), Name: name(rightname)}, {Id: Sha1(left), Name: name(leftname), Left: true}}
+		base, err := MergeBase(c, MergeBaseOptions{}, []Commitish{left, right})
+		if err != nil {
+			return nil, err
+		}
+		if base != (CommitID{}) {
+			revs = append(revs, ParsedRevision{Id: Sha1(base), Excluded: true})
+		}
+		return revs, nil
+	}
+	if dots := strings.Index(arg, ".."); dots >= 0 {
+		leftname, rightname := arg[:dots], arg[dots+2:]
+		left, err := rangeEnd(&leftname)
+		if err != nil {
+			return nil, err
+		}
+		right, err := rangeEnd(&rightname)
+		if err != nil {
+			return nil, err
+		}
+		return []ParsedRevision{{Id: Sha1(right), Name: name(rightname)}, {Id: Sha1(left), Excluded: true, Name: name(leftname)}}, nil
+	}
+
+	var parentRange string
+	switch {
+	case strings.HasSuffix(arg, "^@"), strings.HasSuffix(arg, "^!"):
+		parentRange, arg = arg[len(arg)-1:], arg[:len(arg)-2]
+	case revParseParentShorthand.MatchString(arg):
+		match := revParseParentShorthand.FindStringSubmatch(arg)
+		parentRange, arg = "-", arg[:len(arg)-len(match[0])]
+		if match[1] != "" {
+			parentRange += match[1]
+		}
+	}
+	obj, err := resolveRevision(c, opt, arg)
+	if err != nil {
+		return nil, err
+	}
+	if parentRange == "" {
+		return []ParsedRevision{{Id: obj, Name: name(arg)}}, nil
+	}
+	cmt, err := peelRevision(c, obj, "commit")
+	if err != nil {
+		return nil, err
+	}
+	if parentRange[0] == '-' {
+		parent, err := applyRevisionModifiers(c, cmt, "^"+parentRange[1:])
+		if err != nil {
+			return nil, err
+		}
+		n := parentRange[1:]
+		if n == "" {
+			n = "1"
+		}
+		return []ParsedRevision{{Id: cmt, Name: name(arg)}, {Id: parent, Excluded: true, Name: name(arg + "^" + n)}}, nil
+	}
+	parents, err := CommitID(cmt).Parents(c)
+	if err != nil {
+		return nil, err
+	}
+	var revs []ParsedRevision
+	if parentRange == "!" {
+		revs = append(revs, ParsedRevision{Id: cmt, Name: name(arg)})
+	}
+	for i, p := range parents {
+		revs = append(revs, ParsedRevision{Id: Sha1(p), Excluded: parentRange == "!", Name: name(fmt.Sprintf("%s^%d", arg, i+1))})
+	}
+	return revs, nil
+}
+
+// Returns the objects whose names start with prefix, which must be at
+// least 2 characters long.
+func objectsWithPrefix(c *Client, prefix string) []Sha1 {
+	var candidates []Sha1
+	seen := make(map[Sha1]struct{})
+	add := func(id Sha1) {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			candidates = append(candidates, id)
+		}
+	}
+	dir := prefix[:2]
+	for _, objdir := range append([]string{c.ObjectDir}, c.alternates...) {
+		files, err := ioutil.ReadDir(filepath.Join(objdir, dir))
+		if err == nil {
+			for _, f := range files {
+				cand := dir + f.Name()
+				if strings.HasPrefix(cand, prefix) {
+					if id, err := Sha1FromString(cand); err == nil {
+						add(id)
 					}
+				}
+			}
+		}
 
+		// We need to check the pack file indexes even
+		// if we already found something in order to
+		// ensure that it's not an ambiguous reference.
+		packs, err := ioutil.ReadDir(filepath.Join(objdir, "pack"))
+		if err != nil {
+			// There was an error getting the packfiles,
+			// so assume there aren't any.
+			continue
+		}
+		for _, fi := range packs {
+			if filepath.Ext(fi.Name()) != ".idx" {
+				continue
+			}
+			f, err := os.Open(filepath.Join(objdir, "pack", fi.Name()))
+			if err != nil {
+				continue
+			}
+			for _, obj := range v2PackObjectListFromIndex(f) {
+				if strings.HasPrefix(obj.String(), prefix) {
+					add(obj)
 				}
 			}
+			f.Close()
 		}
 	}
-	if opt.Verify && err2 != nil {
-		if strings.HasPrefix(err2.Error(), "Could not find") {
-			return nil, fmt.Errorf("fatal: need a single revision")
+	return candidates
+}
 
+// Returns the shortest unique abbreviation of id which is at least n
+// characters long.
+func abbreviateSha1(c *Client, id Sha1, n int) string {
+	s := id.String()
+	if n < 4 {
+		n = 4
+	}
+	for ; n < len(s); n++ {
+		if len(objectsWithPrefix(c, s[:n])) <= 1 {
+			return s[:n]
 		}
-		return nil, err2
 	}
-	return
+	return s
 }
@@ -0,0 +1,118 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRevParseOptionOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrevparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := Commit(c, CommitOptions{}, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The output of options is in the order of the arguments, and
+	// doesn't count as a revision for --verify.
+	revs, err := RevParse(c, RevParseOptions{Verify: true}, []string{"--is-bare-repository", "HEAD", "--is-inside-work-tree"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ParsedRevision{
+		{Name: "false", NotRevision: true},
+		{Id: Sha1(cmt), Name: "HEAD"},
+		{Name: "true", NotRevision: true},
+	}
+	if len(revs) != len(want) {
+		t.Fatalf("Unexpected revisions: got %v want %v", revs, want)
+	}
+	for i := range want {
+		if revs[i].Id != want[i].Id || revs[i].NotRevision != want[i].NotRevision || (want[i].NotRevision && revs[i].Name != want[i].Name) {
+			t.Errorf("Unexpected revision %d: got %+v want %+v", i, revs[i], want[i])
+		}
+	}
+
+	if _, err := RevParse(c, RevParseOptions{Verify: true}, []string{"HEAD", "HEAD"}); err == nil {
+		t.Errorf("Expected an error verifying multiple revisions")
+	}
+	if _, err := RevParse(c, RevParseOptions{Verify: true}, []string{"--is-bare-repository"}); err == nil {
+		t.Errorf("Expected an error verifying without a revision")
+	}
+}
+
+func TestRevParseFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrevparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := Commit(c, CommitOptions{}, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Args    []string
+		Want    []string
+		WantErr bool
+	}{
+		// Everything up to and including an unknown argument is
+		// output before the error.
+		{[]string{"HEAD", "bogus", "HEAD"}, []string{cmt.String(), "bogus"}, true},
+		// Once there's a file, the rest of the arguments are files.
+		{[]string{"HEAD", "foo.txt", "foo.txt"}, []string{cmt.String(), "foo.txt", "foo.txt"}, false},
+		{[]string{"HEAD", "foo.txt", "HEAD", "foo.txt"}, []string{cmt.String(), "foo.txt", "HEAD"}, true},
+		{[]string{"foo.txt", "--git-dir"}, []string{"foo.txt", "--git-dir"}, true},
+	}
+	for i, tc := range tests {
+		revs, err := RevParse(c, RevParseOptions{}, tc.Args)
+		if (err != nil) != tc.WantErr {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+		}
+		var got []string
+		for _, rev := range revs {
+			if rev.NotRevision {
+				got = append(got, rev.Name)
+			} else {
+				got = append(got, rev.Id.String())
+			}
+		}
+		if !reflect.DeepEqual(got, tc.Want) {
+			t.Errorf("Test %d: got %q want %q", i, got, tc.Want)
+		}
+	}
+}
@@ -22,7 +22,11 @@ type SendPackOptions struct {
 }
 
 func SendPack(c *Client, opts SendPackOptions, r Remote, refs []Refname) error {
-	remoteConn, err := NewRemoteConn(c, r)
+	urls, err := r.PushURL(c)
+	if err != nil {
+		return err
+	}
+	remoteConn, err := newRemoteConnURL(c, urls)
 	if err != nil {
 		return err
 	}
@@ -498,117 +498,28 @@ func (c CommitID) GetAllObjectsExcept(cl *Client, excludeList map[Sha1]struct{})
 
 }
 
-func (c CommitID) getRefNamesList(cl *Client) (string, error) {
-	refs, err := ShowRef(cl, ShowRefOptions{}, []string{})
-	if err != nil {
-		return "", err
-	}
-
-	headRefSpec, err := SymbolicRefGet(cl, SymbolicRefOptions{}, "HEAD")
-	if err != nil {
-		return "", err
-	}
-
-	refsForCommit := ""
-	for _, ref := range refs {
-		if len(refsForCommit) != 0 {
-			refsForCommit = refsForCommit + ", "
-		}
-
-		if ref.Value == Sha1(c) {
-
-			// TODO relate any other top-level refs to their linked ref, not just HEAD
-			if headRefSpec.String() == ref.Name {
-				refsForCommit = refsForCommit + "HEAD -> "
-			}
-			refsForCommit = refsForCommit + ref.RefName()
-		}
-	}
-
-	return refsForCommit, nil
-}
-
+// FormatMedium returns the commit in git's medium format, decorated with
+// the refs that point to it and followed by a blank line.
 func (c CommitID) FormatMedium(cl *Client) (string, error) {
-	output := ""
-
-	author, err := c.GetAuthor(cl)
+	p, err := NewPrettyPrinter(cl, PrettyOptions{Decorate: "short"})
 	if err != nil {
 		return "", err
 	}
-	output = output + fmt.Sprintf("commit %s", c)
-	refNameList, _ := c.getRefNamesList(cl)
-	if refNameList != "" {
-		output = output + fmt.Sprintf(" (%s)", refNameList)
-	}
-	output = output + "\n"
-	if parents, err := c.Parents(cl); len(parents) > 1 && err == nil {
-		output = output + "Merge: "
-		for i, p := range parents {
-			output = output + fmt.Sprintf("%s", p)
-			if i != len(parents)-1 {
-				output = output + " "
-			}
-		}
-		output = output + "\n"
-	}
-	date, err := c.GetDate(cl)
+	s, err := p.FormatCommit(c, "")
 	if err != nil {
 		return "", err
 	}
-	output = output + fmt.Sprintf("Author: %v\nDate:   %v\n\n", author, date.Format("Mon Jan 2 15:04:05 2006 -0700"))
-
-	msg, err := c.GetCommitMessage(cl)
-	if err != nil {
-		return "", err
-	}
-	lines := strings.Split(strings.TrimSpace(msg.String()), "\n")
-	for _, l := range lines {
-		output = output + fmt.Sprintf("    %v\n", l)
-	}
-	output = output + "\n"
-	return output, nil
+	return s + "\n", nil
 }
 
+// Format returns the commit formatted according to the placeholders in
+// format, as with git log --format=format:<format>.
 func (c CommitID) Format(cl *Client, format string) (string, error) {
-	output := format
-
-	// Newline
-	if strings.Contains(output, "%n") {
-		output = strings.Replace(output, "%n", "\n", -1)
-	}
-
-	// Full commit hash
-	if strings.Contains(output, "%H") {
-		output = strings.Replace(output, "%H", c.String(), -1)
-	}
-
-	// Committer date as unix timestamp
-	if strings.Contains(output, "%ct") {
-		date, err := c.GetCommitterDate(cl)
-		if err != nil {
-			return "", err
-		}
-		output = strings.Replace(output, "%ct", strconv.FormatInt(date.Unix(), 10), -1)
-	}
-
-	// Author date as unix timestamp
-	if strings.Contains(output, "%at") {
-		date, err := c.GetDate(cl)
-		if err != nil {
-			return "", err
-		}
-		output = strings.Replace(output, "%at", strconv.FormatInt(date.Unix(), 10), -1)
-	}
-
-	// Show the non-stylized ref names beside any relevant commit
-	if strings.Contains(output, "%D") {
-		refNameList, _ := c.getRefNamesList(cl)
-		output = strings.Replace(output, "%D", refNameList, -1)
+	p, err := NewPrettyPrinter(cl, PrettyOptions{Format: "format:" + format})
+	if err != nil {
+		return "", err
 	}
-
-	// TODO Add more formatting options (there are many)
-
-	return output, nil
+	return p.FormatCommit(c, "")
 }
 
 // A TreeEntry represents an entry inside of a Treeish.
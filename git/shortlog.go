@@ -0,0 +1,232 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ShortlogOptions control how shortlog groups and summarizes commits.
+type ShortlogOptions struct {
+	// Sort the groups by their number of commits, rather than by name.
+	Numbered bool
+
+	// Only show the number of commits in each group, not their
+	// subjects.
+	Summary bool
+
+	// Show the email address of each person as well as their name.
+	Email bool
+
+	// What commits are grouped by: "author", "committer", or
+	// "trailer:<key>" for the people or values of the trailers with that
+	// key. Commits are grouped by author if Groups is empty. A commit in
+	// more than one group is counted in each of them, but only once in
+	// each.
+	Groups []string
+}
+
+// A Shortlog collects commits into groups and writes a summary of them, as
+// git shortlog does.
+type Shortlog struct {
+	opts    ShortlogOptions
+	mailmap *Mailmap
+
+	// The subjects of the commits in each group, in the order that they
+	// were added.
+	groups map[string][]string
+}
+
+// NewShortlog returns a Shortlog which groups commits as described by
+// opts. The names and emails in commits are mapped by the repository's
+// mailmap.
+func NewShortlog(c *Client, opts ShortlogOptions) (*Shortlog, error) {
+	if len(opts.Groups) == 0 {
+		opts.Groups = []string{"author"}
+	}
+	for _, group := range opts.Groups {
+		switch {
+		case group == "author", group == "committer":
+		case strings.HasPrefix(group, "trailer:") && len(group) > len("trailer:"):
+		default:
+			return nil, fmt.Errorf("fatal: unknown group type: %v", group)
+		}
+	}
+	mailmap, err := ReadMailmap(c)
+	if err != nil {
+		return nil, err
+	}
+	return &Shortlog{opts: opts, mailmap: mailmap, groups: make(map[string][]string)}, nil
+}
+
+// Returns the name of the person in the ident, or their name and email if
+// opts.Email is set, after mapping them with the mailmap. ok is false if
+// ident isn't a valid ident.
+func (s *Shortlog) person(ident string) (name string, ok bool) {
+	person, err := parseIdent(ident)
+	if err != nil {
+		return "", false
+	}
+	// As in git, leading whitespace isn't removed from the name, which
+	// matters for the aligned "Author:" lines of some log formats.
+	person.Name = ident[:len(ident)-len(strings.TrimLeft(ident, " \t"))] + person.Name
+	person = s.mailmap.MapPerson(person)
+	if s.opts.Email {
+		return fmt.Sprintf("%s <%s>", person.Name, person.Email), true
+	}
+	return person.Name, true
+}
+
+// Adds the subject to the group named ident.
+func (s *Shortlog) add(ident, subject string) {
+	if s.opts.Summary {
+		s.groups[ident] = append(s.groups[ident], "")
+		return
+	}
+	subject = strings.TrimLeft(subject, " \t\n\r\v\f")
+	eol := strings.IndexByte(subject, '\n')
+	if eol < 0 {
+		eol = len(subject)
+	}
+	// A "[PATCH ...]" prefix from a mailed patch isn't shown.
+	if strings.HasPrefix(subject, "[PATCH") {
+		if eob := strings.IndexByte(subject, ']'); eob >= 0 && eob < eol {
+			subject = subject[eob+1:]
+		}
+	}
+	subject = strings.TrimLeft(subject, " \t\r\v\f")
+	subject, _ = formatSubject(subject, " ")
+	s.groups[ident] = append(s.groups[ident], subject)
+}
+
+// AddCommit adds the commit id to each of the groups that it's in.
+func (s *Shortlog) AddCommit(c *Client, id CommitID) error {
+	cm, err := parsePrettyCommit(c, id)
+	if err != nil {
+		return err
+	}
+	msg := skipBlankLines(cm.Message)
+	subject, _ := formatSubject(msg, " ")
+	if subject == "" {
+		subject = "<none>"
+	}
+
+	seen := make(map[string]bool)
+	add := func(ident string) {
+		if seen[ident] {
+			return
+		}
+		seen[ident] = true
+		s.add(ident, subject)
+	}
+	for _, group := range s.opts.Groups {
+		switch group {
+		case "author", "committer":
+			ident := cm.Author
+			if group == "committer" {
+				ident = cm.Committer
+			}
+			if name, ok := s.person(ident); ok {
+				add(name)
+			}
+			continue
+		}
+		key := strings.TrimPrefix(group, "trailer:")
+		values := formatTrailers(msg, trailerOptions{Keys: []string{key}, Only: true, Unfold: true, ValueOnly: true})
+		for _, value := range strings.Split(values, "\n") {
+			if value == "" {
+				continue
+			}
+			// Trailers naming people are mapped like authors.
+			if name, ok := s.person(value); ok {
+				value = name
+			}
+			add(value)
+		}
+	}
+	return nil
+}
+
+// ReadLog adds the commits in the output of git log read from r, which is
+// grouped by the "Author:" or "Commit:" lines of each commit, or the
+// "author" or "committer" headers of raw output. Only a single author or
+// committer group is supported.
+func (s *Shortlog) ReadLog(r io.Reader) error {
+	if len(s.opts.Groups) > 1 {
+		return fmt.Errorf("fatal: using multiple --group options with stdin is not supported")
+	}
+	prefixes := []string{"Author: ", "author "}
+	switch s.opts.Groups[0] {
+	case "author":
+	case "committer":
+		prefixes = []string{"Commit: ", "committer "}
+	default:
+		return fmt.Errorf("fatal: using --group=trailer with stdin is not supported")
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		var ident string
+		if strings.HasPrefix(line, prefixes[0]) {
+			ident = line[len(prefixes[0]):]
+		} else if strings.HasPrefix(line, prefixes[1]) {
+			ident = line[len(prefixes[1]):]
+		} else {
+			continue
+		}
+		// The subject is the first line after the headers and
+		// the blank lines following them.
+		for scanner.Scan() && scanner.Text() != "" {
+		}
+		subject := ""
+		for scanner.Scan() {
+			if subject = scanner.Text(); subject != "" {
+				break
+			}
+		}
+		if name, ok := s.person(ident); ok {
+			s.add(name, subject)
+		}
+	}
+	return scanner.Err()
+}
+
+// Write writes the groups to w, sorted by name or by their number of
+// commits. Each group is followed by the subjects of its commits, oldest
+// first, unless opts.Summary is set.
+func (s *Shortlog) Write(w io.Writer) error {
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if s.opts.Numbered {
+		sort.SliceStable(names, func(i, j int) bool {
+			return len(s.groups[names[i]]) > len(s.groups[names[j]])
+		})
+	}
+	for _, name := range names {
+		subjects := s.groups[name]
+		if s.opts.Summary {
+			if _, err := fmt.Fprintf(w, "%6d\t%s\n", len(subjects), name); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s (%d):\n", name, len(subjects)); err != nil {
+			return err
+		}
+		for i := len(subjects) - 1; i >= 0; i-- {
+			if _, err := fmt.Fprintf(w, "      %s\n", subjects[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestShortlogReadLog(t *testing.T) {
+	log := `commit 0123456789012345678901234567890123456789
+Author: B Person <b@example.com>
+Date:   Sun Sep 13 12:26:40 2020 +0000
+
+    [PATCH 2/2] second
+
+commit 1234567890123456789012345678901234567890
+Author: A Person <a@example.com>
+Date:   Sun Sep 13 12:26:40 2020 +0000
+
+    first
+
+commit 2345678901234567890123456789012345678901
+Author: B Person <b@example.com>
+Date:   Sun Sep 13 12:26:40 2020 +0000
+
+    zeroth
+`
+	tests := []struct {
+		Opts ShortlogOptions
+		Want string
+	}{
+		{ShortlogOptions{}, "A Person (1):\n      first\n\nB Person (2):\n      zeroth\n      second\n\n"},
+		{ShortlogOptions{Summary: true, Numbered: true}, "     2\tB Person\n     1\tA Person\n"},
+		{ShortlogOptions{Summary: true, Email: true}, "     1\tA Person <a@example.com>\n     2\tB Person <b@example.com>\n"},
+	}
+	for i, tc := range tests {
+		tc.Opts.Groups = []string{"author"}
+		s := &Shortlog{opts: tc.Opts, groups: make(map[string][]string)}
+		if err := s.ReadLog(strings.NewReader(log)); err != nil {
+			t.Errorf("Case %d: unexpected error: %v", i, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := s.Write(&buf); err != nil {
+			t.Errorf("Case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if got := buf.String(); got != tc.Want {
+			t.Errorf("Case %d: got %q want %q", i, got, tc.Want)
+		}
+	}
+}
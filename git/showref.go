@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -109,7 +110,7 @@ func ShowRef(c *Client, opts ShowRefOptions, patterns []string) ([]Ref, error) {
 	if opts.Verify {
 		// If verify is specified, everything must be an exact match
 		for _, ref := range patterns {
-			if f := c.GitDir.File(File(ref)); !f.Exists() {
+			if !refExists(c, ref) {
 				return nil, fmt.Errorf("fatal: '%v' - not a valid ref", ref)
 			}
 			r, err := parseRef(c, ref)
@@ -138,135 +139,104 @@ func ShowRef(c *Client, opts ShowRefOptions, patterns []string) ([]Ref, error) {
 			vals = append(vals, Ref{"HEAD", Sha1(hcid)})
 		}
 	}
-	// FIXME: Include packed refs
-	if !opts.Heads && !opts.Tags {
-		err := filepath.Walk(c.GitDir.File("refs").String(),
-			func(path string, info os.FileInfo, err error) error {
-				if info.IsDir() {
-					return nil
-				}
-				refname := strings.TrimPrefix(path, c.GitDir.String())
-				ref, err := parseRef(c, refname)
-				if err != nil && err != InvalidCommit {
-					// Invalid commit can just mean we don't
-					// have a local copy of the commit, so
-					// we don't care for the purpose of show-ref
-					return err
-				}
-				if len(patterns) == 0 {
-
-					vals = append(vals, ref)
-					deref, err := getDeref(c, opts, ref)
-					if err != nil {
-						return err
-					}
-					if deref != nil {
-						vals = append(vals, *deref)
-					}
-					return nil
-				}
-				for _, p := range patterns {
-					if ref.Matches(p) {
-						vals = append(vals, ref)
-						deref, err := getDeref(c, opts, ref)
-						if err != nil {
-							return err
-						}
-						if deref != nil {
-							vals = append(vals, *deref)
-						}
-						return nil
-					}
-				}
-				return nil
-			},
-		)
-		if err != nil {
-			return nil, err
-		}
-		return vals, nil
+	refs, err := listRefs(c)
+	if err != nil {
+		return nil, err
 	}
-	if opts.Heads {
-		heads, err := ioutil.ReadDir(c.GitDir.File("refs/heads").String())
-		if err != nil {
-			return nil, err
-		}
-		for _, ref := range heads {
-			refname := "refs/heads/" + ref.Name()
-			ref, err := parseRef(c, refname)
-			if err != nil {
-				return nil, err
-			}
-			if len(patterns) == 0 {
-				vals = append(vals, ref)
-				deref, err := getDeref(c, opts, ref)
-				if err != nil {
-					return nil, err
-				}
-				if deref != nil {
-					vals = append(vals, *deref)
-				}
+	for _, ref := range refs {
+		if opts.Heads || opts.Tags {
+			if !(opts.Heads && strings.HasPrefix(ref.Name, "refs/heads/")) &&
+				!(opts.Tags && strings.HasPrefix(ref.Name, "refs/tags/")) {
 				continue
 			}
+		}
+		if len(patterns) > 0 {
+			matched := false
 			for _, p := range patterns {
 				if ref.Matches(p) {
-					vals = append(vals, ref)
-					deref, err := getDeref(c, opts, ref)
-					if err != nil {
-						return nil, err
-					}
-					if deref != nil {
-						vals = append(vals, *deref)
-					}
+					matched = true
 					break
 				}
 			}
+			if !matched {
+				continue
+			}
 		}
-	}
-	if opts.Tags {
-		tags, err := ioutil.ReadDir(c.GitDir.File("refs/tags").String())
+		vals = append(vals, ref.Ref)
+		if opts.Dereference && ref.Peeled != nil {
+			vals = append(vals, Ref{ref.Name + "^{}", *ref.Peeled})
+			continue
+		}
+		deref, err := getDeref(c, opts, ref.Ref)
 		if err != nil {
 			return nil, err
 		}
-		for _, ref := range tags {
-			refname := "refs/tags/" + ref.Name()
-			ref, err := parseRef(c, refname)
+		if deref != nil {
+			vals = append(vals, *deref)
+		}
+	}
+	return vals, nil
+}
+
+// Returns all the refs under c's GitDir, sorted by name. Loose refs take
+// precedence over refs of the same name in the packed-refs file, and only
+// packed refs have their peeled value set.
+func listRefs(c *Client) ([]packedRef, error) {
+	packed, err := readPackedRefs(c)
+	if err != nil {
+		return nil, err
+	}
+	var refs []packedRef
+	loose := make(map[string]bool)
+	err = filepath.Walk(c.GitDir.File("refs").String(),
+		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
-				return nil, err
-			}
-			if len(patterns) == 0 {
-				vals = append(vals, ref)
-				deref, err := getDeref(c, opts, ref)
-				if err != nil {
-					return nil, err
-				}
-				if deref != nil {
-					vals = append(vals, *deref)
+				if os.IsNotExist(err) {
+					return nil
 				}
-				continue
+				return err
 			}
-			for _, p := range patterns {
-				if ref.Matches(p) {
-					vals = append(vals, ref)
-					deref, err := getDeref(c, opts, ref)
-					if err != nil {
-						return nil, err
-					}
-					if deref != nil {
-						vals = append(vals, *deref)
-					}
-					break
-				}
+			if info.IsDir() {
+				return nil
 			}
+			refname := strings.TrimPrefix(path, c.GitDir.String()+"/")
+			ref, err := parseRef(c, refname)
+			if err != nil && err != InvalidCommit {
+				// Invalid commit can just mean we don't
+				// have a local copy of the commit, so
+				// we don't care for the purpose of listing
+				// refs
+				return err
+			}
+			loose[refname] = true
+			refs = append(refs, packedRef{Ref: ref})
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range packed {
+		if !loose[ref.Name] {
+			refs = append(refs, ref)
 		}
 	}
-
-	return vals, nil
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, nil
 }
 
 func parseRef(c *Client, filename string) (Ref, error) {
 	refname := strings.TrimPrefix(filename, "/")
 	data, err := ioutil.ReadFile(c.GitDir.File(File(refname)).String())
+	if os.IsNotExist(err) {
+		packed, perr := getPackedRef(c, refname)
+		if perr != nil {
+			return Ref{}, perr
+		}
+		if packed != nil {
+			return packed.Ref, nil
+		}
+	}
 	if err != nil {
 		return Ref{}, err
 	}
@@ -298,11 +268,11 @@ func getDeref(c *Client, opts ShowRefOptions, ref Ref) (*Ref, error) {
 		return nil, nil
 	}
 	if ref.Value.Type(c) == "tag" {
-		deref, err := RevParse(c, RevParseOptions{}, []string{ref.Name + "^0"})
+		deref, err := peelTag(c, ref.Value)
 		if err != nil {
 			return nil, err
 		}
-		return &Ref{ref.Name + "^{}", deref[0].Id}, nil
+		return &Ref{ref.Name + "^{}", deref}, nil
 	}
 	return nil, nil
 }
@@ -0,0 +1,147 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// The score given to identical files by similarityScore. Scores are scaled
+// so that they can be compared with git's without rounding differences.
+const maxSimilarityScore = 60000
+
+// The default minimum score for files to be considered renames of each
+// other (50%).
+const defaultRenameScore = maxSimilarityScore / 2
+
+// The modulus used for hashing the chunks of a file.
+const similarityHashBase = 107927
+
+// Splits data into chunks ending in a newline or of at most 64 bytes,
+// and returns the number of bytes of each chunk hash. Carriage returns
+// in CRLF line endings are ignored for text files.
+func similarityHashes(data []byte) map[uint32]int {
+	text := !bytes.Contains(data[:min(len(data), 8000)], []byte{0})
+	hashes := make(map[uint32]int)
+	var accum1, accum2 uint32
+	n := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if text && c == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			continue
+		}
+		old1 := accum1
+		accum1 = (accum1 << 7) ^ (accum2 >> 25)
+		accum2 = (accum2 << 7) ^ (old1 >> 25)
+		accum1 += uint32(c)
+		if n++; n < 64 && c != '\n' {
+			continue
+		}
+		hashes[(accum1+accum2*0x61)%similarityHashBase] += n
+		n = 0
+		accum1, accum2 = 0, 0
+	}
+	if n > 0 {
+		hashes[(accum1+accum2*0x61)%similarityHashBase] += n
+	}
+	return hashes
+}
+
+// Returns how similar the contents of dst are to src, in the same way as
+// git's rename detection, from 0 to maxSimilarityScore. Files whose sizes
+// are too different to possibly reach minScore are given a score of 0
+// without being compared.
+func similarityScore(src, dst []byte, minScore int) int {
+	maxSize, baseSize := len(src), len(dst)
+	if maxSize < baseSize {
+		maxSize, baseSize = baseSize, maxSize
+	}
+	if maxSize == 0 {
+		return 0
+	}
+	if tooDifferentInSize(len(src), len(dst), minScore) {
+		return 0
+	}
+	copied, _ := countChanges(src, dst)
+	return copied * maxSimilarityScore / maxSize
+}
+
+// Returns true if files of srcSize and dstSize bytes can't possibly be
+// minScore similar.
+func tooDifferentInSize(srcSize, dstSize, minScore int) bool {
+	maxSize, baseSize := srcSize, dstSize
+	if maxSize < baseSize {
+		maxSize, baseSize = baseSize, maxSize
+	}
+	return maxSize*(maxSimilarityScore-minScore) < (maxSize-baseSize)*maxSimilarityScore
+}
+
+// Returns the number of bytes of dst which were copied from src, and the
+// number which were added, as git's rename detection counts them.
+func countChanges(src, dst []byte) (copied, added int) {
+	return countHashChanges(similarityHashes(src), similarityHashes(dst))
+}
+
+// Returns the number of bytes which were copied and added between files
+// with the similarity hashes srcHashes and dstHashes.
+func countHashChanges(srcHashes, dstHashes map[uint32]int) (copied, added int) {
+	for hash, dstCount := range dstHashes {
+		srcCount := srcHashes[hash]
+		copied += min(srcCount, dstCount)
+		if dstCount > srcCount {
+			added += dstCount - srcCount
+		}
+	}
+	return copied, added
+}
+
+// Returns score as a percentage, rounded down like git does.
+func similarityPercent(score int) int {
+	return score * 100 / maxSimilarityScore
+}
+
+// ParseSimilarityScore parses the score given to options such as -M and
+// -B in the same way as git, and returns it on the scale used by
+// DiffCommonOptions. A score with a "%" is a percentage, and a score
+// without one is the fractional part of a number, so "5" and "50%" both
+// mean 50% and "05" means 5%. The empty string gives 0.
+func ParseSimilarityScore(s string) (int, error) {
+	num, scale := 0, 1
+	dot := false
+	i := 0
+loop:
+	for ; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '.' && !dot:
+			scale, dot = 1, true
+		case ch == '%':
+			if dot {
+				scale *= 100
+			} else {
+				scale = 100
+			}
+			i++
+			break loop
+		case ch >= '0' && ch <= '9':
+			if scale < 100000 {
+				scale *= 10
+				num = num*10 + int(ch-'0')
+			}
+		default:
+			break loop
+		}
+	}
+	if i < len(s) {
+		return 0, fmt.Errorf("invalid score: %v", s)
+	}
+	if num >= scale {
+		return maxSimilarityScore, nil
+	}
+	return maxSimilarityScore * num / scale, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
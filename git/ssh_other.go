@@ -1,19 +1,31 @@
 // +build !plan9
-// +build !dragonfly
-// +build !openbsd
-// +build !darwin
-// +build !freebsd
-// +build !netbsd
-// +build !solaris
-// +build !linux
 
 package git
 
 import (
+	"io/ioutil"
+	"log"
+
 	"golang.org/x/crypto/ssh"
 )
 
-func getSigners() ([]ssh.Signer, error) {
-	// By default, assume no keys
-	return nil, nil
+// Gets the signers for the private keys in the identity files. Files which
+// don't exist or can't be parsed (ie. because they're encrypted and need
+// to be loaded into an ssh-agent) are skipped.
+func getSigners(identities []string) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, fname := range identities {
+		f, err := ioutil.ReadFile(fname)
+		if err != nil {
+			continue
+		}
+		key, err := ssh.ParsePrivateKey(f)
+		if err != nil {
+			log.Printf("Could not load identity %v: %v\n", fname, err)
+			continue
+		}
+		log.Printf("Using identity %v\n", fname)
+		signers = append(signers, key)
+	}
+	return signers, nil
 }
@@ -9,10 +9,9 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-// from mischief's scpu, get a list of signers
-func getSigners() ([]ssh.Signer, error) {
-	// FIXME: Don't assume Plan 9/factotum is present, look into ~/.ssh
-	// on other platforms.
+// from mischief's scpu, get a list of signers. The identity files are
+// ignored, since keys are held by factotum on Plan 9.
+func getSigners(identities []string) ([]ssh.Signer, error) {
 	k, err := libauth.Listkeys()
 	if err != nil {
 		// if libauth returned an error, it just means factotum isn't
@@ -0,0 +1,171 @@
+package git
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Message numbers from the ssh-agent protocol
+// (draft-miller-ssh-agent)
+const (
+	sshAgentFailure           = 5
+	sshAgentRequestIdentities = 11
+	sshAgentIdentitiesAnswer  = 12
+	sshAgentSignRequest       = 13
+	sshAgentSignResponse      = 14
+)
+
+// An sshAgent is a connection to a running ssh-agent, used to
+// authenticate with keys that we don't have access to directly.
+type sshAgent struct {
+	conn net.Conn
+}
+
+// Connects to the ssh-agent listening on $SSH_AUTH_SOCK. Returns nil if
+// there is no agent.
+func dialSSHAgent() *sshAgent {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		log.Printf("Could not connect to ssh-agent at %v: %v\n", sock, err)
+		return nil
+	}
+	return &sshAgent{conn}
+}
+
+func (a *sshAgent) Close() error {
+	return a.conn.Close()
+}
+
+// Sends a request to the agent and returns the response message.
+func (a *sshAgent) call(req []byte) ([]byte, error) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(req)))
+	if _, err := a.conn.Write(append(length[:], req...)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(a.conn, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > 256*1024 {
+		return nil, fmt.Errorf("ssh-agent response too large")
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(a.conn, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) == 0 {
+		return nil, fmt.Errorf("empty ssh-agent response")
+	}
+	return resp, nil
+}
+
+// Appends an ssh wire format string to buf.
+func appendSSHString(buf, s []byte) []byte {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(s)))
+	return append(append(buf, length[:]...), s...)
+}
+
+// Parses an ssh wire format string from the start of buf, returning
+// the string and the remainder of buf.
+func parseSSHString(buf []byte) ([]byte, []byte, error) {
+	if len(buf) < 4 {
+		return nil, nil, fmt.Errorf("short ssh-agent message")
+	}
+	n := binary.BigEndian.Uint32(buf)
+	buf = buf[4:]
+	if uint32(len(buf)) < n {
+		return nil, nil, fmt.Errorf("short ssh-agent message")
+	}
+	return buf[:n], buf[n:], nil
+}
+
+// Returns a signer for each of the keys the agent holds.
+func (a *sshAgent) Signers() ([]ssh.Signer, error) {
+	resp, err := a.call([]byte{sshAgentRequestIdentities})
+	if err != nil {
+		return nil, err
+	}
+	if resp[0] != sshAgentIdentitiesAnswer {
+		return nil, fmt.Errorf("unexpected ssh-agent response %d", resp[0])
+	}
+	resp = resp[1:]
+	if len(resp) < 4 {
+		return nil, fmt.Errorf("short ssh-agent message")
+	}
+	nkeys := binary.BigEndian.Uint32(resp)
+	resp = resp[4:]
+
+	var signers []ssh.Signer
+	for i := uint32(0); i < nkeys; i++ {
+		var blob, comment []byte
+		if blob, resp, err = parseSSHString(resp); err != nil {
+			return nil, err
+		}
+		if comment, resp, err = parseSSHString(resp); err != nil {
+			return nil, err
+		}
+		key, err := ssh.ParsePublicKey(blob)
+		if err != nil {
+			// Probably a key type that we don't support.
+			log.Printf("Skipping ssh-agent key %s: %v\n", comment, err)
+			continue
+		}
+		signers = append(signers, sshAgentSigner{a, key})
+	}
+	return signers, nil
+}
+
+// An sshAgentSigner signs data using a key held by an ssh-agent.
+type sshAgentSigner struct {
+	agent *sshAgent
+	key   ssh.PublicKey
+}
+
+func (s sshAgentSigner) PublicKey() ssh.PublicKey {
+	return s.key
+}
+
+func (s sshAgentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	req := []byte{sshAgentSignRequest}
+	req = appendSSHString(req, s.key.Marshal())
+	req = appendSSHString(req, data)
+	// No flags
+	req = append(req, 0, 0, 0, 0)
+
+	resp, err := s.agent.call(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp[0] {
+	case sshAgentSignResponse:
+	case sshAgentFailure:
+		return nil, fmt.Errorf("ssh-agent refused to sign")
+	default:
+		return nil, fmt.Errorf("unexpected ssh-agent response %d", resp[0])
+	}
+	sigblob, _, err := parseSSHString(resp[1:])
+	if err != nil {
+		return nil, err
+	}
+	format, rest, err := parseSSHString(sigblob)
+	if err != nil {
+		return nil, err
+	}
+	blob, _, err := parseSSHString(rest)
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.Signature{Format: string(format), Blob: blob}, nil
+}
@@ -0,0 +1,182 @@
+package git
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The subset of the options from an OpenSSH ssh_config(5) file that
+// dgit understands, for a single host.
+type sshHostConfig struct {
+	HostName              string
+	User                  string
+	Port                  string
+	IdentityFiles         []string
+	IdentitiesOnly        bool
+	StrictHostKeyChecking string
+	UserKnownHostsFiles   []string
+}
+
+// Returns the user's home directory.
+func homeDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return home
+	}
+	if home := os.Getenv("home"); home != "" {
+		return home
+	}
+	if u, err := user.Current(); err == nil {
+		return u.HomeDir
+	}
+	return ""
+}
+
+// Loads the ~/.ssh/config options which apply to host. If there is no
+// config file, the zero value is returned.
+func loadSSHConfig(host string) sshHostConfig {
+	f, err := os.Open(filepath.Join(homeDir(), ".ssh", "config"))
+	if err != nil {
+		return sshHostConfig{}
+	}
+	defer f.Close()
+	return parseSSHConfig(f, host)
+}
+
+// Returns true if host matches the list of Host patterns. As with OpenSSH,
+// a host matches if it matches any pattern and doesn't match any negated
+// (!pattern) patterns.
+func sshHostMatches(patterns []string, host string) bool {
+	matched := false
+	host = strings.ToLower(host)
+	for _, p := range patterns {
+		negate := strings.HasPrefix(p, "!")
+		p = strings.ToLower(strings.TrimPrefix(p, "!"))
+		if ok, _ := path.Match(p, host); !ok {
+			continue
+		}
+		if negate {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// Parses an ssh config from r, returning the options that apply to host.
+// As with OpenSSH, the first value found for an option is used, except for
+// IdentityFile which may be specified multiple times.
+func parseSSHConfig(r io.Reader, host string) sshHostConfig {
+	var cfg sshHostConfig
+	seen := make(map[string]bool)
+
+	// Options before the first Host line apply to every host.
+	matching := true
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		// Keywords are separated from their arguments by whitespace
+		// or an optional "="
+		sep := strings.IndexAny(line, " \t=")
+		if sep < 0 {
+			continue
+		}
+		keyword := strings.ToLower(line[:sep])
+		arg := strings.TrimSpace(line[sep:])
+		arg = strings.TrimSpace(strings.TrimPrefix(arg, "="))
+		args := strings.Fields(arg)
+		for i := range args {
+			args[i] = strings.Trim(args[i], `"`)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			matching = sshHostMatches(args, host)
+			continue
+		case "match":
+			// Match blocks aren't supported, other than
+			// "Match all"
+			matching = len(args) == 1 && strings.ToLower(args[0]) == "all"
+			continue
+		}
+		if !matching {
+			continue
+		}
+		if keyword == "identityfile" {
+			cfg.IdentityFiles = append(cfg.IdentityFiles, args[0])
+			continue
+		}
+		if seen[keyword] {
+			continue
+		}
+		seen[keyword] = true
+		switch keyword {
+		case "hostname":
+			cfg.HostName = args[0]
+		case "user":
+			cfg.User = args[0]
+		case "port":
+			cfg.Port = args[0]
+		case "identitiesonly":
+			cfg.IdentitiesOnly = strings.ToLower(args[0]) == "yes"
+		case "stricthostkeychecking":
+			cfg.StrictHostKeyChecking = strings.ToLower(args[0])
+		case "userknownhostsfile":
+			cfg.UserKnownHostsFiles = args
+		}
+	}
+	return cfg
+}
+
+// Expands the ~ and % tokens that ssh_config allows in file names, for a
+// connection to host as remoteuser.
+func expandSSHPath(s, host, remoteuser string) string {
+	home := homeDir()
+	if s == "~" {
+		return home
+	}
+	if strings.HasPrefix(s, "~/") {
+		s = filepath.Join(home, s[2:])
+	}
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var localuser string
+	if u, err := user.Current(); err == nil {
+		localuser = u.Username
+	}
+	var expanded strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i == len(s)-1 {
+			expanded.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'd':
+			expanded.WriteString(home)
+		case 'h':
+			expanded.WriteString(host)
+		case 'r':
+			expanded.WriteString(remoteuser)
+		case 'u':
+			expanded.WriteString(localuser)
+		case '%':
+			expanded.WriteByte('%')
+		default:
+			expanded.WriteByte('%')
+			expanded.WriteByte(s[i])
+		}
+	}
+	return expanded.String()
+}
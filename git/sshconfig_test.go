@@ -0,0 +1,200 @@
+package git
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHConfigParsing(t *testing.T) {
+	config := `# Global options
+IdentityFile ~/.ssh/global
+
+Host *.example.com !bad.example.com
+	User alice
+	Port 2222
+	IdentityFile ~/.ssh/example
+	IdentitiesOnly yes
+
+Host git.example.com
+	HostName=real.example.com
+	User bob
+	StrictHostKeyChecking accept-new
+	UserKnownHostsFile ~/.ssh/example_known_hosts
+
+Match exec "false"
+	User nobody
+
+Host *
+	User fallback
+	Port 22
+`
+	tests := []struct {
+		host string
+		want sshHostConfig
+	}{
+		{
+			"git.example.com",
+			sshHostConfig{
+				HostName:              "real.example.com",
+				User:                  "alice",
+				Port:                  "2222",
+				IdentityFiles:         []string{"~/.ssh/global", "~/.ssh/example"},
+				IdentitiesOnly:        true,
+				StrictHostKeyChecking: "accept-new",
+				UserKnownHostsFiles:   []string{"~/.ssh/example_known_hosts"},
+			},
+		},
+		{
+			"bad.example.com",
+			sshHostConfig{
+				User:          "fallback",
+				Port:          "22",
+				IdentityFiles: []string{"~/.ssh/global"},
+			},
+		},
+		{
+			"github.com",
+			sshHostConfig{
+				User:          "fallback",
+				Port:          "22",
+				IdentityFiles: []string{"~/.ssh/global"},
+			},
+		},
+	}
+	for i, tc := range tests {
+		got := parseSSHConfig(strings.NewReader(config), tc.host)
+		if fmt.Sprintf("%v", got) != fmt.Sprintf("%v", tc.want) {
+			t.Errorf("Test %d (%v): got %v want %v", i, tc.host, got, tc.want)
+		}
+	}
+}
+
+func TestKnownHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitknownhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newKey := func() ssh.PublicKey {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ssh.NewPublicKey(&priv.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	known, other, revoked := newKey(), newKey(), newKey()
+
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte("[hashed.example.com]:2222"))
+	hashed := fmt.Sprintf("|1|%s|%s", base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	knownhosts := filepath.Join(dir, "known_hosts")
+	contents := "# comment\n" +
+		"example.com,192.0.2.1 " + string(ssh.MarshalAuthorizedKey(known)) +
+		hashed + " " + string(ssh.MarshalAuthorizedKey(known)) +
+		"*.wild.example.com,!bad.wild.example.com " + string(ssh.MarshalAuthorizedKey(known)) +
+		"[plain.example.com]:2222,[*.port.example.com]:2200 " + string(ssh.MarshalAuthorizedKey(known)) +
+		"@revoked * " + string(ssh.MarshalAuthorizedKey(revoked))
+	if err := ioutil.WriteFile(knownhosts, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k := newKnownHosts(sshHostConfig{UserKnownHostsFiles: []string{knownhosts}}, "", "")
+
+	tests := []struct {
+		hostport string
+		key      ssh.PublicKey
+		want     knownHostStatus
+	}{
+		{"example.com:22", known, hostKnown},
+		{"192.0.2.1:22", known, hostKnown},
+		{"example.com:22", other, hostKeyChanged},
+		{"example.com:2222", known, hostUnknown},
+		{"hashed.example.com:2222", known, hostKnown},
+		{"hashed.example.com:22", known, hostUnknown},
+		{"foo.wild.example.com:22", known, hostKnown},
+		{"bad.wild.example.com:22", known, hostUnknown},
+		{"plain.example.com:2222", known, hostKnown},
+		{"plain.example.com:22", known, hostUnknown},
+		{"plain.example.com:2200", known, hostUnknown},
+		{"foo.port.example.com:2200", known, hostKnown},
+		{"p:2222", known, hostUnknown},
+		{"example.com:22", revoked, hostKeyRevoked},
+		{"unknown.example.com:22", other, hostUnknown},
+	}
+	for i, tc := range tests {
+		if got := k.lookup(knownHostsAddr(tc.hostport), tc.key); got != tc.want {
+			t.Errorf("Test %d (%v): got status %v want %v", i, tc.hostport, got, tc.want)
+		}
+	}
+
+	// With StrictHostKeyChecking=yes unknown hosts are refused, and
+	// with accept-new they're added to the file.
+	k.strict = "yes"
+	if err := k.HostKeyCallback("new.example.com:22", nil, other); err == nil {
+		t.Error("Unknown host accepted with StrictHostKeyChecking=yes")
+	}
+	k.strict = "accept-new"
+	if err := k.HostKeyCallback("new.example.com:22", nil, other); err != nil {
+		t.Errorf("Unknown host not accepted with StrictHostKeyChecking=accept-new: %v", err)
+	}
+	if got := k.lookup("new.example.com", other); got != hostKnown {
+		t.Errorf("New host was not added to known_hosts: got status %v", got)
+	}
+	if err := k.HostKeyCallback("example.com:22", nil, other); err == nil {
+		t.Error("Changed host key accepted")
+	}
+}
+
+func TestParseRemoteURL(t *testing.T) {
+	tests := []struct {
+		remote string
+		scp    bool
+		want   string
+	}{
+		{"git@github.com:driusan/dgit.git", true, "ssh://git@github.com/~/driusan/dgit.git"},
+		{"example.com:/srv/git/repo", true, "ssh://example.com/srv/git/repo"},
+		{"example.com:~user/repo", true, "ssh://example.com/~user/repo"},
+		{"[::1]:repo", true, "ssh://[::1]/~/repo"},
+		{"./foo:bar", false, "./foo:bar"},
+		{"ssh://example.com:2222/repo", false, "ssh://example.com:2222/repo"},
+	}
+	for i, tc := range tests {
+		if got := isSCPLikeURL(tc.remote); got != tc.scp {
+			t.Errorf("Test %d: isSCPLikeURL(%v) got %v want %v", i, tc.remote, got, tc.scp)
+		}
+		uri, err := parseRemoteURL(tc.remote)
+		if err != nil {
+			t.Errorf("Test %d: %v", i, err)
+			continue
+		}
+		if got := uri.String(); got != tc.want {
+			t.Errorf("Test %d: parseRemoteURL(%v) got %v want %v", i, tc.remote, got, tc.want)
+		}
+	}
+
+	uri, _ := parseRemoteURL("git@github.com:driusan/dgit.git")
+	if got := sshRemotePath(uri); got != "~/driusan/dgit.git" {
+		t.Errorf("Unexpected remote path: got %v", got)
+	}
+	if got := shellQuote("it's"); got != `'it'\''s'` {
+		t.Errorf("Unexpected shell quoting: got %v", got)
+	}
+}
@@ -4,29 +4,93 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"os/exec"
 	"os/user"
+	"path/filepath"
+	"strings"
 
 	"golang.org/x/crypto/ssh"
 )
 
 // an sshConn represents a remote which uses ssh
-// for transport (ie. a remote that starts with ssh://)
+// for transport (ie. a remote that starts with ssh:// or an scp-style
+// user@host:path remote)
 type sshConn struct {
 	// Add functionality shared amongst all types of remotes
 	*sharedRemoteConn
 
+	// The client, used for looking up core.sshCommand and ssh.variant
+	c *Client
+
+	client  *ssh.Client
 	session *ssh.Session
 
+	// Set instead of client and session if an external ssh command
+	// is used for the connection.
+	cmd *exec.Cmd
+
 	stdin  io.Reader
-	stdout io.Writer
+	stdout io.WriteCloser
 }
 
 var _ RemoteConn = &sshConn{}
 
+// Quotes s for a POSIX shell, the same way that git quotes the path
+// passed to the remote service and the output of rev-parse --parseopt.
+// "!" is quoted so that it's safe from history expansion.
+func shellQuote(s string) string {
+	s = strings.Replace(s, "'", `'\''`, -1)
+	return "'" + strings.Replace(s, "!", `'\!'`, -1) + "'"
+}
+
+// Returns the path to pass to the remote git-upload-pack or
+// git-receive-pack. A path starting with /~ (ie. from
+// ssh://host/~user/repo) is relative to a home directory, so the leading
+// slash is removed.
+func sshRemotePath(uri *url.URL) string {
+	if strings.HasPrefix(uri.Path, "/~") {
+		return uri.Path[1:]
+	}
+	return uri.Path
+}
+
+// Returns the external ssh command that should be used to connect, and
+// whether it needs to be run through the shell. If the empty string is
+// returned, the built in ssh client should be used.
+func (s *sshConn) sshCommand() (string, bool) {
+	if cmd := os.Getenv("GIT_SSH_COMMAND"); cmd != "" {
+		return cmd, true
+	}
+	if s.c != nil {
+		if cmd := s.c.GetConfig("core.sshCommand"); cmd != "" {
+			return cmd, true
+		}
+	}
+	if cmd := os.Getenv("GIT_SSH"); cmd != "" {
+		return cmd, false
+	}
+	return "", false
+}
+
 func (s *sshConn) OpenConn(srv GitService) error {
-	host := s.uri.Hostname()
+	remotecmd := s.service + " " + shellQuote(sshRemotePath(s.uri))
+	if sshcmd, shell := s.sshCommand(); sshcmd != "" {
+		return s.openExternal(sshcmd, shell, remotecmd)
+	}
+
+	alias := s.uri.Hostname()
+	cfg := loadSSHConfig(alias)
+	host := alias
+	if cfg.HostName != "" {
+		host = expandSSHPath(cfg.HostName, alias, "")
+	}
 	port := s.uri.Port()
+	if port == "" {
+		port = cfg.Port
+	}
 	if port == "" {
 		port = "22"
 	}
@@ -35,6 +99,8 @@ func (s *sshConn) OpenConn(srv GitService) error {
 	var username string
 	if s.uri.User != nil {
 		username = s.uri.User.Username()
+	} else if cfg.User != "" {
+		username = cfg.User
 	} else {
 		u, err := user.Current()
 		if err != nil {
@@ -43,19 +109,57 @@ func (s *sshConn) OpenConn(srv GitService) error {
 		username = u.Username
 	}
 	log.Println("Using username", username)
+
+	identities := cfg.IdentityFiles
+	if len(identities) == 0 {
+		identities = []string{"~/.ssh/id_rsa", "~/.ssh/id_ecdsa", "~/.ssh/id_ed25519", "~/.ssh/id_dsa"}
+	}
+	for i, id := range identities {
+		identities[i] = expandSSHPath(id, host, username)
+	}
+
+	// Keys from the ssh-agent are tried before identity files, unless
+	// IdentitiesOnly is set.
+	var agent *sshAgent
+	if !cfg.IdentitiesOnly {
+		agent = dialSSHAgent()
+	}
+	if agent != nil {
+		defer agent.Close()
+	}
+	signers := func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+		if agent != nil {
+			agentsigners, err := agent.Signers()
+			if err != nil {
+				log.Printf("Could not get keys from ssh-agent: %v\n", err)
+			}
+			signers = append(signers, agentsigners...)
+		}
+		filesigners, err := getSigners(identities)
+		if err != nil {
+			return nil, err
+		}
+		return append(signers, filesigners...), nil
+	}
+
+	addr := net.JoinHostPort(host, port)
+	knownhosts := newKnownHosts(cfg, host, username)
 	config := &ssh.ClientConfig{
 		User: username,
 		Auth: []ssh.AuthMethod{
-			ssh.PublicKeysCallback(getSigners),
+			ssh.PublicKeysCallback(signers),
 		},
-		HostKeyCallback: hostKeyCallback(),
+		HostKeyCallback:   knownhosts.HostKeyCallback,
+		HostKeyAlgorithms: knownhosts.hostKeyAlgorithms(addr),
 	}
-	conn, err := ssh.Dial("tcp", host+":"+port, config)
+	conn, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
 		return err
 	}
 	session, err := conn.NewSession()
 	if err != nil {
+		conn.Close()
 		return err
 	}
 	session.Stderr = os.Stderr
@@ -64,15 +168,109 @@ func (s *sshConn) OpenConn(srv GitService) error {
 	// We don't check error on setenv because if it failed we'll just fall
 	// back on protocol v1
 	session.Setenv("GIT_PROTOCOL", "version=2")
+	s.client = conn
 	s.session = session
 
-	if err := session.Start(s.service + " " + s.uri.Path); err != nil {
+	if err := session.Start(remotecmd); err != nil {
 		return err
 	}
+	return s.readInitialConnection()
+}
 
+// Returns the ssh variant of the external command sshcmd, which determines
+// what options it accepts. This is either ssh.variant, or auto detected
+// from the name of the command.
+func (s *sshConn) sshVariant(sshcmd string, shell bool) string {
+	if v := os.Getenv("GIT_SSH_VARIANT"); v != "" {
+		return v
+	}
+	if s.c != nil {
+		if v := s.c.GetConfig("ssh.variant"); v != "" && v != "auto" {
+			return v
+		}
+	}
+	prog := sshcmd
+	if shell {
+		if fields := strings.Fields(sshcmd); len(fields) > 0 {
+			prog = fields[0]
+		}
+	}
+	prog = strings.ToLower(strings.TrimSuffix(filepath.Base(prog), ".exe"))
+	switch prog {
+	case "ssh", "plink", "putty", "tortoiseplink":
+		return prog
+	}
+	return "simple"
+}
+
+// Opens the connection by running an external ssh command rather than using
+// the built in client.
+func (s *sshConn) openExternal(sshcmd string, shell bool, remotecmd string) error {
+	var args []string
+	variant := s.sshVariant(sshcmd, shell)
+	port := s.uri.Port()
+	switch variant {
+	case "ssh":
+		args = append(args, "-o", "SendEnv=GIT_PROTOCOL")
+		if port != "" {
+			args = append(args, "-p", port)
+		}
+	case "plink", "putty":
+		if port != "" {
+			args = append(args, "-P", port)
+		}
+	case "tortoiseplink":
+		args = append(args, "-batch")
+		if port != "" {
+			args = append(args, "-P", port)
+		}
+	default:
+		if port != "" {
+			return fmt.Errorf("ssh variant '%s' does not support setting port", variant)
+		}
+	}
+	host := s.uri.Hostname()
+	if s.uri.User != nil {
+		host = s.uri.User.Username() + "@" + host
+	}
+	args = append(args, host, remotecmd)
+	log.Printf("Connecting with external ssh command %v %v\n", sshcmd, args)
+
+	var cmd *exec.Cmd
+	if shell {
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = shellQuote(arg)
+		}
+		cmd = exec.Command(shellCommand, "-c", sshcmd+" "+strings.Join(quoted, " "))
+	} else {
+		cmd = exec.Command(sshcmd, args...)
+	}
+	cmd.Env = append(os.Environ(), "GIT_PROTOCOL=version=2")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	s.stdout = stdin
+	s.stdin = stdout
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.cmd = cmd
+	return s.readInitialConnection()
+}
+
+// Reads the initial ref advertisement or capabilities from the remote
+// service.
+func (s *sshConn) readInitialConnection() error {
 	v, cap, refs, err := parseRemoteInitialConnection(s.stdin, false)
 	if err != nil {
-		session.Close()
+		s.Close()
 		return err
 	}
 	s.packProtocolReader = &packProtocolReader{conn: s.stdin, state: PktLineMode}
@@ -85,7 +283,13 @@ func (s *sshConn) OpenConn(srv GitService) error {
 
 func (s sshConn) Close() error {
 	fmt.Fprintf(s.stdout, "0000")
-	return s.session.Close()
+	if s.cmd != nil {
+		s.stdout.Close()
+		return s.cmd.Wait()
+	}
+	err := s.session.Close()
+	s.client.Close()
+	return err
 }
 
 func (s sshConn) GetRefs(opts LsRemoteOptions, patterns []string) ([]Ref, error) {
@@ -147,11 +351,3 @@ func (s sshConn) Write(data []byte) (int, error) {
 		return 0, fmt.Errorf("Invalid write mode")
 	}
 }
-
-// this should be overridden for various platforms. Plan9/9front should parse
-// $home/lib/sshthumbs, unix should parse ~/.ssh/known_hosts, and Windows should..
-// do something?
-func hostKeyCallback() ssh.HostKeyCallback {
-	fmt.Fprintln(os.Stderr, "WARNING: Fingerprint for hostname not validated.")
-	return ssh.InsecureIgnoreHostKey()
-}
@@ -138,6 +138,21 @@ func StatusBranch(c *Client, opts StatusOptions, lineprefix string) (string, err
 
 }
 
+// Returns the options for detecting the renames between HEAD and the
+// index shown by status, from the status.renames and status.renameLimit
+// config, or diff.renames and diff.renameLimit if they aren't set.
+func statusRenameOptions(c *Client) DiffCommonOptions {
+	renames := c.GetConfig("status.renames")
+	if renames == "" {
+		renames = c.GetConfig("diff.renames")
+	}
+	limit := c.GetConfig("status.renamelimit")
+	if limit == "" {
+		limit = c.GetConfig("diff.renamelimit")
+	}
+	return renameOptions(renames, limit)
+}
+
 // Return a string of the status
 func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, lineprefix string) (string, error) {
 	// If no head commit: "no changes yet", else branch info
@@ -205,7 +220,7 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 		}
 	} else {
 		hasCommit = true
-		staged, err = DiffIndex(c, DiffIndexOptions{Cached: true}, index, head, files)
+		staged, err = DiffIndex(c, DiffIndexOptions{DiffCommonOptions: statusRenameOptions(c), Cached: true}, index, head, files)
 		if err != nil {
 			return "", err
 		}
@@ -227,7 +242,17 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 				continue
 			}
 
-			if f.Src == (TreeEntry{}) {
+			if f.OldName != "" {
+				oldname, err := f.OldName.FilePath(c)
+				if err != nil {
+					return "", err
+				}
+				verb := "renamed"
+				if f.Status() == "C" {
+					verb = "copied"
+				}
+				stagedMsg += fmt.Sprintf("%v\t%v:\t%v -> %v\n", lineprefix, verb, oldname, fname)
+			} else if f.Src == (TreeEntry{}) {
 				stagedMsg += fmt.Sprintf("%v\tnew file:\t%v\n", lineprefix, fname)
 			} else if f.Dst == (TreeEntry{}) {
 				stagedMsg += fmt.Sprintf("%v\tdeleted:\t%v\n", lineprefix, fname)
@@ -427,6 +452,9 @@ func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, linepre
 		return "", err
 	}
 	tree := make(map[IndexPath]*IndexEntry)
+	// The files which were renamed or copied in the index, by their new
+	// name.
+	renames := make(map[IndexPath]HashDiff)
 	// It's not an error to use "git status" before the first commit,
 	// so discard the error
 	if head, err := c.GetHeadCommit(); err == nil {
@@ -440,6 +468,19 @@ func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, linepre
 		for _, e := range i {
 			tree[e.PathName] = e
 		}
+
+		if opts := statusRenameOptions(c); opts.DetectRenames {
+			index, _ := c.GitDir.ReadIndex()
+			staged, err := DiffIndex(c, DiffIndexOptions{DiffCommonOptions: opts, Cached: true}, index, head, files)
+			if err != nil {
+				return "", err
+			}
+			for _, diff := range staged {
+				if diff.OldName != "" {
+					renames[diff.Name] = diff
+				}
+			}
+		}
 	}
 	var ret string
 	var wtst, ist rune
@@ -476,7 +517,19 @@ func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, linepre
 					wtst = ' '
 				}
 			}
-			if ist != ' ' || wtst != ' ' {
+			if rename, ok := renames[f.PathName]; ok {
+				oldname, err := rename.OldName.FilePath(c)
+				if err != nil {
+					return "", err
+				}
+				// The -z format has the old name after the new
+				// one, since it doesn't need to be quoted.
+				if lineending == "\000" {
+					ret += fmt.Sprintf("%v%c %v%v%v%v", rename.Status(), wtst, fname, lineending, oldname, lineending)
+				} else {
+					ret += fmt.Sprintf("%v%c %v -> %v%v", rename.Status(), wtst, oldname, fname, lineending)
+				}
+			} else if ist != ' ' || wtst != ' ' {
 				ret += fmt.Sprintf("%c%c %v%v", ist, wtst, fname, lineending)
 			}
 		case Stage1:
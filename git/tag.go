@@ -3,7 +3,6 @@ package git
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -31,25 +30,16 @@ func TagList(c *Client, opts TagOptions, patterns []string) ([]string, error) {
 		return nil, fmt.Errorf("Tag list with patterns not implemented")
 	}
 
-	files := []string{}
-
-	err := filepath.Walk(filepath.Join(c.GitDir.String(), "refs", "tags"),
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() {
-				files = append(files, path)
-			}
-			return nil
-		})
+	refs, err := listRefs(c)
 	if err != nil {
 		return nil, err
 	}
 
 	var tags []string
-	for _, f := range files {
-		tags = append(tags, f[len(c.GitDir.String())+len("/refs/tags/"):])
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Name, "refs/tags/") {
+			tags = append(tags, strings.TrimPrefix(ref.Name, "refs/tags/"))
+		}
 	}
 	sort.Slice(tags, func(i, j int) bool {
 		if opts.IgnoreCase {
@@ -76,7 +66,7 @@ func TagCommit(c *Client, opts TagOptions, tagname string, cmt Commitish, msg st
 		}
 		comm = cmmt
 	}
-	if refspec.File(c).Exists() && !opts.Force {
+	if refExists(c, refspec.String()) && !opts.Force {
 		return fmt.Errorf("tag '%v' already exists", tagname)
 	}
 	if opts.Annotated {
@@ -123,7 +113,12 @@ func TagDelete(c *Client, opts TagOptions, tagnames []Refname) error {
 			return fmt.Errorf("Invalid tag: %v", tag.Name)
 		}
 		file := c.GitDir.File(File(tag.Name))
-		if err := os.Remove(file.String()); err != nil {
+		if file.Exists() {
+			if err := os.Remove(file.String()); err != nil {
+				return err
+			}
+		}
+		if err := removePackedRefs(c, tag.Name); err != nil {
 			return err
 		}
 	}
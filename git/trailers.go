@@ -0,0 +1,222 @@
+package git
+
+import (
+	"strings"
+)
+
+// Trailer lines which are generated by git, which make a paragraph that
+// contains them more likely to be treated as a trailer block.
+var gitGeneratedTrailerPrefixes = []string{"Signed-off-by: ", "(cherry picked from commit "}
+
+// trailerOptions control which trailers are included by formatTrailers,
+// and how they're formatted. They correspond to the options for the
+// %(trailers) placeholder in a format string.
+type trailerOptions struct {
+	// Only show trailers with one of these keys (compared case
+	// insensitively.) Setting a key implies Only.
+	Keys []string
+
+	// Only show lines of the trailer block which are trailers.
+	Only bool
+
+	// Join continuation lines of trailers.
+	Unfold bool
+
+	// Only show the key or the value of trailers.
+	KeyOnly, ValueOnly bool
+
+	// Separates each trailer in the output instead of terminating
+	// them with a newline, if not nil.
+	Separator *string
+
+	// Separates the key from the value instead of ": ", if not nil.
+	KeyValueSeparator *string
+}
+
+// Returns the offset of the trailer separator in line, or -1 if line
+// isn't a trailer.
+func trailerSeparator(line string) int {
+	whitespace := false
+	for i, c := range line {
+		switch {
+		case c == ':':
+			return i
+		case !whitespace && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-'):
+			continue
+		case i > 0 && (c == ' ' || c == '\t'):
+			whitespace = true
+			continue
+		}
+		break
+	}
+	return -1
+}
+
+// Returns the trailer block of the message msg, which starts at the
+// subject of the commit, as git interpret-trailers would find it. The
+// block is the last paragraph of the message if it consists entirely of
+// trailers, or if it has at least one git generated trailer and at least
+// 25% of its lines are trailers. The title can never be a trailer block.
+func findTrailerBlock(msg string) string {
+	lines := strings.SplitAfter(msg, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	isBlank := func(line string) bool { return strings.TrimSpace(line) == "" }
+
+	// Ignore blank lines and comments at the end.
+	end := len(lines)
+	for end > 0 && (isBlank(lines[end-1]) || strings.HasPrefix(lines[end-1], "#")) {
+		end--
+	}
+
+	endOfTitle := 0
+	for ; endOfTitle < end; endOfTitle++ {
+		if strings.HasPrefix(lines[endOfTitle], "#") {
+			continue
+		}
+		if isBlank(lines[endOfTitle]) {
+			break
+		}
+	}
+
+	trailerLines, nonTrailerLines, possibleContinuations := 0, 0, 0
+	recognizedPrefix := false
+	onlySpaces := true
+lines:
+	for i := end - 1; i >= endOfTitle; i-- {
+		line := lines[i]
+		if strings.HasPrefix(line, "#") {
+			nonTrailerLines += possibleContinuations
+			possibleContinuations = 0
+			continue
+		}
+		if isBlank(line) {
+			if onlySpaces {
+				continue
+			}
+			nonTrailerLines += possibleContinuations
+			if recognizedPrefix && trailerLines*3 >= nonTrailerLines || trailerLines > 0 && nonTrailerLines == 0 {
+				return strings.Join(lines[i+1:end], "")
+			}
+			return ""
+		}
+		onlySpaces = false
+		for _, prefix := range gitGeneratedTrailerPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				trailerLines++
+				possibleContinuations = 0
+				recognizedPrefix = true
+				continue lines
+			}
+		}
+		switch {
+		case trailerSeparator(line) >= 1 && line[0] != ' ' && line[0] != '\t':
+			trailerLines++
+			possibleContinuations = 0
+		case line[0] == ' ' || line[0] == '\t':
+			possibleContinuations++
+		default:
+			nonTrailerLines += 1 + possibleContinuations
+			possibleContinuations = 0
+		}
+	}
+	return ""
+}
+
+// Formats the trailers of msg, which starts at the commit's subject,
+// according to opts.
+func formatTrailers(msg string, opts trailerOptions) string {
+	block := findTrailerBlock(msg)
+	if !opts.Only && !opts.Unfold && opts.Keys == nil && opts.Separator == nil && !opts.KeyOnly && !opts.ValueOnly && opts.KeyValueSeparator == nil {
+		return block
+	}
+
+	// Continuation lines are part of the trailer before them.
+	var trailers []string
+	isTrailer := false
+	for _, line := range strings.SplitAfter(block, "\n") {
+		if line == "" {
+			continue
+		}
+		if isTrailer && (line[0] == ' ' || line[0] == '\t') {
+			trailers[len(trailers)-1] += line
+			continue
+		}
+		trailers = append(trailers, line)
+		isTrailer = trailerSeparator(line) >= 1
+	}
+
+	var s strings.Builder
+	for _, trailer := range trailers {
+		sep := trailerSeparator(trailer)
+		if sep < 1 {
+			if opts.Only {
+				continue
+			}
+			if opts.Separator != nil && s.Len() > 0 {
+				s.WriteString(*opts.Separator)
+			}
+			s.WriteString(trailer)
+			if opts.Separator != nil {
+				str := strings.TrimRight(s.String(), " \t\n")
+				s.Reset()
+				s.WriteString(str)
+			}
+			continue
+		}
+		key := strings.TrimSpace(trailer[:sep])
+		value := strings.TrimSpace(trailer[sep+1:])
+		if opts.Keys != nil {
+			matched := false
+			for _, k := range opts.Keys {
+				if strings.EqualFold(strings.TrimSuffix(k, ":"), key) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		if opts.Unfold {
+			value = unfoldTrailer(value)
+		}
+		if opts.Separator != nil && s.Len() > 0 {
+			s.WriteString(*opts.Separator)
+		}
+		if !opts.ValueOnly {
+			s.WriteString(key)
+		}
+		if !opts.KeyOnly && !opts.ValueOnly {
+			if opts.KeyValueSeparator != nil {
+				s.WriteString(*opts.KeyValueSeparator)
+			} else {
+				s.WriteString(": ")
+			}
+		}
+		if !opts.KeyOnly {
+			s.WriteString(value)
+		}
+		if opts.Separator == nil {
+			s.WriteString("\n")
+		}
+	}
+	return s.String()
+}
+
+// Collapses the continuation lines of a trailer value into single spaces.
+func unfoldTrailer(value string) string {
+	var s strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\n' {
+			s.WriteByte(value[i])
+			continue
+		}
+		for i+1 < len(value) && strings.IndexByte(" \t\n\r\v\f", value[i+1]) >= 0 {
+			i++
+		}
+		s.WriteByte(' ')
+	}
+	return strings.TrimSpace(s.String())
+}
@@ -110,10 +110,12 @@ func UpdateRef(c *Client, opts UpdateRefOptions, ref string, cmt CommitID, reaso
 		// FIXME: This is more of a hack to ensure that the fsck passes
 		// than a real implementation.
 		f := c.GitDir.File(File(ref))
-		if !f.Exists() {
-			return nil
+		if f.Exists() {
+			if err := f.Remove(); err != nil {
+				return err
+			}
 		}
-		return f.Remove()
+		return removePackedRefs(c, ref)
 	}
 
 	// It's not a symbolic ref, it's a real ref. Just directly call UpdateRefSpec
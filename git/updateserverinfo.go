@@ -0,0 +1,92 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type UpdateServerInfoOptions struct {
+	// Rewrite the files even if they haven't changed.
+	Force bool
+}
+
+// UpdateServerInfo writes the info/refs and objects/info/packs files that
+// are needed by clients using the dumb http protocol to find out what refs
+// and packs are in the repository.
+func UpdateServerInfo(c *Client, opts UpdateServerInfoOptions) error {
+	refs, err := ShowRef(c, ShowRefOptions{Dereference: true}, nil)
+	if err != nil {
+		return err
+	}
+	var info bytes.Buffer
+	for _, ref := range refs {
+		fmt.Fprintf(&info, "%s\n", ref.TabString())
+	}
+	if err := writeServerInfoFile(c.GitDir.File("info/refs"), info.Bytes(), opts.Force); err != nil {
+		return err
+	}
+
+	packdir := filepath.Join(c.GetObjectsDir().String(), "pack")
+	files, err := ioutil.ReadDir(packdir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var packs []string
+	for _, fi := range files {
+		name := fi.Name()
+		if filepath.Ext(name) != ".pack" {
+			continue
+		}
+		// Packs without an index can't be used by clients.
+		if !File(filepath.Join(packdir, strings.TrimSuffix(name, ".pack")+".idx")).Exists() {
+			continue
+		}
+		packs = append(packs, name)
+	}
+	sort.Strings(packs)
+	var packinfo bytes.Buffer
+	for _, p := range packs {
+		fmt.Fprintf(&packinfo, "P %s\n", p)
+	}
+	packinfo.WriteString("\n")
+	return writeServerInfoFile(File(filepath.Join(c.GetObjectsDir().String(), "info", "packs")), packinfo.Bytes(), opts.Force)
+}
+
+// Writes data to the server info file f. Unless force is set, the file is
+// only written if the content changed.
+func writeServerInfoFile(f File, data []byte, force bool) error {
+	if !force {
+		if old, err := ioutil.ReadFile(f.String()); err == nil && bytes.Equal(old, data) {
+			return nil
+		}
+	}
+	dir := filepath.Dir(f.String())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file and rename it, so that a client never
+	// sees a partially written file.
+	tmp, err := ioutil.TempFile(dir, filepath.Base(f.String())+"_")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.String())
+}
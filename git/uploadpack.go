@@ -0,0 +1,1207 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type UploadPackOptions struct {
+	// Serve a single request and response and then exit, rather than
+	// carrying on a conversation with the client. This is used by the
+	// smart http protocol.
+	StatelessRPC bool
+
+	// Only advertise the refs (or capabilities for protocol v2) and
+	// then exit.
+	AdvertiseRefs bool
+
+	// The value of the GIT_PROTOCOL environment variable (or
+	// Git-Protocol http header) sent by the client, which is used to
+	// request protocol version 2.
+	GitProtocol string
+}
+
+// Returns the protocol version requested by the GIT_PROTOCOL value
+// gitprotocol, which is a colon separated list of key=value pairs.
+func requestedProtocolVersion(gitprotocol string) uint8 {
+	var version uint8
+	for _, param := range strings.Split(gitprotocol, ":") {
+		switch param {
+		case "version=1":
+			if version < 1 {
+				version = 1
+			}
+		case "version=2":
+			version = 2
+		}
+	}
+	return version
+}
+
+// Returns the refs that upload-pack and receive-pack advertise, starting
+// with HEAD if it points to a valid commit, followed by the other refs
+// in sorted order. The target of HEAD is also returned if it's a
+// symbolic ref.
+func advertisedRefs(c *Client) ([]Ref, string, error) {
+	var refs []Ref
+	var headtarget string
+	if target, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD"); err == nil {
+		headtarget = target.String()
+	}
+	if head, err := c.GetHeadCommit(); err == nil {
+		refs = append(refs, Ref{"HEAD", Sha1(head)})
+	}
+	all, err := ShowRef(c, ShowRefOptions{}, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return append(refs, all...), headtarget, nil
+}
+
+// Returns the object that the tag id ultimately points to, or id itself
+// if it isn't a tag.
+func peelTag(c *Client, id Sha1) (Sha1, error) {
+	for i := 0; i < 100; i++ {
+		if id.Type(c) != "tag" {
+			return id, nil
+		}
+		tag, err := c.GetTagObject(id)
+		if err != nil {
+			return Sha1{}, err
+		}
+		if id, err = Sha1FromString(tag.GetHeader("object")); err != nil {
+			return Sha1{}, err
+		}
+	}
+	return Sha1{}, fmt.Errorf("Tag nesting too deep for %v", id)
+}
+
+// Writes a protocol v0 ref advertisement for refs to w, with the
+// capabilities caps on the first line. If peel is set, annotated tags are
+// followed by their peeled value.
+func writeRefAdvertisement(c *Client, w pktLineWriter, refs []Ref, caps string, peel bool) error {
+	if len(refs) == 0 {
+		if err := w.Printf("%v capabilities^{}\x00%s\n", Sha1{}, caps); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	for i, ref := range refs {
+		var err error
+		if i == 0 {
+			err = w.Printf("%v %s\x00%s\n", ref.Value, ref.Name, caps)
+		} else {
+			err = w.Printf("%v %s\n", ref.Value, ref.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if !peel || ref.Name == "HEAD" || ref.Value.Type(c) != "tag" {
+			continue
+		}
+		peeled, err := peelTag(c, ref.Value)
+		if err != nil {
+			return err
+		}
+		if err := w.Printf("%v %s^{}\n", peeled, ref.Name); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// An objectFilter is a filter from a partial clone, which determines
+// which objects are omitted from the pack.
+type objectFilter struct {
+	// Omit blobs larger than this. -1 means no limit.
+	blobLimit int64
+
+	// Omit trees (and the blobs in them) at this depth or deeper, where
+	// the root tree of a commit has depth 0. -1 means no limit.
+	treeDepth int
+}
+
+// Parses a filter-spec, as given to --filter. Only blob:none,
+// blob:limit=<n>[kmg], and tree:<depth> are supported.
+func parseObjectFilter(spec string) (objectFilter, error) {
+	f := objectFilter{-1, -1}
+	switch {
+	case spec == "blob:none":
+		f.blobLimit = 0
+	case strings.HasPrefix(spec, "blob:limit="):
+		limit := strings.ToLower(strings.TrimPrefix(spec, "blob:limit="))
+		mult := int64(1)
+		switch {
+		case strings.HasSuffix(limit, "k"):
+			mult = 1024
+		case strings.HasSuffix(limit, "m"):
+			mult = 1024 * 1024
+		case strings.HasSuffix(limit, "g"):
+			mult = 1024 * 1024 * 1024
+		}
+		n, err := strconv.ParseInt(strings.TrimRight(limit, "kmg"), 10, 64)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("Invalid filter-spec '%s'", spec)
+		}
+		f.blobLimit = n * mult
+	case strings.HasPrefix(spec, "tree:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(spec, "tree:"))
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("Invalid filter-spec '%s'", spec)
+		}
+		f.treeDepth = n
+	default:
+		return f, fmt.Errorf("Unsupported filter-spec '%s'", spec)
+	}
+	return f, nil
+}
+
+// The state of an upload-pack session
+type uploadPack struct {
+	c    *Client
+	opts UploadPackOptions
+
+	r   *packProtocolReader
+	w   pktLineWriter
+	out io.Writer
+
+	// The advertised refs, and the set of objects which may be wanted
+	// without any uploadpack.allow* config.
+	refs []Ref
+	tips map[Sha1]struct{}
+
+	// Capabilities requested by the client
+	caps map[string]struct{}
+
+	wants  []Sha1
+	common []Sha1
+
+	// Commits that the client told us are shallow in its repository.
+	clientShallow map[Sha1]struct{}
+
+	depth          int
+	deepenSince    int64
+	deepenNot      []string
+	deepenRelative bool
+
+	filter *objectFilter
+}
+
+// UploadPack serves a fetch to the client on the other end of r and w,
+// sending the client a pack with the objects that it wants.
+func UploadPack(c *Client, opts UploadPackOptions, r io.Reader, w io.Writer) error {
+	u := &uploadPack{
+		c:             c,
+		opts:          opts,
+		r:             newPktLineReader(r),
+		w:             pktLineWriter{w},
+		out:           w,
+		caps:          make(map[string]struct{}),
+		clientShallow: make(map[Sha1]struct{}),
+	}
+	refs, headtarget, err := advertisedRefs(c)
+	if err != nil {
+		return err
+	}
+	u.refs = refs
+	u.tips = make(map[Sha1]struct{})
+	for _, ref := range refs {
+		u.tips[ref.Value] = struct{}{}
+		if peeled, err := peelTag(c, ref.Value); err == nil {
+			u.tips[peeled] = struct{}{}
+		}
+	}
+
+	version := requestedProtocolVersion(opts.GitProtocol)
+	if version == 2 {
+		if !opts.StatelessRPC || opts.AdvertiseRefs {
+			if err := u.advertiseV2(); err != nil {
+				return err
+			}
+		}
+		if opts.AdvertiseRefs {
+			return nil
+		}
+		return u.serveV2()
+	}
+
+	if !opts.StatelessRPC || opts.AdvertiseRefs {
+		if version == 1 {
+			if err := u.w.Printf("version 1\n"); err != nil {
+				return err
+			}
+		}
+		caps := "multi_ack thin-pack side-band side-band-64k ofs-delta shallow deepen-since deepen-not deepen-relative no-progress include-tag multi_ack_detailed"
+		if u.allowConfig("uploadpack.allowTipSHA1InWant") {
+			caps += " allow-tip-sha1-in-want"
+		}
+		if u.allowConfig("uploadpack.allowReachableSHA1InWant") {
+			caps += " allow-reachable-sha1-in-want"
+		}
+		if u.allowConfig("uploadpack.allowFilter") {
+			caps += " filter"
+		}
+		if headtarget != "" && len(refs) > 0 && refs[0].Name == "HEAD" {
+			caps += " symref=HEAD:" + headtarget
+		}
+		caps += " agent=dgit/0.0.2"
+		if err := writeRefAdvertisement(c, u.w, refs, caps, true); err != nil {
+			return err
+		}
+	}
+	if opts.AdvertiseRefs {
+		return nil
+	}
+	return u.serveV0()
+}
+
+// Returns true if the boolean config variable name is set to true.
+func (u *uploadPack) allowConfig(name string) bool {
+	switch strings.ToLower(u.c.GetConfig(name)) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+// Returns true if the client requested the capability cap.
+func (u *uploadPack) hasCap(cap string) bool {
+	_, ok := u.caps[cap]
+	return ok
+}
+
+// Writes an error to the client and returns it.
+func (u *uploadPack) errorf(format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	u.w.Printf("ERR upload-pack: %v\n", err)
+	return err
+}
+
+// Checks whether the client is allowed to want id.
+func (u *uploadPack) allowedWant(id Sha1) bool {
+	if _, ok := u.tips[id]; ok {
+		return true
+	}
+	if have, _, err := u.c.HaveObject(id); !have || err != nil {
+		return false
+	}
+	if u.allowConfig("uploadpack.allowAnySHA1InWant") {
+		return true
+	}
+	if !u.allowConfig("uploadpack.allowReachableSHA1InWant") {
+		return false
+	}
+	// Check if the commit is reachable from any of our refs.
+	seen := make(map[Sha1]struct{})
+	var stack []CommitID
+	for tip := range u.tips {
+		if tip.Type(u.c) == "commit" {
+			stack = append(stack, CommitID(tip))
+		}
+	}
+	for len(stack) > 0 {
+		cmt := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if Sha1(cmt) == id {
+			return true
+		}
+		if _, ok := seen[Sha1(cmt)]; ok {
+			continue
+		}
+		seen[Sha1(cmt)] = struct{}{}
+		parents, err := cmt.Parents(u.c)
+		if err != nil {
+			return false
+		}
+		stack = append(stack, parents...)
+	}
+	return false
+}
+
+// Parses a line from the client which is common to the want section of
+// protocol v0 and the fetch command arguments in v2. Returns false if it
+// isn't a line that is handled.
+func (u *uploadPack) parseFetchArg(line string) (bool, error) {
+	switch {
+	case strings.HasPrefix(line, "want "):
+		id, err := Sha1FromString(strings.TrimPrefix(line, "want "))
+		if err != nil {
+			return true, u.errorf("protocol error: expected sha, got '%s'", line)
+		}
+		if !u.allowedWant(id) {
+			return true, u.errorf("not our ref %v", id)
+		}
+		u.wants = append(u.wants, id)
+	case strings.HasPrefix(line, "shallow "):
+		id, err := Sha1FromString(strings.TrimPrefix(line, "shallow "))
+		if err != nil {
+			return true, u.errorf("invalid shallow line: %s", line)
+		}
+		// Ignore shallow commits that we don't have, since we
+		// can't do anything with them.
+		if id.Type(u.c) == "commit" {
+			u.clientShallow[id] = struct{}{}
+		}
+	case strings.HasPrefix(line, "deepen "):
+		depth, err := strconv.Atoi(strings.TrimPrefix(line, "deepen "))
+		if err != nil || depth <= 0 {
+			return true, u.errorf("invalid deepen: %s", line)
+		}
+		u.depth = depth
+	case strings.HasPrefix(line, "deepen-since "):
+		since, err := strconv.ParseInt(strings.TrimPrefix(line, "deepen-since "), 10, 64)
+		if err != nil {
+			return true, u.errorf("invalid deepen-since: %s", line)
+		}
+		u.deepenSince = since
+	case strings.HasPrefix(line, "deepen-not "):
+		u.deepenNot = append(u.deepenNot, strings.TrimPrefix(line, "deepen-not "))
+	case line == "deepen-relative":
+		u.deepenRelative = true
+	case strings.HasPrefix(line, "filter "):
+		if !u.allowConfig("uploadpack.allowFilter") {
+			return true, u.errorf("filtering not recognized by server")
+		}
+		f, err := parseObjectFilter(strings.TrimPrefix(line, "filter "))
+		if err != nil {
+			return true, u.errorf("%v", err)
+		}
+		u.filter = &f
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// Returns true if the client asked for a shallow fetch.
+func (u *uploadPack) deepening() bool {
+	return u.depth > 0 || u.deepenSince != 0 || len(u.deepenNot) > 0
+}
+
+// Records the client's have line for id, and returns true if we have
+// the object in common.
+func (u *uploadPack) gotHave(id Sha1) bool {
+	if have, _, err := u.c.HaveObject(id); !have || err != nil {
+		return false
+	}
+	for _, c := range u.common {
+		if c == id {
+			return true
+		}
+	}
+	u.common = append(u.common, id)
+	return true
+}
+
+// Serves a protocol v0 (or v1) fetch after the ref advertisement has been
+// sent.
+func (u *uploadPack) serveV0() error {
+	first := true
+	for {
+		line, err := readPktLine(u.r)
+		if err == flushPkt {
+			break
+		} else if err == io.EOF && first {
+			// The client only wanted the ref advertisement
+			// (ie. ls-remote)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if first && strings.HasPrefix(line, "want ") {
+			// The first want line has the capabilities that
+			// the client is using.
+			fields := strings.Fields(line)
+			for _, cap := range fields[2:] {
+				u.caps[cap] = struct{}{}
+			}
+			line = strings.Join(fields[:2], " ")
+			first = false
+		}
+		if ok, err := u.parseFetchArg(line); err != nil {
+			return err
+		} else if !ok {
+			return u.errorf("protocol error: unexpected '%s'", line)
+		}
+	}
+	if len(u.wants) == 0 {
+		return nil
+	}
+	if u.hasCap("deepen-relative") {
+		u.deepenRelative = true
+	}
+
+	shallow, unshallow, err := u.shallowInfo()
+	if err != nil {
+		return err
+	}
+	// Clients only expect the shallow section when they asked to deepen,
+	// even if they sent shallow lines.
+	if u.deepening() {
+		for _, s := range shallow {
+			if err := u.w.Printf("shallow %v\n", s); err != nil {
+				return err
+			}
+		}
+		for _, s := range unshallow {
+			if err := u.w.Printf("unshallow %v\n", s); err != nil {
+				return err
+			}
+		}
+		if err := u.w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	done, err := u.negotiateV0()
+	if err != nil || !done {
+		return err
+	}
+	return u.sendPack(shallow, unshallow)
+}
+
+// Negotiates the common commits with the client, following the ACK/NAK
+// rules for the multi_ack capabilities that the client requested. Returns
+// true once the client sends "done".
+func (u *uploadPack) negotiateV0() (bool, error) {
+	multiack := 0
+	if u.hasCap("multi_ack_detailed") {
+		multiack = 2
+	} else if u.hasCap("multi_ack") {
+		multiack = 1
+	}
+	var last Sha1
+	for {
+		line, err := readPktLine(u.r)
+		switch err {
+		case nil:
+		case flushPkt:
+			if len(u.common) == 0 || multiack > 0 {
+				if err := u.w.Printf("NAK\n"); err != nil {
+					return false, err
+				}
+			}
+			if u.opts.StatelessRPC {
+				return false, nil
+			}
+			continue
+		case io.EOF:
+			// The client hung up.
+			return false, nil
+		default:
+			return false, err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "have "):
+			id, err := Sha1FromString(strings.TrimPrefix(line, "have "))
+			if err != nil {
+				return false, u.errorf("protocol error: expected sha1, got '%s'", line)
+			}
+			if !u.gotHave(id) {
+				continue
+			}
+			last = id
+			switch multiack {
+			case 2:
+				err = u.w.Printf("ACK %v common\n", id)
+			case 1:
+				err = u.w.Printf("ACK %v continue\n", id)
+			default:
+				if len(u.common) == 1 {
+					err = u.w.Printf("ACK %v\n", id)
+				}
+			}
+			if err != nil {
+				return false, err
+			}
+		case line == "done":
+			if len(u.common) == 0 {
+				return true, u.w.Printf("NAK\n")
+			}
+			if multiack > 0 {
+				return true, u.w.Printf("ACK %v\n", last)
+			}
+			return true, nil
+		default:
+			return false, u.errorf("protocol error: expected sha1 list, got '%s'", line)
+		}
+	}
+}
+
+// Sends the capability advertisement for protocol v2.
+func (u *uploadPack) advertiseV2() error {
+	fetch := "fetch=shallow"
+	if u.allowConfig("uploadpack.allowFilter") {
+		fetch += " filter"
+	}
+	for _, line := range []string{"version 2", "agent=dgit/0.0.2", "ls-refs=unborn", fetch, "server-option", "object-format=sha1"} {
+		if err := u.w.Printf("%s\n", line); err != nil {
+			return err
+		}
+	}
+	return u.w.Flush()
+}
+
+// Serves protocol v2 commands until the client hangs up, or after a
+// single command for a stateless connection.
+func (u *uploadPack) serveV2() error {
+	for {
+		line, err := readPktLine(u.r)
+		switch err {
+		case nil:
+		case flushPkt:
+			// An empty request
+			if u.opts.StatelessRPC {
+				return nil
+			}
+			continue
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+		if !strings.HasPrefix(line, "command=") {
+			return u.errorf("protocol error: expected command, got '%s'", line)
+		}
+		command := strings.TrimPrefix(line, "command=")
+
+		// Capabilities come before the delimiter, and arguments
+		// after it.
+		var args []string
+		inargs := false
+	request:
+		for {
+			line, err := readPktLine(u.r)
+			switch err {
+			case nil:
+			case delimPkt:
+				inargs = true
+				continue
+			case flushPkt:
+				break request
+			default:
+				return err
+			}
+			// The capabilities that the client sends (agent,
+			// server-option, object-format) don't change
+			// anything that we do.
+			if inargs {
+				args = append(args, line)
+			}
+		}
+
+		switch command {
+		case "ls-refs":
+			err = u.lsRefs(args)
+		case "fetch":
+			err = u.fetchV2(args)
+		default:
+			err = u.errorf("unknown command '%s'", command)
+		}
+		if err != nil || u.opts.StatelessRPC {
+			return err
+		}
+	}
+}
+
+// Handles the ls-refs command in protocol v2.
+func (u *uploadPack) lsRefs(args []string) error {
+	var peel, symrefs, unborn bool
+	var prefixes []string
+	for _, arg := range args {
+		switch {
+		case arg == "peel":
+			peel = true
+		case arg == "symrefs":
+			symrefs = true
+		case arg == "unborn":
+			unborn = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			prefixes = append(prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		}
+	}
+	matches := func(name string) bool {
+		if len(prefixes) == 0 {
+			return true
+		}
+		for _, p := range prefixes {
+			if strings.HasPrefix(name, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	headtarget := ""
+	if target, err := SymbolicRefGet(u.c, SymbolicRefOptions{}, "HEAD"); err == nil {
+		headtarget = target.String()
+	}
+	if unborn && headtarget != "" && (len(u.refs) == 0 || u.refs[0].Name != "HEAD") && matches("HEAD") {
+		line := "unborn HEAD"
+		if symrefs {
+			line += " symref-target:" + headtarget
+		}
+		if err := u.w.Printf("%s\n", line); err != nil {
+			return err
+		}
+	}
+	for _, ref := range u.refs {
+		if !matches(ref.Name) {
+			continue
+		}
+		line := ref.Value.String() + " " + ref.Name
+		if symrefs && ref.Name == "HEAD" && headtarget != "" {
+			line += " symref-target:" + headtarget
+		}
+		if peel && ref.Value.Type(u.c) == "tag" {
+			peeled, err := peelTag(u.c, ref.Value)
+			if err != nil {
+				return err
+			}
+			line += " peeled:" + peeled.String()
+		}
+		if err := u.w.Printf("%s\n", line); err != nil {
+			return err
+		}
+	}
+	return u.w.Flush()
+}
+
+// Handles the fetch command in protocol v2.
+func (u *uploadPack) fetchV2(args []string) error {
+	var haves []Sha1
+	done := false
+	for _, arg := range args {
+		switch arg {
+		case "done":
+			done = true
+			continue
+		case "thin-pack", "no-progress", "include-tag", "ofs-delta", "deepen-relative":
+			u.caps[arg] = struct{}{}
+		}
+		if strings.HasPrefix(arg, "have ") {
+			id, err := Sha1FromString(strings.TrimPrefix(arg, "have "))
+			if err != nil {
+				return u.errorf("protocol error: expected sha1, got '%s'", arg)
+			}
+			haves = append(haves, id)
+			continue
+		}
+		if _, err := u.parseFetchArg(arg); err != nil {
+			return err
+		}
+	}
+	if u.hasCap("deepen-relative") {
+		u.deepenRelative = true
+	}
+	for _, id := range haves {
+		u.gotHave(id)
+	}
+
+	if !done {
+		// We never declare that we're ready, so the client keeps
+		// negotiating until it sends done.
+		if err := u.w.Printf("acknowledgments\n"); err != nil {
+			return err
+		}
+		if len(u.common) == 0 {
+			if err := u.w.Printf("NAK\n"); err != nil {
+				return err
+			}
+		}
+		for _, id := range u.common {
+			if err := u.w.Printf("ACK %v\n", id); err != nil {
+				return err
+			}
+		}
+		return u.w.Flush()
+	}
+
+	shallow, unshallow, err := u.shallowInfo()
+	if err != nil {
+		return err
+	}
+	if u.deepening() || len(u.clientShallow) > 0 {
+		if err := u.w.Printf("shallow-info\n"); err != nil {
+			return err
+		}
+		for _, s := range shallow {
+			if err := u.w.Printf("shallow %v\n", s); err != nil {
+				return err
+			}
+		}
+		for _, s := range unshallow {
+			if err := u.w.Printf("unshallow %v\n", s); err != nil {
+				return err
+			}
+		}
+		if err := u.w.Delim(); err != nil {
+			return err
+		}
+	}
+	if err := u.w.Printf("packfile\n"); err != nil {
+		return err
+	}
+	// Protocol v2 always uses side-band-64k for the pack.
+	u.caps["side-band-64k"] = struct{}{}
+	return u.sendPack(shallow, unshallow)
+}
+
+// A commitWalk is the result of walking the commits to send.
+type commitWalk struct {
+	// The commits to send, in the order they were found.
+	commits []CommitID
+
+	// Commits that are being sent without their parents.
+	shallow map[CommitID]struct{}
+
+	// Commits whose parents are being sent.
+	descended map[CommitID]struct{}
+}
+
+// Walks the commits reachable from the wants, stopping at commits that
+// the client has and at the shallow boundary requested by the client.
+// have is the set of objects that the client has.
+func (u *uploadPack) walkCommits(have map[Sha1]struct{}) (commitWalk, error) {
+	walk := commitWalk{
+		shallow:   make(map[CommitID]struct{}),
+		descended: make(map[CommitID]struct{}),
+	}
+
+	// Commits reachable from deepen-not refs are excluded
+	notset := make(map[Sha1]struct{})
+	for _, name := range u.deepenNot {
+		cmts, err := RevParse(u.c, RevParseOptions{}, []string{name})
+		if err != nil {
+			return walk, u.errorf("git upload-pack: ambiguous deepen-not: %s", name)
+		}
+		for _, cmt := range cmts {
+			id, err := cmt.CommitID(u.c)
+			if err != nil {
+				return walk, err
+			}
+			ancestors, err := id.AncestorMap(u.c)
+			if err != nil {
+				return walk, err
+			}
+			for a := range ancestors {
+				notset[Sha1(a)] = struct{}{}
+			}
+		}
+	}
+	excluded := func(cmt CommitID) bool {
+		if _, ok := notset[Sha1(cmt)]; ok {
+			return true
+		}
+		if u.deepenSince != 0 {
+			date, err := cmt.GetCommitterDate(u.c)
+			if err == nil && date.Unix() < u.deepenSince {
+				return true
+			}
+		}
+		return false
+	}
+
+	limit := u.depth
+	if u.deepenRelative && limit > 0 {
+		// The depth is counted from the client's current shallow
+		// commits, which have depth 1.
+		limit++
+	}
+
+	// Depth 0 means that the commit isn't limited by depth.
+	type item struct {
+		cmt   CommitID
+		depth int
+	}
+	var queue []item
+	for _, w := range u.wants {
+		peeled, err := peelTag(u.c, w)
+		if err != nil {
+			return walk, err
+		}
+		if peeled.Type(u.c) != "commit" {
+			continue
+		}
+		depth := 0
+		if u.depth > 0 && !u.deepenRelative {
+			depth = 1
+		}
+		queue = append(queue, item{CommitID(peeled), depth})
+	}
+	visited := make(map[CommitID]struct{})
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[it.cmt]; ok {
+			continue
+		}
+		visited[it.cmt] = struct{}{}
+
+		_, clientshallow := u.clientShallow[Sha1(it.cmt)]
+		if it.depth == 0 {
+			if clientshallow && u.deepenRelative && limit > 0 {
+				it.depth = 1
+			} else if _, ok := have[Sha1(it.cmt)]; ok {
+				// The client has this commit and everything
+				// before it.
+				continue
+			}
+		}
+		if excluded(it.cmt) {
+			continue
+		}
+		walk.commits = append(walk.commits, it.cmt)
+
+		parents, err := it.cmt.Parents(u.c)
+		if err != nil {
+			return walk, err
+		}
+		if len(parents) == 0 {
+			continue
+		}
+		if it.depth > 0 && it.depth >= limit {
+			walk.shallow[it.cmt] = struct{}{}
+			continue
+		}
+		cut := false
+		for _, p := range parents {
+			if excluded(p) {
+				cut = true
+			}
+		}
+		if cut {
+			walk.shallow[it.cmt] = struct{}{}
+			continue
+		}
+		walk.descended[it.cmt] = struct{}{}
+		for _, p := range parents {
+			depth := 0
+			if it.depth > 0 {
+				depth = it.depth + 1
+			}
+			queue = append(queue, item{p, depth})
+		}
+	}
+	return walk, nil
+}
+
+// Marks everything reachable from the commits that we have in common with
+// the client as something that the client has. The walk stops at the
+// client's shallow commits, since the client doesn't have their parents.
+func (u *uploadPack) clientHas() (map[Sha1]struct{}, error) {
+	have := make(map[Sha1]struct{})
+	var stack []CommitID
+	for _, id := range u.common {
+		peeled, err := peelTag(u.c, id)
+		if err != nil {
+			return nil, err
+		}
+		have[id] = struct{}{}
+		if peeled.Type(u.c) == "commit" {
+			stack = append(stack, CommitID(peeled))
+		} else {
+			have[peeled] = struct{}{}
+		}
+	}
+	for s := range u.clientShallow {
+		stack = append(stack, CommitID(s))
+	}
+	for len(stack) > 0 {
+		cmt := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := have[Sha1(cmt)]; ok {
+			continue
+		}
+		if _, err := cmt.GetAllObjectsExcept(u.c, have); err != nil {
+			return nil, err
+		}
+		have[Sha1(cmt)] = struct{}{}
+		if _, ok := u.clientShallow[Sha1(cmt)]; ok {
+			continue
+		}
+		parents, err := cmt.Parents(u.c)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, parents...)
+	}
+	return have, nil
+}
+
+// Calculates the shallow and unshallow lines to send to the client.
+func (u *uploadPack) shallowInfo() (shallow, unshallow []Sha1, err error) {
+	if !u.deepening() {
+		return nil, nil, nil
+	}
+	walk, err := u.walkCommits(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, cmt := range walk.commits {
+		_, isshallow := walk.shallow[cmt]
+		_, clientshallow := u.clientShallow[Sha1(cmt)]
+		if isshallow && !clientshallow {
+			shallow = append(shallow, Sha1(cmt))
+		}
+		if _, ok := walk.descended[cmt]; ok && clientshallow {
+			unshallow = append(unshallow, Sha1(cmt))
+		}
+	}
+	return shallow, unshallow, nil
+}
+
+// Determines the objects to send to the client. If the client requested a
+// thin pack, the objects that it has which can be used as delta bases are
+// also returned.
+func (u *uploadPack) objectsToSend(unshallow []Sha1) ([]Sha1, map[Sha1]Sha1, error) {
+	have, err := u.clientHas()
+	if err != nil {
+		return nil, nil, err
+	}
+	// The client has the commits that are being unshallowed, but
+	// not their parents.
+	for _, s := range unshallow {
+		delete(have, s)
+	}
+
+	var objects []Sha1
+	sent := make(map[Sha1]struct{})
+	add := func(id Sha1) {
+		if _, ok := sent[id]; ok {
+			return
+		}
+		if _, ok := have[id]; ok {
+			return
+		}
+		sent[id] = struct{}{}
+		objects = append(objects, id)
+	}
+
+	walk, err := u.walkCommits(have)
+	if err != nil {
+		return nil, nil, err
+	}
+	unshallowed := make(map[Sha1]struct{})
+	for _, s := range unshallow {
+		unshallowed[s] = struct{}{}
+	}
+
+	// Tags and non-commit objects that were wanted directly.
+	var roots []Sha1
+	for _, w := range u.wants {
+		for w.Type(u.c) == "tag" {
+			add(w)
+			tag, err := u.c.GetTagObject(w)
+			if err != nil {
+				return nil, nil, err
+			}
+			if w, err = Sha1FromString(tag.GetHeader("object")); err != nil {
+				return nil, nil, err
+			}
+		}
+		switch w.Type(u.c) {
+		case "tree", "blob":
+			roots = append(roots, w)
+		}
+	}
+	for _, cmt := range walk.commits {
+		if _, ok := unshallowed[Sha1(cmt)]; ok {
+			// Only the parents are needed.
+			continue
+		}
+		add(Sha1(cmt))
+	}
+
+	// Trees from the edge commits that the client has are used as
+	// delta bases for a thin pack, matched by path.
+	var edgepaths map[IndexPath]Sha1
+	if u.hasCap("thin-pack") {
+		edgepaths = make(map[IndexPath]Sha1)
+		for _, cmt := range walk.commits {
+			if _, ok := walk.descended[cmt]; !ok {
+				continue
+			}
+			parents, err := cmt.Parents(u.c)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, p := range parents {
+				if _, ok := have[Sha1(p)]; !ok {
+					continue
+				}
+				tree, err := p.TreeID(u.c)
+				if err != nil {
+					return nil, nil, err
+				}
+				entries, err := tree.GetAllObjects(u.c, "", true, false)
+				if err != nil {
+					return nil, nil, err
+				}
+				for path, entry := range entries {
+					edgepaths[path] = entry.Sha1
+				}
+			}
+		}
+	}
+	thin := make(map[Sha1]Sha1)
+
+	var addTree func(tree Sha1, path IndexPath, depth int, explicit bool) error
+	addTree = func(tree Sha1, path IndexPath, depth int, explicit bool) error {
+		if _, ok := sent[tree]; ok {
+			return nil
+		}
+		if _, ok := have[tree]; ok {
+			return nil
+		}
+		if !explicit && u.filter != nil && u.filter.treeDepth >= 0 && depth >= u.filter.treeDepth {
+			return nil
+		}
+		add(tree)
+		obj, err := u.c.GetObject(tree)
+		if err != nil {
+			return err
+		}
+		content := obj.GetContent()
+		for i := 0; i < len(content); {
+			name, entry, size, err := parseRawTreeLine(i, content)
+			if err != nil {
+				return err
+			}
+			i += size
+			entrypath := name
+			if path != "" {
+				entrypath = path + "/" + name
+			}
+			if base, ok := edgepaths[entrypath]; ok && base != entry.Sha1 {
+				thin[entry.Sha1] = base
+			}
+			switch entry.FileMode {
+			case ModeCommit:
+				// Submodules aren't part of the repository
+			case ModeTree, modeGit9Tree:
+				if err := addTree(entry.Sha1, entrypath, depth+1, false); err != nil {
+					return err
+				}
+			default:
+				if u.filter != nil {
+					if u.filter.treeDepth >= 0 && depth+1 >= u.filter.treeDepth {
+						continue
+					}
+					if u.filter.blobLimit >= 0 {
+						_, size, err := u.c.GetObjectMetadata(entry.Sha1)
+						if err != nil {
+							return err
+						}
+						if int64(size) > u.filter.blobLimit {
+							continue
+						}
+					}
+				}
+				add(entry.Sha1)
+			}
+		}
+		return nil
+	}
+	for _, cmt := range walk.commits {
+		if _, ok := unshallowed[Sha1(cmt)]; ok {
+			continue
+		}
+		tree, err := cmt.TreeID(u.c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := addTree(Sha1(tree), "", 0, false); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, root := range roots {
+		if root.Type(u.c) == "blob" {
+			add(root)
+		} else if err := addTree(root, "", 0, true); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if u.hasCap("include-tag") {
+		for _, ref := range u.refs {
+			if !strings.HasPrefix(ref.Name, "refs/tags/") || ref.Value.Type(u.c) != "tag" {
+				continue
+			}
+			peeled, err := peelTag(u.c, ref.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, ok := sent[peeled]; !ok {
+				continue
+			}
+			// Include the tag and any tags that it points to.
+			for id := ref.Value; id.Type(u.c) == "tag"; {
+				add(id)
+				tag, err := u.c.GetTagObject(id)
+				if err != nil {
+					return nil, nil, err
+				}
+				if id, err = Sha1FromString(tag.GetHeader("object")); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+	return objects, thin, nil
+}
+
+// Sends the pack to the client, using a sideband if the client asked
+// for one.
+func (u *uploadPack) sendPack(shallow, unshallow []Sha1) error {
+	var out, progress io.Writer = u.out, nil
+	var errout io.Writer
+	switch {
+	case u.hasCap("side-band-64k"):
+		out = sidebandWriter{u.out, sidebandDataChannel, 65520}
+		progress = sidebandWriter{u.out, sidebandChannel, 65520}
+		errout = sidebandWriter{u.out, sidebandErrChannel, 65520}
+	case u.hasCap("side-band"):
+		out = sidebandWriter{u.out, sidebandDataChannel, 1000}
+		progress = sidebandWriter{u.out, sidebandChannel, 1000}
+		errout = sidebandWriter{u.out, sidebandErrChannel, 1000}
+	}
+	if u.hasCap("no-progress") {
+		progress = nil
+	}
+
+	objects, thin, err := u.objectsToSend(unshallow)
+	if err != nil {
+		if errout != nil {
+			fmt.Fprintf(errout, "%v\n", err)
+		}
+		return err
+	}
+	if progress != nil {
+		fmt.Fprintf(progress, "Enumerating objects: %d, done.\n", len(objects))
+	}
+	window := 10
+	if w, err := strconv.Atoi(u.c.GetConfig("pack.window")); err == nil && w >= 0 {
+		window = w
+	}
+	if _, err := PackObjects(u.c, PackObjectsOptions{
+		Window:          window,
+		DeltaBaseOffset: u.hasCap("ofs-delta"),
+		ThinBases:       thin,
+	}, out, objects); err != nil {
+		if errout != nil {
+			fmt.Fprintf(errout, "%v\n", err)
+		}
+		return err
+	}
+	if progress != nil {
+		fmt.Fprintf(progress, "Total %d\n", len(objects))
+	}
+	if out != u.out {
+		return u.w.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Reads pkt-lines from r until a line with the prefix, returning the
+// lines before it.
+func readPktLinesUntil(t *testing.T, r *packProtocolReader, prefix string) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := readPktLine(r)
+		if err == flushPkt || err == delimPkt {
+			continue
+		} else if err != nil {
+			t.Fatalf("Did not find %q in %v: %v", prefix, lines, err)
+		}
+		if strings.HasPrefix(line, prefix) {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+// Demultiplexes the pack data on side-band channel 1 from r, up to the
+// next flush.
+func readSidebandPack(t *testing.T, r *packProtocolReader) []byte {
+	t.Helper()
+	var pack bytes.Buffer
+	buf := make([]byte, 65520)
+	for {
+		n, err := r.Read(buf)
+		if err == flushPkt {
+			return pack.Bytes()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		switch buf[0] {
+		case sidebandDataChannel:
+			pack.Write(buf[1:n])
+		case sidebandErrChannel:
+			t.Fatalf("Error from upload-pack: %s", buf[1:n])
+		}
+	}
+}
+
+func TestUploadPack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gituploadpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcdir := filepath.Join(dir, "src")
+	src, err := Init(nil, InitOptions{Quiet: true}, srcdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(srcdir); err != nil {
+		t.Fatal(err)
+	}
+	var commits []CommitID
+	for _, content := range []string{"foo\n", "foo\nbar\n"} {
+		if err := ioutil.WriteFile("foo.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Add(src, AddOptions{}, []File{"foo.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		cmt, err := Commit(src, CommitOptions{}, "commit", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, cmt)
+	}
+	first, second := commits[0], commits[1]
+
+	c, err := EnterRepo(srcdir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GitDir.String() != filepath.Join(srcdir, ".git") {
+		t.Errorf("Unexpected git dir: got %v", c.GitDir)
+	}
+	if _, err := EnterRepo(srcdir, true); err == nil {
+		t.Errorf("Expected an error entering a work tree with strict")
+	}
+
+	// Protocol v2 ls-refs
+	var req, resp bytes.Buffer
+	w := pktLineWriter{&req}
+	w.Printf("command=ls-refs\n")
+	w.Delim()
+	w.Printf("symrefs\n")
+	w.Printf("ref-prefix refs/heads/\n")
+	w.Printf("ref-prefix HEAD\n")
+	w.Flush()
+	if err := UploadPack(c, UploadPackOptions{StatelessRPC: true, GitProtocol: "version=2"}, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		second.String() + " HEAD symref-target:refs/heads/master",
+		second.String() + " refs/heads/master",
+	}
+	r := newPktLineReader(&resp)
+	for _, line := range want {
+		if got, err := readPktLine(r); err != nil || got != line {
+			t.Errorf("Unexpected ls-refs output: got %q (%v) want %q", got, err, line)
+		}
+	}
+
+	// Protocol v2 fetch of everything into a new repository
+	req.Reset()
+	resp.Reset()
+	w.Printf("command=fetch\n")
+	w.Delim()
+	w.Printf("want %v\n", second)
+	w.Printf("ofs-delta\n")
+	w.Printf("no-progress\n")
+	w.Printf("done\n")
+	w.Flush()
+	if err := UploadPack(c, UploadPackOptions{StatelessRPC: true, GitProtocol: "version=2"}, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	r = newPktLineReader(&resp)
+	readPktLinesUntil(t, r, "packfile")
+	pack := readSidebandPack(t, r)
+
+	dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "dst.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IndexAndCopyPack(dst, IndexPackOptions{}, bytes.NewReader(pack)); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := second.GetAllObjectsExcept(src, make(map[Sha1]struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range append(objects, Sha1(first), Sha1(second)) {
+		if have, _, err := dst.HaveObject(obj); !have || err != nil {
+			t.Errorf("Object %v was not fetched: %v", obj, err)
+		}
+	}
+
+	// Protocol v0 shallow fetch of the latest commit
+	req.Reset()
+	resp.Reset()
+	w.Printf("want %v multi_ack_detailed side-band-64k thin-pack ofs-delta\n", second)
+	w.Printf("deepen 1\n")
+	w.Flush()
+	w.Printf("done\n")
+	if err := UploadPack(c, UploadPackOptions{StatelessRPC: true}, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	r = newPktLineReader(&resp)
+	if got, err := readPktLine(r); err != nil || got != "shallow "+second.String() {
+		t.Errorf("Unexpected shallow line: got %q (%v)", got, err)
+	}
+	if got, err := readPktLine(r); err != flushPkt {
+		t.Errorf("Expected flush after shallow lines, got %q (%v)", got, err)
+	}
+	if got, err := readPktLine(r); err != nil || got != "NAK" {
+		t.Errorf("Expected NAK, got %q (%v)", got, err)
+	}
+	pack = readSidebandPack(t, r)
+
+	shallow, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "shallow.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IndexAndCopyPack(shallow, IndexPackOptions{}, bytes.NewReader(pack)); err != nil {
+		t.Fatal(err)
+	}
+	if have, _, _ := shallow.HaveObject(Sha1(second)); !have {
+		t.Errorf("Shallow fetch is missing %v", second)
+	}
+	if have, _, _ := shallow.HaveObject(Sha1(first)); have {
+		t.Errorf("Shallow fetch included parent %v", first)
+	}
+}
+
+func TestUploadPackPackedRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gituploadpackpacked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	var commits []CommitID
+	for _, content := range []string{"foo\n", "foo\nbar\n"} {
+		if err := ioutil.WriteFile("foo.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		cmt, err := Commit(c, CommitOptions{}, "commit", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, cmt)
+	}
+	first, second := commits[0], commits[1]
+	if err := TagCommit(c, TagOptions{Annotated: true}, "v1", first, "v1\n"); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := RefSpec("refs/tags/v1").Sha1(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pack everything the way git pack-refs does, with a stale value
+	// for refs/heads/other which is overridden by the loose ref.
+	packed := "# pack-refs with: peeled fully-peeled sorted \n" +
+		second.String() + " refs/heads/master\n" +
+		first.String() + " refs/heads/other\n" +
+		tag.String() + " refs/tags/v1\n" +
+		"^" + first.String() + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, ".git", "packed-refs"), []byte(packed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"refs/heads/master", "refs/tags/v1"} {
+		if err := os.Remove(filepath.Join(dir, ".git", ref)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := UpdateRef(c, UpdateRefOptions{}, "refs/heads/other", second, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var req, resp bytes.Buffer
+	if err := UploadPack(c, UploadPackOptions{StatelessRPC: true, AdvertiseRefs: true}, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		second.String() + " HEAD",
+		second.String() + " refs/heads/master",
+		second.String() + " refs/heads/other",
+		tag.String() + " refs/tags/v1",
+		first.String() + " refs/tags/v1^{}",
+	}
+	r := newPktLineReader(&resp)
+	for i, line := range want {
+		got, err := readPktLine(r)
+		if i == 0 {
+			got = strings.SplitN(got, "\x00", 2)[0]
+		}
+		if err != nil || got != line {
+			t.Errorf("Unexpected ref advertisement: got %q (%v) want %q", got, err, line)
+		}
+	}
+	if got, err := readPktLine(r); err != flushPkt {
+		t.Errorf("Expected flush after refs, got %q (%v)", got, err)
+	}
+
+}
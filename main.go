@@ -17,7 +17,7 @@ var InvalidArgument error = errors.New("Invalid argument to function")
 
 func requiresGitDir(cmd string) bool {
 	switch cmd {
-	case "init", "clone", "ls-remote":
+	case "init", "clone", "ls-remote", "rev-parse", "upload-pack", "receive-pack", "http-backend", "daemon", "credential", "credential-store", "credential-cache", "credential-cache--daemon":
 		return false
 	default:
 		return true
@@ -102,7 +102,7 @@ func main() {
 	c, err := git.NewClient(*gitdir, *workdir)
 	// Pass any local configuration values to the client
 	for _, config := range configs {
-		parts := strings.Split(config, "=")
+		parts := strings.SplitN(config, "=", 2)
 		varname := parts[0]
 		value := "true"
 		if len(parts) > 1 {
@@ -203,6 +203,30 @@ func main() {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(4)
 		}
+	case "shortlog":
+		subcommandUsage = "[<revision range>] [[--] <path>...]"
+		if err := cmd.Shortlog(c, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(4)
+		}
+	case "bisect":
+		subcommandUsage = "[help|start|bad|good|new|old|terms|skip|next|reset|visualize|view|replay|log|run]"
+		if err := cmd.Bisect(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "blame":
+		subcommandUsage = "[<options>] [<rev-opts>] [<rev>] [--] <file>"
+		if err := cmd.Blame(c, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(128)
+		}
+	case "annotate":
+		subcommandUsage = "[<options>] [<rev-opts>] [<rev>] [--] <file>"
+		if err := cmd.Annotate(c, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(128)
+		}
 	case "symbolic-ref":
 		val, err := cmd.SymbolicRef(c, args)
 		if err != nil {
@@ -271,18 +295,43 @@ func main() {
 		}
 	case "rev-parse":
 		subcommandUsage = "<args>..."
-		commits, opts, err := cmd.RevParse(c, args)
-		if err != nil {
-			if (opts.Verify && !opts.Quiet) || !opts.Verify {
-				fmt.Fprintf(os.Stderr, "%v\n", err)
+		// Everything after "--" is a file, which is printed as is
+		// after the revisions.
+		var files []string
+		for i, arg := range args {
+			if arg == "--" && args[0] != "--parseopt" {
+				args, files = args[:i], args[i:]
+				break
 			}
-			os.Exit(4)
 		}
+		commits, opts, err := cmd.RevParse(c, args)
+		switch {
+		case err == git.UsageShown:
+			os.Exit(129)
+		case err != nil && opts.Verify && opts.Quiet:
+			os.Exit(1)
+		}
+		// Anything before an error is still printed.
 		for _, sha := range commits {
+			if sha.NotRevision {
+				fmt.Println(sha.Name)
+				continue
+			}
 			if sha.Excluded {
 				fmt.Print("^")
 			}
-			fmt.Println(sha.Id.String())
+			if sha.Name != "" {
+				fmt.Println(sha.Name)
+			} else {
+				fmt.Println(sha.Id.String())
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(128)
+		}
+		for _, file := range files {
+			fmt.Println(file)
 		}
 
 	case "rev-list":
@@ -422,12 +471,54 @@ func main() {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
+	case "update-server-info":
+		subcommandUsage = "[--force]"
+		if err := cmd.UpdateServerInfo(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "upload-pack":
+		subcommandUsage = "[--strict] [--timeout=<n>] [--stateless-rpc] [--advertise-refs] <directory>"
+		if err := cmd.UploadPack(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(128)
+		}
+	case "receive-pack":
+		subcommandUsage = "[--stateless-rpc] [--advertise-refs] <directory>"
+		if err := cmd.ReceivePack(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(128)
+		}
+	case "http-backend":
+		subcommandUsage = ""
+		if err := cmd.HTTPBackend(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "daemon":
+		subcommandUsage = "[--verbose] [--export-all] [--base-path=<path>] [--listen=<host>] [--port=<n>] [--enable=<service>] [<directory>...]"
+		if err := cmd.Daemon(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "bundle":
+		subcommandUsage = "(create [--version=<version>] <file> <git-rev-list-args> | verify [-q] <file> | list-heads <file> [<refname>...] | unbundle <file> [<refname>...])"
+		if err := cmd.Bundle(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	case "check-ignore":
 		subcommandUsage = "[<pathname>...]"
 		if err := cmd.CheckIgnore(c, args); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(128)
 		}
+	case "check-mailmap":
+		subcommandUsage = "[<options>] <contact>..."
+		if err := cmd.CheckMailmap(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(128)
+		}
 	case "submodule":
 		subcommandUsage = "update"
 		if err := cmd.Submodule(c, args); err != nil {
@@ -473,6 +564,29 @@ func main() {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
+	case "credential":
+		subcommandUsage = "fill|approve|reject"
+		if err := cmd.Credential(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(128)
+		}
+	case "credential-store":
+		subcommandUsage = "get|store|erase"
+		if err := cmd.CredentialStore(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "credential-cache":
+		subcommandUsage = "get|store|erase|exit"
+		if err := cmd.CredentialCache(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "credential-cache--daemon":
+		if err := cmd.CredentialCacheDaemon(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	case "help":
 		flag.CommandLine.SetOutput(os.Stdout)
 		flag.Usage()
@@ -490,6 +604,10 @@ func main() {
    write-tree
    update-ref
    log              
+   shortlog       Summarize log output by author
+   bisect         Use binary search to find the commit that introduced a bug
+   blame          Show what revision and author last modified each line of a file
+   annotate       Annotate file lines with commit information
    symbolic-ref
    clone          Clone a repository into a new directory   
    config
@@ -524,7 +642,17 @@ func main() {
    var              Show a Git logical variable
    submodule        Initialize, update or inspect submodules
    showref          List references in a local repository
+   check-mailmap    Show canonical names and email addresses of contacts
    archive
+   credential       Retrieve and store user credentials
+   credential-store Helper to store credentials on disk
+   credential-cache Helper to temporarily store passwords in memory
+   update-server-info Update auxiliary info file to help dumb servers
+   upload-pack      Send objects packed back to git-fetch-pack
+   receive-pack     Receive what is pushed into the repository
+   http-backend     Server side implementation of Git over HTTP
+   daemon           A really simple server for Git repositories
+   bundle           Move objects and refs by archive
 `)
 
 		os.Exit(0)